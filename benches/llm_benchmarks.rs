@@ -361,6 +361,46 @@ fn benchmark_concurrent_requests(c: &mut Criterion) {
     group.finish();
 }
 
+fn benchmark_rule_preparation(c: &mut Criterion) {
+    use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+    use splice_weaver_mcp::binary_manager::BinaryManager;
+    use std::sync::Arc;
+
+    let tools = AstGrepTools::new(Arc::new(BinaryManager::new().unwrap()));
+    let rule = r#"
+id: bench-rule
+language: javascript
+rule:
+  all:
+    - pattern: "console.log($$$ARGS)"
+    - inside:
+        pattern: "function $NAME($$$) { $$$ }"
+        stopBy: end
+    - not:
+        inside:
+          kind: catch_clause
+fix: "logger.info($$$ARGS)"
+"#;
+
+    let mut group = c.benchmark_group("rule_preparation");
+
+    // Per-call overhead without the cache: validate and write the rule every time
+    group.bench_function("uncached", |b| {
+        b.iter(|| {
+            tools.clear_rule_cache();
+            black_box(tools.prepare_rule(black_box(rule), true).unwrap())
+        });
+    });
+
+    // Per-call overhead when the same rule is reused across many targets
+    group.bench_function("cached", |b| {
+        tools.prepare_rule(rule, true).unwrap();
+        b.iter(|| black_box(tools.prepare_rule(black_box(rule), true).unwrap()));
+    });
+
+    group.finish();
+}
+
 criterion_group!(
     benches,
     benchmark_llm_response_time,
@@ -368,7 +408,8 @@ criterion_group!(
     benchmark_model_comparison,
     benchmark_temperature_effects,
     benchmark_prompt_complexity,
-    benchmark_concurrent_requests
+    benchmark_concurrent_requests,
+    benchmark_rule_preparation
 );
 
 criterion_main!(benches);
@@ -1,5 +1,32 @@
-use crate::binary_manager::BinaryManager;
+use crate::atomic_write;
+use crate::binary_manager::{BinaryManager, AST_GREP_VERSION};
+use crate::build_constraint::{self, BuildConstraints};
+use crate::dialect::{self, DialectScope};
+use crate::edit_guard;
+use crate::edit_plan::{EditPlan, FilePlan};
+use crate::edit_utils::{
+    self, BraceStyle, Branch, CommentStyle, DeclarationItem, NodeSpan, TextEdit,
+};
+use crate::embedded::{self, CodeBlock, EmbeddedRegion};
+use crate::file_lock::FileLocks;
+use crate::formatter::{self, FormatterRegistry};
+use crate::git_blame;
+use crate::grammar::GrammarRegistry;
+use crate::markup;
+use crate::match_explain;
+use crate::match_filter::{MatchFilter, NodeSelector};
+use crate::node_tree::{self, NodeTree};
+use crate::node_types;
+use crate::operation_context::OperationContext;
+use crate::operation_log::{OperationLog, ToolCall};
+use crate::ripgrep_json;
+use crate::rule_check;
+use crate::server_config::{ProtectionConfig, ServerConfig};
 use crate::simple_search::SimpleSearchEngine;
+use crate::structure;
+use crate::text_encoding::{self, ContentEncoding, FileEncoding, OffsetEncoding, UTF8_BOM};
+use crate::toml_doc::{self, Selector};
+use crate::unified_diff;
 use anyhow::{anyhow, Result};
 use rmcp::model::*;
 use rust_embed::RustEmbed;
@@ -7,17 +34,117 @@ use serde_json::Value;
 use serde_yaml;
 use std::collections::HashMap;
 use std::path::{Path, PathBuf};
+use std::process::Stdio;
+use std::sync::atomic::{AtomicBool, Ordering};
 use std::sync::{Arc, Mutex};
+use tokio::io::AsyncWriteExt;
 use tokio::process::Command as TokioCommand;
+use tracing::warn;
 
 #[derive(RustEmbed)]
 #[folder = "assets"]
 struct Assets;
 
+/// File extensions of each language the server recognizes, and whether it
+/// can parse it. Unsupported languages are listed so a file can be named
+/// as e.g. Ruby rather than unknown.
+const LANGUAGE_EXTENSIONS: &[(&str, bool, &[&str])] = &[
+    ("javascript", true, &["js", "mjs", "cjs", "jsx"]),
+    ("typescript", true, &["ts", "mts", "cts", "tsx"]),
+    ("rust", true, &["rs"]),
+    ("python", true, &["py", "pyi", "pyw"]),
+    ("java", true, &["java"]),
+    ("go", true, &["go"]),
+    ("c", true, &["c", "h"]),
+    (
+        "cpp",
+        true,
+        &["cpp", "cc", "cxx", "c++", "hpp", "hh", "hxx"],
+    ),
+    ("csharp", true, &["cs"]),
+    ("swift", true, &["swift"]),
+    ("html", true, &["html", "htm"]),
+    ("css", true, &["css"]),
+    ("ruby", false, &["rb"]),
+    ("php", false, &["php"]),
+    ("kotlin", false, &["kt", "kts"]),
+    ("scala", false, &["scala"]),
+    ("lua", false, &["lua"]),
+    ("bash", false, &["sh", "bash", "zsh"]),
+    ("scss", false, &["scss"]),
+    ("sql", false, &["sql"]),
+    ("json", false, &["json"]),
+    ("yaml", false, &["yaml", "yml"]),
+    ("toml", false, &["toml"]),
+    ("markdown", false, &["md", "markdown"]),
+];
+
+/// Upper bound on cached rules; the cache is reset when it fills up.
+const RULE_CACHE_CAPACITY: usize = 256;
+
 pub struct AstGrepTools {
     binary_manager: Arc<BinaryManager>,
     search_engine: Arc<Mutex<Option<SimpleSearchEngine>>>,
     roots: Arc<Mutex<Vec<Root>>>,
+    /// Each tool the server lists with its options, for get_capabilities
+    tool_options: Arc<Mutex<Vec<(String, Vec<String>)>>>,
+    rule_cache: Arc<Mutex<HashMap<String, Arc<PreparedRule>>>>,
+    config: Arc<Mutex<ServerConfig>>,
+    /// Custom grammars from the config and the sgconfig registering them
+    grammars: Arc<Mutex<GrammarRegistry>>,
+    /// Formatter commands by language, built in or from the config
+    formatters: Arc<Mutex<FormatterRegistry>>,
+    /// Each language's `node-types.json`, once read
+    node_types: Arc<Mutex<HashMap<String, Arc<Value>>>>,
+    operation_log: Arc<Mutex<OperationLog>>,
+    /// Held across each writing call's read-modify-write of a file
+    file_locks: Arc<FileLocks>,
+    /// Session edits are logged under when a call gives no `session_id`
+    session_id: String,
+}
+
+/// A rule config that has been written to a rule file, ready to hand to
+/// ast-grep. The file is removed when the last reference drops.
+pub struct PreparedRule {
+    file: tempfile::TempPath,
+    /// Whether the config has passed `validate_rule_yaml`; internal rules
+    /// are cached without it
+    validated: AtomicBool,
+}
+
+impl PreparedRule {
+    pub fn path(&self) -> &Path {
+        &self.file
+    }
+}
+
+/// One file of a patch or preview: its path, the path a patch names it
+/// by, its contents before (`None` for a new file) and after, and its diff.
+struct FileChange {
+    path: PathBuf,
+    patch_path: String,
+    old: Option<String>,
+    new: String,
+    diff: String,
+}
+
+impl FileChange {
+    /// How the file changes, as `write_patch` and `preview_edits` list it.
+    fn summary(&self) -> Value {
+        let count = |marker: char| {
+            self.diff
+                .lines()
+                .filter(|line| line.starts_with(marker))
+                .count()
+                - 1
+        };
+        serde_json::json!({
+            "path": self.patch_path,
+            "status": if self.old.is_some() { "modified" } else { "added" },
+            "added": count('+'),
+            "removed": count('-'),
+        })
+    }
 }
 
 #[derive(serde::Deserialize)]
@@ -27,6 +154,42 @@ struct Position {
     column: u32,
 }
 
+/// The node kinds `convert_switch` maps between if chains and switches.
+struct SwitchSyntax {
+    if_kind: &'static str,
+    switch_kind: &'static str,
+    case_kind: &'static str,
+    default_kind: &'static str,
+    break_kind: &'static str,
+    fallthrough_kind: &'static str,
+    /// Statements a `break` inside a branch would leave instead of the switch
+    breakable_kinds: &'static [&'static str],
+}
+
+/// How `execute_rule` applies a rule's fixes.
+#[derive(Debug, Clone, Copy)]
+struct FixOptions {
+    dry_run: bool,
+    match_brace_style: bool,
+    force: bool,
+    keep_original_as_comment: bool,
+    /// Run each edited file through its language's registered formatter
+    format: bool,
+    /// Run the formatter over just the statements around each fix
+    format_edited_only: bool,
+    /// Fit each fix to the line breaks at the edges of the text it
+    /// replaces; see `edit_utils::fit_blank_lines`
+    preserve_blank_lines: bool,
+}
+
+impl FixOptions {
+    /// Whether the fixes must be applied by us rather than previewed by
+    /// ast-grep.
+    fn needs_own_fixes(&self) -> bool {
+        !self.dry_run || self.match_brace_style || self.keep_original_as_comment || self.format
+    }
+}
+
 fn get_embedded_catalog() -> Result<String> {
     match Assets::get("catalog.json") {
         Some(catalog_file) => std::str::from_utf8(&catalog_file.data)
@@ -36,19 +199,162 @@ fn get_embedded_catalog() -> Result<String> {
     }
 }
 
+/// The `_test.go` file next to the Go file at `path`.
+fn go_test_path(path: &Path) -> PathBuf {
+    let file_name = path
+        .file_name()
+        .and_then(|file_name| file_name.to_str())
+        .unwrap_or_default();
+    let stem = file_name.strip_suffix(".go").unwrap_or(file_name);
+    path.with_file_name(format!("{stem}_test.go"))
+}
+
+/// Id of a server instance's default session, unique across restarts.
+fn new_session_id() -> String {
+    let started = std::time::SystemTime::now()
+        .duration_since(std::time::UNIX_EPOCH)
+        .map(|elapsed| elapsed.as_millis())
+        .unwrap_or(0);
+    format!("{}-{}", std::process::id(), started)
+}
+
 impl AstGrepTools {
     pub fn new(binary_manager: Arc<BinaryManager>) -> Self {
         Self {
             binary_manager,
             search_engine: Arc::new(Mutex::new(None)),
             roots: Arc::new(Mutex::new(Vec::new())),
+            tool_options: Arc::new(Mutex::new(Vec::new())),
+            rule_cache: Arc::new(Mutex::new(HashMap::new())),
+            config: Arc::new(Mutex::new(ServerConfig::default())),
+            grammars: Arc::new(Mutex::new(GrammarRegistry::default())),
+            formatters: Arc::new(Mutex::new(FormatterRegistry::default())),
+            node_types: Arc::new(Mutex::new(HashMap::new())),
+            operation_log: Arc::new(Mutex::new(OperationLog::default())),
+            file_locks: Arc::new(FileLocks::new()),
+            session_id: new_session_id(),
+        }
+    }
+
+    pub fn set_config(&self, config: ServerConfig) {
+        if let Some(path) = &config.operation_log.file {
+            match OperationLog::open(path) {
+                Ok(log) => *self.operation_log.lock().unwrap() = log,
+                Err(e) => warn!("Keeping the operation log in memory only: {}", e),
+            }
+        }
+        *self.grammars.lock().unwrap() = GrammarRegistry::load(&config.grammars);
+        *self.formatters.lock().unwrap() = FormatterRegistry::load(&config.formatters);
+        *self.config.lock().unwrap() = config;
+    }
+
+    /// An ast-grep command, given the sgconfig registering any custom
+    /// grammars, or the call's dialect's, which registers them too.
+    fn ast_grep_command(&self, binary_path: &Path) -> TokioCommand {
+        let mut command = TokioCommand::new(binary_path);
+        if let Some(scope) = DialectScope::current() {
+            command.arg("--config").arg(scope.sgconfig_path());
+        } else if let Some(sgconfig) = self.grammars.lock().unwrap().sgconfig_path() {
+            command.arg("--config").arg(sgconfig);
+        }
+        command
+    }
+
+    /// Refuse `edits` to `path` if it is generated (unless `force`) or if
+    /// they cross a protected region.
+    fn check_edits(&self, path: &str, source: &str, edits: &[TextEdit], force: bool) -> Result<()> {
+        let config = self.config.lock().unwrap();
+        if !edits.is_empty() {
+            let language = self.file_language(path, source);
+            edit_guard::check_language(path, language.as_deref(), &config.protection)?;
+            edit_guard::check_build_tags(path, source, &config.protection, force)?;
+        }
+        edit_guard::check_edits(path, source, edits, &config.protection, force)
+    }
+
+    /// Language of the file at `path`, from its extension or else its `#!` line.
+    fn file_language(&self, path: &str, source: &str) -> Option<String> {
+        Path::new(path)
+            .extension()
+            .and_then(|extension| extension.to_str())
+            .and_then(|extension| self.extension_language(extension))
+            .or_else(|| {
+                source
+                    .lines()
+                    .next()
+                    .and_then(|line| self.get_shebang_language(line))
+                    .map(|(language, supported)| (language.to_string(), supported))
+            })
+            .map(|(language, _)| language)
+    }
+
+    /// Grammar field for a canonical concept (`NAME`, `BODY`, `TYPE_NAME`)
+    /// in `language`, as configured in `field_aliases`.
+    fn field_name(&self, language: &str, concept: &str) -> Result<String> {
+        self.config
+            .lock()
+            .unwrap()
+            .field_name(language, concept)
+            .ok_or_else(|| anyhow!("No {} field is configured for {}", concept, language))
+    }
+
+    /// Validate and write `rule_config` once, reusing the result for
+    /// identical configs so repeated runs skip YAML parsing and file writes.
+    /// In a dialect, the rule written is retargeted to its grammar.
+    pub fn prepare_rule(&self, rule_config: &str, validate: bool) -> Result<Arc<PreparedRule>> {
+        let retargeted = DialectScope::current().map(|scope| scope.dialect().retarget(rule_config));
+        let written = retargeted.as_deref().unwrap_or(rule_config);
+        let mut cache = self.rule_cache.lock().unwrap();
+        if let Some(rule) = cache.get(written) {
+            if validate && !rule.validated.load(Ordering::Relaxed) {
+                self.validate_rule_yaml(rule_config)?;
+                rule.validated.store(true, Ordering::Relaxed);
+            }
+            return Ok(rule.clone());
+        }
+
+        if validate {
+            self.validate_rule_yaml(rule_config)?;
         }
+
+        let mut file = tempfile::Builder::new()
+            .prefix("splice-weaver-rule-")
+            .suffix(".yml")
+            .tempfile()?;
+        std::io::Write::write_all(&mut file, written.as_bytes())?;
+        let rule = Arc::new(PreparedRule {
+            file: file.into_temp_path(),
+            validated: AtomicBool::new(validate),
+        });
+
+        if cache.len() >= RULE_CACHE_CAPACITY {
+            cache.clear();
+        }
+        cache.insert(written.to_string(), rule.clone());
+        Ok(rule)
+    }
+
+    /// Drop all cached rules, returning how many were removed.
+    pub fn clear_rule_cache(&self) -> usize {
+        let mut cache = self.rule_cache.lock().unwrap();
+        let count = cache.len();
+        cache.clear();
+        count
+    }
+
+    pub fn rule_cache_len(&self) -> usize {
+        self.rule_cache.lock().unwrap().len()
     }
 
     pub fn set_roots(&self, roots: Vec<Root>) {
         *self.roots.lock().unwrap() = roots;
     }
 
+    /// The tools `get_capabilities` reports, each with its options.
+    pub fn set_tool_options(&self, tools: Vec<(String, Vec<String>)>) {
+        *self.tool_options.lock().unwrap() = tools;
+    }
+
     pub fn resolve_path(&self, target: &str) -> Result<PathBuf> {
         let target_path = Path::new(target);
 
@@ -98,6 +404,25 @@ impl AstGrepTools {
         ))
     }
 
+    /// Like `resolve_path`, for a file that may not exist yet: its parent
+    /// directory is resolved against the roots instead.
+    pub fn resolve_output_path(&self, target: &str) -> Result<PathBuf> {
+        if let Ok(resolved) = self.resolve_path(target) {
+            return Ok(resolved);
+        }
+        let target_path = Path::new(target);
+        let file_name = target_path
+            .file_name()
+            .ok_or_else(|| anyhow!("'{}' is not a file path", target))?;
+        let parent = match target_path.parent() {
+            Some(parent) if !parent.as_os_str().is_empty() => parent,
+            _ => Path::new("."),
+        };
+        Ok(self
+            .resolve_path(&parent.to_string_lossy())?
+            .join(file_name))
+    }
+
     fn with_search_engine<F, R>(&self, f: F) -> Result<R>
     where
         F: FnOnce(&SimpleSearchEngine) -> Result<R>,
@@ -115,12 +440,254 @@ impl AstGrepTools {
     }
 
     pub async fn call_tool(&self, tool_name: &str, arguments: Value) -> Result<String> {
+        self.call_tool_with_context(tool_name, arguments, OperationContext::new())
+            .await
+    }
+
+    /// Like `call_tool`, but long operations stop when `ctx` is cancelled or
+    /// the call's `timeoutMs` elapses.
+    pub async fn call_tool_with_context(
+        &self,
+        tool_name: &str,
+        arguments: Value,
+        ctx: OperationContext,
+    ) -> Result<String> {
+        let ctx = ctx.with_timeout_from_args(&arguments);
+        let call = ToolCall::new(
+            tool_name,
+            arguments["session_id"].as_str().unwrap_or(&self.session_id),
+        );
+        self.check_editable_paths(&arguments)?;
+        let _locks = self
+            .file_locks
+            .lock_all(self.locked_target(tool_name, &arguments))
+            .await;
+        let return_edited_node = arguments["returnEditedNode"].as_bool() == Some(true);
+        let encodings = serde_json::json!({
+            "fileEncoding": arguments["fileEncoding"],
+            "offsetEncoding": arguments["offsetEncoding"],
+        });
+        let dialect = self.dialect_scope(&arguments)?;
+        let dispatched = call
+            .clone()
+            .scope(self.dispatch(tool_name, arguments, &ctx));
+        let output = match &dialect {
+            Some(scope) => {
+                let output = scope.clone().scope(dispatched).await?;
+                scope.dialect().annotate(output)
+            }
+            None => dispatched.await?,
+        };
+        match return_edited_node {
+            true => self.add_edited_nodes(output, &call, &encodings).await,
+            false => Ok(output),
+        }
+    }
+
+    /// Refuse a call on a file whose language the `editable_languages`
+    /// allowlist leaves out, whether it parses or edits it: its `target` or
+    /// `path`, the `path` of any of its `files`, or its inline `code`. The
+    /// files found under a directory target are held to it one by one by
+    /// `retain_editable_files`.
+    fn check_editable_paths(&self, args: &Value) -> Result<()> {
+        let protection = self.config.lock().unwrap().protection.clone();
+        if protection.editable_languages.is_empty() {
+            return Ok(());
+        }
+        if let (Some(_), Some(language)) = (args["code"].as_str(), args["language"].as_str()) {
+            edit_guard::check_language("code", Some(language), &protection)?;
+        }
+        let files = args["files"].as_array().into_iter().flatten();
+        let named = [&args["target"], &args["path"]]
+            .into_iter()
+            .chain(files.map(|file| &file["path"]))
+            .filter_map(Value::as_str);
+        for name in named {
+            let Ok(path) = self.resolve_path(name) else {
+                continue;
+            };
+            if path.is_dir() {
+                continue;
+            }
+            self.check_editable_file(name, &path, &protection)?;
+        }
+        Ok(())
+    }
+
+    /// Refuse the file at `path`, called `name`, if the allowlist leaves
+    /// its language out.
+    fn check_editable_file(
+        &self,
+        name: &str,
+        path: &Path,
+        protection: &ProtectionConfig,
+    ) -> Result<()> {
+        // Without a known extension, the language comes from its `#!` line
+        let source = match self.file_language(name, "") {
+            Some(_) => String::new(),
+            None => std::fs::read_to_string(path).unwrap_or_default(),
+        };
+        let language = self.file_language(name, &source);
+        edit_guard::check_language(name, language.as_deref(), protection)
+    }
+
+    /// Drop the matches in files the `editable_languages` allowlist leaves
+    /// out, for a walk of a directory the allowlist was not checked against
+    /// as a whole.
+    fn retain_editable_files(&self, matches: &mut Vec<Value>) {
+        let protection = self.config.lock().unwrap().protection.clone();
+        if protection.editable_languages.is_empty() {
+            return;
+        }
+        let mut editable: HashMap<String, bool> = HashMap::new();
+        matches.retain(|m| {
+            let file = m["file"].as_str().unwrap_or_default();
+            *editable.entry(file.to_string()).or_insert_with(|| {
+                self.check_editable_file(file, Path::new(file), &protection)
+                    .is_ok()
+            })
+        });
+    }
+
+    /// The dialect a call's `dialect` names, checked against the language
+    /// it was given or its target's. For the rest of the call, files of the
+    /// dialect's languages are parsed with its grammar.
+    fn dialect_scope(&self, args: &Value) -> Result<Option<Arc<DialectScope>>> {
+        let Some(name) = args["dialect"].as_str() else {
+            return Ok(None);
+        };
+        let dialect = dialect::find(name)?;
+        let language = match args["language"].as_str() {
+            Some(language) => Some(language.to_ascii_lowercase()),
+            None => args["target"]
+                .as_str()
+                .or(args["path"].as_str())
+                .and_then(|path| self.file_language(path, "")),
+        };
+        if let Some(language) = language.filter(|language| !dialect.applies_to(language)) {
+            return Err(anyhow!(
+                "The {} dialect only applies to {} files, not {}",
+                dialect.name,
+                dialect.languages.join(", "),
+                language
+            ));
+        }
+        let scope = DialectScope::new(dialect, self.grammars.lock().unwrap().sgconfig_path())?;
+        Ok(Some(Arc::new(scope)))
+    }
+
+    /// The files a writing call edits, locked from before they are read
+    /// until the call returns: the target, and the test file next to it
+    /// for generate_go_table_test. Tools that edit several files lock them
+    /// once they know which, and calls that only preview lock nothing.
+    fn locked_target(&self, tool_name: &str, args: &Value) -> Vec<PathBuf> {
+        if args["dry_run"].as_bool() != Some(false)
+            || matches!(tool_name, "apply_edits_from_file" | "apply_unified_diff")
+        {
+            return Vec::new();
+        }
+        let Some(target) = args["target"]
+            .as_str()
+            .and_then(|target| self.resolve_path(target).ok())
+            .filter(|path| path.is_file())
+        else {
+            return Vec::new();
+        };
+        let test_file = (tool_name == "generate_go_table_test").then(|| go_test_path(&target));
+        std::iter::once(target).chain(test_file).collect()
+    }
+
+    async fn dispatch(
+        &self,
+        tool_name: &str,
+        arguments: Value,
+        ctx: &OperationContext,
+    ) -> Result<String> {
         match tool_name {
             "find_scope" => self.find_scope(arguments).await,
-            "execute_rule" => self.execute_rule(arguments).await,
+            "execute_rule" => self.execute_rule(arguments, ctx).await,
+            "validate_rule" => self.validate_rule(arguments).await,
+            "structural_replace" => self.structural_replace(arguments).await,
             "search_examples" => self.search_examples(arguments).await,
             "similarity_search" => self.similarity_search_tool(arguments).await,
             "suggest_examples" => self.suggest_examples(arguments).await,
+            "insert_import" => self.insert_import(arguments).await,
+            "replace_node" => self.replace_node(arguments).await,
+            "check_go_imports" => self.check_go_imports(arguments).await,
+            "insert_member" => self.insert_member(arguments).await,
+            "insert_at" => self.insert_at(arguments).await,
+            "find_html_elements" => self.find_html_elements(arguments).await,
+            "edit_html_class" => self.edit_html_class(arguments).await,
+            "find_css_rules" => self.find_css_rules(arguments).await,
+            "edit_css_declaration" => self.edit_css_declaration(arguments).await,
+            "find_toml_keys" => self.find_toml_keys(arguments).await,
+            "edit_toml_value" => self.edit_toml_value(arguments).await,
+            "get_enclosing_function" => self.get_enclosing_function(arguments).await,
+            "text_between" => self.text_between(arguments).await,
+            "dump_tree" => self.dump_tree(arguments).await,
+            "get_node_text" => self.get_node_text(arguments).await,
+            "file_outline" => self.file_outline(arguments).await,
+            "file_skeleton" => self.file_skeleton(arguments).await,
+            "after_comment" => self.after_comment(arguments).await,
+            "find_comments" => self.find_comments(arguments).await,
+            "find_similar" => self.find_similar(arguments).await,
+            "inline_variable" => self.inline_variable(arguments).await,
+            "rewrite_returns" => self.rewrite_returns(arguments).await,
+            "add_error_checks" => self.add_error_checks(arguments).await,
+            "free_identifiers" => self.free_identifiers(arguments).await,
+            "find_references" => self.find_references(arguments).await,
+            "toggle_go_export" => self.toggle_go_export(arguments).await,
+            "convert_go_returns" => self.convert_go_returns(arguments).await,
+            "reflow_go_signature" => self.reflow_go_signature(arguments).await,
+            "convert_go_receiver" => self.convert_go_receiver(arguments).await,
+            "go_receiver_names" => self.go_receiver_names(arguments).await,
+            "extract_go_interface" => self.extract_go_interface(arguments).await,
+            "hoist_go_closure" => self.hoist_go_closure(arguments).await,
+            "inline_go_function" => self.inline_go_function(arguments).await,
+            "extract_go_constant" => self.extract_go_constant(arguments).await,
+            "find_magic_numbers" => self.find_magic_numbers(arguments).await,
+            "find_long_signatures" => self.find_long_signatures(arguments).await,
+            "generate_go_table_test" => self.generate_go_table_test(arguments).await,
+            "convert_go_concatenation" => self.convert_go_concatenation(arguments).await,
+            "split_go_assignment" => self.split_go_assignment(arguments).await,
+            "convert_go_errors" => self.convert_go_errors(arguments).await,
+            "find_go_shadowing" => self.find_go_shadowing(arguments).await,
+            "group_declarations" => self.group_declarations(arguments).await,
+            "convert_switch" => self.convert_switch(arguments).await,
+            "go_build_constraints" => self.go_build_constraints(arguments).await,
+            "set_go_build_constraint" => self.set_go_build_constraint(arguments).await,
+            "split_function" => self.split_function(arguments).await,
+            "merge_functions" => self.merge_functions(arguments).await,
+            "edit_list_element" => self.edit_list_element(arguments).await,
+            "reorder_elements" => self.reorder_elements(arguments).await,
+            "replace_comment" => self.replace_comment(arguments).await,
+            "detect_language" => self.detect_language(arguments).await,
+            "parse_embedded" => self.parse_embedded(arguments).await,
+            "markdown_code_blocks" => self.markdown_code_blocks(arguments).await,
+            "extract_strings" => self.extract_strings(arguments).await,
+            "replace_string_literal" => self.replace_string_literal(arguments).await,
+            "normalize_quotes" => self.normalize_quotes(arguments).await,
+            "wrap_statements" => self.wrap_statements(arguments).await,
+            "find_unreachable_code" => self.find_unreachable_code(arguments).await,
+            "compute_edit_plan" => self.compute_edit_plan(arguments).await,
+            "apply_edits_from_file" => self.apply_edits_from_file(arguments, ctx).await,
+            "apply_unified_diff" => self.apply_unified_diff(arguments).await,
+            "apply_go_edits" => self.apply_go_edits(arguments).await,
+            "write_patch" => self.write_patch(arguments).await,
+            "preview_edits" => self.preview_edits(arguments).await,
+            "clear_rule_cache" => Ok(format!(
+                "Cleared {} cached rule(s)",
+                self.clear_rule_cache()
+            )),
+            "get_session_log" => self.get_session_log(arguments),
+            "get_capabilities" => {
+                let tools = self.tool_options.lock().unwrap().clone();
+                self.capabilities(&tools).await
+            }
+            "resolve_node_id" => self.resolve_node_id(arguments).await,
+            "node_type_histogram" => self.node_type_histogram(arguments).await,
+            "fields_for_type" => self.fields_for_type(arguments).await,
+            "resolve_match_index" => self.resolve_match_index(arguments).await,
             _ => Err(anyhow!("Unknown tool: {}", tool_name)),
         }
     }
@@ -136,12 +703,9 @@ impl AstGrepTools {
             .as_str()
             .ok_or(anyhow!("Missing scope_rule"))?;
 
-        // Create a temporary rule file with position-aware matching
-        let temp_rule_file = std::env::temp_dir().join("find_scope_rule.yml");
-
         // For now, we'll use the provided rule directly
         // In a more sophisticated implementation, we'd inject position constraints
-        tokio::fs::write(&temp_rule_file, scope_rule).await?;
+        let prepared_rule = self.prepare_rule(scope_rule, false)?;
 
         // Write code to temporary file for processing
         let temp_code_file =
@@ -149,18 +713,22 @@ impl AstGrepTools {
         tokio::fs::write(&temp_code_file, code).await?;
 
         let binary_path = self.binary_manager.ensure_binary().await?;
-        let output = TokioCommand::new(&binary_path)
+        let command = self
+            .ast_grep_command(&binary_path)
             .arg("scan")
             .arg("--rule")
-            .arg(&temp_rule_file)
+            .arg(prepared_rule.path())
             .arg(&temp_code_file)
             .arg("--json")
-            .output()
-            .await?;
+            .kill_on_drop(true)
+            .output();
+        let output = self
+            .within_parse_timeout(scope_rule, &temp_code_file, command)
+            .await;
 
         // Cleanup
-        tokio::fs::remove_file(temp_rule_file).await.ok();
         tokio::fs::remove_file(temp_code_file).await.ok();
+        let output = output?;
 
         if !output.status.success() {
             let stderr = String::from_utf8_lossy(&output.stderr);
@@ -171,155 +739,13691 @@ impl AstGrepTools {
         Ok(stdout.to_string())
     }
 
-    fn validate_rule_yaml(&self, rule_config: &str) -> Result<()> {
-        // First, validate YAML syntax
-        let parsed: serde_yaml::Value = serde_yaml::from_str(rule_config)
-            .map_err(|e| anyhow!("Invalid YAML syntax: {}\n\nPlease check your YAML formatting. Common issues:\n- Incorrect indentation (use spaces, not tabs)\n- Missing colons after keys\n- Unmatched quotes or brackets\n\nExample of correct format:\n{}", e, self.get_example_rule()))?;
+    /// Check a rule config, and optionally a `filter`, without running
+    /// them. A valid rule is returned normalized, with the node kinds,
+    /// fields, metavariables and utils it refers to; an invalid one with
+    /// what is wrong and where.
+    async fn validate_rule(&self, args: Value) -> Result<String> {
+        let rule_config = args["rule_config"]
+            .as_str()
+            .ok_or(anyhow!("Missing rule_config"))?;
+        let invalid =
+            |stage: &str, message: String, path: Option<&str>, at: Option<(usize, usize)>| {
+                serde_json::to_string_pretty(&serde_json::json!({
+                    "valid": false,
+                    "error": {
+                        "stage": stage,
+                        "message": message,
+                        "path": path,
+                        "line": at.map(|(line, _)| line),
+                        "column": at.map(|(_, column)| column),
+                    }
+                }))
+            };
+
+        let parsed: serde_yaml::Value = match serde_yaml::from_str(rule_config) {
+            Ok(parsed) => parsed,
+            Err(e) => {
+                let at = e
+                    .location()
+                    .map(|location| (location.line(), location.column()));
+                return Ok(invalid("yaml", e.to_string(), None, at)?);
+            }
+        };
+        let summary = match rule_check::check_config(&parsed) {
+            Ok(summary) => summary,
+            Err(problem) => {
+                let at = rule_check::locate(rule_config, &problem.path);
+                let path = (!problem.path.is_empty()).then_some(problem.path.as_str());
+                return Ok(invalid("rule", problem.message, path, at)?);
+            }
+        };
+        let language = parsed
+            .get("language")
+            .and_then(|v| v.as_str())
+            .unwrap_or_default();
+        if let Err(e) = self.validate_language(language) {
+            let at = rule_check::locate(rule_config, "language");
+            return Ok(invalid("language", e.to_string(), Some("language"), at)?);
+        }
+        let filter = match args["filter"]
+            .as_str()
+            .map(MatchFilter::parse_at)
+            .transpose()
+        {
+            Ok(filter) => filter,
+            Err((offset, e)) => {
+                let column = args["filter"].as_str().unwrap_or_default()[..offset]
+                    .chars()
+                    .count()
+                    + 1;
+                return Ok(invalid("filter", e.to_string(), None, Some((1, column)))?);
+            }
+        };
+
+        let warnings: Vec<Value> = summary
+            .warnings
+            .iter()
+            .map(|warning| {
+                let at = rule_check::locate(rule_config, &warning.path);
+                serde_json::json!({
+                    "path": warning.path,
+                    "message": warning.message,
+                    "line": at.map(|(line, _)| line),
+                })
+            })
+            .collect();
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "valid": true,
+            "id": parsed.get("id").and_then(|v| v.as_str()),
+            "language": language,
+            "normalized": serde_yaml::to_string(&parsed)?,
+            "kinds": summary.kinds,
+            "fields": summary.fields,
+            "metavariables": summary.metavariables,
+            "utils": summary.utils,
+            "filter": filter.map(|filter| filter.to_string()),
+            "warnings": warnings,
+        }))?)
+    }
+
+    fn validate_rule_yaml(&self, rule_config: &str) -> Result<()> {
+        // First, validate YAML syntax
+        let parsed: serde_yaml::Value = serde_yaml::from_str(rule_config)
+            .map_err(|e| anyhow!("Invalid YAML syntax: {}\n\nPlease check your YAML formatting. Common issues:\n- Incorrect indentation (use spaces, not tabs)\n- Missing colons after keys\n- Unmatched quotes or brackets\n\nExample of correct format:\n{}", e, self.get_example_rule()))?;
+
+        // Ensure we have a mapping (object), not a scalar or array
+        let rule_map = parsed.as_mapping()
+            .ok_or_else(|| anyhow!("Rule must be a YAML object, not a scalar value or array.\n\nExample of correct format:\n{}", self.get_example_rule()))?;
+
+        // Check for required fields
+        let mut missing_fields = Vec::new();
+
+        if !rule_map.contains_key("id") {
+            missing_fields.push("id");
+        }
+        if !rule_map.contains_key("language") {
+            missing_fields.push("language");
+        }
+        if !rule_map.contains_key("rule") {
+            missing_fields.push("rule");
+        }
+
+        if !missing_fields.is_empty() {
+            return Err(anyhow!(
+                "Missing required fields: {}\n\nRequired fields for ast-grep rules:\n- id: unique identifier for the rule\n- language: programming language (e.g., 'javascript', 'rust', 'python')\n- rule: the search/replace pattern definition\n\nExample of correct format:\n{}",
+                missing_fields.join(", "),
+                self.get_example_rule()
+            ));
+        }
+
+        // Validate language field
+        if let Some(language) = rule_map.get("language").and_then(|v| v.as_str()) {
+            self.validate_language(language)?;
+        } else {
+            return Err(anyhow!("Language field must be a string.\n\nSupported languages: javascript, typescript, rust, python, java, go, cpp, c, csharp, swift, html, css\n\nExample of correct format:\n{}", self.get_example_rule()));
+        }
+
+        // Validate rule structure
+        if let Some(rule) = rule_map.get("rule") {
+            if rule.as_mapping().is_none() {
+                return Err(anyhow!("Rule field must be an object containing pattern or other rule definitions.\n\nExample of correct format:\n{}", self.get_example_rule()));
+            }
+        }
+
+        Ok(())
+    }
+
+    fn validate_language(&self, language: &str) -> Result<()> {
+        if let Some(loaded) = self.grammars.lock().unwrap().check(language) {
+            return loaded;
+        }
+        match language {
+            "javascript" | "typescript" | "rust" | "python" | "java" | "go" | "cpp" | "c++" | "c"
+            | "csharp" | "cs" | "swift" | "html" | "css" => Ok(()),
+            _ => Err(anyhow!(
+                "Unsupported language: '{}'\n\nSupported languages: javascript, typescript, rust, python, java, go, cpp, c, csharp, swift, html, css\n\nExample of correct format:\n{}",
+                language,
+                self.get_example_rule()
+            ))
+        }
+    }
+
+    fn get_example_rule(&self) -> &'static str {
+        r#"id: example-rule
+language: javascript
+rule:
+  pattern: function $NAME($$$PARAMS) { $$$BODY }
+  # Optional: add constraints, transformations, etc.
+  # constraints:
+  #   - pattern: $NAME
+  #     regex: "^[a-z]"
+  # transform:
+  #   $NAME: "new_$NAME""#
+    }
+
+    async fn execute_rule(&self, args: Value, ctx: &OperationContext) -> Result<String> {
+        let rule_config = args["rule_config"]
+            .as_str()
+            .ok_or(anyhow!("Missing rule_config"))?;
+        let target = args["target"].as_str().ok_or(anyhow!("Missing target"))?;
+        let operation = args["operation"].as_str().unwrap_or("search");
+        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
+        let match_brace_style = args["matchBraceStyle"].as_bool().unwrap_or(false);
+        let force = args["force"].as_bool().unwrap_or(false);
+        let keep_original_as_comment = args["keepOriginalAsComment"].as_bool().unwrap_or(false);
+        let format = args["format"].as_bool().unwrap_or(false);
+        let format_edited_only = args["formatEditedOnly"].as_bool().unwrap_or(false);
+        let preserve_blank_lines = args["preserveBlankLines"].as_bool().unwrap_or(true);
+        let node_ids = args["nodeIds"].as_bool().unwrap_or(false);
+        let global_index = args["globalIndex"].as_bool().unwrap_or(false);
+        let include_blame = args["includeBlame"].as_bool().unwrap_or(false);
+        let include_scope = args["includeScope"].as_bool().unwrap_or(false);
+        let include_generics = args["includeGenerics"].as_bool().unwrap_or(false);
+        let explain = args["explain"].as_bool().unwrap_or(false);
+        let build_tags: Option<Vec<String>> = args["buildTags"].as_array().map(|tags| {
+            tags.iter()
+                .filter_map(|tag| tag.as_str().map(str::to_string))
+                .collect()
+        });
+        let output_format = args["outputFormat"].as_str().unwrap_or("ast-grep");
+        let filter = args["filter"]
+            .as_str()
+            .map(MatchFilter::parse)
+            .transpose()?;
+
+        // Validate the YAML rule configuration before processing (cached
+        // configs were validated when first seen)
+        let prepared_rule = self.prepare_rule(rule_config, true)?;
+
+        // Resolve the target path using MCP roots
+        let resolved_target = self.resolve_path(target)?;
+
+        // Options that work on the matches of a whole-file search
+        let whole_file_search = output_format == "ast-grep"
+            && matches!(operation, "search" | "scan")
+            && args["byteRange"].is_null();
+        for (option, set) in [
+            ("nodeIds", node_ids),
+            ("globalIndex", global_index),
+            ("buildTags", build_tags.is_some()),
+            ("includeBlame", include_blame),
+            ("includeScope", include_scope),
+            ("includeGenerics", include_generics),
+            ("explain", explain),
+        ] {
+            if set && !whole_file_search {
+                return Err(anyhow!(
+                    "{} only applies to whole-file search and scan operations with outputFormat 'ast-grep'",
+                    option
+                ));
+            }
+        }
+        if let Some(byte_range) = args.get("byteRange").filter(|range| !range.is_null()) {
+            if output_format != "ast-grep" || !matches!(operation, "search" | "scan") {
+                return Err(anyhow!(
+                    "byteRange only applies to the search and scan operations with outputFormat 'ast-grep'"
+                ));
+            }
+            return self
+                .scan_byte_range(
+                    rule_config,
+                    &resolved_target,
+                    byte_range,
+                    filter.as_ref(),
+                    &args,
+                )
+                .await;
+        }
+
+        match (output_format, operation) {
+            ("ast-grep", _) => {}
+            ("ripgrep", "search" | "scan") => {
+                return self
+                    .search_ripgrep_json(rule_config, &resolved_target, filter.as_ref())
+                    .await;
+            }
+            ("lsp", "search" | "scan") => {
+                return self
+                    .search_lsp_ranges(rule_config, &resolved_target, filter.as_ref())
+                    .await;
+            }
+            ("ripgrep" | "lsp", _) => {
+                return Err(anyhow!(
+                    "outputFormat '{}' only applies to the search and scan operations",
+                    output_format
+                ))
+            }
+            _ => {
+                return Err(anyhow!(
+                    "Unknown outputFormat: {}. Use 'ast-grep', 'ripgrep' or 'lsp'",
+                    output_format
+                ))
+            }
+        }
+
+        // Writes go through our own atomic, cancellable path rather than
+        // ast-grep's -U, which rewrites files in place. Filtered previews
+        // take it too, since only the kept matches may produce fixes.
+        let options = FixOptions {
+            dry_run,
+            match_brace_style,
+            force,
+            keep_original_as_comment,
+            format,
+            format_edited_only,
+            preserve_blank_lines,
+        };
+        // A file ast-grep cannot read as it stands (a byte order mark,
+        // Latin-1) is scanned as its decoded text instead
+        let decoded = self.is_decoded_file(&resolved_target, &args).await?;
+        if operation == "replace" && (options.needs_own_fixes() || filter.is_some() || decoded) {
+            return self
+                .apply_rule_fixes(
+                    rule_config,
+                    &resolved_target,
+                    &options,
+                    filter.as_ref(),
+                    &args,
+                    ctx,
+                )
+                .await;
+        }
+
+        let temp_rule_file = prepared_rule.path();
+
+        let binary_path = self.binary_manager.ensure_binary().await?;
+        let mut cmd = self.ast_grep_command(&binary_path);
+
+        match operation {
+            "search" => {
+                cmd.arg("scan")
+                    .arg("--rule")
+                    .arg(temp_rule_file)
+                    .arg(&resolved_target)
+                    .arg("--json");
+            }
+            "replace" => {
+                cmd.arg("scan")
+                    .arg("--rule")
+                    .arg(temp_rule_file)
+                    .arg(&resolved_target);
+
+                // Only previews reach this point; applied fixes are handled above
+                cmd.arg("--json");
+            }
+            "scan" => {
+                cmd.arg("scan")
+                    .arg("--rule")
+                    .arg(temp_rule_file)
+                    .arg(&resolved_target)
+                    .arg("--json");
+            }
+            _ => return Err(anyhow!("Unknown operation: {}", operation)),
+        }
+
+        // Searches only read files, so an interrupted run is simply killed
+        cmd.kill_on_drop(true);
+        let stdout = match decoded {
+            true => serde_json::to_vec_pretty(
+                &ctx.run(self.scan_decoded_json(rule_config, &resolved_target, &args))
+                    .await
+                    .map_err(|interruption| anyhow!("execute_rule {}", interruption))??,
+            )?,
+            false => {
+                let output = ctx
+                    .run(self.within_parse_timeout(rule_config, &resolved_target, cmd.output()))
+                    .await
+                    .map_err(|interruption| anyhow!("execute_rule {}", interruption))??;
+                if !output.status.success() {
+                    let stderr = String::from_utf8_lossy(&output.stderr);
+                    return Err(anyhow!("ast-grep failed: {}", stderr));
+                }
+                output.stdout
+            }
+        };
+
+        // The files found under a directory are held to the allowlist one
+        // by one
+        let restricted = !self
+            .config
+            .lock()
+            .unwrap()
+            .protection
+            .editable_languages
+            .is_empty();
+        let stdout = match restricted && resolved_target.is_dir() && !decoded {
+            true => {
+                let mut matches: Vec<Value> = serde_json::from_slice(&stdout)
+                    .map_err(|e| anyhow!("Failed to parse ast-grep JSON output: {}", e))?;
+                self.retain_editable_files(&mut matches);
+                serde_json::to_vec_pretty(&matches)?
+            }
+            false => stdout,
+        };
+
+        if operation == "replace" && preserve_blank_lines {
+            let mut matches: Vec<Value> = serde_json::from_slice(&stdout)
+                .map_err(|e| anyhow!("Failed to parse ast-grep JSON output: {}", e))?;
+            Self::fit_previewed_fixes(&mut matches).await?;
+            return Ok(serde_json::to_string_pretty(&matches)?);
+        }
+        if node_ids
+            || global_index
+            || include_blame
+            || include_scope
+            || include_generics
+            || explain
+            || filter.is_some()
+            || build_tags.is_some()
+        {
+            let mut matches: Vec<Value> = serde_json::from_slice(&stdout)
+                .map_err(|e| anyhow!("Failed to parse ast-grep JSON output: {}", e))?;
+            if let Some(tags) = &build_tags {
+                Self::retain_built_files(&mut matches, tags).await?;
+            }
+            if node_ids {
+                self.add_node_ids(&mut matches, &self.get_rule_language(rule_config)?)
+                    .await?;
+            }
+            let passed_filter = filter.as_ref().map(|filter| filter.to_string());
+            if let Some(filter) = filter {
+                matches = self.filter_matches(&filter, matches, rule_config).await?;
+            }
+            if global_index {
+                Self::index_matches(&mut matches);
+            }
+            if include_blame {
+                Self::add_blame(&mut matches).await;
+            }
+            if include_scope {
+                self.add_scopes(&mut matches, &self.get_rule_language(rule_config)?)
+                    .await?;
+            }
+            if include_generics {
+                self.add_generics(&mut matches, &self.get_rule_language(rule_config)?)
+                    .await?;
+            }
+            if explain {
+                self.add_explanations(&mut matches, rule_config, passed_filter.as_deref())
+                    .await?;
+            }
+            return Ok(serde_json::to_string_pretty(&matches)?);
+        }
+
+        let stdout = String::from_utf8_lossy(&stdout);
+        Ok(stdout.to_string())
+    }
+
+    /// Show previewed fixes as `apply_rule_fixes` would write them, fitted
+    /// to the blank lines around each match.
+    async fn fit_previewed_fixes(matches: &mut [Value]) -> Result<()> {
+        let mut sources: HashMap<String, String> = HashMap::new();
+        for m in matches.iter_mut() {
+            let Some(file) = m["file"].as_str().map(str::to_string) else {
+                continue;
+            };
+            if !sources.contains_key(&file) {
+                let source = tokio::fs::read_to_string(&file).await?;
+                sources.insert(file.clone(), source);
+            }
+            if let Some(edit) = Self::match_to_edit(m, &sources[&file], true) {
+                m["replacement"] = edit.replacement.into();
+            }
+        }
+        Ok(())
+    }
+
+    /// Drop matches in Go files that do not build under `tags`, going by
+    /// their file names and build constraint comments.
+    async fn retain_built_files(matches: &mut Vec<Value>, tags: &[String]) -> Result<()> {
+        let files: std::collections::BTreeSet<String> = matches
+            .iter()
+            .filter_map(|m| m["file"].as_str())
+            .filter(|file| file.ends_with(".go"))
+            .map(str::to_string)
+            .collect();
+        let mut excluded = std::collections::HashSet::new();
+        for file in files {
+            let source = tokio::fs::read_to_string(&file).await?;
+            let name = Path::new(&file).file_name().and_then(|name| name.to_str());
+            let constraint = build_constraint::file_constraint(name, &source)
+                .map_err(|e| anyhow!("{}: {}", file, e))?;
+            if constraint.is_some_and(|expr| !expr.matches(tags)) {
+                excluded.insert(file);
+            }
+        }
+        matches.retain(|m| !excluded.contains(m["file"].as_str().unwrap_or_default()));
+        Ok(())
+    }
+
+    /// Set each match's `blame` to the most recent commit touching its
+    /// lines, blaming each file once. Matches git cannot blame, such as
+    /// those in untracked files, are left without one.
+    async fn add_blame(matches: &mut [Value]) {
+        let mut ranges: HashMap<String, Vec<(usize, usize)>> = HashMap::new();
+        let lines = |m: &Value| {
+            let line = |position: &str| {
+                m["range"][position]["line"]
+                    .as_u64()
+                    .map(|line| line as usize + 1)
+            };
+            Some((line("start")?, line("end")?))
+        };
+        for m in matches.iter() {
+            if let (Some(file), Some(range)) = (m["file"].as_str(), lines(m)) {
+                ranges.entry(file.to_string()).or_default().push(range);
+            }
+        }
+        let mut blames = HashMap::new();
+        for (file, ranges) in ranges {
+            if let Some(commits) = git_blame::blame_lines(Path::new(&file), &ranges).await {
+                blames.insert(file, commits);
+            }
+        }
+        for m in matches.iter_mut() {
+            let (Some(commits), Some((start, end))) = (
+                m["file"].as_str().and_then(|file| blames.get(file)),
+                lines(m),
+            ) else {
+                continue;
+            };
+            if let Some(commit) = git_blame::latest(commits, start..=end) {
+                m["blame"] = commit.to_json();
+            }
+        }
+    }
+
+    /// Set each match's `scope` to the scope it sits in, from the scopes
+    /// enclosing it: named declarations, functions and block statements.
+    /// Each file is scanned for its scopes once.
+    async fn add_scopes(&self, matches: &mut [Value], language: &str) -> Result<()> {
+        let rule_config = self.build_scope_rule(language)?;
+        let outline_kinds = self.get_outline_kinds(language).unwrap_or(&[]);
+        let function_kinds = self.get_function_kinds(language)?;
+        let top_level = Self::top_level_scope(language);
+        // Each file's scopes: span, node kind, scope kind and names
+        let mut files: HashMap<String, Vec<(NodeSpan, String, &str, Vec<String>)>> = HashMap::new();
+        for m in matches.iter_mut() {
+            let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let file = file.to_string();
+            if !files.contains_key(&file) {
+                let mut scopes = Vec::new();
+                for scope in self.scan_json(&rule_config, Path::new(&file)).await? {
+                    let (Some(node), Some(scope_span)) =
+                        (scope["kind"].as_str(), NodeSpan::from_match(&scope))
+                    else {
+                        continue;
+                    };
+                    let single = &scope["metaVariables"]["single"];
+                    let name = single["NAME"]["text"].as_str();
+                    let (kind, names) =
+                        match outline_kinds.iter().find(|(kind, _, _)| *kind == node) {
+                            Some((_, symbol, _)) if name.is_some() => {
+                                // Go methods belong to their receiver's type
+                                let receiver = single["RECEIVER"]["text"]
+                                    .as_str()
+                                    .map(edit_utils::receiver_type);
+                                (
+                                    *symbol,
+                                    receiver
+                                        .into_iter()
+                                        .chain(name.map(str::to_string))
+                                        .collect(),
+                                )
+                            }
+                            _ if function_kinds.contains(&node) => (
+                                "function",
+                                edit_utils::guess_function_name(&scope_span.text)
+                                    .into_iter()
+                                    .collect(),
+                            ),
+                            _ => ("block", Vec::new()),
+                        };
+                    if kind != "variable" {
+                        scopes.push((scope_span, node.to_string(), kind, names));
+                    }
+                }
+                scopes.sort_by_key(|(span, _, _, _)| (span.start, std::cmp::Reverse(span.end)));
+                files.insert(file.clone(), scopes);
+            }
+            // A match that is itself a scope sits in the one around it
+            let kind = m["kind"].as_str().unwrap_or_default();
+            let ancestors: Vec<(&str, &str, &[String])> = files[&file]
+                .iter()
+                .filter(|(scope, node, _, _)| {
+                    scope.start <= span.start
+                        && span.end <= scope.end
+                        && !((scope.start, scope.end) == (span.start, span.end) && node == kind)
+                })
+                .map(|(_, node, kind, names)| (node.as_str(), *kind, names.as_slice()))
+                .collect();
+            m["scope"] = Self::scope_descriptor(&ancestors, top_level);
+        }
+        Ok(())
+    }
+
+    /// A match's `scope` from the scopes enclosing it, outermost first,
+    /// each with its node kind, scope kind and names: the innermost one's
+    /// kind, or `top_level` if there is none, and the nearest name.
+    fn scope_descriptor(ancestors: &[(&str, &str, &[String])], top_level: &str) -> Value {
+        let path: Vec<&String> = ancestors
+            .iter()
+            .flat_map(|(_, _, names)| names.iter())
+            .collect();
+        let innermost = ancestors.last();
+        serde_json::json!({
+            "kind": innermost.map_or(top_level, |(_, kind, _)| *kind),
+            "node": innermost.map(|(node, _, _)| *node),
+            "name": path.last(),
+            "path": path,
+        })
+    }
+
+    /// The scope at the top level of a file in `language`.
+    fn top_level_scope(language: &str) -> &'static str {
+        match language {
+            "go" | "java" => "package",
+            "c" | "cpp" | "c++" | "csharp" | "cs" => "file",
+            _ => "module",
+        }
+    }
+
+    /// Rule matching the scopes of `language`: the declarations of its
+    /// outline with their names, functions, and block statements.
+    fn build_scope_rule(&self, language: &str) -> Result<String> {
+        let outline = match self.get_outline_kinds(language) {
+            Ok(_) => self.build_outline_rule(language)?,
+            Err(_) => format!("id: file-outline\nlanguage: {language}\nrule:\n  any:\n"),
+        };
+        let kinds: String = self
+            .get_function_kinds(language)?
+            .iter()
+            .chain(self.get_block_scope_kinds(language)?)
+            .map(|kind| format!("    - kind: {kind}\n"))
+            .collect();
+        Ok(outline.replacen("id: file-outline", "id: match-scopes", 1) + &kinds)
+    }
+
+    /// Set each match's `generics` to the generic instantiations and macro
+    /// invocations inside it, in order. An instantiation of a generic
+    /// declared in the same file binds its type parameters to the type
+    /// arguments given, and lists those left to inference; a call without
+    /// type arguments counts only when its callee is such a generic. Each
+    /// file is scanned once.
+    async fn add_generics(&self, matches: &mut [Value], language: &str) -> Result<()> {
+        let rule_config = self.build_generics_rule(language)?;
+        let (open, close) = match language {
+            "go" => ('[', ']'),
+            _ => ('<', '>'),
+        };
+        // Each file's generic declarations by name, and its instantiations
+        type Declarations = HashMap<String, (usize, &'static str, Vec<String>)>;
+        type Uses = Vec<(NodeSpan, &'static str, NodeSpan, usize)>;
+        let mut files: HashMap<String, (Declarations, Uses)> = HashMap::new();
+        for m in matches.iter_mut() {
+            let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let file = file.to_string();
+            if !files.contains_key(&file) {
+                let mut declarations = Declarations::new();
+                let mut uses = Vec::new();
+                for found in self.scan_json(&rule_config, Path::new(&file)).await? {
+                    let single = &found["metaVariables"]["single"];
+                    let (Some(kind), Some(node), Some(name)) = (
+                        found["kind"].as_str(),
+                        NodeSpan::from_match(&found),
+                        NodeSpan::from_match(&single["NAME"]),
+                    ) else {
+                        continue;
+                    };
+                    let line = found["range"]["start"]["line"].as_u64().unwrap_or(0) as usize + 1;
+                    let declared = match kind {
+                        "function_declaration" | "function_item" => Some("function"),
+                        "macro_definition" => Some("macro"),
+                        "type_spec" | "struct_item" | "enum_item" | "union_item" | "trait_item"
+                        | "type_item" => Some("type"),
+                        _ => None,
+                    };
+                    match declared {
+                        Some(declared) => {
+                            let params = single["PARAMS"]["text"]
+                                .as_str()
+                                .map(edit_utils::type_parameter_names)
+                                .unwrap_or_default();
+                            declarations.insert(name.text, (line, declared, params));
+                        }
+                        None => {
+                            let use_kind = match kind {
+                                "call_expression" => "call",
+                                "generic_type" => "type",
+                                "macro_invocation" => "macro",
+                                _ => "value",
+                            };
+                            uses.push((node, use_kind, name, line));
+                        }
+                    }
+                }
+                // A call's callee is only reported as the call
+                let callees: Vec<(usize, usize)> = uses
+                    .iter()
+                    .filter(|(_, kind, _, _)| *kind == "call")
+                    .map(|(_, _, name, _)| (name.start, name.end))
+                    .collect();
+                uses.retain(|(node, kind, _, _)| {
+                    *kind == "call" || !callees.contains(&(node.start, node.end))
+                });
+                uses.sort_by_key(|(node, _, _, _)| node.start);
+                files.insert(file.clone(), (declarations, uses));
+            }
+
+            let (declarations, uses) = &files[&file];
+            let mut generics = Vec::new();
+            for (node, kind, name, line) in uses {
+                if node.start < span.start || span.end < node.end {
+                    continue;
+                }
+                // The callee and the type arguments written after it
+                let head = match *kind {
+                    "macro" => format!("{}!", name.text),
+                    "call" => {
+                        let after = &node.text[name.end - node.start..];
+                        let list = after
+                            .trim_start()
+                            .starts_with(open)
+                            .then(|| {
+                                let list = after.trim_start();
+                                let mut depth = 0;
+                                list.char_indices().find_map(|(i, c)| {
+                                    depth += (c == open) as i32 - (c == close) as i32;
+                                    (depth == 0).then(|| &list[..=i])
+                                })
+                            })
+                            .flatten()
+                            .unwrap_or_default();
+                        format!("{}{list}", name.text)
+                    }
+                    _ => node.text.clone(),
+                };
+                let (callee, arguments) = match edit_utils::type_arguments(&head, open, close) {
+                    Some((callee, arguments)) => (callee.to_string(), Some(arguments)),
+                    None => (name.text.clone(), None),
+                };
+                let (qualifier, generic) = match callee.rsplit_once(['.', ':']) {
+                    Some((qualifier, generic)) => (
+                        Some(qualifier.trim_end_matches(':').to_string()),
+                        generic.to_string(),
+                    ),
+                    None => (None, callee.clone()),
+                };
+                let declaration = declarations.get(&generic);
+                let entry = match (*kind, arguments) {
+                    ("macro", _) => {
+                        let tree = node.text[name.end - node.start..]
+                            .trim_start()
+                            .trim_start_matches('!')
+                            .trim();
+                        let inner = tree.get(1..tree.len().saturating_sub(1)).unwrap_or("");
+                        serde_json::json!({
+                            "kind": "macro",
+                            "name": generic,
+                            "qualifier": qualifier,
+                            "text": head,
+                            "line": line,
+                            "arguments": edit_utils::split_top_level_commas(inner),
+                            "declared_line": declaration
+                                .filter(|(_, declared, _)| *declared == "macro")
+                                .map(|(line, _, _)| line),
+                        })
+                    }
+                    (_, arguments) => {
+                        let declaration = declaration.filter(|(_, declared, params)| {
+                            *declared != "macro" && !params.is_empty()
+                        });
+                        // Without a declaration to go by, Go's brackets may
+                        // index rather than instantiate
+                        let indexes =
+                            language == "go" && (*kind == "value" || name.text.ends_with(close));
+                        if declaration.is_none() && (arguments.is_none() || indexes) {
+                            continue;
+                        }
+                        let arguments = arguments.unwrap_or_default();
+                        let lifetimes = arguments.iter().any(|argument| argument.starts_with('\''));
+                        let params: Vec<&String> = declaration
+                            .map(|(_, _, params)| {
+                                params
+                                    .iter()
+                                    .filter(|param| lifetimes || !param.starts_with('\''))
+                                    .collect()
+                            })
+                            .unwrap_or_default();
+                        let bindings: serde_json::Map<String, Value> = params
+                            .iter()
+                            .zip(&arguments)
+                            .map(|(param, argument)| (param.to_string(), (*argument).into()))
+                            .collect();
+                        serde_json::json!({
+                            "kind": kind,
+                            "name": generic,
+                            "qualifier": qualifier,
+                            "text": head,
+                            "line": line,
+                            "type_arguments": arguments,
+                            "declared_line": declaration.map(|(line, _, _)| line),
+                            "type_parameters": declaration.map(|(_, _, params)| params),
+                            "bindings": bindings,
+                            "inferred": params.get(arguments.len()..).unwrap_or_default(),
+                        })
+                    }
+                };
+                generics.push(entry);
+            }
+            m["generics"] = generics.into();
+        }
+        Ok(())
+    }
+
+    /// Rule matching generic declarations with their type parameters, and
+    /// the calls, instantiations and macro invocations that may use them.
+    fn build_generics_rule(&self, language: &str) -> Result<String> {
+        let kinds = match language {
+            "go" => "    - kind: call_expression\n      has: { field: function, pattern: $NAME }\n    - kind: index_expression\n      has: { field: operand, pattern: $NAME }\n    - kind: generic_type\n      has: { field: type, pattern: $NAME }\n    - all:\n        - any: [{ kind: function_declaration }, { kind: type_spec }]\n        - has: { field: name, pattern: $NAME }\n        - has: { field: type_parameters, pattern: $PARAMS }\n",
+            "rust" => "    - kind: call_expression\n      has: { field: function, pattern: $NAME }\n    - kind: generic_function\n      has: { field: function, pattern: $NAME }\n    - kind: generic_type\n      has: { field: type, pattern: $NAME }\n    - kind: macro_invocation\n      has: { field: macro, pattern: $NAME }\n    - kind: macro_definition\n      has: { field: name, pattern: $NAME }\n    - all:\n        - any: [{ kind: function_item }, { kind: struct_item }, { kind: enum_item }, { kind: union_item }, { kind: trait_item }, { kind: type_item }]\n        - has: { field: name, pattern: $NAME }\n        - has: { field: type_parameters, pattern: $PARAMS }\n",
+            _ => {
+                return Err(anyhow!(
+                    "includeGenerics supports go and rust, not {}",
+                    language
+                ))
+            }
+        };
+        Ok(format!(
+            "id: match-generics\nlanguage: {language}\nrule:\n  any:\n{kinds}"
+        ))
+    }
+
+    /// Put matches from any number of files in a fixed order, by path and
+    /// then by position in the file, and number them with `globalIndex`.
+    /// ast-grep walks directories in parallel, so its own order varies
+    /// from run to run.
+    fn index_matches(matches: &mut [Value]) {
+        matches.sort_by(|a, b| {
+            let key = |m: &Value| {
+                (
+                    m["file"].as_str().unwrap_or_default().to_string(),
+                    m["range"]["byteOffset"]["start"].as_u64(),
+                    m["range"]["byteOffset"]["end"].as_u64(),
+                )
+            };
+            key(a).cmp(&key(b))
+        });
+        for (index, m) in matches.iter_mut().enumerate() {
+            m["globalIndex"] = index.into();
+        }
+    }
+
+    /// Re-run a search and return the match now at `index` in the order of
+    /// an earlier `globalIndex` search, with its current offsets.
+    async fn resolve_match_index(&self, args: Value) -> Result<String> {
+        let rule_config = args["rule_config"]
+            .as_str()
+            .ok_or(anyhow!("Missing rule_config"))?;
+        let target = args["target"].as_str().ok_or(anyhow!("Missing target"))?;
+        let index = args["index"].as_u64().ok_or(anyhow!("Missing index"))? as usize;
+        let filter = args["filter"]
+            .as_str()
+            .map(MatchFilter::parse)
+            .transpose()?;
+
+        let resolved_target = self.resolve_path(target)?;
+        let mut matches = self.scan_json(rule_config, &resolved_target).await?;
+        if let Some(tags) = args["buildTags"].as_array() {
+            let tags: Vec<String> = tags
+                .iter()
+                .filter_map(|tag| tag.as_str().map(str::to_string))
+                .collect();
+            Self::retain_built_files(&mut matches, &tags).await?;
+        }
+        if let Some(filter) = filter {
+            matches = self.filter_matches(&filter, matches, rule_config).await?;
+        }
+        Self::index_matches(&mut matches);
+
+        let total = matches.len();
+        // Edits since the survey may have added or removed matches ahead
+        // of this one, which would silently shift it
+        if let Some(expected) = args["expected_total"].as_u64() {
+            if expected as usize != total {
+                return Err(anyhow!(
+                    "The search now finds {} matches, not {}; indices may have shifted, so search again",
+                    total,
+                    expected
+                ));
+            }
+        }
+        let m = matches.into_iter().nth(index).ok_or_else(|| {
+            anyhow!(
+                "Match index {} is out of range: the search finds {} matches",
+                index,
+                total
+            )
+        })?;
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "index": index,
+            "total": total,
+            "match": m,
+        }))?)
+    }
+
+    /// The matches passing `filter`. Depth conditions need node ids, which
+    /// are added for the check and dropped again unless the matches already
+    /// had them; `:contains()` conditions need the flags `add_contains`
+    /// sets, which are always dropped.
+    async fn filter_matches(
+        &self,
+        filter: &MatchFilter,
+        mut matches: Vec<Value>,
+        rule_config: &str,
+    ) -> Result<Vec<Value>> {
+        let selectors = filter.contains_selectors();
+        if !filter.needs_node_ids() && selectors.is_empty() {
+            return Ok(filter.apply(matches));
+        }
+        let language = self.get_rule_language(rule_config)?;
+        let had_ids = matches.iter().any(|m| m.get("nodeId").is_some());
+        if filter.needs_node_ids() && !had_ids {
+            self.add_node_ids(&mut matches, &language).await?;
+        }
+        if !selectors.is_empty() {
+            self.add_contains(&mut matches, &selectors, &language)
+                .await?;
+        }
+        let mut kept = filter.apply(matches);
+        for m in &mut kept {
+            if let Some(m) = m.as_object_mut() {
+                if !had_ids {
+                    m.remove("nodeId");
+                }
+                m.remove("contains");
+            }
+        }
+        Ok(kept)
+    }
+
+    /// Set each match's `contains` to whether a node inside it, at any
+    /// depth, is one each of `selectors` picks. Each file is scanned once
+    /// per selector, and the search for a match stops at the first node
+    /// found.
+    async fn add_contains(
+        &self,
+        matches: &mut [Value],
+        selectors: &[&NodeSelector],
+        language: &str,
+    ) -> Result<()> {
+        let rules: Vec<String> = selectors
+            .iter()
+            .map(|selector| selector.rule(language))
+            .collect();
+        // Each file's picked nodes by selector, in order of their start
+        let mut files: HashMap<String, Vec<Vec<(NodeSpan, Option<String>)>>> = HashMap::new();
+        for m in matches.iter_mut() {
+            let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let file = file.to_string();
+            if !files.contains_key(&file) {
+                let mut picked = Vec::new();
+                for rule in &rules {
+                    let mut nodes: Vec<(NodeSpan, Option<String>)> = self
+                        .scan_json(rule, Path::new(&file))
+                        .await?
+                        .iter()
+                        .filter_map(|node| {
+                            let kind = node["kind"].as_str().map(str::to_string);
+                            NodeSpan::from_match(node).map(|span| (span, kind))
+                        })
+                        .collect();
+                    nodes.sort_by_key(|(span, _)| span.start);
+                    picked.push(nodes);
+                }
+                files.insert(file.clone(), picked);
+            }
+            let kind = m["kind"].as_str();
+            let flags: Vec<bool> = files[&file]
+                .iter()
+                .map(|nodes| {
+                    let first = nodes.partition_point(|(node, _)| node.start < span.start);
+                    nodes[first..]
+                        .iter()
+                        .take_while(|(node, _)| node.start < span.end)
+                        .any(|(node, node_kind)| {
+                            // The match is not among its own descendants
+                            let itself = (node.start, node.end) == (span.start, span.end)
+                                && (kind.is_none() || node_kind.as_deref() == kind);
+                            node.end <= span.end && !itself
+                        })
+                })
+                .collect();
+            m["contains"] = flags.into();
+        }
+        Ok(())
+    }
+
+    /// Set each match's `explanation` of why it matched, as `match_explain`
+    /// traces it: the rule's checks, the node each relational step found,
+    /// the constraints with the values they were checked against, the
+    /// filter it passed, and the metavariables it bound. Each file is
+    /// scanned once per step.
+    async fn add_explanations(
+        &self,
+        matches: &mut [Value],
+        rule_config: &str,
+        filter: Option<&str>,
+    ) -> Result<()> {
+        let config: Value =
+            serde_yaml::from_str(rule_config).map_err(|e| anyhow!("Invalid YAML syntax: {}", e))?;
+        let language = self.get_rule_language(rule_config)?;
+        let steps = match_explain::steps(&config["rule"]);
+        let step_rules: Vec<String> = match_explain::flatten(&steps)
+            .iter()
+            .map(|step| {
+                let mut step_config = serde_json::json!({
+                    "id": "explain-step",
+                    "language": language,
+                    "rule": step.rule,
+                });
+                // A sub-rule may match the config's utils
+                if let Some(utils) = config.get("utils") {
+                    step_config["utils"] = utils.clone();
+                }
+                serde_yaml::to_string(&step_config)
+            })
+            .collect::<std::result::Result<_, _>>()?;
+        let mut files: HashMap<String, Vec<(Vec<NodeSpan>, Vec<Value>)>> = HashMap::new();
+        for m in matches.iter_mut() {
+            let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let file = file.to_string();
+            if !files.contains_key(&file) {
+                let mut found = Vec::new();
+                for rule in &step_rules {
+                    let (spans, nodes) = self
+                        .scan_json(rule, Path::new(&file))
+                        .await?
+                        .iter()
+                        .filter_map(|node| {
+                            let span = NodeSpan::from_match(node)?;
+                            let described = serde_json::json!({
+                                "kind": node["kind"],
+                                "text": match_explain::first_line(&span.text),
+                                "range": node["range"],
+                            });
+                            Some((span, described))
+                        })
+                        .unzip();
+                    found.push((spans, nodes));
+                }
+                files.insert(file.clone(), found);
+            }
+            m["explanation"] = serde_json::json!({
+                "checks": match_explain::checks(&config["rule"]),
+                "steps": match_explain::trace(&steps, Some((span.start, span.end)), &files[&file], &mut 0),
+                "constraints": match_explain::constraints(&config, m),
+                "filter": filter,
+                "captures": match_explain::captures(m),
+            });
+        }
+        Ok(())
+    }
+
+    /// Set each match's `nodeId`, its path of named-child indices from the
+    /// root, building each file's tree once.
+    async fn add_node_ids(&self, matches: &mut [Value], language: &str) -> Result<()> {
+        let rule_config = self.node_tree_rule(language);
+        let mut trees: HashMap<String, NodeTree> = HashMap::new();
+        for m in matches.iter_mut() {
+            let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let file = file.to_string();
+            if !trees.contains_key(&file) {
+                let nodes = self.scan_json(&rule_config, Path::new(&file)).await?;
+                trees.insert(file.clone(), NodeTree::from_matches(nodes));
+            }
+            m["nodeId"] = trees[&file]
+                .id_of(span.start, span.end, m["kind"].as_str())
+                .into();
+        }
+        Ok(())
+    }
+
+    /// Find the node a `nodeId` from an earlier search names, in the
+    /// source as it is now.
+    async fn resolve_node_id(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let id = args["node_id"].as_str().ok_or(anyhow!("Missing node_id"))?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let nodes = self
+            .scan_source_json(
+                &self.node_tree_rule(language),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let tree = NodeTree::from_matches(nodes);
+        let node = tree.resolve(id)?;
+        let span = NodeSpan::from_match(node).ok_or_else(|| anyhow!("Node {} has no range", id))?;
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "node_id": id,
+            "kind": node["kind"],
+            "text": &source[span.start..span.end],
+            "range": text_encoding::encode_range(&source, &node["range"], offset_encoding),
+        }))?)
+    }
+
+    /// Count a file's named nodes by kind, most frequent first. With
+    /// `scope_rule` only nodes inside its matches (the matches included)
+    /// are counted, each once however many matches hold it.
+    async fn node_type_histogram(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let limit = args["limit"].as_u64().map(|limit| limit as usize);
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let scopes: Option<Vec<NodeSpan>> = match args["scope_rule"].as_str() {
+            Some(scope_rule) => Some(
+                self.scan_source_json(scope_rule, &source, path.as_deref(), language)
+                    .await?
+                    .iter()
+                    .filter_map(NodeSpan::from_match)
+                    .collect(),
+            ),
+            None => None,
+        };
+        if scopes.as_ref().is_some_and(|scopes| scopes.is_empty()) {
+            return Err(anyhow!("scope_rule matches nothing in the source"));
+        }
+        let nodes = self
+            .scan_source_json(
+                &self.node_tree_rule(language),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+
+        let mut counts: HashMap<String, usize> = HashMap::new();
+        let mut total = 0;
+        for node in &nodes {
+            let (Some(kind), Some(span)) = (node["kind"].as_str(), NodeSpan::from_match(node))
+            else {
+                continue;
+            };
+            if let Some(scopes) = &scopes {
+                if !scopes
+                    .iter()
+                    .any(|scope| scope.start <= span.start && span.end <= scope.end)
+                {
+                    continue;
+                }
+            }
+            *counts.entry(kind.to_string()).or_default() += 1;
+            total += 1;
+        }
+        let mut kinds: Vec<(String, usize)> = counts.into_iter().collect();
+        kinds.sort_by(|(a, a_count), (b, b_count)| b_count.cmp(a_count).then(a.cmp(b)));
+        let distinct = kinds.len();
+        kinds.truncate(limit.unwrap_or(distinct));
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.map(|path| path.display().to_string()),
+            "language": language,
+            "scopes": scopes.map(|scopes| scopes.len()),
+            "total": total,
+            "distinct": distinct,
+            "kinds": kinds
+                .iter()
+                .map(|(kind, count)| serde_json::json!({"kind": kind, "count": count}))
+                .collect::<Vec<_>>(),
+        }))?)
+    }
+
+    /// The fields the grammar of `language` gives `kind`, from its
+    /// `node-types.json`. Similar kinds are suggested for one the grammar
+    /// does not have.
+    async fn fields_for_type(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let kind = args["kind"].as_str().ok_or(anyhow!("Missing kind"))?;
+        self.validate_language(language)?;
+
+        let node_types = self.get_node_types(language).await?;
+        let mut described = node_types::describe(&node_types, kind).ok_or_else(|| {
+            let similar = node_types::similar_types(&node_types, kind);
+            if similar.is_empty() {
+                anyhow!("{} has no node type '{}'", language, kind)
+            } else {
+                anyhow!(
+                    "{} has no node type '{}'; did you mean {}?",
+                    language,
+                    kind,
+                    similar.join(", ")
+                )
+            }
+        })?;
+        described["language"] = language.into();
+        Ok(serde_json::to_string_pretty(&described)?)
+    }
+
+    /// The node types of `language`: a custom grammar's `node_types_path`,
+    /// or those of ast-grep's own grammars, fetched the first time they are
+    /// asked for; they only change with the grammar.
+    async fn get_node_types(&self, language: &str) -> Result<Arc<Value>> {
+        if let Some(node_types) = self.node_types.lock().unwrap().get(language) {
+            return Ok(node_types.clone());
+        }
+        let custom = self
+            .config
+            .lock()
+            .unwrap()
+            .grammars
+            .get(language)
+            .map(|grammar| grammar.node_types_path.clone());
+        let node_types = Arc::new(match custom {
+            Some(Some(path)) => node_types::from_file(&path)?,
+            Some(None) => {
+                return Err(anyhow!(
+                    "The node types of {} are not known; set node_types_path on its grammar in splice-weaver.yaml",
+                    language
+                ))
+            }
+            None => node_types::fetch(language).await?,
+        });
+        self.node_types
+            .lock()
+            .unwrap()
+            .insert(language.to_string(), node_types.clone());
+        Ok(node_types)
+    }
+
+    /// Run a search over part of one file: `byteRange` widened to whole
+    /// top-level items, so the slice parses without the rest of the file.
+    /// Matches overlapping the requested bytes come back with offsets, lines
+    /// and columns in the whole file. Structure crossing the window's edges
+    /// is cut off, so a node spanning past it may be missed or misparsed.
+    async fn scan_byte_range(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        byte_range: &Value,
+        filter: Option<&MatchFilter>,
+        args: &Value,
+    ) -> Result<String> {
+        let start = byte_range["start"]
+            .as_u64()
+            .ok_or(anyhow!("Missing byteRange.start"))? as usize;
+        let end = byte_range["end"]
+            .as_u64()
+            .ok_or(anyhow!("Missing byteRange.end"))? as usize;
+        if !target.is_file() {
+            return Err(anyhow!(
+                "byteRange needs a single file target, not {}",
+                target.display()
+            ));
+        }
+        let source = self.read_source_file(target, args).await?;
+        if start > end || end > source.len() {
+            return Err(anyhow!(
+                "Range {}..{} is outside the source ({} bytes)",
+                start,
+                end,
+                source.len()
+            ));
+        }
+        if !source.is_char_boundary(start) || !source.is_char_boundary(end) {
+            return Err(anyhow!(
+                "Range {}..{} does not fall on character boundaries",
+                start,
+                end
+            ));
+        }
+
+        let language = self.get_rule_language(rule_config)?;
+        let (window_start, window_end) = edit_utils::top_level_window(&source, start, end);
+        let file = target.display().to_string();
+        let mut matches: Vec<Value> = self
+            .scan_code_json(rule_config, &source[window_start..window_end], &language)
+            .await?
+            .iter()
+            .map(|m| {
+                let mut mapped =
+                    embedded::map_match_to_parent(m, &source, window_start, Some(&file));
+                // The window is whole lines, so the matched lines are unchanged
+                for key in ["lines", "charCount"] {
+                    if let Some(value) = m.get(key) {
+                        mapped[key] = value.clone();
+                    }
+                }
+                mapped
+            })
+            .filter(|m| {
+                NodeSpan::from_match(m).is_some_and(|span| {
+                    (span.start < end && span.end > start)
+                        || (span.start <= start && span.end >= end)
+                })
+            })
+            .collect();
+        if let Some(filter) = filter {
+            matches = self.filter_matches(filter, matches, rule_config).await?;
+        }
+        Ok(serde_json::to_string_pretty(&matches)?)
+    }
+
+    /// Run a search and render its matches as line-delimited ripgrep
+    /// `--json` events.
+    async fn search_ripgrep_json(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        filter: Option<&MatchFilter>,
+    ) -> Result<String> {
+        let started = std::time::Instant::now();
+        let mut matches = self.scan_json(rule_config, target).await?;
+        if let Some(filter) = filter {
+            matches = self.filter_matches(filter, matches, rule_config).await?;
+        }
+
+        let mut spans_by_file: std::collections::BTreeMap<String, Vec<NodeSpan>> =
+            std::collections::BTreeMap::new();
+        for m in &matches {
+            if let (Some(file), Some(span)) = (m["file"].as_str(), NodeSpan::from_match(m)) {
+                spans_by_file
+                    .entry(file.to_string())
+                    .or_default()
+                    .push(span);
+            }
+        }
+
+        let mut files = Vec::new();
+        for (file, spans) in spans_by_file {
+            let source = tokio::fs::read_to_string(&file).await?;
+            files.push((file, source, spans));
+        }
+        Ok(ripgrep_json::render(&files, started.elapsed()))
+    }
+
+    /// Run a search and group its matches by file as LSP `Range`s (zero-based
+    /// lines, UTF-16 characters), ready for an editor to select.
+    async fn search_lsp_ranges(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        filter: Option<&MatchFilter>,
+    ) -> Result<String> {
+        let mut matches = self.scan_json(rule_config, target).await?;
+        if let Some(filter) = filter {
+            matches = self.filter_matches(filter, matches, rule_config).await?;
+        }
+
+        let mut matches_by_file: std::collections::BTreeMap<String, Vec<&Value>> =
+            std::collections::BTreeMap::new();
+        for m in &matches {
+            if let Some(file) = m["file"].as_str() {
+                matches_by_file.entry(file.to_string()).or_default().push(m);
+            }
+        }
+
+        let mut files = Vec::new();
+        for (file, file_matches) in matches_by_file {
+            let source = tokio::fs::read_to_string(&file).await?;
+            let path = std::fs::canonicalize(&file).unwrap_or_else(|_| PathBuf::from(&file));
+            let ranges: Vec<Value> = file_matches
+                .iter()
+                .filter_map(|m| text_encoding::lsp_range(&source, &m["range"]))
+                .collect();
+            files.push(serde_json::json!({
+                "uri": format!("file://{}", path.display()),
+                "ranges": ranges,
+            }));
+        }
+        Ok(serde_json::to_string_pretty(&files)?)
+    }
+
+    /// Replace every match of `pattern` in a file with `replacement`, a
+    /// template in which each metavariable stands for the source text it
+    /// captured, formatting and comments included. Captures may be
+    /// reordered, repeated or dropped. A metavariable used more than once
+    /// in the pattern makes ast-grep require its occurrences to match the
+    /// same code, so `$A == $A` matches `x == x` but not `x == y`.
+    async fn structural_replace(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let pattern = args["pattern"].as_str().ok_or(anyhow!("Missing pattern"))?;
+        let replacement = args["replacement"]
+            .as_str()
+            .ok_or(anyhow!("Missing replacement"))?;
+        self.validate_language(language)?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        // Each captured name and whether it captures a list of nodes
+        let mut captured: Vec<(String, bool)> = Vec::new();
+        let mut repeated: Vec<String> = Vec::new();
+        for (name, multi) in rule_check::captures(pattern) {
+            match captured.iter().find(|(seen, _)| *seen == name) {
+                Some((_, seen_multi)) if *seen_multi != multi => {
+                    return Err(anyhow!(
+                        "${} is used both as ${} and as $$${} in the pattern",
+                        name,
+                        name,
+                        name
+                    ));
+                }
+                Some(_) if !repeated.contains(&name) => repeated.push(name),
+                Some(_) => {}
+                None => captured.push((name, multi)),
+            }
+        }
+        for (name, multi) in rule_check::captures(replacement) {
+            match captured.iter().find(|(seen, _)| *seen == name) {
+                None => {
+                    return Err(anyhow!(
+                        "${} in the replacement is not captured by the pattern",
+                        name
+                    ));
+                }
+                Some((_, true)) if !multi => {
+                    return Err(anyhow!(
+                        "$$${} captures a list of nodes; write it as $$${} in the replacement",
+                        name,
+                        name
+                    ));
+                }
+                Some((_, false)) if multi => {
+                    return Err(anyhow!(
+                        "${} captures a single node; write it as ${} in the replacement",
+                        name,
+                        name
+                    ));
+                }
+                Some(_) => {}
+            }
+        }
+        let used = rule_check::metavariables(replacement);
+        let dropped: Vec<&str> = captured
+            .iter()
+            .map(|(name, _)| name.as_str())
+            .filter(|name| !used.iter().any(|used| used == name))
+            .collect();
+
+        let (source, path) = self.load_source(&args).await?;
+        // A JSON string is a valid double-quoted YAML scalar
+        let rule = format!(
+            "id: structural-replace\nlanguage: {language}\nrule:\n  pattern: {}\nfix: {}\n",
+            serde_json::to_string(pattern)?,
+            serde_json::to_string(replacement)?
+        );
+        let mut matches = self
+            .scan_source_json(&rule, &source, path.as_deref(), language)
+            .await?;
+        matches.sort_by_key(|m| NodeSpan::from_match(m).map(|span| span.start));
+
+        let mut edits: Vec<TextEdit> = Vec::new();
+        let mut replaced = Vec::new();
+        let mut skipped = Vec::new();
+        for m in &matches {
+            let (Some(span), Some(edit)) = (
+                NodeSpan::from_match(m),
+                Self::match_to_edit(m, &source, true),
+            ) else {
+                continue;
+            };
+            let line = edit_utils::line_number(&source, span.start);
+            // A match nested in one already replaced, such as the receiver
+            // of `a.Close().Close()`, is not where it was once that one is
+            if edits.last().is_some_and(|last| edit.start < last.end) {
+                skipped.push(serde_json::json!({
+                    "line": line,
+                    "text": span.text,
+                    "reason": "it is inside another match; run the replacement again to rewrite it",
+                }));
+                continue;
+            }
+            let metavariables = &m["metaVariables"];
+            let captures: serde_json::Map<String, Value> = captured
+                .iter()
+                .filter_map(|(name, multi)| {
+                    let text = match multi {
+                        false => metavariables["single"][name]["text"].as_str()?.to_string(),
+                        // The source from the first node to the last, with
+                        // the separators between them
+                        true => {
+                            let nodes: Vec<NodeSpan> = metavariables["multi"][name]
+                                .as_array()?
+                                .iter()
+                                .filter_map(NodeSpan::from_match)
+                                .collect();
+                            match (nodes.first(), nodes.last()) {
+                                (Some(first), Some(last)) => {
+                                    source[first.start..last.end].to_string()
+                                }
+                                _ => String::new(),
+                            }
+                        }
+                    };
+                    Some((name.clone(), Value::String(text)))
+                })
+                .collect();
+            replaced.push(serde_json::json!({
+                "line": line,
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+                "before": span.text,
+                "after": edit.replacement,
+                "captures": captures,
+            }));
+            edits.push(edit);
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "pattern": pattern,
+            "replacement": replacement,
+            "metavariables": captured.iter().map(|(name, _)| name).collect::<Vec<_>>(),
+            "repeated": repeated,
+            "dropped": dropped,
+            "count": replaced.len(),
+            "replaced": replaced,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Apply a rule's fixes ourselves. Every file is planned and checked
+    /// against the edit guards before any is written, each write replaces
+    /// the file atomically, and an interruption stops between files with
+    /// the files finished so far reported alongside the status.
+    ///
+    /// With `matchBraceStyle`, inserted braces follow the style already
+    /// used in each file; languages with a canonical formatter are delegated
+    /// to it instead of being restyled by hand. With `format`, every edited
+    /// file goes through its language's registered formatter and is written
+    /// unformatted when the formatter is missing. With `formatEditedOnly`
+    /// either formats only the statements around each fix where it can,
+    /// falling back to the whole file. With
+    /// `keepOriginalAsComment`, each replaced node's original text is
+    /// kept as a `before:` comment below the line the replacement ends on.
+    async fn apply_rule_fixes(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        options: &FixOptions,
+        filter: Option<&MatchFilter>,
+        args: &Value,
+        ctx: &OperationContext,
+    ) -> Result<String> {
+        let FixOptions {
+            dry_run,
+            match_brace_style,
+            force,
+            keep_original_as_comment,
+            format,
+            format_edited_only,
+            preserve_blank_lines,
+        } = *options;
+        let formatter = match format || match_brace_style {
+            true => {
+                let language = self.get_rule_language(rule_config)?;
+                (format || self.delegates_brace_style(&language))
+                    .then(|| self.formatters.lock().unwrap().get(&language).cloned())
+                    .flatten()
+            }
+            false => None,
+        };
+        let comment_prefix = if keep_original_as_comment {
+            Some(self.get_line_comment_prefix(&self.get_rule_language(rule_config)?)?)
+        } else {
+            None
+        };
+        let mut matches = match ctx
+            .run(self.scan_decoded_json(rule_config, target, args))
+            .await
+        {
+            Ok(matches) => matches?,
+            Err(interruption) => {
+                return Ok(serde_json::to_string_pretty(&serde_json::json!({
+                    "applied": false,
+                    "status": interruption.as_str(),
+                    "files": []
+                }))?)
+            }
+        };
+
+        if let Some(filter) = filter {
+            matches = self.filter_matches(filter, matches, rule_config).await?;
+        }
+
+        let mut matches_by_file: std::collections::BTreeMap<String, Vec<&Value>> =
+            std::collections::BTreeMap::new();
+        for m in &matches {
+            if let Some(file) = m["file"].as_str() {
+                matches_by_file.entry(file.to_string()).or_default().push(m);
+            }
+        }
+
+        // A file target was locked for the whole call; a directory's files
+        // are known only now, so anything written since the scan is caught
+        // by comparing match text below
+        let locked_after_scan = !dry_run && !target.is_file();
+        let _locks = match locked_after_scan {
+            true => {
+                self.file_locks
+                    .lock_all(matches_by_file.keys().map(PathBuf::from))
+                    .await
+            }
+            false => Vec::new(),
+        };
+        let mut status = None;
+        let mut planned = Vec::new();
+        for (file, file_matches) in matches_by_file {
+            if let Some(interruption) = ctx.interruption() {
+                status = Some(interruption);
+                break;
+            }
+            let source = self.read_source_file(Path::new(&file), args).await?;
+            if locked_after_scan
+                && file_matches.iter().any(|m| {
+                    NodeSpan::from_match(m)
+                        .is_some_and(|span| source.get(span.start..span.end) != Some(&span.text))
+                })
+            {
+                return Err(anyhow!(
+                    "{} changed while the rule was running; run it again",
+                    file
+                ));
+            }
+            let style = match (match_brace_style, &formatter) {
+                (true, None) => edit_utils::detect_brace_style(&source),
+                _ => None,
+            };
+
+            let mut edits: Vec<TextEdit> = file_matches
+                .iter()
+                .filter_map(|m| Self::match_to_edit(m, &source, preserve_blank_lines))
+                .map(|mut edit| {
+                    if let Some(style) = style {
+                        edit.replacement = edit_utils::restyle_braces(
+                            &edit.replacement,
+                            style,
+                            edit_utils::indentation_at(&source, edit.start),
+                        );
+                    }
+                    edit
+                })
+                .collect();
+            if edits.is_empty() {
+                continue;
+            }
+            if let Some(prefix) = comment_prefix {
+                let originals: Vec<TextEdit> = edits
+                    .iter()
+                    .map(|edit| {
+                        edit_utils::original_as_comment(&source, edit.start, edit.end, prefix)
+                    })
+                    .collect();
+                edits.extend(originals);
+            }
+
+            let new_source = edit_utils::apply_edits(&source, &edits)?;
+            if !dry_run {
+                self.check_edits(&file, &source, &edits, force)?;
+            }
+            planned.push((file, style, source, edits, new_source));
+        }
+
+        let mut files = Vec::new();
+        for (file, style, source, edits, new_source) in planned {
+            let mut formatted = None;
+            let mut format_scope = None;
+            let mut unchanged = false;
+            if !dry_run {
+                if let Some(interruption) = ctx.interruption() {
+                    status = Some(interruption);
+                    break;
+                }
+                // Formatted text is spliced in before the single write, so a
+                // fix the formatter undoes is seen to change nothing
+                let (formatted_source, scope) = match formatter {
+                    Some(_) => {
+                        let language = self.get_rule_language(rule_config)?;
+                        let regions = match format_edited_only {
+                            true => {
+                                let ranges = edit_utils::edited_ranges(&source, &edits);
+                                self.format_edited_regions(&language, &new_source, &ranges)
+                                    .await?
+                            }
+                            false => None,
+                        };
+                        match regions {
+                            Some(regions) => (Some(regions), "edited"),
+                            None => (self.format_source(&language, &new_source).await?, "file"),
+                        }
+                    }
+                    None => (None, "file"),
+                };
+                let written = formatted_source.as_deref().unwrap_or(&new_source);
+                unchanged = written == source;
+                if !unchanged {
+                    self.write_source_file(Path::new(&file), written, &edits, args)
+                        .await?;
+                }
+                if formatter.is_some() {
+                    formatted = Some(formatted_source.is_some());
+                    format_scope = formatted_source.is_some().then_some(scope);
+                }
+            }
+
+            let mut entry = serde_json::json!({
+                "file": file,
+                "edits": edits.len(),
+                "formatted": formatted,
+                "replacements": if dry_run {
+                    Some(edits.iter().map(|e| e.replacement.clone()).collect::<Vec<_>>())
+                } else {
+                    None
+                },
+            });
+            if let Some(format_scope) = format_scope {
+                entry["format_scope"] = format_scope.into();
+            }
+            if unchanged {
+                entry["status"] = "no changes".into();
+            }
+            if match_brace_style {
+                entry["brace_style"] = match (&formatter, style) {
+                    (Some(formatter), _) => format!("delegated to {}", formatter.command),
+                    (None, Some(BraceStyle::SameLine)) => "same-line".to_string(),
+                    (None, Some(BraceStyle::NextLine)) => "next-line".to_string(),
+                    (None, None) => "unchanged".to_string(),
+                }
+                .into();
+            }
+            files.push(entry);
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "applied": !dry_run,
+            "status": status.map_or("completed", |interruption| interruption.as_str()),
+            "files": files
+        }))?)
+    }
+
+    /// Run a rule and save its fixes to an edit plan file instead of
+    /// applying them, so large edit sets can be applied with
+    /// `apply_edits_from_file` without passing through an MCP message.
+    async fn compute_edit_plan(&self, args: Value) -> Result<String> {
+        let rule_config = args["rule_config"]
+            .as_str()
+            .ok_or(anyhow!("Missing rule_config"))?;
+        let target = args["target"].as_str().ok_or(anyhow!("Missing target"))?;
+        let plan_path = args["plan_path"]
+            .as_str()
+            .ok_or(anyhow!("Missing plan_path"))?;
+
+        self.prepare_rule(rule_config, true)?;
+        let resolved_target = self.resolve_path(target)?;
+        let resolved_plan = self.resolve_output_path(plan_path)?;
+        let preserve_blank_lines = args["preserveBlankLines"].as_bool().unwrap_or(true);
+        let matches = self
+            .scan_decoded_json(rule_config, &resolved_target, &args)
+            .await?;
+
+        let mut matches_by_file: std::collections::BTreeMap<String, Vec<&Value>> =
+            std::collections::BTreeMap::new();
+        for m in &matches {
+            if let Some(file) = m["file"].as_str() {
+                matches_by_file.entry(file.to_string()).or_default().push(m);
+            }
+        }
+
+        let mut files = Vec::new();
+        for (file, file_matches) in matches_by_file {
+            let source = self.read_source_file(Path::new(&file), &args).await?;
+            let edits: Vec<TextEdit> = file_matches
+                .iter()
+                .filter_map(|m| Self::match_to_edit(m, &source, preserve_blank_lines))
+                .collect();
+            if edits.is_empty() {
+                continue;
+            }
+            // Fail now rather than when the plan is applied
+            edit_utils::apply_edits(&source, &edits)?;
+            files.push(FilePlan::new(file, &source, edits));
+        }
+        let plan = EditPlan::new(files);
+        atomic_write::write_atomic(&resolved_plan, &plan.to_json()?).await?;
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "plan_path": resolved_plan.display().to_string(),
+            "files": plan.files.len(),
+            "edits": plan.edit_count()
+        }))?)
+    }
+
+    /// Apply an edit plan written by `compute_edit_plan`. The plan is
+    /// refused if any of its files changed since it was computed; otherwise
+    /// files are read and written in the call's `fileEncoding`, as in
+    /// `apply_rule_fixes`.
+    async fn apply_edits_from_file(&self, args: Value, ctx: &OperationContext) -> Result<String> {
+        let plan_path = args["plan_path"]
+            .as_str()
+            .ok_or(anyhow!("Missing plan_path"))?;
+        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
+        let force = args["force"].as_bool().unwrap_or(false);
+
+        let resolved_plan = self.resolve_path(plan_path)?;
+        let contents = tokio::fs::read_to_string(&resolved_plan)
+            .await
+            .map_err(|e| anyhow!("Failed to read {}: {}", resolved_plan.display(), e))?;
+        let plan = EditPlan::from_json(&contents)?;
+
+        let paths = plan
+            .files
+            .iter()
+            .map(|file_plan| self.resolve_path(&file_plan.path))
+            .collect::<Result<Vec<_>>>()?;
+        let _locks = match dry_run {
+            true => Vec::new(),
+            false => self.file_locks.lock_all(paths).await,
+        };
+        let mut stale = Vec::new();
+        let mut planned = Vec::new();
+        for file_plan in &plan.files {
+            let path = self.resolve_path(&file_plan.path)?;
+            let source = self
+                .read_source_file(&path, &args)
+                .await
+                .map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+            if !file_plan.matches(&source) {
+                stale.push(file_plan.path.clone());
+                continue;
+            }
+            let new_source = edit_utils::apply_edits(&source, &file_plan.edits)?;
+            if !dry_run {
+                self.check_edits(&file_plan.path, &source, &file_plan.edits, force)?;
+            }
+            planned.push((path, &file_plan.edits, new_source));
+        }
+        if !stale.is_empty() {
+            return Err(anyhow!(
+                "Edit plan is stale: {} changed since it was computed. Run compute_edit_plan again.",
+                stale.join(", ")
+            ));
+        }
+
+        let mut status = None;
+        let mut files = Vec::new();
+        for (path, edits, new_source) in planned {
+            let mut written = true;
+            if !dry_run {
+                if let Some(interruption) = ctx.interruption() {
+                    status = Some(interruption);
+                    break;
+                }
+                // Leaves a file the edits do not change alone, mtime and all
+                written = self
+                    .write_source_file(&path, &new_source, edits, &args)
+                    .await?;
+            }
+            files.push(serde_json::json!({
+                "file": path.display().to_string(),
+                "edits": edits.len(),
+                "status": (!written).then_some("no changes"),
+            }));
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "applied": !dry_run,
+            "status": status.map_or("completed", |interruption| interruption.as_str()),
+            "files": files
+        }))?)
+    }
+
+    /// Apply a unified diff to the files it names, or to `target` for a
+    /// single-file diff. Every hunk of every file must match before anything
+    /// is written, and files in a supported language are re-parsed so a
+    /// patch that introduces syntax errors is refused.
+    async fn apply_unified_diff(&self, args: Value) -> Result<String> {
+        let diff = args["diff"].as_str().ok_or(anyhow!("Missing diff"))?;
+        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
+        let force = args["force"].as_bool().unwrap_or(false);
+
+        let patches = unified_diff::parse(diff)?;
+        let target = args["target"].as_str();
+        if target.is_some() && patches.len() > 1 {
+            return Err(anyhow!(
+                "target can only be used with a single-file diff; this diff has {} files",
+                patches.len()
+            ));
+        }
+
+        let paths: Vec<PathBuf> = patches
+            .iter()
+            .filter_map(|patch| target.or(patch.path()))
+            .filter_map(|name| self.resolve_output_path(name).ok())
+            .collect();
+        let _locks = match dry_run {
+            true => Vec::new(),
+            false => self.file_locks.lock_all(paths).await,
+        };
+        let mut planned = Vec::new();
+        for patch in &patches {
+            let name = target
+                .or(patch.path())
+                .ok_or_else(|| anyhow!("Diff has a file without a path"))?;
+            if patch.new_path.is_none() {
+                return Err(anyhow!("Deleting files is not supported: {}", name));
+            }
+            let (path, source) = if patch.old_path.is_none() {
+                let path = self.resolve_output_path(name)?;
+                if path.exists() {
+                    return Err(anyhow!("{} already exists; the diff creates it", name));
+                }
+                (path, String::new())
+            } else {
+                let path = self.resolve_path(name)?;
+                let source = self.read_source_file(&path, &args).await?;
+                (path, source)
+            };
+            let display = path.display().to_string();
+            let (edits, hunks) = patch
+                .edits(&source)
+                .map_err(|e| anyhow!("{}: {}", display, e))?;
+            let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+            let language = match args["language"].as_str() {
+                Some(language) => Some(language.to_string()),
+                None => path
+                    .extension()
+                    .and_then(|extension| extension.to_str())
+                    .and_then(|extension| self.extension_language(extension))
+                    .filter(|(_, supported)| *supported)
+                    .map(|(language, _)| language),
+            };
+            if let Some(language) = &language {
+                self.validate_language(language)?;
+                let before = match source.is_empty() {
+                    true => 0,
+                    false => self.count_syntax_errors(&source, language).await?,
+                };
+                let after = self.count_syntax_errors(&new_source, language).await?;
+                if after > before {
+                    return Err(anyhow!(
+                        "{}: the patched file does not parse as {} ({} syntax errors, {} before); nothing was applied",
+                        display,
+                        language,
+                        after,
+                        before
+                    ));
+                }
+            }
+
+            if !dry_run {
+                self.check_edits(&display, &source, &edits, force)?;
+            }
+            planned.push((path, edits, hunks, language, new_source));
+        }
+
+        let encoding = ContentEncoding::from_args(&args)?;
+        let mut files = Vec::new();
+        for (path, edits, hunks, language, new_source) in planned {
+            let unchanged = !dry_run
+                && !self
+                    .write_source_file(&path, &new_source, &edits, &args)
+                    .await?;
+            files.push(serde_json::json!({
+                "file": path.display().to_string(),
+                "hunks": hunks
+                    .iter()
+                    .map(|hunk| serde_json::json!({"line": hunk.line, "offset": hunk.offset}))
+                    .collect::<Vec<_>>(),
+                "validated_as": language,
+                "status": unchanged.then_some("no changes"),
+                "content": if dry_run { Some(encoding.encode(&new_source)) } else { None },
+            }));
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "applied": !dry_run,
+            "files": files
+        }))?)
+    }
+
+    /// Apply edits to Go files as one change and tidy the result: each
+    /// edited file must parse, then goimports adds and removes imports and
+    /// formats it, or the registered Go formatter (gofmt unless configured
+    /// otherwise) formats it where goimports is not installed.
+    /// Nothing is written unless every file got through.
+    async fn apply_go_edits(&self, args: Value) -> Result<String> {
+        let files = args["files"].as_array().ok_or(anyhow!("Missing files"))?;
+        let imports = args["imports"].as_bool().unwrap_or(true);
+        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
+        let force = args["force"].as_bool().unwrap_or(false);
+        let language = "go";
+        if files.is_empty() {
+            return Err(anyhow!("files is empty; give at least one file to edit"));
+        }
+
+        let paths: Vec<PathBuf> = files
+            .iter()
+            .filter_map(|file| file["path"].as_str())
+            .filter_map(|name| self.resolve_path(name).ok())
+            .collect();
+        let _locks = match dry_run {
+            true => Vec::new(),
+            false => self.file_locks.lock_all(paths).await,
+        };
+        let import_rule = "id: go-imports\nlanguage: go\nrule:\n  kind: import_spec\n";
+        let import_paths = |specs: Vec<Value>| -> Vec<String> {
+            specs
+                .iter()
+                .filter_map(|spec| spec["text"].as_str())
+                .filter_map(|text| {
+                    let open = text.find(['"', '`'])?;
+                    Some(text[open + 1..text.len() - 1].to_string())
+                })
+                .collect()
+        };
+        // gofmt unless the config names another formatter or turns it off
+        let go_formatter = self
+            .formatters
+            .lock()
+            .unwrap()
+            .get(language)
+            .map(|formatter| formatter.command.clone());
+        let mut warnings = Vec::new();
+        let mut planned = Vec::new();
+        for file in files {
+            let name = file["path"]
+                .as_str()
+                .ok_or(anyhow!("Missing path for a file"))?;
+            let path = self.resolve_path(name)?;
+            if path.extension().and_then(|extension| extension.to_str()) != Some("go") {
+                return Err(anyhow!("{} is not a Go file", name));
+            }
+            let source = self.read_source_file(&path, &args).await?;
+            let edits: Vec<TextEdit> = serde_json::from_value(file["edits"].clone())
+                .map_err(|e| anyhow!("Invalid edits for {}: {}", name, e))?;
+            let edited =
+                edit_utils::apply_edits(&source, &edits).map_err(|e| anyhow!("{}: {}", name, e))?;
+            let errors = self.count_syntax_errors(&edited, language).await?;
+            if errors > 0 {
+                return Err(anyhow!(
+                    "{}: the edited file does not parse as Go ({} syntax errors); nothing was applied",
+                    name,
+                    errors
+                ));
+            }
+
+            let tidied = match imports {
+                true => Self::run_goimports(&path, &edited)
+                    .await
+                    .map_err(|e| anyhow!("{}: {}; nothing was applied", name, e))?
+                    .map(|tidied| (tidied, "goimports".to_string())),
+                false => None,
+            };
+            let tidied = match tidied {
+                Some(tidied) => Some(tidied),
+                None => {
+                    if imports {
+                        warnings.push(
+                            "goimports is not installed, so imports were left as they are"
+                                .to_string(),
+                        );
+                    }
+                    match &go_formatter {
+                        Some(command) => self
+                            .format_source(language, &edited)
+                            .await?
+                            .map(|formatted| (formatted, command.clone())),
+                        None => None,
+                    }
+                }
+            };
+            if let (None, Some(command)) = (&tidied, &go_formatter) {
+                warnings.push(format!(
+                    "{command} is not installed, so files were left unformatted"
+                ));
+            }
+            let (new_source, formatted_with) = match tidied {
+                Some((tidied, formatter)) => (tidied, Some(formatter)),
+                None => (edited, None),
+            };
+
+            let before = import_paths(
+                self.scan_source_json(import_rule, &source, Some(&path), language)
+                    .await?,
+            );
+            let after = import_paths(
+                self.scan_source_json(import_rule, &new_source, Some(&path), language)
+                    .await?,
+            );
+            let added: Vec<&String> = after
+                .iter()
+                .filter(|import| !before.contains(import))
+                .collect();
+            let removed: Vec<&String> = before
+                .iter()
+                .filter(|import| !after.contains(import))
+                .collect();
+            let entry = serde_json::json!({
+                "file": path.display().to_string(),
+                "edits": edits.len(),
+                "formatted_with": formatted_with,
+                "imports_added": added,
+                "imports_removed": removed,
+            });
+
+            if !dry_run {
+                self.check_edits(&path.display().to_string(), &source, &edits, force)?;
+            }
+            planned.push((path, edits, new_source, entry));
+        }
+        warnings.sort();
+        warnings.dedup();
+
+        let encoding = ContentEncoding::from_args(&args)?;
+        let mut entries = Vec::new();
+        for (path, edits, new_source, mut entry) in planned {
+            let unchanged = !dry_run
+                && !self
+                    .write_source_file(&path, &new_source, &edits, &args)
+                    .await?;
+            entry["status"] = unchanged.then_some("no changes").into();
+            entry["content"] = match dry_run {
+                true => Some(encoding.encode(&new_source)),
+                false => None,
+            }
+            .into();
+            entries.push(entry);
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "applied": !dry_run,
+            "warnings": warnings,
+            "files": entries
+        }))?)
+    }
+
+    /// `source` run through goimports as the file at `path`, so imports of
+    /// packages next to it resolve. `None` when goimports is not installed,
+    /// and an error with its message when it rejects the source.
+    async fn run_goimports(path: &Path, source: &str) -> Result<Option<String>> {
+        let Ok(mut child) = TokioCommand::new("goimports")
+            .arg("-srcdir")
+            .arg(path)
+            .stdin(Stdio::piped())
+            .stdout(Stdio::piped())
+            .stderr(Stdio::piped())
+            .kill_on_drop(true)
+            .spawn()
+        else {
+            return Ok(None);
+        };
+        if let Some(mut stdin) = child.stdin.take() {
+            stdin.write_all(source.as_bytes()).await?;
+        }
+        let output = child.wait_with_output().await?;
+        if !output.status.success() {
+            return Err(anyhow!(
+                "goimports failed: {}",
+                String::from_utf8_lossy(&output.stderr).trim()
+            ));
+        }
+        Ok(Some(String::from_utf8(output.stdout)?))
+    }
+
+    /// The files a patch or preview covers: those of an edit plan
+    /// (`plan_path`), or `files` given as edits or whole new contents,
+    /// each diffed against what is on disk now. A plan whose files changed
+    /// since it was computed is refused, and files the edits leave as they
+    /// are are left out.
+    async fn file_changes(&self, args: &Value) -> Result<Vec<FileChange>> {
+        let context = args["context"].as_u64().unwrap_or(3) as usize;
+        let hunk_headers = args["hunk_headers"].as_str().unwrap_or("none");
+        if !matches!(hunk_headers, "none" | "declaration") {
+            return Err(anyhow!(
+                "Unknown hunk_headers: {}. Use 'none' or 'declaration'",
+                hunk_headers
+            ));
+        }
+        let files: Vec<Value> = match (args["plan_path"].as_str(), args["files"].as_array()) {
+            (Some(plan_path), None) => {
+                let resolved_plan = self.resolve_path(plan_path)?;
+                let contents = tokio::fs::read_to_string(&resolved_plan)
+                    .await
+                    .map_err(|e| anyhow!("Failed to read {}: {}", resolved_plan.display(), e))?;
+                EditPlan::from_json(&contents)?
+                    .files
+                    .iter()
+                    .map(serde_json::to_value)
+                    .collect::<serde_json::Result<_>>()?
+            }
+            (None, Some(files)) => files.clone(),
+            _ => return Err(anyhow!("Provide either plan_path or files")),
+        };
+
+        let mut changes = Vec::new();
+        for file in &files {
+            let name = file["path"]
+                .as_str()
+                .ok_or(anyhow!("Missing path for a file"))?;
+            // A new file may be in a directory the patch also creates
+            let path = match self.resolve_output_path(name) {
+                Ok(path) => path,
+                Err(_) if Path::new(name).is_relative() => self.patch_bases()?[0].join(name),
+                Err(e) => return Err(e),
+            };
+            let old = match path.is_file() {
+                true => Some(
+                    tokio::fs::read_to_string(&path)
+                        .await
+                        .map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?,
+                ),
+                false => None,
+            };
+            if let Some(sha256) = file["sha256"].as_str() {
+                if !old.as_deref().is_some_and(|old| {
+                    sha256.eq_ignore_ascii_case(&crate::edit_plan::content_hash(old))
+                }) {
+                    return Err(anyhow!(
+                        "Edit plan is stale: {} changed since it was computed. Run compute_edit_plan again.",
+                        name
+                    ));
+                }
+            }
+            let new = match (file["content"].as_str(), file.get("edits")) {
+                (Some(content), None) => content.to_string(),
+                (None, Some(edits)) => {
+                    let edits: Vec<TextEdit> = serde_json::from_value(edits.clone())
+                        .map_err(|e| anyhow!("Invalid edits for {}: {}", name, e))?;
+                    let old = old.as_deref().ok_or_else(|| {
+                        anyhow!(
+                            "{} does not exist; give a new file's content instead of edits",
+                            name
+                        )
+                    })?;
+                    edit_utils::apply_edits(old, &edits)?
+                }
+                _ => return Err(anyhow!("Give either content or edits for {}", name)),
+            };
+
+            let patch_path = self.patch_path(&path)?;
+            let mode = Self::git_file_mode(&path);
+            let headers = match (hunk_headers, &old) {
+                ("declaration", Some(old)) => self.declaration_headers(old, &path).await?,
+                _ => Vec::new(),
+            };
+            // The innermost declaration holding the line, i.e. the last to start
+            let section = |line: usize| {
+                headers
+                    .iter()
+                    .filter(|(first, last, _)| *first <= line && line <= *last)
+                    .max_by_key(|(first, _, _)| *first)
+                    .map(|(_, _, header)| header.clone())
+            };
+            let Some(file_patch) = unified_diff::format_git_patch(
+                &patch_path,
+                old.as_deref(),
+                &new,
+                mode,
+                context,
+                section,
+            ) else {
+                continue;
+            };
+            changes.push(FileChange {
+                path,
+                patch_path,
+                old,
+                new,
+                diff: file_patch,
+            });
+        }
+        if changes.is_empty() {
+            return Err(anyhow!(
+                "The edits change nothing, so there is no patch to write"
+            ));
+        }
+        Ok(changes)
+    }
+
+    /// Write edits as a patch `git apply` accepts, instead of applying
+    /// them: the files of an edit plan (`plan_path`), or `files` given as
+    /// edits or whole new contents. Files that do not exist yet are added
+    /// as new files. The patch goes to `output`, or is returned if there is
+    /// none. With `hunk_headers: "declaration"` each hunk is headed by the
+    /// declaration its first change is in, found in the parse tree.
+    async fn write_patch(&self, args: Value) -> Result<String> {
+        let changes = self.file_changes(&args).await?;
+        let patch: String = changes.iter().map(|change| change.diff.as_str()).collect();
+        let entries: Vec<Value> = changes.iter().map(FileChange::summary).collect();
+
+        let output = match args["output"].as_str() {
+            Some(output) => {
+                let resolved_output = self.resolve_output_path(output)?;
+                atomic_write::write_atomic(&resolved_output, &patch).await?;
+                Some(resolved_output.display().to_string())
+            }
+            None => None,
+        };
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "output": output,
+            "files": entries,
+            "patch": if output.is_some() { None } else { Some(patch) },
+        }))?)
+    }
+
+    /// Show what an edit plan or a set of file edits would change, as a
+    /// diff per file against what is on disk now, without writing any of
+    /// them. With `save_plan` the change shown is also saved as an edit
+    /// plan, which `apply_edits_from_file` then applies exactly as shown,
+    /// or refuses if any of the files changed in between.
+    async fn preview_edits(&self, args: Value) -> Result<String> {
+        let changes = self.file_changes(&args).await?;
+        let diffs: serde_json::Map<String, Value> = changes
+            .iter()
+            .map(|change| (change.patch_path.clone(), change.diff.clone().into()))
+            .collect();
+        let plan_path = match args["save_plan"].as_str() {
+            Some(plan_path) => {
+                let resolved_plan = self.resolve_output_path(plan_path)?;
+                let files = changes
+                    .iter()
+                    .map(|change| {
+                        let old = change.old.as_deref().ok_or_else(|| {
+                            anyhow!(
+                                "{} does not exist yet, and an edit plan only edits existing files; use write_patch for new files",
+                                change.patch_path
+                            )
+                        })?;
+                        let edits = edit_guard::edit_between(old, &change.new)
+                            .into_iter()
+                            .collect();
+                        Ok(FilePlan::new(
+                            change.path.display().to_string(),
+                            old,
+                            edits,
+                        ))
+                    })
+                    .collect::<Result<Vec<_>>>()?;
+                atomic_write::write_atomic(&resolved_plan, &EditPlan::new(files).to_json()?)
+                    .await?;
+                Some(resolved_plan.display().to_string())
+            }
+            None => None,
+        };
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "files": changes.iter().map(FileChange::summary).collect::<Vec<_>>(),
+            "diffs": diffs,
+            "plan_path": plan_path,
+        }))?)
+    }
+
+    /// Git's mode for the file at `path`: executable or not. New files and
+    /// files on other platforms are plain files.
+    #[cfg_attr(not(unix), allow(unused_variables))]
+    fn git_file_mode(path: &Path) -> &'static str {
+        #[cfg(unix)]
+        {
+            use std::os::unix::fs::PermissionsExt;
+            if std::fs::metadata(path)
+                .is_ok_and(|metadata| metadata.permissions().mode() & 0o111 != 0)
+            {
+                return "100755";
+            }
+        }
+        "100644"
+    }
+
+    /// Directories the paths in a patch are relative to: the workspace
+    /// roots, or the current directory when no roots are set.
+    fn patch_bases(&self) -> Result<Vec<PathBuf>> {
+        let roots: Vec<PathBuf> = self
+            .roots
+            .lock()
+            .unwrap()
+            .iter()
+            .map(|root| PathBuf::from(root.uri.strip_prefix("file://").unwrap_or(&root.uri)))
+            .collect();
+        match roots.is_empty() {
+            true => Ok(vec![std::env::current_dir()
+                .map_err(|e| anyhow!("Failed to get current directory: {}", e))?]),
+            false => Ok(roots),
+        }
+    }
+
+    /// The functions, methods, types and modules of `source`, the file at
+    /// `path`: their first and last lines, and the first line's text as a
+    /// hunk header shows it. Empty when the file's language has no outline.
+    async fn declaration_headers(
+        &self,
+        source: &str,
+        path: &Path,
+    ) -> Result<Vec<(usize, usize, String)>> {
+        let Some(language) = path
+            .extension()
+            .and_then(|extension| extension.to_str())
+            .and_then(|extension| self.extension_language(extension))
+            .filter(|(_, supported)| *supported)
+            .map(|(language, _)| language)
+        else {
+            return Ok(Vec::new());
+        };
+        let Ok(outline_kinds) = self.get_outline_kinds(&language) else {
+            return Ok(Vec::new());
+        };
+        let rule_config = self.build_outline_rule(&language)?;
+        Ok(self
+            .scan_source_json(&rule_config, source, Some(path), &language)
+            .await?
+            .iter()
+            .filter(|m| {
+                outline_kinds
+                    .iter()
+                    .any(|(kind, symbol, _)| m["kind"] == *kind && *symbol != "variable")
+            })
+            .filter_map(NodeSpan::from_match)
+            .map(|span| {
+                let line_start = edit_utils::line_start(source, span.start);
+                let line = source[line_start..edit_utils::line_end(source, span.start)].trim();
+                // Git cuts the heading at 80 bytes
+                let mut end = line.len().min(80);
+                while !line.is_char_boundary(end) {
+                    end -= 1;
+                }
+                (
+                    edit_utils::line_number(source, span.start),
+                    edit_utils::line_number(source, span.end),
+                    line[..end].trim_end().to_string(),
+                )
+            })
+            .collect())
+    }
+
+    /// `path` as a patch names it, relative to the base holding it.
+    fn patch_path(&self, path: &Path) -> Result<String> {
+        self.patch_bases()?
+            .iter()
+            .find_map(|base| path.strip_prefix(base).ok())
+            .map(|relative| relative.to_string_lossy().replace('\\', "/"))
+            .ok_or_else(|| anyhow!("{} is outside the workspace roots", path.display()))
+    }
+
+    /// Number of nodes in `source` that tree-sitter could not parse.
+    async fn count_syntax_errors(&self, source: &str, language: &str) -> Result<usize> {
+        let rule_config =
+            format!("id: syntax-errors\nlanguage: {language}\nrule:\n  kind: ERROR\n");
+        Ok(self
+            .scan_code_json(&rule_config, source, language)
+            .await?
+            .len())
+    }
+
+    /// The fix ast-grep would apply for a scan match, as a text edit.
+    /// The edit a rule's fix makes at match `m` in `source`. With
+    /// `preserve_blank_lines` the replacement is fitted to the line breaks
+    /// at the edges of the replaced text, so the blank lines around the
+    /// match stay as they were.
+    fn match_to_edit(m: &Value, source: &str, preserve_blank_lines: bool) -> Option<TextEdit> {
+        let start = m["replacementOffsets"]["start"].as_u64()? as usize;
+        let end = m["replacementOffsets"]["end"].as_u64()? as usize;
+        let replacement = m["replacement"].as_str()?;
+        Some(TextEdit {
+            start,
+            end,
+            replacement: match preserve_blank_lines {
+                true => edit_utils::fit_blank_lines(source.get(start..end)?, replacement),
+                false => replacement.to_string(),
+            },
+        })
+    }
+
+    fn get_rule_language(&self, rule_config: &str) -> Result<String> {
+        let parsed: serde_yaml::Value =
+            serde_yaml::from_str(rule_config).map_err(|e| anyhow!("Invalid YAML syntax: {}", e))?;
+        parsed
+            .get("language")
+            .and_then(|v| v.as_str())
+            .map(|language| language.to_lowercase())
+            .ok_or_else(|| anyhow!("Rule is missing a language field"))
+    }
+
+    /// Prefix of a line comment in `language`.
+    fn get_line_comment_prefix(&self, language: &str) -> Result<&'static str> {
+        match language {
+            "python" => Ok("#"),
+            "javascript" | "typescript" | "rust" | "java" | "go" | "cpp" | "c++" | "c"
+            | "csharp" | "cs" | "swift" => Ok("//"),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Whether `language`'s formatter enforces a single brace style, so
+    /// that `matchBraceStyle` formats replacements in it rather than
+    /// restyling them.
+    fn delegates_brace_style(&self, language: &str) -> bool {
+        language == "go"
+    }
+
+    /// Formatters that read a fragment of a file (a run of statements or
+    /// declarations) on stdin and write it back formatted at its original
+    /// indentation.
+    fn get_fragment_formatter(
+        &self,
+        language: &str,
+    ) -> Option<(&'static str, &'static [&'static str])> {
+        match language {
+            "go" => Some(("gofmt", &[])),
+            _ => None,
+        }
+    }
+
+    /// Node kinds that a fragment formatter accepts on their own lines:
+    /// statements, and declarations at the top level.
+    fn get_statement_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "go" => Ok(&[
+                "function_declaration",
+                "method_declaration",
+                "import_declaration",
+                "const_declaration",
+                "type_declaration",
+                "var_declaration",
+                "short_var_declaration",
+                "assignment_statement",
+                "expression_statement",
+                "inc_statement",
+                "dec_statement",
+                "send_statement",
+                "return_statement",
+                "go_statement",
+                "defer_statement",
+                "if_statement",
+                "for_statement",
+                "expression_switch_statement",
+                "type_switch_statement",
+                "select_statement",
+                "labeled_statement",
+                "block",
+            ]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// `source` with only the statements around `ranges` run through the
+    /// language's fragment formatter. `None` when the language has none, a
+    /// range is not inside a statement on lines of its own, or a fragment
+    /// fails to format, so the caller can format the whole file instead.
+    async fn format_edited_regions(
+        &self,
+        language: &str,
+        source: &str,
+        ranges: &[(usize, usize)],
+    ) -> Result<Option<String>> {
+        let Some((command, command_args)) = self.get_fragment_formatter(language) else {
+            return Ok(None);
+        };
+        let kinds = self
+            .get_statement_kinds(language)?
+            .iter()
+            .map(|kind| format!("    - kind: {kind}\n"))
+            .collect::<String>();
+        let rule_config =
+            format!("id: format-statements\nlanguage: {language}\nrule:\n  any:\n{kinds}");
+        let statements: Vec<NodeSpan> = self
+            .scan_code_json(&rule_config, source, language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let Some(regions) = edit_utils::format_regions(source, &statements, ranges) else {
+            return Ok(None);
+        };
+
+        let mut edits = Vec::new();
+        for (start, end) in regions {
+            let Some(replacement) =
+                formatter::run(command, command_args, &source[start..end]).await?
+            else {
+                return Ok(None);
+            };
+            edits.push(TextEdit {
+                start,
+                end,
+                replacement,
+            });
+        }
+        Ok(Some(edit_utils::apply_edits(source, &edits)?))
+    }
+
+    /// `source` run whole through the language's registered formatter, or
+    /// `None` when it has none or the formatter is missing or fails.
+    async fn format_source(&self, language: &str, source: &str) -> Result<Option<String>> {
+        let registered = self.formatters.lock().unwrap().get(language).cloned();
+        match registered {
+            Some(formatter) => formatter::run(&formatter.command, &formatter.args, source).await,
+            None => Ok(None),
+        }
+    }
+
+    fn get_file_extension(&self, language: &str) -> Result<String> {
+        let extension = match language {
+            "javascript" => "js",
+            "typescript" => "ts",
+            "rust" => "rs",
+            "python" => "py",
+            "java" => "java",
+            "go" => "go",
+            "cpp" | "c++" => "cpp",
+            "c" => "c",
+            "csharp" | "cs" => "cs",
+            "swift" => "swift",
+            "html" => "html",
+            "css" => "css",
+            _ => {
+                return self
+                    .grammars
+                    .lock()
+                    .unwrap()
+                    .file_extension(language)
+                    .map(str::to_string)
+                    .ok_or_else(|| anyhow!("Unsupported language: {}", language))
+            }
+        };
+        Ok(extension.to_string())
+    }
+
+    /// Language a file extension maps to. Unsupported languages are still
+    /// named so callers can say exactly what is missing.
+    fn get_extension_language(&self, extension: &str) -> Option<(&'static str, bool)> {
+        let extension = extension.to_ascii_lowercase();
+        LANGUAGE_EXTENSIONS
+            .iter()
+            .find(|(_, _, extensions)| extensions.contains(&extension.as_str()))
+            .map(|(language, supported, _)| (*language, *supported))
+    }
+
+    /// `get_extension_language`, falling back to the custom grammars, which
+    /// are all supported, unless it names a supported language. A grammar
+    /// such as tree-sitter-lua thus takes over from an unsupported one.
+    fn extension_language(&self, extension: &str) -> Option<(String, bool)> {
+        let detected = self.get_extension_language(extension);
+        if let Some((language, true)) = detected {
+            return Some((language.to_string(), true));
+        }
+        self.grammars
+            .lock()
+            .unwrap()
+            .extension_language(extension)
+            .map(|language| (language.to_string(), true))
+            .or(detected.map(|(language, supported)| (language.to_string(), supported)))
+    }
+
+    /// Language named by a `#!` line, e.g. `#!/usr/bin/env python3`.
+    fn get_shebang_language(&self, first_line: &str) -> Option<(&'static str, bool)> {
+        let command = first_line.strip_prefix("#!")?;
+        let mut words = command.split_whitespace();
+        let mut interpreter = words.next()?.rsplit('/').next()?;
+        if interpreter == "env" {
+            // Skip env flags such as `-S`
+            interpreter = words.find(|word| !word.starts_with('-'))?;
+        }
+        let interpreter = interpreter.trim_end_matches(|c: char| c.is_ascii_digit() || c == '.');
+        match interpreter {
+            "node" | "nodejs" | "bun" => Some(("javascript", true)),
+            "deno" | "ts-node" | "tsx" => Some(("typescript", true)),
+            "python" | "pypy" => Some(("python", true)),
+            "rust-script" => Some(("rust", true)),
+            "swift" => Some(("swift", true)),
+            "ruby" => Some(("ruby", false)),
+            "php" => Some(("php", false)),
+            "lua" => Some(("lua", false)),
+            "sh" | "bash" | "zsh" => Some(("bash", false)),
+            _ => None,
+        }
+    }
+
+    /// Report which language the server would use for a file and why:
+    /// an explicit override, the file extension, or a shebang line.
+    async fn detect_language(&self, args: Value) -> Result<String> {
+        let path = args["path"].as_str().ok_or(anyhow!("Missing path"))?;
+
+        let detected = if let Some(language) = args["language"].as_str() {
+            let language = language.to_ascii_lowercase();
+            let supported = self.validate_language(&language).is_ok();
+            Some((language, "override", supported))
+        } else if let Some((language, supported)) = Path::new(path)
+            .extension()
+            .and_then(|extension| extension.to_str())
+            .and_then(|extension| self.extension_language(extension))
+        {
+            Some((language, "extension", supported))
+        } else {
+            let content = match args["content"].as_str() {
+                Some(content) => Some(content.to_string()),
+                None => match self.resolve_path(path) {
+                    Ok(resolved) => tokio::fs::read_to_string(resolved).await.ok(),
+                    Err(_) => None,
+                },
+            };
+            content
+                .as_deref()
+                .and_then(|content| content.lines().next())
+                .and_then(|line| self.get_shebang_language(line))
+                .map(|(language, supported)| (language.to_string(), "shebang", supported))
+        };
+
+        const SUPPORTED: &str =
+            "javascript, typescript, rust, python, java, go, cpp, c, csharp, swift, html, css";
+        let result = match detected {
+            Some((language, method, true)) => serde_json::json!({
+                "path": path,
+                "language": language,
+                "method": method,
+                "supported": true
+            }),
+            Some((language, method, false)) => serde_json::json!({
+                "path": path,
+                "language": language,
+                "method": method,
+                "supported": false,
+                "message": format!(
+                    "{language} is not supported by this server, so parse and edit tools will reject this file. Supported languages: {SUPPORTED}"
+                )
+            }),
+            None => serde_json::json!({
+                "path": path,
+                "language": null,
+                "method": null,
+                "supported": false,
+                "message": format!(
+                    "Could not detect a language from the extension or a shebang line. Pass language explicitly; supported languages: {SUPPORTED}"
+                )
+            }),
+        };
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Run a rule against a file or directory and return ast-grep's JSON
+    /// matches, within the rule language's `parse_timeout`.
+    async fn scan_json(&self, rule_config: &str, target: &Path) -> Result<Vec<Value>> {
+        let rule_file = self.prepare_rule(rule_config, false)?;
+        let binary_path = self.binary_manager.ensure_binary().await?;
+        let command = self
+            .ast_grep_command(&binary_path)
+            .arg("scan")
+            .arg("--rule")
+            .arg(rule_file.path())
+            .arg(target)
+            .arg("--json")
+            .kill_on_drop(true)
+            .output();
+        let output = self
+            .within_parse_timeout(rule_config, target, command)
+            .await?;
+
+        if !output.status.success() {
+            let stderr = String::from_utf8_lossy(&output.stderr);
+            return Err(anyhow!("ast-grep failed: {}", stderr));
+        }
+
+        let mut matches: Vec<Value> = serde_json::from_slice(&output.stdout)
+            .map_err(|e| anyhow!("Failed to parse ast-grep JSON output: {}", e))?;
+        if !target.is_file() {
+            self.retain_editable_files(&mut matches);
+        }
+        Ok(matches)
+    }
+
+    /// Await an ast-grep run of `rule_config` over `target`, failing it
+    /// once the rule language's configured `parse_timeout` has passed, so a
+    /// grammar that hangs on some input fails the call instead of blocking
+    /// the server. A directory is held to the same limit as a single file:
+    /// ast-grep only parses the rule language's files under it. The run
+    /// must be killed on drop.
+    async fn within_parse_timeout(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        run: impl std::future::Future<Output = std::io::Result<std::process::Output>>,
+    ) -> Result<std::process::Output> {
+        let Ok(language) = self.get_rule_language(rule_config) else {
+            return Ok(run.await?);
+        };
+        let limit = self
+            .config
+            .lock()
+            .unwrap()
+            .parse_timeout
+            .for_language(&language);
+        let started = std::time::Instant::now();
+        // Dropping the timed-out run kills ast-grep
+        let output = tokio::time::timeout(limit, run).await.map_err(|_| {
+            anyhow!(
+                "Parse timed out after {:.1}s parsing {} as {} (limit {} ms; raise parse_timeout in splice-weaver.yaml for slow grammars)",
+                started.elapsed().as_secs_f64(),
+                target.display(),
+                language,
+                limit.as_millis()
+            )
+        })??;
+        Ok(output)
+    }
+
+    /// Run a rule against an in-memory snippet and return ast-grep's JSON matches.
+    async fn scan_code_json(
+        &self,
+        rule_config: &str,
+        code: &str,
+        language: &str,
+    ) -> Result<Vec<Value>> {
+        let code_file = tempfile::Builder::new()
+            .suffix(&format!(".{}", self.get_file_extension(language)?))
+            .tempfile()?;
+        tokio::fs::write(code_file.path(), code).await?;
+        self.scan_json(rule_config, code_file.path()).await
+    }
+
+    /// Source text for a tool call: inline `code` (decoded per
+    /// `contentEncoding`), or the contents of `target`.
+    async fn load_source(&self, args: &Value) -> Result<(String, Option<PathBuf>)> {
+        if let Some(code) = args["code"].as_str() {
+            return Ok((ContentEncoding::from_args(args)?.decode(code)?, None));
+        }
+        let target = args["target"]
+            .as_str()
+            .ok_or(anyhow!("Missing code or target"))?;
+        let resolved_target = self.resolve_path(target)?;
+        let source = self.read_source_file(&resolved_target, args).await?;
+        Ok((source, Some(resolved_target)))
+    }
+
+    /// Text of a file in the call's `fileEncoding`, without a UTF-8 byte
+    /// order mark.
+    async fn read_source_file(&self, path: &Path, args: &Value) -> Result<String> {
+        FileEncoding::from_args(args)?.decode(&tokio::fs::read(path).await?)
+    }
+
+    /// Write text read by `read_source_file` back in the call's
+    /// `fileEncoding`, keeping the byte order mark if the file had one.
+    /// Returns false, without touching the file or its mtime, when it
+    /// already holds exactly these bytes.
+    async fn write_source_file(
+        &self,
+        path: &Path,
+        contents: &str,
+        edits: &[TextEdit],
+        args: &Value,
+    ) -> Result<bool> {
+        let existing = tokio::fs::read(path).await.ok();
+        let bom = existing
+            .as_ref()
+            .is_some_and(|bytes| bytes.starts_with(UTF8_BOM));
+        let bytes = FileEncoding::from_args(args)?.encode(contents, bom)?;
+        if existing.as_deref() == Some(bytes.as_slice()) {
+            return Ok(false);
+        }
+        atomic_write::write_atomic_bytes(path, bytes).await?;
+        self.log_edits(path, edits);
+        Ok(true)
+    }
+
+    /// End a single-file edit: unless the call is a dry run or the source
+    /// came inline, check `edits` against the edit guards and write
+    /// `new_source` to `path`. Returns the tool's `result` with `applied`,
+    /// `status` "no changes" when the file already held `new_source`, and
+    /// the new `content` when it was not written.
+    async fn finish_edit(
+        &self,
+        mut result: Value,
+        path: Option<&Path>,
+        source: &str,
+        new_source: &str,
+        edits: &[TextEdit],
+        args: &Value,
+    ) -> Result<Value> {
+        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
+        let force = args["force"].as_bool().unwrap_or(false);
+        let applied = match path {
+            Some(path) if !dry_run => {
+                self.check_edits(&path.display().to_string(), source, edits, force)?;
+                self.write_source_file(path, new_source, edits, args)
+                    .await?
+            }
+            _ => false,
+        };
+        result["applied"] = applied.into();
+        result["status"] = (!applied && !dry_run && path.is_some())
+            .then_some("no changes")
+            .into();
+        result["content"] = match applied {
+            true => Value::Null,
+            false => ContentEncoding::from_args(args)?.encode(new_source).into(),
+        };
+        Ok(result)
+    }
+
+    /// Edits written during a session, oldest first: the calling session
+    /// unless `session_id` names another, optionally only those to `file`.
+    fn get_session_log(&self, args: Value) -> Result<String> {
+        let session = ToolCall::current()
+            .map(|call| call.session)
+            .unwrap_or_else(|| self.session_id.clone());
+        let file = args["file"].as_str();
+        let log = self.operation_log.lock().unwrap();
+        let entries: Vec<_> = log
+            .session(&session)
+            .into_iter()
+            .filter(|entry| file.map_or(true, |file| entry.file == file))
+            .collect();
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "session": session,
+            "entries": entries,
+            "sessions": log.sessions()
+        }))?)
+    }
+
+    /// What this server build offers: its version, the ast-grep binary it
+    /// runs, the languages it recognizes with their extensions, the
+    /// encodings and protections in effect, and `tools` (each tool's name
+    /// and options), so a client can adapt before calling anything.
+    pub async fn capabilities(&self, tools: &[(String, Vec<String>)]) -> Result<String> {
+        let binary_path = self.binary_manager.get_binary_path()?;
+        let installed_version = TokioCommand::new(&binary_path)
+            .arg("--version")
+            .output()
+            .await
+            .ok()
+            .filter(|output| output.status.success())
+            .map(|output| {
+                String::from_utf8_lossy(&output.stdout)
+                    .trim()
+                    .trim_start_matches("ast-grep")
+                    .trim()
+                    .to_string()
+            });
+        let aliases = |language: &str| match language {
+            "cpp" => vec!["c++"],
+            "csharp" => vec!["cs"],
+            _ => vec![],
+        };
+        let grammars = self.grammars.lock().unwrap();
+        // A custom grammar's own entry replaces one the table lists
+        let mut languages: Vec<Value> = LANGUAGE_EXTENSIONS
+            .iter()
+            .filter(|(language, _, _)| grammars.check(language).is_none())
+            .map(|(language, supported, extensions)| {
+                serde_json::json!({
+                    "name": language,
+                    "supported": supported,
+                    "extensions": extensions,
+                    "aliases": aliases(language),
+                })
+            })
+            .collect();
+        languages.extend(grammars.languages());
+        drop(grammars);
+        let config = self.config.lock().unwrap().clone();
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "version": env!("CARGO_PKG_VERSION"),
+            "ast_grep": {
+                "pinned_version": AST_GREP_VERSION,
+                "path": binary_path,
+                "available": installed_version.is_some(),
+                "version": installed_version,
+            },
+            "languages": languages,
+            "features": {
+                "offset_encodings": ["utf-8", "utf-16"],
+                "content_encodings": ["utf-8", "utf-16"],
+                "file_encodings": ["utf-8", "latin-1"],
+                "editable_languages": config.protection.editable_languages,
+                "build_tags": config.protection.build_tags,
+                "operation_log_file": config.operation_log.file,
+                "formatters": self.formatters.lock().unwrap().commands(),
+            },
+            "tools": tools
+                .iter()
+                .map(|(name, options)| serde_json::json!({"name": name, "options": options}))
+                .collect::<Vec<_>>(),
+        }))?)
+    }
+
+    /// Add a write of `edits` to `path` to the operation log of the current
+    /// call's session. The file is already written, so a log that cannot be
+    /// appended to is reported but does not fail the call.
+    fn log_edits(&self, path: &Path, edits: &[TextEdit]) {
+        let Some(call) = ToolCall::current() else {
+            return;
+        };
+        call.record_write(path, edits);
+        let mut log = self.operation_log.lock().unwrap();
+        if let Err(e) = log.record(&call, &path.display().to_string(), edits) {
+            warn!("Edit to {} was not logged: {}", path.display(), e);
+        }
+    }
+
+    /// `output` with `edited_nodes` added: for each edit `call` wrote, the
+    /// node now at its place in the reparsed file, with its kind, range and
+    /// text, so a caller can check an edit's result without reading it
+    /// back. That is the smallest node holding the replacement, and for a
+    /// deletion the node the deleted text was in. Files in languages
+    /// ast-grep cannot parse are left out, and output that is not a JSON
+    /// object is returned as it is.
+    async fn add_edited_nodes(
+        &self,
+        output: String,
+        call: &ToolCall,
+        args: &Value,
+    ) -> Result<String> {
+        let Ok(Value::Object(mut result)) = serde_json::from_str::<Value>(&output) else {
+            return Ok(output);
+        };
+        let offset_encoding = OffsetEncoding::from_args(args)?;
+        let mut edited = Vec::new();
+        let writes = call.writes();
+        for (i, (path, edits)) in writes.iter().enumerate() {
+            // Only the last write to a file has edits that fit its text now
+            if writes[i + 1..].iter().any(|(later, _)| later == path) {
+                continue;
+            }
+            let Some((language, true)) = path
+                .extension()
+                .and_then(|extension| extension.to_str())
+                .and_then(|extension| self.extension_language(extension))
+            else {
+                continue;
+            };
+            let source = self.read_source_file(path, args).await?;
+            let nodes = self
+                .scan_source_json(
+                    &self.node_tree_rule(&language),
+                    &source,
+                    Some(path),
+                    &language,
+                )
+                .await?;
+            let spans: Vec<(&Value, NodeSpan)> = nodes
+                .iter()
+                .filter_map(|m| Some((m, NodeSpan::from_match(m)?)))
+                .collect();
+            for (start, end) in edit_utils::edited_ranges(&source, edits) {
+                // Whitespace around the replacement is not part of its node
+                let text = &source[start..end];
+                let start = start + (text.len() - text.trim_start().len());
+                let end = start.max(end - (text.len() - text.trim_end().len()));
+                // Parents come first, so a node wins over a child of its size
+                let Some((m, span)) = spans
+                    .iter()
+                    .filter(|(_, span)| span.start <= start && end <= span.end)
+                    .min_by_key(|(_, span)| span.end - span.start)
+                else {
+                    continue;
+                };
+                edited.push(serde_json::json!({
+                    "file": path.display().to_string(),
+                    "kind": m["kind"],
+                    "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+                    "text": span.text,
+                }));
+            }
+        }
+        result.insert("edited_nodes".to_string(), edited.into());
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Run a rule over the source of a tool call, scanning the file directly
+    /// when one was given so reported offsets match it exactly. Files whose
+    /// bytes differ from their decoded text (a byte order mark, Latin-1) are
+    /// scanned as code instead, so offsets are into the decoded text.
+    async fn scan_source_json(
+        &self,
+        rule_config: &str,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+    ) -> Result<Vec<Value>> {
+        match path {
+            Some(path) if tokio::fs::read(path).await? == source.as_bytes() => {
+                self.scan_json(rule_config, path).await
+            }
+            _ => self.scan_code_json(rule_config, source, language).await,
+        }
+    }
+
+    /// Whether `target` is a file whose bytes differ from its text in the
+    /// call's `fileEncoding`, so ast-grep cannot scan it directly.
+    async fn is_decoded_file(&self, target: &Path, args: &Value) -> Result<bool> {
+        if !target.is_file() {
+            return Ok(false);
+        }
+        let source = self.read_source_file(target, args).await?;
+        Ok(tokio::fs::read(target).await? != source.as_bytes())
+    }
+
+    /// Run a rule over a file or directory with offsets into each file's
+    /// text as `read_source_file` returns it. A directory is scanned by
+    /// ast-grep first and the matched files whose bytes differ from their
+    /// decoded text are scanned again as code.
+    async fn scan_decoded_json(
+        &self,
+        rule_config: &str,
+        target: &Path,
+        args: &Value,
+    ) -> Result<Vec<Value>> {
+        let (mut matches, files) = match target.is_file() {
+            true => (Vec::new(), vec![target.to_path_buf()]),
+            false => {
+                let matches = self.scan_json(rule_config, target).await?;
+                let files: std::collections::BTreeSet<PathBuf> = matches
+                    .iter()
+                    .filter_map(|m| m["file"].as_str().map(PathBuf::from))
+                    .collect();
+                (matches, files.into_iter().collect())
+            }
+        };
+        for file in files {
+            if !target.is_file() && !self.is_decoded_file(&file, args).await? {
+                continue;
+            }
+            let source = self.read_source_file(&file, args).await?;
+            let language = self.get_rule_language(rule_config)?;
+            let name = file.to_string_lossy().to_string();
+            matches.retain(|m| m["file"].as_str() != Some(name.as_str()));
+            for mut m in self
+                .scan_source_json(rule_config, &source, Some(&file), &language)
+                .await?
+            {
+                m["file"] = name.clone().into();
+                matches.push(m);
+            }
+        }
+        Ok(matches)
+    }
+
+    /// Byte range a tool call refers to: `start_byte`/`end_byte`, or a
+    /// 1-indexed `position`, both counted in the call's `offsetEncoding`.
+    fn get_target_range(&self, args: &Value, source: &str) -> Result<(usize, usize)> {
+        let encoding = OffsetEncoding::from_args(args)?;
+        if let Some(start) = args["start_byte"].as_u64() {
+            let start = start as usize;
+            let end = args["end_byte"]
+                .as_u64()
+                .map(|e| e as usize)
+                .unwrap_or(start);
+            let source_len = encoding.from_byte_offset(source, source.len());
+            if start > end || end > source_len {
+                return Err(anyhow!(
+                    "Range {}..{} is outside the source ({} {})",
+                    start,
+                    end,
+                    source_len,
+                    match encoding {
+                        OffsetEncoding::Utf8 => "bytes",
+                        OffsetEncoding::Utf16 => "UTF-16 code units",
+                    }
+                ));
+            }
+            return Ok((
+                encoding.to_byte_offset(source, start)?,
+                encoding.to_byte_offset(source, end)?,
+            ));
+        }
+        let position: Position = serde_json::from_value(args["position"].clone())
+            .map_err(|_| anyhow!("Missing position or start_byte"))?;
+        let offset = encoding
+            .position_to_byte_offset(source, position.line as usize, position.column as usize)?
+            .ok_or_else(|| anyhow!("Line {} is past the end of the source", position.line))?;
+        Ok((offset, offset))
+    }
+
+    /// Node kinds that count as a function, method, or closure in `language`.
+    fn get_function_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "javascript" | "typescript" => Ok(&[
+                "function_declaration",
+                "function_expression",
+                "generator_function_declaration",
+                "generator_function",
+                "arrow_function",
+                "method_definition",
+            ]),
+            "rust" => Ok(&["function_item", "closure_expression"]),
+            "python" => Ok(&["function_definition", "lambda"]),
+            "go" => Ok(&["function_declaration", "method_declaration", "func_literal"]),
+            "java" => Ok(&[
+                "method_declaration",
+                "constructor_declaration",
+                "lambda_expression",
+            ]),
+            "cpp" | "c++" => Ok(&["function_definition", "lambda_expression"]),
+            "c" => Ok(&["function_definition"]),
+            "csharp" | "cs" => Ok(&[
+                "method_declaration",
+                "constructor_declaration",
+                "local_function_statement",
+                "lambda_expression",
+                "anonymous_method_expression",
+            ]),
+            // Trailing closures are ordinary lambda_literal nodes
+            "swift" => Ok(&[
+                "function_declaration",
+                "init_declaration",
+                "deinit_declaration",
+                "lambda_literal",
+            ]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Node kinds of the statements whose blocks scope the names declared
+    /// in them. Python's blocks do not, so it has none.
+    fn get_block_scope_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "go" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "expression_switch_statement",
+                "type_switch_statement",
+                "select_statement",
+            ]),
+            "rust" => Ok(&[
+                "if_expression",
+                "for_expression",
+                "while_expression",
+                "loop_expression",
+                "match_expression",
+            ]),
+            "python" => Ok(&[]),
+            "javascript" | "typescript" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "for_in_statement",
+                "while_statement",
+                "do_statement",
+                "switch_statement",
+                "try_statement",
+                "catch_clause",
+            ]),
+            "java" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "enhanced_for_statement",
+                "while_statement",
+                "do_statement",
+                "switch_expression",
+                "try_statement",
+                "catch_clause",
+            ]),
+            "c" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "while_statement",
+                "do_statement",
+                "switch_statement",
+            ]),
+            "cpp" | "c++" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "for_range_loop",
+                "while_statement",
+                "do_statement",
+                "switch_statement",
+                "try_statement",
+                "catch_clause",
+            ]),
+            "csharp" | "cs" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "foreach_statement",
+                "while_statement",
+                "do_statement",
+                "switch_statement",
+                "try_statement",
+                "catch_clause",
+                "using_statement",
+            ]),
+            "swift" => Ok(&[
+                "if_statement",
+                "for_statement",
+                "while_statement",
+                "repeat_while_statement",
+                "switch_statement",
+                "do_statement",
+            ]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Rule matching every function-like node, capturing `$NAME` when the
+    /// node has a `NAME` field.
+    fn build_function_rule(&self, language: &str) -> Result<String> {
+        let name_field = self.field_name(language, "NAME")?;
+        let kinds = self
+            .get_function_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        Ok(format!(
+            "id: enclosing-function\nlanguage: {language}\nrule:\n  any:\n    - all:\n        - any: [{kinds}]\n        - has: {{ field: {name_field}, pattern: $NAME }}\n    - any: [{kinds}]\n"
+        ))
+    }
+
+    /// Every function in `source`, with its name when it has one.
+    async fn scan_functions(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+    ) -> Result<Vec<(Option<String>, NodeSpan)>> {
+        let function_rule = self.build_function_rule(language)?;
+        Ok(self
+            .scan_source_json(&function_rule, source, path, language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let name = m["metaVariables"]["single"]["NAME"]["text"]
+                    .as_str()
+                    .map(|name| name.to_string());
+                NodeSpan::from_match(m).map(|span| (name, span))
+            })
+            .collect())
+    }
+
+    /// The function a tool call targets: the one called `name`, or the
+    /// innermost one containing `position`/`start_byte`.
+    fn select_function<'a>(
+        &self,
+        args: &Value,
+        source: &str,
+        functions: &'a [(Option<String>, NodeSpan)],
+    ) -> Result<&'a (Option<String>, NodeSpan)> {
+        match args["name"].as_str() {
+            Some(name) => {
+                let named: Vec<&(Option<String>, NodeSpan)> = functions
+                    .iter()
+                    .filter(|(function_name, _)| function_name.as_deref() == Some(name))
+                    .collect();
+                match named.as_slice() {
+                    [only] => Ok(*only),
+                    [] => Err(anyhow!("No function named '{}' found", name)),
+                    _ => {
+                        let lines: Vec<String> = named
+                            .iter()
+                            .map(|(_, span)| {
+                                edit_utils::line_number(source, span.start).to_string()
+                            })
+                            .collect();
+                        Err(anyhow!(
+                            "'{}' is defined on lines {}; pass position to choose one",
+                            name,
+                            lines.join(", ")
+                        ))
+                    }
+                }
+            }
+            None => {
+                let (start, end) = self.get_target_range(args, source)?;
+                functions
+                    .iter()
+                    .filter(|(_, span)| span.start <= start && end <= span.end)
+                    .min_by_key(|(_, span)| span.end - span.start)
+                    .ok_or_else(|| anyhow!("No function contains the position"))
+            }
+        }
+    }
+
+    async fn get_enclosing_function(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let rule_config = self.build_function_rule(language)?;
+        let matches = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+
+        // Innermost function whose range covers the target range
+        let enclosing = matches
+            .iter()
+            .filter_map(|m| NodeSpan::from_match(m).map(|span| (m, span)))
+            .filter(|(_, span)| span.start <= start && end <= span.end)
+            .min_by_key(|(_, span)| span.end - span.start);
+
+        let function = enclosing.map(|(m, span)| {
+            let name = m["metaVariables"]["single"]["NAME"]["text"]
+                .as_str()
+                .map(|name| name.to_string())
+                .or_else(|| edit_utils::guess_function_name(&span.text));
+            serde_json::json!({
+                "name": name,
+                "signature": span.text.lines().next().unwrap_or("").trim(),
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+            })
+        });
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "function": function
+        }))?)
+    }
+
+    /// ast-grep `range` rule matching nodes spanning exactly `start..end`.
+    /// Its lines are zero-based and its columns count characters.
+    fn range_rule(source: &str, start: usize, end: usize) -> String {
+        let position = |offset: usize| {
+            let line_start = edit_utils::line_start(source, offset);
+            format!(
+                "{{ line: {}, column: {} }}",
+                edit_utils::line_number(source, offset) - 1,
+                source[line_start..offset].chars().count()
+            )
+        };
+        format!("{{ start: {}, end: {} }}", position(start), position(end))
+    }
+
+    /// The exact source between two sibling nodes, such as the blank lines
+    /// and comments separating two functions. `first` and `second` give
+    /// each node's range; they may come in either order, but must not
+    /// overlap and must share a parent.
+    async fn text_between(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let mut ranges = Vec::new();
+        for key in ["first", "second"] {
+            let mut node = args[key].clone();
+            if !node.is_object() || node["end_byte"].is_null() {
+                return Err(anyhow!("Missing {}: give its start_byte and end_byte", key));
+            }
+            node["offsetEncoding"] = args["offsetEncoding"].clone();
+            ranges.push(self.get_target_range(&node, &source)?);
+        }
+        ranges.sort();
+        let ((first_start, first_end), (second_start, second_end)) = (ranges[0], ranges[1]);
+        if second_start < first_end {
+            return Err(anyhow!(
+                "The nodes overlap: {}..{} and {}..{}",
+                first_start,
+                first_end,
+                second_start,
+                second_end
+            ));
+        }
+
+        let first_rule = Self::range_rule(&source, first_start, first_end);
+        let second_rule = Self::range_rule(&source, second_start, second_end);
+        for (rule, (start, end)) in [
+            (&first_rule, (first_start, first_end)),
+            (&second_rule, (second_start, second_end)),
+        ] {
+            let rule_config =
+                format!("id: text-between-node\nlanguage: {language}\nrule:\n  range: {rule}\n");
+            let matches = self
+                .scan_source_json(&rule_config, &source, path.as_deref(), language)
+                .await?;
+            if matches.is_empty() {
+                return Err(anyhow!(
+                    "No node spans exactly {}..{}: {:?}",
+                    start,
+                    end,
+                    &source[start..end]
+                ));
+            }
+        }
+        let rule_config = format!(
+            "id: text-between\nlanguage: {language}\nrule:\n  range: {first_rule}\n  precedes:\n    range: {second_rule}\n"
+        );
+        let siblings = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+        if siblings.is_empty() {
+            return Err(anyhow!(
+                "The nodes at {}..{} and {}..{} are not siblings",
+                first_start,
+                first_end,
+                second_start,
+                second_end
+            ));
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "start_byte": offset_encoding.from_byte_offset(&source, first_end),
+            "end_byte": offset_encoding.from_byte_offset(&source, second_start),
+            "start_line": edit_utils::line_number(&source, first_end),
+            "end_line": edit_utils::line_number(&source, second_start),
+            "text": &source[first_end..second_start],
+        }))?)
+    }
+
+    /// Rule matching every named node of `language` but the root, from
+    /// which `NodeTree` rebuilds the tree.
+    fn node_tree_rule(&self, language: &str) -> String {
+        format!(
+            "id: node-tree\nlanguage: {language}\nrule:\n  {}",
+            node_tree::NODE_RULE
+        )
+    }
+
+    /// Dump a file's syntax tree as compact JSON. `includeTypes` and
+    /// `excludeTypes` prune nodes by kind, keeping the ancestors of those
+    /// shown. With `maxTotalBytes` the tree is expanded breadth-first until
+    /// the budget is spent, so the top-level structure comes first.
+    async fn dump_tree(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let kinds = |name: &str| -> Option<Vec<String>> {
+            args[name].as_array().map(|kinds| {
+                kinds
+                    .iter()
+                    .filter_map(|kind| kind.as_str().map(str::to_string))
+                    .collect()
+            })
+        };
+        let include_types = kinds("includeTypes");
+        let exclude_types = kinds("excludeTypes").unwrap_or_default();
+        let max_total_bytes = args["maxTotalBytes"].as_u64().map(|bytes| bytes as usize);
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let nodes = self
+            .scan_source_json(
+                &self.node_tree_rule(language),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let (nodes, truncated) = NodeTree::from_matches(nodes).dump(max_total_bytes, |kind| {
+            include_types
+                .as_ref()
+                .map_or(true, |include| include.iter().any(|k| k == kind))
+                && !exclude_types.iter().any(|k| k == kind)
+        });
+
+        // Compact, since a tree dump is mostly nesting and indentation
+        // would take much of a budget
+        Ok(serde_json::to_string(&serde_json::json!({
+            "target": path.map(|path| path.display().to_string()),
+            "language": language,
+            "nodes": nodes,
+            "truncated": truncated,
+        }))?)
+    }
+
+    /// Source text of the innermost function (or node of `kind`) covering a
+    /// position. With `normalizeNewlines`, CRLF endings come back as LF so
+    /// the text can be inserted elsewhere; edits convert it back to the
+    /// target file's ending, so the round trip is lossless.
+    async fn get_node_text(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        self.validate_language(language)?;
+        if args["selectors"].is_array()
+            || args["allowMultiple"].as_bool() == Some(true)
+            || args["name"].is_string()
+        {
+            return self.get_node_texts(&args, language).await;
+        }
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        if let Some(token) = args["kind"]
+            .as_str()
+            .and_then(|kind| kind.strip_prefix('"')?.strip_suffix('"'))
+            .filter(|token| !token.is_empty())
+        {
+            return self
+                .get_token_text(&args, language, token, &source, path.as_deref())
+                .await;
+        }
+
+        let kinds: Vec<&str> = match args["kind"].as_str() {
+            Some(kind) => vec![kind],
+            None => self.get_function_kinds(language)?.to_vec(),
+        };
+        let rule_config = format!(
+            "id: node-text\nlanguage: {language}\nrule:\n  any: [{}]\n",
+            kinds
+                .iter()
+                .map(|kind| format!("{{ kind: {kind} }}"))
+                .collect::<Vec<_>>()
+                .join(", ")
+        );
+        let matches = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+        let (node, span) = matches
+            .iter()
+            .filter_map(|m| NodeSpan::from_match(m).map(|span| (m, span)))
+            .filter(|(_, span)| span.start <= start && end <= span.end)
+            .min_by_key(|(_, span)| span.end - span.start)
+            .ok_or_else(|| anyhow!("No {} covers the position", kinds.join(" or ")))?;
+
+        let mut result = self.node_text_json(&args, language, &source, node, &span)?;
+        result["line_ending"] = if edit_utils::uses_crlf(&source) {
+            "crlf"
+        } else {
+            "lf"
+        }
+        .into();
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// What `get_node_text` reports for one node: its kind, its text with
+    /// newlines normalized and truncated as the call asks, and its range.
+    fn node_text_json(
+        &self,
+        args: &Value,
+        language: &str,
+        source: &str,
+        node: &Value,
+        span: &NodeSpan,
+    ) -> Result<Value> {
+        let normalize_newlines = args["normalizeNewlines"].as_bool().unwrap_or(false);
+        let max_bytes = args["maxBytes"].as_u64().map(|max| max as usize);
+        let offset_encoding = OffsetEncoding::from_args(args)?;
+        // Slice the source rather than trusting the match text to keep `\r`
+        let text = &source[span.start..span.end];
+        let mut text = match normalize_newlines {
+            true => text.replace("\r\n", "\n"),
+            false => text.to_string(),
+        };
+        let mut omitted_bytes = 0;
+        if let Some(max_bytes) = max_bytes {
+            let marker = |omitted: usize| self.truncation_marker(args, language, omitted);
+            if let Some((truncated, omitted)) = edit_utils::truncate_text(&text, max_bytes, marker)
+            {
+                text = truncated;
+                omitted_bytes = omitted;
+            }
+        }
+        Ok(serde_json::json!({
+            "kind": node["kind"],
+            "text": text,
+            "range": text_encoding::encode_range(source, &node["range"], offset_encoding),
+            "truncated": omitted_bytes > 0,
+            "omitted_bytes": omitted_bytes,
+        }))
+    }
+
+    /// `get_node_text` for several selectors at once, from one scan of the
+    /// source. A selector takes a `position`, `start_byte`/`end_byte` or a
+    /// function `name`, and an optional `kind`; with `allowMultiple` it
+    /// yields every node it matches instead of one. Results follow the
+    /// selectors' order, each with the `index` of its selector, and a
+    /// selector that matches nothing gets an `error` without failing the
+    /// rest.
+    async fn get_node_texts(&self, args: &Value, language: &str) -> Result<String> {
+        let selectors: Vec<Value> = match args["selectors"].as_array() {
+            Some(selectors) => selectors.clone(),
+            None => vec![args.clone()],
+        };
+        if selectors.is_empty() {
+            return Err(anyhow!("selectors is empty"));
+        }
+        let (source, path) = self.load_source(args).await?;
+
+        // One rule covering every selector's kinds, capturing function
+        // names if any selector asks for one by name
+        let function_kinds = self.get_function_kinds(language)?;
+        let named = selectors
+            .iter()
+            .any(|selector| selector["name"].is_string());
+        let mut kinds: Vec<&str> = Vec::new();
+        for selector in &selectors {
+            match selector["kind"].as_str() {
+                Some(kind) if kind.starts_with('"') => {}
+                Some(kind) => kinds.push(kind),
+                None => kinds.extend(function_kinds),
+            }
+        }
+        if named {
+            kinds.extend(function_kinds);
+        }
+        kinds.sort();
+        kinds.dedup();
+        let any = |kinds: &[&str]| {
+            kinds
+                .iter()
+                .map(|kind| format!("{{ kind: {kind} }}"))
+                .collect::<Vec<_>>()
+                .join(", ")
+        };
+        let rule_config = match named {
+            true => format!(
+                "id: node-texts\nlanguage: {language}\nrule:\n  any:\n    - all:\n        - any: [{}]\n        - has: {{ field: {}, pattern: $NAME }}\n    - any: [{}]\n",
+                any(function_kinds),
+                self.field_name(language, "NAME")?,
+                any(&kinds)
+            ),
+            false => format!(
+                "id: node-texts\nlanguage: {language}\nrule:\n  any: [{}]\n",
+                any(&kinds)
+            ),
+        };
+        let matches = match kinds.is_empty() {
+            true => Vec::new(),
+            false => {
+                self.scan_source_json(&rule_config, &source, path.as_deref(), language)
+                    .await?
+            }
+        };
+        let nodes: Vec<(&Value, NodeSpan)> = matches
+            .iter()
+            .filter_map(|m| NodeSpan::from_match(m).map(|span| (m, span)))
+            .collect();
+
+        let mut results = Vec::new();
+        for (index, selector) in selectors.iter().enumerate() {
+            // Selectors share the call's offset encoding
+            let mut selector = selector.clone();
+            if selector["offsetEncoding"].is_null() && !args["offsetEncoding"].is_null() {
+                selector["offsetEncoding"] = args["offsetEncoding"].clone();
+            }
+            match self.select_nodes(&selector, &source, &nodes, function_kinds) {
+                Ok(found) => {
+                    for (node, span) in found {
+                        let mut result =
+                            self.node_text_json(args, language, &source, node, span)?;
+                        result["index"] = index.into();
+                        results.push(result);
+                    }
+                }
+                Err(e) => results.push(serde_json::json!({
+                    "index": index,
+                    "error": e.to_string(),
+                })),
+            }
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "results": results,
+            "line_ending": if edit_utils::uses_crlf(&source) { "crlf" } else { "lf" },
+        }))?)
+    }
+
+    /// The nodes one `get_node_texts` selector picks out of `nodes`:
+    /// innermost first for a position, in document order otherwise.
+    fn select_nodes<'a>(
+        &self,
+        selector: &Value,
+        source: &str,
+        nodes: &'a [(&'a Value, NodeSpan)],
+        function_kinds: &[&str],
+    ) -> Result<Vec<(&'a Value, &'a NodeSpan)>> {
+        let allow_multiple = selector["allowMultiple"].as_bool().unwrap_or(false);
+        let kinds: Vec<&str> = match selector["kind"].as_str() {
+            Some(kind) if kind.starts_with('"') => {
+                return Err(anyhow!(
+                    "Token kinds such as {} are read one at a time with get_node_text",
+                    kind
+                ))
+            }
+            Some(kind) => vec![kind],
+            None => function_kinds.to_vec(),
+        };
+        let mut found: Vec<(&Value, &NodeSpan)> = nodes
+            .iter()
+            .filter(|(node, _)| kinds.contains(&node["kind"].as_str().unwrap_or_default()))
+            .map(|(node, span)| (*node, span))
+            .collect();
+        let positioned = !selector["position"].is_null() || !selector["start_byte"].is_null();
+        if let Some(name) = selector["name"].as_str() {
+            found.retain(|(node, _)| node["metaVariables"]["single"]["NAME"]["text"] == name);
+            if found.is_empty() {
+                return Err(anyhow!("No function named '{}' found", name));
+            }
+        } else if !positioned && !allow_multiple {
+            return Err(anyhow!(
+                "A selector needs a position, start_byte or name, or allowMultiple to return every {}",
+                kinds.join(" or ")
+            ));
+        }
+        if positioned {
+            let (start, end) = self.get_target_range(selector, source)?;
+            found.retain(|(_, span)| span.start <= start && end <= span.end);
+            found.sort_by_key(|(_, span)| span.end - span.start);
+            if found.is_empty() {
+                return Err(anyhow!("No {} covers the position", kinds.join(" or ")));
+            }
+        }
+        if found.len() > 1 && !allow_multiple {
+            if let (Some(name), false) = (selector["name"].as_str(), positioned) {
+                let lines: Vec<String> = found
+                    .iter()
+                    .map(|(_, span)| edit_utils::line_number(source, span.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "'{}' is defined on lines {}; pass position to choose one, or allowMultiple",
+                    name,
+                    lines.join(", ")
+                ));
+            }
+            found.truncate(1);
+        }
+        Ok(found)
+    }
+
+    /// `get_node_text` for a literal token such as `":="` or `"return"`:
+    /// the occurrence of `token` covering the call's position, outside of
+    /// comments and string literals. Tokens are anonymous nodes, which
+    /// ast-grep rules cannot select by kind, so they are matched by text.
+    async fn get_token_text(
+        &self,
+        args: &Value,
+        language: &str,
+        token: &str,
+        source: &str,
+        path: Option<&Path>,
+    ) -> Result<String> {
+        let (start, end) = self.get_target_range(args, source)?;
+        let offset_encoding = OffsetEncoding::from_args(args)?;
+        let literal_kinds: Vec<String> = self
+            .get_comment_kinds(language)
+            .unwrap_or(&[])
+            .iter()
+            .chain(self.get_string_literal_kinds(language).unwrap_or(&[]))
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect();
+        let literals: Vec<NodeSpan> = match literal_kinds.is_empty() {
+            true => Vec::new(),
+            false => {
+                let rule_config = format!(
+                    "id: token-literals\nlanguage: {language}\nrule:\n  any: [{}]\n",
+                    literal_kinds.join(", ")
+                );
+                self.scan_source_json(&rule_config, source, path, language)
+                    .await?
+                    .iter()
+                    .filter_map(NodeSpan::from_match)
+                    .collect()
+            }
+        };
+        let at = edit_utils::token_occurrences(source, token)
+            .into_iter()
+            .filter(|&at| at <= start && end <= at + token.len())
+            .find(|&at| {
+                !literals
+                    .iter()
+                    .any(|literal| literal.start <= at && at < literal.end)
+            })
+            .ok_or_else(|| anyhow!("No {} token covers the position", token))?;
+        let range = text_encoding::byte_range(source, at, at + token.len());
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "kind": format!("\"{token}\""),
+            "text": token,
+            "range": text_encoding::encode_range(source, &range, offset_encoding),
+            "line_ending": if edit_utils::uses_crlf(source) { "crlf" } else { "lf" },
+            "truncated": false,
+            "omitted_bytes": 0,
+        }))?)
+    }
+
+    /// Marker standing in for `omitted` bytes of truncated text: the
+    /// `truncationMarker` argument with `$OMITTED` filled in, or else a
+    /// comment in `language`'s style.
+    fn truncation_marker(&self, args: &Value, language: &str, omitted: usize) -> String {
+        match args["truncationMarker"].as_str() {
+            Some(marker) => marker.replace("$OMITTED", &omitted.to_string()),
+            None => match self.get_line_comment_prefix(language) {
+                Ok("#") => format!("# ...{omitted} bytes omitted..."),
+                _ => format!("/* ...{omitted} bytes omitted... */"),
+            },
+        }
+    }
+
+    /// Declarations listed by `file_outline` in `language`: node kind, the
+    /// symbol kind reported for it, and the field concept holding its name.
+    /// Functions directly inside a type are reported as methods.
+    fn get_outline_kinds(
+        &self,
+        language: &str,
+    ) -> Result<&'static [(&'static str, &'static str, &'static str)]> {
+        match language {
+            "javascript" => Ok(&[
+                ("function_declaration", "function", "NAME"),
+                ("generator_function_declaration", "function", "NAME"),
+                ("class_declaration", "type", "NAME"),
+                ("method_definition", "function", "NAME"),
+                ("variable_declarator", "variable", "NAME"),
+            ]),
+            "typescript" => Ok(&[
+                ("function_declaration", "function", "NAME"),
+                ("generator_function_declaration", "function", "NAME"),
+                ("class_declaration", "type", "NAME"),
+                ("abstract_class_declaration", "type", "NAME"),
+                ("interface_declaration", "type", "NAME"),
+                ("type_alias_declaration", "type", "NAME"),
+                ("enum_declaration", "type", "NAME"),
+                ("method_definition", "function", "NAME"),
+                ("public_field_definition", "variable", "NAME"),
+                ("variable_declarator", "variable", "NAME"),
+            ]),
+            "rust" => Ok(&[
+                ("function_item", "function", "NAME"),
+                ("function_signature_item", "function", "NAME"),
+                ("struct_item", "type", "NAME"),
+                ("enum_item", "type", "NAME"),
+                ("union_item", "type", "NAME"),
+                ("trait_item", "type", "NAME"),
+                ("type_item", "type", "NAME"),
+                ("impl_item", "impl", "TYPE_NAME"),
+                ("mod_item", "module", "NAME"),
+                ("const_item", "variable", "NAME"),
+                ("static_item", "variable", "NAME"),
+            ]),
+            "python" => Ok(&[
+                ("function_definition", "function", "NAME"),
+                ("class_definition", "type", "NAME"),
+                ("assignment", "variable", "TARGET"),
+            ]),
+            "go" => Ok(&[
+                ("function_declaration", "function", "NAME"),
+                ("method_declaration", "method", "NAME"),
+                ("type_spec", "type", "NAME"),
+                ("var_spec", "variable", "NAME"),
+                ("const_spec", "variable", "NAME"),
+            ]),
+            "java" => Ok(&[
+                ("class_declaration", "type", "NAME"),
+                ("interface_declaration", "type", "NAME"),
+                ("enum_declaration", "type", "NAME"),
+                ("record_declaration", "type", "NAME"),
+                ("method_declaration", "function", "NAME"),
+                ("constructor_declaration", "function", "NAME"),
+                ("variable_declarator", "variable", "NAME"),
+            ]),
+            "csharp" | "cs" => Ok(&[
+                ("namespace_declaration", "module", "NAME"),
+                ("class_declaration", "type", "NAME"),
+                ("struct_declaration", "type", "NAME"),
+                ("interface_declaration", "type", "NAME"),
+                ("record_declaration", "type", "NAME"),
+                ("enum_declaration", "type", "NAME"),
+                ("method_declaration", "function", "NAME"),
+                ("constructor_declaration", "function", "NAME"),
+                ("property_declaration", "variable", "NAME"),
+                ("variable_declarator", "variable", "NAME"),
+            ]),
+            // class_declaration also covers structs, enums, actors, and extensions
+            "swift" => Ok(&[
+                ("class_declaration", "type", "NAME"),
+                ("protocol_declaration", "type", "NAME"),
+                ("function_declaration", "function", "NAME"),
+                ("property_declaration", "variable", "NAME"),
+            ]),
+            _ => Err(anyhow!(
+                "file_outline does not support '{}'. Supported languages: javascript, typescript, rust, python, go, java, csharp, swift",
+                language
+            )),
+        }
+    }
+
+    /// Rule matching the declarations `file_outline` lists, capturing
+    /// `$NAME` and, for Go methods, `$RECEIVER`.
+    fn build_outline_rule(&self, language: &str) -> Result<String> {
+        let receiver_field = self.field_name(language, "RECEIVER")?;
+        let mut alternatives = Vec::new();
+        for (kind, symbol, concept) in self.get_outline_kinds(language)? {
+            let name_field = self.field_name(language, concept)?;
+            // Go methods name their receiver; elsewhere it is the parent type
+            let receiver = match *symbol {
+                "method" => {
+                    format!("\n        - has: {{ field: {receiver_field}, pattern: $RECEIVER }}")
+                }
+                _ => String::new(),
+            };
+            alternatives.push(format!(
+                "    - all:\n        - kind: {kind}\n        - has: {{ field: {name_field}, pattern: $NAME }}{receiver}\n"
+            ));
+        }
+        Ok(format!(
+            "id: file-outline\nlanguage: {language}\nrule:\n  any:\n{}",
+            alternatives.concat()
+        ))
+    }
+
+    /// List a file's declarations in document order. Only top-level ones by
+    /// default; `depth` also includes declarations nested that many levels
+    /// inside others. Variables local to a function are never listed.
+    async fn file_outline(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let max_depth = args["depth"].as_u64().unwrap_or(0) as usize;
+        let group_methods = args["group_methods"].as_bool().unwrap_or(false);
+        self.validate_language(language)?;
+        let outline_kinds = self.get_outline_kinds(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let rule_config = self.build_outline_rule(language)?;
+        let matches = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+
+        let mut declarations: Vec<(&Value, NodeSpan, &str)> = matches
+            .iter()
+            .filter_map(|m| {
+                let span = NodeSpan::from_match(m)?;
+                let kind = m["kind"].as_str()?;
+                let (_, symbol, _) = outline_kinds.iter().find(|(node, _, _)| *node == kind)?;
+                Some((m, span, *symbol))
+            })
+            .collect();
+        // Document order, outer declarations before inner ones starting with them
+        declarations.sort_by_key(|(_, span, _)| (span.start, std::cmp::Reverse(span.end)));
+
+        let name = |m: &Value| {
+            m["metaVariables"]["single"]["NAME"]["text"]
+                .as_str()
+                .map(str::to_string)
+        };
+        let mut symbols = Vec::new();
+        // Declarations containing the current one, outermost first
+        let mut ancestors: Vec<(&NodeSpan, &str, &Value)> = Vec::new();
+        for (m, span, symbol) in &declarations {
+            while ancestors
+                .last()
+                .is_some_and(|(parent, _, _)| span.end > parent.end)
+            {
+                ancestors.pop();
+            }
+            let (symbol, receiver) = match (ancestors.last(), *symbol) {
+                (_, "method") => (
+                    "method",
+                    m["metaVariables"]["single"]["RECEIVER"]["text"]
+                        .as_str()
+                        .map(edit_utils::receiver_type),
+                ),
+                (Some((_, "type" | "impl", parent)), "function") => ("method", name(parent)),
+                (Some((_, "function" | "method", _)), "variable") => continue,
+                (_, symbol) => (symbol, None),
+            };
+            let depth = ancestors.len();
+            ancestors.push((span, symbol, *m));
+            if depth > max_depth {
+                continue;
+            }
+            symbols.push(serde_json::json!({
+                "name": name(m),
+                "kind": symbol,
+                "receiver": receiver,
+                "depth": depth,
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+            }));
+        }
+
+        if group_methods {
+            symbols = Self::group_methods_by_receiver(symbols);
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "language": language,
+            "symbols": symbols
+        }))?)
+    }
+
+    /// Bracketed literals that `file_skeleton` folds when they are long:
+    /// arrays, maps, and struct or object literals in `language`.
+    fn get_skeleton_block_kinds(&self, language: &str) -> &'static [&'static str] {
+        match language {
+            "javascript" | "typescript" => &["array", "object"],
+            "python" => &["list", "tuple", "set", "dictionary"],
+            "go" => &["literal_value"],
+            "rust" => &["array_expression", "field_initializer_list"],
+            "java" => &["array_initializer"],
+            "csharp" | "cs" => &["initializer_expression"],
+            "c" | "cpp" | "c++" => &["initializer_list"],
+            "swift" => &["array_literal", "dictionary_literal"],
+            _ => &[],
+        }
+    }
+
+    /// The file with each function body longer than `maxBodyLines` lines
+    /// replaced by `{ ... }` (`...` in Python), and each literal longer
+    /// than `maxBlockLines` folded to its brackets, so signatures, types,
+    /// imports and comments outside bodies fit in far less text.
+    async fn file_skeleton(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let max_body_lines = args["maxBodyLines"].as_u64().unwrap_or(1) as usize;
+        let max_block_lines = args["maxBlockLines"].as_u64().unwrap_or(20) as usize;
+        self.validate_language(language)?;
+        let function_kinds = self.get_function_kinds(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let lines = |span: &NodeSpan| {
+            edit_utils::line_number(&source, span.end)
+                - edit_utils::line_number(&source, span.start)
+                + 1
+        };
+
+        let kinds = function_kinds
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rule_config = format!(
+            "id: skeleton-bodies\nlanguage: {language}\nrule:\n  any: [{kinds}]\n  has: {{ field: body, pattern: $BODY }}\n"
+        );
+        // Expression bodies of lambdas are left as they are
+        let mut folds: Vec<(NodeSpan, &str)> = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let body = NodeSpan::from_match(&m["metaVariables"]["single"]["BODY"])?;
+                let python_block = language == "python" && m["kind"] == "function_definition";
+                let braced = body.text.starts_with('{') && body.text.ends_with('}');
+                (python_block || braced).then_some((body, "function"))
+            })
+            .filter(|(body, _)| lines(body) > max_body_lines)
+            .collect();
+        let block_kinds = self.get_skeleton_block_kinds(language);
+        if !block_kinds.is_empty() {
+            let rule_config = format!(
+                "id: skeleton-blocks\nlanguage: {language}\nrule:\n  any: [{}]\n",
+                block_kinds
+                    .iter()
+                    .map(|kind| format!("{{ kind: {kind} }}"))
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            );
+            folds.extend(
+                self.scan_source_json(&rule_config, &source, path.as_deref(), language)
+                    .await?
+                    .iter()
+                    .filter_map(NodeSpan::from_match)
+                    .filter(|block| lines(block) > max_block_lines)
+                    .map(|block| (block, "block")),
+            );
+        }
+        // Outermost first, dropping folds inside one already made
+        folds.sort_by_key(|(span, _)| (span.start, std::cmp::Reverse(span.end)));
+        let mut edits = Vec::new();
+        let mut folded = Vec::new();
+        let mut folded_to = 0;
+        for (span, fold) in folds {
+            if span.start < folded_to {
+                continue;
+            }
+            folded_to = span.end;
+            let replacement = match span.text.chars().next() {
+                Some(open @ ('{' | '[' | '(')) => {
+                    let close = span.text.chars().last().unwrap_or(open);
+                    format!("{open} ... {close}")
+                }
+                _ => "...".to_string(),
+            };
+            folded.push(serde_json::json!({
+                "kind": fold,
+                "line": edit_utils::line_number(&source, span.start),
+                "lines": lines(&span),
+            }));
+            edits.push(TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement,
+            });
+        }
+        let skeleton = edit_utils::apply_edits(&source, &edits)?;
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "language": language,
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "lines": source.lines().count(),
+            "skeleton_lines": skeleton.lines().count(),
+            "folded": folded,
+            "content": ContentEncoding::from_args(&args)?.encode(&skeleton),
+        }))?)
+    }
+
+    /// Move top-level methods (Go's) into a `methods` list on the type
+    /// declared in the same outline that their receiver names, the way IDEs
+    /// show a type. Pointer and value receivers name the same type, and
+    /// generic receivers are matched without their type parameters.
+    fn group_methods_by_receiver(symbols: Vec<Value>) -> Vec<Value> {
+        let type_index = |symbols: &[Value], receiver: &str| {
+            let name = receiver.split('[').next().unwrap_or(receiver);
+            symbols
+                .iter()
+                .position(|symbol| symbol["kind"] == "type" && symbol["name"] == name)
+        };
+
+        let mut grouped: Vec<Value> = Vec::new();
+        let mut methods = Vec::new();
+        for symbol in symbols {
+            match symbol["receiver"].as_str() {
+                Some(receiver) if symbol["kind"] == "method" && symbol["depth"] == 0 => {
+                    methods.push((receiver.to_string(), symbol))
+                }
+                _ => grouped.push(symbol),
+            }
+        }
+        for (receiver, mut method) in methods {
+            match type_index(&grouped, &receiver) {
+                Some(index) => {
+                    method["depth"] = 1.into();
+                    let owner = &mut grouped[index];
+                    if owner["methods"].is_null() {
+                        owner["methods"] = Value::Array(Vec::new());
+                    }
+                    owner["methods"].as_array_mut().unwrap().push(method);
+                }
+                // Types declared in another file of the package stay flat
+                None => grouped.push(method),
+            }
+        }
+        grouped.sort_by_key(|symbol| symbol["range"]["byteOffset"]["start"].as_u64());
+        grouped
+    }
+
+    /// Node kinds of comments in `language`.
+    fn get_comment_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "rust" | "java" => Ok(&["line_comment", "block_comment"]),
+            "swift" => Ok(&["comment", "multiline_comment"]),
+            "javascript" | "typescript" | "python" | "go" | "cpp" | "c++" | "c" | "csharp"
+            | "cs" | "html" | "css" => Ok(&["comment"]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Find the nodes that directly follow a comment matching `regex`, such
+    /// as regions tagged `// BEGIN generated`. Up to `max_blank_lines` blank
+    /// lines may separate the comment from the node.
+    async fn after_comment(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let pattern = args["regex"].as_str().ok_or(anyhow!("Missing regex"))?;
+        let max_blank_lines = args["max_blank_lines"].as_u64().unwrap_or(1) as usize;
+        regex::Regex::new(pattern).map_err(|e| anyhow!("Invalid regex: {}", e))?;
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let comment_kinds = self
+            .get_comment_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        // A JSON string is a valid double-quoted YAML scalar
+        let quoted = serde_json::to_string(pattern)?;
+        let comment_rule = format!(
+            "id: marker-comment\nlanguage: {language}\nrule:\n  any: [{comment_kinds}]\n  regex: {quoted}\n"
+        );
+        let kind = match args["kind"].as_str() {
+            Some(kind) => format!("\n    - kind: {kind}"),
+            None => String::new(),
+        };
+        let node_rule = format!(
+            "id: after-comment\nlanguage: {language}\nrule:\n  all:\n    - not: {{ any: [{comment_kinds}] }}\n    - follows: {{ any: [{comment_kinds}], regex: {quoted} }}{kind}\n"
+        );
+
+        let comment_matches = self
+            .scan_source_json(&comment_rule, &source, path.as_deref(), language)
+            .await?;
+        let comments: Vec<(&Value, NodeSpan)> = comment_matches
+            .iter()
+            .filter_map(|m| NodeSpan::from_match(m).map(|span| (m, span)))
+            .collect();
+        let nodes = self
+            .scan_source_json(&node_rule, &source, path.as_deref(), language)
+            .await?;
+
+        let mut matches = Vec::new();
+        for node in &nodes {
+            let Some(node_span) = NodeSpan::from_match(node) else {
+                continue;
+            };
+            // The nearest marker comment separated only by whitespace
+            let marker = comments
+                .iter()
+                .filter_map(|(comment, span)| {
+                    edit_utils::blank_lines_between(&source, span.end, node_span.start)
+                        .map(|blank_lines| (comment, span, blank_lines))
+                })
+                .max_by_key(|(_, span, _)| span.end);
+            if let Some((comment, comment_span, blank_lines)) = marker {
+                if blank_lines > max_blank_lines {
+                    continue;
+                }
+                matches.push(serde_json::json!({
+                    "comment": {
+                        "text": comment_span.text.trim_end(),
+                        "range": text_encoding::encode_range(&source, &comment["range"], offset_encoding),
+                    },
+                    "node": {
+                        "kind": node["kind"],
+                        "text": node_span.text,
+                        "range": text_encoding::encode_range(&source, &node["range"], offset_encoding),
+                    },
+                    "blank_lines": blank_lines,
+                }));
+            }
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "regex": pattern,
+            "max_blank_lines": max_blank_lines,
+            "matches": matches
+        }))?)
+    }
+
+    /// Find comments matching `regex` (TODO, FIXME and XXX by default) in a
+    /// file or directory, with the declaration each sits in or documents.
+    /// Only comment nodes are searched, so a string literal mentioning
+    /// TODO is not a match.
+    async fn find_comments(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let pattern = args["regex"].as_str().unwrap_or(r"\b(TODO|FIXME|XXX)\b");
+        let include_blame = args["includeBlame"].as_bool().unwrap_or(false);
+        let regex = regex::Regex::new(pattern).map_err(|e| anyhow!("Invalid regex: {}", e))?;
+        self.validate_language(language)?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let comment_kinds = self
+            .get_comment_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        // A JSON string is a valid double-quoted YAML scalar
+        let quoted = serde_json::to_string(pattern)?;
+        let comment_rule = format!(
+            "id: find-comments\nlanguage: {language}\nrule:\n  any: [{comment_kinds}]\n  regex: {quoted}\n"
+        );
+
+        // The matching comments of each file searched, with its source
+        let mut files: Vec<(Option<PathBuf>, String, Vec<Value>)> = Vec::new();
+        let directory = match (args["code"].is_null(), args["target"].as_str()) {
+            (true, Some(target)) => Some(self.resolve_path(target)?).filter(|path| path.is_dir()),
+            _ => None,
+        };
+        match directory {
+            Some(directory) => {
+                let mut by_file: std::collections::BTreeMap<String, Vec<Value>> =
+                    std::collections::BTreeMap::new();
+                for m in self.scan_json(&comment_rule, &directory).await? {
+                    if let Some(file) = m["file"].as_str() {
+                        by_file.entry(file.to_string()).or_default().push(m);
+                    }
+                }
+                for (file, matches) in by_file {
+                    // ast-grep's offsets count a byte order mark, so the
+                    // source is read as is
+                    let source = tokio::fs::read_to_string(&file).await?;
+                    files.push((Some(PathBuf::from(file)), source, matches));
+                }
+            }
+            None => {
+                let (source, path) = self.load_source(&args).await?;
+                let matches = self
+                    .scan_source_json(&comment_rule, &source, path.as_deref(), language)
+                    .await?;
+                files.push((path, source, matches));
+            }
+        }
+
+        // Languages without an outline still list their comments
+        let outline_kinds = self.get_outline_kinds(language).ok();
+        let outline_rule = match outline_kinds {
+            Some(_) => Some(self.build_outline_rule(language)?),
+            None => None,
+        };
+        let line_prefix = self.get_line_comment_prefix(language)?;
+        let mut comments = Vec::new();
+        for (path, source, matches) in &files {
+            let mut declarations: Vec<(NodeSpan, Value)> = Vec::new();
+            if let (Some(rule), Some(kinds)) = (&outline_rule, outline_kinds) {
+                for m in self
+                    .scan_source_json(rule, source, path.as_deref(), language)
+                    .await?
+                {
+                    let (Some(span), Some(kind)) = (NodeSpan::from_match(&m), m["kind"].as_str())
+                    else {
+                        continue;
+                    };
+                    let Some((_, symbol, _)) = kinds.iter().find(|(node, _, _)| *node == kind)
+                    else {
+                        continue;
+                    };
+                    let single = &m["metaVariables"]["single"];
+                    let mut declaration = serde_json::json!({
+                        "name": single["NAME"]["text"],
+                        "kind": symbol,
+                        "line": edit_utils::line_number(source, span.start),
+                    });
+                    if let Some(receiver) = single["RECEIVER"]["text"].as_str() {
+                        declaration["receiver"] = edit_utils::receiver_type(receiver).into();
+                    }
+                    declarations.push((span, declaration));
+                }
+            }
+            // Innermost first, so the first containing one is the nearest
+            declarations.sort_by_key(|(span, _)| span.end - span.start);
+
+            let mut ranges = Vec::new();
+            let mut found = Vec::new();
+            for m in matches {
+                let Some(span) = NodeSpan::from_match(m) else {
+                    continue;
+                };
+                let containing = declarations.iter().find(|(declaration, _)| {
+                    declaration.start <= span.start && span.end <= declaration.end
+                });
+                let declaration = match containing {
+                    Some((_, declaration)) => {
+                        let mut declaration = declaration.clone();
+                        declaration["relation"] = "inside".into();
+                        Some(declaration)
+                    }
+                    // A comment on lines of its own directly above a
+                    // declaration, possibly among other comment lines,
+                    // documents it
+                    None if source[edit_utils::line_start(source, span.start)..span.start]
+                        .trim()
+                        .is_empty() =>
+                    {
+                        let mut end = span.end;
+                        loop {
+                            let rest = &source[end..];
+                            let next = end + rest.len() - rest.trim_start().len();
+                            let last_line = edit_utils::line_number(
+                                source,
+                                source[..end].trim_end_matches(['\r', '\n']).len(),
+                            );
+                            if !source[next..].starts_with(line_prefix)
+                                || edit_utils::line_number(source, next) != last_line + 1
+                            {
+                                break;
+                            }
+                            end = edit_utils::line_end(source, next);
+                        }
+                        declarations
+                            .iter()
+                            .filter(|(declaration, _)| {
+                                // Go's type_spec starts after the `type` keyword
+                                let line = edit_utils::line_start(source, declaration.start);
+                                edit_utils::blank_lines_between(source, end, line) == Some(0)
+                            })
+                            .min_by_key(|(declaration, _)| declaration.start)
+                            .map(|(_, declaration)| {
+                                let mut declaration = declaration.clone();
+                                declaration["relation"] = "above".into();
+                                declaration
+                            })
+                    }
+                    None => None,
+                };
+                let start_line = m["range"]["start"]["line"].as_u64().unwrap_or(0) as usize + 1;
+                let end_line = m["range"]["end"]["line"].as_u64().unwrap_or(0) as usize + 1;
+                ranges.push((start_line, end_line));
+                let mut entry = serde_json::json!({
+                    "file": path.as_ref().map(|path| path.display().to_string()),
+                    "text": span.text.trim_end(),
+                    "match": regex.find(&span.text).map(|found| found.as_str()),
+                    "line": start_line,
+                    "range": text_encoding::encode_range(source, &m["range"], offset_encoding),
+                    "declaration": declaration,
+                });
+                if path.is_none() {
+                    entry.as_object_mut().unwrap().remove("file");
+                }
+                found.push((entry, start_line, end_line));
+            }
+
+            let blame = match (include_blame, path) {
+                (true, Some(path)) if !ranges.is_empty() => {
+                    git_blame::blame_lines(path, &ranges).await
+                }
+                _ => None,
+            };
+            for (mut entry, start_line, end_line) in found {
+                if let Some(commit) = blame
+                    .as_ref()
+                    .and_then(|commits| git_blame::latest(commits, start_line..=end_line))
+                {
+                    entry["blame"] = commit.to_json();
+                }
+                comments.push(entry);
+            }
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "regex": pattern,
+            "total": comments.len(),
+            "comments": comments
+        }))?)
+    }
+
+    /// Find nodes structurally similar to the one at a position, such as
+    /// copy-pasted functions with renamed variables. The reference is the
+    /// innermost function (or node of `kind`) covering the position, and
+    /// other nodes of the same kinds are compared by normalized token
+    /// sequence; those scoring at least `threshold` are returned, best first.
+    async fn find_similar(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let threshold = args["threshold"].as_f64().unwrap_or(0.8);
+        if !(0.0..=1.0).contains(&threshold) {
+            return Err(anyhow!(
+                "threshold must be between 0 and 1, got {}",
+                threshold
+            ));
+        }
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let kinds: Vec<&str> = match args["kind"].as_str() {
+            Some(kind) => vec![kind],
+            None => self.get_function_kinds(language)?.to_vec(),
+        };
+        let rule_config = format!(
+            "id: find-similar\nlanguage: {language}\nrule:\n  any: [{}]\n",
+            kinds
+                .iter()
+                .map(|kind| format!("{{ kind: {kind} }}"))
+                .collect::<Vec<_>>()
+                .join(", ")
+        );
+        let matches = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+        let nodes: Vec<(&Value, NodeSpan)> = matches
+            .iter()
+            .filter_map(|m| NodeSpan::from_match(m).map(|span| (m, span)))
+            .collect();
+
+        let (reference, reference_span) = nodes
+            .iter()
+            .filter(|(_, span)| span.start <= start && end <= span.end)
+            .min_by_key(|(_, span)| span.end - span.start)
+            .ok_or_else(|| anyhow!("No {} covers the position", kinds.join(" or ")))?;
+        let reference_tokens = structure::normalized_tokens(&reference_span.text);
+
+        let mut candidates: Vec<(f64, &Value, &NodeSpan)> = nodes
+            .iter()
+            // Nodes nested in the reference, or containing it, are not clones of it
+            .filter(|(_, span)| {
+                span.end <= reference_span.start || span.start >= reference_span.end
+            })
+            .filter_map(|(m, span)| {
+                let tokens = structure::normalized_tokens(&span.text);
+                if structure::similarity_bound(reference_tokens.len(), tokens.len()) < threshold {
+                    return None;
+                }
+                let score = structure::similarity(&reference_tokens, &tokens);
+                (score >= threshold).then_some((score, *m, span))
+            })
+            .collect();
+        candidates.sort_by(|a, b| b.0.total_cmp(&a.0).then(a.2.start.cmp(&b.2.start)));
+
+        let describe = |m: &Value, span: &NodeSpan| {
+            serde_json::json!({
+                "kind": m["kind"],
+                "signature": span.text.lines().next().unwrap_or("").trim(),
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+            })
+        };
+        let candidates: Vec<Value> = candidates
+            .iter()
+            .map(|(score, m, span)| {
+                let mut candidate = describe(m, span);
+                candidate["similarity"] = ((score * 1000.0).round() / 1000.0).into();
+                candidate
+            })
+            .collect();
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "reference": describe(reference, reference_span),
+            "threshold": threshold,
+            "candidates": candidates
+        }))?)
+    }
+
+    /// Node kind of a `return` in `language`.
+    fn get_return_kind(&self, language: &str) -> Result<&'static str> {
+        match language {
+            "rust" => Ok("return_expression"),
+            // Shared with break, continue, and throw; callers match `^return`
+            "swift" => Ok("control_transfer_statement"),
+            "javascript" | "typescript" | "python" | "go" | "java" | "c" | "cpp" | "c++"
+            | "csharp" | "cs" => Ok("return_statement"),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Apply `template` to the value of every `return` in one function,
+    /// with `$EXPR` standing for the returned expression. Returns inside
+    /// nested functions and closures belong to them and are skipped unless
+    /// `skipNested` is false.
+    async fn rewrite_returns(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let template = args["template"]
+            .as_str()
+            .ok_or(anyhow!("Missing template"))?;
+        let skip_nested = args["skipNested"].as_bool().unwrap_or(true);
+        if !template.contains("$EXPR") {
+            return Err(anyhow!(
+                "template must contain $EXPR for the returned expression, e.g. 'Ok($EXPR)'"
+            ));
+        }
+
+        self.validate_language(language)?;
+        let return_kind = self.get_return_kind(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let nested: Vec<&NodeSpan> = functions
+            .iter()
+            .map(|(_, span)| span)
+            .filter(|span| {
+                function.start <= span.start
+                    && span.end <= function.end
+                    && (span.start, span.end) != (function.start, function.end)
+            })
+            .collect();
+
+        let return_rule = format!(
+            "id: rewrite-returns\nlanguage: {language}\nrule:\n  kind: {return_kind}\n  regex: ^return\n"
+        );
+        let returns: Vec<NodeSpan> = self
+            .scan_source_json(&return_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| function.start <= span.start && span.end <= function.end)
+            .filter(|span| {
+                !skip_nested
+                    || !nested
+                        .iter()
+                        .any(|inner| inner.start <= span.start && span.end <= inner.end)
+            })
+            .collect();
+
+        let mut edits = Vec::new();
+        let mut bare_returns = 0;
+        for span in &returns {
+            match edit_utils::return_value_range(&source, span) {
+                Some((start, end)) => edits.push(TextEdit {
+                    start,
+                    end,
+                    replacement: template.replace("$EXPR", &source[start..end]),
+                }),
+                None => bare_returns += 1,
+            }
+        }
+        let lines: Vec<usize> = edits
+            .iter()
+            .map(|edit| edit_utils::line_number(&source, edit.start))
+            .collect();
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": function_name
+                .clone()
+                .or_else(|| edit_utils::guess_function_name(&function.text)),
+            "rewritten": edits.len(),
+            "bare_returns": bare_returns,
+            "lines": lines,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Insert an error check after each Go call whose `err` result is not
+    /// looked at by the next statement. The default check returns `err`
+    /// with zero values for the enclosing function's other results;
+    /// `template` replaces it, with `$RETURN` standing for that list.
+    /// Sites in functions whose last result is not an `error` are only
+    /// reported.
+    async fn add_error_checks(&self, args: Value) -> Result<String> {
+        let language = args["language"].as_str().unwrap_or("go");
+        if language != "go" {
+            return Err(anyhow!(
+                "add_error_checks only supports Go, got {}",
+                language
+            ));
+        }
+        let template = args["template"]
+            .as_str()
+            .unwrap_or("if err != nil {\n\treturn $RETURN\n}");
+        let only_lines: Option<Vec<u64>> = args["lines"]
+            .as_array()
+            .map(|lines| lines.iter().filter_map(|line| line.as_u64()).collect());
+        let (source, path) = self.load_source(&args).await?;
+
+        // Header assignments (`if v, err := f(); err != nil`) are already checked
+        let assignment_rule = r#"id: unchecked-err
+language: go
+rule:
+  any: [{ kind: short_var_declaration }, { kind: assignment_statement }]
+  all:
+    - has: { field: left, has: { kind: identifier, regex: ^err$ } }
+    - has: { field: right, has: { kind: call_expression, pattern: $CALL } }
+  not:
+    inside:
+      any: [{ kind: if_statement }, { kind: expression_switch_statement }, { kind: type_switch_statement }, { kind: for_clause }]
+"#;
+        let mentions_err_rule = r#"id: mentions-err
+language: go
+rule:
+  any:
+    - kind: if_statement
+    - kind: return_statement
+    - kind: expression_statement
+    - kind: short_var_declaration
+    - kind: assignment_statement
+    - kind: defer_statement
+    - kind: go_statement
+    - kind: var_declaration
+    - kind: expression_switch_statement
+    - kind: send_statement
+  has: { kind: identifier, regex: ^err$, stopBy: end }
+"#;
+        let kinds = self
+            .get_function_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let function_rule = format!(
+            "id: error-check-function\nlanguage: go\nrule:\n  any:\n    - all:\n        - any: [{kinds}]\n        - has: {{ field: result, pattern: $RESULT }}\n    - any: [{kinds}]\n"
+        );
+
+        let assignments = self
+            .scan_source_json(assignment_rule, &source, path.as_deref(), language)
+            .await?;
+        let checked_starts: std::collections::HashSet<usize> = self
+            .scan_source_json(mentions_err_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .map(|span| span.start)
+            .collect();
+        let functions: Vec<(NodeSpan, Option<String>)> = self
+            .scan_source_json(&function_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let result = m["metaVariables"]["single"]["RESULT"]["text"]
+                    .as_str()
+                    .map(|result| result.to_string());
+                NodeSpan::from_match(m).map(|span| (span, result))
+            })
+            .collect();
+
+        let mut edits = Vec::new();
+        let mut sites = Vec::new();
+        for m in &assignments {
+            let Some(span) = NodeSpan::from_match(m) else {
+                continue;
+            };
+            let line = edit_utils::line_number(&source, span.start);
+            if checked_starts.contains(&edit_utils::skip_trivia(&source, span.end)) {
+                continue;
+            }
+            if only_lines
+                .as_ref()
+                .is_some_and(|lines| !lines.contains(&(line as u64)))
+            {
+                continue;
+            }
+            let call = m["metaVariables"]["single"]["CALL"]["text"]
+                .as_str()
+                .unwrap_or("");
+            let result = functions
+                .iter()
+                .filter(|(function, _)| function.start <= span.start && span.end <= function.end)
+                .min_by_key(|(function, _)| function.end - function.start)
+                .map(|(_, result)| result.as_deref().unwrap_or(""));
+            let types = result.map(edit_utils::go_result_types).unwrap_or_default();
+            if types.last().map(String::as_str) != Some("error") {
+                sites.push(serde_json::json!({
+                    "line": line,
+                    "call": call,
+                    "skipped": "the enclosing function does not return an error",
+                }));
+                continue;
+            }
+            let mut values: Vec<String> = types[..types.len() - 1]
+                .iter()
+                .map(|go_type| edit_utils::go_zero_value(go_type))
+                .collect();
+            values.push("err".to_string());
+
+            // Below the assignment's last line, after any trailing comment
+            let indent = edit_utils::indentation_at(&source, span.start);
+            let at = edit_utils::line_end(&source, span.end);
+            let mut check = String::new();
+            if !source[..at].ends_with('\n') {
+                check.push('\n');
+            }
+            for check_line in template.replace("$RETURN", &values.join(", ")).lines() {
+                check.push_str(&format!("{indent}{check_line}\n"));
+            }
+            edits.push(TextEdit {
+                start: at,
+                end: at,
+                replacement: check,
+            });
+            sites.push(serde_json::json!({
+                "line": line,
+                "call": call,
+                "returns": values.join(", "),
+            }));
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "inserted": edits.len(),
+            "sites": sites,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Switch a Go function between named and unnamed results. Going to
+    /// unnamed, bare returns spell out the results and the names become
+    /// `var` declarations; going to named, explicit returns stay unless
+    /// `bare_returns` turns them into assignments and a bare `return`.
+    /// Conversions that would change behaviour, or shadow a name already in
+    /// use, are refused.
+    async fn convert_go_returns(&self, args: Value) -> Result<String> {
+        let style = args["style"].as_str().ok_or(anyhow!("Missing style"))?;
+        let bare_returns = args["bare_returns"].as_bool().unwrap_or(false);
+        if !matches!(style, "named" | "unnamed") {
+            return Err(anyhow!(
+                "Unknown style: {}. Use 'named' or 'unnamed'",
+                style
+            ));
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let display_name = function_name
+            .clone()
+            .or_else(|| edit_utils::guess_function_name(&function.text))
+            .unwrap_or_else(|| "the function".to_string());
+        let kinds = self
+            .get_function_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let signature_rule = format!(
+            "id: go-results\nlanguage: go\nrule:\n  any: [{kinds}]\n  all:\n    - has: {{ field: result, pattern: $RESULT }}\n    - has: {{ field: body, pattern: $BODY }}\n"
+        );
+        let signature = self
+            .scan_source_json(&signature_rule, &source, path.as_deref(), language)
+            .await?
+            .into_iter()
+            .find(|m| {
+                NodeSpan::from_match(m)
+                    .is_some_and(|span| (span.start, span.end) == (function.start, function.end))
+            })
+            .ok_or_else(|| anyhow!("{} has no results to convert", display_name))?;
+        let offsets = |name: &str| {
+            let range = &signature["metaVariables"]["single"][name]["range"]["byteOffset"];
+            Some((
+                range["start"].as_u64()? as usize,
+                range["end"].as_u64()? as usize,
+            ))
+        };
+        let (result_start, result_end) =
+            offsets("RESULT").ok_or_else(|| anyhow!("Could not locate the result list"))?;
+        let (body_start, body_end) =
+            offsets("BODY").ok_or_else(|| anyhow!("Could not locate the function body"))?;
+        let results = edit_utils::go_results(&source[result_start..result_end]);
+        let body = &source[body_start..body_end];
+        let mentions = |text: &str, name: &str| {
+            regex::Regex::new(&format!(r"\b{}\b", regex::escape(name)))
+                .map(|word| word.is_match(text))
+                .unwrap_or(false)
+        };
+
+        // Returns of this function, not of closures inside it
+        let return_rule = "id: go-returns\nlanguage: go\nrule:\n  kind: return_statement\n";
+        let nested: Vec<&NodeSpan> = functions
+            .iter()
+            .map(|(_, span)| span)
+            .filter(|span| {
+                function.start <= span.start
+                    && span.end <= function.end
+                    && (span.start, span.end) != (function.start, function.end)
+            })
+            .collect();
+        let returns: Vec<NodeSpan> = self
+            .scan_source_json(return_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| function.start <= span.start && span.end <= function.end)
+            .filter(|span| {
+                !nested
+                    .iter()
+                    .any(|inner| inner.start <= span.start && span.end <= inner.end)
+            })
+            .collect();
+
+        let mut edits = Vec::new();
+        let new_results: Vec<(Option<String>, String)>;
+        if style == "unnamed" {
+            if results.iter().any(|(name, _)| name.is_none()) {
+                return Err(anyhow!("{}'s results are already unnamed", display_name));
+            }
+            let names: Vec<&str> = results
+                .iter()
+                .filter_map(|(name, _)| name.as_deref())
+                .collect();
+            if names.contains(&"_") {
+                return Err(anyhow!(
+                    "Refusing to convert {}: a result named _ cannot be returned by name",
+                    display_name
+                ));
+            }
+            // A deferred call can change a named result after `return`
+            let defer_rule = "id: go-defers\nlanguage: go\nrule:\n  kind: defer_statement\n";
+            for defer in self
+                .scan_source_json(defer_rule, &source, path.as_deref(), language)
+                .await?
+                .iter()
+                .filter_map(NodeSpan::from_match)
+                .filter(|span| body_start <= span.start && span.end <= body_end)
+            {
+                if let Some(name) = names.iter().find(|name| mentions(&defer.text, name)) {
+                    return Err(anyhow!(
+                        "Refusing to convert {}: the defer on line {} uses the named result {}, which it could change after return",
+                        display_name,
+                        edit_utils::line_number(&source, defer.start),
+                        name
+                    ));
+                }
+            }
+
+            let mut bare = 0;
+            for span in &returns {
+                if edit_utils::return_value_range(&source, span).is_none() {
+                    bare += 1;
+                    edits.push(TextEdit {
+                        start: span.start,
+                        end: span.end,
+                        replacement: format!("return {}", names.join(", ")),
+                    });
+                }
+            }
+            // Declare the names the body still uses, grouped as in the signature
+            let used: Vec<(Option<String>, String)> = results
+                .iter()
+                .filter(|(name, _)| bare > 0 || mentions(body, name.as_deref().unwrap_or_default()))
+                .cloned()
+                .collect();
+            if !used.is_empty() {
+                let first_statement = edit_utils::skip_trivia(&source, body_start + 1);
+                let indent = edit_utils::indentation_at(&source, first_statement);
+                let mut declarations = String::new();
+                let mut i = 0;
+                while i < used.len() {
+                    let group_type = &used[i].1;
+                    let group: Vec<&str> = used[i..]
+                        .iter()
+                        .take_while(|(_, result_type)| result_type == group_type)
+                        .filter_map(|(name, _)| name.as_deref())
+                        .collect();
+                    i += group.len();
+                    declarations
+                        .push_str(&format!("\n{indent}var {} {group_type}", group.join(", ")));
+                }
+                edits.push(TextEdit {
+                    start: body_start + 1,
+                    end: body_start + 1,
+                    replacement: declarations,
+                });
+            }
+            new_results = results
+                .iter()
+                .map(|(_, result_type)| (None, result_type.clone()))
+                .collect();
+        } else {
+            if results.iter().any(|(name, _)| name.is_some()) {
+                return Err(anyhow!("{}'s results are already named", display_name));
+            }
+            let names: Vec<String> = match args["names"].as_array() {
+                Some(names) => names
+                    .iter()
+                    .map(|name| {
+                        name.as_str()
+                            .map(|name| name.to_string())
+                            .ok_or(anyhow!("names must be strings"))
+                    })
+                    .collect::<Result<_>>()?,
+                // Only an error result has a conventional name: err, then
+                // err2, err3... for any more
+                None if results
+                    .iter()
+                    .all(|(_, result_type)| result_type == "error") =>
+                {
+                    (1..=results.len())
+                        .map(|n| match n {
+                            1 => "err".to_string(),
+                            n => format!("err{n}"),
+                        })
+                        .collect()
+                }
+                None => {
+                    return Err(anyhow!(
+                        "Pass names for the results of {}: {}",
+                        display_name,
+                        edit_utils::format_go_results(&results)
+                    ))
+                }
+            };
+            if names.len() != results.len() {
+                return Err(anyhow!(
+                    "{} has {} results but {} names were given",
+                    display_name,
+                    results.len(),
+                    names.len()
+                ));
+            }
+            if let Some((_, name)) = names
+                .iter()
+                .enumerate()
+                .find(|(i, name)| *name != "_" && names[..*i].contains(name))
+            {
+                return Err(anyhow!(
+                    "Refusing to name two results {}: Go result names must be unique",
+                    name
+                ));
+            }
+            if let Some(name) = names.iter().find(|name| mentions(&function.text, name)) {
+                return Err(anyhow!(
+                    "Refusing to name a result {}: {} already uses that name",
+                    name,
+                    display_name
+                ));
+            }
+            if bare_returns {
+                for span in &returns {
+                    if let Some((start, end)) = edit_utils::return_value_range(&source, span) {
+                        let indent = edit_utils::indentation_at(&source, span.start);
+                        edits.push(TextEdit {
+                            start: span.start,
+                            end: span.end,
+                            replacement: format!(
+                                "{} = {}\n{indent}return",
+                                names.join(", "),
+                                &source[start..end]
+                            ),
+                        });
+                    }
+                }
+            }
+            new_results = names
+                .into_iter()
+                .zip(&results)
+                .map(|(name, (_, result_type))| (Some(name), result_type.clone()))
+                .collect();
+        }
+        let new_signature = edit_utils::format_go_results(&new_results);
+        edits.push(TextEdit {
+            start: result_start,
+            end: result_end,
+            replacement: new_signature.clone(),
+        });
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": display_name,
+            "results": new_signature,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Reflow a Go function's parameters between one line and one per line,
+    /// Go style, with a trailing comma and the closing parenthesis back at
+    /// the function's indentation. Only the lists are rewritten, so the
+    /// receiver, type parameters and body stay as they are; joining also
+    /// joins a wrapped result list. Signatures with a comment inside are
+    /// refused, since joining lines could leave code inside the comment.
+    async fn reflow_go_signature(&self, args: Value) -> Result<String> {
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let display_name = function_name
+            .clone()
+            .or_else(|| edit_utils::guess_function_name(&function.text))
+            .unwrap_or_else(|| "the function".to_string());
+        let field_span = |field: &'static str| {
+            self.function_field(&source, path.as_deref(), language, function, field)
+        };
+        let parameters = field_span("parameters")
+            .await?
+            .map(|span| (span.start, span.end))
+            .ok_or_else(|| anyhow!("Could not locate the parameters of {}", display_name))?;
+        // Only a parenthesized result list is reflowed, not a single type
+        let result = field_span("result")
+            .await?
+            .filter(|span| span.text.starts_with('('))
+            .map(|span| (span.start, span.end));
+        let body_start = field_span("body").await?.map(|span| span.start);
+
+        let wrapped = source[parameters.0..parameters.1].contains('\n');
+        let layout = args["layout"].as_str().unwrap_or(match wrapped {
+            true => "single_line",
+            false => "multi_line",
+        });
+        if !matches!(layout, "single_line" | "multi_line") {
+            return Err(anyhow!(
+                "Unknown layout: {}. Use 'single_line' or 'multi_line'",
+                layout
+            ));
+        }
+        let signature_end = body_start.unwrap_or(function.end);
+        if let Some(comment) = self
+            .scan_source_json(
+                "id: go-signature-comments\nlanguage: go\nrule:\n  kind: comment\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .find(|span| parameters.0 <= span.start && span.end <= signature_end)
+        {
+            return Err(anyhow!(
+                "The signature of {} has a comment on line {}; move it out before reflowing",
+                display_name,
+                edit_utils::line_number(&source, comment.start)
+            ));
+        }
+
+        // The parameters of a list, and not those of function types in them
+        let declarations: Vec<NodeSpan> = self
+            .scan_source_json(
+                "id: go-signature-parameters\nlanguage: go\nrule:\n  any: [{ kind: parameter_declaration }, { kind: variadic_parameter_declaration }]\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let elements = |(start, end): (usize, usize)| -> Vec<&NodeSpan> {
+            let inside: Vec<&NodeSpan> = declarations
+                .iter()
+                .filter(|span| start < span.start && span.end < end)
+                .collect();
+            inside
+                .iter()
+                .filter(|span| {
+                    !inside.iter().any(|outer| {
+                        outer.start <= span.start
+                            && span.end <= outer.end
+                            && (outer.start, outer.end) != (span.start, span.end)
+                    })
+                })
+                .copied()
+                .collect()
+        };
+        let joined = |list: (usize, usize)| -> Result<String> {
+            let texts = elements(list)
+                .iter()
+                .map(|span| match span.text.contains('\n') {
+                    true => Err(anyhow!(
+                        "The parameter on line {} spans several lines, so it cannot be joined onto one",
+                        edit_utils::line_number(&source, span.start)
+                    )),
+                    false => Ok(span.text.as_str()),
+                })
+                .collect::<Result<Vec<&str>>>()?;
+            Ok(format!("({})", texts.join(", ")))
+        };
+
+        let parameter_count = elements(parameters).len();
+        let mut edits = Vec::new();
+        match layout {
+            "multi_line" => {
+                if parameter_count == 0 {
+                    return Err(anyhow!(
+                        "{} has no parameters to put on lines of their own",
+                        display_name
+                    ));
+                }
+                let indent = edit_utils::indentation_at(&source, function.start);
+                let lines: String = elements(parameters)
+                    .iter()
+                    .map(|span| format!("{indent}\t{},\n", span.text))
+                    .collect();
+                edits.push(TextEdit {
+                    start: parameters.0,
+                    end: parameters.1,
+                    replacement: format!("(\n{lines}{indent})"),
+                });
+            }
+            _ => {
+                edits.push(TextEdit {
+                    start: parameters.0,
+                    end: parameters.1,
+                    replacement: joined(parameters)?,
+                });
+                if let Some(result) =
+                    result.filter(|&(start, end)| source[start..end].contains('\n'))
+                {
+                    edits.push(TextEdit {
+                        start: result.0,
+                        end: result.1,
+                        replacement: joined(result)?,
+                    });
+                }
+            }
+        }
+        edits.retain(|edit| source[edit.start..edit.end] != edit.replacement);
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        // Every edit is before the body, so it moves by their total change
+        let moved = edits.iter().fold(signature_end as isize, |end, edit| {
+            end + edit.replacement.len() as isize - (edit.end - edit.start) as isize
+        }) as usize;
+        let signature = new_source[function.start..moved].trim_end();
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": display_name,
+            "line": edit_utils::line_number(&source, function.start),
+            "layout": layout,
+            "parameters": parameter_count,
+            "signature": signature,
+            "unchanged": edits.is_empty(),
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Rules matching identifiers that bind a name in `language`:
+    /// parameters, local declarations, loop and pattern variables, and
+    /// nested function names.
+    fn get_binding_rules(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "javascript" => Ok(&[
+                "{ kind: identifier, inside: { kind: variable_declarator, field: name } }",
+                "{ kind: identifier, inside: { kind: formal_parameters } }",
+                "{ kind: identifier, inside: { kind: assignment_pattern, field: left } }",
+                "{ kind: identifier, inside: { kind: array_pattern } }",
+                "{ kind: identifier, inside: { kind: pair_pattern, field: value } }",
+                "{ kind: identifier, inside: { kind: rest_pattern } }",
+                "{ kind: identifier, inside: { kind: catch_clause, field: parameter } }",
+                "{ kind: identifier, inside: { kind: arrow_function, field: parameter } }",
+                "{ kind: identifier, inside: { kind: for_in_statement, field: left } }",
+                "{ kind: identifier, inside: { kind: function_declaration, field: name } }",
+                "{ kind: identifier, inside: { kind: class_declaration, field: name } }",
+                "{ kind: shorthand_property_identifier_pattern }",
+            ]),
+            "typescript" => Ok(&[
+                "{ kind: identifier, inside: { kind: variable_declarator, field: name } }",
+                "{ kind: identifier, inside: { kind: required_parameter, field: pattern } }",
+                "{ kind: identifier, inside: { kind: optional_parameter, field: pattern } }",
+                "{ kind: identifier, inside: { kind: assignment_pattern, field: left } }",
+                "{ kind: identifier, inside: { kind: array_pattern } }",
+                "{ kind: identifier, inside: { kind: pair_pattern, field: value } }",
+                "{ kind: identifier, inside: { kind: rest_pattern } }",
+                "{ kind: identifier, inside: { kind: catch_clause, field: parameter } }",
+                "{ kind: identifier, inside: { kind: arrow_function, field: parameter } }",
+                "{ kind: identifier, inside: { kind: for_in_statement, field: left } }",
+                "{ kind: identifier, inside: { kind: function_declaration, field: name } }",
+                "{ kind: shorthand_property_identifier_pattern }",
+            ]),
+            "python" => Ok(&[
+                "{ kind: identifier, inside: { kind: parameters } }",
+                "{ kind: identifier, inside: { kind: lambda_parameters } }",
+                "{ kind: identifier, inside: { kind: default_parameter, field: name } }",
+                "{ kind: identifier, inside: { kind: typed_parameter } }",
+                "{ kind: identifier, inside: { kind: typed_default_parameter, field: name } }",
+                "{ kind: identifier, inside: { kind: list_splat_pattern } }",
+                "{ kind: identifier, inside: { kind: dictionary_splat_pattern } }",
+                "{ kind: identifier, inside: { kind: assignment, field: left } }",
+                "{ kind: identifier, inside: { kind: pattern_list } }",
+                "{ kind: identifier, inside: { kind: tuple_pattern } }",
+                "{ kind: identifier, inside: { kind: list_pattern } }",
+                "{ kind: identifier, inside: { kind: for_statement, field: left } }",
+                "{ kind: identifier, inside: { kind: for_in_clause, field: left } }",
+                "{ kind: identifier, inside: { kind: as_pattern_target } }",
+                "{ kind: identifier, inside: { kind: named_expression, field: name } }",
+                "{ kind: identifier, inside: { kind: function_definition, field: name } }",
+                "{ kind: identifier, inside: { kind: class_definition, field: name } }",
+            ]),
+            "go" => Ok(&[
+                "{ kind: identifier, inside: { kind: expression_list, inside: { kind: short_var_declaration, field: left } } }",
+                "{ kind: identifier, inside: { kind: expression_list, inside: { kind: range_clause, field: left } } }",
+                "{ kind: identifier, inside: { kind: var_spec, field: name } }",
+                "{ kind: identifier, inside: { kind: const_spec, field: name } }",
+                // Parameters, receivers, and named results
+                "{ kind: identifier, inside: { kind: parameter_declaration, field: name } }",
+                "{ kind: identifier, inside: { kind: variadic_parameter_declaration, field: name } }",
+                "{ kind: identifier, inside: { kind: function_declaration, field: name } }",
+            ]),
+            "rust" => Ok(&[
+                "{ kind: identifier, inside: { kind: let_declaration, field: pattern } }",
+                "{ kind: identifier, inside: { kind: let_condition, field: pattern } }",
+                "{ kind: identifier, inside: { kind: parameter, field: pattern } }",
+                "{ kind: identifier, inside: { kind: closure_parameters } }",
+                "{ kind: identifier, inside: { kind: for_expression, field: pattern } }",
+                "{ kind: identifier, inside: { kind: tuple_pattern } }",
+                "{ kind: identifier, inside: { kind: slice_pattern } }",
+                "{ kind: identifier, inside: { kind: ref_pattern } }",
+                "{ kind: identifier, inside: { kind: mut_pattern } }",
+                "{ kind: identifier, inside: { kind: match_pattern } }",
+                "{ kind: identifier, inside: { kind: tuple_struct_pattern }, not: { inside: { kind: tuple_struct_pattern, field: type } } }",
+                "{ kind: shorthand_field_identifier }",
+                "{ kind: identifier, inside: { kind: function_item, field: name } }",
+            ]),
+            _ => Err(anyhow!(
+                "free_identifiers does not support {} yet (supported: javascript, typescript, python, go, rust)",
+                language
+            )),
+        }
+    }
+
+    /// Rules for identifiers in `language` that are neither bindings nor
+    /// reads of a variable, such as attribute and keyword argument names.
+    fn get_non_read_rules(&self, language: &str) -> &'static [&'static str] {
+        match language {
+            "python" => &[
+                "{ inside: { kind: attribute, field: attribute } }",
+                "{ inside: { kind: keyword_argument, field: name } }",
+            ],
+            // `Type::method` reads `Type`, not a variable called `method`
+            "rust" => &["{ inside: { kind: scoped_identifier, field: name } }"],
+            _ => &[],
+        }
+    }
+
+    /// Rules for the identifiers that bind a name in `language`, and for
+    /// those that read one.
+    fn build_identifier_rules(&self, language: &str) -> Result<(String, String)> {
+        let binding_rules = self.get_binding_rules(language)?;
+        let read_kinds = match language {
+            "javascript" | "typescript" => {
+                "[{ kind: identifier }, { kind: shorthand_property_identifier }]"
+            }
+            _ => "[{ kind: identifier }]",
+        };
+        let not_reads = binding_rules
+            .iter()
+            .chain(self.get_non_read_rules(language))
+            .map(|rule| format!("      - {rule}\n"))
+            .collect::<String>();
+        let bindings = binding_rules
+            .iter()
+            .map(|rule| format!("    - {rule}\n"))
+            .collect::<String>();
+        let read_rule = format!(
+            "id: identifier-reads\nlanguage: {language}\nrule:\n  any: {read_kinds}\n  not:\n    any:\n{not_reads}"
+        );
+        let binding_rule =
+            format!("id: identifier-bindings\nlanguage: {language}\nrule:\n  any:\n{bindings}");
+        Ok((binding_rule, read_rule))
+    }
+
+    /// Identifiers a function reads but does not bind itself, such as
+    /// package-level names, imports and builtins. Names bound anywhere in
+    /// the function (parameters, locals, named results) are subtracted
+    /// wholesale, without tracking block scopes or use before declaration.
+    async fn free_identifiers(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        self.validate_language(language)?;
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+
+        let in_function =
+            |span: &NodeSpan| function.start <= span.start && span.end <= function.end;
+
+        let bound: std::collections::BTreeSet<String> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| in_function(span))
+            .map(|span| span.text)
+            .collect();
+        let mut free: Vec<(String, usize, usize)> = Vec::new();
+        for span in self
+            .scan_source_json(&read_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| in_function(span))
+        {
+            if span.text == "_" || bound.contains(&span.text) {
+                continue;
+            }
+            match free.iter_mut().find(|(name, _, _)| *name == span.text) {
+                Some((_, _, count)) => *count += 1,
+                None => free.push((
+                    span.text.clone(),
+                    edit_utils::line_number(&source, span.start),
+                    1,
+                )),
+            }
+        }
+        free.sort_by_key(|(_, line, _)| *line);
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "function": function_name
+                .clone()
+                .or_else(|| edit_utils::guess_function_name(&function.text)),
+            "free": free
+                .iter()
+                .map(|(name, line, count)| serde_json::json!({
+                    "name": name,
+                    "first_line": line,
+                    "uses": count,
+                }))
+                .collect::<Vec<_>>(),
+            "bound": bound,
+        }))?)
+    }
+
+    /// Every place in the file that refers to a top-level symbol, each
+    /// classed as its declaration, a read, a write or a call. Uses inside a
+    /// function that binds the same name (a parameter or local) refer to
+    /// that local and are left out, counted as `shadowed`. As in
+    /// `free_identifiers`, a binding covers its whole function; block scopes
+    /// are not tracked.
+    async fn find_references(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        self.validate_language(language)?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        self.build_identifier_rules(language).map_err(|_| {
+            anyhow!(
+                "find_references does not support {} yet (supported: javascript, typescript, python, go, rust)",
+                language
+            )
+        })?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let (name, at) = match args["name"].as_str() {
+            Some(name) => (name.to_string(), None),
+            None => {
+                let (start, _) = self.get_target_range(&args, &source)?;
+                let is_word = |c: char| c.is_alphanumeric() || c == '_';
+                let word_start = source[..start]
+                    .char_indices()
+                    .rev()
+                    .take_while(|(_, c)| is_word(*c))
+                    .last()
+                    .map_or(start, |(i, _)| i);
+                let word_end = source[start..]
+                    .find(|c: char| !is_word(c))
+                    .map_or(source.len(), |i| start + i);
+                if word_start == word_end {
+                    return Err(anyhow!("No identifier at the given position"));
+                }
+                (source[word_start..word_end].to_string(), Some(word_start))
+            }
+        };
+        if !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(&name) {
+            return Err(anyhow!("'{}' is not a valid identifier", name));
+        }
+        let (references, shadowed) = self
+            .collect_references(&source, path.as_deref(), language, &name)
+            .await?;
+        if let Some(at) = at {
+            if let Some((_, scope)) = shadowed.iter().find(|(span, _)| span.start == at) {
+                return Err(anyhow!(
+                    "'{}' on line {} is local to the function on line {}; find_references looks for top-level symbols",
+                    name,
+                    edit_utils::line_number(&source, at),
+                    edit_utils::line_number(&source, scope.start)
+                ));
+            }
+        }
+
+        let count = |kind: &str| {
+            references
+                .iter()
+                .filter(|(_, _, reference_kind)| *reference_kind == kind)
+                .count()
+        };
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "name": name,
+            "declarations": references
+                .iter()
+                .filter(|(_, _, kind)| *kind == "declaration")
+                .map(|(span, _, _)| edit_utils::line_number(&source, span.start))
+                .collect::<Vec<_>>(),
+            "references": references
+                .iter()
+                .map(|(span, range, kind)| {
+                    let line_start = edit_utils::line_start(&source, span.start);
+                    let line_end = edit_utils::line_end(&source, span.start);
+                    serde_json::json!({
+                        "kind": kind,
+                        "line": edit_utils::line_number(&source, span.start),
+                        "text": source[line_start..line_end].trim(),
+                        "range": text_encoding::encode_range(&source, range, offset_encoding),
+                    })
+                })
+                .collect::<Vec<_>>(),
+            "counts": {
+                "read": count("read"),
+                "write": count("write"),
+                "call": count("call"),
+            },
+            "shadowed": shadowed.len(),
+        }))?)
+    }
+
+    /// The references to the top-level symbol `name` in a file, in order,
+    /// each with its range and kind, and the uses that refer instead to a
+    /// local of the same name, each with the function that binds it.
+    async fn collect_references(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+        name: &str,
+    ) -> Result<(
+        Vec<(NodeSpan, Value, &'static str)>,
+        Vec<(NodeSpan, NodeSpan)>,
+    )> {
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let constraint = format!("  regex: ^{name}$\n");
+        let scan = |rule: String| async move {
+            Ok::<Vec<(NodeSpan, Value)>, anyhow::Error>(
+                self.scan_source_json(&rule, source, path, language)
+                    .await?
+                    .iter()
+                    .filter_map(|m| NodeSpan::from_match(m).map(|span| (span, m["range"].clone())))
+                    .collect(),
+            )
+        };
+        let bindings = scan(format!("{binding_rule}{constraint}")).await?;
+        let mut uses = scan(format!("{read_rule}{constraint}")).await?;
+        if matches!(language, "go" | "rust" | "typescript") {
+            uses.extend(
+                scan(format!(
+                    "id: type-references\nlanguage: {language}\nrule:\n  kind: type_identifier\n{constraint}"
+                ))
+                .await?,
+            );
+        }
+        uses.retain(|(span, _)| !edit_utils::is_member_name(source, span));
+
+        // A binding is local to the innermost function whose parameters or
+        // body hold it; a function's own name is bound outside it
+        let functions = self.scan_functions(source, path, language).await?;
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        let owner = |span: &NodeSpan| {
+            functions
+                .iter()
+                .map(|(_, function)| function)
+                .filter(|function| {
+                    let head = function.text.find('(').unwrap_or(0);
+                    within(span, function) && span.start >= function.start + head
+                })
+                .min_by_key(|function| function.end - function.start)
+        };
+        let scopes: Vec<&NodeSpan> = bindings
+            .iter()
+            .filter_map(|(span, _)| owner(span))
+            .collect();
+        let shadowed_at = |span: &NodeSpan| scopes.iter().find(|scope| within(span, scope));
+
+        // Names declared in a type's body are members, not top-level symbols
+        let outline_kinds = self.get_outline_kinds(language)?;
+        let outline = self
+            .scan_source_json(&self.build_outline_rule(language)?, source, path, language)
+            .await?;
+        let members: Vec<(NodeSpan, usize)> = outline
+            .iter()
+            .filter(|m| {
+                outline_kinds.iter().any(|(kind, symbol, _)| {
+                    m["kind"].as_str() == Some(*kind) && matches!(*symbol, "type" | "impl")
+                })
+            })
+            .filter_map(|m| {
+                let name = NodeSpan::from_match(&m["metaVariables"]["single"]["NAME"])?;
+                Some((NodeSpan::from_match(m)?, name.end))
+            })
+            .collect();
+        let top_level = |span: &NodeSpan| {
+            owner(span).is_none()
+                && !members
+                    .iter()
+                    .any(|(scope, name_end)| within(span, scope) && span.start >= *name_end)
+        };
+        let declarations: Vec<(NodeSpan, Value)> = outline
+            .iter()
+            .filter(|m| m["metaVariables"]["single"]["RECEIVER"].is_null())
+            .filter_map(|m| {
+                let name_node = &m["metaVariables"]["single"]["NAME"];
+                let span = NodeSpan::from_match(name_node)?;
+                Some((span, name_node["range"].clone()))
+            })
+            .filter(|(span, _)| span.text == name && top_level(span))
+            .collect();
+
+        let mut references: Vec<(NodeSpan, Value, &'static str)> = Vec::new();
+        let mut shadowed = Vec::new();
+        for (span, range) in declarations.iter().chain(&bindings).chain(&uses) {
+            if references
+                .iter()
+                .any(|(seen, _, _)| seen.start == span.start)
+            {
+                continue;
+            }
+            let is_binding = bindings.iter().any(|(bound, _)| bound.start == span.start);
+            let is_declaration = declarations
+                .iter()
+                .any(|(declared, _)| declared.start == span.start)
+                || (is_binding && top_level(span));
+            if let Some(scope) = shadowed_at(span).filter(|_| !is_declaration) {
+                shadowed.push((span.clone(), (*scope).clone()));
+                continue;
+            }
+            // A member of a type with the same name
+            if !is_declaration && is_binding {
+                continue;
+            }
+            let kind = if is_declaration {
+                "declaration"
+            } else if edit_utils::is_write_access(source, span) {
+                "write"
+            } else if source[span.end..]
+                .trim_start_matches([' ', '\t'])
+                .starts_with('(')
+            {
+                "call"
+            } else {
+                "read"
+            };
+            references.push((span.clone(), range.clone(), kind));
+        }
+        references.sort_by_key(|(span, _, _)| span.start);
+        Ok((references, shadowed))
+    }
+
+    /// Export a Go declaration by capitalizing its name, or unexport it by
+    /// lowering it, and rename its uses in the file. Top-level functions,
+    /// types, vars and consts are renamed through `collect_references`, so
+    /// locals of the same name are left alone. Fields and methods are
+    /// renamed wherever a member of that name is used, which is only safe
+    /// when no other type in the file has one; that case is refused.
+    async fn toggle_go_export(&self, args: Value) -> Result<String> {
+        let owner_type = args["type"].as_str();
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        // Each declared name: its kind, the type it belongs to, and its span
+        let scan = |rule: String| {
+            let source = &source;
+            let path = path.as_deref();
+            async move {
+                Ok::<Vec<Value>, anyhow::Error>(
+                    self.scan_source_json(&rule, source, path, language).await?,
+                )
+            }
+        };
+        let name_rule = |id: &str, kind: &str, parent: &str| {
+            format!("id: {id}\nlanguage: go\nrule:\n  kind: {kind}\n  inside: {{ any: [{parent}], field: name }}\n")
+        };
+        let spans = |matches: Vec<Value>| -> Vec<NodeSpan> {
+            matches.iter().filter_map(NodeSpan::from_match).collect()
+        };
+        let types: Vec<(NodeSpan, NodeSpan)> = scan(
+            "id: go-types\nlanguage: go\nrule:\n  any: [{ kind: type_spec }, { kind: type_alias }]\n  has: { field: name, pattern: $NAME }\n".to_string(),
+        )
+        .await?
+        .iter()
+        .filter_map(|m| {
+            Some((
+                NodeSpan::from_match(m)?,
+                NodeSpan::from_match(&m["metaVariables"]["single"]["NAME"])?,
+            ))
+        })
+        .collect();
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let in_function = |span: &NodeSpan| {
+            functions
+                .iter()
+                .any(|(_, function)| function.start <= span.start && span.end <= function.end)
+        };
+        let owner_of = |span: &NodeSpan| {
+            types
+                .iter()
+                .filter(|(spec, _)| spec.start <= span.start && span.end <= spec.end)
+                .min_by_key(|(spec, _)| spec.end - spec.start)
+                .map(|(_, name)| name.text.clone())
+        };
+
+        let mut declared: Vec<(&str, Option<String>, NodeSpan)> = Vec::new();
+        for span in spans(
+            scan(name_rule(
+                "go-functions",
+                "identifier",
+                "{ kind: function_declaration }",
+            ))
+            .await?,
+        ) {
+            declared.push(("function", None, span));
+        }
+        for (_, name) in &types {
+            if !in_function(name) {
+                declared.push(("type", None, name.clone()));
+            }
+        }
+        for kind in ["var", "const"] {
+            let rule = name_rule(
+                "go-values",
+                "identifier",
+                &format!("{{ kind: {kind}_spec }}"),
+            );
+            for span in spans(scan(rule).await?) {
+                if !in_function(&span) {
+                    declared.push((kind, None, span));
+                }
+            }
+        }
+        for span in spans(
+            scan(name_rule(
+                "go-fields",
+                "field_identifier",
+                "{ kind: field_declaration }",
+            ))
+            .await?,
+        ) {
+            let owner = owner_of(&span);
+            declared.push(("field", owner, span));
+        }
+        for m in scan(
+            "id: go-methods\nlanguage: go\nrule:\n  kind: method_declaration\n  all:\n    - has: { field: receiver, pattern: $RECEIVER }\n    - has: { field: name, pattern: $NAME }\n".to_string(),
+        )
+        .await?
+        {
+            let (Some(receiver), Some(name)) = (
+                m["metaVariables"]["single"]["RECEIVER"]["text"].as_str(),
+                NodeSpan::from_match(&m["metaVariables"]["single"]["NAME"]),
+            ) else {
+                continue;
+            };
+            let receiver_type = edit_utils::receiver_type(receiver)
+                .split('[')
+                .next()
+                .map(|name| name.trim().to_string());
+            declared.push(("method", receiver_type, name));
+        }
+        // Interface methods count as other members with the name
+        let interface_methods = spans(
+            scan("id: go-interface-methods\nlanguage: go\nrule:\n  kind: field_identifier\n  inside: { kind: interface_type, stopBy: end }\n".to_string())
+                .await?,
+        );
+
+        let candidates: Vec<&(&str, Option<String>, NodeSpan)> = match args["name"].as_str() {
+            Some(name) => declared
+                .iter()
+                .filter(|(_, owner, span)| {
+                    span.text == name
+                        && owner_type.is_none_or(|wanted| owner.as_deref() == Some(wanted))
+                })
+                .collect(),
+            None => {
+                let (start, end) = self.get_target_range(&args, &source)?;
+                declared
+                    .iter()
+                    .filter(|(_, _, span)| span.start <= start && end <= span.end)
+                    .collect()
+            }
+        };
+        let (kind, owner, declaration) = match candidates.as_slice() {
+            [only] => *only,
+            [] => {
+                return Err(match args["name"].as_str() {
+                    Some(name) => anyhow!("No declaration of '{}' found", name),
+                    None => anyhow!("No declared name at the position"),
+                })
+            }
+            _ => {
+                let lines: Vec<String> = candidates
+                    .iter()
+                    .map(|(_, _, span)| edit_utils::line_number(&source, span.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "'{}' is declared on lines {}; pass type or position to choose one",
+                    candidates[0].2.text,
+                    lines.join(", ")
+                ));
+            }
+        };
+        let name = declaration.text.as_str();
+        let member = matches!(*kind, "field" | "method");
+        if *kind == "function" && matches!(name, "main" | "init") {
+            return Err(anyhow!(
+                "{} is called by the Go runtime and must keep its name",
+                name
+            ));
+        }
+        let new_name = edit_utils::toggle_export_case(name).ok_or_else(|| {
+            anyhow!(
+                "'{}' does not start with a letter, so it has no case to toggle",
+                name
+            )
+        })?;
+        let exporting = new_name.starts_with(|c: char| c.is_uppercase());
+
+        let taken = spans(
+            scan(format!(
+                "id: go-new-name\nlanguage: go\nrule:\n  any: [{{ kind: identifier }}, {{ kind: type_identifier }}, {{ kind: field_identifier }}]\n  regex: ^{new_name}$\n"
+            ))
+            .await?,
+        );
+        if let Some(span) = taken.first() {
+            return Err(anyhow!(
+                "'{}' is already used on line {}; rename it first",
+                new_name,
+                edit_utils::line_number(&source, span.start)
+            ));
+        }
+
+        let line_of = |span: &NodeSpan| edit_utils::line_number(&source, span.start);
+        let line_text = |span: &NodeSpan| {
+            let start = edit_utils::line_start(&source, span.start);
+            let end = edit_utils::line_end(&source, span.start);
+            source[start..end].trim().to_string()
+        };
+        // Composite literal keys name a field, or a value in a map literal
+        let keys: Vec<NodeSpan> = spans(
+            scan(format!(
+                "id: go-keys\nlanguage: go\nrule:\n  kind: identifier\n  regex: ^{name}$\n  inside: {{ kind: literal_element, inside: {{ kind: keyed_element }} }}\n"
+            ))
+            .await?,
+        )
+        .into_iter()
+        .filter(|span| source[span.end..].trim_start().starts_with(':'))
+        .collect();
+
+        let mut renamed: Vec<NodeSpan> = Vec::new();
+        let mut skipped = Vec::new();
+        if member {
+            let others: Vec<&NodeSpan> = declared
+                .iter()
+                .filter(|(other_kind, _, span)| {
+                    matches!(*other_kind, "field" | "method")
+                        && span.text == name
+                        && span.start != declaration.start
+                })
+                .map(|(_, _, span)| span)
+                .chain(interface_methods.iter().filter(|span| span.text == name))
+                .collect();
+            if let Some(other) = others.first() {
+                return Err(anyhow!(
+                    "Another type has a member named {} (line {}), so the uses of each cannot be told apart",
+                    name,
+                    line_of(other)
+                ));
+            }
+            if declared.iter().any(|(other_kind, _, span)| {
+                !matches!(*other_kind, "field" | "method") && span.text == name
+            }) {
+                return Err(anyhow!(
+                    "'{}' also names a top-level declaration, so its uses cannot be told apart from the {}",
+                    name,
+                    kind
+                ));
+            }
+            // `pkg.Name` is a member of an imported package, not of a type
+            let packages: Vec<String> = spans(
+                scan("id: go-imports\nlanguage: go\nrule:\n  kind: import_spec\n".to_string())
+                    .await?,
+            )
+            .iter()
+            .filter_map(|spec| {
+                let words: Vec<&str> = spec.text.split_whitespace().collect();
+                match words.as_slice() {
+                    [path] => path
+                        .trim_matches(['"', '`'])
+                        .rsplit('/')
+                        .next()
+                        .map(str::to_string),
+                    [alias, _] => Some(alias.to_string()),
+                    _ => None,
+                }
+            })
+            .collect();
+            let qualifier = |span: &NodeSpan| {
+                let before = source[..span.start]
+                    .trim_end()
+                    .strip_suffix('.')?
+                    .trim_end();
+                let start = before
+                    .rfind(|c: char| !(c.is_alphanumeric() || c == '_'))
+                    .map_or(0, |i| i + 1);
+                Some(&before[start..])
+            };
+            renamed.extend(
+                spans(
+                    scan(format!(
+                        "id: go-member-uses\nlanguage: go\nrule:\n  kind: field_identifier\n  regex: ^{name}$\n"
+                    ))
+                    .await?,
+                )
+                .into_iter()
+                .filter(|span| {
+                    !qualifier(span).is_some_and(|qualifier| packages.iter().any(|package| package == qualifier))
+                }),
+            );
+            if *kind == "field" {
+                renamed.extend(keys.iter().cloned());
+            }
+        } else {
+            let (references, _) = self
+                .collect_references(&source, path.as_deref(), language, name)
+                .await?;
+            for (span, _, _) in references {
+                if keys.contains(&span) {
+                    skipped.push(serde_json::json!({
+                        "line": line_of(&span),
+                        "text": line_text(&span),
+                        "reason": "a composite literal key may name a struct field rather than the declaration",
+                    }));
+                } else {
+                    renamed.push(span);
+                }
+            }
+        }
+        renamed.sort_by_key(|span| span.start);
+        renamed.dedup_by_key(|span| span.start);
+
+        let mut edits: Vec<TextEdit> = renamed
+            .iter()
+            .map(|span| TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement: new_name.clone(),
+            })
+            .collect();
+        // A doc comment starts with the name it documents
+        if let Some((start, _)) =
+            edit_utils::leading_comment_range(&source, declaration.start, "//")
+                .filter(|(_, end)| *end == edit_utils::line_start(&source, declaration.start))
+        {
+            let comment = &source[start..];
+            let indent = comment.len() - comment.trim_start().len();
+            let prefix = format!("// {name}");
+            if comment[indent..].starts_with(&prefix)
+                && !comment[indent + prefix.len()..]
+                    .starts_with(|c: char| c.is_alphanumeric() || c == '_')
+            {
+                let at = start + indent + "// ".len();
+                edits.push(TextEdit {
+                    start: at,
+                    end: at + name.len(),
+                    replacement: new_name.clone(),
+                });
+            }
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let mut warnings = vec![
+            "Only this file is renamed; uses in other files of the package are not".to_string(),
+        ];
+        if member && renamed.len() > 1 {
+            warnings.push(format!(
+                "Members are matched by name: check that each renamed use of {} is not a member of a type from another package",
+                name
+            ));
+        }
+        if !exporting {
+            warnings.push(format!(
+                "Code in other packages that uses {} will no longer compile",
+                name
+            ));
+            match *kind {
+                "method" => warnings.push(format!(
+                    "{} may no longer satisfy interfaces that require {}",
+                    owner.as_deref().unwrap_or("The type"),
+                    name
+                )),
+                "field" => warnings.push(
+                    "encoding/json and other reflection-based packages skip unexported fields"
+                        .to_string(),
+                ),
+                _ => {}
+            }
+        }
+        // Other files of the package that mention the old name
+        let mut mentioned_in = Vec::new();
+        if let Some(directory) = path.as_ref().and_then(|path| path.parent()) {
+            let word = regex::Regex::new(&format!(r"\b{name}\b"))?;
+            let mut entries = tokio::fs::read_dir(directory).await?;
+            while let Some(entry) = entries.next_entry().await? {
+                let other = entry.path();
+                if Some(&other) == path.as_ref()
+                    || other.extension().and_then(|extension| extension.to_str()) != Some("go")
+                {
+                    continue;
+                }
+                if let Ok(text) = tokio::fs::read_to_string(&other).await {
+                    if word.is_match(&text) {
+                        mentioned_in.push(other.display().to_string());
+                    }
+                }
+            }
+            mentioned_in.sort();
+        }
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "kind": kind,
+            "type": owner,
+            "name": name,
+            "new_name": new_name,
+            "exported": exporting,
+            "renamed": renamed
+                .iter()
+                .map(|span| serde_json::json!({"line": line_of(span), "text": line_text(span)}))
+                .collect::<Vec<_>>(),
+            "skipped": skipped,
+            "warnings": warnings,
+            "mentioned_in": mentioned_in,
+        });
+        let result = self
+            .finish_edit(result, path, &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Switch a Go method between a value and a pointer receiver. Going to
+    /// a value receiver, assignments through the receiver in the body are
+    /// reported, since they would then change a copy.
+    async fn convert_go_receiver(&self, args: Value) -> Result<String> {
+        let style = args["style"].as_str().ok_or(anyhow!("Missing style"))?;
+        if !matches!(style, "pointer" | "value") {
+            return Err(anyhow!(
+                "Unknown style: {}. Use 'pointer' or 'value'",
+                style
+            ));
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let display_name = function_name
+            .clone()
+            .or_else(|| edit_utils::guess_function_name(&function.text))
+            .unwrap_or_else(|| "the function".to_string());
+        let receiver_field = self.field_name(language, "RECEIVER")?;
+        let receiver = self
+            .function_field(
+                &source,
+                path.as_deref(),
+                language,
+                function,
+                &receiver_field,
+            )
+            .await?
+            .ok_or_else(|| anyhow!("{} is not a method", display_name))?;
+        let (receiver_name, type_offset) = edit_utils::go_receiver_parts(&receiver.text)
+            .ok_or_else(|| anyhow!("Could not parse the receiver {}", receiver.text))?;
+        let type_start = receiver.start + type_offset;
+        let is_pointer = source[type_start..].starts_with('*');
+
+        let mut mutations = Vec::new();
+        let edit = if style == "pointer" {
+            if is_pointer {
+                return Err(anyhow!("{} already has a pointer receiver", display_name));
+            }
+            TextEdit {
+                start: type_start,
+                end: type_start,
+                replacement: "*".to_string(),
+            }
+        } else {
+            if !is_pointer {
+                return Err(anyhow!("{} already has a value receiver", display_name));
+            }
+            let after_star = &source[type_start + 1..];
+            let star_end = source.len() - after_star.trim_start().len();
+
+            // Writes through the receiver would be lost on a copy
+            if let Some(name) = receiver_name.filter(|name| *name != "_") {
+                let through_receiver = regex::Regex::new(&format!(
+                    r"^(\*\s*{0}\b|\(\s*\*\s*{0}\s*\)|{0}\s*[.\[])",
+                    regex::escape(name)
+                ))?;
+                let write_rule = "id: go-writes\nlanguage: go\nrule:\n  any:\n    - kind: assignment_statement\n      has: { field: left, pattern: $LEFT }\n    - kind: inc_statement\n    - kind: dec_statement\n";
+                for m in self
+                    .scan_source_json(write_rule, &source, path.as_deref(), language)
+                    .await?
+                {
+                    let Some(span) = NodeSpan::from_match(&m) else {
+                        continue;
+                    };
+                    if span.start < function.start || function.end < span.end {
+                        continue;
+                    }
+                    let written = m["metaVariables"]["single"]["LEFT"]["text"]
+                        .as_str()
+                        .unwrap_or_else(|| span.text.trim_end_matches(['+', '-']));
+                    if written
+                        .split(',')
+                        .any(|target| through_receiver.is_match(target.trim()))
+                    {
+                        mutations.push(serde_json::json!({
+                            "line": edit_utils::line_number(&source, span.start),
+                            "text": span.text,
+                        }));
+                    }
+                }
+            }
+            TextEdit {
+                start: type_start,
+                end: star_end,
+                replacement: String::new(),
+            }
+        };
+        let receiver_end = receiver.end + edit.replacement.len() - (edit.end - edit.start);
+        let edits = vec![edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let new_receiver = &new_source[receiver.start..receiver_end];
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": display_name,
+            "receiver": new_receiver,
+            "mutations": mutations,
+            "warning": if mutations.is_empty() {
+                None
+            } else {
+                Some(format!(
+                    "{} assigns through its receiver {} time(s); with a value receiver those changes are made to a copy and lost",
+                    display_name,
+                    mutations.len()
+                ))
+            },
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Rename the receivers of a Go type's methods to one name: `name`, or
+    /// else the one most of its methods use. Each receiver is renamed with
+    /// its uses in that method's body; methods whose body redeclares the
+    /// old name, or already uses the new one, are reported instead.
+    async fn go_receiver_names(&self, args: Value) -> Result<String> {
+        let type_name = args["type"].as_str().ok_or(anyhow!("Missing type"))?;
+        let chosen = args["name"].as_str();
+        if let Some(name) = chosen {
+            if name == "_" || !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(name) {
+                return Err(anyhow!("'{}' is not a valid receiver name", name));
+            }
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let receiver_field = self.field_name(language, "RECEIVER")?;
+        let name_field = self.field_name(language, "NAME")?;
+        let body_field = self.field_name(language, "BODY")?;
+        let method_rule = format!(
+            "id: receiver-methods\nlanguage: go\nrule:\n  kind: method_declaration\n  all:\n    - has: {{ field: {receiver_field}, pattern: $RECEIVER }}\n    - has: {{ field: {name_field}, pattern: $NAME }}\n    - has: {{ field: {body_field}, pattern: $BODY }}\n"
+        );
+        let capture = |m: &Value, name: &str| {
+            let single = &m["metaVariables"]["single"][name];
+            let range = &single["range"]["byteOffset"];
+            Some(NodeSpan {
+                start: range["start"].as_u64()? as usize,
+                end: range["end"].as_u64()? as usize,
+                text: single["text"].as_str()?.to_string(),
+            })
+        };
+        // Each method of the type: its name, its span, where its receiver
+        // name starts and what it is, and its body
+        let mut methods: Vec<(String, NodeSpan, Option<(usize, String)>, NodeSpan)> = Vec::new();
+        for m in self
+            .scan_source_json(&method_rule, &source, path.as_deref(), language)
+            .await?
+        {
+            let (Some(method), Some(receiver), Some(name), Some(body)) = (
+                NodeSpan::from_match(&m),
+                capture(&m, "RECEIVER"),
+                capture(&m, "NAME"),
+                capture(&m, "BODY"),
+            ) else {
+                continue;
+            };
+            // Generic receivers such as `(l *List[T])` name the type `List`
+            let receiver_type = edit_utils::receiver_type(&receiver.text);
+            if receiver_type.split('[').next().map(str::trim) != Some(type_name) {
+                continue;
+            }
+            let receiver_name = edit_utils::go_receiver_parts(&receiver.text)
+                .and_then(|(name, _)| name)
+                .filter(|name| *name != "_")
+                .and_then(|name| {
+                    let open = receiver.text.find('(')?;
+                    let offset = open + receiver.text[open..].find(name)?;
+                    Some((receiver.start + offset, name.to_string()))
+                });
+            methods.push((name.text, method, receiver_name, body));
+        }
+        methods.sort_by_key(|(_, method, _, _)| method.start);
+        methods.dedup_by_key(|(_, method, _, _)| method.start);
+        if methods.is_empty() {
+            return Err(anyhow!("No methods of {} found", type_name));
+        }
+
+        let mut counts: std::collections::BTreeMap<&str, usize> = std::collections::BTreeMap::new();
+        for (_, _, receiver_name, _) in &methods {
+            if let Some((_, name)) = receiver_name {
+                *counts.entry(name.as_str()).or_default() += 1;
+            }
+        }
+        // Ties go to the name of the earliest method
+        let mut majority: Option<&str> = None;
+        for (_, _, receiver_name, _) in &methods {
+            if let Some((_, name)) = receiver_name {
+                if majority.map_or(true, |best| counts[name.as_str()] > counts[best]) {
+                    majority = Some(name.as_str());
+                }
+            }
+        }
+        let target = chosen
+            .or(majority)
+            .ok_or_else(|| anyhow!("No method of {} names its receiver", type_name))?
+            .to_string();
+
+        let mut names: Vec<&str> = counts.keys().copied().collect();
+        if !names.contains(&target.as_str()) {
+            names.push(&target);
+        }
+        let names = names
+            .iter()
+            .map(|name| regex::escape(name))
+            .collect::<Vec<_>>()
+            .join("|");
+        let identifier_rule = format!(
+            "id: receiver-uses\nlanguage: go\nrule:\n  kind: identifier\n  regex: ^({names})$\n"
+        );
+        let identifiers: Vec<NodeSpan> = self
+            .scan_source_json(&identifier_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let bindings = self
+            .get_binding_rules(language)?
+            .iter()
+            .map(|rule| format!("    - {rule}\n"))
+            .collect::<String>();
+        let binding_rule = format!(
+            "id: receiver-shadows\nlanguage: go\nrule:\n  regex: ^({names})$\n  any:\n{bindings}"
+        );
+        let declared: Vec<NodeSpan> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        let mut edits = Vec::new();
+        let mut renamed = Vec::new();
+        let mut skipped = Vec::new();
+        for (method_name, method, receiver_name, body) in &methods {
+            let Some((name_start, name)) = receiver_name else {
+                continue;
+            };
+            if *name == target {
+                continue;
+            }
+            let line = edit_utils::line_number(&source, method.start);
+            let reason = if identifiers
+                .iter()
+                .any(|span| span.text == target && within(span, method))
+            {
+                Some(format!("the method already uses the name {target}"))
+            } else if declared
+                .iter()
+                .any(|span| span.text == *name && within(span, body))
+            {
+                Some(format!("the body declares another {name}"))
+            } else {
+                None
+            };
+            if let Some(reason) = reason {
+                skipped.push(serde_json::json!({
+                    "method": method_name,
+                    "line": line,
+                    "receiver": name,
+                    "reason": reason,
+                }));
+                continue;
+            }
+            let uses: Vec<&NodeSpan> = identifiers
+                .iter()
+                .filter(|span| span.text == *name && within(span, body))
+                .collect();
+            edits.push(TextEdit {
+                start: *name_start,
+                end: name_start + name.len(),
+                replacement: target.clone(),
+            });
+            edits.extend(uses.iter().map(|span| TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement: target.clone(),
+            }));
+            renamed.push(serde_json::json!({
+                "method": method_name,
+                "line": line,
+                "receiver": name,
+                "uses": uses.len(),
+            }));
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "type": type_name,
+            "name": target,
+            "names": counts,
+            "renamed": renamed,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Declare an interface holding the method signatures of a Go type:
+    /// its exported methods, or all of them with `include_unexported`. The
+    /// declaration goes after the type's, or before its first method when
+    /// the type is declared elsewhere, with the methods' doc comments.
+    /// `assertion` adds `var _ Interface = (*Type)(nil)` below it.
+    async fn extract_go_interface(&self, args: Value) -> Result<String> {
+        let type_name = args["type"].as_str().ok_or(anyhow!("Missing type"))?;
+        let interface = args["name"].as_str().ok_or(anyhow!("Missing name"))?;
+        let include_unexported = args["include_unexported"].as_bool().unwrap_or(false);
+        let assertion = args["assertion"].as_bool().unwrap_or(false);
+        if interface == "_" || !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(interface)
+        {
+            return Err(anyhow!("'{}' is not a valid interface name", interface));
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let receiver_field = self.field_name(language, "RECEIVER")?;
+        let name_field = self.field_name(language, "NAME")?;
+        let body_field = self.field_name(language, "BODY")?;
+        let method_rule = format!(
+            "id: interface-methods\nlanguage: go\nrule:\n  kind: method_declaration\n  all:\n    - has: {{ field: {receiver_field}, pattern: $RECEIVER }}\n    - has: {{ field: {name_field}, pattern: $NAME }}\n    - has: {{ field: {body_field}, pattern: $BODY }}\n"
+        );
+        let capture =
+            |m: &Value, name: &str| NodeSpan::from_match(&m["metaVariables"]["single"][name]);
+        // Each method of the type: its span, receiver, name and body
+        let mut methods: Vec<(NodeSpan, NodeSpan, NodeSpan, NodeSpan)> = Vec::new();
+        for m in self
+            .scan_source_json(&method_rule, &source, path.as_deref(), language)
+            .await?
+        {
+            let (Some(method), Some(receiver), Some(name), Some(body)) = (
+                NodeSpan::from_match(&m),
+                capture(&m, "RECEIVER"),
+                capture(&m, "NAME"),
+                capture(&m, "BODY"),
+            ) else {
+                continue;
+            };
+            let receiver_type = edit_utils::receiver_type(&receiver.text);
+            if receiver_type.split('[').next().map(str::trim) != Some(type_name) {
+                continue;
+            }
+            if receiver_type.contains('[') {
+                return Err(anyhow!(
+                    "{} is generic; its interface would need type parameters, which extract_go_interface does not add",
+                    type_name
+                ));
+            }
+            methods.push((method, receiver, name, body));
+        }
+        methods.sort_by_key(|(method, _, _, _)| method.start);
+        methods.dedup_by_key(|(method, _, _, _)| method.start);
+        if methods.is_empty() {
+            return Err(anyhow!("No methods of {} found", type_name));
+        }
+
+        let type_rule =
+            format!("id: type-specs\nlanguage: go\nrule:\n  kind: type_spec\n  has: {{ field: {name_field}, pattern: $NAME }}\n");
+        let type_specs: Vec<(String, NodeSpan)> = self
+            .scan_source_json(&type_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| Some((capture(m, "NAME")?.text, NodeSpan::from_match(m)?)))
+            .collect();
+        if type_specs.iter().any(|(name, _)| name == interface) {
+            return Err(anyhow!("A type named '{}' already exists", interface));
+        }
+        let declaration = match type_specs.iter().find(|(name, _)| name == type_name) {
+            Some((_, spec)) => self
+                .scan_source_json(
+                    "id: type-declarations\nlanguage: go\nrule:\n  kind: type_declaration\n",
+                    &source,
+                    path.as_deref(),
+                    language,
+                )
+                .await?
+                .iter()
+                .filter_map(NodeSpan::from_match)
+                .find(|declaration| declaration.start <= spec.start && spec.end <= declaration.end),
+            None => None,
+        };
+
+        // A doc comment is the run of // lines right above a method
+        let doc_start = |method: &NodeSpan| {
+            let line = edit_utils::line_start(&source, method.start);
+            edit_utils::leading_comment_range(&source, method.start, "//")
+                .filter(|(_, end)| *end == line)
+                .map_or(line, |(start, _)| start)
+        };
+        let indent = edit_utils::indent_unit(&source);
+        let line_break = regex::Regex::new(r"\s*\n\s*")?;
+        let mut lines = Vec::new();
+        let mut extracted = Vec::new();
+        let mut skipped = Vec::new();
+        for (method, receiver, name, body) in &methods {
+            let line = edit_utils::line_number(&source, method.start);
+            if !include_unexported && !name.text.starts_with(|c: char| c.is_uppercase()) {
+                skipped.push(serde_json::json!({
+                    "line": line,
+                    "text": name.text,
+                    "reason": "The method is unexported; set include_unexported to include it.",
+                }));
+                continue;
+            }
+            // Parameters split over lines are joined onto one
+            let signature = line_break
+                .replace_all(source[name.start..body.start].trim(), " ")
+                .replace("( ", "(")
+                .replace(", )", ")");
+            let doc = &source[doc_start(method)..edit_utils::line_start(&source, method.start)];
+            for comment in doc.lines() {
+                lines.push(format!("{indent}{}", comment.trim()));
+            }
+            lines.push(format!("{indent}{signature}"));
+            extracted.push(serde_json::json!({
+                "name": name.text,
+                "line": line,
+                "signature": signature,
+                "pointer_receiver": edit_utils::go_receiver_parts(&receiver.text)
+                    .is_some_and(|(_, offset)| receiver.text[offset..].starts_with('*')),
+            }));
+        }
+        if extracted.is_empty() {
+            return Err(anyhow!(
+                "{} has no exported methods; set include_unexported to include the others",
+                type_name
+            ));
+        }
+        let pointer_receivers = extracted
+            .iter()
+            .any(|method| method["pointer_receiver"] == true);
+
+        let mut declaration_text =
+            format!("type {interface} interface {{\n{}\n}}", lines.join("\n"));
+        if assertion {
+            declaration_text.push_str(&format!("\n\nvar _ {interface} = (*{type_name})(nil)"));
+        }
+        let edit = match &declaration {
+            Some(declaration) => {
+                let at = edit_utils::line_end(&source, declaration.end);
+                let leading = if source[..at].ends_with('\n') {
+                    "\n"
+                } else {
+                    "\n\n"
+                };
+                TextEdit {
+                    start: at,
+                    end: at,
+                    replacement: format!("{leading}{declaration_text}\n"),
+                }
+            }
+            None => {
+                let at = doc_start(&methods[0].0);
+                TextEdit {
+                    start: at,
+                    end: at,
+                    replacement: format!("{declaration_text}\n\n"),
+                }
+            }
+        };
+        let edits = vec![edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "interface": interface,
+            "type": type_name,
+            "methods": extracted,
+            "skipped": skipped,
+            // Methods with pointer receivers are only in *T's method set
+            "implemented_by": if pointer_receivers {
+                format!("*{type_name}")
+            } else {
+                type_name.to_string()
+            },
+            "declaration": declaration_text,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Turn a Go func literal into a named top-level function, leaving a
+    /// reference to it in its place. Variables the literal captures from
+    /// the functions around it are reported, and either refused or, with
+    /// `captured: "parameters"`, passed in as leading parameters.
+    async fn hoist_go_closure(&self, args: Value) -> Result<String> {
+        let name = args["name"].as_str().ok_or(anyhow!("Missing name"))?;
+        let captured_mode = args["captured"].as_str().unwrap_or("refuse");
+        if !matches!(captured_mode, "refuse" | "parameters") {
+            return Err(anyhow!(
+                "Unknown captured: {}. Use 'refuse' or 'parameters'",
+                captured_mode
+            ));
+        }
+        if name == "_" || !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(name) {
+            return Err(anyhow!("'{}' is not a valid function name", name));
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        let literal = functions
+            .iter()
+            .filter(|(function_name, span)| {
+                function_name.is_none() && span.start <= start && end <= span.end
+            })
+            .map(|(_, span)| span)
+            .min_by_key(|span| span.end - span.start)
+            .ok_or_else(|| anyhow!("No func literal contains the position"))?;
+        // The functions around the literal, innermost first
+        let mut enclosing: Vec<&(Option<String>, NodeSpan)> = functions
+            .iter()
+            .filter(|(_, span)| {
+                span.start <= literal.start
+                    && literal.end <= span.end
+                    && (span.start, span.end) != (literal.start, literal.end)
+            })
+            .collect();
+        enclosing.sort_by_key(|(_, span)| span.end - span.start);
+        let top = enclosing.last().map(|(_, span)| span);
+        let top_name = enclosing
+            .last()
+            .and_then(|(function_name, _)| function_name.clone());
+        let display_name = top_name
+            .clone()
+            .or_else(|| top.and_then(|top| edit_utils::guess_function_name(&top.text)))
+            .unwrap_or_else(|| "the enclosing function".to_string());
+        if top.is_some_and(|top| {
+            regex::Regex::new(r"^func\s*(\([^)]*\)\s*)?\w+\s*\[")
+                .is_ok_and(|generic| generic.is_match(&top.text))
+        }) {
+            return Err(anyhow!(
+                "{} is generic; a func literal cannot be hoisted out of it",
+                display_name
+            ));
+        }
+        if functions.iter().any(|(function_name, span)| {
+            function_name.as_deref() == Some(name)
+                && span.text.starts_with("func ")
+                && !span.text["func ".len()..].trim_start().starts_with('(')
+        }) {
+            return Err(anyhow!("A function named '{}' already exists", name));
+        }
+
+        let field = |function: &NodeSpan, field: &'static str| {
+            let function = function.clone();
+            let source = &source;
+            let path = path.as_deref();
+            async move {
+                self.function_field(source, path, language, &function, field)
+                    .await
+            }
+        };
+        let body = field(literal, "body")
+            .await?
+            .ok_or_else(|| anyhow!("The func literal has no body"))?;
+        let params = field(literal, "parameters")
+            .await?
+            .ok_or_else(|| anyhow!("The func literal has no parameter list"))?;
+        let result = field(literal, "result").await?;
+        let signature = source[literal.start + "func".len()..body.start].trim();
+
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        let bindings: Vec<NodeSpan> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| top.is_some_and(|top| within(span, top)))
+            .collect();
+        let reads: Vec<NodeSpan> = self
+            .scan_source_json(&read_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| top.is_some_and(|top| within(span, top)))
+            .collect();
+        if bindings.iter().chain(&reads).any(|span| span.text == name) {
+            return Err(anyhow!(
+                "'{}' is already a name in {}; choose another",
+                name,
+                display_name
+            ));
+        }
+
+        // Captured: names the literal reads but does not bind, which the
+        // functions around it bind
+        let bound_inside: std::collections::HashSet<&str> = bindings
+            .iter()
+            .filter(|span| within(span, literal))
+            .map(|span| span.text.as_str())
+            .collect();
+        let bound_outside: std::collections::HashSet<&str> = bindings
+            .iter()
+            .filter(|span| !within(span, literal) && Some(&span.text) != top_name.as_ref())
+            .map(|span| span.text.as_str())
+            .collect();
+        let mut captured: Vec<(String, usize)> = Vec::new();
+        for span in reads.iter().filter(|span| within(span, literal)) {
+            if span.text != "_"
+                && !bound_inside.contains(span.text.as_str())
+                && bound_outside.contains(span.text.as_str())
+                && !captured.iter().any(|(captured, _)| *captured == span.text)
+            {
+                captured.push((
+                    span.text.clone(),
+                    edit_utils::line_number(&source, span.start),
+                ));
+            }
+        }
+
+        let mut outer_params: Vec<String> = Vec::new();
+        for (_, function) in &enclosing {
+            for field_name in ["receiver", "parameters", "result"] {
+                if let Some(list) = field(function, field_name).await? {
+                    outer_params.push(list.text);
+                }
+            }
+        }
+        let outer_params: Vec<&str> = outer_params.iter().map(String::as_str).collect();
+        let head = &source[top.map_or(literal.start, |top| top.start)..literal.start];
+        let mut captured_types: Vec<Option<String>> = Vec::new();
+        for (captured_name, _) in &captured {
+            captured_types.push(
+                args["types"][captured_name]
+                    .as_str()
+                    .map(|captured_type| captured_type.to_string())
+                    .or_else(|| self.go_declared_type(head, &outer_params, captured_name)),
+            );
+        }
+        let captured_json: Vec<Value> = captured
+            .iter()
+            .zip(&captured_types)
+            .map(|((captured_name, line), captured_type)| {
+                serde_json::json!({"name": captured_name, "type": captured_type, "line": line})
+            })
+            .collect();
+
+        if !captured.is_empty() {
+            let listed = captured
+                .iter()
+                .map(|(captured_name, line)| format!("{} (line {})", captured_name, line))
+                .collect::<Vec<_>>()
+                .join(", ");
+            if captured_mode == "refuse" {
+                return Err(anyhow!(
+                    "The func literal captures {} from {}; set captured to 'parameters' to pass them in",
+                    listed,
+                    display_name
+                ));
+            }
+            let untyped: Vec<&str> = captured
+                .iter()
+                .zip(&captured_types)
+                .filter(|(_, captured_type)| captured_type.is_none())
+                .map(|((captured_name, _), _)| captured_name.as_str())
+                .collect();
+            if !untyped.is_empty() {
+                return Err(anyhow!(
+                    "Cannot tell the type of {}; give it in types",
+                    untyped.join(", ")
+                ));
+            }
+            // A parameter is a copy, so assignments to it would not reach
+            // the captured variable
+            let write_rule = "id: go-writes\nlanguage: go\nrule:\n  any:\n    - kind: assignment_statement\n      has: { field: left, pattern: $LEFT }\n    - kind: inc_statement\n    - kind: dec_statement\n";
+            for m in self
+                .scan_source_json(write_rule, &source, path.as_deref(), language)
+                .await?
+            {
+                let Some(span) = NodeSpan::from_match(&m) else {
+                    continue;
+                };
+                if !within(&span, literal) {
+                    continue;
+                }
+                let written = m["metaVariables"]["single"]["LEFT"]["text"]
+                    .as_str()
+                    .unwrap_or_else(|| span.text.trim_end_matches(['+', '-']));
+                if let Some((captured_name, _)) = captured.iter().find(|(captured_name, _)| {
+                    written
+                        .split(',')
+                        .any(|target| target.trim() == captured_name)
+                }) {
+                    return Err(anyhow!(
+                        "The func literal assigns to the captured {} on line {}; as a parameter the change would not reach {}",
+                        captured_name,
+                        edit_utils::line_number(&source, span.start),
+                        display_name
+                    ));
+                }
+            }
+        }
+
+        let literal_params = edit_utils::go_results(&params.text);
+        let literal_params = match params.text.trim() == "()" {
+            true => Vec::new(),
+            false => literal_params,
+        };
+        let typed_captures: Vec<String> = captured
+            .iter()
+            .zip(&captured_types)
+            .map(|((captured_name, _), captured_type)| {
+                format!(
+                    "{} {}",
+                    captured_name,
+                    captured_type.as_deref().unwrap_or("any")
+                )
+            })
+            .collect();
+        let captured_names: Vec<&str> = captured
+            .iter()
+            .map(|(captured_name, _)| captured_name.as_str())
+            .collect();
+        if !captured.is_empty() && literal_params.iter().any(|(param, _)| param.is_none()) {
+            return Err(anyhow!(
+                "The func literal's parameters have no names, so the captured variables cannot be added to them"
+            ));
+        }
+        let new_params = match typed_captures.is_empty() {
+            true => params.text.clone(),
+            false => {
+                let inner = params.text.trim()[1..params.text.trim().len() - 1].trim();
+                match inner.is_empty() {
+                    true => format!("({})", typed_captures.join(", ")),
+                    false => format!("({}, {})", typed_captures.join(", "), inner),
+                }
+            }
+        };
+        let results = result
+            .as_ref()
+            .map(|result| format!(" {}", result.text))
+            .unwrap_or_default();
+        // At the top level the body loses the indentation of the line the
+        // literal closes on
+        let base = edit_utils::indentation_at(&source, body.end - 1);
+        let body_text = body
+            .text
+            .lines()
+            .enumerate()
+            .map(|(i, line)| match (i, line.strip_prefix(base)) {
+                (0, _) => line.to_string(),
+                (_, Some(stripped)) => stripped.to_string(),
+                (_, None) => line.trim_start().to_string(),
+            })
+            .collect::<Vec<_>>()
+            .join("\n");
+        let function_source = format!("func {name}{new_params}{results} {body_text}");
+
+        let after = &source[literal.end..];
+        let called = after.trim_start_matches([' ', '\t']).starts_with('(');
+        let (reference_end, reference) = match (called, captured.is_empty()) {
+            (_, true) => (literal.end, name.to_string()),
+            // Called in place: pass the captured variables first
+            (true, false) => {
+                let open = literal.end + after.find('(').unwrap_or(0);
+                let closes = source[open + 1..].trim_start().starts_with(')');
+                let separator = if closes { "" } else { ", " };
+                (
+                    open + 1,
+                    format!("{}({}{}", name, captured_names.join(", "), separator),
+                )
+            }
+            // Used as a value: a closure that forwards to the new function
+            (false, false) => {
+                let mut forwarded: Vec<String> = captured_names
+                    .iter()
+                    .map(|captured_name| captured_name.to_string())
+                    .collect();
+                for (param, param_type) in &literal_params {
+                    if param.as_deref() == Some("_") {
+                        return Err(anyhow!(
+                            "The func literal has a blank parameter, so it cannot be forwarded to {}",
+                            name
+                        ));
+                    }
+                    let param = param.clone().unwrap_or_default();
+                    match param_type.starts_with("...") {
+                        true => forwarded.push(format!("{param}...")),
+                        false => forwarded.push(param),
+                    }
+                }
+                let call = format!("{}({})", name, forwarded.join(", "));
+                let call = if result.is_some() {
+                    format!("return {call}")
+                } else {
+                    call
+                };
+                (literal.end, format!("func{signature} {{ {call} }}"))
+            }
+        };
+
+        let insert_at = match top {
+            Some(top) => edit_utils::line_end(&source, top.end),
+            None => edit_utils::line_end(&source, literal.end),
+        };
+        let leading = if source[..insert_at].ends_with('\n') {
+            "\n"
+        } else {
+            "\n\n"
+        };
+        let edits = vec![
+            TextEdit {
+                start: literal.start,
+                end: reference_end,
+                replacement: reference.clone(),
+            },
+            TextEdit {
+                start: insert_at,
+                end: insert_at,
+                replacement: format!("{leading}{function_source}\n"),
+            },
+        ];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": name,
+            "from": display_name,
+            "signature": format!("func {name}{new_params}{results}"),
+            "captured": captured_json,
+            "replacement": reference,
+        });
+        let result = self
+            .finish_edit(result, path, &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Inline a Go function whose body is a single `return` of one
+    /// expression: each call in the file becomes that expression with the
+    /// arguments in place of the parameters, and the function is removed
+    /// once nothing else in the file refers to it. Calls whose arguments
+    /// might have side effects and would run more or fewer times, or in a
+    /// different order, are skipped.
+    async fn inline_go_function(&self, args: Value) -> Result<String> {
+        let side_effect_free = args["side_effect_free"].as_bool().unwrap_or(false);
+        let keep_function = args["keep_function"].as_bool().unwrap_or(false);
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let name = function_name
+            .clone()
+            .ok_or_else(|| anyhow!("A func literal has no name to inline at its calls"))?;
+        if function.text["func".len()..].trim_start().starts_with('(') {
+            return Err(anyhow!(
+                "'{}' is a method; only plain functions can be inlined",
+                name
+            ));
+        }
+        if regex::Regex::new(r"^func\s+\w+\s*\[")?.is_match(&function.text) {
+            return Err(anyhow!(
+                "'{}' is generic; its type parameters cannot be inlined",
+                name
+            ));
+        }
+
+        let field = |field: &'static str| {
+            self.function_field(&source, path.as_deref(), language, function, field)
+        };
+        let body = field("body")
+            .await?
+            .ok_or_else(|| anyhow!("'{}' has no body", name))?;
+        let params = field("parameters")
+            .await?
+            .ok_or_else(|| anyhow!("'{}' has no parameter list", name))?;
+        if field("result").await?.is_none() {
+            return Err(anyhow!(
+                "'{}' returns nothing, so its calls have no value to inline",
+                name
+            ));
+        }
+        let inner = body
+            .text
+            .trim()
+            .strip_prefix('{')
+            .and_then(|text| text.strip_suffix('}'))
+            .unwrap_or_default()
+            .trim();
+        let return_rule = "id: go-returns\nlanguage: go\nrule:\n  kind: return_statement\n";
+        let statement = self
+            .scan_source_json(return_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .find(|span| body.start < span.start && span.end < body.end && span.text == inner)
+            .ok_or_else(|| anyhow!("The body of '{}' is not a single return statement", name))?;
+        let returned = &statement.text["return".len()..];
+        let expr_start =
+            statement.start + "return".len() + returned.len() - returned.trim_start().len();
+        let expr_end = statement.end;
+        let (values, _) = edit_utils::list_elements(&source, expr_start, expr_end, language);
+        if values.len() != 1 {
+            return Err(anyhow!(
+                "'{}' returns {} values; only a single expression can be inlined",
+                name,
+                values.len()
+            ));
+        }
+        let expression = &source[expr_start..expr_end];
+
+        let parameters = match params.text.trim() == "()" {
+            true => Vec::new(),
+            false => edit_utils::go_results(&params.text),
+        };
+        if parameters
+            .iter()
+            .any(|(_, param_type)| param_type.starts_with("..."))
+        {
+            return Err(anyhow!(
+                "'{}' is variadic, so its arguments cannot be matched to parameters one to one",
+                name
+            ));
+        }
+
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        let bindings: Vec<NodeSpan> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let mut reads: Vec<NodeSpan> = self
+            .scan_source_json(&read_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| !edit_utils::is_member_name(&source, span))
+            .collect();
+        reads.sort_by_key(|span| span.start);
+        let in_expression: Vec<&NodeSpan> = reads
+            .iter()
+            .filter(|span| expr_start <= span.start && span.end <= expr_end)
+            .collect();
+        if in_expression.iter().any(|span| span.text == name) {
+            return Err(anyhow!("'{}' calls itself, so it cannot be inlined", name));
+        }
+        // Each read of a parameter, with the parameter's index
+        let uses: Vec<(usize, &NodeSpan)> = in_expression
+            .iter()
+            .filter_map(|span| {
+                parameters
+                    .iter()
+                    .position(|(param, _)| param.as_deref() == Some(span.text.as_str()))
+                    .map(|index| (index, *span))
+            })
+            .collect();
+        // Other names the expression reads, which a local at a call could
+        // shadow
+        let free: std::collections::BTreeSet<&str> = in_expression
+            .iter()
+            .filter(|span| !uses.iter().any(|(_, used)| used.start == span.start))
+            .map(|span| span.text.as_str())
+            .collect();
+
+        let call_rule = format!(
+            "id: go-function-calls\nlanguage: go\nrule:\n  kind: call_expression\n  has:\n    field: function\n    kind: identifier\n    regex: ^{name}$\n"
+        );
+        let calls: Vec<NodeSpan> = self
+            .scan_source_json(&call_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let statement_rule = format!(
+            "{call_rule}  inside:\n    any: [{{ kind: expression_statement }}, {{ kind: defer_statement }}, {{ kind: go_statement }}]\n"
+        );
+        let statements: Vec<NodeSpan> = self
+            .scan_source_json(&statement_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+
+        let mut edits = Vec::new();
+        let mut inlined = Vec::new();
+        let mut skipped: Vec<(usize, &str, String)> = Vec::new();
+        for call in &calls {
+            let line = edit_utils::line_number(&source, call.start);
+            if calls
+                .iter()
+                .any(|outer| outer != call && within(call, outer))
+            {
+                skipped.push((
+                    line,
+                    &call.text,
+                    format!(
+                        "It is an argument of another call to {name}; inline again to reach it"
+                    ),
+                ));
+                continue;
+            }
+            if statements.contains(call) {
+                skipped.push((
+                    line,
+                    &call.text,
+                    "The call is a statement of its own, so there is nothing to use the expression"
+                        .to_string(),
+                ));
+                continue;
+            }
+            let open = call.start + call.text.find('(').unwrap_or_default();
+            let (elements, _) =
+                edit_utils::list_elements(&source, open + 1, call.end - 1, language);
+            let arguments: Vec<&str> = elements
+                .iter()
+                .map(|&(start, end)| &source[start..end])
+                .collect();
+            if arguments
+                .last()
+                .is_some_and(|argument| argument.ends_with("..."))
+            {
+                skipped.push((
+                    line,
+                    &call.text,
+                    "It spreads a slice into the arguments".to_string(),
+                ));
+                continue;
+            }
+            if arguments.len() != parameters.len() {
+                skipped.push((
+                    line,
+                    &call.text,
+                    format!(
+                        "It passes {} arguments to {} parameters",
+                        arguments.len(),
+                        parameters.len()
+                    ),
+                ));
+                continue;
+            }
+
+            if !side_effect_free {
+                let impure: Vec<usize> = (0..arguments.len())
+                    .filter(|&index| !edit_utils::is_trivially_pure(arguments[index]))
+                    .collect();
+                let miscounted = impure.iter().find_map(|&index| {
+                    match uses.iter().filter(|(used, _)| *used == index).count() {
+                        1 => None,
+                        0 => Some(format!(
+                            "The argument `{}` may have side effects and would no longer run",
+                            arguments[index]
+                        )),
+                        count => Some(format!(
+                            "The argument `{}` may have side effects and would run {} times",
+                            arguments[index], count
+                        )),
+                    }
+                });
+                if let Some(reason) = miscounted {
+                    skipped.push((line, &call.text, reason));
+                    continue;
+                }
+                let order: Vec<usize> = uses
+                    .iter()
+                    .map(|(index, _)| *index)
+                    .filter(|index| impure.contains(index))
+                    .collect();
+                if order != impure {
+                    let listed: Vec<String> = impure
+                        .iter()
+                        .map(|&index| format!("`{}`", arguments[index]))
+                        .collect();
+                    skipped.push((
+                        line,
+                        &call.text,
+                        format!(
+                            "The arguments {} may have side effects and would run in a different order",
+                            listed.join(", ")
+                        ),
+                    ));
+                    continue;
+                }
+            }
+
+            let scope = functions
+                .iter()
+                .map(|(_, span)| span)
+                .filter(|span| within(call, span))
+                .max_by_key(|span| span.end - span.start);
+            if let Some(shadowed) = free.iter().find(|free_name| {
+                bindings.iter().any(|binding| {
+                    binding.text == **free_name && scope.is_some_and(|scope| within(binding, scope))
+                })
+            }) {
+                skipped.push((
+                    line,
+                    &call.text,
+                    format!(
+                        "`{shadowed}` in the expression would refer to a local `{shadowed}` here"
+                    ),
+                ));
+                continue;
+            }
+
+            let mut replacement = String::new();
+            let mut last = expr_start;
+            for (index, span) in &uses {
+                replacement.push_str(&source[last..span.start]);
+                let argument = arguments[*index];
+                match edit_utils::needs_parentheses(argument) {
+                    true => replacement.push_str(&format!("({argument})")),
+                    false => replacement.push_str(argument),
+                }
+                last = span.end;
+            }
+            replacement.push_str(&source[last..expr_end]);
+            // Parentheses are only needed where an operator could bind to
+            // part of the expression
+            let before = source[..call.start].trim_end();
+            let after = source[call.end..].trim_start();
+            let delimited = (before.ends_with(['(', ',', '{', ';'])
+                || before.ends_with("return")
+                || (before.ends_with('=')
+                    && !["==", "!=", "<=", ">="]
+                        .iter()
+                        .any(|operator| before.ends_with(operator))))
+                && (after.is_empty()
+                    || after.starts_with([')', ',', '}', ';'])
+                    || source[call.end..]
+                        .trim_start_matches([' ', '\t'])
+                        .starts_with(['\n', '\r']));
+            if !delimited && edit_utils::needs_parentheses(&replacement) {
+                replacement = format!("({replacement})");
+            }
+            inlined.push(serde_json::json!({
+                "line": line,
+                "call": call.text,
+                "replacement": replacement,
+            }));
+            edits.push(TextEdit {
+                start: call.start,
+                end: call.end,
+                replacement,
+            });
+        }
+        if inlined.is_empty() {
+            return Err(match skipped.first() {
+                Some((line, _, reason)) => anyhow!(
+                    "None of the calls to '{}' can be inlined. Line {}: {}",
+                    name,
+                    line,
+                    reason
+                ),
+                None => anyhow!("No calls to '{}' found", name),
+            });
+        }
+
+        // The function stays while anything it has not replaced refers to it
+        let references: Vec<usize> = reads
+            .iter()
+            .filter(|span| span.text == name && !within(span, function))
+            .filter(|span| !edits.iter().any(|edit| edit.start == span.start))
+            .map(|span| edit_utils::line_number(&source, span.start))
+            .collect();
+        let removed = !keep_function && references.is_empty();
+        if removed {
+            let (start, end) =
+                edit_utils::declaration_removal_range(&source, function.start, function.end, "//");
+            edits.push(TextEdit {
+                start,
+                end,
+                replacement: String::new(),
+            });
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let skipped: Vec<Value> = skipped
+            .iter()
+            .map(|(line, text, reason)| {
+                serde_json::json!({"line": line, "text": text, "reason": reason})
+            })
+            .collect();
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": name,
+            "expression": expression,
+            "inlined": inlined,
+            "skipped": skipped,
+            "removed": removed,
+            "references": references,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Replace the Go literal at the call's position with a new constant
+    /// declared at file scope, and with `all` every identical literal in
+    /// the file. The constant joins the first top-level `const ( ... )`
+    /// group that does not count with `iota`, or gets a declaration of its
+    /// own after the imports. It is untyped unless `typed` is set, so each
+    /// use keeps converting the way the literal did.
+    async fn extract_go_constant(&self, args: Value) -> Result<String> {
+        let name = args["name"].as_str().ok_or(anyhow!("Missing name"))?;
+        let all = args["all"].as_bool().unwrap_or(false);
+        let typed = args["typed"].as_bool().unwrap_or(false);
+        let language = "go";
+        if !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(name) || name == "_" {
+            return Err(anyhow!("'{}' is not a valid constant name", name));
+        }
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        let rule = "id: go-literals\nlanguage: go\nrule:\n  any:\n    - kind: int_literal\n    - kind: float_literal\n    - kind: imaginary_literal\n    - kind: rune_literal\n    - kind: interpreted_string_literal\n    - kind: raw_string_literal\n    - kind: import_spec\n    - kind: const_spec\n    - all:\n      - kind: const_declaration\n      - inside: { kind: source_file }\n";
+        let mut literals: Vec<(String, NodeSpan)> = Vec::new();
+        let mut contexts: Vec<(String, NodeSpan)> = Vec::new();
+        for m in self
+            .scan_source_json(rule, &source, path.as_deref(), language)
+            .await?
+        {
+            let (Some(kind), Some(span)) = (m["kind"].as_str(), NodeSpan::from_match(&m)) else {
+                continue;
+            };
+            match kind {
+                "import_spec" | "const_spec" | "const_declaration" => {
+                    contexts.push((kind.to_string(), span))
+                }
+                _ => literals.push((kind.to_string(), span)),
+            }
+        }
+        literals.sort_by_key(|(_, span)| span.start);
+        literals.dedup_by_key(|(_, span)| span.start);
+        let tags: Vec<NodeSpan> = self
+            .scan_source_json(
+                "id: go-struct-tags\nlanguage: go\nrule:\n  any: [{ kind: interpreted_string_literal }, { kind: raw_string_literal }]\n  inside: { kind: field_declaration }\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+
+        let (kind, literal) = literals
+            .iter()
+            .find(|(_, span)| span.start <= start && end <= span.end)
+            .cloned()
+            .ok_or_else(|| anyhow!("No literal covers the position"))?;
+        let within = |span: &NodeSpan, kind: &str| {
+            contexts.iter().any(|(context_kind, context)| {
+                context_kind == kind && context.start <= span.start && span.end <= context.end
+            })
+        };
+        // Why a literal cannot be replaced with a constant
+        let reason = |span: &NodeSpan| -> Option<String> {
+            if within(span, "import_spec") {
+                Some("an import path must be a literal".to_string())
+            } else if tags.contains(span) {
+                Some("a struct tag must be a literal".to_string())
+            } else {
+                contexts
+                    .iter()
+                    .filter(|(context_kind, _)| context_kind == "const_spec")
+                    .find(|(_, spec)| {
+                        spec.end == span.end
+                            && source[spec.start..span.start].trim_end().ends_with('=')
+                    })
+                    .map(|(_, spec)| {
+                        let constant = spec.text.split([' ', '\t', '=']).next().unwrap_or("");
+                        format!("it is already the value of the constant {constant}")
+                    })
+            }
+        };
+        if let Some(reason) = reason(&literal) {
+            return Err(anyhow!(
+                "The literal {} on line {} cannot be extracted: {}",
+                literal.text,
+                edit_utils::line_number(&source, literal.start),
+                reason
+            ));
+        }
+        let taken = self
+            .scan_source_json(
+                &format!("id: go-name\nlanguage: go\nrule:\n  any: [{{ kind: identifier }}, {{ kind: type_identifier }}, {{ kind: field_identifier }}, {{ kind: package_identifier }}]\n  regex: ^{name}$\n"),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        if let Some(span) = taken.iter().find_map(NodeSpan::from_match) {
+            return Err(anyhow!(
+                "'{}' is already used on line {}; choose another name",
+                name,
+                edit_utils::line_number(&source, span.start)
+            ));
+        }
+
+        let literal_type = match kind.as_str() {
+            "int_literal" => "int",
+            "float_literal" => "float64",
+            "imaginary_literal" => "complex128",
+            "rune_literal" => "rune",
+            _ => "string",
+        };
+        let mut edits = Vec::new();
+        let mut replaced = Vec::new();
+        let mut skipped = Vec::new();
+        for (_, span) in &literals {
+            if span.text != literal.text || (!all && span.start != literal.start) {
+                continue;
+            }
+            let line = edit_utils::line_number(&source, span.start);
+            if let Some(reason) = reason(span) {
+                skipped
+                    .push(serde_json::json!({"line": line, "text": span.text, "reason": reason}));
+                continue;
+            }
+            let line_start = edit_utils::line_start(&source, span.start);
+            let line_end = edit_utils::line_end(&source, span.start);
+            replaced.push(serde_json::json!({
+                "line": line,
+                "text": source[line_start..line_end].trim(),
+            }));
+            edits.push(TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement: name.to_string(),
+            });
+        }
+
+        // The first const group that is not an iota sequence, written over
+        // several lines
+        let iota = regex::Regex::new(r"\biota\b")?;
+        let group = contexts.iter().find(|(kind, declaration)| {
+            kind == "const_declaration"
+                && declaration.text["const".len()..]
+                    .trim_start()
+                    .starts_with('(')
+                && !iota.is_match(&declaration.text)
+                && source[edit_utils::line_start(&source, declaration.end - 1)..declaration.end - 1]
+                    .trim()
+                    .is_empty()
+        });
+        let value = match typed {
+            true => format!("{literal_type} = {}", literal.text),
+            false => format!("= {}", literal.text),
+        };
+        let (declaration, declared_on) = match group {
+            Some((_, group)) => {
+                let close = group.end - 1;
+                let last_spec = contexts
+                    .iter()
+                    .filter(|(kind, spec)| {
+                        kind == "const_spec" && group.start <= spec.start && spec.end <= group.end
+                    })
+                    .map(|(_, spec)| spec)
+                    .max_by_key(|spec| spec.start);
+                let indent =
+                    last_spec.map_or("\t", |spec| edit_utils::indentation_at(&source, spec.start));
+                // Line the `=` up with the spec above, as gofmt does
+                let width = last_spec
+                    .filter(|spec| !spec.text.contains('\n'))
+                    .and_then(|spec| spec.text.find('='))
+                    .filter(|column| !typed && *column > name.len())
+                    .unwrap_or(name.len() + 1);
+                let spec = format!("{name:width$}{value}");
+                let at = edit_utils::line_start(&source, close);
+                edits.push(TextEdit {
+                    start: at,
+                    end: at,
+                    replacement: format!("{indent}{spec}\n"),
+                });
+                (spec, edit_utils::line_number(&source, at))
+            }
+            None => {
+                let anchor = self
+                    .scan_source_json(
+                        "id: go-preamble\nlanguage: go\nrule:\n  any: [{ kind: package_clause }, { kind: import_declaration }]\n",
+                        &source,
+                        path.as_deref(),
+                        language,
+                    )
+                    .await?
+                    .iter()
+                    .filter_map(NodeSpan::from_match)
+                    .map(|span| span.end)
+                    .max()
+                    .ok_or_else(|| anyhow!("Could not locate the package clause"))?;
+                let at = edit_utils::line_end(&source, anchor);
+                let declaration = format!("const {name} {value}");
+                edits.push(TextEdit {
+                    start: at,
+                    end: at,
+                    replacement: format!("\n{declaration}\n"),
+                });
+                // After the blank line that separates it
+                (declaration, edit_utils::line_number(&source, at) + 1)
+            }
+        };
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "name": name,
+            "literal": literal.text,
+            "type": literal_type,
+            "declaration": declaration,
+            "grouped": group.is_some(),
+            "declared_on": declared_on,
+            "replaced": replaced,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Survey the numeric literals of a Go file that may deserve a name,
+    /// grouped by value, for `extract_go_constant` to act on. Each use
+    /// comes with its function and what the number means there: what it is
+    /// compared with, assigned to, passed to or keyed by. Values in
+    /// `ignore` (0 and 1 unless given, compared without a sign), loop
+    /// bounds unless `includeLoopBounds` is set, and the values of
+    /// constants are left out.
+    async fn find_magic_numbers(&self, args: Value) -> Result<String> {
+        let ignore: Vec<String> = match args["ignore"].as_array() {
+            Some(values) => values
+                .iter()
+                .map(|value| match value {
+                    Value::String(text) => text.clone(),
+                    value => value.to_string(),
+                })
+                .collect(),
+            None => vec!["0".to_string(), "1".to_string()],
+        };
+        let include_loop_bounds = args["includeLoopBounds"].as_bool().unwrap_or(false);
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let literals: Vec<NodeSpan> = self
+            .scan_source_json(
+                "id: go-numbers\nlanguage: go\nrule:\n  any: [{ kind: int_literal }, { kind: float_literal }, { kind: imaginary_literal }]\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let contexts = self
+            .scan_source_json(
+                "id: go-number-contexts\nlanguage: go\nrule:\n  any:\n    - kind: binary_expression\n      all:\n        - has: { field: left, pattern: $LEFT }\n        - has: { field: right, pattern: $RIGHT }\n    - any: [{ kind: assignment_statement }, { kind: short_var_declaration }]\n      all:\n        - has: { field: left, pattern: $LEFT }\n        - has: { field: right, pattern: $RIGHT }\n    - kind: var_spec\n      has: { field: value, pattern: $RIGHT }\n    - kind: call_expression\n      all:\n        - has: { field: function, pattern: $FUNCTION }\n        - has: { field: arguments, pattern: $ARGS }\n    - kind: keyed_element\n    - kind: return_statement\n    - kind: expression_case\n    - kind: for_clause\n    - kind: const_spec\n    - kind: array_type\n    - kind: unary_expression\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let contexts: Vec<(&str, NodeSpan, &Value)> = contexts
+            .iter()
+            .filter_map(|m| {
+                Some((
+                    m["kind"].as_str()?,
+                    NodeSpan::from_match(m)?,
+                    &m["metaVariables"]["single"],
+                ))
+            })
+            .collect();
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        let text = |(start, end): (usize, usize)| source[start..end].trim();
+        // The element of a list holding `span`, and its index
+        let element = |list: &NodeSpan, span: &NodeSpan| {
+            edit_utils::list_elements(&source, list.start, list.end, language)
+                .0
+                .into_iter()
+                .enumerate()
+                .find(|(_, (start, end))| *start <= span.start && span.end <= *end)
+        };
+
+        // `Timeout` for `cfg.Timeout` or `timeout`, to follow a prefix
+        let suffix = |target: &str| {
+            let name = target
+                .split('[')
+                .next()
+                .unwrap_or("")
+                .rsplit('.')
+                .next()?
+                .trim();
+            if name.is_empty() || !name.chars().all(|c| c.is_alphanumeric() || c == '_') {
+                return None;
+            }
+            match name.starts_with(char::is_lowercase) {
+                true => edit_utils::toggle_export_case(name),
+                false => Some(name.to_string()),
+            }
+        };
+
+        let mut groups: Vec<(String, Vec<Value>, Option<String>, usize)> = Vec::new();
+        for literal in &literals {
+            let mut enclosing: Vec<&(&str, NodeSpan, &Value)> = contexts
+                .iter()
+                .filter(|(_, context, _)| within(literal, context))
+                .collect();
+            enclosing.sort_by_key(|(_, context, _)| context.end - context.start);
+            let negative = enclosing.iter().any(|(kind, context, _)| {
+                *kind == "unary_expression"
+                    && context.end == literal.end
+                    && context.text.strip_prefix('-').map(str::trim) == Some(literal.text.as_str())
+            });
+            if ignore
+                .iter()
+                .any(|ignored| ignored.trim_start_matches(['-', '+']) == literal.text)
+            {
+                continue;
+            }
+            let value = match negative {
+                true => format!("-{}", literal.text),
+                false => literal.text.clone(),
+            };
+
+            // A loop's bounds keep their comparison but are reported as such
+            let loop_bound = enclosing.iter().any(|(kind, _, _)| *kind == "for_clause");
+            if enclosing.iter().any(|(kind, _, _)| *kind == "const_spec")
+                || (loop_bound && !include_loop_bounds)
+            {
+                continue;
+            }
+            let mut occurrence = serde_json::json!({});
+            let mut role = "expression";
+            let mut name = None;
+            // The arithmetic the number is part of, up to where it is used
+            let mut expression: Option<&NodeSpan> = None;
+            for (kind, context, variables) in enclosing {
+                let variable = |name: &str| NodeSpan::from_match(&variables[name]);
+                match *kind {
+                    "array_type" => role = "array_length",
+                    "unary_expression" => continue,
+                    "binary_expression" => {
+                        let (Some(left), Some(right)) = (variable("LEFT"), variable("RIGHT"))
+                        else {
+                            continue;
+                        };
+                        let operator = source[left.end..right.start].trim();
+                        if !matches!(operator, "==" | "!=" | "<" | "<=" | ">" | ">=") {
+                            expression = Some(context);
+                            continue;
+                        }
+                        let (other, number_on_right) = match within(literal, &right) {
+                            true => (&left, true),
+                            false => (&right, false),
+                        };
+                        role = "comparison";
+                        occurrence["operator"] = operator.into();
+                        occurrence["compared_to"] = other.text.as_str().into();
+                        // `n < 10` bounds n from above, `10 < n` from below
+                        let bound = match (operator, number_on_right) {
+                            ("<" | "<=", true) | (">" | ">=", false) => Some("max"),
+                            (">" | ">=", true) | ("<" | "<=", false) => Some("min"),
+                            _ => None,
+                        };
+                        name = bound
+                            .zip(suffix(&other.text))
+                            .map(|(bound, suffix)| format!("{bound}{suffix}"));
+                    }
+                    "assignment_statement" | "short_var_declaration" | "var_spec" => {
+                        let Some(right) = variable("RIGHT") else {
+                            continue;
+                        };
+                        if !within(literal, &right) {
+                            continue;
+                        }
+                        let Some((index, _)) = element(&right, literal) else {
+                            continue;
+                        };
+                        let targets = match *kind {
+                            "var_spec" => {
+                                let names = source[context.start..right.start]
+                                    .trim_end()
+                                    .trim_end_matches('=');
+                                names
+                                    .split(',')
+                                    .map(|name| {
+                                        name.split_whitespace().next().unwrap_or("").to_string()
+                                    })
+                                    .collect::<Vec<_>>()
+                            }
+                            _ => {
+                                let Some(left) = variable("LEFT") else {
+                                    continue;
+                                };
+                                edit_utils::list_elements(&source, left.start, left.end, language)
+                                    .0
+                                    .into_iter()
+                                    .map(|range| text(range).to_string())
+                                    .collect()
+                            }
+                        };
+                        let Some(target) = targets.get(index).filter(|target| !target.is_empty())
+                        else {
+                            continue;
+                        };
+                        role = "assignment";
+                        occurrence["assigned_to"] = target.as_str().into();
+                        name = suffix(target).map(|suffix| format!("default{suffix}"));
+                    }
+                    "keyed_element" => {
+                        let Some((key, _)) = context.text.split_once(':') else {
+                            continue;
+                        };
+                        if literal.start < context.start + key.len() {
+                            continue;
+                        }
+                        role = "field";
+                        occurrence["field"] = key.trim().into();
+                        name = suffix(key).map(|suffix| format!("default{suffix}"));
+                    }
+                    "call_expression" => {
+                        let (Some(function), Some(arguments)) =
+                            (variable("FUNCTION"), variable("ARGS"))
+                        else {
+                            continue;
+                        };
+                        if !within(literal, &arguments) {
+                            continue;
+                        }
+                        let Some((index, _)) = edit_utils::list_elements(
+                            &source,
+                            arguments.start + 1,
+                            arguments.end - 1,
+                            language,
+                        )
+                        .0
+                        .into_iter()
+                        .enumerate()
+                        .find(|(_, (start, end))| *start <= literal.start && literal.end <= *end) else {
+                            continue;
+                        };
+                        role = "argument";
+                        occurrence["argument_of"] = function.text.as_str().into();
+                        occurrence["argument"] = index.into();
+                    }
+                    "return_statement" => role = "return",
+                    "expression_case" => role = "case",
+                    _ => continue,
+                }
+                break;
+            }
+
+            let line_start = edit_utils::line_start(&source, literal.start);
+            let line_end = edit_utils::line_end(&source, literal.start);
+            occurrence["line"] = edit_utils::line_number(&source, literal.start).into();
+            occurrence["text"] = source[line_start..line_end].trim().into();
+            occurrence["range"] = text_encoding::encode_range(
+                &source,
+                &text_encoding::byte_range(&source, literal.start, literal.end),
+                offset_encoding,
+            );
+            occurrence["function"] = functions
+                .iter()
+                .filter(|(name, function)| name.is_some() && within(literal, function))
+                .min_by_key(|(_, function)| function.end - function.start)
+                .and_then(|(name, _)| name.clone())
+                .into();
+            occurrence["role"] = match loop_bound {
+                true => "loop_bound",
+                false => role,
+            }
+            .into();
+            if let Some(expression) = expression {
+                occurrence["expression"] = expression.text.as_str().into();
+            }
+            let name = name.filter(|_| !loop_bound);
+            match groups.iter_mut().find(|(other, ..)| *other == value) {
+                Some((_, occurrences, suggestion, _)) => {
+                    occurrences.push(occurrence);
+                    if suggestion.is_none() {
+                        *suggestion = name;
+                    }
+                }
+                None => groups.push((
+                    value,
+                    vec![occurrence],
+                    name,
+                    offset_encoding.from_byte_offset(&source, literal.start),
+                )),
+            }
+        }
+        // The most repeated first; ties in the order they appear
+        groups.sort_by_key(|(_, occurrences, _, _)| std::cmp::Reverse(occurrences.len()));
+
+        let numbers: Vec<Value> = groups
+            .iter()
+            .map(|(value, occurrences, suggestion, first)| {
+                serde_json::json!({
+                    "value": value,
+                    "count": occurrences.len(),
+                    "suggested_name": suggestion,
+                    "extract": {"start_byte": first, "all": true},
+                    "occurrences": occurrences,
+                })
+            })
+            .collect();
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "count": numbers.len(),
+            "numbers": numbers,
+        }))?)
+    }
+
+    /// Survey the functions of a file whose signatures have grown long:
+    /// those declaring more than `maxParameters` parameters (5 unless
+    /// given) or returning more than `maxResults` values (3 unless given),
+    /// the most crowded first. Parameters and results are counted as
+    /// `edit_utils::parameter_count` and `edit_utils::result_count` do; a
+    /// Go method's receiver is not a parameter, and JavaScript functions
+    /// have no declared results to count.
+    async fn find_long_signatures(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let max_parameters = args["maxParameters"].as_u64().unwrap_or(5) as usize;
+        let max_results = args["maxResults"].as_u64().unwrap_or(3) as usize;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        self.validate_language(language)?;
+        if !matches!(
+            language,
+            "javascript" | "typescript" | "python" | "go" | "rust"
+        ) {
+            return Err(anyhow!(
+                "find_long_signatures does not support {} yet (supported: javascript, typescript, python, go, rust)",
+                language
+            ));
+        }
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let fields =
+            |field: &'static str| self.function_fields(&source, path.as_deref(), language, field);
+        let parameter_lists = fields("parameters").await?;
+        // An arrow function's lone parameter written without parentheses
+        let lone_parameters = match language {
+            "javascript" | "typescript" => fields("parameter").await?,
+            _ => Vec::new(),
+        };
+        let result_lists = match language {
+            "go" => fields("result").await?,
+            "javascript" => Vec::new(),
+            _ => fields("return_type").await?,
+        };
+        let field_of = |fields: &[(NodeSpan, NodeSpan)], function: &NodeSpan| {
+            fields
+                .iter()
+                .find(|(owner, _)| (owner.start, owner.end) == (function.start, function.end))
+                .map(|(_, node)| node.clone())
+        };
+
+        let mut long = Vec::new();
+        for (name, function) in &functions {
+            let params = field_of(&parameter_lists, function)
+                .or_else(|| field_of(&lone_parameters, function));
+            let result = field_of(&result_lists, function);
+            let parameters = params.as_ref().map_or(0, |params| {
+                edit_utils::parameter_count(language, &params.text)
+            });
+            let results = result
+                .as_ref()
+                .map_or(0, |result| edit_utils::result_count(language, &result.text));
+            let exceeds: Vec<&str> = [
+                ("parameters", parameters > max_parameters),
+                ("results", results > max_results),
+            ]
+            .into_iter()
+            .filter_map(|(count, over)| over.then_some(count))
+            .collect();
+            if exceeds.is_empty() {
+                continue;
+            }
+            let signature_end = [
+                params.map(|params| params.end),
+                result.map(|result| result.end),
+            ]
+            .into_iter()
+            .flatten()
+            .max()
+            .unwrap_or(function.end);
+            long.push((
+                parameters + results,
+                serde_json::json!({
+                    "name": name,
+                    "line": edit_utils::line_number(&source, function.start),
+                    "range": text_encoding::encode_range(
+                        &source,
+                        &text_encoding::byte_range(&source, function.start, function.end),
+                        offset_encoding,
+                    ),
+                    "signature": source[function.start..signature_end].trim(),
+                    "parameters": parameters,
+                    "results": results,
+                    "exceeds": exceeds,
+                }),
+            ));
+        }
+        // The most parameters and results first; ties in the order they appear
+        long.sort_by_key(|(total, _)| std::cmp::Reverse(*total));
+        let long: Vec<Value> = long.into_iter().map(|(_, function)| function).collect();
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "language": language,
+            "maxParameters": max_parameters,
+            "maxResults": max_results,
+            "checked": functions.len(),
+            "count": long.len(),
+            "functions": long,
+        }))?)
+    }
+
+    /// Add a table-driven test of a Go function to the `_test.go` file
+    /// next to it, creating the file when there is none. The cases are
+    /// left for the caller to fill in; the imports the test needs are
+    /// added. Without a target file the test file's content is returned.
+    async fn generate_go_table_test(&self, args: Value) -> Result<String> {
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let name = function_name
+            .clone()
+            .ok_or_else(|| anyhow!("A func literal has no name to call from a test"))?;
+        if regex::Regex::new(r"^func\s*(\([^)]*\[|\w+\s*\[)")?.is_match(&function.text) {
+            return Err(anyhow!(
+                "'{}' is generic; a test has to choose its type arguments",
+                name
+            ));
+        }
+        let field = |field: &'static str| {
+            self.function_field(&source, path.as_deref(), language, function, field)
+        };
+        let params = match field("parameters").await? {
+            Some(params) if params.text.trim() != "()" => edit_utils::go_results(&params.text),
+            _ => Vec::new(),
+        };
+        let results = field("result")
+            .await?
+            .map(|result| edit_utils::go_result_types(&result.text))
+            .unwrap_or_default();
+        let receiver = match field("receiver").await? {
+            Some(receiver) => edit_utils::go_results(&receiver.text)
+                .into_iter()
+                .next()
+                .map(|(_, receiver_type)| receiver_type),
+            None => None,
+        };
+
+        // Named as gotests names them: `TestAdd`, `Test_add`, `TestServer_Start`
+        let test_name = match args["test_name"].as_str() {
+            Some(test_name) => test_name.to_string(),
+            None => {
+                let tested = match &receiver {
+                    Some(receiver) => format!("{}_{}", receiver.trim_start_matches('*'), name),
+                    None => name.clone(),
+                };
+                match tested.starts_with(char::is_uppercase) {
+                    true => format!("Test{tested}"),
+                    false => format!("Test_{tested}"),
+                }
+            }
+        };
+        let (test, deep_equal) =
+            edit_utils::go_table_test(&test_name, &name, receiver.as_deref(), &params, &results);
+
+        let test_path = match &path {
+            Some(path) => {
+                let file_name = path
+                    .file_name()
+                    .and_then(|file_name| file_name.to_str())
+                    .unwrap_or_default();
+                if file_name.ends_with("_test.go") {
+                    return Err(anyhow!("{} is a test file already", path.display()));
+                }
+                Some(go_test_path(path))
+            }
+            None => None,
+        };
+        let existing = match &test_path {
+            Some(test_path) if test_path.is_file() => {
+                Some(self.read_source_file(test_path, &args).await?)
+            }
+            _ => None,
+        };
+        let test_source = match &existing {
+            Some(existing) => {
+                let tests = self
+                    .scan_functions(existing, test_path.as_deref(), language)
+                    .await?;
+                if let Some((_, clash)) = tests
+                    .iter()
+                    .find(|(test, _)| test.as_deref() == Some(test_name.as_str()))
+                {
+                    return Err(anyhow!(
+                        "{} already has {} on line {}",
+                        test_path.as_ref().unwrap().display(),
+                        test_name,
+                        edit_utils::line_number(existing, clash.start)
+                    ));
+                }
+                format!("{}\n\n{test}", existing.trim_end())
+            }
+            None => {
+                let package = regex::Regex::new(r"(?m)^package\s+(\w+)")?
+                    .captures(&source)
+                    .map(|captures| captures[1].to_string())
+                    .ok_or_else(|| anyhow!("Could not locate the package clause"))?;
+                format!("package {package}\n\n{test}")
+            }
+        };
+        let mut imports = vec!["\"testing\"".to_string()];
+        if deep_equal {
+            imports.insert(0, "\"reflect\"".to_string());
+        }
+        let (new_source, added, present) = self
+            .ensure_imports(&test_source, None, language, &imports)
+            .await?;
+        let old_source = existing.as_deref().unwrap_or_default();
+        let edits: Vec<TextEdit> = edit_guard::edit_between(old_source, &new_source)
+            .into_iter()
+            .collect();
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "test_file": test_path.as_ref().map(|path| path.display().to_string()),
+            "created": existing.is_none(),
+            "function": name,
+            "test_name": test_name,
+            "imports": {
+                "added": added,
+                "present": present,
+            },
+        });
+        let result = self
+            .finish_edit(
+                result,
+                test_path.as_deref(),
+                old_source,
+                &new_source,
+                &edits,
+                &args,
+            )
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Rewrite Go string concatenations such as `"Hello, " + name` as
+    /// `fmt.Sprintf` calls, importing `fmt` if the file does not already.
+    async fn convert_go_concatenation(&self, args: Value) -> Result<String> {
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        // Every `+` with its operands; a chain `a + b + c` nests on the left
+        let sum_rule = "id: go-sums\nlanguage: go\nrule:\n  pattern: $LEFT + $RIGHT\n";
+        let offsets = |m: &Value, name: &str| {
+            let range = &m["metaVariables"]["single"][name]["range"]["byteOffset"];
+            Some((
+                range["start"].as_u64()? as usize,
+                range["end"].as_u64()? as usize,
+            ))
+        };
+        let mut sums: HashMap<(usize, usize), ((usize, usize), (usize, usize))> = HashMap::new();
+        for m in self
+            .scan_source_json(sum_rule, &source, path.as_deref(), language)
+            .await?
+        {
+            if let (Some(span), Some(left), Some(right)) = (
+                NodeSpan::from_match(&m),
+                offsets(&m, "LEFT"),
+                offsets(&m, "RIGHT"),
+            ) {
+                sums.insert((span.start, span.end), (left, right));
+            }
+        }
+        let operands_of = |chain: (usize, usize)| {
+            let mut operands = Vec::new();
+            let mut current = chain;
+            while let Some(&(left, right)) = sums.get(&current) {
+                operands.push(right);
+                current = left;
+            }
+            operands.push(current);
+            operands.reverse();
+            operands
+        };
+        let lefts: std::collections::HashSet<(usize, usize)> =
+            sums.values().map(|(left, _)| *left).collect();
+        let mut chains: Vec<(usize, usize)> = sums
+            .keys()
+            .filter(|span| !lefts.contains(span))
+            .copied()
+            .collect();
+        chains.sort();
+
+        let selected = !args["position"].is_null() || !args["start_byte"].is_null();
+        if selected {
+            let (start, end) = self.get_target_range(&args, &source)?;
+            let chain = chains
+                .iter()
+                .filter(|chain| chain.0 <= start && end <= chain.1)
+                .min_by_key(|chain| chain.1 - chain.0)
+                .copied()
+                .ok_or_else(|| anyhow!("No + expression covers the position"))?;
+            chains = vec![chain];
+        } else {
+            // A chain inside another's operand, e.g. a call argument, is
+            // left to a later run rather than edited twice
+            let all = chains.clone();
+            chains.retain(|chain| {
+                !all.iter()
+                    .any(|outer| outer != chain && outer.0 <= chain.0 && chain.1 <= outer.1)
+            });
+        }
+
+        let structure_rule = "id: go-structure\nlanguage: go\nrule:\n  any:\n    - kind: package_clause\n    - kind: import_declaration\n    - kind: import_spec\n    - kind: const_declaration\n";
+        let structure: Vec<(String, NodeSpan)> = self
+            .scan_source_json(structure_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| Some((m["kind"].as_str()?.to_string(), NodeSpan::from_match(m)?)))
+            .collect();
+        let of_kind = |kind: &'static str| {
+            structure
+                .iter()
+                .filter(move |(node_kind, _)| node_kind == kind)
+                .map(|(_, span)| span)
+        };
+        // How the file refers to fmt: `fmt.`, an alias, or a dot import
+        let imported = of_kind("import_spec").find_map(|spec| {
+            match spec.text.split_whitespace().collect::<Vec<_>>().as_slice() {
+                ["\"fmt\""] => Some("fmt.".to_string()),
+                [".", "\"fmt\""] => Some(String::new()),
+                [alias, "\"fmt\""] if *alias != "_" => Some(format!("{alias}.")),
+                _ => None,
+            }
+        });
+        let qualifier = imported.clone().unwrap_or_else(|| "fmt.".to_string());
+
+        let mut edits = Vec::new();
+        let mut conversions = Vec::new();
+        let mut skipped = Vec::new();
+        for (start, end) in chains {
+            let line = edit_utils::line_number(&source, start);
+            let operands = operands_of((start, end));
+            let texts: Vec<&str> = operands
+                .iter()
+                .map(|&(start, end)| &source[start..end])
+                .collect();
+            // Sums of numbers, or of string variables only, are left alone
+            let Some(call) = edit_utils::go_sprintf_call(&qualifier, &texts) else {
+                if selected {
+                    return Err(anyhow!(
+                        "The + expression on line {} does not join string literals with other operands",
+                        line
+                    ));
+                }
+                continue;
+            };
+            let reason = if of_kind("const_declaration")
+                .any(|span| span.start <= start && end <= span.end)
+            {
+                Some("a constant declaration cannot call Sprintf")
+            } else if operands
+                .windows(2)
+                .any(|pair| source[pair[0].1..pair[1].0].trim() != "+")
+            {
+                Some("a comment between its operands would be lost")
+            } else {
+                None
+            };
+            match reason {
+                Some(reason) => skipped.push(serde_json::json!({
+                    "line": line,
+                    "text": &source[start..end],
+                    "reason": reason,
+                })),
+                None => {
+                    conversions.push(serde_json::json!({
+                        "line": line,
+                        "before": &source[start..end],
+                        "after": call,
+                    }));
+                    edits.push(TextEdit {
+                        start,
+                        end,
+                        replacement: call,
+                    });
+                }
+            }
+        }
+        if edits.is_empty() {
+            let reasons = skipped
+                .iter()
+                .map(|entry| {
+                    format!(
+                        "line {}: {}",
+                        entry["line"],
+                        entry["reason"].as_str().unwrap_or_default()
+                    )
+                })
+                .collect::<Vec<_>>();
+            return Err(match reasons.is_empty() {
+                true => anyhow!("No string concatenation to convert"),
+                false => anyhow!(
+                    "No string concatenation can be converted ({})",
+                    reasons.join("; ")
+                ),
+            });
+        }
+
+        let import_added = imported.is_none();
+        if import_added {
+            let declarations: Vec<NodeSpan> = of_kind("import_declaration").cloned().collect();
+            let block_specs: Vec<NodeSpan> = declarations
+                .iter()
+                .find(|declaration| declaration.text.contains('('))
+                .map(|block| {
+                    of_kind("import_spec")
+                        .filter(|spec| block.start <= spec.start && spec.end <= block.end)
+                        .cloned()
+                        .collect()
+                })
+                .unwrap_or_default();
+            let with_import = if !block_specs.is_empty() {
+                edit_utils::insert_sorted_line(&source, &block_specs, "\"fmt\"")
+                    .map(|(text, _)| text)
+            } else if !declarations.is_empty() {
+                edit_utils::insert_sorted_line(&source, &declarations, "import \"fmt\"")
+                    .map(|(text, _)| text)
+            } else {
+                let package = of_kind("package_clause")
+                    .next()
+                    .ok_or_else(|| anyhow!("Could not locate the package clause"))?;
+                let end = edit_utils::line_end(&source, package.end);
+                Some(format!(
+                    "{}\nimport \"fmt\"\n{}",
+                    &source[..end],
+                    &source[end..]
+                ))
+            };
+            edits.extend(
+                with_import.and_then(|with_import| edit_guard::edit_between(&source, &with_import)),
+            );
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "conversions": conversions,
+            "skipped": skipped,
+            "import_added": import_added,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Split the Go `a, b := f(), g()` or `a, b = f(), g()` at a position
+    /// into one statement per name. A name `:=` only assigns, because the
+    /// same scope declared it before, gets `=`. Calls returning several
+    /// values cannot be split, and neither can statements in a for, if or
+    /// switch header, or ones where a later value or target reads a name
+    /// that an earlier one assigns, as in `a, b = b, a`.
+    async fn split_go_assignment(&self, args: Value) -> Result<String> {
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        let kinds = "[{ kind: short_var_declaration }, { kind: assignment_statement }]";
+        let found = self
+            .scan_source_json(
+                &format!("id: go-assignments\nlanguage: go\nrule:\n  any: {kinds}\n  all:\n    - has: {{ field: left, pattern: $LEFT }}\n    - has: {{ field: right, pattern: $RIGHT }}\n"),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let (statement, left, right) = found
+            .iter()
+            .filter_map(|m| {
+                let single = &m["metaVariables"]["single"];
+                Some((
+                    NodeSpan::from_match(m)?,
+                    NodeSpan::from_match(&single["LEFT"])?,
+                    NodeSpan::from_match(&single["RIGHT"])?,
+                ))
+            })
+            .filter(|(statement, _, _)| statement.start <= start && end <= statement.end)
+            .min_by_key(|(statement, _, _)| statement.end - statement.start)
+            .ok_or_else(|| anyhow!("No assignment or := covers the position"))?;
+        let line = edit_utils::line_number(&source, statement.start);
+        let operator = source[left.end..right.start].trim();
+        if operator != ":=" && operator != "=" {
+            return Err(anyhow!(
+                "The {} assignment on line {} cannot be split; only = and := can",
+                operator,
+                line
+            ));
+        }
+        let targets = edit_utils::list_elements(&source, left.start, left.end, language).0;
+        let values = edit_utils::list_elements(&source, right.start, right.end, language).0;
+        if targets.len() < 2 {
+            return Err(anyhow!(
+                "The assignment on line {} has only one target",
+                line
+            ));
+        }
+        if values.len() == 1 {
+            return Err(anyhow!(
+                "'{}' on line {} returns several values at once, so it cannot be split",
+                right.text,
+                line
+            ));
+        }
+        if values.len() != targets.len() {
+            return Err(anyhow!(
+                "The assignment on line {} has {} targets but {} values",
+                line,
+                targets.len(),
+                values.len()
+            ));
+        }
+        let header_rule = format!(
+            "id: go-header-assignments\nlanguage: go\nrule:\n  any: {kinds}\n  inside:\n    any: [{{ kind: for_clause }}, {{ kind: if_statement }}, {{ kind: expression_switch_statement }}, {{ kind: type_switch_statement }}, {{ kind: communication_case }}]\n"
+        );
+        if self
+            .scan_source_json(&header_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .any(|span| (span.start, span.end) == (statement.start, statement.end))
+        {
+            return Err(anyhow!(
+                "The assignment on line {} is in a statement's header, which takes a single statement",
+                line
+            ));
+        }
+
+        let text = |(start, end): (usize, usize)| &source[start..end];
+        let within =
+            |span: &NodeSpan, (start, end): (usize, usize)| start <= span.start && span.end <= end;
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let reads: Vec<NodeSpan> = self
+            .scan_source_json(&read_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| statement.start <= span.start && span.end <= statement.end)
+            .collect();
+        let reads_in = |range: (usize, usize)| -> Vec<&str> {
+            reads
+                .iter()
+                .filter(|span| within(span, range))
+                .map(|span| span.text.as_str())
+                .collect()
+        };
+        // The names each target assigns: a plain name, or the variables
+        // a field, index or dereference goes through
+        let assigned: Vec<Vec<&str>> = targets
+            .iter()
+            .map(|&target| match text(target) {
+                "_" => Vec::new(),
+                name if name.chars().all(|c| c.is_alphanumeric() || c == '_') => vec![name],
+                _ => reads_in(target),
+            })
+            .collect();
+        for (i, &value) in values.iter().enumerate().skip(1) {
+            let mut depends = reads_in(value);
+            if operator == "=" {
+                depends.extend(
+                    reads_in(targets[i])
+                        .into_iter()
+                        .filter(|name| *name != text(targets[i])),
+                );
+            }
+            if let Some(name) = assigned[..i]
+                .iter()
+                .flatten()
+                .find(|name| depends.contains(name))
+            {
+                return Err(anyhow!(
+                    "'{}' on line {} needs {} as it was before the assignment; splitting would change it",
+                    text(targets[i]),
+                    line,
+                    name
+                ));
+            }
+        }
+
+        // With :=, a name the same scope declared earlier is only assigned
+        let mut declared_before: Vec<bool> = vec![operator == "="; targets.len()];
+        if operator == ":=" {
+            let scopes = self.scan_go_scopes(&source, path.as_deref()).await?;
+            let scope_of = |span: &NodeSpan| self.go_scope_of(&scopes, span);
+            let scope = scope_of(&statement);
+            let bindings: Vec<NodeSpan> = self
+                .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+                .await?
+                .iter()
+                .filter_map(NodeSpan::from_match)
+                .filter(|binding| binding.end <= statement.start)
+                .collect();
+            for (i, &target) in targets.iter().enumerate() {
+                declared_before[i] = bindings
+                    .iter()
+                    .any(|binding| binding.text == text(target) && scope_of(binding) == scope);
+            }
+        }
+
+        let mut warnings = Vec::new();
+        let mut statements = Vec::new();
+        for (i, (&target, &value)) in targets.iter().zip(&values).enumerate() {
+            let name = text(target);
+            let assigns = name == "_" || declared_before[i];
+            statements.push(format!(
+                "{} {} {}",
+                name,
+                if assigns { "=" } else { ":=" },
+                text(value)
+            ));
+            // A call may read what the statements before it now assign
+            let earlier: Vec<&str> = (0..i)
+                .filter(|&j| declared_before[j] && text(targets[j]) != "_")
+                .map(|j| text(targets[j]))
+                .collect();
+            if !earlier.is_empty() && text(value).contains('(') {
+                warnings.push(format!(
+                    "'{}' now runs after {} {} assigned; check that it does not read {}",
+                    text(value),
+                    earlier.join(", "),
+                    if earlier.len() == 1 { "is" } else { "are" },
+                    if earlier.len() == 1 { "it" } else { "them" }
+                ));
+            }
+        }
+        let indent = edit_utils::indentation_at(&source, statement.start);
+        let edits = vec![TextEdit {
+            start: statement.start,
+            end: statement.end,
+            replacement: statements.join(&format!("\n{indent}")),
+        }];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "line": line,
+            "operator": operator,
+            "statements": statements,
+            "warnings": warnings,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Point Go error constructors such as `errors.New` and `fmt.Errorf` at
+    /// a project's own, as `mapping` gives them (`"fmt.Errorf":
+    /// "apperr.Errorf"`), in the returns of the whole file or of one
+    /// function. Only the called function changes, so messages and format
+    /// arguments stay as they are. The new package is imported, and an old
+    /// one the file no longer uses is dropped.
+    async fn convert_go_errors(&self, args: Value) -> Result<String> {
+        let returns_only = args["returnsOnly"].as_bool().unwrap_or(true);
+        let mapping = args["mapping"]
+            .as_object()
+            .filter(|mapping| !mapping.is_empty())
+            .ok_or(anyhow!("Missing mapping"))?;
+        let import = args["import"].as_str().map(edit_utils::go_import_spec);
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+
+        let found = self
+            .scan_source_json(
+                "id: go-imports\nlanguage: go\nrule:\n  any:\n    - kind: import_declaration\n    - kind: import_spec\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let spans = |kind: &str| -> Vec<NodeSpan> {
+            found
+                .iter()
+                .filter(|m| m["kind"] == kind)
+                .filter_map(NodeSpan::from_match)
+                .collect()
+        };
+        let declarations = spans("import_declaration");
+        let specs = spans("import_spec");
+        // The name the file calls each imported path by
+        let name_of = |import_path: &str| {
+            specs
+                .iter()
+                .find(|spec| edit_utils::go_import_path(&spec.text) == import_path)
+                .map(|spec| edit_utils::go_import_name(&spec.text))
+                .filter(|name| !matches!(*name, "_" | "."))
+        };
+        let mut constructors = Vec::new();
+        for (old, new) in mapping {
+            let new = new
+                .as_str()
+                .ok_or_else(|| anyhow!("The mapping of {} must be a function name", old))?;
+            let (import_path, function) = old
+                .rsplit_once('.')
+                .ok_or_else(|| anyhow!("{} is not a package function such as errors.New", old))?;
+            let (qualifier, _) = new
+                .split_once('.')
+                .ok_or_else(|| anyhow!("{} is not a package function such as apperr.New", new))?;
+            let imported = match &import {
+                Some(import) => edit_utils::go_import_name(import) == qualifier,
+                None => specs
+                    .iter()
+                    .any(|spec| edit_utils::go_import_name(&spec.text) == qualifier),
+            };
+            if !imported {
+                return Err(anyhow!(
+                    "{} calls package {}, which the file does not import; pass its path as import",
+                    new,
+                    qualifier
+                ));
+            }
+            if let Some(name) = name_of(import_path) {
+                constructors.push((format!("{name}.{function}"), old.as_str(), new, import_path));
+            }
+        }
+
+        let scope = match !args["name"].is_null()
+            || !args["position"].is_null()
+            || !args["start_byte"].is_null()
+        {
+            true => {
+                let functions = self
+                    .scan_functions(&source, path.as_deref(), language)
+                    .await?;
+                let (_, function) = self.select_function(&args, &source, &functions)?;
+                Some((function.start, function.end))
+            }
+            false => None,
+        };
+        let inside = match returns_only {
+            true => "\n  inside: { kind: return_statement, stopBy: end }",
+            false => "",
+        };
+        let calls = self
+            .scan_source_json(
+                &format!("id: go-error-calls\nlanguage: go\nrule:\n  kind: call_expression\n  has: {{ field: function, kind: selector_expression, pattern: $FUNCTION }}{inside}\n"),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let mut edits = Vec::new();
+        let mut conversions = Vec::new();
+        let mut warnings = Vec::new();
+        let mut replaced_paths = Vec::new();
+        for call in &calls {
+            let (Some(span), Some(function)) = (
+                NodeSpan::from_match(call),
+                NodeSpan::from_match(&call["metaVariables"]["single"]["FUNCTION"]),
+            ) else {
+                continue;
+            };
+            if scope.is_some_and(|(start, end)| span.start < start || end < span.end) {
+                continue;
+            }
+            let called: String = function.text.split_whitespace().collect();
+            let Some((_, old, new, import_path)) =
+                constructors.iter().find(|(name, ..)| *name == called)
+            else {
+                continue;
+            };
+            let line = edit_utils::line_number(&source, span.start);
+            if span.text.contains("%w") {
+                warnings.push(format!(
+                    "Line {} wraps an error with %w; check that {} does too, or errors.Is and errors.As stop seeing it",
+                    line, new
+                ));
+            }
+            conversions.push(serde_json::json!({
+                "line": line,
+                "from": old,
+                "to": new,
+                "before": span.text,
+                "after": format!("{}{}", new, &source[function.end..span.end]),
+            }));
+            edits.push(TextEdit {
+                start: function.start,
+                end: function.end,
+                replacement: new.to_string(),
+            });
+            replaced_paths.push(*import_path);
+        }
+        if edits.is_empty() {
+            let names: Vec<&str> = mapping.keys().map(String::as_str).collect();
+            return Err(anyhow!(
+                "No call to {} {}to convert",
+                names.join(" or "),
+                if returns_only {
+                    "in a return statement "
+                } else {
+                    ""
+                }
+            ));
+        }
+
+        // An old package's import goes once nothing else refers to it
+        let mut removed = Vec::new();
+        replaced_paths.sort();
+        replaced_paths.dedup();
+        for import_path in replaced_paths {
+            let Some(name) = name_of(import_path) else {
+                continue;
+            };
+            let uses = self
+                .scan_source_json(
+                    &format!("id: go-package-uses\nlanguage: go\nrule:\n  any:\n    - kind: selector_expression\n      has: {{ field: operand, regex: '^{name}$' }}\n    - kind: qualified_type\n      has: {{ field: package, regex: '^{name}$' }}\n"),
+                    &source,
+                    path.as_deref(),
+                    language,
+                )
+                .await?;
+            let still_used = uses.iter().filter_map(NodeSpan::from_match).any(|use_| {
+                !edits
+                    .iter()
+                    .any(|edit| edit.start <= use_.start && use_.end <= edit.end)
+            });
+            let spec = specs
+                .iter()
+                .find(|spec| edit_utils::go_import_path(&spec.text) == import_path);
+            let declaration = spec.and_then(|spec| {
+                declarations.iter().find(|declaration| {
+                    declaration.start <= spec.start && spec.end <= declaration.end
+                })
+            });
+            if let (false, Some(spec), Some(declaration)) = (still_used, spec, declaration) {
+                edits.push(edit_utils::go_import_deletion(&source, declaration, spec));
+                removed.push(spec.text.clone());
+            }
+        }
+        let converted = edit_utils::apply_edits(&source, &edits)?;
+        let (new_source, added, present) = self
+            .ensure_imports(
+                &converted,
+                None,
+                language,
+                &import.into_iter().collect::<Vec<_>>(),
+            )
+            .await?;
+        let edits: Vec<TextEdit> = edit_guard::edit_between(&source, &new_source)
+            .into_iter()
+            .collect();
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "conversions": conversions,
+            "warnings": warnings,
+            "imports": {
+                "added": added,
+                "present": present,
+                "removed": removed,
+            },
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Report the declarations in a Go function that shadow a variable of
+    /// the same name from an enclosing scope, such as a `:=` in an inner
+    /// block that re-declares a parameter or `err`, each with where the
+    /// shadowed one is declared. Package-level names count as enclosing.
+    /// Nothing is changed unless `rename` names a shadowing declaration
+    /// and what to call it instead; its uses are renamed with it.
+    async fn find_go_shadowing(&self, args: Value) -> Result<String> {
+        let language = "go";
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let (source, path) = self.load_source(&args).await?;
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let scan = |rule: String| {
+            let source = &source;
+            let path = path.as_deref();
+            async move {
+                Ok::<Vec<(NodeSpan, Value)>, anyhow::Error>(
+                    self.scan_source_json(&rule, source, path, language)
+                        .await?
+                        .iter()
+                        .filter_map(|m| {
+                            NodeSpan::from_match(m).map(|span| (span, m["range"].clone()))
+                        })
+                        .collect(),
+                )
+            }
+        };
+        let bindings: Vec<(NodeSpan, Value)> = scan(binding_rule)
+            .await?
+            .into_iter()
+            .filter(|(span, _)| span.text != "_")
+            .collect();
+        let mut reads = scan(read_rule).await?;
+        reads.retain(|(span, _)| !edit_utils::is_member_name(&source, span));
+        let scopes = self.scan_go_scopes(&source, path.as_deref()).await?;
+        let statements: Vec<NodeSpan> = self
+            .scan_source_json(
+                "id: go-declaring-statements\nlanguage: go\nrule:\n  any: [{ kind: short_var_declaration }, { kind: var_spec }, { kind: const_spec }, { kind: range_clause }]\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+
+        // A function's own name belongs to the package, like a top-level
+        // var or const; `None` stands for the package scope
+        let scope_of = |span: &NodeSpan| {
+            let names_function = functions.iter().any(|(_, function)| {
+                let head = function.text.find('(').unwrap_or(0);
+                function.start <= span.start && span.start < function.start + head
+            });
+            match names_function {
+                true => None,
+                false => self.go_scope_of(&scopes, span),
+            }
+        };
+        let contains =
+            |outer: Option<(usize, usize)>, inner: Option<(usize, usize)>| match (outer, inner) {
+                (None, _) => true,
+                (Some(_), None) => false,
+                (Some((start, end)), Some((inner_start, inner_end))) => {
+                    start <= inner_start && inner_end <= end
+                }
+            };
+        let size =
+            |scope: Option<(usize, usize)>| scope.map_or(usize::MAX, |(start, end)| end - start);
+        // A variable is in scope from the end of the statement declaring it,
+        // so `x := x + 1` reads the outer `x`
+        let visible_from = |span: &NodeSpan| {
+            statements
+                .iter()
+                .filter(|statement| statement.start <= span.start && span.end <= statement.end)
+                .map(|statement| statement.end)
+                .min()
+                .unwrap_or(span.end)
+        };
+        let scoped: Vec<(&NodeSpan, &Value, Option<(usize, usize)>, usize)> = bindings
+            .iter()
+            .map(|(span, range)| (span, range, scope_of(span), visible_from(span)))
+            .collect();
+        // A `:=` naming a variable its scope already declared assigns that
+        // variable rather than declaring another
+        let declaration_of = |i: usize| {
+            let (span, _, scope, _) = scoped[i];
+            scoped
+                .iter()
+                .position(|(other, _, other_scope, _)| {
+                    other.text == span.text && *other_scope == scope
+                })
+                .unwrap_or(i)
+        };
+        // The declaration a use at `at` refers to: the innermost one in scope
+        let resolve = |name: &str, at: usize| {
+            scoped
+                .iter()
+                .enumerate()
+                .filter(|(_, (span, _, scope, from))| {
+                    span.text == name
+                        && (scope.is_none() || *from <= at)
+                        && contains(*scope, Some((at, at)))
+                })
+                .min_by_key(|(i, (_, _, scope, _))| (size(*scope), *i))
+                .map(|(i, _)| declaration_of(i))
+        };
+        let within_function =
+            |span: &NodeSpan| function.start <= span.start && span.end <= function.end;
+        let describe = |span: &NodeSpan, range: &Value| {
+            let line_start = edit_utils::line_start(&source, span.start);
+            let line_end = edit_utils::line_end(&source, span.start);
+            serde_json::json!({
+                "line": edit_utils::line_number(&source, span.start),
+                "text": source[line_start..line_end].trim(),
+                "range": text_encoding::encode_range(&source, range, offset_encoding),
+            })
+        };
+
+        let mut shadowing = Vec::new();
+        let mut report = Vec::new();
+        for (i, &(span, range, scope, _)) in scoped.iter().enumerate() {
+            if !within_function(span) || scope.is_none() || declaration_of(i) != i {
+                continue;
+            }
+            let Some(outer) = resolve(&span.text, span.start) else {
+                continue;
+            };
+            let (outer_span, outer_range, outer_scope, _) = scoped[outer];
+            if outer_scope == scope {
+                continue;
+            }
+            let mut entry = describe(span, range);
+            entry["name"] = span.text.as_str().into();
+            let mut shadowed = describe(outer_span, outer_range);
+            shadowed["package_level"] = outer_scope.is_none().into();
+            entry["shadows"] = shadowed;
+            shadowing.push(i);
+            report.push(entry);
+        }
+
+        let rename = &args["rename"];
+        let mut edits = Vec::new();
+        let mut renamed = Value::Null;
+        if !rename.is_null() {
+            let name = rename["name"]
+                .as_str()
+                .ok_or(anyhow!("Missing rename.name"))?;
+            let to = rename["to"].as_str().ok_or(anyhow!("Missing rename.to"))?;
+            if !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(to) {
+                return Err(anyhow!("'{}' is not a valid identifier", to));
+            }
+            let lines: Vec<usize> = shadowing
+                .iter()
+                .filter(|&&i| scoped[i].0.text == name)
+                .map(|&i| edit_utils::line_number(&source, scoped[i].0.start))
+                .collect();
+            let line = rename["line"].as_u64().map(|line| line as usize);
+            let target = match (line, lines.as_slice()) {
+                (_, []) => {
+                    return Err(anyhow!(
+                        "No declaration of '{}' shadows another in the function",
+                        name
+                    ))
+                }
+                (None, [_]) => {
+                    shadowing[shadowing
+                        .iter()
+                        .position(|&i| scoped[i].0.text == name)
+                        .unwrap_or(0)]
+                }
+                (None, _) => {
+                    return Err(anyhow!(
+                        "'{}' shadows another on lines {}; pass rename.line to choose one",
+                        name,
+                        lines
+                            .iter()
+                            .map(ToString::to_string)
+                            .collect::<Vec<_>>()
+                            .join(", ")
+                    ))
+                }
+                (Some(line), _) => *shadowing
+                    .iter()
+                    .find(|&&i| {
+                        scoped[i].0.text == name
+                            && edit_utils::line_number(&source, scoped[i].0.start) == line
+                    })
+                    .ok_or_else(|| {
+                        anyhow!("No shadowing declaration of '{}' on line {}", name, line)
+                    })?,
+            };
+            if let Some((taken, _)) = bindings.iter().chain(&reads).find(|(span, _)| {
+                span.text == to && (within_function(span) || scope_of(span).is_none())
+            }) {
+                return Err(anyhow!(
+                    "'{}' is already used on line {}",
+                    to,
+                    edit_utils::line_number(&source, taken.start)
+                ));
+            }
+            let mut spans: Vec<&NodeSpan> = scoped
+                .iter()
+                .enumerate()
+                .filter(|(i, (span, ..))| span.text == name && declaration_of(*i) == target)
+                .map(|(_, (span, ..))| *span)
+                .collect();
+            spans.extend(
+                reads
+                    .iter()
+                    .filter(|(span, _)| {
+                        span.text == name && resolve(name, span.start) == Some(target)
+                    })
+                    .map(|(span, _)| span),
+            );
+            spans.sort_by_key(|span| span.start);
+            edits = spans
+                .iter()
+                .map(|span| TextEdit {
+                    start: span.start,
+                    end: span.end,
+                    replacement: to.to_string(),
+                })
+                .collect();
+            renamed = serde_json::json!({
+                "name": name,
+                "to": to,
+                "line": edit_utils::line_number(&source, scoped[target].0.start),
+                "lines": spans
+                    .iter()
+                    .map(|span| edit_utils::line_number(&source, span.start))
+                    .collect::<Vec<_>>(),
+            });
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let mut result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": function_name,
+            "line": edit_utils::line_number(&source, function.start),
+            "shadowing": report,
+            "renamed": renamed,
+        });
+        if rename.is_null() {
+            result["applied"] = false.into();
+            return Ok(serde_json::to_string_pretty(&result)?);
+        }
+        let result = self
+            .finish_edit(result, path, &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// The Go nodes that open a scope, each with its kind.
+    async fn scan_go_scopes(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+    ) -> Result<Vec<(String, NodeSpan)>> {
+        let scope_rule = "id: go-scopes\nlanguage: go\nrule:\n  any: [{ kind: block }, { kind: expression_case }, { kind: type_case }, { kind: default_case }, { kind: communication_case }, { kind: if_statement }, { kind: for_statement }, { kind: expression_switch_statement }, { kind: type_switch_statement }, { kind: function_declaration }, { kind: method_declaration }, { kind: func_literal }]\n";
+        Ok(self
+            .scan_source_json(scope_rule, source, path, "go")
+            .await?
+            .iter()
+            .filter_map(|m| Some((m["kind"].as_str()?.to_string(), NodeSpan::from_match(m)?)))
+            .collect())
+    }
+
+    /// The range of the innermost of `scopes` holding `span`, or `None` at
+    /// the top level. A function's parameters share the scope of its body.
+    fn go_scope_of(
+        &self,
+        scopes: &[(String, NodeSpan)],
+        span: &NodeSpan,
+    ) -> Option<(usize, usize)> {
+        let (kind, scope) = scopes
+            .iter()
+            .filter(|(_, scope)| {
+                scope.start <= span.start
+                    && span.end <= scope.end
+                    && (scope.start, scope.end) != (span.start, span.end)
+            })
+            .min_by_key(|(_, scope)| scope.end - scope.start)?;
+        let function = scopes.iter().find(|(function_kind, function)| {
+            kind == "block"
+                && function.end == scope.end
+                && self
+                    .get_function_kinds("go")
+                    .is_ok_and(|kinds| kinds.contains(&function_kind.as_str()))
+        });
+        Some(function.map_or((scope.start, scope.end), |(_, function)| {
+            (function.start, function.end)
+        }))
+    }
+
+    /// Merge runs of consecutive top-level Go `var` or `const` declarations
+    /// into parenthesized groups, or split groups back into separate
+    /// declarations, keeping each declaration's comments with it.
+    async fn group_declarations(&self, args: Value) -> Result<String> {
+        let language = args["language"].as_str().unwrap_or("go");
+        if language != "go" {
+            return Err(anyhow!(
+                "group_declarations only supports Go, got {}",
+                language
+            ));
+        }
+        let direction = args["direction"].as_str();
+        if let Some(direction) = direction {
+            if !matches!(direction, "group" | "split") {
+                return Err(anyhow!(
+                    "Unknown direction '{}'; use group or split",
+                    direction
+                ));
+            }
+        }
+        let (source, path) = self.load_source(&args).await?;
+
+        let rule = "id: go-declarations\nlanguage: go\nrule:\n  any:\n    - all:\n      - any: [{ kind: var_declaration }, { kind: const_declaration }]\n      - inside: { kind: source_file }\n    - kind: var_spec\n    - kind: const_spec\n";
+        let mut declarations: Vec<(&'static str, NodeSpan)> = Vec::new();
+        let mut specs: Vec<NodeSpan> = Vec::new();
+        for m in self
+            .scan_source_json(rule, &source, path.as_deref(), language)
+            .await?
+        {
+            let Some(span) = NodeSpan::from_match(&m) else {
+                continue;
+            };
+            match m["kind"].as_str() {
+                Some("var_declaration") => declarations.push(("var", span)),
+                Some("const_declaration") => declarations.push(("const", span)),
+                Some(_) => specs.push(span),
+                None => {}
+            }
+        }
+        declarations.sort_by_key(|(_, span)| span.start);
+        declarations.dedup_by_key(|(_, span)| span.start);
+        // Specs of a declaration, not of ones nested in its function literals
+        let specs_of = |declaration: &NodeSpan| -> Vec<&NodeSpan> {
+            let inside: Vec<&NodeSpan> = specs
+                .iter()
+                .filter(|spec| declaration.start <= spec.start && spec.end <= declaration.end)
+                .collect();
+            let mut outermost: Vec<&NodeSpan> = inside
+                .iter()
+                .filter(|spec| {
+                    !inside.iter().any(|outer| {
+                        (outer.start, outer.end) != (spec.start, spec.end)
+                            && outer.start <= spec.start
+                            && spec.end <= outer.end
+                    })
+                })
+                .copied()
+                .collect();
+            outermost.sort_by_key(|spec| spec.start);
+            outermost.dedup_by_key(|spec| spec.start);
+            outermost
+        };
+        let is_group = |keyword: &str, declaration: &NodeSpan| {
+            declaration.text[keyword.len()..]
+                .trim_start()
+                .starts_with('(')
+        };
+        let line = |offset: usize| edit_utils::line_number(&source, offset);
+        // End of a line's text, before its newline
+        let text_end = |offset: usize| {
+            source[..edit_utils::line_end(&source, offset)]
+                .trim_end_matches(['\r', '\n'])
+                .len()
+        };
+        let iota = regex::Regex::new(r"\biota\b")?;
+
+        let selected = !args["position"].is_null() || !args["start_byte"].is_null();
+        let target = if selected {
+            let (start, end) = self.get_target_range(&args, &source)?;
+            let declaration = declarations
+                .iter()
+                .find(|(_, span)| span.start <= start && end <= span.end)
+                .ok_or_else(|| {
+                    anyhow!("No top-level var or const declaration covers the position")
+                })?;
+            Some(declaration)
+        } else {
+            None
+        };
+        // With just a position, a group is split and anything else grouped
+        let split = match direction {
+            Some(direction) => direction == "split",
+            None => target.is_some_and(|(keyword, span)| is_group(keyword, span)),
+        };
+
+        // Each candidate: the keyword, the region it replaces, its items,
+        // and why it cannot be rewritten, if it cannot
+        let mut candidates: Vec<(&str, (usize, usize), Vec<DeclarationItem>, Option<&str>)> =
+            Vec::new();
+        if split {
+            for (keyword, declaration) in &declarations {
+                if !is_group(keyword, declaration) {
+                    continue;
+                }
+                let specs = specs_of(declaration);
+                let open = declaration.start + declaration.text.find('(').unwrap_or(0);
+                let close = declaration.end.saturating_sub(1);
+                let mut items = Vec::new();
+                let mut reason = None;
+                let mut previous_end = edit_utils::line_end(&source, open);
+                if specs.is_empty() {
+                    reason = Some("the group is empty");
+                } else if !source[open + 1..previous_end.min(close)].trim().is_empty() {
+                    reason = Some("the group is written on one line");
+                }
+                for spec in &specs {
+                    if reason.is_some() {
+                        break;
+                    }
+                    if spec.start < previous_end {
+                        reason = Some("two declarations share a line");
+                        break;
+                    }
+                    let trailing = source[spec.end..text_end(spec.end)].trim();
+                    if !trailing.is_empty() && !trailing.starts_with("//") {
+                        reason = Some("a declaration shares a line with other code");
+                        break;
+                    }
+                    if *keyword == "const"
+                        && (iota.is_match(&spec.text) || !spec.text.contains('='))
+                    {
+                        reason = Some("iota and omitted values depend on the group");
+                        break;
+                    }
+                    let indent = edit_utils::indentation_at(&source, spec.start);
+                    let text = &source[spec.start..text_end(spec.end)];
+                    if text.contains('\n') && text.contains('`') {
+                        reason = Some("a raw string spans several lines");
+                        break;
+                    }
+                    let gap = &source[previous_end..edit_utils::line_start(&source, spec.start)];
+                    items.push(DeclarationItem {
+                        blank_before: gap.lines().any(|line| line.trim().is_empty()),
+                        comments: gap
+                            .lines()
+                            .map(str::trim)
+                            .filter(|line| !line.is_empty())
+                            .map(str::to_string)
+                            .collect(),
+                        spec: text
+                            .lines()
+                            .enumerate()
+                            .map(|(i, line)| match i {
+                                0 => line,
+                                _ => line.strip_prefix(indent).unwrap_or(line),
+                            })
+                            .collect::<Vec<_>>()
+                            .join("\n"),
+                    });
+                    previous_end = edit_utils::line_end(&source, spec.end);
+                }
+                if reason.is_none() && !source[previous_end.min(close)..close].trim().is_empty() {
+                    reason = Some("a comment before the closing parenthesis would be lost");
+                }
+                candidates.push((keyword, (declaration.start, declaration.end), items, reason));
+            }
+        } else {
+            // Runs of single-line declarations of one keyword with nothing
+            // but their own comments and blank lines in between
+            let mut runs: Vec<(&str, Vec<(usize, usize, DeclarationItem)>)> = Vec::new();
+            let mut previous_end: Option<usize> = None;
+            for (keyword, declaration) in &declarations {
+                let single = !is_group(keyword, declaration)
+                    && !declaration.text.contains('\n')
+                    && declaration.start == edit_utils::line_start(&source, declaration.start);
+                let trailing = source[declaration.end..text_end(declaration.end)].trim();
+                let mut start = declaration.start;
+                while start > 0 {
+                    let above = edit_utils::line_start(&source, start - 1);
+                    if !source[above..start].trim_start().starts_with("//") {
+                        break;
+                    }
+                    start = above;
+                }
+                let comments: Vec<String> = source[start..declaration.start]
+                    .lines()
+                    .map(|line| line.trim().to_string())
+                    .collect();
+                // A directive such as //go:embed applies to its declaration alone
+                let groupable = single
+                    && (trailing.is_empty() || trailing.starts_with("//"))
+                    && !comments.iter().any(|comment| comment.starts_with("//go:"));
+                let adjacent = previous_end
+                    .is_some_and(|end| end <= start && source[end..start].trim().is_empty());
+                let continues = groupable
+                    && adjacent
+                    && runs
+                        .last()
+                        .is_some_and(|(run_keyword, _)| run_keyword == keyword);
+                let item = DeclarationItem {
+                    blank_before: previous_end.is_some_and(|end| {
+                        end <= start
+                            && source[end..start]
+                                .lines()
+                                .any(|line| line.trim().is_empty())
+                    }),
+                    comments,
+                    spec: format!(
+                        "{}{}",
+                        declaration.text[keyword.len()..].trim_start(),
+                        &source[declaration.end..text_end(declaration.end)]
+                    ),
+                };
+                match (continues, groupable) {
+                    (true, _) => {
+                        if let Some((_, run)) = runs.last_mut() {
+                            run.push((start, declaration.end, item));
+                        }
+                    }
+                    (false, true) => runs.push((keyword, vec![(start, declaration.end, item)])),
+                    (false, false) => runs.push((keyword, Vec::new())),
+                }
+                previous_end = Some(edit_utils::line_end(&source, declaration.end));
+            }
+            for (keyword, run) in runs {
+                if run.len() < 2 {
+                    continue;
+                }
+                let region = (run[0].0, text_end(run[run.len() - 1].1));
+                let reason = (keyword == "const"
+                    && run.iter().any(|(_, _, item)| iota.is_match(&item.spec)))
+                .then_some("iota would count the grouped declarations");
+                let items = run.into_iter().map(|(_, _, item)| item).collect();
+                candidates.push((keyword, region, items, reason));
+            }
+        }
+        if let Some((_, target)) = target {
+            let candidate = candidates
+                .into_iter()
+                .find(|(_, (start, end), _, _)| *start <= target.start && target.start < *end)
+                .ok_or_else(|| match split {
+                    true => anyhow!("The declaration at the position is not a group"),
+                    false => anyhow!(
+                        "The declaration at the position has no neighbouring single-line declaration of the same kind to group with"
+                    ),
+                })?;
+            if let (_, region, _, Some(reason)) = &candidate {
+                return Err(anyhow!(
+                    "The declaration on line {} cannot be {}: {}",
+                    line(region.0),
+                    if split { "split" } else { "grouped" },
+                    reason
+                ));
+            }
+            candidates = vec![candidate];
+        }
+
+        let mut edits = Vec::new();
+        let mut changes = Vec::new();
+        let mut skipped = Vec::new();
+        for (keyword, (start, end), items, reason) in candidates {
+            if let Some(reason) = reason {
+                skipped.push(serde_json::json!({
+                    "line": line(start),
+                    "text": &source[start..end],
+                    "reason": reason,
+                }));
+                continue;
+            }
+            let replacement = match split {
+                true => edit_utils::go_separate_declarations(keyword, &items),
+                false => edit_utils::go_declaration_group(keyword, &items),
+            };
+            changes.push(serde_json::json!({
+                "line": line(start),
+                "count": items.len(),
+                "before": &source[start..end],
+                "after": replacement,
+            }));
+            edits.push(TextEdit {
+                start,
+                end,
+                replacement,
+            });
+        }
+        if edits.is_empty() {
+            return Err(match (skipped.is_empty(), split) {
+                (true, true) => anyhow!("No grouped var or const declaration to split"),
+                (true, false) => anyhow!("No consecutive var or const declarations to group"),
+                (false, _) => anyhow!(
+                    "No declarations can be {} ({})",
+                    if split { "split" } else { "grouped" },
+                    skipped
+                        .iter()
+                        .map(|entry| format!(
+                            "line {}: {}",
+                            entry["line"],
+                            entry["reason"].as_str().unwrap_or_default()
+                        ))
+                        .collect::<Vec<_>>()
+                        .join("; ")
+                ),
+            });
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "direction": if split { "split" } else { "group" },
+            "changes": changes,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds `convert_switch` maps between in `language`.
+    fn get_switch_syntax(&self, language: &str) -> Result<SwitchSyntax> {
+        match language {
+            "go" => Ok(SwitchSyntax {
+                if_kind: "if_statement",
+                switch_kind: "expression_switch_statement",
+                case_kind: "expression_case",
+                default_kind: "default_case",
+                break_kind: "break_statement",
+                fallthrough_kind: "fallthrough_statement",
+                breakable_kinds: &[
+                    "for_statement",
+                    "expression_switch_statement",
+                    "type_switch_statement",
+                    "select_statement",
+                ],
+            }),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Rewrite the if/else-if chain at a position that compares one value
+    /// against others as a switch on it, or a switch as the equivalent if
+    /// chain. Chains whose conditions are not all such comparisons, and
+    /// cases whose `break` or `fallthrough` would change meaning, are
+    /// refused.
+    async fn convert_switch(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let direction = args["direction"].as_str();
+        if let Some(direction) = direction {
+            if !matches!(direction, "to_switch" | "to_if") {
+                return Err(anyhow!(
+                    "Unknown direction '{}'; use to_switch or to_if",
+                    direction
+                ));
+            }
+        }
+
+        self.validate_language(language)?;
+        let syntax = self.get_switch_syntax(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        let has = |field: &str, name: &str| {
+            format!("      - has:\n          field: {field}\n          pattern: ${name}\n")
+        };
+        let all = |kind: &str, fields: &[(&str, &str)]| {
+            let fields: String = fields
+                .iter()
+                .map(|(field, name)| has(field, name))
+                .collect();
+            format!("    - all:\n      - kind: {kind}\n{fields}")
+        };
+        let mut rule = format!("id: switch-parts\nlanguage: {language}\nrule:\n  any:\n");
+        rule.push_str(&all(
+            syntax.if_kind,
+            &[
+                ("initializer", "INIT"),
+                ("condition", "COND"),
+                ("consequence", "BODY"),
+            ],
+        ));
+        rule.push_str(&all(
+            syntax.if_kind,
+            &[("condition", "COND"), ("consequence", "BODY")],
+        ));
+        rule.push_str(&all(
+            syntax.switch_kind,
+            &[("initializer", "INIT"), ("value", "TAG")],
+        ));
+        rule.push_str(&all(syntax.switch_kind, &[("value", "TAG")]));
+        rule.push_str(&all(syntax.switch_kind, &[("initializer", "INIT")]));
+        rule.push_str(&all(syntax.case_kind, &[("value", "VALUES")]));
+        rule.push_str("    - pattern: $L == $R\n    - pattern: $L || $R\n");
+        for kind in [
+            syntax.switch_kind,
+            syntax.default_kind,
+            syntax.break_kind,
+            syntax.fallthrough_kind,
+        ]
+        .iter()
+        .chain(syntax.breakable_kinds)
+        {
+            rule.push_str(&format!("    - kind: {kind}\n"));
+        }
+        let matches = self
+            .scan_source_json(&rule, &source, path.as_deref(), language)
+            .await?;
+
+        let capture = |m: &Value, name: &str| {
+            let range = &m["metaVariables"]["single"][name]["range"]["byteOffset"];
+            Some((
+                range["start"].as_u64()? as usize,
+                range["end"].as_u64()? as usize,
+            ))
+        };
+        let mut spans: Vec<(String, (usize, usize))> = Vec::new();
+        let mut captures: HashMap<(usize, usize), HashMap<&str, (usize, usize)>> = HashMap::new();
+        for m in &matches {
+            let (Some(kind), Some(span)) = (m["kind"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            let range = (span.start, span.end);
+            let found: HashMap<&str, (usize, usize)> =
+                ["INIT", "COND", "BODY", "TAG", "VALUES", "L", "R"]
+                    .into_iter()
+                    .filter_map(|name| Some((name, capture(m, name)?)))
+                    .collect();
+            if !found.is_empty() {
+                captures.entry(range).or_default().extend(found);
+            }
+            spans.push((kind.to_string(), range));
+        }
+        let of_kind = |kind: &str| -> Vec<(usize, usize)> {
+            let mut ranges: Vec<(usize, usize)> = spans
+                .iter()
+                .filter(|(node_kind, _)| node_kind == kind)
+                .map(|(_, range)| *range)
+                .collect();
+            ranges.sort();
+            ranges.dedup();
+            ranges
+        };
+        let text = |(start, end): (usize, usize)| &source[start..end];
+        let line = |offset: usize| edit_utils::line_number(&source, offset);
+        let field = |node: (usize, usize), name: &str| {
+            captures
+                .get(&node)
+                .and_then(|fields| fields.get(name))
+                .copied()
+        };
+        let ifs: Vec<(usize, usize)> = of_kind(syntax.if_kind)
+            .into_iter()
+            .filter(|node| field(*node, "COND").is_some() && field(*node, "BODY").is_some())
+            .collect();
+        let switches = of_kind(syntax.switch_kind);
+        let breakables: Vec<(usize, usize)> = syntax
+            .breakable_kinds
+            .iter()
+            .flat_map(|kind| of_kind(kind))
+            .collect();
+        let within =
+            |inner: (usize, usize), outer: (usize, usize)| outer.0 <= inner.0 && inner.1 <= outer.1;
+        // A `break` that would leave the switch rather than the loop or
+        // switch around it, found within `body`
+        let loose_break = |body: (usize, usize)| {
+            of_kind(syntax.break_kind).into_iter().find(|&statement| {
+                within(statement, body)
+                    && text(statement).trim() == "break"
+                    && !breakables
+                        .iter()
+                        .any(|&node| within(node, body) && within(statement, node) && node != body)
+            })
+        };
+        let indent = |offset: usize| edit_utils::indentation_at(&source, offset).to_string();
+        let body_indent = |offset: usize| format!("{}\t", indent(offset));
+        // The if whose `else` branch a chained if is
+        let parent_if = |node: (usize, usize)| {
+            ifs.iter().copied().find(|&parent| {
+                let body = field(parent, "BODY").unwrap();
+                body.1 <= node.0 && parent.1 == node.1 && source[body.1..node.0].trim() == "else"
+            })
+        };
+
+        let innermost = |nodes: &[(usize, usize)]| {
+            nodes
+                .iter()
+                .copied()
+                .filter(|&node| node.0 <= start && end <= node.1)
+                .min_by_key(|node| node.1 - node.0)
+        };
+        let head_if = innermost(&ifs).map(|mut node| {
+            while let Some(parent) = parent_if(node) {
+                node = parent;
+            }
+            node
+        });
+        let switch = innermost(&switches);
+        let to_switch = match (direction, head_if, switch) {
+            (Some("to_switch"), Some(_), _) => true,
+            (Some("to_switch"), None, _) => {
+                return Err(anyhow!("No if statement covers the position"))
+            }
+            (Some(_), _, Some(_)) => false,
+            (Some(_), _, None) => return Err(anyhow!("No switch statement covers the position")),
+            (None, Some(chain), Some(switch)) => !within(switch, chain),
+            (None, Some(_), None) => true,
+            (None, None, Some(_)) => false,
+            (None, None, None) => {
+                return Err(anyhow!("No if statement or switch covers the position"))
+            }
+        };
+
+        let (node, subject, replacement, branch_count) = if to_switch {
+            let head = head_if.unwrap();
+            let mut branches: Vec<Branch> = Vec::new();
+            let mut subject: Option<String> = None;
+            let mut current = head;
+            loop {
+                let condition = field(current, "COND").unwrap();
+                let body = field(current, "BODY").unwrap();
+                if current != head && field(current, "INIT").is_some() {
+                    return Err(anyhow!(
+                        "The else if on line {} has its own initializer, which a switch cannot keep",
+                        line(current.0)
+                    ));
+                }
+                // The `==` comparisons joined by `||` the condition is made of
+                let mut comparisons = Vec::new();
+                let mut pending = vec![condition];
+                while let Some(expression) = pending.pop() {
+                    let operands = (field(expression, "L"), field(expression, "R"));
+                    let inner = text(expression).trim();
+                    match operands {
+                        (Some(left), Some(right)) if source[left.1..right.0].trim() == "||" => {
+                            pending.extend([right, left])
+                        }
+                        (Some(left), Some(right)) => comparisons.push((left, right)),
+                        _ if inner.starts_with('(') && inner.ends_with(')') => {
+                            let open = expression.0 + text(expression).find('(').unwrap();
+                            let close = expression.0 + text(expression).rfind(')').unwrap();
+                            let inner = &source[open + 1..close];
+                            let skipped = inner.len() - inner.trim_start().len();
+                            pending.push((open + 1 + skipped, open + 1 + inner.trim_end().len()));
+                        }
+                        _ => {
+                            return Err(anyhow!(
+                                "The condition on line {} is not a comparison with ==: {}",
+                                line(condition.0),
+                                text(condition)
+                            ))
+                        }
+                    }
+                }
+                let compact =
+                    |range: (usize, usize)| text(range).split_whitespace().collect::<String>();
+                let subject_text = subject.get_or_insert_with(|| {
+                    // The operand every comparison shares, preferably the left
+                    let (left, right) = comparisons[0];
+                    let in_all = |operand: (usize, usize)| {
+                        comparisons.iter().all(|&(l, r)| {
+                            compact(l) == compact(operand) || compact(r) == compact(operand)
+                        })
+                    };
+                    match !in_all(left) && in_all(right) {
+                        true => text(right).to_string(),
+                        false => text(left).to_string(),
+                    }
+                });
+                let subject_compact: String = subject_text.split_whitespace().collect();
+                let mut values = Vec::new();
+                for &(left, right) in &comparisons {
+                    let value = if compact(left) == subject_compact {
+                        right
+                    } else if compact(right) == subject_compact {
+                        left
+                    } else {
+                        return Err(anyhow!(
+                            "The condition on line {} does not compare {}: {}",
+                            line(condition.0),
+                            subject_text,
+                            text(condition)
+                        ));
+                    };
+                    values.push(text(value).to_string());
+                }
+                if let Some(statement) = loose_break(body) {
+                    return Err(anyhow!(
+                        "The break on line {} would leave the switch instead of its loop",
+                        line(statement.0)
+                    ));
+                }
+                let (comment, statements) =
+                    edit_utils::branch_body(&source[body.0 + 1..body.1 - 1], &body_indent(head.0));
+                branches.push(Branch {
+                    values,
+                    comment,
+                    body: statements,
+                });
+
+                // What follows the branch: an else if, an else block, or nothing
+                let rest = &source[body.1..current.1];
+                if rest.trim().is_empty() {
+                    break;
+                }
+                let alternative = body.1 + rest.find(|c: char| c == '{' || c == 'i').unwrap_or(0);
+                if source[body.1..alternative].trim() != "else" {
+                    return Err(anyhow!(
+                        "A comment between the branches on line {} would be lost",
+                        line(body.1)
+                    ));
+                }
+                match ifs.iter().find(|node| node.0 == alternative) {
+                    Some(&next) => current = next,
+                    None => {
+                        let block = (alternative, current.1);
+                        if let Some(statement) = loose_break(block) {
+                            return Err(anyhow!(
+                                "The break on line {} would leave the switch instead of its loop",
+                                line(statement.0)
+                            ));
+                        }
+                        let (comment, statements) = edit_utils::branch_body(
+                            &source[block.0 + 1..block.1 - 1],
+                            &body_indent(head.0),
+                        );
+                        branches.push(Branch {
+                            values: Vec::new(),
+                            comment,
+                            body: statements,
+                        });
+                        break;
+                    }
+                }
+            }
+
+            let subject = subject.unwrap();
+            let compared = branches
+                .iter()
+                .filter(|branch| !branch.values.is_empty())
+                .count();
+            if compared < 2 {
+                return Err(anyhow!(
+                    "The if statement on line {} has no else if to turn into a case",
+                    line(head.0)
+                ));
+            }
+            if !edit_utils::is_plain_operand(&subject) {
+                return Err(anyhow!(
+                    "The chain evaluates {} once per condition and a switch would evaluate it once",
+                    subject
+                ));
+            }
+            let mut seen = std::collections::HashSet::new();
+            for value in branches.iter().flat_map(|branch| &branch.values) {
+                if !seen.insert(value.split_whitespace().collect::<String>()) {
+                    return Err(anyhow!(
+                        "{} is compared more than once, which a switch does not allow",
+                        value
+                    ));
+                }
+            }
+            let header = match field(head, "INIT") {
+                Some(init) => format!("{}; {}", text(init), subject),
+                None => subject.clone(),
+            };
+            let replacement = edit_utils::go_switch_text(&header, &branches, &indent(head.0));
+            (head, Some(subject), replacement, branches.len())
+        } else {
+            let switch = switch.unwrap();
+            let tag = field(switch, "TAG").map(|tag| text(tag).to_string());
+            let init = field(switch, "INIT").map(|init| text(init).to_string());
+            // The switch's own cases, not those of switches nested in them
+            let cases: Vec<(usize, usize)> = [syntax.case_kind, syntax.default_kind]
+                .iter()
+                .flat_map(|kind| of_kind(kind))
+                .filter(|&case| {
+                    within(case, switch)
+                        && !switches.iter().any(|&other| {
+                            other != switch && within(other, switch) && within(case, other)
+                        })
+                })
+                .collect::<std::collections::BTreeSet<_>>()
+                .into_iter()
+                .collect();
+            let Some(first) = cases.first() else {
+                return Err(anyhow!(
+                    "The switch on line {} has no cases",
+                    line(switch.0)
+                ));
+            };
+            let open = source[switch.0..first.0]
+                .rfind('{')
+                .map(|i| switch.0 + i + 1);
+            let mut gaps = vec![(open.unwrap_or(first.0), first.0)];
+            gaps.extend(cases.windows(2).map(|pair| (pair[0].1, pair[1].0)));
+            gaps.push((cases.last().unwrap().1, switch.1 - 1));
+            if let Some(gap) = gaps.iter().find(|gap| !text(**gap).trim().is_empty()) {
+                return Err(anyhow!(
+                    "A comment between the cases on line {} would be lost",
+                    line(gap.0)
+                ));
+            }
+
+            let mut branches = Vec::new();
+            let mut default = None;
+            for &case in &cases {
+                if let Some(statement) = of_kind(syntax.fallthrough_kind)
+                    .into_iter()
+                    .find(|&statement| within(statement, case))
+                {
+                    return Err(anyhow!(
+                        "The fallthrough on line {} has no if chain equivalent",
+                        line(statement.0)
+                    ));
+                }
+                if let Some(statement) = loose_break(case) {
+                    return Err(anyhow!(
+                        "The break on line {} would leave the enclosing loop instead of the switch",
+                        line(statement.0)
+                    ));
+                }
+                let values = field(case, "VALUES");
+                let label_end = values.map_or(case.0, |values| values.1);
+                let colon = label_end + source[label_end..case.1].find(':').unwrap_or(0);
+                let (comment, statements) =
+                    edit_utils::branch_body(&source[colon + 1..case.1], &body_indent(switch.0));
+                let values = match values {
+                    Some(values) => {
+                        let (elements, _) =
+                            edit_utils::list_elements(&source, values.0, values.1, language);
+                        elements
+                            .into_iter()
+                            .map(|element| text(element).to_string())
+                            .collect()
+                    }
+                    None => Vec::new(),
+                };
+                let branch = Branch {
+                    values,
+                    comment,
+                    body: statements,
+                };
+                match branch.values.is_empty() {
+                    true => default = Some(branch),
+                    false => branches.push(branch),
+                }
+            }
+            if branches.is_empty() {
+                return Err(anyhow!(
+                    "The switch on line {} has only a default case",
+                    line(switch.0)
+                ));
+            }
+            let compared: usize = branches.iter().map(|branch| branch.values.len()).sum();
+            if let Some(tag) = &tag {
+                if compared > 1 && !edit_utils::is_plain_operand(tag) {
+                    return Err(anyhow!(
+                        "The switch evaluates {} once and an if chain would evaluate it per comparison",
+                        tag
+                    ));
+                }
+            }
+            // An empty default does nothing: the chain simply ends
+            branches.extend(
+                default
+                    .filter(|branch| !branch.body.trim().is_empty() || !branch.comment.is_empty()),
+            );
+            let replacement = edit_utils::go_if_chain_text(
+                init.as_deref(),
+                tag.as_deref(),
+                &branches,
+                &indent(switch.0),
+            );
+            (switch, tag, replacement, branches.len())
+        };
+
+        let edits = vec![TextEdit {
+            start: node.0,
+            end: node.1,
+            replacement: replacement.clone(),
+        }];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "direction": if to_switch { "to_switch" } else { "to_if" },
+            "line": line(node.0),
+            "subject": subject,
+            "branches": branch_count,
+            "before": text(node),
+            "after": replacement,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Report the build constraints of a Go file: its `//go:build` line,
+    /// legacy `// +build` lines, and GOOS/GOARCH file name suffix, and
+    /// whether it builds under the given tags.
+    async fn go_build_constraints(&self, args: Value) -> Result<String> {
+        let (source, path) = self.load_source(&args).await?;
+        let file_name = path
+            .as_deref()
+            .and_then(|path| path.file_name())
+            .and_then(|name| name.to_str());
+        let constraints = BuildConstraints::find(&source)?;
+        let from_name = file_name.and_then(build_constraint::file_name_constraint);
+        let builds = build_constraint::file_constraint(file_name, &source)?;
+        // Go reads only the //go:build line, so stale +build lines mislead
+        // older tools and readers
+        let plus_build_matches = match (&constraints.go_build, constraints.plus_build_expr()) {
+            (Some(go_build), Some(plus_build)) => Some(plus_build.equivalent(&go_build.expr)),
+            _ => None,
+        };
+        let active = args["tags"].as_array().map(|tags| {
+            let tags: Vec<String> = tags
+                .iter()
+                .filter_map(|tag| tag.as_str().map(str::to_string))
+                .collect();
+            builds.as_ref().map_or(true, |expr| expr.matches(&tags))
+        });
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "go_build": constraints.go_build.as_ref().map(|line| serde_json::json!({
+                "line": line.line,
+                "constraint": line.expr.to_string(),
+            })),
+            "plus_build": constraints
+                .plus_build
+                .iter()
+                .map(|line| serde_json::json!({
+                    "line": line.line,
+                    "constraint": line.expr.to_string(),
+                }))
+                .collect::<Vec<_>>(),
+            "plus_build_matches": plus_build_matches,
+            "file_name_constraint": from_name.map(|expr| expr.to_string()),
+            "builds_when": builds.map(|expr| expr.to_string()),
+            "active": active,
+        }))?)
+    }
+
+    /// Add, replace or remove the `//go:build` line of a Go file, keeping
+    /// the blank line Go needs after it and dropping legacy `// +build`
+    /// lines.
+    async fn set_go_build_constraint(&self, args: Value) -> Result<String> {
+        // An empty constraint removes the line
+        let expr = args["constraint"]
+            .as_str()
+            .map(str::trim)
+            .filter(|constraint| !constraint.is_empty())
+            .map(|constraint| {
+                build_constraint::parse(constraint.strip_prefix("//go:build").unwrap_or(constraint))
+            })
+            .transpose()?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let constraints = BuildConstraints::find(&source)?;
+        let new_source = build_constraint::set_constraint(&source, expr.as_ref())?;
+        let edits: Vec<TextEdit> = edit_guard::edit_between(&source, &new_source)
+            .into_iter()
+            .collect();
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "before": constraints.expr().map(|expr| expr.to_string()),
+            "after": expr.map(|expr| expr.to_string()),
+            "plus_build_removed": constraints.plus_build.len(),
+            "changed": !edits.is_empty(),
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// The node in `field` of `function` (its `parameters`, `body`,
+    /// `result`...), or `None` when it has none.
+    async fn function_field(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+        function: &NodeSpan,
+        field: &str,
+    ) -> Result<Option<NodeSpan>> {
+        Ok(self
+            .function_fields(source, path, language, field)
+            .await?
+            .into_iter()
+            .find(|(owner, _)| (owner.start, owner.end) == (function.start, function.end))
+            .map(|(_, node)| node))
+    }
+
+    /// Each function of `source` that has a `field`, with the node in it.
+    async fn function_fields(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+        field: &str,
+    ) -> Result<Vec<(NodeSpan, NodeSpan)>> {
+        let kinds = self
+            .get_function_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rule_config = format!(
+            "id: function-field\nlanguage: {language}\nrule:\n  any: [{kinds}]\n  has: {{ field: {field}, pattern: $FIELD }}\n"
+        );
+        Ok(self
+            .scan_source_json(&rule_config, source, path, language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                Some((
+                    NodeSpan::from_match(m)?,
+                    NodeSpan::from_match(&m["metaVariables"]["single"]["FIELD"])?,
+                ))
+            })
+            .collect())
+    }
+
+    /// Type of the Go variable `name` where its declaration states or
+    /// implies one: a parameter in one of the `params` lists, a
+    /// `var name T`, or a `:=`/`var =` of a literal in `head`.
+    fn go_declared_type(&self, head: &str, params: &[&str], name: &str) -> Option<String> {
+        let escaped = regex::escape(name);
+        let declared_type = |pattern: String| {
+            regex::Regex::new(&pattern)
+                .ok()
+                .and_then(|declaration| declaration.captures(head))
+                .map(|captures| captures[1].trim().to_string())
+        };
+        params
+            .iter()
+            .flat_map(|params| edit_utils::go_results(params))
+            .find(|(param, _)| param.as_deref() == Some(name))
+            .map(|(_, param_type)| match param_type.strip_prefix("...") {
+                Some(element) => format!("[]{element}"),
+                None => param_type,
+            })
+            .or_else(|| {
+                declared_type(format!(
+                    r"(?m)^\s*var\s+(?:\w+\s*,\s*)*{escaped}(?:\s*,\s*\w+)*\s+([^=\n]+?)\s*(?:=.*)?$"
+                ))
+            })
+            .or_else(|| {
+                declared_type(format!(
+                    r"(?m)^\s*(?:var\s+{escaped}\s*=|{escaped}\s*:=)\s*(.+?)\s*$"
+                ))
+                .and_then(|init| edit_utils::go_literal_type(&init))
+            })
+    }
+
+    /// Move the statements of a function from `split_line` on into a new
+    /// helper, leaving a call to it in their place. Parameters and locals
+    /// of the first half that the moved statements read become the
+    /// helper's parameters; in Go and TypeScript their types are taken
+    /// from their declarations where those state or imply one, and the
+    /// rest are reported as `untyped`.
+    async fn split_function(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let split_line = args["split_line"]
+            .as_u64()
+            .ok_or(anyhow!("Missing split_line"))? as usize;
+        let helper = args["helper_name"]
+            .as_str()
+            .ok_or(anyhow!("Missing helper_name"))?;
+
+        self.validate_language(language)?;
+        if !matches!(language, "javascript" | "typescript" | "python" | "go") {
+            return Err(anyhow!(
+                "split_function does not support {} yet (supported: javascript, typescript, python, go)",
+                language
+            ));
+        }
+        if !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(helper) {
+            return Err(anyhow!("'{}' is not a valid function name", helper));
+        }
+        let (source, path) = self.load_source(&args).await?;
+
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let (function_name, function) = self.select_function(&args, &source, &functions)?;
+        let function_name = function_name
+            .as_deref()
+            .ok_or_else(|| anyhow!("split_function cannot split an anonymous function"))?;
+        if matches!(language, "javascript" | "typescript")
+            && !(function.text.starts_with("function")
+                || function.text.starts_with("async function"))
+        {
+            return Err(anyhow!(
+                "split_function only splits function declarations; {} is a method",
+                function_name
+            ));
+        }
+        if functions
+            .iter()
+            .any(|(name, _)| name.as_deref() == Some(helper))
+        {
+            return Err(anyhow!("A function named '{}' already exists", helper));
+        }
+        let field = |field: &'static str| {
+            self.function_field(&source, path.as_deref(), language, function, field)
+        };
+        let body = field("body")
+            .await?
+            .ok_or_else(|| anyhow!("{} has no body to split", function_name))?;
+        let params = field("parameters").await?;
+        let result = match language {
+            "go" => field("result").await?,
+            "typescript" | "python" => field("return_type").await?,
+            _ => None,
+        };
+        let receiver = match language {
+            "go" => field("receiver").await?,
+            _ => None,
+        };
+
+        // The first half is head_start..split, the moved half split..tail_end
+        let braces = language != "python";
+        let head_start = match braces {
+            true => edit_utils::skip_trivia(&source, body.start + 1),
+            false => body.start,
+        };
+        let tail_end = match braces {
+            true => body.start + source[body.start..body.end - 1].trim_end().len(),
+            false => body.end,
+        };
+        let split = edit_utils::offset_from_position(&source, split_line, 1)
+            .map(|line| line + edit_utils::indentation_at(&source, line).len())
+            .filter(|split| head_start < *split && *split < tail_end)
+            .ok_or_else(|| {
+                anyhow!(
+                    "Line {} is not in the body of {} after its first statement",
+                    split_line,
+                    function_name
+                )
+            })?;
+        let body_indent = edit_utils::indentation_at(&source, head_start);
+        if edit_utils::bracket_depth(&source[head_start..split]) != 0
+            || edit_utils::indentation_at(&source, split) != body_indent
+        {
+            return Err(anyhow!(
+                "Line {} does not start a statement directly in the body of {}",
+                split_line,
+                function_name
+            ));
+        }
+        let tail = &source[split..tail_end];
+        let mentions = |name: &str| {
+            regex::Regex::new(&format!(r"\b{}\b", regex::escape(name)))
+                .map(|word| word.is_match(tail))
+                .unwrap_or(false)
+        };
+        let unmovable: &[&str] = match language {
+            "python" => &["yield"],
+            "go" => &[],
+            _ => &["this", "super", "arguments", "yield"],
+        };
+        if let Some(keyword) = unmovable.iter().find(|keyword| mentions(keyword)) {
+            return Err(anyhow!(
+                "Refusing to split {}: the moved statements use {}, which would mean something else in {}",
+                function_name,
+                keyword,
+                helper
+            ));
+        }
+        if language == "go"
+            && result.as_ref().is_some_and(|result| {
+                edit_utils::go_results(&result.text)
+                    .iter()
+                    .any(|(name, _)| name.is_some())
+            })
+        {
+            return Err(anyhow!(
+                "{} has named results; convert them with convert_go_returns first",
+                function_name
+            ));
+        }
+
+        // A method's helper is a method of the same receiver
+        let receiver_name = match (language, &receiver, &params) {
+            ("go", Some(receiver), _) => edit_utils::go_results(&receiver.text)
+                .into_iter()
+                .find_map(|(name, _)| name),
+            ("python", _, Some(params)) => params
+                .text
+                .trim_start_matches('(')
+                .split([',', ')'])
+                .next()
+                .map(str::trim)
+                .filter(|first| *first == "self")
+                .map(|first| first.to_string()),
+            _ => None,
+        };
+
+        // Parameters: names the first half binds that the moved half reads
+        let (binding_rule, read_rule) = self.build_identifier_rules(language)?;
+        let reads: std::collections::HashSet<String> = self
+            .scan_source_json(&read_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| split <= span.start && span.end <= tail_end)
+            .map(|span| span.text)
+            .collect();
+        let mut bindings: Vec<NodeSpan> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| function.start <= span.start && span.end <= split)
+            .collect();
+        bindings.sort_by_key(|span| span.start);
+        let mut parameters: Vec<String> = Vec::new();
+        for span in bindings {
+            let name = span.text;
+            if reads.contains(&name)
+                && name != function_name
+                && name != "_"
+                && receiver_name.as_deref() != Some(name.as_str())
+                && !parameters.contains(&name)
+            {
+                parameters.push(name);
+            }
+        }
+
+        let head = &source[function.start..split];
+        let declared_type = |pattern: String| {
+            regex::Regex::new(&pattern)
+                .ok()
+                .and_then(|declaration| declaration.captures(head))
+                .map(|captures| captures[1].trim().to_string())
+        };
+        let mut untyped = Vec::new();
+        let mut typed_parameters = Vec::new();
+        for name in &parameters {
+            let escaped = regex::escape(name);
+            let parameter_type = match language {
+                "go" => {
+                    let params: Vec<&str> =
+                        params.iter().map(|params| params.text.as_str()).collect();
+                    self.go_declared_type(head, &params, name)
+                }
+                "typescript" => params
+                    .iter()
+                    .flat_map(|params| edit_utils::typescript_parameters(&params.text))
+                    .find(|(param, _)| param == name)
+                    .and_then(|(_, annotation)| annotation)
+                    .or_else(|| {
+                        declared_type(format!(
+                            r"\b(?:let|const|var)\s+{escaped}\s*:\s*([^=;]+?)\s*="
+                        ))
+                    }),
+                _ => None,
+            };
+            typed_parameters.push(match (language, parameter_type) {
+                ("go", Some(parameter_type)) => format!("{name} {parameter_type}"),
+                ("typescript", Some(parameter_type)) => format!("{name}: {parameter_type}"),
+                ("go", None) => {
+                    untyped.push(name.clone());
+                    format!("{name} any")
+                }
+                ("typescript", None) => {
+                    untyped.push(name.clone());
+                    name.clone()
+                }
+                _ => name.clone(),
+            });
+        }
+
+        let is_async = function.text.starts_with("async") && mentions("await");
+        let async_keyword = if is_async { "async " } else { "" };
+        let callee = match &receiver_name {
+            Some(receiver_name) => format!("{receiver_name}.{helper}"),
+            None => helper.to_string(),
+        };
+        let mut call = format!("{callee}({})", parameters.join(", "));
+        if is_async {
+            call = format!("await {call}");
+        }
+        let call = match language {
+            "go" if result.is_none() => call,
+            "javascript" | "typescript" => format!("return {call};"),
+            _ => format!("return {call}"),
+        };
+        let indent = edit_utils::indentation_at(&source, function.start);
+        let result_text = result.as_ref().map(|result| result.text.as_str());
+        let helper_source = match language {
+            "python" => {
+                let self_parameter = receiver_name.iter().cloned();
+                let helper_parameters: Vec<String> =
+                    self_parameter.chain(typed_parameters).collect();
+                let returns = result_text
+                    .map(|result| format!(" -> {result}"))
+                    .unwrap_or_default();
+                format!(
+                    "{async_keyword}def {helper}({}){returns}:\n{body_indent}{tail}",
+                    helper_parameters.join(", ")
+                )
+            }
+            "go" => {
+                let method_receiver = match (&receiver, &receiver_name) {
+                    (Some(receiver), Some(_)) => format!("{} ", receiver.text),
+                    _ => String::new(),
+                };
+                let results = result_text
+                    .map(|result| format!(" {result}"))
+                    .unwrap_or_default();
+                format!(
+                    "func {method_receiver}{helper}({}){results} {{\n{body_indent}{tail}\n{indent}}}",
+                    typed_parameters.join(", ")
+                )
+            }
+            _ => format!(
+                "{async_keyword}function {helper}({}){} {{\n{body_indent}{tail}\n{indent}}}",
+                typed_parameters.join(", "),
+                result_text.unwrap_or_default()
+            ),
+        };
+        let separator = match language == "python" && indent.is_empty() {
+            true => "\n\n\n",
+            false => "\n\n",
+        };
+        let edits = vec![
+            TextEdit {
+                start: split,
+                end: tail_end,
+                replacement: call,
+            },
+            TextEdit {
+                start: function.end,
+                end: function.end,
+                replacement: format!("{separator}{indent}{helper_source}"),
+            },
+        ];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let before = self.count_syntax_errors(&source, language).await?;
+        if self.count_syntax_errors(&new_source, language).await? > before {
+            return Err(anyhow!(
+                "Splitting {} at line {} does not parse as {}; pick a line that starts a statement",
+                function_name,
+                split_line,
+                language
+            ));
+        }
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "function": function_name,
+            "helper": helper,
+            "parameters": parameters,
+            "untyped": untyped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Append the statements of the `second` function to the body of the
+    /// `first` and remove the second, with its doc comment. The two must
+    /// have the same signature, apart from their names, unless `signature`
+    /// gives the merged function's; the second's statements are copied as
+    /// written either way. With `update_calls` the references to the
+    /// second in this file are renamed to the first.
+    async fn merge_functions(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let signature = args["signature"].as_str().map(str::trim);
+        let update_calls = args["update_calls"].as_bool().unwrap_or(false);
+
+        self.validate_language(language)?;
+        if !matches!(language, "javascript" | "typescript" | "python" | "go") {
+            return Err(anyhow!(
+                "merge_functions does not support {} yet (supported: javascript, typescript, python, go)",
+                language
+            ));
+        }
+        let (source, path) = self.load_source(&args).await?;
+        let functions = self
+            .scan_functions(&source, path.as_deref(), language)
+            .await?;
+        let mut selected = Vec::new();
+        for key in ["first", "second"] {
+            let mut selector = args[key].clone();
+            if !selector.is_object() {
+                return Err(anyhow!("Missing {}: give its name or position", key));
+            }
+            selector["offsetEncoding"] = args["offsetEncoding"].clone();
+            let (name, function) = self.select_function(&selector, &source, &functions)?;
+            let name = name
+                .clone()
+                .ok_or_else(|| anyhow!("merge_functions cannot merge an anonymous function"))?;
+            selected.push((name, function.clone()));
+        }
+        let (first_name, first) = &selected[0];
+        let (second_name, second) = &selected[1];
+        let within =
+            |span: &NodeSpan, outer: &NodeSpan| outer.start <= span.start && span.end <= outer.end;
+        if first == second {
+            return Err(anyhow!("first and second are both {}", first_name));
+        }
+        if within(first, second) || within(second, first) {
+            return Err(anyhow!(
+                "{} and {} are nested one inside the other",
+                first_name,
+                second_name
+            ));
+        }
+
+        // Both must be declared in the same scope: a function, a class, or
+        // the file
+        let class_kinds: &[&str] = match language {
+            "python" => &["class_definition"],
+            "go" => &[],
+            _ => &["class_declaration", "class", "abstract_class_declaration"],
+        };
+        let mut scopes: Vec<NodeSpan> = functions.iter().map(|(_, span)| span.clone()).collect();
+        if !class_kinds.is_empty() {
+            let kinds = class_kinds
+                .iter()
+                .map(|kind| format!("{{ kind: {kind} }}"))
+                .collect::<Vec<_>>()
+                .join(", ");
+            scopes.extend(
+                self.scan_source_json(
+                    &format!("id: classes\nlanguage: {language}\nrule:\n  any: [{kinds}]\n"),
+                    &source,
+                    path.as_deref(),
+                    language,
+                )
+                .await?
+                .iter()
+                .filter_map(NodeSpan::from_match),
+            );
+        }
+        let scope = |function: &NodeSpan| {
+            scopes
+                .iter()
+                .filter(|scope| *scope != function && within(function, scope))
+                .min_by_key(|scope| scope.end - scope.start)
+                .map(|scope| scope.start)
+        };
+        let in_scope = scope(first);
+        if in_scope != scope(second) {
+            return Err(anyhow!(
+                "{} and {} are not declared in the same scope",
+                first_name,
+                second_name
+            ));
+        }
+        if language == "python"
+            && edit_utils::declaration_line_start(&source, second.start)
+                < edit_utils::line_start(&source, second.start)
+        {
+            return Err(anyhow!(
+                "{} is decorated; merging it would drop its decorators",
+                second_name
+            ));
+        }
+        if matches!(language, "javascript" | "typescript")
+            && source[edit_utils::line_start(&source, second.start)..second.start]
+                .trim()
+                .starts_with("export")
+        {
+            return Err(anyhow!(
+                "{} is exported, and other modules may import it",
+                second_name
+            ));
+        }
+
+        let mut bodies = Vec::new();
+        let mut headers = Vec::new();
+        for (name, function) in &selected {
+            let body = self
+                .function_field(&source, path.as_deref(), language, function, "body")
+                .await?
+                .ok_or_else(|| anyhow!("{} has no body to merge", name))?;
+            // The signature runs up to the body's brace, or the colon before it
+            let head = source[function.start..body.start].trim_end();
+            let head = match language {
+                "python" => head.strip_suffix(':').unwrap_or(head).trim_end(),
+                _ => head,
+            };
+            let prefix =
+                source[edit_utils::line_start(&source, function.start)..function.start].trim();
+            if (language != "python" && !body.text.starts_with('{'))
+                || head.contains("=>")
+                || prefix.ends_with(['=', ':', '(', ','])
+            {
+                return Err(anyhow!(
+                    "{} is a function expression; merge_functions merges declarations and methods",
+                    name
+                ));
+            }
+            headers.push(head.to_string());
+            bodies.push(body);
+        }
+        let (first_body, second_body) = (&bodies[0], &bodies[1]);
+        let whitespace = regex::Regex::new(r"\s+")?;
+        let normalize = |header: &str, name: &str| {
+            let unnamed = regex::Regex::new(&format!(r"\b{}\b", regex::escape(name)))
+                .map(|word| word.replace(header, "").to_string())
+                .unwrap_or_else(|_| header.to_string());
+            whitespace
+                .replace_all(&unnamed, " ")
+                .replace("( ", "(")
+                .replace(" )", ")")
+        };
+        let mismatch = normalize(&headers[0], first_name) != normalize(&headers[1], second_name);
+        let mut warnings = Vec::new();
+        match signature {
+            None if mismatch => {
+                return Err(anyhow!(
+                    "{} and {} have different signatures (`{}` and `{}`); pass the merged signature",
+                    first_name,
+                    second_name,
+                    whitespace.replace_all(&headers[0], " "),
+                    whitespace.replace_all(&headers[1], " ")
+                ));
+            }
+            Some(signature) => {
+                let declares =
+                    regex::Regex::new(&format!(r"\b{}\s*[(\[<]", regex::escape(first_name)))?;
+                if !declares.is_match(signature) {
+                    return Err(anyhow!(
+                        "The signature must keep the name {}; rename the merged function afterwards",
+                        first_name
+                    ));
+                }
+                if mismatch {
+                    warnings.push(format!(
+                        "{second_name}'s statements are copied as written, so they must use the merged signature's parameter names."
+                    ));
+                }
+            }
+            None => {}
+        }
+
+        let blocks = self
+            .statements_by_block(language, &source, path.as_deref())
+            .await?;
+        let statements = |body: &NodeSpan| {
+            blocks
+                .iter()
+                .find(|(block, _)| (block.start, block.end) == (body.start, body.end))
+                .map(|(_, statements)| statements.clone())
+                .unwrap_or_default()
+        };
+        let first_statements = statements(first_body);
+        let mut second_statements = statements(second_body);
+        if let Some((_, last)) = first_statements.last() {
+            if let Some(exit) = Self::block_exit(language, &last.text) {
+                return Err(anyhow!(
+                    "{} ends with `{}` on line {}, so the statements merged after it would never run",
+                    first_name,
+                    exit,
+                    edit_utils::line_number(&source, last.start)
+                ));
+            }
+        }
+        let nested: Vec<&NodeSpan> = functions
+            .iter()
+            .map(|(_, span)| span)
+            .filter(|span| *span != first && within(span, first_body))
+            .collect();
+        let early_returns: Vec<usize> = regex::Regex::new(r"\breturn\b")?
+            .find_iter(&source[first_body.start..first_body.end])
+            .map(|found| first_body.start + found.start())
+            .filter(|at| {
+                !nested
+                    .iter()
+                    .any(|span| span.start <= *at && *at < span.end)
+            })
+            .map(|at| edit_utils::line_number(&source, at))
+            .collect();
+        if !early_returns.is_empty() {
+            warnings.push(format!(
+                "{first_name} can return early (line {}), and then {second_name}'s statements do not run.",
+                early_returns
+                    .iter()
+                    .map(|line| line.to_string())
+                    .collect::<Vec<_>>()
+                    .join(", ")
+            ));
+        }
+
+        // The second's statements, without a Python docstring
+        let mut copy_start = match language {
+            "python" => edit_utils::line_start(&source, second_body.start),
+            _ => match source
+                [second_body.start + 1..edit_utils::line_end(&source, second_body.start)]
+                .trim()
+                .is_empty()
+            {
+                true => edit_utils::line_end(&source, second_body.start),
+                false => second_body.start + 1,
+            },
+        };
+        let copy_end = match language {
+            "python" => second_body.end,
+            _ => {
+                second_body.start
+                    + source[second_body.start..second_body.end - 1]
+                        .trim_end()
+                        .len()
+            }
+        };
+        if language == "python"
+            && second_statements.first().is_some_and(|(kind, statement)| {
+                kind == "expression_statement" && statement.text.starts_with(['"', '\''])
+            })
+        {
+            let (_, docstring) = second_statements.remove(0);
+            copy_start = edit_utils::line_end(&source, docstring.end).min(copy_end);
+            warnings.push(format!("{second_name}'s docstring was dropped."));
+        }
+
+        // Locals both bodies declare
+        let (binding_rule, _) = self.build_identifier_rules(language)?;
+        let bindings: Vec<NodeSpan> = self
+            .scan_source_json(&binding_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let bound_in = |body: &NodeSpan| -> std::collections::BTreeSet<String> {
+            bindings
+                .iter()
+                .filter(|span| within(span, body))
+                .map(|span| span.text.clone())
+                .filter(|name| name != "_")
+                .collect()
+        };
+        let shared: Vec<String> = bound_in(first_body)
+            .intersection(&bound_in(second_body))
+            .cloned()
+            .collect();
+        if !shared.is_empty() {
+            warnings.push(match language {
+                "python" => format!(
+                    "Both bodies assign {}, which the merged body shares.",
+                    shared.join(", ")
+                ),
+                _ => format!(
+                    "Both bodies declare {}, which the merged body would declare twice; rename them in one function first.",
+                    shared.join(", ")
+                ),
+            });
+        }
+
+        // References to the second, renamed in the copy and the rest of the file
+        let mut edits = Vec::new();
+        let mut copy_edits = Vec::new();
+        let mut calls_updated = Vec::new();
+        let is_method = in_scope.is_some()
+            || (language == "go"
+                && self
+                    .function_field(&source, path.as_deref(), language, second, "receiver")
+                    .await?
+                    .is_some());
+        if update_calls && is_method {
+            return Err(anyhow!(
+                "update_calls only follows calls of top-level functions; {} is not one",
+                second_name
+            ));
+        }
+        let references: Vec<NodeSpan> = match is_method {
+            true => Vec::new(),
+            false => self
+                .collect_references(&source, path.as_deref(), language, second_name)
+                .await?
+                .0
+                .into_iter()
+                .filter(|(_, _, kind)| *kind != "declaration")
+                .map(|(span, _, _)| span)
+                .collect(),
+        };
+        let mut stale = Vec::new();
+        for span in &references {
+            let line = edit_utils::line_number(&source, span.start);
+            if within(span, second) {
+                if update_calls && copy_start <= span.start && span.end <= copy_end {
+                    copy_edits.push(TextEdit {
+                        start: span.start - copy_start,
+                        end: span.end - copy_start,
+                        replacement: first_name.clone(),
+                    });
+                }
+                continue;
+            }
+            if !update_calls {
+                stale.push(line.to_string());
+                continue;
+            }
+            let line_start = edit_utils::line_start(&source, span.start);
+            let line_end = edit_utils::line_end(&source, span.start);
+            calls_updated.push(serde_json::json!({
+                "line": line,
+                "text": source[line_start..line_end].trim(),
+            }));
+            edits.push(TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement: first_name.clone(),
+            });
+        }
+        if !stale.is_empty() {
+            warnings.push(format!(
+                "{second_name} is still referred to on line {}; set update_calls to refer to {first_name} instead.",
+                stale.join(", ")
+            ));
+        }
+        if !copy_edits.is_empty() {
+            warnings.push(format!(
+                "{second_name} refers to itself, and the copied statements now refer to {first_name}."
+            ));
+        }
+        if language == "go" && !is_method && second_name.starts_with(|c: char| c.is_uppercase()) {
+            warnings.push(format!(
+                "{second_name} is exported; callers in other packages are not updated."
+            ));
+        }
+
+        // Splice the copy in at the end of the first body
+        let copied = edit_utils::apply_edits(&source[copy_start..copy_end], &copy_edits)?;
+        let indent = match first_statements.first() {
+            Some((_, statement)) => {
+                edit_utils::indentation_at(&source, statement.start).to_string()
+            }
+            None => format!(
+                "{}{}",
+                edit_utils::indentation_at(&source, first.start),
+                edit_utils::indent_unit(&source)
+            ),
+        };
+        let copied = edit_utils::reindent(&copied, &indent);
+        let copied = copied.trim_matches('\n');
+        if let Some(signature) = signature {
+            edits.push(TextEdit {
+                start: first.start,
+                end: first.start + headers[0].len(),
+                replacement: signature.to_string(),
+            });
+        }
+        if !copied.is_empty() {
+            let (at, replacement) = match language {
+                "python" => (first_body.end, format!("\n{copied}")),
+                _ => {
+                    let close = first_body.end - 1;
+                    let close_line = edit_utils::line_start(&source, close);
+                    match source[close_line..close].trim().is_empty() {
+                        true => (close_line, format!("{copied}\n")),
+                        false => (
+                            close,
+                            format!(
+                                "\n{copied}\n{}",
+                                edit_utils::indentation_at(&source, first.start)
+                            ),
+                        ),
+                    }
+                }
+            };
+            edits.push(TextEdit {
+                start: at,
+                end: at,
+                replacement,
+            });
+        }
+        let comment_prefix = if language == "python" { "#" } else { "//" };
+        let (start, end) = edit_utils::declaration_removal_range(
+            &source,
+            second.start,
+            second.end,
+            comment_prefix,
+        );
+        edits.push(TextEdit {
+            start,
+            end,
+            replacement: String::new(),
+        });
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let before = self.count_syntax_errors(&source, language).await?;
+        if self.count_syntax_errors(&new_source, language).await? > before {
+            return Err(anyhow!(
+                "Merging {} into {} does not parse as {}; check the signature",
+                second_name,
+                first_name,
+                language
+            ));
+        }
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "merged": first_name,
+            "removed": second_name,
+            "signature": whitespace.replace_all(signature.unwrap_or(&headers[0]), " "),
+            "statements": second_statements.len(),
+            "calls_updated": calls_updated,
+            "warnings": warnings,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds that hold a bracketed, comma-separated list in `language`.
+    fn get_list_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "javascript" | "typescript" => Ok(&[
+                "arguments",
+                "formal_parameters",
+                "array",
+                "object",
+                "array_pattern",
+                "object_pattern",
+                "named_imports",
+                "type_arguments",
+                "type_parameters",
+            ]),
+            "python" => Ok(&[
+                "argument_list",
+                "parameters",
+                "list",
+                "tuple",
+                "set",
+                "dictionary",
+            ]),
+            "go" => Ok(&[
+                "argument_list",
+                "parameter_list",
+                "literal_value",
+                "type_arguments",
+                "type_parameter_list",
+            ]),
+            "rust" => Ok(&[
+                "arguments",
+                "parameters",
+                "array_expression",
+                "tuple_expression",
+                "field_initializer_list",
+                "field_declaration_list",
+                "use_list",
+                "type_arguments",
+            ]),
+            _ => Err(anyhow!(
+                "edit_list_element does not support '{}'. Supported languages: javascript, typescript, python, go, rust",
+                language
+            )),
+        }
+    }
+
+    /// Delete or insert an element of a comma-separated list (call
+    /// arguments, parameters, array items, struct literal fields) together
+    /// with its comma, so the list stays valid in either trailing-comma
+    /// style.
+    async fn edit_list_element(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let operation = args["operation"]
+            .as_str()
+            .ok_or(anyhow!("Missing operation"))?;
+
+        self.validate_language(language)?;
+        let kinds = self
+            .get_list_kinds(language)?
+            .iter()
+            .map(|kind| format!("    - kind: {kind}\n"))
+            .collect::<String>();
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        // The innermost list whose brackets enclose the position
+        let rule_config = format!("id: list\nlanguage: {language}\nrule:\n  any:\n{kinds}");
+        let list = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| span.start < start && end < span.end)
+            .filter(|span| {
+                span.text.starts_with(['(', '[', '{', '<'])
+                    && span.text.ends_with([')', ']', '}', '>'])
+            })
+            .min_by_key(|span| span.end - span.start)
+            .ok_or_else(|| anyhow!("No comma-separated list at the given position"))?;
+        let inner = (list.start + 1, list.end - 1);
+        let (elements, trailing_comma) =
+            edit_utils::list_elements(&source, inner.0, inner.1, language);
+
+        let (edit, index, element) = match operation {
+            "delete" => {
+                let index = match args["index"].as_u64() {
+                    Some(index) => index as usize,
+                    None => elements
+                        .iter()
+                        .position(|&(s, e)| s <= start && end <= e)
+                        .ok_or_else(|| {
+                            anyhow!(
+                                "The position is between list elements; pass index to choose one"
+                            )
+                        })?,
+                };
+                let &(element_start, element_end) = elements.get(index).ok_or_else(|| {
+                    anyhow!(
+                        "Index {} is past the end of a list of {}",
+                        index,
+                        elements.len()
+                    )
+                })?;
+                (
+                    edit_utils::list_delete_edit(&elements, inner, index),
+                    index,
+                    source[element_start..element_end].to_string(),
+                )
+            }
+            "insert" => {
+                let element = args["element"]
+                    .as_str()
+                    .ok_or(anyhow!("Missing element"))?
+                    .trim();
+                let index = args["index"]
+                    .as_u64()
+                    .map_or(elements.len(), |index| index as usize);
+                if index > elements.len() {
+                    return Err(anyhow!(
+                        "Index {} is past the end of a list of {}",
+                        index,
+                        elements.len()
+                    ));
+                }
+                (
+                    edit_utils::list_insert_edit(&source, &elements, inner, index, element),
+                    index,
+                    element.to_string(),
+                )
+            }
+            _ => {
+                return Err(anyhow!(
+                    "Unknown operation: {}. Use 'delete' or 'insert'",
+                    operation
+                ))
+            }
+        };
+        let edits = vec![edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "operation": operation,
+            "list": list.text,
+            "elements": elements.len(),
+            "trailing_comma": trailing_comma,
+            "index": index,
+            "element": element,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds of the lists `reorder_elements` sorts in `language`:
+    /// struct bodies and parameter lists.
+    fn get_reorder_container_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "go" => Ok(&["field_declaration_list", "parameter_list"]),
+            "rust" => Ok(&["field_declaration_list", "parameters"]),
+            "python" => Ok(&["parameters"]),
+            "javascript" => Ok(&["formal_parameters"]),
+            "typescript" => Ok(&["formal_parameters", "interface_body", "object_type"]),
+            "java" => Ok(&["formal_parameters"]),
+            "cpp" | "c++" | "c" => Ok(&["field_declaration_list", "parameter_list"]),
+            "csharp" | "cs" => Ok(&["parameter_list"]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Sort the fields of a struct or the parameters of a function by
+    /// name, by type, exported first, or into a given order, in one edit.
+    /// Comments and attributes move with their element and separators stay
+    /// where they are.
+    async fn reorder_elements(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let order = args["order"].as_str().ok_or(anyhow!("Missing order"))?;
+
+        self.validate_language(language)?;
+        let container_kinds = self.get_reorder_container_kinds(language)?;
+        let kinds: Vec<&str> = match args["container"].as_str() {
+            Some(kind) if container_kinds.contains(&kind) => vec![kind],
+            Some(kind) => {
+                return Err(anyhow!(
+                    "Cannot reorder a {} in {}; use one of {}",
+                    kind,
+                    language,
+                    container_kinds.join(", ")
+                ))
+            }
+            None => container_kinds.to_vec(),
+        };
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        let any_kind = kinds
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rule_config =
+            format!("id: reorder-container\nlanguage: {language}\nrule:\n  any: [{any_kind}]\n");
+        let containers: Vec<(String, NodeSpan)> = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| Some((m["kind"].as_str()?.to_string(), NodeSpan::from_match(m)?)))
+            .collect();
+        let (container_kind, container) = containers
+            .iter()
+            .filter(|(_, span)| span.start <= start && end <= span.end)
+            .min_by_key(|(_, span)| span.end - span.start)
+            .cloned()
+            .ok_or_else(|| {
+                anyhow!(
+                    "No {} covers the position",
+                    kinds.join(" or ").replace('_', " ")
+                )
+            })?;
+
+        // The container's named children; those of a list nested in it,
+        // such as an anonymous struct's fields, are left out
+        let rule_config = format!(
+            "id: reorder-children\nlanguage: {language}\nrule:\n  nthChild:\n    position: n+1\n  inside:\n    kind: {container_kind}\n"
+        );
+        let nested: Vec<&NodeSpan> = containers
+            .iter()
+            .filter(|(kind, span)| {
+                *kind == container_kind && container.start < span.start && span.end <= container.end
+            })
+            .map(|(_, span)| span)
+            .collect();
+        let attached_kinds: Vec<&str> = self
+            .get_comment_kinds(language)?
+            .iter()
+            .copied()
+            .chain(["attribute_item", "attribute_list"])
+            .collect();
+        let mut children: Vec<(String, NodeSpan)> = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| Some((m["kind"].as_str()?.to_string(), NodeSpan::from_match(m)?)))
+            .filter(|(_, span)| container.start < span.start && span.end <= container.end)
+            .filter(|(_, span)| {
+                !nested
+                    .iter()
+                    .any(|list| list.start <= span.start && span.end <= list.end)
+            })
+            .collect();
+        children.sort_by_key(|(_, span)| span.start);
+        let flagged: Vec<(NodeSpan, bool)> = children
+            .iter()
+            .map(|(kind, span)| (span.clone(), attached_kinds.contains(&kind.as_str())))
+            .collect();
+        let items = edit_utils::list_items(&source, &flagged);
+        let elements: Vec<&(String, NodeSpan)> = children
+            .iter()
+            .filter(|(kind, _)| !attached_kinds.contains(&kind.as_str()))
+            .collect();
+
+        if let Some((kind, span)) = elements.iter().find(|(kind, _)| {
+            matches!(
+                kind.as_str(),
+                "list_splat_pattern"
+                    | "dictionary_splat_pattern"
+                    | "keyword_separator"
+                    | "positional_separator"
+            )
+        }) {
+            return Err(anyhow!(
+                "Cannot reorder parameters around '{}' ({}): it changes how the ones around it are passed",
+                span.text,
+                kind
+            ));
+        }
+        // The receiver stays first and a variadic parameter last
+        let pinned_first = |(kind, span): &(String, NodeSpan)| {
+            matches!(kind.as_str(), "self_parameter" | "receiver_parameter")
+                || (language == "python" && matches!(span.text.as_str(), "self" | "cls"))
+        };
+        let pinned_last = |(kind, span): &(String, NodeSpan)| {
+            matches!(
+                kind.as_str(),
+                "variadic_parameter_declaration"
+                    | "variadic_parameter"
+                    | "spread_parameter"
+                    | "rest_pattern"
+            ) || span.text.starts_with("...")
+                || span.text.starts_with("params ")
+        };
+        let first = elements
+            .first()
+            .is_some_and(|element| pinned_first(element)) as usize;
+        let last = elements
+            .last()
+            .is_some_and(|element| elements.len() > first && pinned_last(element))
+            as usize;
+        let mut movable: Vec<usize> = (first..elements.len() - last).collect();
+
+        let name = |index: usize| edit_utils::declared_name(&elements[index].1.text, language);
+        match order {
+            "alphabetical" => movable.sort_by_key(|&index| name(index).to_lowercase()),
+            "by_type" => movable.sort_by_key(|&index| {
+                edit_utils::declared_type(&elements[index].1.text, language).to_string()
+            }),
+            "exported_first" => {
+                let mut ranked = Vec::with_capacity(movable.len());
+                for index in movable {
+                    let exported =
+                        edit_utils::is_exported_declaration(&elements[index].1.text, language)
+                            .ok_or_else(|| {
+                                anyhow!("{} has no notion of exported elements", language)
+                            })?;
+                    ranked.push((!exported, index));
+                }
+                ranked.sort_by_key(|&(unexported, _)| unexported);
+                movable = ranked.into_iter().map(|(_, index)| index).collect();
+            }
+            "custom" => {
+                let names: Vec<&str> = args["names"]
+                    .as_array()
+                    .ok_or(anyhow!("Missing names for the custom order"))?
+                    .iter()
+                    .filter_map(|name| name.as_str())
+                    .collect();
+                let mut listed = Vec::with_capacity(names.len());
+                for wanted in &names {
+                    let index = (0..elements.len())
+                        .find(|&index| name(index) == *wanted)
+                        .ok_or_else(|| anyhow!("No element named '{}' in the list", wanted))?;
+                    if !movable.contains(&index) {
+                        return Err(anyhow!(
+                            "'{}' has to stay where it is and cannot be reordered",
+                            wanted
+                        ));
+                    }
+                    if listed.contains(&index) {
+                        return Err(anyhow!("'{}' is listed twice", wanted));
+                    }
+                    listed.push(index);
+                }
+                // Elements not listed follow in their current order
+                movable.retain(|index| !listed.contains(index));
+                listed.append(&mut movable);
+                movable = listed;
+            }
+            _ => {
+                return Err(anyhow!(
+                    "Unknown order: {}. Use alphabetical, by_type, exported_first or custom",
+                    order
+                ))
+            }
+        }
+        let permutation: Vec<usize> = (0..first)
+            .chain(movable)
+            .chain(elements.len() - last..elements.len())
+            .collect();
+
+        // Python requires parameters without defaults before those with them
+        if language == "python" {
+            let has_default = |index: usize| elements[index].0.contains("default_parameter");
+            if let Some(pair) = permutation
+                .windows(2)
+                .find(|pair| has_default(pair[0]) && !has_default(pair[1]))
+            {
+                return Err(anyhow!(
+                    "This order puts '{}' after '{}', which has a default value",
+                    name(pair[1]),
+                    name(pair[0])
+                ));
+            }
+        }
+
+        let changed = permutation
+            .iter()
+            .enumerate()
+            .any(|(slot, &index)| slot != index);
+        let edits = edit_utils::reorder_edits(&source, &items, &permutation)?;
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "container": container_kind,
+            "order": permutation.iter().map(|&index| name(index)).collect::<Vec<_>>(),
+            "changed": changed,
+            "warning": match container_kind.contains("parameter") {
+                true => "Call sites are not updated; arguments passed by position now go to other parameters",
+                false => "Composite literals and initializers that list values by position are not updated",
+            },
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Patterns for a local variable declared with an initializer, capturing
+    /// `$NAME` and `$INIT`.
+    fn get_declaration_patterns(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "javascript" | "typescript" => Ok(&[
+                "const $NAME = $INIT",
+                "let $NAME = $INIT",
+                "var $NAME = $INIT",
+            ]),
+            "rust" => Ok(&["let $NAME = $INIT;", "let $NAME: $TYPE = $INIT;"]),
+            "python" => Ok(&["$NAME = $INIT"]),
+            "go" => Ok(&[
+                "$NAME := $INIT",
+                "var $NAME = $INIT",
+                "var $NAME $TYPE = $INIT",
+            ]),
+            "java" | "csharp" | "cs" | "c" | "cpp" | "c++" => Ok(&["$TYPE $NAME = $INIT;"]),
+            "swift" => Ok(&[
+                "let $NAME = $INIT",
+                "let $NAME: $TYPE = $INIT",
+                "var $NAME = $INIT",
+            ]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Replace each read of a single-assignment local with its initializer
+    /// and remove the declaration. All substitutions are applied in one pass
+    /// against the original offsets.
+    async fn inline_variable(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let name = args["name"].as_str().ok_or(anyhow!("Missing name"))?;
+        let side_effect_free = args["side_effect_free"].as_bool().unwrap_or(false);
+
+        self.validate_language(language)?;
+        if !regex::Regex::new(r"^[A-Za-z_][A-Za-z0-9_]*$")?.is_match(name) {
+            return Err(anyhow!("'{}' is not a valid variable name", name));
+        }
+        let (source, path) = self.load_source(&args).await?;
+
+        let patterns = self
+            .get_declaration_patterns(language)?
+            .iter()
+            .map(|pattern| format!("    - pattern: \"{pattern}\"\n"))
+            .collect::<String>();
+        let declaration_rule = format!(
+            "id: inline-variable-declaration\nlanguage: {language}\nrule:\n  any:\n{patterns}constraints:\n  NAME:\n    regex: ^{name}$\n"
+        );
+        let declarations: Vec<(NodeSpan, String)> = self
+            .scan_source_json(&declaration_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let init = m["metaVariables"]["single"]["INIT"]["text"].as_str()?;
+                Some((NodeSpan::from_match(m)?, init.to_string()))
+            })
+            .collect();
+
+        // Pick the declaration at the given position, or the only one
+        let (declaration, init) = if args["position"].is_object() || args["start_byte"].is_u64() {
+            let (start, end) = self.get_target_range(&args, &source)?;
+            declarations
+                .iter()
+                .find(|(span, _)| span.start <= start && end <= span.end)
+                .cloned()
+                .ok_or_else(|| anyhow!("No declaration of '{}' at the given position", name))?
+        } else {
+            match declarations.as_slice() {
+                [only] => only.clone(),
+                [] => {
+                    return Err(anyhow!(
+                        "No declaration of '{}' with an initializer found",
+                        name
+                    ))
+                }
+                _ => {
+                    return Err(anyhow!(
+                        "'{}' is declared {} times; pass position to choose one",
+                        name,
+                        declarations.len()
+                    ))
+                }
+            }
+        };
+
+        // Uses are limited to the innermost function around the declaration
+        let function_rule = self.build_function_rule(language)?;
+        let (scope_start, scope_end) = self
+            .scan_source_json(&function_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| span.start <= declaration.start && declaration.end <= span.end)
+            .min_by_key(|span| span.end - span.start)
+            .map(|span| (span.start, span.end))
+            .unwrap_or((0, source.len()));
+
+        if declarations.iter().any(|(span, _)| {
+            span != &declaration && scope_start <= span.start && span.end <= scope_end
+        }) {
+            return Err(anyhow!(
+                "'{}' is assigned more than once in its function; only single-assignment variables can be inlined",
+                name
+            ));
+        }
+
+        let identifier_rule = format!(
+            "id: inline-variable-uses\nlanguage: {language}\nrule:\n  kind: identifier\n  regex: ^{name}$\n"
+        );
+        let uses: Vec<NodeSpan> = self
+            .scan_source_json(&identifier_rule, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| scope_start <= span.start && span.end <= scope_end)
+            .filter(|span| span.end <= declaration.start || span.start >= declaration.end)
+            .filter(|span| !edit_utils::is_member_name(&source, span))
+            .collect();
+
+        if let Some(span) = uses.iter().find(|span| span.end <= declaration.start) {
+            return Err(anyhow!(
+                "'{}' is used on line {} before its declaration",
+                name,
+                edit_utils::line_number(&source, span.start)
+            ));
+        }
+        if let Some(span) = uses
+            .iter()
+            .find(|span| edit_utils::is_write_access(&source, span))
+        {
+            return Err(anyhow!(
+                "'{}' is reassigned on line {}; only single-assignment variables can be inlined",
+                name,
+                edit_utils::line_number(&source, span.start)
+            ));
+        }
+        if !side_effect_free && !edit_utils::is_trivially_pure(&init) {
+            return Err(anyhow!(
+                "The initializer `{}` may have side effects, and inlining changes when and how often it runs. Pass side_effect_free: true if it is safe to duplicate.",
+                init
+            ));
+        }
+
+        let replacement = if edit_utils::needs_parentheses(&init) {
+            format!("({})", init.trim())
+        } else {
+            init.trim().to_string()
+        };
+        let (remove_start, remove_end) = edit_utils::statement_removal_range(&source, &declaration);
+        let mut edits: Vec<TextEdit> = uses
+            .iter()
+            .map(|span| TextEdit {
+                start: span.start,
+                end: span.end,
+                replacement: replacement.clone(),
+            })
+            .collect();
+        edits.push(TextEdit {
+            start: remove_start,
+            end: remove_end,
+            replacement: String::new(),
+        });
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "name": name,
+            "initializer": init,
+            "replaced": uses.len(),
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Line prefix recognised as a doc comment in `language`, and the style
+    /// used when a declaration has no doc comment yet.
+    fn get_doc_comment_syntax(&self, language: &str) -> Result<(&'static str, CommentStyle)> {
+        match language {
+            "rust" | "csharp" | "cs" | "swift" => Ok(("///", CommentStyle::Line("///"))),
+            "go" | "c" | "cpp" | "c++" => Ok(("//", CommentStyle::Line("//"))),
+            "python" => Ok(("#", CommentStyle::Line("#"))),
+            "java" | "javascript" | "typescript" => Ok(("//", CommentStyle::Block)),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Replace the doc comment above a named declaration, or insert one if
+    /// it has none. The comment prefix is re-applied to every line.
+    async fn replace_comment(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let name = args["name"].as_str().ok_or(anyhow!("Missing name"))?;
+        let comment = args["comment"].as_str().ok_or(anyhow!("Missing comment"))?;
+
+        self.validate_language(language)?;
+        let (line_prefix, default_style) = self.get_doc_comment_syntax(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let kind = match args["kind"].as_str() {
+            Some(kind) => format!("\n  kind: {kind}"),
+            None => String::new(),
+        };
+        let name_field = self.field_name(language, "NAME")?;
+        let rule_config = format!(
+            "id: replace-comment\nlanguage: {language}\nrule:{kind}\n  has:\n    field: {name_field}\n    regex: ^{}$\n",
+            regex::escape(name)
+        );
+        let declarations: Vec<NodeSpan> = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let declaration = match declarations.as_slice() {
+            [only] => only,
+            [] => return Err(anyhow!("No declaration named '{}' found", name)),
+            _ => {
+                let lines: Vec<String> = declarations
+                    .iter()
+                    .map(|span| edit_utils::line_number(&source, span.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "'{}' is declared on lines {}; pass kind to choose one",
+                    name,
+                    lines.join(", ")
+                ));
+            }
+        };
+
+        let indent = edit_utils::indentation_at(&source, declaration.start);
+        let (edit, action) =
+            match edit_utils::leading_comment_range(&source, declaration.start, line_prefix) {
+                Some((start, end)) => {
+                    let style =
+                        edit_utils::detect_comment_style(&source[start..end], default_style);
+                    let edit = TextEdit {
+                        start,
+                        end,
+                        replacement: edit_utils::render_comment(comment, style, indent),
+                    };
+                    (edit, "replaced")
+                }
+                None => {
+                    let start = edit_utils::declaration_line_start(&source, declaration.start);
+                    let edit = TextEdit {
+                        start,
+                        end: start,
+                        replacement: edit_utils::render_comment(comment, default_style, indent),
+                    };
+                    (edit, "inserted")
+                }
+            };
+        let line = edit_utils::line_number(&source, edit.start);
+        let edits = [edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "name": name,
+            "action": action,
+            "line": line,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds for string literals that may hold code in another language.
+    fn get_string_literal_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            "go" => Ok(&["raw_string_literal", "interpreted_string_literal"]),
+            "rust" => Ok(&["raw_string_literal", "string_literal"]),
+            "python" => Ok(&["string"]),
+            "javascript" | "typescript" => Ok(&["string", "template_string"]),
+            "java" => Ok(&["string_literal"]),
+            "c" | "cpp" | "c++" => Ok(&["string_literal", "raw_string_literal"]),
+            "csharp" | "cs" => Ok(&[
+                "string_literal",
+                "verbatim_string_literal",
+                "raw_string_literal",
+            ]),
+            "swift" => Ok(&[
+                "line_string_literal",
+                "multi_line_string_literal",
+                "raw_string_literal",
+            ]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Call node kinds of `language`, each with the `has` rule selecting
+    /// its argument list.
+    fn get_call_kinds(&self, language: &str) -> Result<&'static [(&'static str, &'static str)]> {
+        match language {
+            "go" | "c" | "cpp" | "c++" => Ok(&[("call_expression", "field: arguments")]),
+            "rust" => Ok(&[
+                ("call_expression", "field: arguments"),
+                ("macro_invocation", "kind: token_tree"),
+            ]),
+            "python" => Ok(&[("call", "field: arguments")]),
+            "javascript" | "typescript" => Ok(&[
+                ("call_expression", "field: arguments"),
+                ("new_expression", "field: arguments"),
+            ]),
+            "java" => Ok(&[
+                ("method_invocation", "field: arguments"),
+                ("object_creation_expression", "field: arguments"),
+            ]),
+            "csharp" | "cs" => Ok(&[
+                ("invocation_expression", "field: arguments"),
+                ("object_creation_expression", "field: arguments"),
+            ]),
+            "swift" => Ok(&[("call_expression", "kind: call_suffix")]),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Node kinds whose string literals are never shown to users: import
+    /// paths, struct tags, attributes and the like.
+    fn get_non_user_facing_kinds(&self, language: &str) -> &'static [&'static str] {
+        match language {
+            "go" => &["import_spec", "field_declaration"],
+            "rust" => &["attribute_item", "inner_attribute_item"],
+            "javascript" | "typescript" => &["import_statement"],
+            "java" => &["annotation"],
+            "c" | "cpp" | "c++" => &["preproc_include"],
+            "csharp" | "cs" => &["attribute"],
+            _ => &[],
+        }
+    }
+
+    /// List the user-facing string literals of a file, optionally only
+    /// those passed directly to calls of `functions`, for externalizing
+    /// them into translations.
+    async fn extract_strings(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        // Callee patterns such as `fmt.Print*`, `*` matching anything
+        let functions = args["functions"]
+            .as_array()
+            .map(|functions| {
+                functions
+                    .iter()
+                    .filter_map(Value::as_str)
+                    .map(|function| {
+                        let pattern = regex::escape(function).replace(r"\*", ".*");
+                        regex::Regex::new(&format!("^{pattern}$"))
+                    })
+                    .collect::<Result<Vec<_>, _>>()
+            })
+            .transpose()?;
+
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let kinds = self
+            .get_string_literal_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        // A literal that is a whole statement is a docstring or directive
+        let excluded: String = self
+            .get_non_user_facing_kinds(language)
+            .iter()
+            .map(|kind| format!("      - inside: {{ kind: {kind}, stopBy: end }}\n"))
+            .collect();
+        let rule_config = format!(
+            "id: extract-strings\nlanguage: {language}\nrule:\n  any: [{kinds}]\n  not:\n    any:\n      - inside: {{ kind: expression_statement }}\n{excluded}"
+        );
+        let literals = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+
+        // Each call's callee is its text up to the argument list
+        let mut calls: Vec<(usize, usize, String)> = Vec::new();
+        if functions.is_some() {
+            let call_kinds: String = self
+                .get_call_kinds(language)?
+                .iter()
+                .map(|(kind, arguments)| {
+                    format!("    - kind: {kind}\n      has: {{ {arguments}, pattern: $ARGS }}\n")
+                })
+                .collect();
+            let call_rule =
+                format!("id: string-calls\nlanguage: {language}\nrule:\n  any:\n{call_kinds}");
+            for m in self
+                .scan_source_json(&call_rule, &source, path.as_deref(), language)
+                .await?
+            {
+                let range = &m["metaVariables"]["single"]["ARGS"]["range"]["byteOffset"];
+                if let (Some(call), Some(start), Some(end)) = (
+                    NodeSpan::from_match(&m),
+                    range["start"].as_u64(),
+                    range["end"].as_u64(),
+                ) {
+                    let (start, end) = (start as usize, end as usize);
+                    calls.push((start, end, source[call.start..start].trim().to_string()));
+                }
+            }
+        }
+
+        let mut strings = Vec::new();
+        for m in &literals {
+            let Some(span) = NodeSpan::from_match(m) else {
+                continue;
+            };
+            let call = calls
+                .iter()
+                .filter(|(start, end, _)| *start <= span.start && span.end <= *end)
+                .min_by_key(|(start, end, _)| end - start)
+                .map(|(_, _, callee)| callee.as_str());
+            if let Some(functions) = &functions {
+                if !call.is_some_and(|callee| functions.iter().any(|f| f.is_match(callee))) {
+                    continue;
+                }
+            }
+            strings.push(serde_json::json!({
+                "text": span.text,
+                "kind": m["kind"],
+                "line": edit_utils::line_number(&source, span.start),
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+                "call": call,
+                "interpolated": edit_utils::is_interpolated_string(&span.text, language),
+            }));
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.map(|path| path.display().to_string()),
+            "language": language,
+            "strings": strings,
+        }))?)
+    }
+
+    /// Replace the string literal at the call's position with a lookup of
+    /// its translation, e.g. `i18n.T("greeting")`.
+    async fn replace_string_literal(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let key = args["key"].as_str().ok_or(anyhow!("Missing key"))?;
+        let template = args["template"].as_str().unwrap_or("i18n.T(\"{key}\")");
+        if !template.contains("{key}") && !template.contains("{text}") {
+            return Err(anyhow!(
+                "template must contain {{key}} or {{text}}, e.g. i18n.T(\"{{key}}\")"
+            ));
+        }
+
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        let kinds = self
+            .get_string_literal_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rule_config =
+            format!("id: string-literal\nlanguage: {language}\nrule:\n  any: [{kinds}]\n");
+        let literal = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| span.start <= start && end <= span.end)
+            .min_by_key(|span| span.end - span.start)
+            .ok_or_else(|| anyhow!("No string literal covers the position"))?;
+
+        let replacement = template
+            .replace("{key}", key)
+            .replace("{text}", &literal.text);
+        let edits = vec![TextEdit {
+            start: literal.start,
+            end: literal.end,
+            replacement: replacement.clone(),
+        }];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "line": edit_utils::line_number(&source, literal.start),
+            "original": literal.text,
+            "replacement": replacement,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds of the string literals that may use either quote, and
+    /// the kinds whose strings must keep theirs.
+    fn get_quoted_string_kinds(
+        &self,
+        language: &str,
+    ) -> Result<(&'static [&'static str], &'static [&'static str])> {
+        match language {
+            // Template strings are always backticks; JSX attribute values
+            // have no escapes, so they cannot always trade quotes
+            "javascript" | "typescript" => Ok((&["string"], &["jsx_attribute"])),
+            "python" => Ok((&["string"], &[])),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Rewrite a file's string literals to use `quote` ("single" or
+    /// "double"), leaving alone those that contain it.
+    async fn normalize_quotes(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let quote = match args["quote"].as_str().unwrap_or("double") {
+            "double" => '"',
+            "single" => '\'',
+            other => {
+                return Err(anyhow!(
+                    "quote must be \"single\" or \"double\", not \"{}\"",
+                    other
+                ))
+            }
+        };
+
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (kinds, fixed) = self.get_quoted_string_kinds(language)?;
+        let kinds = kinds
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let excluded: String = fixed
+            .iter()
+            .map(|kind| format!("  not: {{ inside: {{ kind: {kind}, stopBy: end }} }}\n"))
+            .collect();
+        let rule_config = format!(
+            "id: normalize-quotes\nlanguage: {language}\nrule:\n  any: [{kinds}]\n{excluded}"
+        );
+        let literals = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?;
+
+        let mut edits = Vec::new();
+        let mut changes = Vec::new();
+        let mut skipped = Vec::new();
+        for span in literals.iter().filter_map(NodeSpan::from_match) {
+            let line = edit_utils::line_number(&source, span.start);
+            match edit_utils::requote_string(&span.text, quote) {
+                Ok(Some(replacement)) => {
+                    changes.push(serde_json::json!({
+                        "line": line,
+                        "original": span.text,
+                        "replacement": replacement,
+                    }));
+                    edits.push(TextEdit {
+                        start: span.start,
+                        end: span.end,
+                        replacement,
+                    });
+                }
+                Ok(None) => {}
+                Err(reason) => skipped.push(serde_json::json!({
+                    "line": line,
+                    "text": span.text,
+                    "reason": reason,
+                })),
+            }
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "quote": if quote == '"' { "double" } else { "single" },
+            "changes": changes,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds of the blocks whose statements can be wrapped, and of the
+    /// statements that declare names for the ones after them.
+    fn get_statement_block_kinds(
+        &self,
+        language: &str,
+    ) -> Result<(&'static [&'static str], &'static [&'static str])> {
+        match language {
+            // Newer Go grammars hold a block's statements in a statement_list
+            "go" => Ok((
+                &["block", "statement_list"],
+                &[
+                    "var_declaration",
+                    "const_declaration",
+                    "short_var_declaration",
+                    "type_declaration",
+                ],
+            )),
+            "rust" => Ok((
+                &["block"],
+                &[
+                    "let_declaration",
+                    "const_item",
+                    "static_item",
+                    "use_declaration",
+                ],
+            )),
+            // Names assigned in a Python block outlive it
+            "python" => Ok((&["block"], &[])),
+            "javascript" | "typescript" => Ok((
+                &["statement_block"],
+                &[
+                    "lexical_declaration",
+                    "variable_declaration",
+                    "function_declaration",
+                    "class_declaration",
+                ],
+            )),
+            "java" => Ok((&["block"], &["local_variable_declaration"])),
+            "c" | "cpp" | "c++" => Ok((&["compound_statement"], &["declaration"])),
+            "csharp" | "cs" => Ok((
+                &["block"],
+                &["local_declaration_statement", "local_function_statement"],
+            )),
+            "swift" => Ok((&["statements"], &["property_declaration"])),
+            _ => Err(anyhow!("Unsupported language: {}", language)),
+        }
+    }
+
+    /// Each statement block in the source with its own statements in
+    /// order, and not those of the blocks inside it. A Go statement_list
+    /// stands in for its block.
+    async fn statements_by_block(
+        &self,
+        language: &str,
+        source: &str,
+        path: Option<&Path>,
+    ) -> Result<Vec<(NodeSpan, Vec<(String, NodeSpan)>)>> {
+        let (block_kinds, _) = self.get_statement_block_kinds(language)?;
+        let kinds = block_kinds
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let blocks: Vec<NodeSpan> = self
+            .scan_source_json(
+                &format!("id: statement-blocks\nlanguage: {language}\nrule:\n  any: [{kinds}]\n"),
+                source,
+                path,
+                language,
+            )
+            .await?
+            .iter()
+            .filter(|m| m["kind"] != "statement_list")
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let children = self
+            .scan_source_json(
+                &format!(
+                    "id: block-statements\nlanguage: {language}\nrule:\n  inside:\n    any: [{kinds}]\n"
+                ),
+                source,
+                path,
+                language,
+            )
+            .await?;
+
+        let mut statements: Vec<Vec<(String, NodeSpan)>> = vec![Vec::new(); blocks.len()];
+        for m in &children {
+            let (Some(kind), Some(span)) = (m["kind"].as_str(), NodeSpan::from_match(m)) else {
+                continue;
+            };
+            if !kind.chars().all(|c| c.is_ascii_alphanumeric() || c == '_')
+                || kind.contains("comment")
+                || kind == "statement_list"
+            {
+                continue;
+            }
+            let parent = blocks
+                .iter()
+                .enumerate()
+                .filter(|(_, parent)| {
+                    parent.start <= span.start
+                        && span.end <= parent.end
+                        && (parent.start, parent.end) != (span.start, span.end)
+                })
+                .min_by_key(|(_, parent)| parent.end - parent.start);
+            if let Some((index, _)) = parent {
+                statements[index].push((kind.to_string(), span));
+            }
+        }
+        for block_statements in &mut statements {
+            block_statements.sort_by_key(|(_, span)| span.start);
+            block_statements.dedup_by_key(|(_, span)| (span.start, span.end));
+        }
+        Ok(blocks.into_iter().zip(statements).collect())
+    }
+
+    /// Put the `before` and `after` templates around each statement directly
+    /// in the innermost block covering the call's position. `$LINE` and
+    /// `$INDEX` in a template become the statement's line and its index in
+    /// the block.
+    async fn wrap_statements(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let before = args["before"].as_str().unwrap_or("");
+        let after = args["after"].as_str().unwrap_or("");
+        if before.is_empty() && after.is_empty() {
+            return Err(anyhow!("Provide before, after or both"));
+        }
+        let nest = args["nest"].as_bool().unwrap_or(false);
+        let skip_declarations = args["skipDeclarations"].as_bool().unwrap_or(false);
+
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+        let (_, declaration_kinds) = self.get_statement_block_kinds(language)?;
+        let blocks = self
+            .statements_by_block(language, &source, path.as_deref())
+            .await?;
+        let (block, statements) = blocks
+            .iter()
+            .filter(|(block, _)| block.start <= start && end <= block.end)
+            .min_by_key(|(block, _)| block.end - block.start)
+            .ok_or_else(|| anyhow!("No statement block covers the position"))?;
+
+        let step = edit_utils::indent_unit(&source);
+        let mut edits = Vec::new();
+        let mut wrapped = Vec::new();
+        let mut skipped = Vec::new();
+        let count = statements.len();
+        for (index, (kind, span)) in statements.iter().enumerate() {
+            let line = edit_utils::line_number(&source, span.start);
+            let line_start = edit_utils::line_start(&source, span.start);
+            let line_end = edit_utils::line_end(&source, span.end);
+            let rest = source[span.end..line_end].trim();
+            let reason = if !source[line_start..span.start].trim().is_empty()
+                || !(rest.is_empty() || rest.starts_with("//") || rest.starts_with('#'))
+            {
+                Some("the statement shares its line with another")
+            } else if skip_declarations && declaration_kinds.contains(&kind.as_str()) {
+                Some("the statement is a declaration")
+            } else if nest && declaration_kinds.contains(&kind.as_str()) {
+                Some("nesting it would put the names it declares out of scope for the statements after it")
+            } else if language == "rust"
+                && index + 1 == count
+                && !span.text.ends_with(';')
+                && !["_statement", "_declaration", "_item"]
+                    .iter()
+                    .any(|suffix| kind.ends_with(suffix))
+            {
+                Some("the statement is the block's value")
+            } else if nest
+                && span.text.contains('\n')
+                && ["`", "\"\"\"", "'''", "r\"", "r#"]
+                    .iter()
+                    .any(|quote| span.text.contains(quote))
+            {
+                Some("nesting it could change a multi-line string")
+            } else {
+                None
+            };
+            if let Some(reason) = reason {
+                skipped.push(serde_json::json!({
+                    "line": line,
+                    "text": span.text,
+                    "reason": reason,
+                }));
+                continue;
+            }
+
+            // Nothing after a statement that leaves the block would run
+            let jumps = ["return", "break", "continue", "throw", "raise", "goto"].contains(
+                &span
+                    .text
+                    .split(|c: char| !c.is_alphanumeric())
+                    .next()
+                    .unwrap_or(""),
+            );
+            let fill = |template: &str| {
+                template
+                    .replace("$LINE", &line.to_string())
+                    .replace("$INDEX", &index.to_string())
+            };
+            let after_text = if jumps && !nest {
+                String::new()
+            } else {
+                fill(after)
+            };
+            edits.push(TextEdit {
+                start: line_start,
+                end: line_end,
+                replacement: edit_utils::wrap_lines(
+                    &source[line_start..line_end],
+                    edit_utils::indentation_at(&source, span.start),
+                    &fill(before),
+                    &after_text,
+                    nest.then_some(step.as_str()),
+                ),
+            });
+            wrapped.push(serde_json::json!({
+                "line": line,
+                "index": index,
+                "kind": kind,
+                "after": !after_text.is_empty(),
+            }));
+        }
+        if edits.is_empty() {
+            return Err(match skipped.is_empty() {
+                true => anyhow!("The block has no statements to wrap"),
+                false => anyhow!(
+                    "No statements of the block can be wrapped ({})",
+                    skipped
+                        .iter()
+                        .map(|entry| format!(
+                            "line {}: {}",
+                            entry["line"],
+                            entry["reason"].as_str().unwrap_or_default()
+                        ))
+                        .collect::<Vec<_>>()
+                        .join("; ")
+                ),
+            });
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "block": {
+                "line": edit_utils::line_number(&source, block.start),
+                "end_line": edit_utils::line_number(&source, block.end),
+            },
+            "wrapped": wrapped,
+            "skipped": skipped,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// What ends a block unconditionally, if the statement `text` does:
+    /// `return`, `break`, `continue`, `goto`, `throw` or `raise`, or a
+    /// call to a function that never returns, such as Go's `panic`.
+    fn block_exit(language: &str, text: &str) -> Option<&'static str> {
+        let word_end = text
+            .find(|c: char| !(c.is_alphanumeric() || c == '_'))
+            .unwrap_or(text.len());
+        let (word, rest) = (&text[..word_end], text[word_end..].trim_start());
+        let (calls, call): (&[&'static str], bool) = match language {
+            "go" => (&["panic"], rest.starts_with('(')),
+            "rust" => (
+                &["panic", "unreachable", "todo", "unimplemented"],
+                rest.starts_with('!'),
+            ),
+            _ => (&[], false),
+        };
+        ["return", "break", "continue", "goto", "throw", "raise"]
+            .into_iter()
+            .find(|exit| *exit == word)
+            .or_else(|| calls.iter().copied().find(|name| call && *name == word))
+    }
+
+    /// Find the statements that can never run because they follow a
+    /// `return`, `break`, `continue`, `goto`, `throw`, `raise` or panic in
+    /// the same block, and with `delete` remove them. A labeled statement
+    /// that a `goto` jumps to can be reached again, and so can everything
+    /// after it. Only a statement that leaves the block itself counts: an
+    /// `if` whose branches all return does not make what follows it dead.
+    async fn find_unreachable_code(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let delete = args["delete"].as_bool().unwrap_or(false);
+        self.validate_language(language)?;
+        let (source, path) = self.load_source(&args).await?;
+        let blocks = self
+            .statements_by_block(language, &source, path.as_deref())
+            .await?;
+        let targets: Vec<String> = regex::Regex::new(r"\bgoto\s+([A-Za-z_][A-Za-z0-9_]*)")?
+            .captures_iter(&source)
+            .map(|capture| capture[1].to_string())
+            .collect();
+
+        // Each unreachable run of statements, with the exit it follows
+        let mut runs: Vec<(&'static str, &NodeSpan, Vec<&NodeSpan>)> = Vec::new();
+        for (_, statements) in &blocks {
+            let mut exit: Option<(&'static str, &NodeSpan)> = None;
+            let mut run: Vec<&NodeSpan> = Vec::new();
+            for (kind, span) in statements {
+                if let Some((word, exit_span)) = exit {
+                    let label = span.text.split(':').next().unwrap_or("").trim();
+                    if kind != "labeled_statement" || !targets.iter().any(|target| target == label)
+                    {
+                        run.push(span);
+                        continue;
+                    }
+                    // Reached by a goto from elsewhere
+                    if !run.is_empty() {
+                        runs.push((word, exit_span, std::mem::take(&mut run)));
+                    }
+                }
+                exit = Self::block_exit(language, &span.text).map(|word| (word, span));
+            }
+            if let Some((word, exit_span)) = exit.filter(|_| !run.is_empty()) {
+                runs.push((word, exit_span, run));
+            }
+        }
+        runs.sort_by_key(|(_, exit_span, _)| exit_span.start);
+
+        let mut unreachable = Vec::new();
+        let mut edits = Vec::new();
+        for (word, exit_span, run) in &runs {
+            let (first, last) = (run[0], run[run.len() - 1]);
+            let span = NodeSpan {
+                start: first.start,
+                end: last.end,
+                text: source[first.start..last.end].to_string(),
+            };
+            unreachable.push(serde_json::json!({
+                "line": edit_utils::line_number(&source, span.start),
+                "end_line": edit_utils::line_number(&source, span.end),
+                "statements": run.len(),
+                "text": span.text,
+                "after": {
+                    "line": edit_utils::line_number(&source, exit_span.start),
+                    "exit": word,
+                    "text": exit_span.text.lines().next().unwrap_or(""),
+                },
+            }));
+            if delete {
+                let (start, end) = edit_utils::statement_removal_range(&source, &span);
+                edits.push(TextEdit {
+                    start,
+                    end,
+                    replacement: String::new(),
+                });
+            }
+        }
+        let mut warnings = Vec::new();
+        if delete && !edits.is_empty() {
+            warnings.push(
+                "Variables, imports and functions used only by the deleted statements may now be unused"
+                    .to_string(),
+            );
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let mut result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "count": unreachable.len(),
+            "unreachable": unreachable,
+            "warnings": warnings,
+        });
+        if !delete {
+            result["applied"] = false.into();
+            return Ok(serde_json::to_string_pretty(&result)?);
+        }
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// The embedded code at the call's position: the contents of the
+    /// innermost string literal covering it, or of the `<script>` or
+    /// `<style>` element covering it when the host language is HTML.
+    async fn find_embedded_region(
+        &self,
+        args: &Value,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+    ) -> Result<EmbeddedRegion> {
+        let (start, end) = self.get_target_range(args, source)?;
+        let covers = |region: &EmbeddedRegion| region.start <= start && end <= region.end;
+
+        if language == "html" {
+            return embedded::html_element_regions(source, "script")
+                .into_iter()
+                .chain(embedded::html_element_regions(source, "style"))
+                .find(covers)
+                .ok_or_else(|| anyhow!("No <script> or <style> element covers the position"));
+        }
+
+        self.validate_language(language)?;
+        let kinds = self
+            .get_string_literal_kinds(language)?
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let rule_config =
+            format!("id: embedded-string\nlanguage: {language}\nrule:\n  any: [{kinds}]\n");
+        let literal = self
+            .scan_source_json(&rule_config, source, path, language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| span.start <= start && end <= span.end)
+            .min_by_key(|span| span.end - span.start)
+            .ok_or_else(|| anyhow!("No string literal covers the position"))?;
+
+        let (content_start, content_end) =
+            embedded::string_literal_content(&literal.text, language)?;
+        Ok(EmbeddedRegion {
+            start: literal.start + content_start,
+            end: literal.start + content_end,
+            terminator: literal.text[content_end..].to_string(),
+            dedent: 0,
+        })
+    }
+
+    /// The Markdown code block chosen by the call's `block` index, or else
+    /// the one whose contents cover its position.
+    fn find_code_block(&self, args: &Value, source: &str) -> Result<CodeBlock> {
+        let blocks = embedded::markdown_code_blocks(source);
+        if let Some(index) = args["block"].as_u64() {
+            let count = blocks.len();
+            return blocks.into_iter().nth(index as usize).ok_or_else(|| {
+                anyhow!(
+                    "Block {} is out of range: the document has {} code blocks",
+                    index,
+                    count
+                )
+            });
+        }
+        let (start, end) = self.get_target_range(args, source)?;
+        blocks
+            .into_iter()
+            .find(|block| block.region.start <= start && end <= block.region.end)
+            .ok_or_else(|| anyhow!("No code block covers the position"))
+    }
+
+    /// Language a code fence's info string names, e.g. `go` in
+    /// `go title="main.go"`, by language name, alias or file extension.
+    fn get_info_string_language(&self, info: &str) -> Option<(&'static str, bool)> {
+        let word = info
+            .split_whitespace()
+            .next()?
+            .trim_start_matches(['{', '.'])
+            .trim_end_matches('}')
+            .to_ascii_lowercase();
+        let word = match word.as_str() {
+            "golang" => "go",
+            "c#" => "csharp",
+            "shell" | "console" => "bash",
+            word => word,
+        };
+        LANGUAGE_EXTENSIONS
+            .iter()
+            .find(|(language, _, _)| *language == word)
+            .map(|(language, supported, _)| (*language, *supported))
+            .or_else(|| self.get_extension_language(word))
+    }
+
+    /// List the fenced and indented code blocks of a Markdown document,
+    /// with the language each fence names.
+    async fn markdown_code_blocks(&self, args: Value) -> Result<String> {
+        let validate = args["validate"].as_bool().unwrap_or(false);
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let mut blocks = Vec::new();
+        for (index, block) in embedded::markdown_code_blocks(&source).iter().enumerate() {
+            let language = self.get_info_string_language(&block.info);
+            let code = embedded::region_code(&source, &block.region);
+            let mut entry = serde_json::json!({
+                "index": index,
+                "info": block.info,
+                "language": language.map(|(language, _)| language),
+                "supported": language.is_some_and(|(_, supported)| supported),
+                "style": if block.indented { "indented" } else { "fenced" },
+                "range": text_encoding::encode_range(
+                    &source,
+                    &embedded::region_range(&source, &block.region),
+                    offset_encoding
+                ),
+                "code": code,
+            });
+            if let Some((language, true)) = language.filter(|_| validate) {
+                entry["syntax_errors"] = self.count_syntax_errors(&code, language).await?.into();
+            }
+            blocks.push(entry);
+        }
+
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.map(|path| path.display().to_string()),
+            "blocks": blocks,
+        }))?)
+    }
+
+    /// Parse the contents of a string literal (or an HTML `<script>`
+    /// element, or a Markdown code block) as another language and run a rule over it, reporting
+    /// matches at their offsets in the parent file.
+    async fn parse_embedded(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let (source, path) = self.load_source(&args).await?;
+        // A Markdown fence can name its own language
+        let (region, block_language) = if language == "markdown" {
+            let block = self.find_code_block(&args, &source)?;
+            let block_language = self
+                .get_info_string_language(&block.info)
+                .map(|(language, _)| language);
+            (block.region, block_language)
+        } else {
+            let region = self
+                .find_embedded_region(&args, &source, path.as_deref(), language)
+                .await?;
+            (region, None)
+        };
+        let embedded_language = args["embedded_language"]
+            .as_str()
+            .or(block_language)
+            .ok_or(anyhow!("Missing embedded_language"))?;
+        self.validate_language(embedded_language)?;
+        let code = &embedded::region_code(&source, &region);
+        let target = path.as_ref().map(|path| path.display().to_string());
+
+        let mut result = serde_json::json!({
+            "target": target,
+            "language": language,
+            "embedded_language": embedded_language,
+            "region": text_encoding::encode_range(
+                &source,
+                &embedded::region_range(&source, &region),
+                offset_encoding
+            ),
+            "code": code,
+        });
+
+        let Some(rule_config) = args["rule_config"].as_str() else {
+            return Ok(serde_json::to_string_pretty(&result)?);
+        };
+        let rule_language = self.get_rule_language(rule_config)?;
+        if rule_language != embedded_language.to_lowercase() {
+            return Err(anyhow!(
+                "Rule language '{}' does not match embedded_language '{}'",
+                rule_language,
+                embedded_language
+            ));
+        }
+
+        let matches: Vec<Value> = self
+            .scan_code_json(rule_config, code, embedded_language)
+            .await?
+            .iter()
+            .map(|m| embedded::map_match_to_region(m, &source, &region, target.as_deref()))
+            .collect();
+        let edits: Vec<TextEdit> = matches
+            .iter()
+            .filter_map(|m| Self::match_to_edit(m, &source, true))
+            .map(|edit| TextEdit {
+                replacement: embedded::indent_replacement(&region, &edit.replacement),
+                ..edit
+            })
+            .collect();
+        if let Some(edit) = edits.iter().find(|edit| {
+            !region.terminator.is_empty() && edit.replacement.contains(&region.terminator)
+        }) {
+            return Err(anyhow!(
+                "The replacement at line {} contains '{}', which would end the embedded code early",
+                edit_utils::line_number(&source, edit.start),
+                region.terminator
+            ));
+        }
+
+        result["matches"] = matches
+            .iter()
+            .map(|m| {
+                let mut m = m.clone();
+                m["range"] = text_encoding::encode_range(&source, &m["range"], offset_encoding);
+                m
+            })
+            .collect();
+        if edits.is_empty() {
+            return Ok(serde_json::to_string_pretty(&result)?);
+        }
+
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// `source` with each of `imports` added where it is missing: Go import
+    /// specs grouped as goimports would, and whole import statements in
+    /// the languages `insert_import` supports, in sorted order. Returns the
+    /// imports added and those already there.
+    async fn ensure_imports(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+        language: &str,
+        imports: &[String],
+    ) -> Result<(String, Vec<String>, Vec<String>)> {
+        let mut source = source.to_string();
+        let (mut added, mut present) = (Vec::new(), Vec::new());
+        for import in imports {
+            let edited = match language {
+                "go" => {
+                    let found = self
+                        .scan_source_json(
+                            "id: go-imports\nlanguage: go\nrule:\n  any:\n    - kind: package_clause\n    - kind: import_declaration\n    - kind: import_spec\n",
+                            &source,
+                            path,
+                            language,
+                        )
+                        .await?;
+                    let spans = |kind: &str| -> Vec<NodeSpan> {
+                        found
+                            .iter()
+                            .filter(|m| m["kind"] == kind)
+                            .filter_map(NodeSpan::from_match)
+                            .collect()
+                    };
+                    let package_end = spans("package_clause")
+                        .first()
+                        .map(|clause| clause.end)
+                        .ok_or_else(|| anyhow!("Could not locate the package clause"))?;
+                    let specs = spans("import_spec");
+                    let declarations: Vec<(NodeSpan, Vec<NodeSpan>)> = spans("import_declaration")
+                        .into_iter()
+                        .map(|declaration| {
+                            let inside = specs
+                                .iter()
+                                .filter(|spec| {
+                                    declaration.start <= spec.start && spec.end <= declaration.end
+                                })
+                                .cloned()
+                                .collect();
+                            (declaration, inside)
+                        })
+                        .collect();
+                    edit_utils::go_import_edit(&source, &declarations, package_end, import)
+                        .map(|edit| edit_utils::apply_edits(&source, &[edit]))
+                        .transpose()?
+                }
+                _ => {
+                    let import_kind = self.get_import_kind(language)?;
+                    let existing: Vec<NodeSpan> = self
+                        .scan_source_json(
+                            &format!(
+                                "id: imports\nlanguage: {language}\nrule:\n  kind: {import_kind}\n"
+                            ),
+                            &source,
+                            path,
+                            language,
+                        )
+                        .await?
+                        .iter()
+                        .filter_map(NodeSpan::from_match)
+                        .filter(|span| {
+                            matches!(language, "csharp" | "cs")
+                                || edit_utils::indentation_at(&source, span.start).is_empty()
+                        })
+                        .collect();
+                    edit_utils::insert_sorted_line(&source, &existing, import)
+                        .map(|(edited, _)| edited)
+                }
+            };
+            match edited {
+                Some(edited) => {
+                    source = edited;
+                    added.push(import.clone());
+                }
+                None => present.push(import.clone()),
+            }
+        }
+        Ok((source, added, present))
+    }
+
+    /// Report the problems in a Go file's imports that otherwise only show
+    /// at compile time: a path imported twice under the same name, one
+    /// path under several names, and blank imports. Nothing is changed
+    /// unless `fix` asks for `dedupe`, which deletes the repeated imports,
+    /// or `consolidate`, which merges the import declarations into one
+    /// group.
+    async fn check_go_imports(&self, args: Value) -> Result<String> {
+        let fixes: Vec<&str> = args["fix"]
+            .as_array()
+            .into_iter()
+            .flatten()
+            .filter_map(Value::as_str)
+            .collect();
+        if let Some(unknown) = fixes
+            .iter()
+            .find(|fix| !matches!(**fix, "dedupe" | "consolidate"))
+        {
+            return Err(anyhow!(
+                "Unknown fix '{}'; use dedupe or consolidate",
+                unknown
+            ));
+        }
+        let language = "go";
+        let (source, path) = self.load_source(&args).await?;
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+
+        let found = self
+            .scan_source_json(
+                "id: go-imports\nlanguage: go\nrule:\n  any:\n    - kind: import_declaration\n    - kind: import_spec\n",
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        let declarations: Vec<NodeSpan> = found
+            .iter()
+            .filter(|m| m["kind"] == "import_declaration")
+            .filter_map(NodeSpan::from_match)
+            .collect();
+        let specs: Vec<(&Value, NodeSpan)> = found
+            .iter()
+            .filter(|m| m["kind"] == "import_spec")
+            .filter_map(|m| Some((m, NodeSpan::from_match(m)?)))
+            .collect();
+        let line_of = |span: &NodeSpan| edit_utils::line_number(&source, span.start);
+
+        let mut issues = Vec::new();
+        let mut redundant = Vec::new();
+        for (i, (m, spec)) in specs.iter().enumerate() {
+            let import_path = edit_utils::go_import_path(&spec.text);
+            let name = edit_utils::go_import_name(&spec.text);
+            let same_path: Vec<&NodeSpan> = specs
+                .iter()
+                .map(|(_, other)| other)
+                .filter(|other| edit_utils::go_import_path(&other.text) == import_path)
+                .collect();
+            let named = same_path
+                .iter()
+                .find(|other| edit_utils::go_import_name(&other.text) != "_");
+            let earlier = same_path[..same_path
+                .iter()
+                .position(|other| other.start == spec.start)
+                .unwrap_or(0)]
+                .iter()
+                .find(|other| edit_utils::go_import_name(&other.text) == name);
+            let (kind, message, related) = match (name, earlier, named) {
+                (_, Some(earlier), _) => (
+                    "duplicate",
+                    format!(
+                        "\"{}\" is already imported as {} on line {}",
+                        import_path,
+                        name,
+                        line_of(earlier)
+                    ),
+                    Some(*earlier),
+                ),
+                ("_", None, Some(named)) => (
+                    "duplicate",
+                    format!(
+                        "The blank import of \"{}\" does nothing, since line {} imports it by name",
+                        import_path,
+                        line_of(named)
+                    ),
+                    Some(*named),
+                ),
+                ("_", None, None) => (
+                    "blank_import",
+                    format!("\"{}\" is imported only for its side effects", import_path),
+                    None,
+                ),
+                (_, None, Some(named)) if named.start != spec.start => (
+                    "alias_conflict",
+                    format!(
+                        "\"{}\" is imported as {} here and as {} on line {}",
+                        import_path,
+                        name,
+                        edit_utils::go_import_name(&named.text),
+                        line_of(named)
+                    ),
+                    Some(*named),
+                ),
+                _ => continue,
+            };
+            if kind == "duplicate" {
+                redundant.push(i);
+            }
+            issues.push(serde_json::json!({
+                "kind": kind,
+                "path": import_path,
+                "spec": spec.text,
+                "line": line_of(spec),
+                "range": text_encoding::encode_range(&source, &m["range"], offset_encoding),
+                "related_line": related.map(line_of),
+                "message": message,
+                "fix": (kind == "duplicate").then_some("dedupe"),
+            }));
+        }
+
+        let mut edits = Vec::new();
+        let mut fixed = Vec::new();
+        let dedupe = fixes.contains(&"dedupe");
+        // A merged declaration goes with its lines and the blank line before
+        let delete_declaration = |declaration: &NodeSpan| {
+            let start = edit_utils::line_start(&source, declaration.start);
+            let end = edit_utils::line_end(&source, declaration.end);
+            match source[start..declaration.start].trim().is_empty()
+                && source[declaration.end..end].trim().is_empty()
+            {
+                true => TextEdit {
+                    start: start - usize::from(source[..start].ends_with("\n\n")),
+                    end,
+                    replacement: String::new(),
+                },
+                false => TextEdit {
+                    start: declaration.start,
+                    end: declaration.end,
+                    replacement: String::new(),
+                },
+            }
+        };
+        if fixes.contains(&"consolidate") && declarations.len() > 1 {
+            let kept: Vec<&str> = specs
+                .iter()
+                .enumerate()
+                .filter(|(i, _)| !(dedupe && redundant.contains(i)))
+                .map(|(_, (_, spec))| spec.text.trim())
+                .collect();
+            edits.push(TextEdit {
+                start: declarations[0].start,
+                end: declarations[0].end,
+                replacement: edit_utils::go_import_group(&kept),
+            });
+            edits.extend(declarations[1..].iter().map(delete_declaration));
+            fixed.push("consolidate");
+            if dedupe && !redundant.is_empty() {
+                fixed.push("dedupe");
+            }
+        } else if dedupe && !redundant.is_empty() {
+            for &i in &redundant {
+                let spec = &specs[i].1;
+                let declaration = declarations
+                    .iter()
+                    .find(|declaration| {
+                        declaration.start <= spec.start && spec.end <= declaration.end
+                    })
+                    .ok_or_else(|| anyhow!("Could not locate the declaration of {}", spec.text))?;
+                edits.push(edit_utils::go_import_deletion(&source, declaration, spec));
+            }
+            fixed.push("dedupe");
+        }
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let mut result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "issues": issues,
+            "fixed": fixed,
+        });
+        if fixes.is_empty() {
+            result["applied"] = false.into();
+            return Ok(serde_json::to_string_pretty(&result)?);
+        }
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Replace the innermost function (or node of `kind`) covering a
+    /// position with `replacement`, and add any of `imports` the file is
+    /// missing in the same edit. Lines after the replacement's first are
+    /// indented to the node's line. With `keepOriginalAsComment`, the
+    /// node's original text is kept as a `before:` comment below it. The
+    /// result must parse no worse than the file did.
+    async fn replace_node(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let replacement = args["replacement"]
+            .as_str()
+            .ok_or(anyhow!("Missing replacement"))?;
+        self.validate_language(language)?;
+        let mut imports = Vec::new();
+        for import in args["imports"].as_array().into_iter().flatten() {
+            let import = import
+                .as_str()
+                .map(str::trim)
+                .filter(|import| !import.is_empty())
+                .ok_or(anyhow!("Each of imports must be a non-empty string"))?;
+            imports.push(match language {
+                "go" => edit_utils::go_import_spec(import),
+                _ => import.to_string(),
+            });
+        }
+        if !imports.is_empty() && language != "go" {
+            self.get_import_kind(language)?;
+        }
+        let (source, path) = self.load_source(&args).await?;
+        let (start, end) = self.get_target_range(&args, &source)?;
+
+        let kinds: Vec<&str> = match args["kind"].as_str() {
+            Some(kind) => vec![kind],
+            None => self.get_function_kinds(language)?.to_vec(),
+        };
+        let rule_config = format!(
+            "id: replace-node\nlanguage: {language}\nrule:\n  any: [{}]\n",
+            kinds
+                .iter()
+                .map(|kind| format!("{{ kind: {kind} }}"))
+                .collect::<Vec<_>>()
+                .join(", ")
+        );
+        let (kind, node) = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| Some((m["kind"].as_str()?.to_string(), NodeSpan::from_match(m)?)))
+            .filter(|(_, span)| span.start <= start && end <= span.end)
+            .min_by_key(|(_, span)| span.end - span.start)
+            .ok_or_else(|| anyhow!("No {} covers the position", kinds.join(" or ")))?;
+
+        // Imports first, on the file as it is, as one edit over the lines
+        // they touch
+        let (with_imports, added, present) = self
+            .ensure_imports(&source, path.as_deref(), language, &imports)
+            .await?;
+        let mut edits: Vec<TextEdit> = edit_guard::edit_between(&source, &with_imports)
+            .into_iter()
+            .collect();
+        if edits
+            .iter()
+            .any(|edit| edit.start < node.end && node.start < edit.end)
+        {
+            return Err(anyhow!(
+                "The {} on line {} overlaps the lines the imports go on; replace it without imports",
+                kind,
+                edit_utils::line_number(&source, node.start)
+            ));
+        }
+        let indent = edit_utils::indentation_at(&source, node.start);
+        let reindented = edit_utils::reindent(replacement, indent);
+        let text = reindented
+            .strip_prefix(indent)
+            .unwrap_or(&reindented)
+            .to_string();
+        edits.push(TextEdit {
+            start: node.start,
+            end: node.end,
+            replacement: text,
+        });
+        if args["keepOriginalAsComment"].as_bool().unwrap_or(false) {
+            let prefix = self.get_line_comment_prefix(language)?;
+            edits.push(edit_utils::original_as_comment(
+                &source, node.start, node.end, prefix,
+            ));
+        }
+        edits.sort_by_key(|edit| edit.start);
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let before = self.count_syntax_errors(&source, language).await?;
+        if self.count_syntax_errors(&new_source, language).await? > before {
+            return Err(anyhow!(
+                "Replacing the {} on line {} does not parse as {}",
+                kind,
+                edit_utils::line_number(&source, node.start),
+                language
+            ));
+        }
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "kind": kind,
+            "line": edit_utils::line_number(&source, node.start),
+            "replaced": node.text,
+            "imports": {
+                "added": added,
+                "present": present,
+            },
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kind ast-grep uses for a single import statement in `language`.
+    fn get_import_kind(&self, language: &str) -> Result<&'static str> {
+        match language {
+            "csharp" | "cs" => Ok("using_directive"),
+            "java" => Ok("import_declaration"),
+            "rust" => Ok("use_declaration"),
+            "python" => Ok("import_statement"),
+            "javascript" | "typescript" => Ok("import_statement"),
+            "swift" => Ok("import_declaration"),
+            _ => Err(anyhow!(
+                "insert_import does not support '{}'. Supported languages: csharp, java, rust, python, javascript, typescript, swift",
+                language
+            )),
+        }
+    }
+
+    async fn insert_import(&self, args: Value) -> Result<String> {
+        let target = args["target"].as_str().ok_or(anyhow!("Missing target"))?;
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let import = args["import"].as_str().ok_or(anyhow!("Missing import"))?;
+
+        self.validate_language(language)?;
+        let import_kind = self.get_import_kind(language)?;
+        let resolved_target = self.resolve_path(target)?;
+        let source = self.read_source_file(&resolved_target, &args).await?;
+
+        let rule_config =
+            format!("id: insert-import\nlanguage: {language}\nrule:\n  kind: {import_kind}\n");
+        // Only top-level imports take part in ordering, except in C# where
+        // using directives commonly live inside the namespace block.
+        let nested_allowed = matches!(language, "csharp" | "cs");
+        let existing: Vec<NodeSpan> = self
+            .scan_source_json(&rule_config, &source, Some(&resolved_target), language)
+            .await?
+            .iter()
+            .filter_map(NodeSpan::from_match)
+            .filter(|span| {
+                nested_allowed || edit_utils::indentation_at(&source, span.start).is_empty()
+            })
+            .collect();
+
+        let (new_source, line) = match edit_utils::insert_sorted_line(&source, &existing, import) {
+            Some(result) => result,
+            None => {
+                return Ok(serde_json::to_string_pretty(&serde_json::json!({
+                    "target": resolved_target.display().to_string(),
+                    "import": import.trim(),
+                    "changed": false,
+                    "message": "Import already present"
+                }))?)
+            }
+        };
+
+        let edits: Vec<TextEdit> = edit_guard::edit_between(&source, &new_source)
+            .into_iter()
+            .collect();
+        let result = serde_json::json!({
+            "target": resolved_target.display().to_string(),
+            "import": import.trim(),
+            "changed": true,
+            "line": line,
+        });
+        let result = self
+            .finish_edit(
+                result,
+                Some(resolved_target.as_path()),
+                &source,
+                &new_source,
+                &edits,
+                &args,
+            )
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds that declare a type with a brace-delimited member body in
+    /// `language`.
+    fn get_type_declaration_kinds(&self, language: &str) -> Result<&'static [&'static str]> {
+        match language {
+            // class_declaration also covers structs, enums, actors, and extensions
+            "swift" => Ok(&["class_declaration", "protocol_declaration"]),
+            "java" => Ok(&[
+                "class_declaration",
+                "interface_declaration",
+                "enum_declaration",
+                "record_declaration",
+            ]),
+            "csharp" | "cs" => Ok(&[
+                "class_declaration",
+                "struct_declaration",
+                "interface_declaration",
+                "record_declaration",
+            ]),
+            "javascript" => Ok(&["class_declaration"]),
+            "typescript" => Ok(&["class_declaration", "interface_declaration"]),
+            "rust" => Ok(&["impl_item"]),
+            "cpp" | "c++" => Ok(&["class_specifier", "struct_specifier"]),
+            _ => Err(anyhow!(
+                "insert_member does not support '{}'. Supported languages: swift, java, csharp, javascript, typescript, rust, cpp",
+                language
+            )),
+        }
+    }
+
+    /// Insert a method or property as the last member of a named type,
+    /// indented to match the members already there.
+    async fn insert_member(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        let type_name = args["type_name"]
+            .as_str()
+            .ok_or(anyhow!("Missing type_name"))?;
+        let member = args["member"].as_str().ok_or(anyhow!("Missing member"))?;
+
+        self.validate_language(language)?;
+        let kinds = self.get_type_declaration_kinds(language)?;
+        let name_field = self.field_name(language, "TYPE_NAME")?;
+        let body_field = self.field_name(language, "BODY")?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let kinds = kinds
+            .iter()
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        // Generic parameters are part of the name field in some grammars
+        let rule_config = format!(
+            "id: insert-member\nlanguage: {language}\nrule:\n  all:\n    - any: [{kinds}]\n    - has:\n        field: {name_field}\n        regex: ^{}(<.*>)?$\n    - has: {{ field: {body_field}, pattern: $BODY }}\n",
+            regex::escape(type_name)
+        );
+        let mut declarations: Vec<(NodeSpan, NodeSpan)> = self
+            .scan_source_json(&rule_config, &source, path.as_deref(), language)
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let body = NodeSpan::from_match(&m["metaVariables"]["single"]["BODY"])?;
+                Some((NodeSpan::from_match(m)?, body))
+            })
+            .collect();
+        if let Some(line) = args["line"].as_u64() {
+            declarations
+                .retain(|(span, _)| edit_utils::line_number(&source, span.start) == line as usize);
+        }
+        let (_, body) = match declarations.as_slice() {
+            [only] => only,
+            [] => return Err(anyhow!("No type named '{}' found", type_name)),
+            _ => {
+                let lines: Vec<String> = declarations
+                    .iter()
+                    .map(|(span, _)| edit_utils::line_number(&source, span.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "'{}' is declared on lines {}; pass line to choose one",
+                    type_name,
+                    lines.join(", ")
+                ));
+            }
+        };
+
+        let edit = edit_utils::member_insertion(&source, body, member)
+            .ok_or_else(|| anyhow!("'{}' has no member body to insert into", type_name))?;
+        let member_start =
+            edit.start + edit.replacement.len() - edit.replacement.trim_start_matches('\n').len();
+        let edits = [edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let line = edit_utils::line_number(&new_source, member_start);
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "type_name": type_name,
+            "line": line,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Node kinds of the package clause and of the imports of `language`,
+    /// the preamble `insert_at` places code around. Languages without a
+    /// package clause have none of the first.
+    fn get_preamble_kinds(
+        &self,
+        language: &str,
+    ) -> Result<(&'static [&'static str], &'static [&'static str])> {
+        match language {
+            "go" => Ok((&["package_clause"], &["import_declaration"])),
+            "java" => Ok((&["package_declaration"], &["import_declaration"])),
+            "rust" => Ok((&[], &["use_declaration", "extern_crate_declaration"])),
+            "python" => Ok((
+                &[],
+                &[
+                    "import_statement",
+                    "import_from_statement",
+                    "future_import_statement",
+                ],
+            )),
+            "javascript" | "typescript" => Ok((&[], &["import_statement"])),
+            "csharp" | "cs" => Ok((&[], &["using_directive"])),
+            "swift" => Ok((&[], &["import_declaration"])),
+            "cpp" | "c++" | "c" => Ok((&[], &["preproc_include"])),
+            _ => Err(anyhow!(
+                "insert_at does not support '{}'. Supported languages: go, java, rust, python, javascript, typescript, csharp, swift, c, cpp",
+                language
+            )),
+        }
+    }
+
+    /// Insert top-level code at a named place in a file rather than next
+    /// to a node: `after_package` (after the package clause, or after the
+    /// file's header where there is none), `after_imports` (after the last
+    /// top-level import, or `after_package` without one),
+    /// `before_first_declaration` (before the first code after those and
+    /// the comment above it, or `end_of_file` when there is none) and
+    /// `end_of_file`. The code is set apart by blank lines, and the result
+    /// must parse no worse than the file did.
+    async fn insert_at(&self, args: Value) -> Result<String> {
+        let language = args["language"]
+            .as_str()
+            .ok_or(anyhow!("Missing language"))?;
+        // `after-imports` is accepted for `after_imports`
+        let anchor = args["anchor"]
+            .as_str()
+            .ok_or(anyhow!("Missing anchor"))?
+            .replace('-', "_");
+        let text = args["text"].as_str().ok_or(anyhow!("Missing text"))?;
+        self.validate_language(language)?;
+        if !matches!(
+            anchor.as_str(),
+            "after_package" | "after_imports" | "before_first_declaration" | "end_of_file"
+        ) {
+            return Err(anyhow!(
+                "Unknown anchor: {}. Use 'after_package', 'after_imports', 'before_first_declaration' or 'end_of_file'",
+                anchor
+            ));
+        }
+        if text.trim().is_empty() {
+            return Err(anyhow!("text is empty"));
+        }
+        let (package_kinds, import_kinds) = self.get_preamble_kinds(language)?;
+        let comment_kinds = self.get_comment_kinds(language)?;
+        let (source, path) = self.load_source(&args).await?;
+
+        let kinds = package_kinds
+            .iter()
+            .chain(import_kinds)
+            .chain(comment_kinds)
+            .map(|kind| format!("{{ kind: {kind} }}"))
+            .collect::<Vec<_>>()
+            .join(", ");
+        let found = self
+            .scan_source_json(
+                &format!("id: insert-at-preamble\nlanguage: {language}\nrule:\n  any: [{kinds}]\n"),
+                &source,
+                path.as_deref(),
+                language,
+            )
+            .await?;
+        // Only nodes starting their line take part, so nothing nested does
+        let top_level = |kinds: &[&str]| -> Vec<NodeSpan> {
+            found
+                .iter()
+                .filter(|m| kinds.iter().any(|kind| m["kind"] == *kind))
+                .filter_map(NodeSpan::from_match)
+                .filter(|span| edit_utils::line_start(&source, span.start) == span.start)
+                .collect()
+        };
+        // The start of the line after a node
+        let after = |span: &NodeSpan| edit_utils::line_end(&source, span.end.saturating_sub(1));
+        let docstring = match language {
+            "python" => self
+                .scan_source_json(
+                    "id: module-docstring\nlanguage: python\nrule:\n  kind: string\n  inside: { kind: expression_statement, inside: { kind: module } }\n",
+                    &source,
+                    path.as_deref(),
+                    language,
+                )
+                .await?
+                .iter()
+                .filter_map(NodeSpan::from_match)
+                .map(|span| (span.start, span.end))
+                .min(),
+            _ => None,
+        };
+        let after_package = top_level(package_kinds)
+            .iter()
+            .map(after)
+            .max()
+            .unwrap_or_else(|| {
+                let comments: Vec<(usize, usize)> = top_level(comment_kinds)
+                    .iter()
+                    .map(|span| (span.start, span.end))
+                    .collect();
+                edit_utils::file_header_end(&source, &comments, docstring)
+            });
+        let after_imports = top_level(import_kinds)
+            .iter()
+            .map(after)
+            .max()
+            .filter(|end| *end > after_package);
+
+        let (resolved, at) = match anchor.as_str() {
+            "after_package" => ("after_package", after_package),
+            "after_imports" => match after_imports {
+                Some(end) => ("after_imports", end),
+                None => ("after_package", after_package),
+            },
+            "before_first_declaration" => {
+                let from = after_imports.unwrap_or(after_package);
+                let rest = &source[from..];
+                match rest.trim().is_empty() {
+                    true => ("end_of_file", source.len()),
+                    false => (
+                        "before_first_declaration",
+                        edit_utils::line_start(
+                            &source,
+                            from + rest.len() - rest.trim_start().len(),
+                        ),
+                    ),
+                }
+            }
+            _ => ("end_of_file", source.len()),
+        };
+        let edit = edit_utils::block_insertion(&source, at, text);
+        let text_start =
+            edit.start + edit.replacement.len() - edit.replacement.trim_start_matches('\n').len();
+        let edits = [edit];
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let line = edit_utils::line_number(&new_source, text_start);
+        let before = self.count_syntax_errors(&source, language).await?;
+        if self.count_syntax_errors(&new_source, language).await? > before {
+            return Err(anyhow!(
+                "The text inserted on line {} does not parse as {}",
+                line,
+                language
+            ));
+        }
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "anchor": anchor,
+            "resolved": resolved,
+            "line": line,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
+
+    /// Elements of an HTML document selected by the `tag`, `id`, `class`
+    /// and `attribute` arguments, in document order, each with its range
+    /// and parsed start tag. The tag is matched by the rule; attributes
+    /// are compared on the start tag's text.
+    async fn select_html_elements(
+        &self,
+        args: &Value,
+        source: &str,
+        path: Option<&Path>,
+    ) -> Result<Vec<(NodeSpan, Value, markup::StartTag)>> {
+        let mut rule = "id: html-elements\nlanguage: html\nrule:\n  any:\n    - kind: element\n    - kind: script_element\n    - kind: style_element\n".to_string();
+        if let Some(tag) = args["tag"].as_str() {
+            if !regex::Regex::new(r"^[A-Za-z][A-Za-z0-9-]*$")?.is_match(tag) {
+                return Err(anyhow!("'{}' is not a valid tag name", tag));
+            }
+            rule.push_str(&format!(
+                "  has:\n    any:\n      - kind: start_tag\n      - kind: self_closing_tag\n    has:\n      kind: tag_name\n      regex: ^(?i){tag}$\n"
+            ));
+        }
+        let attribute = match &args["attribute"] {
+            Value::Null => None,
+            attribute => Some((
+                attribute["name"]
+                    .as_str()
+                    .ok_or(anyhow!("attribute needs a name"))?,
+                attribute["value"].as_str(),
+            )),
+        };
 
-        // Ensure we have a mapping (object), not a scalar or array
-        let rule_map = parsed.as_mapping()
-            .ok_or_else(|| anyhow!("Rule must be a YAML object, not a scalar value or array.\n\nExample of correct format:\n{}", self.get_example_rule()))?;
+        Ok(self
+            .scan_source_json(&rule, source, path, "html")
+            .await?
+            .iter()
+            .filter_map(|m| {
+                let span = NodeSpan::from_match(m)?;
+                let start_tag = markup::StartTag::parse(&span.text)?;
+                Some((span, m["range"].clone(), start_tag))
+            })
+            .filter(|(_, _, start_tag)| {
+                let id = start_tag
+                    .attribute("id")
+                    .and_then(|attribute| attribute.value.as_deref());
+                args["id"].as_str().is_none_or(|wanted| id == Some(wanted))
+                    && args["class"]
+                        .as_str()
+                        .is_none_or(|wanted| start_tag.classes().contains(&wanted))
+                    && attribute.is_none_or(|(name, value)| {
+                        start_tag.attribute(name).is_some_and(|attribute| {
+                            value.is_none_or(|value| attribute.value.as_deref() == Some(value))
+                        })
+                    })
+            })
+            .collect())
+    }
 
-        // Check for required fields
-        let mut missing_fields = Vec::new();
+    /// List the elements of an HTML document with a given tag, id, class or
+    /// attribute, with their attributes and where they are.
+    async fn find_html_elements(&self, args: Value) -> Result<String> {
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let (source, path) = self.load_source(&args).await?;
+        let elements = self
+            .select_html_elements(&args, &source, path.as_deref())
+            .await?;
 
-        if !rule_map.contains_key("id") {
-            missing_fields.push("id");
-        }
-        if !rule_map.contains_key("language") {
-            missing_fields.push("language");
-        }
-        if !rule_map.contains_key("rule") {
-            missing_fields.push("rule");
-        }
+        let elements: Vec<Value> = elements
+            .iter()
+            .map(|(span, range, start_tag)| {
+                let attributes: serde_json::Map<String, Value> = start_tag
+                    .attributes
+                    .iter()
+                    .map(|attribute| (attribute.name.clone(), attribute.value.clone().into()))
+                    .collect();
+                serde_json::json!({
+                    "tag": start_tag.name.to_ascii_lowercase(),
+                    "id": start_tag.attribute("id").and_then(|attribute| attribute.value.clone()),
+                    "classes": start_tag.classes(),
+                    "attributes": attributes,
+                    "line": edit_utils::line_number(&source, span.start),
+                    "start_tag": start_tag.text,
+                    "range": text_encoding::encode_range(&source, range, offset_encoding),
+                })
+            })
+            .collect();
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "count": elements.len(),
+            "elements": elements,
+        }))?)
+    }
 
-        if !missing_fields.is_empty() {
-            return Err(anyhow!(
-                "Missing required fields: {}\n\nRequired fields for ast-grep rules:\n- id: unique identifier for the rule\n- language: programming language (e.g., 'javascript', 'rust', 'python')\n- rule: the search/replace pattern definition\n\nExample of correct format:\n{}",
-                missing_fields.join(", "),
-                self.get_example_rule()
-            ));
+    /// Add names to or remove them from the `class` attribute of HTML
+    /// elements, chosen by tag, id, class or attribute, or by position.
+    /// The attribute is created when missing and removed when emptied.
+    async fn edit_html_class(&self, args: Value) -> Result<String> {
+        let names = |key: &str| -> Result<Vec<String>> {
+            let names: Vec<String> = match &args[key] {
+                Value::Null => Vec::new(),
+                Value::String(name) => vec![name.clone()],
+                value => serde_json::from_value(value.clone())
+                    .map_err(|_| anyhow!("{} must be a class name or a list of them", key))?,
+            };
+            match names
+                .iter()
+                .find(|name| name.is_empty() || name.contains(char::is_whitespace))
+            {
+                Some(name) => Err(anyhow!("'{}' is not a class name", name)),
+                None => Ok(names),
+            }
+        };
+        let add = names("add")?;
+        let remove = names("remove")?;
+        if add.is_empty() && remove.is_empty() {
+            return Err(anyhow!("Give add or remove"));
         }
+        let all = args["all"].as_bool().unwrap_or(false);
+        let (source, path) = self.load_source(&args).await?;
 
-        // Validate language field
-        if let Some(language) = rule_map.get("language").and_then(|v| v.as_str()) {
-            self.validate_language(language)?;
+        let selects = ["tag", "id", "class", "attribute"]
+            .iter()
+            .any(|key| !args[key].is_null());
+        let elements = if selects {
+            self.select_html_elements(&args, &source, path.as_deref())
+                .await?
         } else {
-            return Err(anyhow!("Language field must be a string.\n\nSupported languages: javascript, typescript, rust, python, java, go, cpp, c\n\nExample of correct format:\n{}", self.get_example_rule()));
+            let (start, end) = self.get_target_range(&args, &source)?;
+            self.select_html_elements(&Value::Null, &source, path.as_deref())
+                .await?
+                .into_iter()
+                .filter(|(span, _, _)| span.start <= start && end <= span.end)
+                .min_by_key(|(span, _, _)| span.end - span.start)
+                .into_iter()
+                .collect()
+        };
+        match elements.len() {
+            0 => return Err(anyhow!("No element matches")),
+            1 => {}
+            count if !all => {
+                let lines: Vec<String> = elements
+                    .iter()
+                    .map(|(span, _, _)| edit_utils::line_number(&source, span.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "{} elements match, on lines {}; pass all: true to edit every one, or narrow the selection",
+                    count,
+                    lines.join(", ")
+                ));
+            }
+            _ => {}
         }
 
-        // Validate rule structure
-        if let Some(rule) = rule_map.get("rule") {
-            if rule.as_mapping().is_none() {
-                return Err(anyhow!("Rule field must be an object containing pattern or other rule definitions.\n\nExample of correct format:\n{}", self.get_example_rule()));
+        let add: Vec<&str> = add.iter().map(String::as_str).collect();
+        let remove: Vec<&str> = remove.iter().map(String::as_str).collect();
+        let mut edits = Vec::new();
+        let mut edited = Vec::new();
+        for (span, _, start_tag) in &elements {
+            let (classes, edit) = start_tag.edit_classes(&add, &remove);
+            if let Some((start, end, replacement)) = edit {
+                edits.push(TextEdit {
+                    start: span.start + start,
+                    end: span.start + end,
+                    replacement,
+                });
             }
+            edited.push(serde_json::json!({
+                "tag": start_tag.name.to_ascii_lowercase(),
+                "line": edit_utils::line_number(&source, span.start),
+                "before": start_tag.classes(),
+                "after": classes,
+            }));
         }
-
-        Ok(())
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "elements": edited,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
     }
 
-    fn validate_language(&self, language: &str) -> Result<()> {
-        match language {
-            "javascript" | "typescript" | "rust" | "python" | "java" | "go" | "cpp" | "c++" | "c" => Ok(()),
-            _ => Err(anyhow!(
-                "Unsupported language: '{}'\n\nSupported languages: javascript, typescript, rust, python, java, go, cpp, c\n\nExample of correct format:\n{}",
-                language,
-                self.get_example_rule()
-            ))
-        }
+    /// The rule sets of a stylesheet, each with its declarations, in order.
+    /// Rule sets nested in at-rules such as `@media` are included.
+    async fn scan_css_rules(
+        &self,
+        source: &str,
+        path: Option<&Path>,
+    ) -> Result<Vec<(NodeSpan, Value, Vec<NodeSpan>)>> {
+        let spans = |matches: Vec<Value>| -> Vec<(NodeSpan, Value)> {
+            matches
+                .iter()
+                .filter_map(|m| Some((NodeSpan::from_match(m)?, m["range"].clone())))
+                .collect()
+        };
+        let rules = spans(
+            self.scan_source_json(
+                "id: css-rules\nlanguage: css\nrule:\n  kind: rule_set\n",
+                source,
+                path,
+                "css",
+            )
+            .await?,
+        );
+        let declarations = spans(
+            self.scan_source_json(
+                "id: css-declarations\nlanguage: css\nrule:\n  kind: declaration\n  inside:\n    kind: block\n    inside:\n      kind: rule_set\n",
+                source,
+                path,
+                "css",
+            )
+            .await?,
+        );
+        Ok(rules
+            .iter()
+            .map(|(rule, range)| {
+                // A declaration belongs to the innermost rule set around it
+                let owned = declarations
+                    .iter()
+                    .filter(|(declaration, _)| {
+                        rule.start <= declaration.start
+                            && declaration.end <= rule.end
+                            && !rules.iter().any(|(inner, _)| {
+                                inner.end - inner.start < rule.end - rule.start
+                                    && inner.start <= declaration.start
+                                    && declaration.end <= inner.end
+                            })
+                    })
+                    .map(|(declaration, _)| declaration.clone())
+                    .collect();
+                (rule.clone(), range.clone(), owned)
+            })
+            .collect())
     }
 
-    fn get_example_rule(&self) -> &'static str {
-        r#"id: example-rule
-language: javascript
-rule:
-  pattern: function $NAME($$$PARAMS) { $$$BODY }
-  # Optional: add constraints, transformations, etc.
-  # constraints:
-  #   - pattern: $NAME
-  #     regex: "^[a-z]"
-  # transform:
-  #   $NAME: "new_$NAME""#
+    /// List the rule sets of a stylesheet with their declarations,
+    /// optionally only those a selector picks or that set a property.
+    async fn find_css_rules(&self, args: Value) -> Result<String> {
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let (source, path) = self.load_source(&args).await?;
+        let property = args["property"].as_str();
+        let rules = self.scan_css_rules(&source, path.as_deref()).await?;
+
+        let rules: Vec<Value> = rules
+            .iter()
+            .filter(|(rule, _, _)| {
+                let selectors = rule.text.split('{').next().unwrap_or("");
+                args["selector"]
+                    .as_str()
+                    .is_none_or(|wanted| markup::selects(selectors, wanted))
+            })
+            .filter_map(|(rule, range, declarations)| {
+                let declarations: Vec<Value> = declarations
+                    .iter()
+                    .filter_map(|declaration| {
+                        let (name, value, important) = markup::split_declaration(&declaration.text)?;
+                        Some(serde_json::json!({
+                            "property": name,
+                            "value": value,
+                            "important": important,
+                            "line": edit_utils::line_number(&source, declaration.start),
+                        }))
+                    })
+                    .collect();
+                let declares = property.is_none_or(|property| {
+                    declarations
+                        .iter()
+                        .any(|declaration| declaration["property"].as_str() == Some(property))
+                });
+                declares.then(|| {
+                    serde_json::json!({
+                        "selectors": markup::selector_list(rule.text.split('{').next().unwrap_or("")),
+                        "line": edit_utils::line_number(&source, rule.start),
+                        "range": text_encoding::encode_range(&source, range, offset_encoding),
+                        "declarations": declarations,
+                    })
+                })
+            })
+            .collect();
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "count": rules.len(),
+            "rules": rules,
+        }))?)
     }
 
-    async fn execute_rule(&self, args: Value) -> Result<String> {
-        let rule_config = args["rule_config"]
+    /// Set or remove one declaration in the CSS rule set a selector picks.
+    /// An existing declaration of the property gets the new value (the last
+    /// one, when it is declared more than once); otherwise a new one is
+    /// added after the rule's last declaration.
+    async fn edit_css_declaration(&self, args: Value) -> Result<String> {
+        let selector = args["selector"]
             .as_str()
-            .ok_or(anyhow!("Missing rule_config"))?;
-        let target = args["target"].as_str().ok_or(anyhow!("Missing target"))?;
-        let operation = args["operation"].as_str().unwrap_or("search");
-        let dry_run = args["dry_run"].as_bool().unwrap_or(true);
-
-        // Validate the YAML rule configuration before processing
-        self.validate_rule_yaml(rule_config)?;
-
-        // Resolve the target path using MCP roots
-        let resolved_target = self.resolve_path(target)?;
-
-        // Create temporary rule file
-        let temp_rule_file = std::env::temp_dir().join("execute_rule.yml");
-        tokio::fs::write(&temp_rule_file, rule_config).await?;
-
-        let binary_path = self.binary_manager.ensure_binary().await?;
-        let mut cmd = TokioCommand::new(&binary_path);
+            .ok_or(anyhow!("Missing selector"))?;
+        let property = args["property"]
+            .as_str()
+            .ok_or(anyhow!("Missing property"))?;
+        let remove = args["remove"].as_bool().unwrap_or(false);
+        let value = match (args["value"].as_str(), remove) {
+            (Some(_), true) => return Err(anyhow!("Give either value or remove, not both")),
+            (None, false) => return Err(anyhow!("Missing value (or pass remove: true)")),
+            (value, _) => value.map(str::trim),
+        };
+        if !regex::Regex::new(r"^-{0,2}[A-Za-z_][A-Za-z0-9_-]*$")?.is_match(property) {
+            return Err(anyhow!("'{}' is not a valid property name", property));
+        }
+        if value.is_some_and(|value| value.is_empty() || value.contains([';', '{', '}'])) {
+            return Err(anyhow!(
+                "value must be a single declaration value, without ';' or braces"
+            ));
+        }
+        let (source, path) = self.load_source(&args).await?;
 
-        match operation {
-            "search" => {
-                cmd.arg("scan")
-                    .arg("--rule")
-                    .arg(&temp_rule_file)
-                    .arg(&resolved_target)
-                    .arg("--json");
+        let rules = self.scan_css_rules(&source, path.as_deref()).await?;
+        let selected: Vec<&(NodeSpan, Value, Vec<NodeSpan>)> = rules
+            .iter()
+            .filter(|(rule, _, _)| {
+                markup::selects(rule.text.split('{').next().unwrap_or(""), selector)
+            })
+            .filter(|(rule, _, _)| {
+                args["line"]
+                    .as_u64()
+                    .is_none_or(|line| edit_utils::line_number(&source, rule.start) as u64 == line)
+            })
+            .collect();
+        let (rule, _, declarations) = match selected.as_slice() {
+            [only] => *only,
+            [] => return Err(anyhow!("No rule set is selected by '{}'", selector)),
+            _ => {
+                let lines: Vec<String> = selected
+                    .iter()
+                    .map(|(rule, _, _)| edit_utils::line_number(&source, rule.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "'{}' selects the rule sets on lines {}; pass line to choose one",
+                    selector,
+                    lines.join(", ")
+                ));
             }
-            "replace" => {
-                cmd.arg("scan")
-                    .arg("--rule")
-                    .arg(&temp_rule_file)
-                    .arg(&resolved_target);
-
-                if dry_run {
-                    // When dry_run is true, add --json to get structured output without applying
-                    cmd.arg("--json");
+        };
+        let existing = declarations.iter().rev().find(|declaration| {
+            markup::split_declaration(&declaration.text)
+                .is_some_and(|(name, _, _)| name.eq_ignore_ascii_case(property))
+        });
+        let previous = existing
+            .and_then(|declaration| markup::split_declaration(&declaration.text))
+            .map(|(_, value, important)| match important {
+                true => format!("{value} !important"),
+                false => value.to_string(),
+            });
+
+        let mut edits = Vec::new();
+        let action = match (existing, value) {
+            (Some(declaration), None) => {
+                let (start, end) = edit_utils::statement_removal_range(&source, declaration);
+                edits.push(TextEdit {
+                    start,
+                    end,
+                    replacement: String::new(),
+                });
+                "removed"
+            }
+            (None, None) => {
+                return Err(anyhow!(
+                    "The rule set on line {} has no {} declaration",
+                    edit_utils::line_number(&source, rule.start),
+                    property
+                ))
+            }
+            (Some(_), Some(value)) if previous.as_deref() == Some(value) => "unchanged",
+            (Some(declaration), Some(value)) => {
+                let name = declaration
+                    .text
+                    .split(':')
+                    .next()
+                    .unwrap_or(property)
+                    .trim_end();
+                let semicolon = if declaration.text.trim_end().ends_with(';') {
+                    ";"
                 } else {
-                    // When dry_run is false, apply the fixes using -U without --json
-                    cmd.arg("-U");
-                }
+                    ""
+                };
+                edits.push(TextEdit {
+                    start: declaration.start,
+                    end: declaration.end,
+                    replacement: format!("{name}: {value}{semicolon}"),
+                });
+                "updated"
             }
-            "scan" => {
-                cmd.arg("scan")
-                    .arg("--rule")
-                    .arg(&temp_rule_file)
-                    .arg(&resolved_target)
-                    .arg("--json");
+            (None, Some(value)) => {
+                let new_declaration = format!("{property}: {value};");
+                let open = rule.start + rule.text.find('{').unwrap_or(0);
+                let close = rule.end - 1;
+                match declarations.last() {
+                    Some(last) => {
+                        if !last.text.trim_end().ends_with(';') {
+                            edits.push(TextEdit {
+                                start: last.end,
+                                end: last.end,
+                                replacement: ";".to_string(),
+                            });
+                        }
+                        let one_line = edit_utils::line_number(&source, open)
+                            == edit_utils::line_number(&source, last.start);
+                        let replacement = match one_line {
+                            true => format!(" {new_declaration}"),
+                            false => format!(
+                                "\n{}{new_declaration}",
+                                edit_utils::indentation_at(&source, last.start)
+                            ),
+                        };
+                        edits.push(TextEdit {
+                            start: last.end,
+                            end: last.end,
+                            replacement,
+                        });
+                    }
+                    None => {
+                        // Indent like the other rules of the stylesheet
+                        let indent = rules
+                            .iter()
+                            .find_map(|(other, _, declarations)| {
+                                let first = declarations.first()?;
+                                let outer = edit_utils::indentation_at(&source, other.start);
+                                edit_utils::indentation_at(&source, first.start)
+                                    .strip_prefix(outer)
+                                    .filter(|unit| !unit.is_empty())
+                            })
+                            .unwrap_or("  ");
+                        let outer = edit_utils::indentation_at(&source, rule.start);
+                        // Keep any comments in the block
+                        let kept = source[open + 1..close].trim_end().len();
+                        let replacement = match kept {
+                            0 => format!("\n{outer}{indent}{new_declaration}\n{outer}"),
+                            _ => format!("\n{outer}{indent}{new_declaration}"),
+                        };
+                        let start = open + 1 + kept;
+                        edits.push(TextEdit {
+                            start,
+                            end: if kept == 0 { close } else { start },
+                            replacement,
+                        });
+                    }
+                }
+                "added"
             }
-            _ => return Err(anyhow!("Unknown operation: {}", operation)),
-        }
-
-        let output = cmd.output().await?;
-
-        tokio::fs::remove_file(temp_rule_file).await.ok();
+        };
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "selectors": markup::selector_list(rule.text.split('{').next().unwrap_or("")),
+            "line": edit_utils::line_number(&source, rule.start),
+            "property": property,
+            "action": action,
+            "previous": previous,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
+    }
 
-        if !output.status.success() {
-            let stderr = String::from_utf8_lossy(&output.stderr);
-            return Err(anyhow!("ast-grep failed: {}", stderr));
-        }
+    /// List the tables of a TOML document with their keys, or the values
+    /// and tables a selector such as
+    /// `table[name=dependencies] > pair[key=serde]` picks.
+    async fn find_toml_keys(&self, args: Value) -> Result<String> {
+        let offset_encoding = OffsetEncoding::from_args(&args)?;
+        let (source, path) = self.load_source(&args).await?;
+        let tables = toml_doc::tables(&source)?;
+        let range = |start: usize, end: usize| {
+            text_encoding::encode_range(
+                &source,
+                &text_encoding::byte_range(&source, start, end),
+                offset_encoding,
+            )
+        };
+        let describe_table = |table: &toml_doc::Table| {
+            serde_json::json!({
+                "name": toml_doc::dotted(&table.name),
+                "array": table.array,
+                "line": table.header.map(|(start, _)| edit_utils::line_number(&source, start)),
+                "keys": table
+                    .pairs
+                    .iter()
+                    .map(|pair| toml_doc::dotted(&pair.key))
+                    .collect::<Vec<_>>(),
+            })
+        };
+        let Some(selector) = args["selector"].as_str() else {
+            let tables: Vec<Value> = tables
+                .iter()
+                .filter(|table| table.header.is_some() || !table.pairs.is_empty())
+                .map(describe_table)
+                .collect();
+            return Ok(serde_json::to_string_pretty(&serde_json::json!({
+                "target": path.as_ref().map(|path| path.display().to_string()),
+                "count": tables.len(),
+                "tables": tables,
+            }))?);
+        };
 
-        let stdout = String::from_utf8_lossy(&output.stdout);
-        Ok(stdout.to_string())
+        let selector = Selector::parse(selector)?;
+        // A selector naming only a table lists every entry of an array
+        let names_table = selector
+            .table
+            .as_ref()
+            .is_some_and(|(name, index)| *name == selector.path && index.is_none());
+        let candidates = match names_table {
+            true => tables.iter().collect(),
+            false => toml_doc::candidate_tables(&tables, &selector)?,
+        };
+        let values: Vec<Value> = toml_doc::find(&candidates, &selector.path)
+            .iter()
+            .map(|(table, pair)| {
+                let value = &source[pair.value_start..pair.value_end];
+                serde_json::json!({
+                    "key": toml_doc::dotted(&selector.path),
+                    "table": toml_doc::dotted(&table.name),
+                    "line": edit_utils::line_number(&source, pair.start),
+                    "type": pair.kind,
+                    "value": value,
+                    "range": range(pair.value_start, pair.value_end),
+                })
+            })
+            .collect();
+        let tables: Vec<Value> = candidates
+            .iter()
+            .filter(|table| table.header.is_some() && table.name == selector.path)
+            .map(|table| describe_table(table))
+            .collect();
+        Ok(serde_json::to_string_pretty(&serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "count": values.len() + tables.len(),
+            "values": values,
+            "tables": tables,
+        }))?)
     }
 
-    fn get_file_extension(&self, language: &str) -> Result<&str> {
-        match language {
-            "javascript" => Ok("js"),
-            "typescript" => Ok("ts"),
-            "rust" => Ok("rs"),
-            "python" => Ok("py"),
-            "java" => Ok("java"),
-            "go" => Ok("go"),
-            "cpp" | "c++" => Ok("cpp"),
-            "c" => Ok("c"),
-            _ => Err(anyhow!("Unsupported language: {}", language)),
+    /// Set the value of the TOML key a selector picks. Only the value is
+    /// replaced, so the key's spacing and any comment after it stay, and a
+    /// literal string stays literal. A key the document does not have yet
+    /// is added where `toml_doc::insertion` puts it.
+    async fn edit_toml_value(&self, args: Value) -> Result<String> {
+        let selector_text = args["selector"]
+            .as_str()
+            .ok_or(anyhow!("Missing selector"))?;
+        let raw = args["raw"].as_bool().unwrap_or(false);
+        let value = match (&args["value"], raw) {
+            (Value::Null, _) => return Err(anyhow!("Missing value")),
+            (Value::String(text), true) => {
+                let text = text.trim();
+                if !toml_doc::is_value(text) {
+                    return Err(anyhow!("'{}' is not a single TOML value", text));
+                }
+                text.to_string()
+            }
+            (_, true) => return Err(anyhow!("With raw, value must be TOML text in a string")),
+            (value, false) => toml_doc::to_toml(value)?,
+        };
+        let (source, path) = self.load_source(&args).await?;
+        let tables = toml_doc::tables(&source)?;
+        let selector = Selector::parse(selector_text)?;
+        if selector
+            .table
+            .as_ref()
+            .is_some_and(|(name, _)| *name == selector.path)
+        {
+            return Err(anyhow!(
+                "'{}' names a table; add > pair[key=...] to pick a key in it",
+                selector_text
+            ));
         }
+        let candidates = toml_doc::candidate_tables(&tables, &selector)?;
+        let found = toml_doc::find(&candidates, &selector.path);
+
+        let mut previous = None;
+        let (action, edit) = match found.as_slice() {
+            [(_, pair)] => {
+                let old = &source[pair.value_start..pair.value_end];
+                previous = Some(old.to_string());
+                let literal = old.starts_with('\'') && !old.starts_with("'''");
+                let value = match args["value"].as_str() {
+                    Some(text) if literal && !raw && !text.contains(['\'', '\n', '\r']) => {
+                        format!("'{text}'")
+                    }
+                    _ => value,
+                };
+                match old == value {
+                    true => ("unchanged", None),
+                    false => ("updated", Some((pair.value_start, pair.value_end, value))),
+                }
+            }
+            [] => (
+                "added",
+                Some(toml_doc::insertion(&source, &tables, &selector, &value)?),
+            ),
+            _ => {
+                let lines: Vec<String> = found
+                    .iter()
+                    .map(|(_, pair)| edit_utils::line_number(&source, pair.start).to_string())
+                    .collect();
+                return Err(anyhow!(
+                    "{} is set on lines {}; choose an array's entry with table[name=...][index=N]",
+                    toml_doc::dotted(&selector.path),
+                    lines.join(", ")
+                ));
+            }
+        };
+        let edits: Vec<TextEdit> = edit
+            .into_iter()
+            .map(|(start, end, replacement)| TextEdit {
+                start,
+                end,
+                replacement,
+            })
+            .collect();
+        let new_source = edit_utils::apply_edits(&source, &edits)?;
+        let line = match (found.first(), edits.first()) {
+            (Some((_, pair)), _) => edit_utils::line_number(&source, pair.start),
+            (None, Some(edit)) => {
+                let skipped = edit.replacement.len() - edit.replacement.trim_start().len();
+                edit_utils::line_number(&new_source, edit.start + skipped)
+            }
+            (None, None) => 0,
+        };
+
+        let result = serde_json::json!({
+            "target": path.as_ref().map(|path| path.display().to_string()),
+            "key": toml_doc::dotted(&selector.path),
+            "line": line,
+            "action": action,
+            "previous": previous,
+        });
+        let result = self
+            .finish_edit(result, path.as_deref(), &source, &new_source, &edits, &args)
+            .await?;
+        Ok(serde_json::to_string_pretty(&result)?)
     }
 
     pub fn list_resources(&self) -> Vec<Resource> {
@@ -891,6 +14995,42 @@ rule:
 - `except_clause`
 - `identifier`
 
+## C#
+- `namespace_declaration`
+- `file_scoped_namespace_declaration`
+- `using_directive`
+- `class_declaration`
+- `interface_declaration`
+- `method_declaration`
+- `property_declaration`
+- `attribute_list`
+- `attribute`
+- `invocation_expression`
+- `identifier`
+
+Attribute lists are children of the member they decorate, so a rule matching
+`method_declaration` or `class_declaration` covers its `[Attribute]` lines too:
+deleting or moving the member carries its attributes along. Select the
+attributes themselves with `kind: attribute_list` or `kind: attribute`.
+
+## Swift
+- `class_declaration` (also structs, enums, actors, and extensions)
+- `protocol_declaration`
+- `function_declaration`
+- `init_declaration`
+- `property_declaration`
+- `lambda_literal`
+- `call_expression`
+- `attribute`
+- `import_declaration`
+- `simple_identifier`
+
+Attributes such as `@objc` and property wrappers such as `@Published` sit in
+the declaration's `modifiers`, so a rule matching `property_declaration` or
+`function_declaration` covers them; select one with `kind: attribute`. A
+trailing closure is the `lambda_literal` inside the call's
+`call_suffix`, e.g. `kind: lambda_literal` with `inside: { kind: call_expression }`.
+
 ## To discover all node kinds for a language:
 ```bash
 ast-grep run --pattern '$ANY' --lang <language> <file> --debug-query
@@ -1314,9 +15454,15 @@ Use this rule with the execute_rule tool:
             ("function", "javascript" | "typescript") => "pattern: \"function $NAME($$$) { $$$ }\"",
             ("function", "rust") => "pattern: \"fn $NAME($$$) -> $RET { $$$ }\"",
             ("function", "python") => "pattern: \"def $NAME($$$): $$$\"",
+            ("function", "csharp" | "cs") => "kind: method_declaration",
+            ("function", "swift") => "kind: function_declaration",
             ("class", "javascript" | "typescript") => "pattern: \"class $NAME { $$$ }\"",
             ("class", "rust") => "pattern: \"impl $TYPE { $$$ }\"",
             ("class", "python") => "pattern: \"class $NAME: $$$\"",
+            ("class", "csharp" | "cs") => "kind: class_declaration",
+            // Covers class, struct, enum, actor, and extension declarations
+            ("class", "swift") => "kind: class_declaration",
+            ("namespace", "csharp" | "cs") => "kind: namespace_declaration",
             ("loop", "javascript" | "typescript") => "pattern: \"for ($$$) { $$$ }\"",
             ("loop", "rust") => "pattern: \"for $VAR in $ITER { $$$ }\"",
             ("loop", "python") => "pattern: \"for $VAR in $ITER: $$$\"",
@@ -3053,6 +17199,10 @@ ast-grep://docs/language-guide/{language}
 | Java | `.java` | `ast-grep://examples/java` |
 | C/C++ | `.c`, `.cpp`, `.h`, `.hpp` | `ast-grep://examples/cpp` |
 | Go | `.go` | `ast-grep://examples/go` |
+| C# | `.cs` | `ast-grep://examples/csharp` |
+| Swift | `.swift` | `ast-grep://examples/swift` |
+| HTML | `.html`, `.htm` | `ast-grep://examples/html` |
+| CSS | `.css` | `ast-grep://examples/css` |
 
 ## 💡 Quick Tips
 
@@ -3341,6 +17491,113 @@ mod tests {
         AstGrepTools::new(binary_manager)
     }
 
+    #[test]
+    fn test_group_methods_by_receiver() {
+        let symbol = |name: &str, kind: &str, receiver: Option<&str>, start: u64| {
+            serde_json::json!({
+                "name": name,
+                "kind": kind,
+                "receiver": receiver,
+                "depth": 0,
+                "range": {"byteOffset": {"start": start, "end": start + 1}}
+            })
+        };
+        let grouped = AstGrepTools::group_methods_by_receiver(vec![
+            symbol("Area", "method", Some("Circle"), 0),
+            symbol("Circle", "type", None, 10),
+            symbol("Scale", "method", Some("Circle"), 20),
+            symbol("Walk", "method", Some("Tree"), 30),
+            symbol("Keys", "method", Some("Map[K, V]"), 40),
+            symbol("Map", "type", None, 50),
+        ]);
+
+        let names: Vec<&str> = grouped
+            .iter()
+            .map(|symbol| symbol["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(names, ["Circle", "Walk", "Map"]);
+        let methods = |index: usize| -> Vec<&str> {
+            grouped[index]["methods"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|method| method["name"].as_str().unwrap())
+                .collect()
+        };
+        assert_eq!(methods(0), ["Area", "Scale"]);
+        assert_eq!(methods(2), ["Keys"]);
+        assert_eq!(grouped[0]["methods"][0]["depth"], 1);
+    }
+
+    #[test]
+    fn test_index_matches_orders_by_path_then_position() {
+        let m = |file: &str, start: u64| serde_json::json!({"file": file, "range": {"byteOffset": {"start": start, "end": start + 1}}});
+        let mut matches = vec![m("src/b.go", 40), m("src/a.go", 90), m("src/b.go", 5)];
+        AstGrepTools::index_matches(&mut matches);
+
+        let order: Vec<(&str, u64)> = matches
+            .iter()
+            .map(|m| {
+                (
+                    m["file"].as_str().unwrap(),
+                    m["range"]["byteOffset"]["start"].as_u64().unwrap(),
+                )
+            })
+            .collect();
+        assert_eq!(order, [("src/a.go", 90), ("src/b.go", 5), ("src/b.go", 40)]);
+        assert_eq!(matches[2]["globalIndex"], 2);
+    }
+
+    #[test]
+    fn test_block_exit_of_a_statement() {
+        assert_eq!(AstGrepTools::block_exit("go", "return err"), Some("return"));
+        assert_eq!(
+            AstGrepTools::block_exit("go", "panic(\"unreachable\")"),
+            Some("panic")
+        );
+        assert_eq!(
+            AstGrepTools::block_exit("rust", "unreachable!();"),
+            Some("unreachable")
+        );
+        assert_eq!(
+            AstGrepTools::block_exit("python", "raise ValueError()"),
+            Some("raise")
+        );
+        assert_eq!(AstGrepTools::block_exit("go", "returned := true"), None);
+        assert_eq!(
+            AstGrepTools::block_exit("javascript", "continue_loop()"),
+            None
+        );
+        assert_eq!(AstGrepTools::block_exit("python", "panic()"), None);
+    }
+
+    #[test]
+    fn test_scope_descriptor_names_the_nearest_scope() {
+        let names = |names: &[&str]| {
+            names
+                .iter()
+                .map(|name| name.to_string())
+                .collect::<Vec<_>>()
+        };
+        let (method, closure) = (names(&["Server", "Start"]), names(&[]));
+        let scope = AstGrepTools::scope_descriptor(
+            &[
+                ("method_declaration", "method", &method),
+                ("func_literal", "function", &closure),
+                ("for_statement", "block", &closure),
+            ],
+            "package",
+        );
+        assert_eq!(scope["kind"], "block");
+        assert_eq!(scope["node"], "for_statement");
+        assert_eq!(scope["name"], "Start");
+        assert_eq!(scope["path"], serde_json::json!(["Server", "Start"]));
+
+        let top = AstGrepTools::scope_descriptor(&[], "package");
+        assert_eq!(top["kind"], "package");
+        assert!(top["name"].is_null());
+    }
+
     #[test]
     fn test_discovery_resources_included() {
         let tools = create_test_tools();
@@ -3444,6 +17701,40 @@ mod tests {
         );
     }
 
+    #[test]
+    fn test_rule_cache_reuses_prepared_rule() {
+        let tools = create_test_tools();
+        let rule = "id: cached\nlanguage: rust\nrule:\n  pattern: foo()\n";
+
+        let first = tools.prepare_rule(rule, false).unwrap();
+        let second = tools.prepare_rule(rule, false).unwrap();
+        assert!(
+            Arc::ptr_eq(&first, &second),
+            "Same config should hit the cache"
+        );
+        assert_eq!(std::fs::read_to_string(first.path()).unwrap(), rule);
+        assert_eq!(tools.rule_cache_len(), 1);
+
+        assert_eq!(tools.clear_rule_cache(), 1);
+        assert_eq!(tools.rule_cache_len(), 0);
+        let third = tools.prepare_rule(rule, false).unwrap();
+        assert!(
+            !Arc::ptr_eq(&first, &third),
+            "Cleared cache should re-prepare"
+        );
+    }
+
+    #[test]
+    fn test_rule_cache_validates_on_first_validated_use() {
+        let tools = create_test_tools();
+        let rule = "language: rust\nrule:\n  pattern: foo()\n";
+
+        // Cached unvalidated, as internal scans do
+        tools.prepare_rule(rule, false).unwrap();
+        let error = tools.prepare_rule(rule, true).err().unwrap().to_string();
+        assert!(error.contains("Missing required fields: id"), "{}", error);
+    }
+
     #[test]
     fn test_resource_count_increased() {
         let tools = create_test_tools();
@@ -0,0 +1,147 @@
+//! Crash- and cancellation-safe file replacement.
+
+use anyhow::{anyhow, Result};
+use std::io::Write;
+use std::path::{Path, PathBuf};
+
+/// Replace `path` with `contents` so readers only ever see the old or the
+/// new file. The data is written to a temporary file in the same directory
+/// and renamed over the target, keeping the original permissions and, on
+/// Unix, its owner and group where the process is allowed to. A symlinked
+/// path replaces the file it points to, not the link.
+///
+/// The write runs on a blocking thread, so it finishes even if the calling
+/// future is dropped part-way through.
+pub async fn write_atomic(path: &Path, contents: &str) -> Result<()> {
+    write_atomic_bytes(path, contents.as_bytes().to_vec()).await
+}
+
+/// `write_atomic` for contents that are not UTF-8 text.
+pub async fn write_atomic_bytes(path: &Path, contents: Vec<u8>) -> Result<()> {
+    let path: PathBuf = path.to_path_buf();
+    tokio::task::spawn_blocking(move || write_atomic_blocking(&path, &contents))
+        .await
+        .map_err(|e| anyhow!("File write task failed: {}", e))?
+}
+
+pub fn write_atomic_blocking(path: &Path, contents: &[u8]) -> Result<()> {
+    let original = std::fs::metadata(path).ok();
+    let path = match original {
+        Some(_) => std::fs::canonicalize(path)?,
+        None => path.to_path_buf(),
+    };
+    let dir = match path.parent() {
+        Some(parent) if !parent.as_os_str().is_empty() => parent,
+        _ => Path::new("."),
+    };
+    let mut file = tempfile::Builder::new()
+        .prefix(".splice-weaver-")
+        .suffix(".tmp")
+        .tempfile_in(dir)
+        .map_err(|e| {
+            anyhow!(
+                "Failed to create a temporary file in {}: {}",
+                dir.display(),
+                e
+            )
+        })?;
+    file.write_all(contents)?;
+    file.as_file().sync_all()?;
+    match original {
+        Some(metadata) => {
+            // Ownership first: changing it can clear setuid/setgid bits
+            copy_owner(file.as_file(), &metadata);
+            file.as_file().set_permissions(metadata.permissions())?;
+        }
+        None => set_new_file_permissions(file.as_file())?,
+    }
+    file.persist(&path)
+        .map_err(|e| anyhow!("Failed to replace {}: {}", path.display(), e.error))?;
+    sync_dir(dir);
+    Ok(())
+}
+
+/// Give the replacement the original's owner and group. Only root may give
+/// a file away, so otherwise just the group is kept, if we are a member.
+#[cfg(unix)]
+fn copy_owner(file: &std::fs::File, original: &std::fs::Metadata) {
+    use std::os::unix::fs::{fchown, MetadataExt};
+    if fchown(file, Some(original.uid()), Some(original.gid())).is_err() {
+        let _ = fchown(file, None, Some(original.gid()));
+    }
+}
+
+#[cfg(not(unix))]
+fn copy_owner(_file: &std::fs::File, _original: &std::fs::Metadata) {}
+
+/// Temporary files are created private (0600); a new file gets the usual
+/// 0644 instead.
+#[cfg(unix)]
+fn set_new_file_permissions(file: &std::fs::File) -> Result<()> {
+    use std::os::unix::fs::PermissionsExt;
+    Ok(file.set_permissions(std::fs::Permissions::from_mode(0o644))?)
+}
+
+#[cfg(not(unix))]
+fn set_new_file_permissions(_file: &std::fs::File) -> Result<()> {
+    Ok(())
+}
+
+/// Flush the rename itself, so after a crash the directory entry points at
+/// the old or the new file. Best effort: not every platform allows it.
+fn sync_dir(dir: &Path) {
+    #[cfg(unix)]
+    if let Ok(dir) = std::fs::File::open(dir) {
+        let _ = dir.sync_all();
+    }
+    #[cfg(not(unix))]
+    let _ = dir;
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_write_atomic_replaces_contents() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("main.go");
+        std::fs::write(&path, "package old\n").unwrap();
+
+        write_atomic(&path, "package new\n").await.unwrap();
+        assert_eq!(std::fs::read_to_string(&path).unwrap(), "package new\n");
+
+        // No temporary files are left behind
+        let entries: Vec<_> = std::fs::read_dir(dir.path()).unwrap().collect();
+        assert_eq!(entries.len(), 1);
+    }
+
+    #[cfg(unix)]
+    #[tokio::test]
+    async fn test_write_atomic_keeps_mode_and_symlinks() {
+        use std::os::unix::fs::PermissionsExt;
+        let dir = tempfile::tempdir().unwrap();
+        let mode = |path: &Path| std::fs::metadata(path).unwrap().permissions().mode() & 0o777;
+
+        let script = dir.path().join("build.sh");
+        std::fs::write(&script, "#!/bin/sh\n").unwrap();
+        std::fs::set_permissions(&script, std::fs::Permissions::from_mode(0o750)).unwrap();
+        let link = dir.path().join("latest.sh");
+        std::os::unix::fs::symlink(&script, &link).unwrap();
+
+        write_atomic(&link, "#!/bin/sh\nmake\n").await.unwrap();
+        assert!(std::fs::symlink_metadata(&link)
+            .unwrap()
+            .file_type()
+            .is_symlink());
+        assert_eq!(
+            std::fs::read_to_string(&script).unwrap(),
+            "#!/bin/sh\nmake\n"
+        );
+        assert_eq!(mode(&script), 0o750);
+
+        let new_file = dir.path().join("plan.json");
+        write_atomic(&new_file, "{}").await.unwrap();
+        assert_eq!(mode(&new_file), 0o644);
+    }
+}
@@ -1,10 +1,12 @@
+use crate::atomic_write;
 use anyhow::{anyhow, Result};
 use std::fs;
 use std::path::{Path, PathBuf};
 use tokio::process::Command as TokioCommand;
 use tracing::{info, warn};
 
-const AST_GREP_VERSION: &str = "0.38.7";
+/// ast-grep release downloaded when no binary is installed
+pub const AST_GREP_VERSION: &str = "0.38.7";
 const GITHUB_RELEASE_URL: &str = "https://github.com/ast-grep/ast-grep/releases/download";
 
 pub struct BinaryManager {
@@ -152,7 +154,7 @@ impl BinaryManager {
             if file_name.ends_with("ast-grep") || file_name.ends_with("ast-grep.exe") {
                 let mut buffer = Vec::new();
                 std::io::copy(&mut file, &mut buffer)?;
-                fs::write(&self.binary_path, buffer)?;
+                atomic_write::write_atomic_blocking(&self.binary_path, &buffer)?;
                 return Ok(());
             }
 
@@ -165,7 +167,7 @@ impl BinaryManager {
             {
                 let mut buffer = Vec::new();
                 std::io::copy(&mut file, &mut buffer)?;
-                fs::write(&self.binary_path, buffer)?;
+                atomic_write::write_atomic_blocking(&self.binary_path, &buffer)?;
                 return Ok(());
             }
         }
@@ -189,7 +191,7 @@ impl BinaryManager {
             if file_name.ends_with("ast-grep") || file_name.ends_with("ast-grep.exe") {
                 let mut buffer = Vec::new();
                 std::io::copy(&mut entry, &mut buffer)?;
-                fs::write(&self.binary_path, buffer)?;
+                atomic_write::write_atomic_blocking(&self.binary_path, &buffer)?;
                 return Ok(());
             }
         }
@@ -0,0 +1,638 @@
+//! Go build constraints: the `//go:build` line in a file's header, the
+//! legacy `// +build` lines it replaced, and the GOOS/GOARCH suffixes of
+//! file names such as `poll_linux_amd64.go`.
+//!
+//! Parsing follows `go/build` and `go/build/constraint`: a `//go:build`
+//! line counts anywhere before the first line of code, `// +build` lines
+//! only above the last blank line before it, and only when there is no
+//! `//go:build` line.
+
+use crate::edit_utils;
+use anyhow::{anyhow, Result};
+use std::fmt;
+
+/// Operating systems Go knows, which a file name suffix can select.
+const KNOWN_OS: &[&str] = &[
+    "aix",
+    "android",
+    "darwin",
+    "dragonfly",
+    "freebsd",
+    "hurd",
+    "illumos",
+    "ios",
+    "js",
+    "linux",
+    "nacl",
+    "netbsd",
+    "openbsd",
+    "plan9",
+    "solaris",
+    "wasip1",
+    "windows",
+    "zos",
+];
+
+/// The operating systems the `unix` tag stands for.
+const UNIX_OS: &[&str] = &[
+    "aix",
+    "android",
+    "darwin",
+    "dragonfly",
+    "freebsd",
+    "hurd",
+    "illumos",
+    "ios",
+    "linux",
+    "netbsd",
+    "openbsd",
+    "solaris",
+];
+
+/// Architectures Go knows, which a file name suffix can select.
+const KNOWN_ARCH: &[&str] = &[
+    "386",
+    "amd64",
+    "amd64p32",
+    "arm",
+    "armbe",
+    "arm64",
+    "arm64be",
+    "loong64",
+    "mips",
+    "mipsle",
+    "mips64",
+    "mips64le",
+    "mips64p32",
+    "mips64p32le",
+    "ppc",
+    "ppc64",
+    "ppc64le",
+    "riscv",
+    "riscv64",
+    "s390",
+    "s390x",
+    "sparc",
+    "sparc64",
+    "wasm",
+];
+
+/// A build constraint expression such as `linux && (amd64 || arm64)`.
+#[derive(Debug, Clone, PartialEq)]
+pub enum Expr {
+    Tag(String),
+    Not(Box<Expr>),
+    And(Box<Expr>, Box<Expr>),
+    Or(Box<Expr>, Box<Expr>),
+}
+
+impl Expr {
+    /// Whether the expression holds when exactly the tags `ok` accepts
+    /// are set.
+    pub fn eval(&self, ok: &dyn Fn(&str) -> bool) -> bool {
+        match self {
+            Expr::Tag(tag) => ok(tag),
+            Expr::Not(x) => !x.eval(ok),
+            Expr::And(x, y) => x.eval(ok) && y.eval(ok),
+            Expr::Or(x, y) => x.eval(ok) || y.eval(ok),
+        }
+    }
+
+    /// Whether the expression holds when `tags` are set, as `go build
+    /// -tags` would see them: `ios` also sets `darwin`, `android` sets
+    /// `linux`, `illumos` sets `solaris`, and any Unix system sets `unix`.
+    pub fn matches(&self, tags: &[String]) -> bool {
+        self.eval(&|tag| tag_is_set(tag, tags))
+    }
+
+    /// Whether `self` and `other` hold for the same sets of tags, the
+    /// check `go vet` makes between a `//go:build` line and the
+    /// `// +build` lines beside it.
+    pub fn equivalent(&self, other: &Expr) -> bool {
+        let mut tags = Vec::new();
+        self.collect_tags(&mut tags);
+        other.collect_tags(&mut tags);
+        tags.sort();
+        tags.dedup();
+        // Too many tags to try every combination
+        if tags.len() > 16 {
+            return self == other;
+        }
+        (0..1u32 << tags.len()).all(|set| {
+            let ok = |tag: &str| {
+                tags.iter()
+                    .position(|&known| known == tag)
+                    .is_some_and(|i| set & (1 << i) != 0)
+            };
+            self.eval(&ok) == other.eval(&ok)
+        })
+    }
+
+    fn collect_tags<'a>(&'a self, tags: &mut Vec<&'a str>) {
+        match self {
+            Expr::Tag(tag) => tags.push(tag),
+            Expr::Not(x) => x.collect_tags(tags),
+            Expr::And(x, y) | Expr::Or(x, y) => {
+                x.collect_tags(tags);
+                y.collect_tags(tags);
+            }
+        }
+    }
+
+    fn and(x: Expr, y: Expr) -> Expr {
+        Expr::And(Box::new(x), Box::new(y))
+    }
+
+    fn or(x: Expr, y: Expr) -> Expr {
+        Expr::Or(Box::new(x), Box::new(y))
+    }
+}
+
+impl fmt::Display for Expr {
+    /// The expression as `gofmt` writes it, with parentheses only where
+    /// `||` sits inside `&&` or under `!`.
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        match self {
+            Expr::Tag(tag) => write!(f, "{}", tag),
+            Expr::Not(x) => match **x {
+                Expr::Tag(_) | Expr::Not(_) => write!(f, "!{}", x),
+                _ => write!(f, "!({})", x),
+            },
+            Expr::And(x, y) => {
+                let operand = |x: &Expr| match x {
+                    Expr::Or(..) => format!("({})", x),
+                    _ => x.to_string(),
+                };
+                write!(f, "{} && {}", operand(x), operand(y))
+            }
+            Expr::Or(x, y) => write!(f, "{} || {}", x, y),
+        }
+    }
+}
+
+fn tag_is_set(tag: &str, tags: &[String]) -> bool {
+    let set = |name: &str| tags.iter().any(|tag| tag == name);
+    set(tag)
+        || (tag == "unix" && UNIX_OS.iter().any(|os| set(os)))
+        || (tag == "linux" && set("android"))
+        || (tag == "solaris" && set("illumos"))
+        || (tag == "darwin" && set("ios"))
+}
+
+fn is_tag_char(c: char) -> bool {
+    c.is_alphanumeric() || c == '_' || c == '.'
+}
+
+/// Parse the expression of a `//go:build` line, the text after
+/// `//go:build`.
+pub fn parse(text: &str) -> Result<Expr> {
+    let mut parser = Parser { text, pos: 0 };
+    let expr = parser.or()?;
+    match parser.next_token()? {
+        None => Ok(expr),
+        Some(token) => Err(anyhow!(
+            "Unexpected '{}' in build constraint '{}'",
+            token,
+            text.trim()
+        )),
+    }
+}
+
+struct Parser<'a> {
+    text: &'a str,
+    pos: usize,
+}
+
+impl<'a> Parser<'a> {
+    /// The next token, without consuming it.
+    fn peek(&self) -> Result<Option<&'a str>> {
+        let rest = &self.text[self.pos..];
+        let rest = rest.trim_start_matches([' ', '\t']);
+        let Some(c) = rest.chars().next() else {
+            return Ok(None);
+        };
+        let len = match c {
+            '(' | ')' | '!' => 1,
+            '&' | '|' if rest[1..].starts_with(c) => 2,
+            _ if is_tag_char(c) => rest.find(|c| !is_tag_char(c)).unwrap_or(rest.len()),
+            _ => {
+                return Err(anyhow!(
+                    "Invalid character '{}' in build constraint '{}'",
+                    c,
+                    self.text.trim()
+                ))
+            }
+        };
+        Ok(Some(&rest[..len]))
+    }
+
+    fn next_token(&mut self) -> Result<Option<&'a str>> {
+        let token = self.peek()?;
+        if let Some(token) = token {
+            let rest = &self.text[self.pos..];
+            self.pos += rest.len() - rest.trim_start_matches([' ', '\t']).len() + token.len();
+        }
+        Ok(token)
+    }
+
+    fn or(&mut self) -> Result<Expr> {
+        let mut x = self.and()?;
+        while self.peek()? == Some("||") {
+            self.next_token()?;
+            x = Expr::or(x, self.and()?);
+        }
+        Ok(x)
+    }
+
+    fn and(&mut self) -> Result<Expr> {
+        let mut x = self.not()?;
+        while self.peek()? == Some("&&") {
+            self.next_token()?;
+            x = Expr::and(x, self.not()?);
+        }
+        Ok(x)
+    }
+
+    fn not(&mut self) -> Result<Expr> {
+        if self.peek()? != Some("!") {
+            return self.atom();
+        }
+        self.next_token()?;
+        if self.peek()? == Some("!") {
+            return Err(anyhow!(
+                "Double negation is not allowed in build constraint '{}'",
+                self.text.trim()
+            ));
+        }
+        Ok(Expr::Not(Box::new(self.atom()?)))
+    }
+
+    fn atom(&mut self) -> Result<Expr> {
+        match self.next_token()? {
+            Some("(") => {
+                let x = self.or()?;
+                match self.next_token()? {
+                    Some(")") => Ok(x),
+                    _ => Err(anyhow!(
+                        "Missing ')' in build constraint '{}'",
+                        self.text.trim()
+                    )),
+                }
+            }
+            Some(token) if token.starts_with(is_tag_char) => Ok(Expr::Tag(token.to_string())),
+            Some(token) => Err(anyhow!(
+                "Unexpected '{}' in build constraint '{}'",
+                token,
+                self.text.trim()
+            )),
+            None => Err(anyhow!(
+                "Build constraint '{}' ends unexpectedly",
+                self.text.trim()
+            )),
+        }
+    }
+}
+
+/// Parse the fields of a `// +build` line, the text after `+build`:
+/// spaces separate alternatives and commas the tags they all need.
+pub fn parse_plus_build(text: &str) -> Result<Expr> {
+    let mut alternatives = Vec::new();
+    for field in text.split_whitespace() {
+        let mut terms = Vec::new();
+        for term in field.split(',') {
+            let (negated, tag) = match term.strip_prefix('!') {
+                Some(tag) => (true, tag),
+                None => (false, term),
+            };
+            if tag.is_empty() || !tag.chars().all(is_tag_char) {
+                return Err(anyhow!(
+                    "Invalid term '{}' in '// +build {}'",
+                    term,
+                    text.trim()
+                ));
+            }
+            let tag = Expr::Tag(tag.to_string());
+            terms.push(match negated {
+                true => Expr::Not(Box::new(tag)),
+                false => tag,
+            });
+        }
+        alternatives.push(terms.into_iter().reduce(Expr::and).unwrap());
+    }
+    // A bare `// +build` line never builds
+    Ok(alternatives
+        .into_iter()
+        .reduce(Expr::or)
+        .unwrap_or_else(|| Expr::Tag("ignore".to_string())))
+}
+
+/// One constraint comment line of a file.
+#[derive(Debug, Clone, PartialEq)]
+pub struct ConstraintLine {
+    /// 1-indexed
+    pub line: usize,
+    /// Offsets of the whole line, its newline included
+    pub start: usize,
+    pub end: usize,
+    pub expr: Expr,
+}
+
+/// The constraint comments in the header of a Go file.
+#[derive(Debug, Clone, Default, PartialEq)]
+pub struct BuildConstraints {
+    pub go_build: Option<ConstraintLine>,
+    /// `// +build` lines; Go ignores them when there is a `//go:build` line
+    pub plus_build: Vec<ConstraintLine>,
+}
+
+impl BuildConstraints {
+    /// Find the constraint comments in the header of `source`.
+    pub fn find(source: &str) -> Result<Self> {
+        let mut constraints = Self::default();
+        // Offset of the end of the last blank line before the first line
+        // of code; `// +build` lines below it are part of the package doc
+        let mut header_end = 0;
+        let mut ended = false;
+        let mut in_block_comment = false;
+        let mut candidates = Vec::new();
+        let mut offset = 0;
+        'lines: for (index, raw) in source.split_inclusive('\n').enumerate() {
+            let (start, end) = (offset, offset + raw.len());
+            offset = end;
+            let mut line = raw.trim();
+            if line.is_empty() && !ended {
+                header_end = end;
+                continue;
+            }
+            if !line.starts_with("//") {
+                ended = true;
+            }
+            if !in_block_comment && is_go_build_comment(line) {
+                if let Some(first) = &constraints.go_build {
+                    return Err(anyhow!(
+                        "Multiple //go:build lines, on lines {} and {}",
+                        first.line,
+                        index + 1
+                    ));
+                }
+                constraints.go_build = Some(ConstraintLine {
+                    line: index + 1,
+                    start,
+                    end,
+                    expr: parse(&line["//go:build".len()..])
+                        .map_err(|e| anyhow!("Invalid //go:build line {}: {}", index + 1, e))?,
+                });
+            }
+            if !in_block_comment && !ended {
+                if let Some(fields) = plus_build_fields(line) {
+                    candidates.push((index + 1, start, end, fields));
+                }
+            }
+            // Skip past comments to find the first line of code
+            while !line.is_empty() {
+                if in_block_comment {
+                    match line.find("*/") {
+                        Some(i) => {
+                            in_block_comment = false;
+                            line = line[i + 2..].trim();
+                            continue;
+                        }
+                        None => continue 'lines,
+                    }
+                }
+                if line.starts_with("//") {
+                    continue 'lines;
+                }
+                match line.strip_prefix("/*") {
+                    Some(rest) => {
+                        in_block_comment = true;
+                        line = rest.trim();
+                    }
+                    None => break 'lines,
+                }
+            }
+        }
+        for (line, start, end, fields) in candidates {
+            if end > header_end {
+                continue;
+            }
+            constraints.plus_build.push(ConstraintLine {
+                line,
+                start,
+                end,
+                expr: parse_plus_build(fields)
+                    .map_err(|e| anyhow!("Invalid // +build line {}: {}", line, e))?,
+            });
+        }
+        Ok(constraints)
+    }
+
+    /// The constraint Go applies: the `//go:build` line, or else all the
+    /// `// +build` lines together.
+    pub fn expr(&self) -> Option<Expr> {
+        match &self.go_build {
+            Some(line) => Some(line.expr.clone()),
+            None => self.plus_build_expr(),
+        }
+    }
+
+    /// What the `// +build` lines require together.
+    pub fn plus_build_expr(&self) -> Option<Expr> {
+        self.plus_build
+            .iter()
+            .map(|line| line.expr.clone())
+            .reduce(Expr::and)
+    }
+}
+
+fn is_go_build_comment(line: &str) -> bool {
+    line.strip_prefix("//go:build")
+        .is_some_and(|rest| rest.is_empty() || rest.starts_with([' ', '\t']))
+}
+
+/// The fields of a `// +build` comment line.
+fn plus_build_fields(line: &str) -> Option<&str> {
+    let rest = line.strip_prefix("//")?.trim_start();
+    let fields = rest.strip_prefix("+build")?;
+    (fields.is_empty() || fields.starts_with([' ', '\t'])).then_some(fields)
+}
+
+/// The constraint a Go file name implies, such as `linux && amd64` for
+/// `poll_linux_amd64.go`. Like `go/build`, only what follows the first
+/// `_` counts, so `linux_amd64.go` only requires `amd64`.
+pub fn file_name_constraint(file_name: &str) -> Option<Expr> {
+    let name = file_name.strip_suffix(".go")?;
+    let name = name.strip_suffix("_test").unwrap_or(name);
+    let parts: Vec<&str> = name[name.find('_')?..].split('_').collect();
+    let n = parts.len();
+    let tag = |name: &str| Expr::Tag(name.to_string());
+    if n >= 2 && KNOWN_OS.contains(&parts[n - 2]) && KNOWN_ARCH.contains(&parts[n - 1]) {
+        return Some(Expr::and(tag(parts[n - 2]), tag(parts[n - 1])));
+    }
+    (KNOWN_OS.contains(&parts[n - 1]) || KNOWN_ARCH.contains(&parts[n - 1]))
+        .then(|| tag(parts[n - 1]))
+}
+
+/// Everything that limits when a Go file builds: its file name and its
+/// constraint comments.
+pub fn file_constraint(file_name: Option<&str>, source: &str) -> Result<Option<Expr>> {
+    let from_name = file_name.and_then(file_name_constraint);
+    let from_comments = BuildConstraints::find(source)?.expr();
+    Ok(match (from_name, from_comments) {
+        (Some(x), Some(y)) => Some(Expr::and(x, y)),
+        (x, y) => x.or(y),
+    })
+}
+
+/// `source` with its constraint comments replaced by a `//go:build` line
+/// for `expr`, or removed when `expr` is `None`. The line goes where the
+/// old one was, or at the top of the file, and is followed by a blank
+/// line. `// +build` lines are always removed: Go has read `//go:build`
+/// alone since 1.17.
+pub fn set_constraint(source: &str, expr: Option<&Expr>) -> Result<String> {
+    let constraints = BuildConstraints::find(source)?;
+    let newline = match edit_utils::uses_crlf(source) {
+        true => "\r\n",
+        false => "\n",
+    };
+    let mut removed: Vec<(usize, usize)> = constraints
+        .go_build
+        .iter()
+        .chain(&constraints.plus_build)
+        .map(|line| (line.start, line.end))
+        .collect();
+    removed.sort();
+    let at = removed.first().map_or(0, |&(start, _)| start);
+
+    let mut result = String::with_capacity(source.len());
+    let mut cursor = 0;
+    for (start, end) in removed {
+        result.push_str(&source[cursor..start]);
+        cursor = end;
+    }
+    result.push_str(&source[cursor..]);
+
+    let (before, mut after) = result.split_at(at);
+    let blank_next = after
+        .split_inclusive('\n')
+        .next()
+        .is_some_and(|line| line.trim().is_empty());
+    let previous_line = before
+        .strip_suffix('\n')
+        .map(|before| before.rsplit('\n').next().unwrap_or_default());
+    let blank_before = previous_line.map_or(true, |line| line.trim().is_empty());
+    Ok(match expr {
+        Some(expr) => {
+            let blank = if blank_next { "" } else { newline };
+            format!("{}//go:build {}{}{}{}", before, expr, newline, blank, after)
+        }
+        None => {
+            // Drop the blank line that separated the removed lines, unless
+            // it still separates something
+            if blank_next && blank_before {
+                after = &after[after.find('\n').map_or(after.len(), |i| i + 1)..];
+            }
+            format!("{}{}", before, after)
+        }
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn tags(names: &[&str]) -> Vec<String> {
+        names.iter().map(|name| name.to_string()).collect()
+    }
+
+    #[test]
+    fn test_parse_and_print() {
+        let expr = parse(" linux && (amd64||arm64) && !cgo").unwrap();
+        assert_eq!(expr.to_string(), "linux && (amd64 || arm64) && !cgo");
+        assert!(expr.matches(&tags(&["linux", "arm64"])));
+        assert!(!expr.matches(&tags(&["linux", "arm64", "cgo"])));
+        assert!(parse("unix").unwrap().matches(&tags(&["darwin"])));
+        assert_eq!(parse("!(a || b)").unwrap().to_string(), "!(a || b)");
+
+        assert!(parse("linux &&").is_err());
+        assert!(parse("(linux").is_err());
+        assert!(parse("!!linux").is_err());
+        assert!(parse("linux amd64").is_err());
+        assert!(parse("linux & amd64").is_err());
+    }
+
+    #[test]
+    fn test_parse_plus_build() {
+        let expr = parse_plus_build(" linux,386 darwin,!cgo").unwrap();
+        assert_eq!(expr.to_string(), "linux && 386 || darwin && !cgo");
+        assert!(parse_plus_build("linux,").is_err());
+        assert_eq!(parse_plus_build("").unwrap().to_string(), "ignore");
+
+        let go_build = parse("(linux && 386) || (darwin && !cgo)").unwrap();
+        assert!(go_build.equivalent(&expr));
+        assert!(!go_build.equivalent(&parse_plus_build("linux,386 darwin").unwrap()));
+    }
+
+    #[test]
+    fn test_find_in_header_only() {
+        let source = "// Copyright 2024\n\n//go:build linux\n// +build linux\n\n// Package poll does.\n// +build ignored\npackage poll\n\n//go:build not-a-header\n";
+        let constraints = BuildConstraints::find(source).unwrap();
+        let go_build = constraints.go_build.as_ref().unwrap();
+        assert_eq!(go_build.line, 3);
+        assert_eq!(&source[go_build.start..go_build.end], "//go:build linux\n");
+        // The +build line in the package doc is not a constraint
+        assert_eq!(constraints.plus_build.len(), 1);
+        assert_eq!(constraints.expr().unwrap().to_string(), "linux");
+
+        let legacy = BuildConstraints::find("// +build a b\n// +build c\n\npackage x\n").unwrap();
+        assert_eq!(legacy.expr().unwrap().to_string(), "(a || b) && c");
+        let doc_only = BuildConstraints::find("// +build linux\npackage x\n").unwrap();
+        assert_eq!(doc_only.expr(), None);
+        assert!(BuildConstraints::find("//go:build a\n//go:build b\npackage x\n").is_err());
+    }
+
+    #[test]
+    fn test_file_name_constraint() {
+        let name = |file: &str| file_name_constraint(file).map(|expr| expr.to_string());
+        assert_eq!(
+            name("poll_linux_amd64.go").as_deref(),
+            Some("linux && amd64")
+        );
+        assert_eq!(name("poll_windows_test.go").as_deref(), Some("windows"));
+        // Only what follows the first underscore counts
+        assert_eq!(name("linux_amd64.go").as_deref(), Some("amd64"));
+        assert_eq!(name("linux.go"), None);
+        assert_eq!(name("poll_fast.go"), None);
+        let expr = file_constraint(Some("x_android.go"), "package x\n")
+            .unwrap()
+            .unwrap();
+        assert!(!expr.matches(&tags(&["linux"])));
+        assert!(expr.matches(&tags(&["android"])));
+    }
+
+    #[test]
+    fn test_set_constraint() {
+        let linux = parse("linux").unwrap();
+        assert_eq!(
+            set_constraint("package x\n", Some(&linux)).unwrap(),
+            "//go:build linux\n\npackage x\n"
+        );
+        let source = "// Copyright 2024\n\n//go:build darwin\n// +build darwin\n\n// Package x does.\npackage x\n";
+        assert_eq!(
+            set_constraint(source, Some(&linux)).unwrap(),
+            "// Copyright 2024\n\n//go:build linux\n\n// Package x does.\npackage x\n"
+        );
+        assert_eq!(
+            set_constraint(source, None).unwrap(),
+            "// Copyright 2024\n\n// Package x does.\npackage x\n"
+        );
+        assert_eq!(
+            set_constraint("//go:build darwin\n\npackage x\n", None).unwrap(),
+            "package x\n"
+        );
+        assert_eq!(
+            set_constraint("//go:build a\r\n\r\npackage x\r\n", Some(&linux)).unwrap(),
+            "//go:build linux\r\n\r\npackage x\r\n"
+        );
+    }
+}
@@ -0,0 +1,246 @@
+//! Grammar variants a tool call can parse its files with instead of the
+//! ones their extensions select, such as a `.h` header as C++ or
+//! JavaScript and TypeScript with the TSX grammar.
+//!
+//! A call naming a `dialect` runs inside a [`DialectScope`]. While it does,
+//! ast-grep is given an sgconfig whose `languageGlobs` send every file of
+//! the dialect's languages to its grammar, and rules written for any of
+//! those languages are retargeted to that grammar. Tools keep working in
+//! the language they were asked about, so nothing else changes, and no
+//! other call is affected.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::future::Future;
+use std::path::Path;
+use std::sync::Arc;
+
+tokio::task_local! {
+    static CURRENT_DIALECT: Arc<DialectScope>;
+}
+
+/// A grammar the files of a family of languages can be parsed with.
+#[derive(Debug)]
+pub struct Dialect {
+    pub name: &'static str,
+    /// The ast-grep language parsing the files
+    pub grammar: &'static str,
+    /// Languages whose files and rules the dialect applies to
+    pub languages: &'static [&'static str],
+    globs: &'static [&'static str],
+}
+
+const C_FAMILY: &[&str] = &[
+    "*.c", "*.h", "*.cpp", "*.cc", "*.cxx", "*.c++", "*.hpp", "*.hh", "*.hxx",
+];
+const JS_FAMILY: &[&str] = &[
+    "*.js", "*.mjs", "*.cjs", "*.jsx", "*.ts", "*.mts", "*.cts", "*.tsx",
+];
+
+const DIALECTS: &[Dialect] = &[
+    Dialect {
+        name: "c",
+        grammar: "c",
+        languages: &["c", "cpp", "c++"],
+        globs: C_FAMILY,
+    },
+    Dialect {
+        name: "cpp",
+        grammar: "cpp",
+        languages: &["c", "cpp", "c++"],
+        globs: C_FAMILY,
+    },
+    Dialect {
+        name: "typescript",
+        grammar: "typescript",
+        languages: &["typescript"],
+        globs: &["*.ts", "*.mts", "*.cts", "*.tsx"],
+    },
+    Dialect {
+        name: "tsx",
+        grammar: "tsx",
+        languages: &["javascript", "typescript"],
+        globs: JS_FAMILY,
+    },
+];
+
+/// The dialect called `name`. `c++` names cpp, and `jsx` names tsx, the
+/// grammar parsing JSX in both JavaScript and TypeScript files.
+pub fn find(name: &str) -> Result<&'static Dialect> {
+    let name = match name.to_ascii_lowercase().as_str() {
+        "c++" => "cpp".to_string(),
+        "jsx" => "tsx".to_string(),
+        name => name.to_string(),
+    };
+    DIALECTS
+        .iter()
+        .find(|dialect| dialect.name == name)
+        .ok_or_else(|| {
+            anyhow!(
+                "Unknown dialect: {}. Use c, cpp, typescript or tsx (or jsx)",
+                name
+            )
+        })
+}
+
+impl Dialect {
+    /// Whether files of `language` may be parsed with this dialect.
+    pub fn applies_to(&self, language: &str) -> bool {
+        self.languages.contains(&language)
+    }
+
+    /// `rule_config` with its language set to the dialect's grammar, if it
+    /// is written for one of the dialect's languages. Other rules, and text
+    /// that is not a rule, are returned as they are.
+    pub fn retarget(&self, rule_config: &str) -> String {
+        let Ok(mut rule) = serde_yaml::from_str::<serde_yaml::Value>(rule_config) else {
+            return rule_config.to_string();
+        };
+        let language = rule
+            .get("language")
+            .and_then(|language| language.as_str())
+            .map(str::to_lowercase);
+        let retargeted = match language {
+            Some(language) if language != self.grammar && self.applies_to(&language) => rule
+                .as_mapping_mut()
+                .and_then(|rule| rule.get_mut("language")),
+            _ => None,
+        };
+        match retargeted {
+            Some(language) => {
+                *language = self.grammar.into();
+                serde_yaml::to_string(&rule).unwrap_or_else(|_| rule_config.to_string())
+            }
+            None => rule_config.to_string(),
+        }
+    }
+
+    /// The sgconfig `base` (the custom grammars' one, if any) with
+    /// `languageGlobs` sending the dialect's files to its grammar.
+    pub fn sgconfig_yaml(&self, base: Option<&str>) -> Result<String> {
+        let mut config: Value = match base {
+            Some(base) => serde_yaml::from_str(base)?,
+            None => serde_json::json!({"ruleDirs": []}),
+        };
+        config["languageGlobs"] = serde_json::json!({ self.grammar: self.globs });
+        Ok(serde_yaml::to_string(&config)?)
+    }
+
+    /// `output` with the dialect named in it: on a JSON object, or on each
+    /// object of a JSON list such as ast-grep's matches. Other output is
+    /// returned as it is.
+    pub fn annotate(&self, output: String) -> String {
+        let mut parsed = match serde_json::from_str::<Value>(&output) {
+            Ok(parsed @ (Value::Object(_) | Value::Array(_))) => parsed,
+            _ => return output,
+        };
+        match &mut parsed {
+            Value::Object(result) => {
+                result.insert("dialect".to_string(), self.name.into());
+            }
+            Value::Array(items) => {
+                for item in items.iter_mut().filter_map(Value::as_object_mut) {
+                    item.insert("dialect".to_string(), self.name.into());
+                }
+            }
+            _ => {}
+        }
+        serde_json::to_string_pretty(&parsed).unwrap_or(output)
+    }
+}
+
+/// The dialect a tool call runs in, and the sgconfig file handing it to
+/// ast-grep.
+#[derive(Debug)]
+pub struct DialectScope {
+    dialect: &'static Dialect,
+    sgconfig: tempfile::TempPath,
+}
+
+impl DialectScope {
+    /// Write the sgconfig for `dialect`, keeping the custom grammars
+    /// registered in `base` if there is one.
+    pub fn new(dialect: &'static Dialect, base: Option<&Path>) -> Result<Self> {
+        let base = base.map(std::fs::read_to_string).transpose()?;
+        let file = tempfile::Builder::new()
+            .prefix("sgconfig-")
+            .suffix(".yml")
+            .tempfile()?;
+        std::fs::write(file.path(), dialect.sgconfig_yaml(base.as_deref())?)?;
+        Ok(Self {
+            dialect,
+            sgconfig: file.into_temp_path(),
+        })
+    }
+
+    pub fn dialect(&self) -> &'static Dialect {
+        self.dialect
+    }
+
+    /// The sgconfig file to pass ast-grep during the call.
+    pub fn sgconfig_path(&self) -> &Path {
+        &self.sgconfig
+    }
+
+    /// Run `future` in this dialect.
+    pub async fn scope<F: Future>(self: Arc<Self>, future: F) -> F::Output {
+        CURRENT_DIALECT.scope(self, future).await
+    }
+
+    /// The dialect the current task is running in, if any.
+    pub fn current() -> Option<Arc<Self>> {
+        CURRENT_DIALECT.try_with(|scope| scope.clone()).ok()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_find_and_retarget() {
+        assert_eq!(find("C++").unwrap().name, "cpp");
+        assert_eq!(find("jsx").unwrap().grammar, "tsx");
+        assert!(find("python4")
+            .unwrap_err()
+            .to_string()
+            .contains("Unknown dialect: python4"));
+        // Python has a single grammar, so no dialect
+        assert!(find("python3").is_err());
+
+        let cpp = find("cpp").unwrap();
+        assert!(cpp.applies_to("c"));
+        assert!(!cpp.applies_to("go"));
+        let rule = "id: classes\nlanguage: C\nrule:\n  kind: class_specifier\n";
+        let retargeted: serde_yaml::Value = serde_yaml::from_str(&cpp.retarget(rule)).unwrap();
+        assert_eq!(retargeted.get("language").unwrap().as_str(), Some("cpp"));
+        assert_eq!(retargeted.get("id").unwrap().as_str(), Some("classes"));
+        // Rules already in the grammar, or for other languages, are kept
+        let go = "id: calls\nlanguage: go\nrule:\n  kind: call_expression\n";
+        assert_eq!(cpp.retarget(go), go);
+        assert_eq!(cpp.retarget("- not a rule"), "- not a rule");
+    }
+
+    #[test]
+    fn test_sgconfig_and_annotate() {
+        let tsx = find("tsx").unwrap();
+        let base = "ruleDirs: []\ncustomLanguages:\n  mojo:\n    extensions: [mojo]\n";
+        let config: Value = serde_yaml::from_str(&tsx.sgconfig_yaml(Some(base)).unwrap()).unwrap();
+        assert_eq!(config["customLanguages"]["mojo"]["extensions"][0], "mojo");
+        assert_eq!(config["languageGlobs"]["tsx"][0], "*.js");
+        let config: Value = serde_yaml::from_str(&tsx.sgconfig_yaml(None).unwrap()).unwrap();
+        assert_eq!(config["ruleDirs"], serde_json::json!([]));
+
+        let annotated: Value =
+            serde_json::from_str(&tsx.annotate(r#"[{"text": "a"}, 1]"#.to_string())).unwrap();
+        assert_eq!(
+            annotated,
+            serde_json::json!([{"text": "a", "dialect": "tsx"}, 1])
+        );
+        let annotated: Value =
+            serde_json::from_str(&tsx.annotate(r#"{"language": "javascript"}"#.to_string()))
+                .unwrap();
+        assert_eq!(annotated["dialect"], "tsx");
+        assert_eq!(tsx.annotate("plain text".to_string()), "plain text");
+    }
+}
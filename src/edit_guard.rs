@@ -0,0 +1,316 @@
+//! Refuse edits to generated files and to protected regions inside files.
+
+use crate::build_constraint;
+use crate::edit_utils::{self, TextEdit};
+use crate::server_config::ProtectionConfig;
+use anyhow::{anyhow, Result};
+use regex::Regex;
+use std::path::Path;
+
+/// A region between protected-region marker comments, inclusive of the
+/// marker lines themselves.
+#[derive(Debug, Clone, PartialEq)]
+pub struct ProtectedRegion {
+    pub start: usize,
+    pub end: usize,
+    pub start_line: usize,
+    pub end_line: usize,
+}
+
+/// The header line that marks `source` as generated, with its 1-indexed line.
+pub fn find_generated_marker(
+    source: &str,
+    config: &ProtectionConfig,
+) -> Result<Option<(usize, String)>> {
+    let markers = config
+        .generated_markers
+        .iter()
+        .map(|pattern| {
+            Regex::new(pattern)
+                .map_err(|e| anyhow!("Invalid generated marker pattern '{}': {}", pattern, e))
+        })
+        .collect::<Result<Vec<_>>>()?;
+
+    Ok(source
+        .lines()
+        .take(config.header_lines)
+        .enumerate()
+        .find(|(_, line)| markers.iter().any(|marker| marker.is_match(line)))
+        .map(|(index, line)| (index + 1, line.trim().to_string())))
+}
+
+/// Regions enclosed by the configured start/end marker lines. An unclosed
+/// start marker protects the rest of the file.
+pub fn find_protected_regions(source: &str, config: &ProtectionConfig) -> Vec<ProtectedRegion> {
+    let mut regions = Vec::new();
+    let mut open: Option<(usize, usize)> = None;
+    let mut offset = 0;
+
+    for (index, line) in source.split_inclusive('\n').enumerate() {
+        let line_number = index + 1;
+        match open {
+            None if line.contains(&config.region_start) => open = Some((offset, line_number)),
+            Some((start, start_line)) if line.contains(&config.region_end) => {
+                regions.push(ProtectedRegion {
+                    start,
+                    end: offset + line.len(),
+                    start_line,
+                    end_line: line_number,
+                });
+                open = None;
+            }
+            _ => {}
+        }
+        offset += line.len();
+    }
+
+    if let Some((start, start_line)) = open {
+        regions.push(ProtectedRegion {
+            start,
+            end: source.len(),
+            start_line,
+            end_line: edit_utils::line_number(source, source.len()),
+        });
+    }
+    regions
+}
+
+/// Check that `edits` to the file at `path` are allowed. Generated files are
+/// refused outright unless `force` is set; edits touching a protected
+/// region are always refused.
+pub fn check_edits(
+    path: &str,
+    source: &str,
+    edits: &[TextEdit],
+    config: &ProtectionConfig,
+    force: bool,
+) -> Result<()> {
+    if edits.is_empty() {
+        return Ok(());
+    }
+
+    if !force {
+        if let Some((line, marker)) = find_generated_marker(source, config)? {
+            return Err(anyhow!(
+                "Refusing to edit {}: it is marked as generated on line {}: `{}`\n\nRegenerate it from its source instead, or pass force: true to edit anyway.",
+                path,
+                line,
+                marker
+            ));
+        }
+    }
+
+    for region in find_protected_regions(source, config) {
+        let touched = edits.iter().find(|edit| {
+            // Insertions exactly at a region boundary leave it intact
+            edit.start < region.end && edit.end > region.start
+                || (edit.start == edit.end && edit.start > region.start && edit.start < region.end)
+        });
+        if let Some(edit) = touched {
+            return Err(anyhow!(
+                "Refusing to edit {}: the change at line {} crosses the protected region on lines {}-{} (marked by '{}' / '{}')",
+                path,
+                edit_utils::line_number(source, edit.start),
+                region.start_line,
+                region.end_line,
+                config.region_start,
+                config.region_end
+            ));
+        }
+    }
+
+    Ok(())
+}
+
+/// Check that files in `language` (as detected from the file) may be edited
+/// under the configured `editable_languages` allowlist.
+pub fn check_language(path: &str, language: Option<&str>, config: &ProtectionConfig) -> Result<()> {
+    if config.editable_languages.is_empty() {
+        return Ok(());
+    }
+    let allowed = language.is_some_and(|language| {
+        config
+            .editable_languages
+            .iter()
+            .any(|editable| editable.eq_ignore_ascii_case(language))
+    });
+    if allowed {
+        return Ok(());
+    }
+    Err(anyhow!(
+        "Refusing to edit {}: editing is disabled for {} files on this server (editable languages: {})",
+        path,
+        language.unwrap_or("unrecognized"),
+        config.editable_languages.join(", ")
+    ))
+}
+
+/// Refuse edits to a Go file that does not build under the configured
+/// `build_tags`, by its file name or its build constraint comments.
+pub fn check_build_tags(
+    path: &str,
+    source: &str,
+    config: &ProtectionConfig,
+    force: bool,
+) -> Result<()> {
+    if config.build_tags.is_empty() || force || !path.ends_with(".go") {
+        return Ok(());
+    }
+    let file_name = Path::new(path).file_name().and_then(|name| name.to_str());
+    match build_constraint::file_constraint(file_name, source)? {
+        Some(expr) if !expr.matches(&config.build_tags) => Err(anyhow!(
+            "Refusing to edit {}: it only builds when `{}`, which this server's build tags ({}) do not satisfy\n\nPass force: true to edit it anyway.",
+            path,
+            expr,
+            config.build_tags.join(", ")
+        )),
+        _ => Ok(()),
+    }
+}
+
+/// Single edit turning `before` into `after`, starting at the beginning of
+/// the first changed line. Useful when a helper returns new text rather than
+/// a list of edits.
+pub fn edit_between(before: &str, after: &str) -> Option<TextEdit> {
+    if before == after {
+        return None;
+    }
+    let mut prefix = before
+        .bytes()
+        .zip(after.bytes())
+        .take_while(|(a, b)| a == b)
+        .count();
+    while !before.is_char_boundary(prefix) {
+        prefix -= 1;
+    }
+    let start = edit_utils::line_start(before, prefix);
+    let suffix = before[start..]
+        .bytes()
+        .rev()
+        .zip(after[start..].bytes().rev())
+        .take_while(|(a, b)| a == b)
+        .count();
+    // Shared bytes can stop mid-character
+    let mut before_end = before.len() - suffix;
+    let mut after_end = after.len() - suffix;
+    while !before.is_char_boundary(before_end) || !after.is_char_boundary(after_end) {
+        before_end += 1;
+        after_end += 1;
+    }
+    Some(TextEdit {
+        start,
+        end: before_end,
+        replacement: after[start..after_end].to_string(),
+    })
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn insertion_at(offset: usize) -> TextEdit {
+        TextEdit {
+            start: offset,
+            end: offset,
+            replacement: "x".to_string(),
+        }
+    }
+
+    #[test]
+    fn test_generated_marker_detected() {
+        let config = ProtectionConfig::default();
+        let source = "// Code generated by protoc-gen-go. DO NOT EDIT.\n\npackage pb\n";
+        let error = check_edits("api.pb.go", source, &[insertion_at(40)], &config, false)
+            .unwrap_err()
+            .to_string();
+        assert!(error.contains("line 1"));
+        assert!(error.contains("Code generated by protoc-gen-go. DO NOT EDIT."));
+
+        // force overrides the generated-file guard
+        assert!(check_edits("api.pb.go", source, &[insertion_at(40)], &config, true).is_ok());
+    }
+
+    #[test]
+    fn test_marker_outside_header_ignored() {
+        let config = ProtectionConfig {
+            header_lines: 1,
+            ..ProtectionConfig::default()
+        };
+        let source = "package main\n// Code generated by hand. DO NOT EDIT.\n";
+        assert!(find_generated_marker(source, &config).unwrap().is_none());
+    }
+
+    #[test]
+    fn test_protected_region_blocks_crossing_edits() {
+        let config = ProtectionConfig::default();
+        let source = "a\n// BEGIN PROTECTED REGION\nb\n// END PROTECTED REGION\nc\n";
+        let regions = find_protected_regions(source, &config);
+        assert_eq!(regions.len(), 1);
+        assert_eq!((regions[0].start_line, regions[0].end_line), (2, 4));
+
+        let b = source.find("b\n").unwrap();
+        let c = source.find("c\n").unwrap();
+        assert!(check_edits("f", source, &[insertion_at(b)], &config, true).is_err());
+        let crossing = TextEdit {
+            start: 0,
+            end: c,
+            replacement: String::new(),
+        };
+        assert!(check_edits("f", source, &[crossing], &config, true).is_err());
+        assert!(check_edits("f", source, &[insertion_at(c)], &config, false).is_ok());
+        assert!(check_edits("f", source, &[insertion_at(0)], &config, false).is_ok());
+    }
+
+    #[test]
+    fn test_build_tags_refuse_excluded_files() {
+        let config = ProtectionConfig {
+            build_tags: vec!["linux".to_string(), "amd64".to_string()],
+            ..ProtectionConfig::default()
+        };
+        let windows = "//go:build windows\n\npackage poll\n";
+        let error = check_build_tags("poll.go", windows, &config, false)
+            .unwrap_err()
+            .to_string();
+        assert!(error.contains("only builds when `windows`"), "{}", error);
+        assert!(check_build_tags("poll.go", windows, &config, true).is_ok());
+        assert!(check_build_tags("poll_darwin.go", "package poll\n", &config, false).is_err());
+        assert!(check_build_tags(
+            "poll_unix.go",
+            "//go:build unix\n\npackage poll\n",
+            &config,
+            false
+        )
+        .is_ok());
+        assert!(check_build_tags("notes.txt", windows, &config, false).is_ok());
+    }
+
+    #[test]
+    fn test_editable_languages_allowlist() {
+        let config = ProtectionConfig {
+            editable_languages: vec!["go".to_string()],
+            ..ProtectionConfig::default()
+        };
+        assert!(check_language("main.go", Some("go"), &config).is_ok());
+        let error = check_language("deploy.sh", Some("bash"), &config)
+            .unwrap_err()
+            .to_string();
+        assert!(
+            error.contains("editing is disabled for bash files"),
+            "{}",
+            error
+        );
+        assert!(check_language("Makefile", None, &config).is_err());
+
+        // No allowlist leaves every language editable
+        let config = ProtectionConfig::default();
+        assert!(check_language("Makefile", None, &config).is_ok());
+    }
+
+    #[test]
+    fn test_edit_between() {
+        let edit = edit_between("using A;\nusing C;\n", "using A;\nusing B;\nusing C;\n").unwrap();
+        assert_eq!((edit.start, edit.end), (9, 9));
+        assert_eq!(edit.replacement, "using B;\n");
+        assert!(edit_between("same", "same").is_none());
+    }
+}
@@ -0,0 +1,109 @@
+//! Edit plans: a rule's fixes saved to disk so they can be applied later
+//! without sending every edit through an MCP message.
+//!
+//! Each file in a plan records a SHA-256 of the contents its edits were
+//! computed against, so a plan is refused once any of its files has changed.
+
+use crate::edit_utils::TextEdit;
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use sha2::{Digest, Sha256};
+
+/// Format version written to and expected in plan files.
+pub const PLAN_VERSION: u32 = 1;
+
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct EditPlan {
+    pub version: u32,
+    pub files: Vec<FilePlan>,
+}
+
+/// The edits for one file, with the hash of the contents they apply to.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct FilePlan {
+    pub path: String,
+    pub sha256: String,
+    pub edits: Vec<TextEdit>,
+}
+
+/// Lowercase hex SHA-256 of `source`.
+pub fn content_hash(source: &str) -> String {
+    format!("{:x}", Sha256::digest(source.as_bytes()))
+}
+
+impl EditPlan {
+    pub fn new(files: Vec<FilePlan>) -> Self {
+        Self {
+            version: PLAN_VERSION,
+            files,
+        }
+    }
+
+    pub fn from_json(contents: &str) -> Result<Self> {
+        let plan: Self =
+            serde_json::from_str(contents).map_err(|e| anyhow!("Invalid edit plan: {}", e))?;
+        if plan.version != PLAN_VERSION {
+            return Err(anyhow!(
+                "Unsupported edit plan version {} (expected {})",
+                plan.version,
+                PLAN_VERSION
+            ));
+        }
+        Ok(plan)
+    }
+
+    pub fn to_json(&self) -> Result<String> {
+        Ok(serde_json::to_string_pretty(self)?)
+    }
+
+    pub fn edit_count(&self) -> usize {
+        self.files.iter().map(|file| file.edits.len()).sum()
+    }
+}
+
+impl FilePlan {
+    pub fn new(path: String, source: &str, edits: Vec<TextEdit>) -> Self {
+        Self {
+            path,
+            sha256: content_hash(source),
+            edits,
+        }
+    }
+
+    /// Whether `source` is still the content this plan was computed against.
+    pub fn matches(&self, source: &str) -> bool {
+        self.sha256.eq_ignore_ascii_case(&content_hash(source))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_plan_round_trip_and_staleness() {
+        let source = "let a = 1;\n";
+        let edit = TextEdit {
+            start: 4,
+            end: 5,
+            replacement: "b".to_string(),
+        };
+        let plan = EditPlan::new(vec![FilePlan::new(
+            "a.js".to_string(),
+            source,
+            vec![edit.clone()],
+        )]);
+
+        let parsed = EditPlan::from_json(&plan.to_json().unwrap()).unwrap();
+        assert_eq!(parsed.edit_count(), 1);
+        assert_eq!(parsed.files[0].edits[0], edit);
+        assert!(parsed.files[0].matches(source));
+        assert!(!parsed.files[0].matches("let a = 2;\n"));
+
+        let future = plan
+            .to_json()
+            .unwrap()
+            .replace("\"version\": 1", "\"version\": 2");
+        assert!(EditPlan::from_json(&future).is_err());
+    }
+}
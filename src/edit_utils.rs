@@ -0,0 +1,3653 @@
+//! Text-level helpers for applying edits located by ast-grep matches.
+//!
+//! ast-grep does the parsing and matching; these helpers work on the byte
+//! ranges it reports so tools can splice new text into a file safely.
+
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+
+/// A node located by ast-grep: its byte range in the source and its text.
+#[derive(Debug, Clone, PartialEq)]
+pub struct NodeSpan {
+    pub start: usize,
+    pub end: usize,
+    pub text: String,
+}
+
+impl NodeSpan {
+    /// Build a span from one entry of ast-grep's `--json` output.
+    pub fn from_match(m: &serde_json::Value) -> Option<Self> {
+        let offsets = &m["range"]["byteOffset"];
+        Some(Self {
+            start: offsets["start"].as_u64()? as usize,
+            end: offsets["end"].as_u64()? as usize,
+            text: m["text"].as_str().unwrap_or("").to_string(),
+        })
+    }
+}
+
+/// Byte offset of the start of the line containing `offset`.
+pub fn line_start(source: &str, offset: usize) -> usize {
+    source[..offset].rfind('\n').map(|i| i + 1).unwrap_or(0)
+}
+
+/// Byte offset just past the newline ending the line containing `offset`.
+pub fn line_end(source: &str, offset: usize) -> usize {
+    source[offset..]
+        .find('\n')
+        .map(|i| offset + i + 1)
+        .unwrap_or(source.len())
+}
+
+/// Leading whitespace of the line containing `offset`.
+pub fn indentation_at(source: &str, offset: usize) -> &str {
+    let start = line_start(source, offset);
+    let line = &source[start..];
+    let width = line.len() - line.trim_start_matches([' ', '\t']).len();
+    &line[..width]
+}
+
+/// 1-indexed line number of `offset`.
+pub fn line_number(source: &str, offset: usize) -> usize {
+    source[..offset].matches('\n').count() + 1
+}
+
+/// Byte offset of a 1-indexed line and character column, clamped to the end
+/// of that line. Returns `None` if the line does not exist.
+pub fn offset_from_position(source: &str, line: usize, column: usize) -> Option<usize> {
+    let line_offset = if line <= 1 {
+        0
+    } else {
+        source
+            .match_indices('\n')
+            .nth(line - 2)
+            .map(|(i, _)| i + 1)?
+    };
+    let rest = &source[line_offset..];
+    let line_text = &rest[..rest.find('\n').unwrap_or(rest.len())];
+    let column_offset = line_text
+        .char_indices()
+        .nth(column.saturating_sub(1))
+        .map(|(i, _)| i)
+        .unwrap_or(line_text.len());
+    Some(line_offset + column_offset)
+}
+
+/// Best-effort name of a function from its source text: the identifier right
+/// before the parameter list (skipping a Go method receiver). Returns `None`
+/// for anonymous functions.
+pub fn guess_function_name(text: &str) -> Option<String> {
+    let signature = text.split('{').next().unwrap_or(text);
+    let signature = match signature.trim_start().strip_prefix("func (") {
+        Some(rest) => &rest[rest.find(')')? + 1..],
+        None => signature,
+    };
+    let head = &signature[..signature.find('(')?];
+    let name: String = head
+        .trim_end()
+        .chars()
+        .rev()
+        .take_while(|c| c.is_alphanumeric() || *c == '_')
+        .collect::<Vec<_>>()
+        .into_iter()
+        .rev()
+        .collect();
+    match name.as_str() {
+        "" | "fn" | "func" | "function" | "def" | "lambda" => None,
+        _ => Some(name),
+    }
+}
+
+/// Ordering key for a single-line statement, ignoring surrounding whitespace
+/// and the statement terminator so `using System;` sorts before
+/// `using System.Linq;`.
+fn sort_key(statement: &str) -> &str {
+    statement.trim().trim_end_matches(';').trim_end()
+}
+
+/// Insert `line` among the `existing` single-line statements (e.g. imports)
+/// keeping them in ordinal order. Returns the new source and the 1-indexed
+/// line the statement was inserted at, or `None` if it is already present.
+///
+/// When there are no existing statements the line is placed at the top of
+/// the file, separated from the following code by a blank line.
+pub fn insert_sorted_line(
+    source: &str,
+    existing: &[NodeSpan],
+    line: &str,
+) -> Option<(String, usize)> {
+    let line = line.trim();
+    if existing.iter().any(|span| span.text.trim() == line) {
+        return None;
+    }
+
+    let mut sorted: Vec<&NodeSpan> = existing.iter().collect();
+    sorted.sort_by_key(|span| span.start);
+
+    let key = sort_key(line);
+    let (offset, indent) = match sorted.iter().find(|span| sort_key(&span.text) > key) {
+        Some(next) => (
+            line_start(source, next.start),
+            indentation_at(source, next.start).to_string(),
+        ),
+        None => match sorted.last() {
+            Some(last) => (
+                line_end(source, last.end),
+                indentation_at(source, last.start).to_string(),
+            ),
+            None => {
+                let mut result = format!("{line}\n\n");
+                result.push_str(source);
+                return Some((result, 1));
+            }
+        },
+    };
+
+    let mut result = String::with_capacity(source.len() + line.len() + indent.len() + 2);
+    result.push_str(&source[..offset]);
+    if !result.is_empty() && !result.ends_with('\n') {
+        result.push('\n');
+    }
+    let inserted_line = line_number(&result, result.len());
+    result.push_str(&indent);
+    result.push_str(line);
+    result.push('\n');
+    result.push_str(&source[offset..]);
+
+    Some((result, inserted_line))
+}
+
+/// Path of a Go import spec such as `"time"` or `log "github.com/x/log"`.
+pub fn go_import_path(spec: &str) -> &str {
+    let spec = spec.trim();
+    let quoted = spec.strip_suffix(['"', '`']).and_then(|rest| {
+        let start = rest.rfind(['"', '`'])?;
+        Some(&rest[start + 1..])
+    });
+    quoted.unwrap_or_else(|| spec.rsplit(char::is_whitespace).next().unwrap_or(spec))
+}
+
+/// Name a Go import spec binds: its alias (`_` and `.` included), or else
+/// the last element of its path, which is usually the package's name.
+pub fn go_import_name(spec: &str) -> &str {
+    let path = go_import_path(spec);
+    match spec
+        .trim()
+        .split_whitespace()
+        .collect::<Vec<_>>()
+        .as_slice()
+    {
+        [alias, _] => alias,
+        _ => path.rsplit('/').next().unwrap_or(path),
+    }
+}
+
+/// A Go import as a spec: a bare path such as `time` gets its quotes,
+/// and an alias keeps its place before the path.
+pub fn go_import_spec(import: &str) -> String {
+    let import = import.trim();
+    if import.ends_with(['"', '`']) {
+        return import.to_string();
+    }
+    match import.rsplit_once(char::is_whitespace) {
+        Some((alias, path)) => format!("{} \"{}\"", alias.trim(), path),
+        None => format!("\"{import}\""),
+    }
+}
+
+/// The edit deleting the Go import `spec` from its `declaration`: the
+/// spec's line in a group, or for `import "fmt"` the whole declaration
+/// along with the blank line before it. Text sharing a line with the spec
+/// is kept.
+pub fn go_import_deletion(source: &str, declaration: &NodeSpan, spec: &NodeSpan) -> TextEdit {
+    let grouped = declaration.text["import".len()..]
+        .trim_start()
+        .starts_with('(');
+    let span = if grouped { spec } else { declaration };
+    let start = line_start(source, span.start);
+    let end = line_end(source, span.end);
+    if !source[start..span.start].trim().is_empty() || !source[span.end..end].trim().is_empty() {
+        return TextEdit {
+            start: span.start,
+            end: span.end,
+            replacement: String::new(),
+        };
+    }
+    let blank_before = !grouped && source[..start].ends_with("\n\n");
+    TextEdit {
+        start: if blank_before { start - 1 } else { start },
+        end,
+        replacement: String::new(),
+    }
+}
+
+/// Whether a Go import path is in the standard library, whose paths have
+/// no dot in their first element.
+pub fn is_go_std_import(path: &str) -> bool {
+    !path.split('/').next().unwrap_or(path).contains('.')
+}
+
+/// The edit that adds the Go import `spec` (`"time"` or `alias "path"`) to a
+/// file with the import `declarations`, each with its specs, and a package
+/// clause ending at `package_end`; `None` when the path is imported already.
+/// As goimports does, standard library imports go in the first group of a
+/// parenthesized declaration and others in the last group that has any, each
+/// kept sorted, and a missing group is added with a blank line between. A
+/// single unparenthesized import becomes a group to take the new one.
+pub fn go_import_edit(
+    source: &str,
+    declarations: &[(NodeSpan, Vec<NodeSpan>)],
+    package_end: usize,
+    spec: &str,
+) -> Option<TextEdit> {
+    let path = go_import_path(spec);
+    if declarations
+        .iter()
+        .flat_map(|(_, specs)| specs)
+        .any(|existing| go_import_path(&existing.text) == path)
+    {
+        return None;
+    }
+    let std = is_go_std_import(path);
+    let insert = |at: usize, text: String| TextEdit {
+        start: at,
+        end: at,
+        replacement: text,
+    };
+    let grouped = declarations.iter().find(|(declaration, specs)| {
+        declaration.text["import".len()..]
+            .trim_start()
+            .starts_with('(')
+            && !specs.is_empty()
+    });
+    if let Some((_, specs)) = grouped {
+        let indent = indentation_at(source, specs[0].start);
+        // Runs of specs with no blank line between them
+        let mut groups: Vec<Vec<&NodeSpan>> = Vec::new();
+        for current in specs {
+            let joined = groups
+                .last()
+                .and_then(|group| group.last())
+                .is_some_and(|previous| {
+                    let gap =
+                        &source[line_end(source, previous.end)..line_start(source, current.start)];
+                    !gap.lines().any(|line| line.trim().is_empty())
+                });
+            match groups.last_mut() {
+                Some(group) if joined => group.push(current),
+                _ => groups.push(vec![current]),
+            }
+        }
+        let all_std = |group: &&Vec<&NodeSpan>| {
+            group
+                .iter()
+                .all(|spec| is_go_std_import(go_import_path(&spec.text)))
+        };
+        let group = match std {
+            true => groups.iter().find(all_std),
+            false => groups.iter().rfind(|group| !all_std(group)),
+        };
+        return Some(match group {
+            Some(group) => match group.iter().find(|next| go_import_path(&next.text) > path) {
+                Some(next) => insert(line_start(source, next.start), format!("{indent}{spec}\n")),
+                None => {
+                    let last = group[group.len() - 1];
+                    insert(line_end(source, last.end), format!("{indent}{spec}\n"))
+                }
+            },
+            None if std => insert(
+                line_start(source, specs[0].start),
+                format!("{indent}{spec}\n\n"),
+            ),
+            None => {
+                let last = &specs[specs.len() - 1];
+                insert(line_end(source, last.end), format!("\n{indent}{spec}\n"))
+            }
+        });
+    }
+    match declarations.last() {
+        Some((declaration, specs)) => {
+            let mut lines: Vec<&str> = specs.iter().map(|spec| spec.text.trim()).collect();
+            lines.push(spec);
+            Some(TextEdit {
+                start: declaration.start,
+                end: declaration.end,
+                replacement: go_import_group(&lines),
+            })
+        }
+        None => Some(insert(
+            line_end(source, package_end),
+            format!("\nimport {spec}\n"),
+        )),
+    }
+}
+
+/// A parenthesized Go import declaration of `specs`, sorted by path with
+/// the standard library first and a blank line before the others.
+pub fn go_import_group(specs: &[&str]) -> String {
+    let mut lines = specs.to_vec();
+    lines.sort_by_key(|spec| {
+        (
+            !is_go_std_import(go_import_path(spec)),
+            go_import_path(spec),
+        )
+    });
+    let mut group = String::from("import (\n");
+    for (i, line) in lines.iter().enumerate() {
+        let previous_std = i > 0 && is_go_std_import(go_import_path(lines[i - 1]));
+        if previous_std && !is_go_std_import(go_import_path(line)) {
+            group.push('\n');
+        }
+        group.push_str(&format!("\t{line}\n"));
+    }
+    group.push(')');
+    group
+}
+
+/// A replacement of the bytes `start..end` with `replacement`.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct TextEdit {
+    pub start: usize,
+    pub end: usize,
+    pub replacement: String,
+}
+
+/// The first `max_bytes` of `text`, cut after the last line that fits when
+/// there is one, with `marker` (given the number of bytes left out) in
+/// place of the rest. `None` if `text` already fits.
+pub fn truncate_text(
+    text: &str,
+    max_bytes: usize,
+    marker: impl Fn(usize) -> String,
+) -> Option<(String, usize)> {
+    if text.len() <= max_bytes {
+        return None;
+    }
+    let mut cut = max_bytes;
+    while !text.is_char_boundary(cut) {
+        cut -= 1;
+    }
+    if let Some(newline) = text[..cut].rfind('\n') {
+        cut = newline + 1;
+    }
+    let omitted = text.len() - cut;
+    let separator = if cut == 0 || text[..cut].ends_with('\n') {
+        ""
+    } else {
+        " "
+    };
+    Some((
+        format!("{}{}{}", &text[..cut], separator, marker(omitted)),
+        omitted,
+    ))
+}
+
+/// Whether `source` uses CRLF line endings, judged by its first line.
+pub fn uses_crlf(source: &str) -> bool {
+    source
+        .find('\n')
+        .is_some_and(|newline| source[..newline].ends_with('\r'))
+}
+
+/// `text` with every bare `\n` turned into `\r\n`.
+pub fn to_crlf(text: &str) -> String {
+    text.replace("\r\n", "\n").replace('\n', "\r\n")
+}
+
+/// Apply non-overlapping edits to `source` in one pass. Offsets refer to the
+/// original source, so callers never need to adjust for earlier edits.
+/// Replacements take the file's line ending, so LF text (such as node text
+/// read with `normalizeNewlines`) never mixes endings in a CRLF file.
+pub fn apply_edits(source: &str, edits: &[TextEdit]) -> Result<String> {
+    let crlf = uses_crlf(source);
+    let mut sorted: Vec<&TextEdit> = edits.iter().collect();
+    sorted.sort_by_key(|edit| (edit.start, edit.end));
+
+    let mut result = String::with_capacity(source.len());
+    let mut cursor = 0;
+    for edit in sorted {
+        if edit.start < cursor {
+            return Err(anyhow!(
+                "Overlapping edits at bytes {}..{} and an earlier edit ending at {}",
+                edit.start,
+                edit.end,
+                cursor
+            ));
+        }
+        if edit.end > source.len() || edit.start > edit.end {
+            return Err(anyhow!(
+                "Edit range {}..{} is outside the source ({} bytes)",
+                edit.start,
+                edit.end,
+                source.len()
+            ));
+        }
+        result.push_str(&source[cursor..edit.start]);
+        match crlf && edit.replacement.contains('\n') {
+            true => result.push_str(&to_crlf(&edit.replacement)),
+            false => result.push_str(&edit.replacement),
+        }
+        cursor = edit.end;
+    }
+    result.push_str(&source[cursor..]);
+    Ok(result)
+}
+
+/// Where each edit's replacement lands in the text `apply_edits` makes
+/// from `source`, in source order.
+pub fn edited_ranges(source: &str, edits: &[TextEdit]) -> Vec<(usize, usize)> {
+    let crlf = uses_crlf(source);
+    let mut sorted: Vec<&TextEdit> = edits.iter().collect();
+    sorted.sort_by_key(|edit| (edit.start, edit.end));
+
+    let mut shift = 0isize;
+    sorted
+        .into_iter()
+        .map(|edit| {
+            let len = match crlf && edit.replacement.contains('\n') {
+                true => to_crlf(&edit.replacement).len(),
+                false => edit.replacement.len(),
+            };
+            let start = (edit.start as isize + shift) as usize;
+            shift += len as isize - (edit.end - edit.start) as isize;
+            (start, start + len)
+        })
+        .collect()
+}
+
+/// Whole-line regions to reformat so every range in `ranges` is covered:
+/// for each, the smallest of `statements` containing it that shares its
+/// lines with nothing but whitespace or a trailing `//` comment.
+/// Overlapping regions are merged. `None` if some range has no such
+/// statement.
+pub fn format_regions(
+    source: &str,
+    statements: &[NodeSpan],
+    ranges: &[(usize, usize)],
+) -> Option<Vec<(usize, usize)>> {
+    let mut regions = ranges
+        .iter()
+        .map(|&(start, end)| {
+            statements
+                .iter()
+                .filter(|span| span.start <= start && end <= span.end)
+                .filter(|span| {
+                    let after = source[span.end..line_end(source, span.end)].trim();
+                    indentation_at(source, span.start).len()
+                        == span.start - line_start(source, span.start)
+                        && (after.is_empty() || after.starts_with("//"))
+                })
+                .map(|span| (line_start(source, span.start), line_end(source, span.end)))
+                .min_by_key(|(start, end)| end - start)
+        })
+        .collect::<Option<Vec<_>>>()?;
+    regions.sort();
+
+    let mut merged: Vec<(usize, usize)> = Vec::new();
+    for (start, end) in regions {
+        match merged.last_mut() {
+            Some(last) if start < last.1 => last.1 = last.1.max(end),
+            _ => merged.push((start, end)),
+        }
+    }
+    Some(merged)
+}
+
+/// Copy of `text` with the contents of string and char literals masked out,
+/// so callers can scan for operators and brackets without tripping on them.
+fn mask_string_literals(text: &str) -> String {
+    let mut masked = String::with_capacity(text.len());
+    let mut quote: Option<char> = None;
+    let mut escaped = false;
+    for c in text.chars() {
+        match quote {
+            Some(q) => {
+                if escaped {
+                    escaped = false;
+                } else if c == '\\' {
+                    escaped = true;
+                } else if c == q {
+                    quote = None;
+                    masked.push(c);
+                    continue;
+                }
+                masked.push('_');
+            }
+            None => {
+                if matches!(c, '"' | '\'' | '`') {
+                    quote = Some(c);
+                }
+                masked.push(c);
+            }
+        }
+    }
+    masked
+}
+
+/// Whether `expr` must be parenthesized before it replaces an identifier,
+/// i.e. it contains an operator or whitespace outside of brackets.
+pub fn needs_parentheses(expr: &str) -> bool {
+    let masked = mask_string_literals(expr.trim());
+    let mut depth = 0i32;
+    for c in masked.chars() {
+        match c {
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            _ if depth == 0
+                && !(c.is_alphanumeric() || matches!(c, '_' | '.' | ':' | '"' | '\'' | '`')) =>
+            {
+                return true
+            }
+            _ => {}
+        }
+    }
+    false
+}
+
+/// Whether evaluating `expr` obviously has no side effects: no calls,
+/// assignments, increments, or object construction.
+pub fn is_trivially_pure(expr: &str) -> bool {
+    let masked = mask_string_literals(expr);
+    if masked.contains('(') || masked.contains("++") || masked.contains("--") {
+        return false;
+    }
+    let assigns = masked.char_indices().any(|(i, c)| {
+        c == '='
+            && !masked[..i].ends_with(['=', '!', '<', '>'])
+            && !masked[i + 1..].starts_with(['=', '>'])
+    });
+    let keywords = masked
+        .split(|c: char| !(c.is_alphanumeric() || c == '_'))
+        .any(|word| matches!(word, "new" | "await" | "yield"));
+    !assigns && !keywords
+}
+
+/// Whether the identifier at `span` is being assigned or incremented rather
+/// than read.
+pub fn is_write_access(source: &str, span: &NodeSpan) -> bool {
+    let before = source[..span.start].trim_end();
+    let after = source[span.end..].trim_start();
+    if before.ends_with("++") || before.ends_with("--") {
+        return true;
+    }
+    if after.starts_with("++") || after.starts_with("--") {
+        return true;
+    }
+    if after.starts_with('=') {
+        return !after[1..].starts_with(['=', '>']);
+    }
+    const COMPOUND: &[&str] = &[
+        "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=", "<<=", ">>=", "**=", "//=", "??=", "&&=",
+        "||=", ":=",
+    ];
+    COMPOUND.iter().any(|op| after.starts_with(op))
+}
+
+/// Whether the identifier at `span` is a member or path segment (`a.x`,
+/// `a->x`, `A::x`) rather than a variable reference.
+pub fn is_member_name(source: &str, span: &NodeSpan) -> bool {
+    let before = source[..span.start].trim_end();
+    (before.ends_with('.') && !before.ends_with(".."))
+        || before.ends_with("->")
+        || before.ends_with("::")
+}
+
+/// Range to delete when removing the statement at `span`: its whole lines if
+/// nothing else shares them, otherwise just the statement.
+pub fn statement_removal_range(source: &str, span: &NodeSpan) -> (usize, usize) {
+    let start = line_start(source, span.start);
+    let end = line_end(source, span.end);
+    let alone =
+        source[start..span.start].trim().is_empty() && source[span.end..end].trim().is_empty();
+    if alone {
+        (start, end)
+    } else {
+        (span.start, span.end)
+    }
+}
+
+/// Byte range of the value returned by the return statement at `span`: the
+/// text after the `return` keyword, without a trailing `;`. `None` for a
+/// bare `return`.
+pub fn return_value_range(source: &str, span: &NodeSpan) -> Option<(usize, usize)> {
+    let text = &source[span.start..span.end];
+    let after_keyword = text.strip_prefix("return")?;
+    if after_keyword.starts_with(|c: char| c.is_alphanumeric() || c == '_') {
+        return None;
+    }
+    let start = span.end - after_keyword.trim_start().len();
+    let end = span.start + text.trim_end().trim_end_matches(';').trim_end().len();
+    (start < end).then_some((start, end))
+}
+
+/// Fit a rule's `replacement` for the text `replaced` to the line breaks
+/// around it, so replacing a node keeps the blank lines that separate it
+/// from its neighbours. Only the edges count: whitespace at the start or
+/// end of either text that contains a line break. The replacement's own
+/// edge whitespace is dropped, since the node it replaces had none, and
+/// the replaced text's is kept, since it was there before, e.g. when the
+/// rule's `expandEnd` took the newline after the node. Blank lines inside
+/// the replacement are its own and stay. A blank replacement deletes and
+/// is left as it is.
+pub fn fit_blank_lines(replaced: &str, replacement: &str) -> String {
+    if replacement.trim().is_empty() {
+        return replacement.to_string();
+    }
+    let (lead, _, trail) = line_break_edges(replaced);
+    let (_, core, _) = line_break_edges(replacement);
+    format!("{}{}{}", lead, core, trail)
+}
+
+/// Split `text` into its leading whitespace if that holds a line break,
+/// the rest, and its trailing whitespace if that holds one.
+fn line_break_edges(text: &str) -> (&str, &str, &str) {
+    let breaks = |whitespace: &str| whitespace.contains('\n');
+    let lead_len = text.len() - text.trim_start().len();
+    let lead_len = if breaks(&text[..lead_len]) {
+        lead_len
+    } else {
+        0
+    };
+    let rest = &text[lead_len..];
+    let core_len = rest.trim_end().len();
+    let core_len = if breaks(&rest[core_len..]) {
+        core_len
+    } else {
+        rest.len()
+    };
+    (&text[..lead_len], &rest[..core_len], &rest[core_len..])
+}
+
+/// Edit keeping the code at `start..end` as a line comment below it, headed
+/// by `{prefix} before:`, so a replacement of that code can be reviewed and
+/// reverted by hand. The comment goes after the line the code ends on, so
+/// it never splits a statement.
+pub fn original_as_comment(source: &str, start: usize, end: usize, prefix: &str) -> TextEdit {
+    let indent = indentation_at(source, start);
+    let at = if source[..end].ends_with('\n') {
+        end
+    } else {
+        line_end(source, end)
+    };
+
+    let mut comment = String::new();
+    if !source[..at].ends_with('\n') {
+        comment.push('\n');
+    }
+    comment.push_str(&format!("{indent}{prefix} before:\n"));
+    for (index, line) in source[start..end].trim_end().lines().enumerate() {
+        // Later lines carry the file's indentation; keep only what is relative
+        let line = match index {
+            0 => line,
+            _ => line.strip_prefix(indent).unwrap_or(line.trim_start()),
+        };
+        if line.trim().is_empty() {
+            comment.push_str(&format!("{indent}{prefix}\n"));
+        } else {
+            comment.push_str(&format!("{indent}{prefix} {}\n", line.trim_end()));
+        }
+    }
+    TextEdit {
+        start: at,
+        end: at,
+        replacement: comment,
+    }
+}
+
+/// Type named by a Go method receiver: `(s *Server)` is `Server`.
+pub fn receiver_type(receiver: &str) -> String {
+    let inner = receiver
+        .trim()
+        .trim_start_matches('(')
+        .trim_end_matches(')')
+        .trim();
+    let receiver_type = match inner.split_once(char::is_whitespace) {
+        // Unnamed generic receivers have spaces in their type parameters
+        Some((name, receiver_type)) if !name.contains('[') => receiver_type.trim(),
+        _ => inner,
+    };
+    receiver_type.trim_start_matches('*').to_string()
+}
+
+/// `name` with its Go export status flipped by the case of its first
+/// letter. A leading initialism is lowered as a whole, so `URLParser`
+/// becomes `urlParser` and `ID` becomes `id`. `None` when the name does not
+/// start with a cased letter.
+pub fn toggle_export_case(name: &str) -> Option<String> {
+    let chars: Vec<char> = name.chars().collect();
+    let first = *chars.first()?;
+    if first.is_lowercase() {
+        return Some(
+            first
+                .to_uppercase()
+                .chain(chars[1..].iter().copied())
+                .collect(),
+        );
+    }
+    if !first.is_uppercase() {
+        return None;
+    }
+    let run = chars.iter().take_while(|c| c.is_uppercase()).count();
+    // In `URLParser` the P starts the next word
+    let lowered = match chars.get(run) {
+        Some(next) if run > 1 && next.is_lowercase() => run - 1,
+        _ => run,
+    };
+    Some(
+        chars[..lowered]
+            .iter()
+            .flat_map(|c| c.to_lowercase())
+            .chain(chars[lowered..].iter().copied())
+            .collect(),
+    )
+}
+
+/// The name declared by a Go receiver list such as `(p *Person)`, if it has
+/// one, and the offset in `receiver` where its type starts.
+pub fn go_receiver_parts(receiver: &str) -> Option<(Option<&str>, usize)> {
+    let open = receiver.find('(')? + 1;
+    let inner = &receiver[open..];
+    let start = open + inner.len() - inner.trim_start().len();
+    let inner = receiver[start..]
+        .trim_end()
+        .trim_end_matches(')')
+        .trim_end();
+    match inner.split_once(char::is_whitespace) {
+        // Unnamed generic receivers have spaces in their type parameters
+        Some((name, rest)) if !name.contains('[') && !name.starts_with('*') => {
+            Some((Some(name), start + inner.len() - rest.trim_start().len()))
+        }
+        _ => Some((None, start)),
+    }
+}
+
+/// Words that may precede a declared name without being part of it.
+const DECLARATION_MODIFIERS: &[&str] = &[
+    "pub",
+    "mut",
+    "ref",
+    "private",
+    "public",
+    "protected",
+    "internal",
+    "readonly",
+    "static",
+    "final",
+    "const",
+    "let",
+    "var",
+    "override",
+    "abstract",
+];
+
+/// Whether `language` writes a declaration's type before its name, as in
+/// `int count`.
+fn type_comes_first(language: &str) -> bool {
+    matches!(language, "java" | "c" | "cpp" | "c++" | "csharp" | "cs")
+}
+
+/// Offsets of the identifier a field or parameter declaration `text`
+/// declares, e.g. `name` in `pub name: String` or `int name = 0`; the
+/// first one of a Go list such as `a, b int`.
+fn declared_name_range(text: &str, language: &str) -> Option<(usize, usize)> {
+    let is_identifier = |c: char| c.is_alphanumeric() || c == '_' || c == '$';
+    let declaration = &text[..text.find(['=', '`']).unwrap_or(text.len())];
+    // A Go embedded field is named after its type, e.g. `Mutex` in `*sync.Mutex`
+    if language == "go" && !declaration.trim().contains(char::is_whitespace) {
+        let start = declaration.rfind(['.', '*']).map_or(0, |i| i + 1);
+        let end = start + declaration[start..].trim_end().len();
+        return (start < end).then_some((start, end));
+    }
+    let mut words = Vec::new();
+    let mut start = None;
+    for (i, c) in declaration.char_indices() {
+        match (is_identifier(c), start) {
+            (true, None) => start = Some(i),
+            (false, Some(word_start)) => {
+                words.push((word_start, i));
+                start = None;
+            }
+            _ => {}
+        }
+    }
+    if let Some(word_start) = start {
+        words.push((word_start, declaration.len()));
+    }
+    let mut candidates = words.into_iter().filter(|&(start, end)| {
+        let word = &declaration[start..end];
+        // `pub(crate)` is a modifier too
+        !DECLARATION_MODIFIERS.contains(&word) && !declaration[..start].ends_with("pub(")
+    });
+    match type_comes_first(language) {
+        // Array brackets and initializers follow the name
+        true => candidates
+            .filter(|&(start, _)| !declaration[..start].trim_end().ends_with('['))
+            .last(),
+        false => candidates.next(),
+    }
+}
+
+/// The name a field or parameter declaration declares, or its whole text
+/// when it declares none (e.g. a Go embedded field).
+pub fn declared_name<'a>(text: &'a str, language: &str) -> &'a str {
+    match declared_name_range(text, language) {
+        Some((start, end)) => &text[start..end],
+        None => text.trim(),
+    }
+}
+
+/// The type a field or parameter declaration gives, without its name,
+/// modifiers, default value or Go struct tag.
+pub fn declared_type<'a>(text: &'a str, language: &str) -> &'a str {
+    let declaration = text[..text.find(['=', '`']).unwrap_or(text.len())].trim();
+    // A Go embedded field is all type
+    if language == "go" && !declaration.contains(char::is_whitespace) {
+        return declaration;
+    }
+    let Some((start, end)) = declared_name_range(declaration, language) else {
+        return declaration;
+    };
+    if type_comes_first(language) {
+        let before = &declaration[..start];
+        let modifiers = before
+            .split_whitespace()
+            .take_while(|word| DECLARATION_MODIFIERS.contains(word))
+            .map(|word| before.find(word).unwrap() + word.len())
+            .last()
+            .unwrap_or(0);
+        return before[modifiers..].trim();
+    }
+    let mut rest = declaration[end..].trim_start();
+    // The other names of a Go list such as `a, b int`
+    while let Some(after_comma) = rest.strip_prefix(',') {
+        let after_comma = after_comma.trim_start();
+        let name_end = after_comma
+            .find(|c: char| !(c.is_alphanumeric() || c == '_'))
+            .unwrap_or(after_comma.len());
+        rest = after_comma[name_end..].trim_start();
+    }
+    rest.trim_start_matches(['?', '!', ':']).trim()
+}
+
+/// Whether a field or parameter declaration is visible outside its
+/// module, in `language`'s terms; `None` where the language has no such
+/// notion.
+pub fn is_exported_declaration(text: &str, language: &str) -> Option<bool> {
+    let name = declared_name(text, language);
+    let words: Vec<&str> = text.split_whitespace().collect();
+    match language {
+        "go" => Some(name.starts_with(char::is_uppercase)),
+        "rust" => Some(words.first().is_some_and(|word| word.starts_with("pub"))),
+        "java" | "csharp" | "cs" => Some(words.contains(&"public")),
+        "python" => Some(!name.starts_with('_')),
+        "javascript" | "typescript" => Some(
+            !words.contains(&"private")
+                && !words.contains(&"protected")
+                && !text.trim_start().starts_with('#'),
+        ),
+        _ => None,
+    }
+}
+
+/// One element of a list being reordered, such as a struct field, with
+/// what travels along with it.
+#[derive(Debug, Clone, PartialEq)]
+pub struct ListItem {
+    /// Start of the comments or attributes on the lines right above the
+    /// element, or of the element itself
+    pub start: usize,
+    pub end: usize,
+    /// A comment ending the element's line
+    pub trailing: Option<(usize, usize)>,
+}
+
+/// Group the named children of a list into [`ListItem`]s. `children` are
+/// in document order, each flagged if it is a comment or attribute rather
+/// than an element. Those on their own lines attach to the element below
+/// unless a blank line separates them; a comment after an element on its
+/// line is that element's trailing comment. Any other stays where it is.
+pub fn list_items(source: &str, children: &[(NodeSpan, bool)]) -> Vec<ListItem> {
+    let own_line = |offset: usize| source[line_start(source, offset)..offset].trim().is_empty();
+    let ends_line = |offset: usize| source[offset..line_end(source, offset)].trim().is_empty();
+    let adjacent = |end: usize, start: usize| source[end..start].matches('\n').count() <= 1;
+
+    let mut items: Vec<ListItem> = Vec::new();
+    // Start of the comments gathered for the next element, and end of the last
+    let mut leading: Option<(usize, usize)> = None;
+    for (span, attached) in children {
+        if !attached {
+            let start = match leading.take() {
+                Some((start, end)) if adjacent(end, span.start) => start,
+                _ => span.start,
+            };
+            items.push(ListItem {
+                start,
+                end: span.end,
+                trailing: None,
+            });
+            continue;
+        }
+        let trails = leading.is_none()
+            && ends_line(span.end)
+            && items.last().is_some_and(|item| {
+                item.trailing.is_none() && !source[item.end..span.start].contains('\n')
+            });
+        if trails {
+            items.last_mut().unwrap().trailing = Some((span.start, span.end));
+        } else if own_line(span.start) {
+            leading = match leading {
+                Some((start, end)) if adjacent(end, span.start) => Some((start, span.end)),
+                _ => Some((span.start, span.end)),
+            };
+        }
+    }
+    items
+}
+
+/// Edits putting `items` in the order `order`, which lists indices into
+/// `items`. Each slot gets the text of the item moving into it while the
+/// separators between slots stay put, and trailing comments move with
+/// their items.
+pub fn reorder_edits(source: &str, items: &[ListItem], order: &[usize]) -> Result<Vec<TextEdit>> {
+    let mut edits = Vec::new();
+    for (slot, &moved) in order.iter().enumerate() {
+        if slot == moved {
+            continue;
+        }
+        let (here, there) = (&items[slot], &items[moved]);
+        edits.push(TextEdit {
+            start: here.start,
+            end: here.end,
+            replacement: source[there.start..there.end].to_string(),
+        });
+        let comment = there.trailing.map(|(start, end)| &source[start..end]);
+        match (here.trailing, comment) {
+            (Some((start, end)), Some(comment)) => edits.push(TextEdit {
+                start,
+                end,
+                replacement: comment.to_string(),
+            }),
+            // The spaces before the comment go with it
+            (Some((start, end)), None) => edits.push(TextEdit {
+                start: source[..start].trim_end_matches([' ', '\t']).len(),
+                end,
+                replacement: String::new(),
+            }),
+            // After the separator ending the slot's line
+            (None, Some(comment)) => {
+                let line = &source[here.end..line_end(source, here.end)];
+                if !line.trim().chars().all(|c| c == ',' || c == ';') {
+                    return Err(anyhow!(
+                        "Cannot move the comment '{}' to line {}: the line holds more than one element",
+                        comment,
+                        source[..here.end].matches('\n').count() + 1
+                    ));
+                }
+                let at = here.end + line.trim_end().len();
+                edits.push(TextEdit {
+                    start: at,
+                    end: at,
+                    replacement: format!(" {}", comment),
+                });
+            }
+            (None, None) => {}
+        }
+    }
+    Ok(edits)
+}
+
+/// Whether the string literal `text` in `language` interpolates values,
+/// like a Python f-string or a JavaScript template with `${...}`, so it
+/// cannot become a translation as it stands.
+pub fn is_interpolated_string(text: &str, language: &str) -> bool {
+    match language {
+        "python" => text
+            .chars()
+            .take_while(|c| c.is_ascii_alphabetic())
+            .any(|c| c.eq_ignore_ascii_case(&'f')),
+        "javascript" | "typescript" => text.starts_with('`') && text.contains("${"),
+        "swift" => text.contains("\\("),
+        _ => false,
+    }
+}
+
+/// The string literal `text` delimited by `quote` instead, with escaped
+/// old quotes unescaped. `Ok(None)` if it already uses `quote`, and the
+/// reason it is left alone otherwise: it contains `quote`, so it would
+/// need escaping, or it is not a quoted literal at all.
+pub fn requote_string(
+    text: &str,
+    quote: char,
+) -> std::result::Result<Option<String>, &'static str> {
+    let quote_at = text.find(['"', '\'']).ok_or("not_quoted")?;
+    let (prefix, literal) = text.split_at(quote_at);
+    let old = literal.chars().next().unwrap_or(quote);
+    let delimiter_len = if literal.len() >= 6 && literal.starts_with(&old.to_string().repeat(3)) {
+        3
+    } else {
+        1
+    };
+    if literal.len() < 2 * delimiter_len || !literal.ends_with(&literal[..delimiter_len]) {
+        return Err("not_quoted");
+    }
+    if old == quote {
+        return Ok(None);
+    }
+    let content = &literal[delimiter_len..literal.len() - delimiter_len];
+    if content.contains(quote) {
+        return Err("contains_quote");
+    }
+
+    // Raw strings keep their backslashes, so there is nothing to unescape
+    let raw = prefix.contains(['r', 'R']);
+    let mut requoted = String::with_capacity(text.len());
+    let mut chars = content.chars();
+    while let Some(c) = chars.next() {
+        match c {
+            '\\' if !raw => match chars.next() {
+                Some(next) if next == old => requoted.push(next),
+                Some(next) => {
+                    requoted.push(c);
+                    requoted.push(next);
+                }
+                None => requoted.push(c),
+            },
+            c => requoted.push(c),
+        }
+    }
+    let delimiter = quote.to_string().repeat(delimiter_len);
+    Ok(Some(format!("{prefix}{delimiter}{requoted}{delimiter}")))
+}
+
+/// The contents of the Go string literal `text` written for an interpreted
+/// `fmt` format string: `%` doubled, and a raw literal's backslashes,
+/// quotes and line breaks escaped. `None` if `text` is not a single
+/// literal.
+pub fn go_format_literal(text: &str) -> Option<String> {
+    let quote = text.chars().next().filter(|c| matches!(c, '"' | '`'))?;
+    if text.len() < 2 || !text.ends_with(quote) {
+        return None;
+    }
+    let content = &text[1..text.len() - 1];
+    let raw = quote == '`';
+
+    let mut format = String::with_capacity(content.len());
+    let mut chars = content.chars();
+    while let Some(c) = chars.next() {
+        match c {
+            '%' => format.push_str("%%"),
+            // Two literals joined some other way, e.g. `"a" + "b"`
+            c if c == quote => return None,
+            '\\' if !raw => {
+                format.push(c);
+                format.push(chars.next()?);
+            }
+            '\\' => format.push_str("\\\\"),
+            '"' => format.push_str("\\\""),
+            '\n' => format.push_str("\\n"),
+            '\t' => format.push_str("\\t"),
+            // Raw strings drop carriage returns
+            '\r' if raw => {}
+            c => format.push(c),
+        }
+    }
+    Some(format)
+}
+
+/// A `Sprintf` call (qualified by `qualifier`, e.g. `fmt.`) equivalent to
+/// concatenating `operands` with `+`: literals become the format string,
+/// everything else a `%s` argument. `None` unless there are both.
+pub fn go_sprintf_call(qualifier: &str, operands: &[&str]) -> Option<String> {
+    let mut format = String::new();
+    let mut arguments = Vec::new();
+    for operand in operands {
+        match go_format_literal(operand) {
+            Some(text) => format.push_str(&text),
+            None => {
+                format.push_str("%s");
+                arguments.push(operand.trim());
+            }
+        }
+    }
+    if arguments.is_empty() || arguments.len() == operands.len() {
+        return None;
+    }
+    Some(format!(
+        "{qualifier}Sprintf(\"{format}\", {})",
+        arguments.join(", ")
+    ))
+}
+
+/// One branch of an if chain or switch: the values its case matches
+/// (none for a final `else` or `default`), the comment ending its first
+/// line, and its statements as whole lines.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Branch {
+    pub values: Vec<String>,
+    pub comment: String,
+    pub body: String,
+}
+
+/// Split a branch body, from just after its `{` or `:` to just before its
+/// closing `}` or its end, into the comment ending its first line and the
+/// statements as whole lines, each ending in a newline. A statement on the
+/// first line is moved to a line of its own at `indent`.
+pub fn branch_body(text: &str, indent: &str) -> (String, String) {
+    let is_comment =
+        |line: &str| line.starts_with("//") || (line.starts_with("/*") && line.ends_with("*/"));
+    let (head, rest) = match text.find('\n') {
+        Some(i) => (text[..i].trim(), text[i + 1..].trim_end()),
+        None => (text.trim(), ""),
+    };
+    let mut body = String::new();
+    let comment = match head {
+        "" => String::new(),
+        head if is_comment(head) => head.to_string(),
+        head => {
+            body = format!("{indent}{head}\n");
+            String::new()
+        }
+    };
+    if !rest.trim().is_empty() {
+        body.push_str(rest);
+        body.push('\n');
+    }
+    (comment, body)
+}
+
+/// Whether `text` is a name or a selector such as `req.Method`, which reads
+/// the same however often it is evaluated.
+pub fn is_plain_operand(text: &str) -> bool {
+    text.split('.').all(|part| {
+        part.chars()
+            .next()
+            .is_some_and(|c| c.is_alphabetic() || c == '_')
+            && part.chars().all(|c| c.is_alphanumeric() || c == '_')
+    })
+}
+
+/// A Go `switch` on `header` (the tag, after any initializer) with a case
+/// per branch, the statement starting at `indent`.
+pub fn go_switch_text(header: &str, branches: &[Branch], indent: &str) -> String {
+    let mut text = format!("switch {header} {{\n");
+    for branch in branches {
+        text.push_str(indent);
+        match branch.values.is_empty() {
+            true => text.push_str("default:"),
+            false => text.push_str(&format!("case {}:", branch.values.join(", "))),
+        }
+        if !branch.comment.is_empty() {
+            text.push(' ');
+            text.push_str(&branch.comment);
+        }
+        text.push('\n');
+        text.push_str(&branch.body);
+    }
+    text.push_str(indent);
+    text.push('}');
+    text
+}
+
+/// A Go if chain equivalent to a switch with `init` and the tag `subject`
+/// (a tagless switch if `None`): each branch with values becomes an `if`
+/// or `else if`, and one without the final `else`, so it must come last.
+pub fn go_if_chain_text(
+    init: Option<&str>,
+    subject: Option<&str>,
+    branches: &[Branch],
+    indent: &str,
+) -> String {
+    // Operands looser than `==` need parentheses to be compared as a whole
+    let operand = |value: &str| match ["||", "&&", "==", "!=", "<", ">"]
+        .iter()
+        .any(|operator| value.contains(operator))
+    {
+        true => format!("({value})"),
+        false => value.to_string(),
+    };
+    let mut text = String::new();
+    for (i, branch) in branches.iter().enumerate() {
+        let condition = match subject {
+            Some(subject) => branch
+                .values
+                .iter()
+                .map(|value| format!("{subject} == {}", operand(value)))
+                .collect::<Vec<_>>(),
+            None => branch.values.clone(),
+        }
+        .join(" || ");
+        match (i, branch.values.is_empty()) {
+            (_, true) => text.push_str(" else {"),
+            (0, false) => {
+                text.push_str("if ");
+                if let Some(init) = init {
+                    text.push_str(&format!("{init}; "));
+                }
+                text.push_str(&format!("{condition} {{"));
+            }
+            (_, false) => text.push_str(&format!(" else if {condition} {{")),
+        }
+        if !branch.comment.is_empty() {
+            text.push(' ');
+            text.push_str(&branch.comment);
+        }
+        text.push('\n');
+        text.push_str(&branch.body);
+        text.push_str(indent);
+        text.push('}');
+    }
+    text
+}
+
+/// One declaration of a Go `var` or `const` group: whether a blank line
+/// sets it apart from the one before, its comment lines, and its spec
+/// without the keyword, including any comment ending its line.
+#[derive(Debug, Clone, PartialEq)]
+pub struct DeclarationItem {
+    pub blank_before: bool,
+    pub comments: Vec<String>,
+    pub spec: String,
+}
+
+/// `items` as one parenthesized Go declaration, e.g. `var (...)`.
+pub fn go_declaration_group(keyword: &str, items: &[DeclarationItem]) -> String {
+    let mut text = format!("{keyword} (\n");
+    for (i, item) in items.iter().enumerate() {
+        if item.blank_before && i > 0 {
+            text.push('\n');
+        }
+        for comment in &item.comments {
+            text.push_str(&format!("\t{comment}\n"));
+        }
+        text.push_str(&format!("\t{}\n", item.spec));
+    }
+    text.push(')');
+    text
+}
+
+/// `items` as separate Go declarations, each comment above its own.
+pub fn go_separate_declarations(keyword: &str, items: &[DeclarationItem]) -> String {
+    let mut text = String::new();
+    for (i, item) in items.iter().enumerate() {
+        if i > 0 {
+            text.push('\n');
+            if item.blank_before {
+                text.push('\n');
+            }
+        }
+        for comment in &item.comments {
+            text.push_str(&format!("{comment}\n"));
+        }
+        text.push_str(&format!("{keyword} {}", item.spec));
+    }
+    text
+}
+
+/// Split `text` on commas that are not nested in brackets.
+pub fn split_top_level_commas(text: &str) -> Vec<&str> {
+    split_commas(text, false)
+}
+
+/// `split_top_level_commas`, with `<>` also counted as brackets when
+/// `angle_brackets` is set, as in TypeScript and Rust generics. The `>` of
+/// an arrow (`=>` or `->`) does not close one.
+fn split_commas(text: &str, angle_brackets: bool) -> Vec<&str> {
+    let mut parts = Vec::new();
+    let mut depth = 0;
+    let mut start = 0;
+    let mut previous = ' ';
+    for (i, c) in text.char_indices() {
+        let angle = angle_brackets && !(c == '>' && matches!(previous, '=' | '-'));
+        previous = c;
+        match c {
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            '<' if angle => depth += 1,
+            '>' if angle => depth -= 1,
+            ',' if depth == 0 => {
+                parts.push(text[start..i].trim());
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    parts.push(text[start..].trim());
+    parts.retain(|part| !part.is_empty());
+    parts
+}
+
+/// The name and type arguments of a generic instantiation written as
+/// `head`, which ends in its argument list: Go's `Map[int, string]` is
+/// `Map` with `int` and `string`, and Rust's `collect::<Vec<_>>` is
+/// `collect` with `Vec<_>`. `None` when `head` does not end in a list
+/// opened by `open`.
+pub fn type_arguments(head: &str, open: char, close: char) -> Option<(&str, Vec<&str>)> {
+    let head = head.trim();
+    let inner_end = head.strip_suffix(close)?.len();
+    let mut depth = 0;
+    let mut chars = head.char_indices().rev().peekable();
+    while let Some((i, c)) = chars.next() {
+        let arrow = c == '>' && matches!(chars.peek(), Some((_, '-' | '=')));
+        if c == close && !arrow {
+            depth += 1;
+        } else if c == open {
+            depth -= 1;
+            if depth == 0 {
+                let name = head[..i].trim_end().trim_end_matches("::").trim_end();
+                let arguments = split_commas(&head[i + 1..inner_end], open == '<');
+                return (!name.is_empty()).then_some((name, arguments));
+            }
+        }
+    }
+    None
+}
+
+/// Names of the parameters in a generic declaration's type parameter list:
+/// Go's `[K comparable, V any]` and `[T, U any]`, or Rust's
+/// `<'a, T: Clone, const N: usize>`, whose lifetimes are kept.
+pub fn type_parameter_names(params: &str) -> Vec<String> {
+    let params = params.trim();
+    let inner = params
+        .strip_prefix(['[', '<'])
+        .and_then(|rest| rest.strip_suffix([']', '>']))
+        .unwrap_or(params);
+    split_commas(inner, true)
+        .into_iter()
+        .filter_map(|param| {
+            let param = param.strip_prefix("const ").unwrap_or(param).trim_start();
+            let lifetime = usize::from(param.starts_with('\''));
+            let end = param[lifetime..]
+                .find(|c: char| !(c.is_alphanumeric() || c == '_'))
+                .map_or(param.len(), |end| end + lifetime);
+            (end > lifetime).then(|| param[..end].to_string())
+        })
+        .collect()
+}
+
+/// Whether `text` starts with a Rust lifetime such as `'a` rather than a
+/// char literal.
+fn starts_with_lifetime(text: &str) -> bool {
+    let rest = &text[1..];
+    let name = rest.len()
+        - rest
+            .trim_start_matches(|c: char| c.is_alphanumeric() || c == '_')
+            .len();
+    name > 0 && !rest[name..].starts_with('\'')
+}
+
+/// Trimmed byte ranges of the elements of the comma-separated list between
+/// `start` and `end` (just inside its brackets), and whether the last
+/// element is followed by a comma. Commas inside brackets, strings and
+/// comments do not separate elements; `<>` count as brackets in Rust and
+/// TypeScript generics.
+pub fn list_elements(
+    source: &str,
+    start: usize,
+    end: usize,
+    language: &str,
+) -> (Vec<(usize, usize)>, bool) {
+    let text = &source[start..end];
+    let angle_brackets = matches!(language, "rust" | "typescript");
+    let mut separators = Vec::new();
+    let mut depth = 0;
+    let mut quote: Option<char> = None;
+    let mut escaped = false;
+    let mut skip_to = 0;
+    let mut previous = ' ';
+    for (i, c) in text.char_indices() {
+        if i < skip_to {
+            continue;
+        }
+        if let Some(q) = quote {
+            if escaped {
+                escaped = false;
+            } else if c == '\\' {
+                escaped = true;
+            } else if c == q {
+                quote = None;
+            }
+            continue;
+        }
+        let rest = &text[i..];
+        let angle = angle_brackets && !(c == '>' && matches!(previous, '=' | '-'));
+        previous = c;
+        match c {
+            '\'' if language == "rust" && starts_with_lifetime(rest) => {}
+            '"' | '\'' | '`' => quote = Some(c),
+            '/' if rest.starts_with("/*") => {
+                skip_to = rest.find("*/").map_or(text.len(), |close| i + close + 2)
+            }
+            '/' if rest.starts_with("//") => {
+                skip_to = rest.find('\n').map_or(text.len(), |newline| i + newline)
+            }
+            '#' if language == "python" => {
+                skip_to = rest.find('\n').map_or(text.len(), |newline| i + newline)
+            }
+            '(' | '[' | '{' => depth += 1,
+            ')' | ']' | '}' => depth -= 1,
+            '<' if angle => depth += 1,
+            '>' if angle => depth -= 1,
+            ',' if depth == 0 => separators.push(start + i),
+            _ => {}
+        }
+    }
+
+    let mut elements = Vec::new();
+    let mut trailing_comma = false;
+    let mut part_start = start;
+    for part_end in separators.iter().copied().chain([end]) {
+        let element_start = skip_trivia(source, part_start).min(part_end);
+        let element_end = part_start + source[part_start..part_end].trim_end().len();
+        if element_start < element_end {
+            elements.push((element_start, element_end));
+        } else if part_end == end && !elements.is_empty() {
+            trailing_comma = true;
+        }
+        part_start = part_end + 1;
+    }
+    (elements, trailing_comma)
+}
+
+/// Edit removing element `index` of a list from `list_elements`, along
+/// with the comma and spacing that separate it from a neighbor: the next
+/// element's, or for the last element the previous one's, so a trailing
+/// comma stays in place. Removing the only element empties the list.
+pub fn list_delete_edit(
+    elements: &[(usize, usize)],
+    inner: (usize, usize),
+    index: usize,
+) -> TextEdit {
+    let (start, end) = match (index.checked_sub(1), elements.get(index + 1)) {
+        (_, Some(next)) => (elements[index].0, next.0),
+        (Some(previous), None) => (elements[previous].1, elements[index].1),
+        (None, None) => inner,
+    };
+    TextEdit {
+        start,
+        end,
+        replacement: String::new(),
+    }
+}
+
+/// Edit inserting `element` so it becomes element `index` of a list from
+/// `list_elements`, separated from its neighbors the way the list already
+/// separates its elements (`, ` on one line, or one element per line).
+pub fn list_insert_edit(
+    source: &str,
+    elements: &[(usize, usize)],
+    inner: (usize, usize),
+    index: usize,
+    element: &str,
+) -> TextEdit {
+    let separator = match elements {
+        [] => {
+            return TextEdit {
+                start: inner.0,
+                end: inner.1,
+                replacement: element.to_string(),
+            }
+        }
+        [first, second, ..] => source[first.1..second.0].to_string(),
+        [only] if source[only.1..inner.1].contains('\n') => {
+            format!(",\n{}", indentation_at(source, only.0))
+        }
+        [_] => ", ".to_string(),
+    };
+    match elements.get(index) {
+        Some(&(start, _)) => TextEdit {
+            start,
+            end: start,
+            replacement: format!("{element}{separator}"),
+        },
+        None => {
+            let end = elements[elements.len() - 1].1;
+            TextEdit {
+                start: end,
+                end,
+                replacement: format!("{separator}{element}"),
+            }
+        }
+    }
+}
+
+/// Names and types in a Go function's result list: `(a, b int, err error)`
+/// is `a int`, `b int`, `err error`, and `(int, error)` has no names.
+pub fn go_results(result: &str) -> Vec<(Option<String>, String)> {
+    let result = result.trim();
+    let Some(inner) = result
+        .strip_prefix('(')
+        .and_then(|rest| rest.strip_suffix(')'))
+    else {
+        return vec![(None, result.to_string())];
+    };
+    let parts = split_top_level_commas(inner);
+    // `name type` parts; a type such as `chan int` also has a space
+    let named = |part: &str| {
+        part.split_once(char::is_whitespace)
+            .filter(|(name, _)| {
+                !matches!(*name, "chan" | "func" | "interface" | "map" | "struct")
+                    && name.chars().all(|c| c.is_alphanumeric() || c == '_')
+            })
+            .map(|(name, result_type)| (name.to_string(), result_type.trim().to_string()))
+    };
+    if !parts.iter().any(|part| named(part).is_some()) {
+        return parts.iter().map(|part| (None, part.to_string())).collect();
+    }
+    // Names without a type share the type of the next named part
+    let mut results = Vec::new();
+    let mut untyped: Vec<String> = Vec::new();
+    for part in parts {
+        match named(part) {
+            Some((name, result_type)) => {
+                for untyped_name in untyped.drain(..) {
+                    results.push((Some(untyped_name), result_type.clone()));
+                }
+                results.push((Some(name), result_type));
+            }
+            None => untyped.push(part.to_string()),
+        }
+    }
+    results
+}
+
+/// Types in a Go function's result list: `(n int, err error)` and
+/// `(int, error)` are both `["int", "error"]`, and `(a, b int)` is
+/// `["int", "int"]`.
+pub fn go_result_types(result: &str) -> Vec<String> {
+    go_results(result)
+        .into_iter()
+        .map(|(_, result_type)| result_type)
+        .collect()
+}
+
+/// A Go result list for `results`, grouping neighbours of the same type
+/// when they are named: `(a, b int, err error)`, or `(int, error)`. A
+/// single unnamed result has no parentheses.
+pub fn format_go_results(results: &[(Option<String>, String)]) -> String {
+    if let [(None, result_type)] = results {
+        return result_type.clone();
+    }
+    let mut parts: Vec<String> = Vec::new();
+    for (i, (name, result_type)) in results.iter().enumerate() {
+        let next_type = results.get(i + 1).map(|(_, next)| next);
+        match name {
+            Some(name) if next_type == Some(result_type) => parts.push(name.clone()),
+            Some(name) => parts.push(format!("{name} {result_type}")),
+            None => parts.push(result_type.clone()),
+        }
+    }
+    format!("({})", parts.join(", "))
+}
+
+/// Type of a Go expression where its form gives it away: basic literals,
+/// composite literals, `&T{}`, `make(T, ..)` and `new(T)`. `None` for
+/// anything that would need type checking, such as calls.
+pub fn go_literal_type(expr: &str) -> Option<String> {
+    let expr = expr.trim();
+    let first = expr.chars().next()?;
+    if expr == "true" || expr == "false" {
+        return Some("bool".to_string());
+    }
+    if first == '"' || first == '`' {
+        return (split_top_level_commas(expr).len() == 1).then(|| "string".to_string());
+    }
+    if first == '\'' {
+        return Some("rune".to_string());
+    }
+    if first.is_ascii_digit() {
+        if expr.starts_with("0x") || expr.starts_with("0X") {
+            return Some("int".to_string());
+        }
+        return match expr.chars().all(|c| c.is_ascii_digit() || c == '_') {
+            true => Some("int".to_string()),
+            false
+                if expr.chars().all(|c| {
+                    c.is_ascii_digit() || matches!(c, '_' | '.' | 'e' | 'E' | '-' | '+')
+                }) =>
+            {
+                Some("float64".to_string())
+            }
+            false => None,
+        };
+    }
+    let call_argument = |callee: &str| {
+        expr.strip_prefix(callee)
+            .and_then(|rest| rest.strip_suffix(')'))
+            .and_then(|inner| {
+                split_top_level_commas(inner)
+                    .first()
+                    .map(|arg| arg.to_string())
+            })
+    };
+    if let Some(made) = call_argument("make(") {
+        return Some(made);
+    }
+    if let Some(pointee) = call_argument("new(") {
+        return Some(format!("*{pointee}"));
+    }
+    // `T{...}`, `[]T{...}`, `map[K]V{...}` and `&T{...}`
+    let (pointer, literal) = match expr.strip_prefix('&') {
+        Some(literal) => ("*", literal),
+        None => ("", expr),
+    };
+    let brace = literal.find('{')?;
+    let literal_type = literal[..brace].trim();
+    let simple = literal_type
+        .chars()
+        .all(|c| c.is_alphanumeric() || matches!(c, '_' | '.' | '[' | ']' | '*'));
+    (literal.ends_with('}') && !literal_type.is_empty() && simple)
+        .then(|| format!("{pointer}{literal_type}"))
+}
+
+/// Names and type annotations of a TypeScript parameter list:
+/// `(a: number, b?: string, c = 1)` is `a: number`, `b: string | undefined`
+/// and an unannotated `c`. Destructured parameters are skipped.
+pub fn typescript_parameters(params: &str) -> Vec<(String, Option<String>)> {
+    let inner = params
+        .trim()
+        .strip_prefix('(')
+        .and_then(|rest| rest.strip_suffix(')'))
+        .unwrap_or(params);
+    split_commas(inner, true)
+        .into_iter()
+        .filter_map(|param| {
+            // Drop a default value, but not the `=>` of a function type
+            let bytes = param.as_bytes();
+            let default =
+                (0..bytes.len()).find(|&i| bytes[i] == b'=' && bytes.get(i + 1) != Some(&b'>'));
+            let param = param[..default.unwrap_or(param.len())].trim();
+            let param = param.trim_start_matches("...");
+            let (name, annotation) = match param.split_once(':') {
+                Some((name, annotation)) => (name.trim(), Some(annotation.trim())),
+                None => (param, None),
+            };
+            let (name, optional) = match name.strip_suffix('?') {
+                Some(name) => (name, true),
+                None => (name, false),
+            };
+            if !name
+                .chars()
+                .all(|c| c.is_alphanumeric() || matches!(c, '_' | '$'))
+            {
+                return None;
+            }
+            let annotation = annotation.map(|annotation| match optional {
+                true => format!("{annotation} | undefined"),
+                false => annotation.to_string(),
+            });
+            Some((name.to_string(), annotation))
+        })
+        .collect()
+}
+
+/// How many parameters a function's `parameters` of `language` declare.
+/// Go names sharing a type (`a, b int`) count one each. A Rust or Python
+/// `self` (or `cls`) and a TypeScript `this` do not count, nor do Python's
+/// bare `*` and `/` markers. A list without brackets, such as a lambda's
+/// or a Rust closure's, counts its elements.
+pub fn parameter_count(language: &str, params: &str) -> usize {
+    let params = params.trim();
+    if language == "go" {
+        return go_results(params).len();
+    }
+    let inner = params
+        .strip_prefix('(')
+        .and_then(|rest| rest.strip_suffix(')'))
+        .or_else(|| {
+            params
+                .strip_prefix('|')
+                .and_then(|rest| rest.strip_suffix('|'))
+        })
+        .unwrap_or(params);
+    let receiver = |param: &str| {
+        // `&'a mut self` and `self: Box<Self>` are both `self`
+        let name = param
+            .split(':')
+            .next()
+            .unwrap_or("")
+            .trim_start_matches('&');
+        let name = name.split_whitespace().last().unwrap_or("");
+        match language {
+            "rust" => name == "self",
+            "python" => matches!(name, "self" | "cls"),
+            "typescript" => name == "this",
+            _ => false,
+        }
+    };
+    split_commas(inner, matches!(language, "rust" | "typescript"))
+        .into_iter()
+        .filter(|param| !matches!(*param, "*" | "/") && !receiver(param))
+        .count()
+}
+
+/// How many values a function's `result` of `language` returns: one per
+/// element of a Go result list or of a tuple type (Rust's `(A, B)`,
+/// TypeScript's `[A, B]` or Python's `tuple[A, B]`), and otherwise one.
+/// `()`, `void` and `None` return none; a tuple of any length, such as
+/// `tuple[int, ...]`, is one value.
+pub fn result_count(language: &str, result: &str) -> usize {
+    let result = result
+        .trim()
+        .trim_start_matches(':')
+        .trim_start_matches("->")
+        .trim();
+    let elements = match language {
+        "go" => return go_results(result).len(),
+        "rust" => result
+            .strip_prefix('(')
+            .and_then(|rest| rest.strip_suffix(')')),
+        "typescript" => result
+            .strip_prefix('[')
+            .and_then(|rest| rest.strip_suffix(']')),
+        "python" => ["tuple[", "Tuple[", "typing.Tuple["]
+            .iter()
+            .find_map(|prefix| result.strip_prefix(prefix))
+            .and_then(|rest| rest.strip_suffix(']')),
+        _ => None,
+    };
+    match elements {
+        Some(inner) => {
+            let elements = split_commas(inner, matches!(language, "rust" | "typescript"));
+            match elements.contains(&"...") {
+                true => 1,
+                false => elements.len(),
+            }
+        }
+        None if matches!(result, "" | "void" | "None") => 0,
+        None => 1,
+    }
+}
+
+/// Offsets of each occurrence of `token` in `source` that stands as a
+/// whole token: a keyword such as `return` is not part of a longer word,
+/// and an operator such as `=` is not part of `==`, `:=` or `<=`.
+pub fn token_occurrences(source: &str, token: &str) -> Vec<usize> {
+    let word = |c: char| c.is_alphanumeric() || c == '_';
+    let operator = |c: char| "=<>!&|+-*/%^:.?~".contains(c);
+    let joins = |edge: Option<char>, neighbour: Option<char>| match (edge, neighbour) {
+        (Some(edge), Some(neighbour)) => {
+            (word(edge) && word(neighbour)) || (operator(edge) && operator(neighbour))
+        }
+        _ => false,
+    };
+    if token.is_empty() {
+        return Vec::new();
+    }
+    source
+        .match_indices(token)
+        .map(|(at, _)| at)
+        .filter(|&at| {
+            let before = source[..at].chars().next_back();
+            let after = source[at + token.len()..].chars().next();
+            !joins(token.chars().next(), before) && !joins(token.chars().next_back(), after)
+        })
+        .collect()
+}
+
+/// Net count of open brackets in `text`, ignoring brackets in string
+/// literals: 0 when every bracket opened in `text` is also closed there.
+pub fn bracket_depth(text: &str) -> i32 {
+    mask_string_literals(text)
+        .chars()
+        .map(|c| match c {
+            '(' | '[' | '{' => 1,
+            ')' | ']' | '}' => -1,
+            _ => 0,
+        })
+        .sum()
+}
+
+/// Zero value of a Go type, for filling in the other results of an early
+/// `return`. Named types could be structs or interfaces, so they get the
+/// `*new(T)` form that is valid for either.
+pub fn go_zero_value(go_type: &str) -> String {
+    let go_type = go_type.trim();
+    match go_type {
+        "bool" => "false".to_string(),
+        "string" => "\"\"".to_string(),
+        "int" | "int8" | "int16" | "int32" | "int64" | "uint" | "uint8" | "uint16" | "uint32"
+        | "uint64" | "uintptr" | "byte" | "rune" | "float32" | "float64" | "complex64"
+        | "complex128" => "0".to_string(),
+        "error" | "any" => "nil".to_string(),
+        // Pointers, slices, maps, channels, functions and interfaces
+        _ if [
+            "*",
+            "[]",
+            "<-",
+            "map[",
+            "chan ",
+            "chan<-",
+            "func(",
+            "interface{",
+            "interface {",
+        ]
+        .iter()
+        .any(|prefix| go_type.starts_with(prefix)) =>
+        {
+            "nil".to_string()
+        }
+        // Fixed-size arrays and struct literals
+        _ if go_type.starts_with('[') || go_type.starts_with("struct") => format!("{go_type}{{}}"),
+        _ => format!("*new({go_type})"),
+    }
+}
+
+/// A table-driven Go test of `function`: a struct of cases with a field
+/// for the receiver of a method, one for each parameter and one for each
+/// result, an empty table, and a loop running each case as a subtest. A
+/// last `error` result becomes a `wantErr` flag. Results of types `!=`
+/// cannot compare are checked with `reflect.DeepEqual`; the second value
+/// returned says whether it is used.
+pub fn go_table_test(
+    test_name: &str,
+    function: &str,
+    receiver: Option<&str>,
+    params: &[(Option<String>, String)],
+    results: &[String],
+) -> (String, bool) {
+    let comparable = |go_type: &str| {
+        matches!(
+            go_type,
+            "bool"
+                | "string"
+                | "int"
+                | "int8"
+                | "int16"
+                | "int32"
+                | "int64"
+                | "uint"
+                | "uint8"
+                | "uint16"
+                | "uint32"
+                | "uint64"
+                | "uintptr"
+                | "byte"
+                | "rune"
+                | "float32"
+                | "float64"
+                | "complex64"
+                | "complex128"
+        )
+    };
+    let returns_error = results.last().is_some_and(|last| last == "error");
+    let values = &results[..results.len() - returns_error as usize];
+    let suffix = |i: usize| match i {
+        0 => String::new(),
+        i => i.to_string(),
+    };
+    let mut reserved: Vec<String> = vec!["name".to_string(), "wantErr".to_string()];
+    reserved.extend((0..values.len()).map(|i| format!("want{}", suffix(i))));
+    if receiver.is_some() {
+        reserved.push("receiver".to_string());
+    }
+
+    // Each parameter's field, type, and the argument passed for it
+    let mut fields: Vec<(String, String)> = vec![("name".to_string(), "string".to_string())];
+    if let Some(receiver) = receiver {
+        fields.push(("receiver".to_string(), receiver.to_string()));
+    }
+    let mut arguments = Vec::new();
+    for (i, (name, param_type)) in params.iter().enumerate() {
+        let field = match name.as_deref() {
+            None | Some("_") => format!("arg{i}"),
+            Some(name) if reserved.iter().any(|taken| taken == name) => {
+                format!("arg{}", toggle_export_case(name).unwrap_or_default())
+            }
+            Some(name) => name.to_string(),
+        };
+        match param_type.strip_prefix("...") {
+            Some(element) => {
+                fields.push((field.clone(), format!("[]{element}")));
+                arguments.push(format!("tt.{field}..."));
+            }
+            None => {
+                fields.push((field.clone(), param_type.clone()));
+                arguments.push(format!("tt.{field}"));
+            }
+        }
+    }
+    for (i, value_type) in values.iter().enumerate() {
+        fields.push((format!("want{}", suffix(i)), value_type.clone()));
+    }
+    if returns_error {
+        fields.push(("wantErr".to_string(), "bool".to_string()));
+    }
+
+    let width = fields
+        .iter()
+        .map(|(field, _)| field.len())
+        .max()
+        .unwrap_or(0);
+    let mut test = format!("func {test_name}(t *testing.T) {{\n\ttests := []struct {{\n");
+    for (field, field_type) in &fields {
+        test.push_str(&format!("\t\t{field:width$} {field_type}\n"));
+    }
+    test.push_str("\t}{\n\t\t// TODO: add test cases\n\t}\n");
+    test.push_str("\tfor _, tt := range tests {\n\t\tt.Run(tt.name, func(t *testing.T) {\n");
+
+    let callee = match receiver {
+        Some(_) => format!("tt.receiver.{function}"),
+        None => function.to_string(),
+    };
+    let described = match receiver {
+        Some(receiver) => format!("{}.{function}()", receiver.trim_start_matches('*')),
+        None => format!("{function}()"),
+    };
+    let mut got: Vec<String> = (0..values.len())
+        .map(|i| format!("got{}", suffix(i)))
+        .collect();
+    if returns_error {
+        got.push("err".to_string());
+    }
+    let call = format!("{callee}({})", arguments.join(", "));
+    match got.is_empty() {
+        true => test.push_str(&format!("\t\t\t{call}\n")),
+        false => test.push_str(&format!("\t\t\t{} := {call}\n", got.join(", "))),
+    }
+    if returns_error {
+        test.push_str(&format!(
+            "\t\t\tif (err != nil) != tt.wantErr {{\n\t\t\t\tt.Fatalf(\"{described} error = %v, wantErr %v\", err, tt.wantErr)\n\t\t\t}}\n"
+        ));
+    }
+    let mut deep_equal = false;
+    for (i, value_type) in values.iter().enumerate() {
+        let (got, want) = (format!("got{}", suffix(i)), format!("want{}", suffix(i)));
+        let differs = match comparable(value_type) {
+            true => format!("{got} != tt.{want}"),
+            false => {
+                deep_equal = true;
+                format!("!reflect.DeepEqual({got}, tt.{want})")
+            }
+        };
+        // One result is the call's value; of several, each is named
+        let label = match values.len() {
+            1 => String::new(),
+            _ => format!(" {got}"),
+        };
+        test.push_str(&format!(
+            "\t\t\tif {differs} {{\n\t\t\t\tt.Errorf(\"{described}{label} = %v, want %v\", {got}, tt.{want})\n\t\t\t}}\n"
+        ));
+    }
+    test.push_str("\t\t})\n\t}\n}\n");
+    (test, deep_equal)
+}
+
+/// First offset at or after `offset` that is not whitespace, a `;`, or a
+/// `//` or `/* */` comment.
+pub fn skip_trivia(source: &str, mut offset: usize) -> usize {
+    loop {
+        let rest = &source[offset..];
+        let trimmed = rest.trim_start_matches(|c: char| c.is_whitespace() || c == ';');
+        offset += rest.len() - trimmed.len();
+        if trimmed.starts_with("//") {
+            offset = line_end(source, offset);
+        } else if trimmed.starts_with("/*") {
+            offset = match trimmed.find("*/") {
+                Some(close) => offset + close + 2,
+                None => source.len(),
+            };
+        } else {
+            return offset;
+        }
+    }
+}
+
+/// Whether `line` begins a top-level item: it starts in the first column
+/// and does not close or continue the item above it.
+fn starts_top_level_item(line: &str) -> bool {
+    let first = line.trim_end();
+    !first.is_empty()
+        && !first.starts_with(char::is_whitespace)
+        && !first.starts_with(['}', ')', ']'])
+        && !["else", "elif", "except", "finally"]
+            .iter()
+            .any(|keyword| first.starts_with(keyword))
+}
+
+/// Smallest run of whole top-level items covering `start..end`: from the
+/// first line of the item containing `start` to just before the next item
+/// starting after `end`. Items are judged by indentation alone, so code
+/// nested in one outer block (a Java class, a C# namespace) yields the
+/// whole of that block.
+pub fn top_level_window(source: &str, start: usize, end: usize) -> (usize, usize) {
+    let mut window_start = line_start(source, start);
+    while window_start > 0 && !starts_top_level_item(&source[window_start..]) {
+        window_start = line_start(source, window_start - 1);
+    }
+    let mut window_end = if end > start && end == line_start(source, end) {
+        end
+    } else {
+        line_end(source, end)
+    };
+    while window_end < source.len() && !starts_top_level_item(&source[window_end..]) {
+        window_end = line_end(source, window_end);
+    }
+    (window_start, window_end)
+}
+
+/// How a doc comment is written.
+#[derive(Debug, Clone, Copy, PartialEq)]
+pub enum CommentStyle {
+    /// One prefixed line per line of text, e.g. `///` or `#`
+    Line(&'static str),
+    /// A `/** ... */` block with ` * ` continuation lines
+    Block,
+}
+
+/// Whether a line holds an attribute, decorator, or annotation that sits
+/// between a declaration and its doc comment.
+fn is_attribute_line(line: &str) -> bool {
+    let line = line.trim();
+    line.starts_with("#[")
+        || line.starts_with('@')
+        || (line.starts_with('[') && line.ends_with(']'))
+}
+
+/// Start of the first line of the declaration at `decl_start`, including any
+/// attribute lines directly above it.
+pub fn declaration_line_start(source: &str, decl_start: usize) -> usize {
+    let mut start = line_start(source, decl_start);
+    while start > 0 {
+        let previous = line_start(source, start - 1);
+        if !is_attribute_line(&source[previous..start]) {
+            break;
+        }
+        start = previous;
+    }
+    start
+}
+
+/// Whole-line byte range of the doc comment above the declaration at
+/// `decl_start`: contiguous `line_prefix` lines or a `/** */` block, skipping
+/// attribute lines and at most one blank line in between.
+pub fn leading_comment_range(
+    source: &str,
+    decl_start: usize,
+    line_prefix: &str,
+) -> Option<(usize, usize)> {
+    let mut end = declaration_line_start(source, decl_start);
+    if end == 0 {
+        return None;
+    }
+    let previous = line_start(source, end - 1);
+    if source[previous..end].trim().is_empty() && previous > 0 {
+        end = previous;
+    }
+
+    let mut start = end;
+    let last = line_start(source, end.checked_sub(1)?);
+    if source[last..end].trim().ends_with("*/") {
+        // Walk up to the line opening the block
+        start = last;
+        while !source[start..end].trim_start().starts_with("/*") {
+            if start == 0 {
+                return None;
+            }
+            start = line_start(source, start - 1);
+        }
+        return source[start..end]
+            .trim_start()
+            .starts_with("/**")
+            .then_some((start, end));
+    }
+    while start > 0 {
+        let previous = line_start(source, start - 1);
+        if !source[previous..start]
+            .trim_start()
+            .starts_with(line_prefix)
+        {
+            break;
+        }
+        start = previous;
+    }
+    (start < end).then_some((start, end))
+}
+
+/// Whole-line byte range that removes the declaration spanning
+/// `start..end` together with the comment right above it and the blank
+/// lines that separate it from the next declaration, or from the previous
+/// one when nothing follows it.
+pub fn declaration_removal_range(
+    source: &str,
+    start: usize,
+    end: usize,
+    line_prefix: &str,
+) -> (usize, usize) {
+    let line = declaration_line_start(source, start);
+    let mut start = leading_comment_range(source, start, line_prefix)
+        .filter(|(_, end)| *end == line)
+        .map_or(line, |(start, _)| start);
+    let mut end = line_end(source, end);
+    let mut next = end;
+    while next < source.len() && source[next..line_end(source, next)].trim().is_empty() {
+        next = line_end(source, next);
+    }
+    if next < source.len() {
+        end = next;
+    } else {
+        end = source.len();
+        while start > 0
+            && source[line_start(source, start - 1)..start]
+                .trim()
+                .is_empty()
+        {
+            start = line_start(source, start - 1);
+        }
+    }
+    (start, end)
+}
+
+/// Number of blank lines between a comment ending at `comment_end` and a
+/// node starting at `node_start`, or `None` if anything but whitespace
+/// separates them. A comment that includes its own newline still counts as
+/// ending on its last line.
+pub fn blank_lines_between(source: &str, comment_end: usize, node_start: usize) -> Option<usize> {
+    if comment_end > node_start || !source[comment_end..node_start].trim().is_empty() {
+        return None;
+    }
+    let last_line = line_number(
+        source,
+        source[..comment_end].trim_end_matches(['\r', '\n']).len(),
+    );
+    Some(line_number(source, node_start).saturating_sub(last_line + 1))
+}
+
+/// The comment style an existing comment is written in, keeping its exact
+/// line prefix (e.g. `///` rather than `//`).
+pub fn detect_comment_style(comment: &str, fallback: CommentStyle) -> CommentStyle {
+    let first = comment.trim_start();
+    if first.starts_with("/*") {
+        return CommentStyle::Block;
+    }
+    for prefix in ["///", "//!", "//", "#", "--"] {
+        if first.starts_with(prefix) {
+            return CommentStyle::Line(prefix);
+        }
+    }
+    fallback
+}
+
+/// Render `text` as a comment in `style`, indented by `indent`, ending with
+/// a newline.
+pub fn render_comment(text: &str, style: CommentStyle, indent: &str) -> String {
+    let lines: Vec<&str> = text.trim_end().lines().collect();
+    let mut rendered = String::new();
+    match style {
+        CommentStyle::Line(prefix) => {
+            for line in lines {
+                if line.trim().is_empty() {
+                    rendered.push_str(&format!("{indent}{prefix}\n"));
+                } else {
+                    rendered.push_str(&format!("{indent}{prefix} {}\n", line.trim_end()));
+                }
+            }
+        }
+        CommentStyle::Block => {
+            rendered.push_str(&format!("{indent}/**\n"));
+            for line in lines {
+                if line.trim().is_empty() {
+                    rendered.push_str(&format!("{indent} *\n"));
+                } else {
+                    rendered.push_str(&format!("{indent} * {}\n", line.trim_end()));
+                }
+            }
+            rendered.push_str(&format!("{indent} */\n"));
+        }
+    }
+    rendered
+}
+
+/// The file's indentation step: a tab if lines are tab-indented, otherwise
+/// the smallest run of leading spaces (four when nothing is indented).
+pub fn indent_unit(source: &str) -> String {
+    let mut smallest: Option<usize> = None;
+    for line in source.lines() {
+        let trimmed = line.trim_start();
+        // Block comment continuation lines are offset by a single space
+        if trimmed.is_empty() || trimmed.starts_with('*') {
+            continue;
+        }
+        if line.starts_with('\t') {
+            return "\t".to_string();
+        }
+        let spaces = line.len() - line.trim_start_matches(' ').len();
+        if spaces > 0 {
+            smallest = Some(smallest.map_or(spaces, |smallest| smallest.min(spaces)));
+        }
+    }
+    " ".repeat(smallest.unwrap_or(4))
+}
+
+/// Shift `text` so its least-indented line starts at `indent`, keeping the
+/// relative indentation of the rest. Blank lines are left empty.
+pub fn reindent(text: &str, indent: &str) -> String {
+    let common = text
+        .lines()
+        .filter(|line| !line.trim().is_empty())
+        .map(|line| line.len() - line.trim_start_matches([' ', '\t']).len())
+        .min()
+        .unwrap_or(0);
+    text.lines()
+        .map(|line| {
+            if line.trim().is_empty() {
+                String::new()
+            } else {
+                format!("{indent}{}", line[common..].trim_end())
+            }
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// Whole `lines` of a statement with `before` and `after` placed around
+/// them at `indent`, each template line keeping its relative indentation.
+/// With `nested` the statement's non-blank lines are shifted by that step,
+/// for templates that open and close a block. An empty template adds no
+/// line.
+pub fn wrap_lines(
+    lines: &str,
+    indent: &str,
+    before: &str,
+    after: &str,
+    nested: Option<&str>,
+) -> String {
+    let (body, newline) = match lines.strip_suffix('\n') {
+        Some(body) => (body, "\n"),
+        None => (lines, ""),
+    };
+    let mut wrapped = Vec::new();
+    if !before.is_empty() {
+        wrapped.push(reindent(before, indent));
+    }
+    wrapped.extend(body.lines().map(|line| match nested {
+        Some(step) if !line.trim().is_empty() => format!("{step}{line}"),
+        _ => line.to_string(),
+    }));
+    if !after.is_empty() {
+        wrapped.push(reindent(after, indent));
+    }
+    wrapped.join("\n") + newline
+}
+
+/// Edit adding `member` as the last member of a type. `decl` spans the
+/// type's declaration or just its body; either way the body is the brace
+/// block ending at its last `}`. The member is indented like the existing
+/// members, or one step deeper than the line holding `{` when the body is
+/// empty, and separated from them by a blank line. `None` if there is no
+/// brace-delimited body.
+pub fn member_insertion(source: &str, decl: &NodeSpan, member: &str) -> Option<TextEdit> {
+    let text = &source[decl.start..decl.end];
+    let open = decl.start + text.find('{')?;
+    let close = decl.start + text.rfind('}')?;
+    if open >= close {
+        return None;
+    }
+    let body = &source[open + 1..close];
+    let decl_indent = indentation_at(source, open);
+
+    // The first line after the one holding `{` that has any content
+    let member_indent = body
+        .lines()
+        .skip(1)
+        .find(|line| !line.trim().is_empty())
+        .map(|line| line[..line.len() - line.trim_start_matches([' ', '\t']).len()].to_string())
+        .unwrap_or_else(|| format!("{decl_indent}{}", indent_unit(source)));
+    let rendered = reindent(member.trim_matches('\n'), &member_indent);
+    let has_members = !body.trim().is_empty();
+
+    let close_line = line_start(source, close);
+    if close_line > open && source[close_line..close].trim().is_empty() {
+        // `}` on its own line: insert just above it
+        let separator = if has_members && !source[..close_line].ends_with("\n\n") {
+            "\n"
+        } else {
+            ""
+        };
+        Some(TextEdit {
+            start: close_line,
+            end: close_line,
+            replacement: format!("{separator}{rendered}\n"),
+        })
+    } else {
+        // `{}` or `{ ... }` on one line: move `}` onto a line of its own
+        let separator = if has_members { "\n" } else { "" };
+        Some(TextEdit {
+            start: open + 1 + body.trim_end().len(),
+            end: close,
+            replacement: format!("\n{separator}{rendered}\n{decl_indent}"),
+        })
+    }
+}
+
+/// Start of the line after a file's header, the leading lines that belong
+/// to the file rather than to its code: a `#!` line, a Python module
+/// `docstring`, and runs of `comments` that a blank line sets apart from
+/// what follows. A comment directly above code documents that code, and
+/// ends the header. 0 when the file has no header.
+pub fn file_header_end(
+    source: &str,
+    comments: &[(usize, usize)],
+    docstring: Option<(usize, usize)>,
+) -> usize {
+    let mut header_end = 0;
+    let mut offset = 0;
+    loop {
+        let rest = &source[offset..];
+        let next = offset + rest.len() - rest.trim_start().len();
+        let (end, always) = if next == 0 && source.starts_with("#!") {
+            (line_end(source, 0), true)
+        } else if let Some((_, end)) = docstring.filter(|(start, _)| *start == next) {
+            (end, true)
+        } else if let Some(&(_, end)) = comments.iter().find(|(start, _)| *start == next) {
+            (end, false)
+        } else {
+            return header_end;
+        };
+        offset = line_end(source, end.saturating_sub(1).max(next));
+        let blank_line = source[offset..]
+            .lines()
+            .next()
+            .map_or(true, |line| line.trim().is_empty());
+        if always || blank_line {
+            header_end = offset;
+        }
+    }
+}
+
+/// Edit adding the top-level `block` at `at`, the start of a line or the
+/// end of the file, set apart by a blank line from any code before and
+/// after it.
+pub fn block_insertion(source: &str, at: usize, block: &str) -> TextEdit {
+    let (before, after) = source.split_at(at);
+    let leading = if before.trim().is_empty() || before.ends_with("\n\n") {
+        ""
+    } else if before.ends_with('\n') {
+        "\n"
+    } else {
+        "\n\n"
+    };
+    let blank_after = after
+        .lines()
+        .next()
+        .map_or(true, |line| line.trim().is_empty());
+    let trailing = match blank_after {
+        true => "\n",
+        false => "\n\n",
+    };
+    TextEdit {
+        start: at,
+        end: at,
+        replacement: format!(
+            "{leading}{}{trailing}",
+            block.trim_start_matches('\n').trim_end()
+        ),
+    }
+}
+
+/// Placement of a block's opening brace.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum BraceStyle {
+    /// K&R / 1TBS: `if (x) {` and `} else {`
+    SameLine,
+    /// Allman: the `{` and `else` each start their own line
+    NextLine,
+}
+
+/// Whether the text before a trailing `{` looks like a block header rather
+/// than, say, an object literal.
+fn is_block_header(head: &str) -> bool {
+    let head = head.trim();
+    head.ends_with(')')
+        || matches!(head, "else" | "try" | "do" | "finally" | "}" | "} else")
+        || [
+            "class ",
+            "struct ",
+            "interface ",
+            "enum ",
+            "namespace ",
+            "impl ",
+        ]
+        .iter()
+        .any(|keyword| head.starts_with(keyword) || head.contains(&format!(" {keyword}")))
+}
+
+/// Detect the dominant brace style of `source`, or `None` if it has no blocks.
+pub fn detect_brace_style(source: &str) -> Option<BraceStyle> {
+    let mut same_line = 0;
+    let mut next_line = 0;
+    for line in source.lines() {
+        let trimmed = line.trim();
+        if trimmed == "{" {
+            next_line += 1;
+        } else if let Some(head) = trimmed.strip_suffix('{') {
+            if is_block_header(head) {
+                same_line += 1;
+            }
+        }
+    }
+    match (same_line, next_line) {
+        (0, 0) => None,
+        (same, next) if next > same => Some(BraceStyle::NextLine),
+        _ => Some(BraceStyle::SameLine),
+    }
+}
+
+/// Rewrite block braces and `else` placement in `text` to follow `style`.
+/// `base_indent` is the indentation of the line the text is inserted on, used
+/// for its first line which carries no indentation of its own.
+pub fn restyle_braces(text: &str, style: BraceStyle, base_indent: &str) -> String {
+    let mut lines: Vec<String> = Vec::new();
+    for (index, line) in text.split('\n').enumerate() {
+        let indent = if index == 0 {
+            base_indent.to_string()
+        } else {
+            line[..line.len() - line.trim_start().len()].to_string()
+        };
+        let trimmed = line.trim();
+
+        match style {
+            BraceStyle::NextLine => {
+                let Some(head) = trimmed.strip_suffix('{') else {
+                    lines.push(line.to_string());
+                    continue;
+                };
+                if head.trim().is_empty() || !is_block_header(head) {
+                    lines.push(line.to_string());
+                    continue;
+                }
+                let head = head.trim_end();
+                let prefix = if index == 0 { "" } else { indent.as_str() };
+                match head.strip_prefix('}') {
+                    Some(rest) if !rest.trim().is_empty() => {
+                        lines.push(format!("{prefix}}}"));
+                        lines.push(format!("{indent}{}", rest.trim()));
+                    }
+                    _ => lines.push(format!("{prefix}{head}")),
+                }
+                lines.push(format!("{indent}{{"));
+            }
+            BraceStyle::SameLine => {
+                let previous = lines.last().map(|l| l.trim().to_string());
+                match previous {
+                    Some(prev) if trimmed == "{" && !prev.is_empty() && is_block_header(&prev) => {
+                        lines.last_mut().unwrap().push_str(" {");
+                    }
+                    Some(prev) if prev == "}" && trimmed.starts_with("else") => {
+                        let last = lines.last_mut().unwrap();
+                        last.push(' ');
+                        last.push_str(trimmed);
+                    }
+                    _ => lines.push(line.to_string()),
+                }
+            }
+        }
+    }
+    lines.join("\n")
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn spans(source: &str, needle: &str) -> Vec<NodeSpan> {
+        source
+            .match_indices(needle)
+            .map(|(start, _)| {
+                let end = start + source[start..].find(';').unwrap() + 1;
+                NodeSpan {
+                    start,
+                    end,
+                    text: source[start..end].to_string(),
+                }
+            })
+            .collect()
+    }
+
+    #[test]
+    fn test_requote_string() {
+        assert_eq!(
+            requote_string(r#"'it\'s'"#, '"'),
+            Ok(Some(r#""it's""#.to_string()))
+        );
+        assert_eq!(
+            requote_string(r#""a\tb""#, '\''),
+            Ok(Some(r#"'a\tb'"#.to_string()))
+        );
+        assert_eq!(requote_string("'done'", '\''), Ok(None));
+        assert_eq!(requote_string(r#"'say "hi"'"#, '"'), Err("contains_quote"));
+        assert_eq!(
+            requote_string("'''doc'''", '"'),
+            Ok(Some(r#""""doc""""#.to_string()))
+        );
+        assert_eq!(
+            requote_string(r"rb'\d\''", '"'),
+            Ok(Some(r#"rb"\d\'""#.to_string()))
+        );
+        assert_eq!(requote_string("''", '"'), Ok(Some(r#""""#.to_string())));
+    }
+
+    #[test]
+    fn test_wrap_lines() {
+        assert_eq!(
+            wrap_lines("\tx := f()\n", "\t", "trace(3)", "", None),
+            "\ttrace(3)\n\tx := f()\n"
+        );
+        assert_eq!(
+            wrap_lines(
+                "    save(a,\n        b);\n",
+                "    ",
+                "try {",
+                "} catch (e) {\n  report(e);\n}",
+                Some("  ")
+            ),
+            "    try {\n      save(a,\n          b);\n    } catch (e) {\n      report(e);\n    }\n"
+        );
+    }
+
+    #[test]
+    fn test_insert_sorted_line_between_existing() {
+        let source = "using System;\nusing System.Text;\n\nnamespace App {}\n";
+        let existing = spans(source, "using ");
+        let (result, line) = insert_sorted_line(source, &existing, "using System.Linq;").unwrap();
+        assert_eq!(
+            result,
+            "using System;\nusing System.Linq;\nusing System.Text;\n\nnamespace App {}\n"
+        );
+        assert_eq!(line, 2);
+    }
+
+    #[test]
+    fn test_insert_sorted_line_after_last() {
+        let source = "using System;\n\nclass A {}\n";
+        let existing = spans(source, "using ");
+        let (result, line) = insert_sorted_line(source, &existing, "using Xunit;").unwrap();
+        assert_eq!(result, "using System;\nusing Xunit;\n\nclass A {}\n");
+        assert_eq!(line, 2);
+    }
+
+    #[test]
+    fn test_insert_sorted_line_keeps_indentation() {
+        let source = "namespace App\n{\n    using System;\n    class A {}\n}\n";
+        let existing = spans(source, "using ");
+        let (result, _) = insert_sorted_line(source, &existing, "using Azure;").unwrap();
+        assert!(result.contains("    using Azure;\n    using System;\n"));
+    }
+
+    #[test]
+    fn test_insert_sorted_line_without_existing() {
+        let (result, line) = insert_sorted_line("class A {}\n", &[], "using System;").unwrap();
+        assert_eq!(result, "using System;\n\nclass A {}\n");
+        assert_eq!(line, 1);
+    }
+
+    #[test]
+    fn test_insert_sorted_line_already_present() {
+        let source = "using System;\n";
+        let existing = spans(source, "using ");
+        assert!(insert_sorted_line(source, &existing, "using System;").is_none());
+    }
+
+    #[test]
+    fn test_apply_edits_uses_original_offsets() {
+        let edits = vec![
+            TextEdit {
+                start: 4,
+                end: 5,
+                replacement: "bb".to_string(),
+            },
+            TextEdit {
+                start: 0,
+                end: 1,
+                replacement: "aaa".to_string(),
+            },
+        ];
+        assert_eq!(apply_edits("a + b + c", &edits).unwrap(), "aaa + bb + c");
+    }
+
+    #[test]
+    fn test_apply_edits_rejects_overlap() {
+        let edits = vec![
+            TextEdit {
+                start: 0,
+                end: 4,
+                replacement: String::new(),
+            },
+            TextEdit {
+                start: 2,
+                end: 6,
+                replacement: String::new(),
+            },
+        ];
+        assert!(apply_edits("abcdefgh", &edits).is_err());
+    }
+
+    #[test]
+    fn test_edits_take_crlf_line_endings() {
+        let source = "a\r\nb\r\n";
+        let edits = [TextEdit {
+            start: 3,
+            end: 3,
+            replacement: "x\ny\r\n".to_string(),
+        }];
+        assert!(uses_crlf(source));
+        assert_eq!(apply_edits(source, &edits).unwrap(), "a\r\nx\r\ny\r\nb\r\n");
+        assert!(!uses_crlf("a\nb\r\n"));
+        // LF files keep replacements as written
+        assert_eq!(apply_edits("ab\nc\n", &edits).unwrap(), "ab\nx\ny\r\nc\n");
+    }
+
+    #[test]
+    fn test_truncate_text_at_line_boundary() {
+        let marker = |omitted: usize| format!("/* ...{omitted} bytes omitted... */");
+        let text = "fn a() {\n    one();\n    two();\n}";
+        let (truncated, omitted) = truncate_text(text, 24, marker).unwrap();
+        assert_eq!(
+            truncated,
+            "fn a() {\n    one();\n/* ...12 bytes omitted... */"
+        );
+        assert_eq!(omitted, 12);
+        // A single long line is cut mid-line, on a character boundary
+        let (truncated, _) = truncate_text("let s = \"😀😀\";", 10, marker).unwrap();
+        assert_eq!(truncated, "let s = \" /* ...10 bytes omitted... */");
+        assert!(truncate_text(text, text.len(), marker).is_none());
+    }
+
+    #[test]
+    fn test_detect_brace_style() {
+        let allman = "void F()\n{\n    if (x)\n    {\n    }\n}\n";
+        let knr = "void F() {\n    if (x) {\n    }\n}\n";
+        assert_eq!(detect_brace_style(allman), Some(BraceStyle::NextLine));
+        assert_eq!(detect_brace_style(knr), Some(BraceStyle::SameLine));
+        assert_eq!(detect_brace_style("x = 1;\n"), None);
+        // Object literals do not count as blocks
+        assert_eq!(detect_brace_style("const a = {\n};\n"), None);
+    }
+
+    #[test]
+    fn test_restyle_braces_to_next_line() {
+        let text = "if (x) {\n        a();\n    } else {\n        b();\n    }";
+        assert_eq!(
+            restyle_braces(text, BraceStyle::NextLine, "    "),
+            "if (x)\n    {\n        a();\n    }\n    else\n    {\n        b();\n    }"
+        );
+    }
+
+    #[test]
+    fn test_restyle_braces_to_same_line() {
+        let text = "if (x)\n    {\n        a();\n    }\n    else\n    {\n        b();\n    }";
+        assert_eq!(
+            restyle_braces(text, BraceStyle::SameLine, "    "),
+            "if (x) {\n        a();\n    } else {\n        b();\n    }"
+        );
+    }
+
+    #[test]
+    fn test_offset_from_position() {
+        let source = "fn a() {}\nlet é = 1;\n";
+        assert_eq!(offset_from_position(source, 1, 1), Some(0));
+        assert_eq!(offset_from_position(source, 2, 1), Some(10));
+        // Columns count characters, not bytes
+        assert_eq!(offset_from_position(source, 2, 6), Some(16));
+        assert_eq!(offset_from_position(source, 2, 100), Some(21));
+        assert_eq!(offset_from_position(source, 5, 1), None);
+    }
+
+    #[test]
+    fn test_guess_function_name() {
+        assert_eq!(
+            guess_function_name("fn divide(a: f64) {}"),
+            Some("divide".to_string())
+        );
+        assert_eq!(
+            guess_function_name("func (s *Server) Start(ctx context.Context) error {"),
+            Some("Start".to_string())
+        );
+        assert_eq!(
+            guess_function_name("int add(int a, int b) { return a + b; }"),
+            Some("add".to_string())
+        );
+        assert_eq!(guess_function_name("function () { return 1; }"), None);
+        assert_eq!(guess_function_name("(a, b) => a + b"), None);
+    }
+
+    #[test]
+    fn test_needs_parentheses() {
+        assert!(!needs_parentheses("count"));
+        assert!(!needs_parentheses("user.name"));
+        assert!(!needs_parentheses("compute(a, b)"));
+        assert!(!needs_parentheses("\"a + b\""));
+        assert!(!needs_parentheses("(a + b)"));
+        assert!(needs_parentheses("a + b"));
+        assert!(needs_parentheses("(a) + (b)"));
+        assert!(needs_parentheses("-x"));
+    }
+
+    #[test]
+    fn test_is_trivially_pure() {
+        assert!(is_trivially_pure("a * b + 1"));
+        assert!(is_trivially_pure("x == \"(\""));
+        assert!(is_trivially_pure("items[0]"));
+        assert!(!is_trivially_pure("load()"));
+        assert!(!is_trivially_pure("new Date"));
+        assert!(!is_trivially_pure("i++"));
+        assert!(!is_trivially_pure("a = b"));
+    }
+
+    #[test]
+    fn test_is_write_access() {
+        let source = "x = 1; y == x; x += 2; x++; f(x); z => x";
+        let at = |needle: &str| {
+            let start = source.find(needle).unwrap();
+            NodeSpan {
+                start,
+                end: start + 1,
+                text: "x".to_string(),
+            }
+        };
+        assert!(is_write_access(source, &at("x = 1")));
+        assert!(!is_write_access(source, &at("x;")));
+        assert!(is_write_access(source, &at("x +=")));
+        assert!(is_write_access(source, &at("x++")));
+        assert!(!is_write_access(source, &at("x)")));
+    }
+
+    #[test]
+    fn test_statement_removal_range() {
+        let source = "fn f() {\n    let x = 1;\n    g(x);\n}\n";
+        let start = source.find("let").unwrap();
+        let span = NodeSpan {
+            start,
+            end: start + "let x = 1;".len(),
+            text: "let x = 1;".to_string(),
+        };
+        assert_eq!(
+            statement_removal_range(source, &span),
+            (9, 9 + "    let x = 1;\n".len())
+        );
+    }
+
+    #[test]
+    fn test_return_value_range() {
+        fn value(source: &str) -> Option<&str> {
+            let span = NodeSpan {
+                start: 0,
+                end: source.len(),
+                text: source.to_string(),
+            };
+            return_value_range(source, &span).map(|(start, end)| &source[start..end])
+        }
+        assert_eq!(value("return a + b;"), Some("a + b"));
+        assert_eq!(value("return user, nil"), Some("user, nil"));
+        assert_eq!(value("return\n    (x)"), Some("(x)"));
+        assert_eq!(value("return;"), None);
+        assert_eq!(value("return"), None);
+        assert_eq!(value("returned"), None);
+    }
+
+    #[test]
+    fn test_blank_lines_between() {
+        let source = "// BEGIN generated\n\n  \nfn a() {}\n";
+        let node = source.find("fn").unwrap();
+        assert_eq!(blank_lines_between(source, 18, node), Some(2));
+        // Comment nodes that include their newline end on the same line
+        assert_eq!(blank_lines_between(source, 19, node), Some(2));
+        assert_eq!(blank_lines_between("// x\nfn a() {}", 4, 5), Some(0));
+        assert_eq!(blank_lines_between("// x\nlet b;\nfn a() {}", 4, 12), None);
+    }
+
+    #[test]
+    fn test_receiver_type() {
+        assert_eq!(receiver_type("(s *Server)"), "Server");
+        assert_eq!(receiver_type("(Point)"), "Point");
+        assert_eq!(receiver_type("(m *Map[K, V])"), "Map[K, V]");
+        assert_eq!(receiver_type("(Map[K, V])"), "Map[K, V]");
+    }
+
+    #[test]
+    fn test_toggle_export_case() {
+        assert_eq!(toggle_export_case("parseURL").as_deref(), Some("ParseURL"));
+        assert_eq!(toggle_export_case("Server").as_deref(), Some("server"));
+        assert_eq!(
+            toggle_export_case("URLParser").as_deref(),
+            Some("urlParser")
+        );
+        assert_eq!(
+            toggle_export_case("HTTP2Client").as_deref(),
+            Some("http2Client")
+        );
+        assert_eq!(toggle_export_case("ID").as_deref(), Some("id"));
+        assert_eq!(toggle_export_case("_cache"), None);
+    }
+
+    #[test]
+    fn test_go_result_types_and_zero_values() {
+        assert_eq!(go_result_types("error"), ["error"]);
+        assert_eq!(go_result_types("(int, error)"), ["int", "error"]);
+        assert_eq!(
+            go_result_types("(a, b int, err error)"),
+            ["int", "int", "error"]
+        );
+        assert_eq!(
+            go_result_types("(map[string]int, chan int, error)"),
+            ["map[string]int", "chan int", "error"]
+        );
+        let zeros: Vec<String> = [
+            "*Config",
+            "[]byte",
+            "[4]int",
+            "string",
+            "Point",
+            "func() error",
+        ]
+        .iter()
+        .map(|go_type| go_zero_value(go_type))
+        .collect();
+        assert_eq!(
+            zeros,
+            ["nil", "nil", "[4]int{}", "\"\"", "*new(Point)", "nil"]
+        );
+    }
+
+    #[test]
+    fn test_go_results_round_trip() {
+        let results = go_results("(sum, count int, average float64)");
+        assert_eq!(
+            results,
+            vec![
+                (Some("sum".to_string()), "int".to_string()),
+                (Some("count".to_string()), "int".to_string()),
+                (Some("average".to_string()), "float64".to_string()),
+            ]
+        );
+        assert_eq!(
+            format_go_results(&results),
+            "(sum, count int, average float64)"
+        );
+        let unnamed: Vec<(Option<String>, String)> = results
+            .into_iter()
+            .map(|(_, result_type)| (None, result_type))
+            .collect();
+        assert_eq!(format_go_results(&unnamed), "(int, int, float64)");
+        assert_eq!(format_go_results(&[(None, "error".to_string())]), "error");
+    }
+
+    #[test]
+    fn test_parameter_and_result_counts() {
+        assert_eq!(
+            parameter_count("go", "(ctx context.Context, a, b int, opts ...Option)"),
+            4
+        );
+        assert_eq!(parameter_count("go", "()"), 0);
+        assert_eq!(
+            parameter_count("rust", "(&'a mut self, key: &str, map: HashMap<K, V>)"),
+            2
+        );
+        assert_eq!(parameter_count("rust", "|a, b|"), 2);
+        assert_eq!(parameter_count("python", "(self, a, *, b=1, **kwargs)"), 3);
+        assert_eq!(
+            parameter_count("typescript", "(this: Window, a: Map<string, number>)"),
+            1
+        );
+        assert_eq!(parameter_count("javascript", "x"), 1);
+
+        assert_eq!(
+            result_count("go", "(value []byte, ok, found bool, err error)"),
+            4
+        );
+        assert_eq!(result_count("go", "error"), 1);
+        assert_eq!(result_count("rust", "(Vec<(u8, u8)>, usize)"), 2);
+        assert_eq!(result_count("rust", "()"), 0);
+        assert_eq!(result_count("typescript", ": [string, number]"), 2);
+        assert_eq!(result_count("typescript", ": void"), 0);
+        assert_eq!(result_count("python", "tuple[int, str, bytes]"), 3);
+        assert_eq!(result_count("python", "tuple[int, ...]"), 1);
+        assert_eq!(result_count("python", "None"), 0);
+    }
+
+    #[test]
+    fn test_token_occurrences_are_whole_tokens() {
+        let source = "if a == b { a = b; x := a <= b; returned = a; return a }";
+        assert_eq!(token_occurrences(source, "="), [14, 41]);
+        assert_eq!(token_occurrences(source, ":="), [21]);
+        assert_eq!(token_occurrences(source, "return"), [46]);
+    }
+
+    #[test]
+    fn test_parameter_types_from_declarations() {
+        assert_eq!(go_literal_type("0").as_deref(), Some("int"));
+        assert_eq!(go_literal_type("1.5").as_deref(), Some("float64"));
+        assert_eq!(go_literal_type("\"a\"").as_deref(), Some("string"));
+        assert_eq!(
+            go_literal_type("make(map[string]int, 8)").as_deref(),
+            Some("map[string]int")
+        );
+        assert_eq!(
+            go_literal_type("&Config{Name: \"x\"}").as_deref(),
+            Some("*Config")
+        );
+        assert_eq!(go_literal_type("[]int{1, 2}").as_deref(), Some("[]int"));
+        assert_eq!(go_literal_type("load(path)"), None);
+
+        assert_eq!(
+            typescript_parameters("(a: number, b?: Map<string, number>, c = 1)"),
+            [
+                ("a".to_string(), Some("number".to_string())),
+                (
+                    "b".to_string(),
+                    Some("Map<string, number> | undefined".to_string())
+                ),
+                ("c".to_string(), None),
+            ]
+        );
+        assert_eq!(bracket_depth("if (x) { a = \"{\";"), 1);
+        assert_eq!(bracket_depth("f(a[0]);"), 0);
+    }
+
+    #[test]
+    fn test_skip_trivia() {
+        let source = "a := 1; // note\n\t/* more */\n\tb()";
+        assert_eq!(skip_trivia(source, 6), source.find("b()").unwrap());
+    }
+
+    /// `source` after deleting element `index` of its outermost list.
+    fn delete_list_element(source: &str, index: usize) -> String {
+        let inner = (source.find('(').unwrap() + 1, source.rfind(')').unwrap());
+        let (elements, _) = list_elements(source, inner.0, inner.1, "go");
+        apply_edits(source, &[list_delete_edit(&elements, inner, index)]).unwrap()
+    }
+
+    #[test]
+    fn test_list_elements() {
+        let source = "f(a, g(b, c), \"d, e\" /* f, g */, h)";
+        let (elements, trailing_comma) = list_elements(source, 2, source.len() - 1, "go");
+        let texts: Vec<&str> = elements.iter().map(|&(s, e)| &source[s..e]).collect();
+        assert_eq!(texts, ["a", "g(b, c)", "\"d, e\" /* f, g */", "h"]);
+        assert!(!trailing_comma);
+
+        let source = "fn f<'a>(x: &'a str, y: Map<K, V>,\n) {}";
+        let inner = (source.find("(x").unwrap() + 1, source.find(") {").unwrap());
+        let (elements, trailing_comma) = list_elements(source, inner.0, inner.1, "rust");
+        assert_eq!(elements.len(), 2);
+        assert_eq!(&source[elements[1].0..elements[1].1], "y: Map<K, V>");
+        assert!(trailing_comma);
+    }
+
+    #[test]
+    fn test_list_delete_edit() {
+        assert_eq!(delete_list_element("f(a, b, c)", 1), "f(a, c)");
+        assert_eq!(delete_list_element("f(a, b, c)", 0), "f(b, c)");
+        assert_eq!(delete_list_element("f(a, b, c)", 2), "f(a, b)");
+        assert_eq!(delete_list_element("f(a)", 0), "f()");
+        assert_eq!(
+            delete_list_element("f(\n\ta,\n\tb,\n\tc,\n)", 2),
+            "f(\n\ta,\n\tb,\n)"
+        );
+        assert_eq!(
+            delete_list_element("f(\n\ta,\n\tb,\n\tc,\n)", 0),
+            "f(\n\tb,\n\tc,\n)"
+        );
+        assert_eq!(delete_list_element("f(\n\ta,\n)", 0), "f()");
+    }
+
+    #[test]
+    fn test_list_insert_edit() {
+        let insert = |source: &str, index: usize| {
+            let inner = (source.find('(').unwrap() + 1, source.rfind(')').unwrap());
+            let (elements, _) = list_elements(source, inner.0, inner.1, "go");
+            let edit = list_insert_edit(source, &elements, inner, index, "x");
+            apply_edits(source, &[edit]).unwrap()
+        };
+        assert_eq!(insert("f(a, b)", 0), "f(x, a, b)");
+        assert_eq!(insert("f(a, b)", 2), "f(a, b, x)");
+        assert_eq!(insert("f()", 0), "f(x)");
+        assert_eq!(insert("f(a)", 1), "f(a, x)");
+        assert_eq!(insert("f(\n\ta,\n)", 1), "f(\n\ta,\n\tx,\n)");
+        assert_eq!(insert("f(\n\ta\n)", 0), "f(\n\tx,\n\ta\n)");
+        assert_eq!(insert("f(\n\ta,\n\tb,\n)", 2), "f(\n\ta,\n\tb,\n\tx,\n)");
+    }
+
+    #[test]
+    fn test_edited_ranges() {
+        let source = "a := 1\r\nb := 2\r\n";
+        let edits = [
+            TextEdit {
+                start: 13,
+                end: 14,
+                replacement: "3".to_string(),
+            },
+            TextEdit {
+                start: 5,
+                end: 6,
+                replacement: "f(\n)".to_string(),
+            },
+        ];
+        let result = apply_edits(source, &edits).unwrap();
+        let ranges = edited_ranges(source, &edits);
+        assert_eq!(&result[ranges[0].0..ranges[0].1], "f(\r\n)");
+        assert_eq!(&result[ranges[1].0..ranges[1].1], "3");
+    }
+
+    #[test]
+    fn test_format_regions() {
+        let source = "func f() {\n\tx := g(1,2) // note\n\ty := 2\n\treturn x+y\n}\n";
+        let span = |text: &str| {
+            let start = source.find(text).unwrap();
+            NodeSpan {
+                start,
+                end: start + text.len(),
+                text: text.to_string(),
+            }
+        };
+        let function = span(source.trim_end());
+        let statements = [
+            span("x := g(1,2)"),
+            span("y := 2"),
+            span("return x+y"),
+            function.clone(),
+        ];
+        let inside = |text: &str| {
+            let start = source.find(text).unwrap();
+            (start, start + text.len())
+        };
+
+        let regions = format_regions(source, &statements, &[inside("1,2")]).unwrap();
+        assert_eq!(
+            &source[regions[0].0..regions[0].1],
+            "\tx := g(1,2) // note\n"
+        );
+
+        // Adjacent statements stay separate regions; a range spanning two
+        // is widened to the function
+        let regions =
+            format_regions(source, &statements, &[inside("y := 2"), inside("x+y")]).unwrap();
+        assert_eq!(regions.len(), 2);
+        let regions = format_regions(source, &statements, &[inside("2\n\treturn")]).unwrap();
+        assert_eq!(regions, [(0, source.len())]);
+
+        assert!(format_regions(source, &statements[..3], &[inside("2\n\treturn")]).is_none());
+    }
+
+    #[test]
+    fn test_go_receiver_parts() {
+        let parts = |receiver: &'static str| {
+            let (name, start) = go_receiver_parts(receiver).unwrap();
+            (name, &receiver[start..])
+        };
+        assert_eq!(parts("(p Person)"), (Some("p"), "Person)"));
+        assert_eq!(parts("(p  *Person)"), (Some("p"), "*Person)"));
+        assert_eq!(parts("(*Person)"), (None, "*Person)"));
+        assert_eq!(parts("(l *List[K, V])"), (Some("l"), "*List[K, V])"));
+        assert_eq!(parts("(List[K, V])"), (None, "List[K, V])"));
+    }
+
+    #[test]
+    fn test_fit_blank_lines() {
+        // A block scalar fix ends with a newline the node never had
+        assert_eq!(
+            fit_blank_lines("fn old() {}", "fn new() {}\n"),
+            "fn new() {}"
+        );
+        assert_eq!(
+            fit_blank_lines("fn old() {}", "\n\nfn new() {}\n\n"),
+            "fn new() {}"
+        );
+        // A fix for a nested node drops the indentation after its own
+        // leading newline, as the line already has it
+        assert_eq!(
+            fit_blank_lines(
+                "def old(self):\n        pass",
+                "\n    def new(self):\n        pass\n"
+            ),
+            "def new(self):\n        pass"
+        );
+        // Line breaks the replaced range took in keep the file's layout
+        assert_eq!(
+            fit_blank_lines("fn old() {}\n\n", "fn new() {}"),
+            "fn new() {}\n\n"
+        );
+        assert_eq!(
+            fit_blank_lines("fn old() {}\r\n", "fn new() {}\n"),
+            "fn new() {}\r\n"
+        );
+        // Blank lines inside the fix, spaces at its edges, and deletions
+        // are the fix's own
+        assert_eq!(
+            fit_blank_lines("x", "fn a() {}\n\nfn b() {}"),
+            "fn a() {}\n\nfn b() {}"
+        );
+        assert_eq!(fit_blank_lines("a + b", " a - b "), " a - b ");
+        assert_eq!(fit_blank_lines("fn old() {}\n", ""), "");
+    }
+
+    #[test]
+    fn test_declared_name_and_type() {
+        assert_eq!(declared_name("pub(crate) count: usize", "rust"), "count");
+        assert_eq!(declared_type("pub(crate) count: usize", "rust"), "usize");
+        assert_eq!(declared_name("Name string `json:\"name\"`", "go"), "Name");
+        assert_eq!(declared_type("Name string `json:\"name\"`", "go"), "string");
+        assert_eq!(declared_type("a, b int", "go"), "int");
+        assert_eq!(declared_name("*sync.Mutex", "go"), "Mutex");
+        assert_eq!(declared_type("*sync.Mutex", "go"), "*sync.Mutex");
+        assert_eq!(declared_name("final int[] values", "java"), "values");
+        assert_eq!(declared_type("final int[] values", "java"), "int[]");
+        assert_eq!(declared_name("char name[16];", "c"), "name");
+        assert_eq!(
+            declared_name("private readonly id?: string", "typescript"),
+            "id"
+        );
+        assert_eq!(
+            declared_type("private readonly id?: string", "typescript"),
+            "string"
+        );
+        assert_eq!(declared_name("timeout: float = 1.0", "python"), "timeout");
+    }
+
+    #[test]
+    fn test_is_exported_declaration() {
+        assert_eq!(is_exported_declaration("Name string", "go"), Some(true));
+        assert_eq!(is_exported_declaration("*sync.Mutex", "go"), Some(true));
+        assert_eq!(is_exported_declaration("count int", "go"), Some(false));
+        assert_eq!(
+            is_exported_declaration("pub(crate) id: u32", "rust"),
+            Some(true)
+        );
+        assert_eq!(is_exported_declaration("id: u32", "rust"), Some(false));
+        assert_eq!(
+            is_exported_declaration("_cache=None", "python"),
+            Some(false)
+        );
+        assert_eq!(is_exported_declaration("int x", "c"), None);
+    }
+
+    #[test]
+    fn test_reorder_edits_move_comments_with_items() {
+        let source = "{\n    // The id\n    id: u32, // unique\n    // Section\n\n    name: String,\n    age: u8, // years\n}";
+        let span = |text: &str| {
+            let start = source.find(text).unwrap();
+            NodeSpan {
+                start,
+                end: start + text.len(),
+                text: text.to_string(),
+            }
+        };
+        let children = [
+            (span("// The id"), true),
+            (span("id: u32"), false),
+            (span("// unique"), true),
+            (span("// Section"), true),
+            (span("name: String"), false),
+            (span("age: u8"), false),
+            (span("// years"), true),
+        ];
+        let items = list_items(source, &children);
+        assert_eq!(items.len(), 3);
+        assert_eq!(items[0].start, source.find("// The id").unwrap());
+        // A blank line parts a comment from the element below it
+        assert_eq!(items[1].start, source.find("name").unwrap());
+        assert!(items[1].trailing.is_none());
+
+        let edits = reorder_edits(source, &items, &[2, 0, 1]).unwrap();
+        assert_eq!(
+            apply_edits(source, &edits).unwrap(),
+            "{\n    age: u8, // years\n    // Section\n\n    // The id\n    id: u32, // unique\n    name: String,\n}"
+        );
+    }
+
+    #[test]
+    fn test_is_interpolated_string() {
+        assert!(is_interpolated_string("f\"Hello {name}\"", "python"));
+        assert!(is_interpolated_string("rF'{x}'", "python"));
+        assert!(!is_interpolated_string("r'\\d+'", "python"));
+        assert!(is_interpolated_string("`Hi ${name}`", "typescript"));
+        assert!(!is_interpolated_string("`plain`", "javascript"));
+        assert!(is_interpolated_string("\"Hi \\(name)\"", "swift"));
+        assert!(!is_interpolated_string("\"{}\"", "rust"));
+    }
+
+    #[test]
+    fn test_go_sprintf_call() {
+        assert_eq!(
+            go_format_literal(r#""100% \"sure\"\n""#).unwrap(),
+            r#"100%% \"sure\"\n"#
+        );
+        assert_eq!(
+            go_format_literal("`C:\\dir \"x\"\n`").unwrap(),
+            r#"C:\\dir \"x\"\n"#
+        );
+        assert_eq!(go_format_literal(r#""a" + "b""#), None);
+        assert_eq!(go_format_literal("name"), None);
+
+        assert_eq!(
+            go_sprintf_call("fmt.", &[r#""Hello, ""#, "name", r#""!""#]).unwrap(),
+            r#"fmt.Sprintf("Hello, %s!", name)"#
+        );
+        assert_eq!(
+            go_sprintf_call("", &["dir", r#""/""#, "file.Name()"]).unwrap(),
+            r#"Sprintf("%s/%s", dir, file.Name())"#
+        );
+        assert_eq!(go_sprintf_call("fmt.", &[r#""a""#, r#""b""#]), None);
+        assert_eq!(go_sprintf_call("fmt.", &["a", "b"]), None);
+    }
+
+    #[test]
+    fn test_if_chain_and_switch_text() {
+        assert_eq!(
+            branch_body(" // one\n\t\ta()\n\n\t\tb()\n\t", "\t\t"),
+            ("// one".to_string(), "\t\ta()\n\n\t\tb()\n".to_string())
+        );
+        assert_eq!(
+            branch_body(" return 1 ", "\t\t"),
+            (String::new(), "\t\treturn 1\n".to_string())
+        );
+        assert_eq!(branch_body("\n\t", "\t\t"), (String::new(), String::new()));
+        assert!(is_plain_operand("req.Method"));
+        assert!(!is_plain_operand("f()"));
+        assert!(!is_plain_operand("s[0]"));
+        assert!(!is_plain_operand("1"));
+
+        let branches = vec![
+            Branch {
+                values: vec!["1".to_string(), "2".to_string()],
+                comment: "// small".to_string(),
+                body: "\t\ta()\n".to_string(),
+            },
+            Branch {
+                values: vec!["a || b".to_string()],
+                comment: String::new(),
+                body: String::new(),
+            },
+            Branch {
+                values: Vec::new(),
+                comment: String::new(),
+                body: "\t\tc()\n".to_string(),
+            },
+        ];
+        assert_eq!(
+            go_switch_text("x", &branches, "\t"),
+            "switch x {\n\tcase 1, 2: // small\n\t\ta()\n\tcase a || b:\n\tdefault:\n\t\tc()\n\t}"
+        );
+        assert_eq!(
+            go_if_chain_text(Some("x := f()"), Some("x"), &branches, "\t"),
+            "if x := f(); x == 1 || x == 2 { // small\n\t\ta()\n\t} else if x == (a || b) {\n\t} else {\n\t\tc()\n\t}"
+        );
+        assert_eq!(
+            go_if_chain_text(None, None, &branches[..2], ""),
+            "if 1 || 2 { // small\n\t\ta()\n} else if a || b {\n}"
+        );
+    }
+
+    #[test]
+    fn test_go_declaration_group() {
+        let items = vec![
+            DeclarationItem {
+                blank_before: false,
+                comments: vec!["// Version is the release.".to_string()],
+                spec: "Version = \"1.2\"".to_string(),
+            },
+            DeclarationItem {
+                blank_before: true,
+                comments: Vec::new(),
+                spec: "debug = false // set by -debug".to_string(),
+            },
+        ];
+        assert_eq!(
+            go_declaration_group("var", &items),
+            "var (\n\t// Version is the release.\n\tVersion = \"1.2\"\n\n\tdebug = false // set by -debug\n)"
+        );
+        assert_eq!(
+            go_separate_declarations("var", &items),
+            "// Version is the release.\nvar Version = \"1.2\"\n\nvar debug = false // set by -debug"
+        );
+    }
+
+    #[test]
+    fn test_top_level_window() {
+        let source = "package main\n\nfunc a() {\n\tif x {\n\t}\n}\n\n// b does b\nfunc b() {\n\treturn\n}\n";
+        let inside_a = source.find("if x").unwrap();
+        let (start, end) = top_level_window(source, inside_a, inside_a + 4);
+        assert_eq!(&source[start..end], "func a() {\n\tif x {\n\t}\n}\n\n");
+
+        let (start, end) = top_level_window(source, inside_a, source.find("return").unwrap());
+        assert_eq!(&source[start..], &source[source.find("func a").unwrap()..]);
+        assert_eq!(end, source.len());
+
+        let python = "if a:\n    pass\nelse:\n    b()\nc()\n";
+        let (start, end) = top_level_window(
+            python,
+            python.find("b()").unwrap(),
+            python.find("b()").unwrap(),
+        );
+        assert_eq!(&python[start..end], "if a:\n    pass\nelse:\n    b()\n");
+    }
+
+    #[test]
+    fn test_original_as_comment() {
+        let source = "fn main() {\n    let total = sum(\n        a,\n        b,\n    );\n}\n";
+        let start = source.find("sum").unwrap();
+        let end = source.find(");").unwrap() + 1;
+        let edits = [
+            TextEdit {
+                start,
+                end,
+                replacement: "a + b".to_string(),
+            },
+            original_as_comment(source, start, end, "//"),
+        ];
+        assert_eq!(
+            apply_edits(source, &edits).unwrap(),
+            "fn main() {\n    let total = a + b;\n    // before:\n    // sum(\n    //     a,\n    //     b,\n    // )\n}\n"
+        );
+
+        // A match on the last line of a file without a trailing newline
+        let source = "x = old()";
+        let edit = original_as_comment(source, 4, 9, "#");
+        assert_eq!(edit.start, 9);
+        assert_eq!(edit.replacement, "\n# before:\n# old()\n");
+    }
+
+    #[test]
+    fn test_leading_comment_range() {
+        let source = "// Section\n\n// divide returns a / b.\n// It fails when b is zero.\nfunc divide() {}\n";
+        let decl = source.find("func").unwrap();
+        let (start, end) = leading_comment_range(source, decl, "//").unwrap();
+        assert_eq!(
+            &source[start..end],
+            "// divide returns a / b.\n// It fails when b is zero.\n"
+        );
+
+        // Attributes and one blank line may separate the comment from the item
+        let source = "/// Adds.\n\n#[inline]\nfn add() {}\n";
+        let (start, end) =
+            leading_comment_range(source, source.find("fn").unwrap(), "///").unwrap();
+        assert_eq!(&source[start..end], "/// Adds.\n");
+
+        let source = "/**\n * Adds.\n */\nfunction add() {}\n";
+        let (start, end) =
+            leading_comment_range(source, source.find("function").unwrap(), "//").unwrap();
+        assert_eq!((start, end), (0, source.find("function").unwrap()));
+
+        let source = "// not a doc comment\nfn add() {}\n";
+        assert!(leading_comment_range(source, source.find("fn").unwrap(), "///").is_none());
+    }
+
+    #[test]
+    fn test_declaration_removal_range() {
+        let source = "func a() {}\n\n// b does b.\nfunc b() {}\n\nfunc c() {}\n";
+        let b = source.find("func b").unwrap();
+        let (start, end) = declaration_removal_range(source, b, b + "func b() {}".len(), "//");
+        assert_eq!(
+            format!("{}{}", &source[..start], &source[end..]),
+            "func a() {}\n\nfunc c() {}\n"
+        );
+
+        // The last declaration takes the blank lines above it
+        let source = "def a():\n    pass\n\n\ndef b():\n    pass\n";
+        let b = source.find("def b").unwrap();
+        let (start, end) = declaration_removal_range(source, b, source.len() - 1, "#");
+        assert_eq!(&source[..start], "def a():\n    pass\n");
+        assert_eq!(end, source.len());
+    }
+
+    #[test]
+    fn test_type_arguments() {
+        assert_eq!(
+            type_arguments("Map[int, string]", '[', ']'),
+            Some(("Map", vec!["int", "string"]))
+        );
+        assert_eq!(
+            type_arguments("Max[[]int]", '[', ']'),
+            Some(("Max", vec!["[]int"]))
+        );
+        assert_eq!(
+            type_arguments("iter.collect::<HashMap<K, Box<dyn Fn() -> V>>>", '<', '>'),
+            Some(("iter.collect", vec!["HashMap<K, Box<dyn Fn() -> V>>"]))
+        );
+        assert_eq!(type_arguments("Max", '[', ']'), None);
+        assert_eq!(type_arguments("items[0]", '<', '>'), None);
+
+        assert_eq!(type_parameter_names("[T, U any]"), ["T", "U"]);
+        assert_eq!(
+            type_parameter_names("[K comparable, V interface{ ~int | ~string }]"),
+            ["K", "V"]
+        );
+        assert_eq!(
+            type_parameter_names("<'a, T: Clone + Into<String>, const N: usize>"),
+            ["'a", "T", "N"]
+        );
+    }
+
+    #[test]
+    fn test_go_import_edit() {
+        let span = |source: &str, text: &str| {
+            let start = source.find(text).unwrap();
+            NodeSpan {
+                start,
+                end: start + text.len(),
+                text: text.to_string(),
+            }
+        };
+        let add = |source: &str, declarations: &[(NodeSpan, Vec<NodeSpan>)], spec: &str| {
+            let edit = go_import_edit(source, declarations, "package main".len(), spec)?;
+            apply_edits(source, &[edit]).ok()
+        };
+
+        let source = "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/x/log\"\n)\n";
+        let block = span(
+            source,
+            "import (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/x/log\"\n)",
+        );
+        let declarations = vec![(
+            block,
+            vec![
+                span(source, "\"fmt\""),
+                span(source, "\"os\""),
+                span(source, "\"github.com/x/log\""),
+            ],
+        )];
+        assert_eq!(
+            add(source, &declarations, "\"errors\"").unwrap(),
+            "package main\n\nimport (\n\t\"errors\"\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/x/log\"\n)\n"
+        );
+        assert_eq!(
+            add(source, &declarations, "y \"example.com/y\"").unwrap(),
+            "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\ty \"example.com/y\"\n\t\"github.com/x/log\"\n)\n"
+        );
+        assert!(go_import_edit(source, &declarations, 12, "f \"fmt\"").is_none());
+
+        // A single import becomes a group, with other packages after a blank line
+        let source = "package main\n\nimport \"fmt\"\n";
+        let declarations = vec![(
+            span(source, "import \"fmt\""),
+            vec![span(source, "\"fmt\"")],
+        )];
+        assert_eq!(
+            add(source, &declarations, "\"github.com/x/log\"").unwrap(),
+            "package main\n\nimport (\n\t\"fmt\"\n\n\t\"github.com/x/log\"\n)\n"
+        );
+        assert_eq!(
+            add("package main\n\nfunc main() {}\n", &[], "\"time\"").unwrap(),
+            "package main\n\nimport \"time\"\n\nfunc main() {}\n"
+        );
+    }
+
+    #[test]
+    fn test_go_table_test() {
+        let params = go_results("(a, b int, name string)");
+        let results = go_result_types("(int, error)");
+        let (test, deep_equal) = go_table_test("TestAdd", "Add", None, &params, &results);
+        assert!(!deep_equal);
+        assert_eq!(
+            test,
+            "func TestAdd(t *testing.T) {
+\ttests := []struct {
+\t\tname    string
+\t\ta       int
+\t\tb       int
+\t\targName string
+\t\twant    int
+\t\twantErr bool
+\t}{
+\t\t// TODO: add test cases
+\t}
+\tfor _, tt := range tests {
+\t\tt.Run(tt.name, func(t *testing.T) {
+\t\t\tgot, err := Add(tt.a, tt.b, tt.argName)
+\t\t\tif (err != nil) != tt.wantErr {
+\t\t\t\tt.Fatalf(\"Add() error = %v, wantErr %v\", err, tt.wantErr)
+\t\t\t}
+\t\t\tif got != tt.want {
+\t\t\t\tt.Errorf(\"Add() = %v, want %v\", got, tt.want)
+\t\t\t}
+\t\t})
+\t}
+}
+"
+        );
+
+        // Methods get a receiver, and variadic parameters a slice
+        let params = go_results("(sep string, parts ...string)");
+        let results = go_result_types("([]string, bool)");
+        let (test, deep_equal) =
+            go_table_test("TestPath_Join", "Join", Some("*Path"), &params, &results);
+        assert!(deep_equal);
+        assert!(test.contains("\t\treceiver *Path\n\t\tsep      string\n\t\tparts    []string\n"));
+        assert!(test.contains("got, got1 := tt.receiver.Join(tt.sep, tt.parts...)\n"));
+        assert!(test.contains("if !reflect.DeepEqual(got, tt.want) {"));
+        assert!(test.contains("t.Errorf(\"Path.Join() got1 = %v, want %v\", got1, tt.want1)"));
+
+        let (test, _) = go_table_test("Test_reset", "reset", None, &[], &[]);
+        assert!(test.contains("\t\tname string\n\t}{"));
+        assert!(test.contains("\t\t\treset()\n\t\t})"));
+    }
+
+    #[test]
+    fn test_go_import_names() {
+        assert_eq!(go_import_name("\"fmt\""), "fmt");
+        assert_eq!(go_import_name("\"github.com/x/log\""), "log");
+        assert_eq!(go_import_name("f \"fmt\""), "f");
+        assert_eq!(go_import_name("_ \"embed\""), "_");
+        assert_eq!(go_import_spec("time"), "\"time\"");
+        assert_eq!(
+            go_import_spec("log github.com/x/log"),
+            "log \"github.com/x/log\""
+        );
+        assert_eq!(go_import_spec("_ \"embed\""), "_ \"embed\"");
+
+        let source = "package main\n\nimport \"os\"\n\nimport (\n\t\"fmt\"\n\t\"io\"\n)\n";
+        let span = |text: &str| {
+            let start = source.find(text).unwrap();
+            NodeSpan {
+                start,
+                end: start + text.len(),
+                text: text.to_string(),
+            }
+        };
+        let group = span("import (\n\t\"fmt\"\n\t\"io\"\n)");
+        let delete = |declaration: &NodeSpan, spec: &str| {
+            apply_edits(
+                source,
+                &[go_import_deletion(source, declaration, &span(spec))],
+            )
+            .unwrap()
+        };
+        assert_eq!(
+            delete(&group, "\"io\""),
+            "package main\n\nimport \"os\"\n\nimport (\n\t\"fmt\"\n)\n"
+        );
+        assert_eq!(
+            delete(&span("import \"os\""), "\"os\""),
+            "package main\n\nimport (\n\t\"fmt\"\n\t\"io\"\n)\n"
+        );
+        assert_eq!(
+            go_import_group(&["\"github.com/x/log\"", "\"os\"", "f \"fmt\""]),
+            "import (\n\tf \"fmt\"\n\t\"os\"\n\n\t\"github.com/x/log\"\n)"
+        );
+    }
+
+    #[test]
+    fn test_member_insertion() {
+        let source = "struct Point: Shape {\n    var x: Double\n\n    func area() -> Double {\n        0\n    }\n}\n";
+        let decl = NodeSpan {
+            start: 0,
+            end: source.len() - 1,
+            text: source.trim_end().to_string(),
+        };
+        let edit =
+            member_insertion(source, &decl, "func scaled() -> Point {\n    self\n}").unwrap();
+        assert_eq!(
+            apply_edits(source, &[edit]).unwrap(),
+            "struct Point: Shape {\n    var x: Double\n\n    func area() -> Double {\n        0\n    }\n\n    func scaled() -> Point {\n        self\n    }\n}\n"
+        );
+
+        // An empty inline body gets its closing brace on a new line
+        let source = "    class Empty {}\n";
+        let decl = NodeSpan {
+            start: 4,
+            end: source.len() - 1,
+            text: "class Empty {}".to_string(),
+        };
+        let edit = member_insertion(source, &decl, "\tfunc run() {}").unwrap();
+        assert_eq!(
+            apply_edits(source, &[edit]).unwrap(),
+            "    class Empty {\n        func run() {}\n    }\n"
+        );
+
+        let decl = NodeSpan {
+            start: 0,
+            end: 9,
+            text: "enum E;\n".to_string(),
+        };
+        assert!(member_insertion("enum E;\n\n", &decl, "x").is_none());
+    }
+
+    #[test]
+    fn test_file_header_and_block_insertion() {
+        let source = "#!/usr/bin/env python3\n\"\"\"Tools.\"\"\"\n# License: MIT\n\n# Lists users\ndef users():\n    pass\n";
+        let docstring = Some((23, 35));
+        let comments = [(0, 22), (36, 50), (52, 65)];
+        // The function's own comment is not part of the header
+        let end = file_header_end(source, &comments, docstring);
+        assert_eq!(&source[end..], "\n# Lists users\ndef users():\n    pass\n");
+        assert_eq!(
+            file_header_end("// Run runs.\nfunc Run() {}\n", &[(0, 12)], None),
+            0
+        );
+
+        let edit = block_insertion(source, end, "import os\n");
+        assert_eq!(
+            apply_edits(source, &[edit]).unwrap(),
+            "#!/usr/bin/env python3\n\"\"\"Tools.\"\"\"\n# License: MIT\n\nimport os\n\n# Lists users\ndef users():\n    pass\n"
+        );
+        let source = "package main\n\nfunc main() {}";
+        let edit = block_insertion(source, source.len(), "var x = 1");
+        assert_eq!(
+            apply_edits(source, &[edit]).unwrap(),
+            "package main\n\nfunc main() {}\n\nvar x = 1\n"
+        );
+        let edit = block_insertion(source, 0, "// Header");
+        assert_eq!(
+            apply_edits(source, &[edit]).unwrap(),
+            "// Header\n\npackage main\n\nfunc main() {}"
+        );
+    }
+
+    #[test]
+    fn test_render_comment() {
+        assert_eq!(
+            render_comment(
+                "Adds two numbers.\n\nPanics never.",
+                CommentStyle::Line("///"),
+                "    "
+            ),
+            "    /// Adds two numbers.\n    ///\n    /// Panics never.\n"
+        );
+        assert_eq!(
+            render_comment("Adds.", CommentStyle::Block, ""),
+            "/**\n * Adds.\n */\n"
+        );
+        assert_eq!(
+            detect_comment_style("  # Adds.\n", CommentStyle::Block),
+            CommentStyle::Line("#")
+        );
+    }
+}
@@ -0,0 +1,508 @@
+//! Locate code embedded in another language (SQL in a Go raw string, a
+//! `<script>` body in HTML, a code block in Markdown) and map matches found
+//! in it back to the file that contains it.
+
+use crate::edit_utils;
+use anyhow::{anyhow, Result};
+use regex::Regex;
+use serde_json::Value;
+
+/// The byte range of embedded source inside its parent file.
+#[derive(Debug, Clone, PartialEq)]
+pub struct EmbeddedRegion {
+    pub start: usize,
+    pub end: usize,
+    /// Text that would end the region early if a replacement contained it
+    pub terminator: String,
+    /// Columns of indentation stripped from each line before parsing: 4
+    /// for an indented Markdown code block, 0 when the text is verbatim
+    pub dedent: usize,
+}
+
+/// A fenced or indented code block in a Markdown document.
+#[derive(Debug, Clone, PartialEq)]
+pub struct CodeBlock {
+    /// The block's contents, between its fences or over its indented lines
+    pub region: EmbeddedRegion,
+    /// The opening fence's info string, e.g. `go` or `go title="main.go"`;
+    /// empty for indented blocks
+    pub info: String,
+    pub indented: bool,
+}
+
+/// Range of a string literal's contents within `text`, the literal's full
+/// source (prefixes and quotes included). Only literals whose contents are
+/// their value verbatim are accepted, since escapes and interpolation would
+/// make offsets drift between the parent and the re-parsed text.
+pub fn string_literal_content(text: &str, language: &str) -> Result<(usize, usize)> {
+    let quote_at = text
+        .find(['"', '\'', '`'])
+        .ok_or_else(|| anyhow!("Not a string literal: {}", text))?;
+    let prefix = &text[..quote_at];
+    let quote = text[quote_at..].chars().next().unwrap_or('"');
+
+    // C++ raw strings: R"delim( ... )delim"
+    if prefix.ends_with('R') && quote == '"' {
+        let open = text[quote_at..]
+            .find('(')
+            .map(|i| quote_at + i)
+            .ok_or_else(|| anyhow!("Malformed raw string literal"))?;
+        let delimiter = &text[quote_at + 1..open];
+        let close = format!("){delimiter}\"");
+        if !text.ends_with(&close) || text.len() < open + 1 + close.len() {
+            return Err(anyhow!("Malformed raw string literal"));
+        }
+        return Ok((open + 1, text.len() - close.len()));
+    }
+
+    // Rust raw strings: r#"..."#, with any number of hashes
+    let hashes = prefix.chars().rev().take_while(|c| *c == '#').count();
+    let prefix_letters = &prefix[..prefix.len() - hashes];
+
+    let quote_len = if text[quote_at..].starts_with("\"\"\"") || text[quote_at..].starts_with("'''")
+    {
+        3
+    } else {
+        1
+    };
+    let open = quote_at + quote_len;
+    let close_len = quote_len + hashes;
+    if text.len() < open + close_len
+        || !text[text.len() - close_len..].starts_with(&text[quote_at..open])
+    {
+        return Err(anyhow!("Unterminated string literal"));
+    }
+    let (start, end) = (open, text.len() - close_len);
+    let content = &text[start..end];
+
+    // Go backtick strings are raw; JavaScript template strings are not.
+    // Hashes delimit raw strings in both Rust and Swift (`#"..."#`).
+    let raw =
+        quote == '`' && language == "go" || hashes > 0 || prefix_letters.contains(['r', 'R', '@']);
+    if !raw && content.contains('\\') {
+        return Err(anyhow!(
+            "The string contains escape sequences, so its offsets cannot be mapped back exactly. Use a raw string literal."
+        ));
+    }
+    if quote == '`' && content.contains("${") || prefix_letters.contains(['f', 'F', '$']) {
+        return Err(anyhow!(
+            "The string contains interpolation, so it cannot be parsed on its own"
+        ));
+    }
+    Ok((start, end))
+}
+
+/// Contents of every `<script>` or `<style>` element in an HTML document.
+pub fn html_element_regions(source: &str, element: &str) -> Vec<EmbeddedRegion> {
+    let pattern = format!(r"(?is)<{element}\b[^>]*>(.*?)</{element}\s*>");
+    let Ok(regex) = Regex::new(&pattern) else {
+        return Vec::new();
+    };
+    regex
+        .captures_iter(source)
+        .filter_map(|captures| captures.get(1))
+        .map(|content| EmbeddedRegion {
+            start: content.start(),
+            end: content.end(),
+            terminator: format!("</{element}"),
+            dedent: 0,
+        })
+        .collect()
+}
+
+/// Width of `line`'s leading whitespace in columns, tabs stopping at
+/// multiples of 4.
+fn indent_width(line: &str) -> usize {
+    let mut width = 0;
+    for c in line.chars() {
+        match c {
+            ' ' => width += 1,
+            '\t' => width = (width / 4 + 1) * 4,
+            _ => break,
+        }
+    }
+    width
+}
+
+/// Bytes of leading whitespace making up the first `columns` columns of
+/// `line` (fewer if it is indented less).
+fn stripped_indent(line: &str, columns: usize) -> usize {
+    let mut width = 0;
+    let mut bytes = 0;
+    for c in line.chars() {
+        if width >= columns {
+            break;
+        }
+        match c {
+            ' ' => width += 1,
+            '\t' => width = (width / 4 + 1) * 4,
+            _ => break,
+        }
+        bytes += 1;
+    }
+    bytes
+}
+
+/// Marker, length and trailing text of a code fence line such as
+/// `` ```go ``, indented at most three spaces.
+fn code_fence(line: &str) -> Option<(char, usize, &str)> {
+    let trimmed = line.trim_start_matches(' ');
+    if line.len() - trimmed.len() > 3 {
+        return None;
+    }
+    let marker = trimmed.chars().next().filter(|c| matches!(c, '`' | '~'))?;
+    let length = trimmed.len() - trimmed.trim_start_matches(marker).len();
+    let rest = trimmed[length..].trim();
+    // An info string after backticks cannot itself contain backticks
+    (length >= 3 && !(marker == '`' && rest.contains('`'))).then_some((marker, length, rest))
+}
+
+/// Whether `line` starts a bullet or numbered list item.
+fn is_list_item(line: &str) -> bool {
+    let trimmed = line.trim_start();
+    let digits = trimmed.len()
+        - trimmed
+            .trim_start_matches(|c: char| c.is_ascii_digit())
+            .len();
+    let rest = &trimmed[digits..];
+    if digits > 0 {
+        rest.starts_with(". ") || rest.starts_with(") ")
+    } else {
+        ["- ", "* ", "+ "]
+            .iter()
+            .any(|marker| rest.starts_with(marker))
+    }
+}
+
+/// Every code block in a Markdown document, in order. Fenced blocks run to
+/// a closing fence of the same marker at least as long, or to the end of
+/// the document. Indented blocks are lines indented four or more columns
+/// after a blank line, outside list items, whose indented lines continue
+/// the item rather than starting code.
+pub fn markdown_code_blocks(source: &str) -> Vec<CodeBlock> {
+    let mut lines = Vec::new();
+    let mut offset = 0;
+    for line in source.split_inclusive('\n') {
+        lines.push((offset, line));
+        offset += line.len();
+    }
+    let blank = |line: &str| line.trim().is_empty();
+
+    let mut blocks = Vec::new();
+    let mut previous_blank = true;
+    let mut in_list = false;
+    let mut i = 0;
+    while i < lines.len() {
+        let (start, line) = lines[i];
+        if let Some((marker, length, info)) = code_fence(line) {
+            let close = lines[i + 1..].iter().position(|(_, line)| {
+                code_fence(line).is_some_and(|(closing, closing_length, rest)| {
+                    closing == marker && closing_length >= length && rest.is_empty()
+                })
+            });
+            let content_start = lines.get(i + 1).map_or(source.len(), |(start, _)| *start);
+            let (content_end, next) = match close {
+                Some(close) => (lines[i + 1 + close].0, i + 2 + close),
+                None => (source.len(), lines.len()),
+            };
+            blocks.push(CodeBlock {
+                region: EmbeddedRegion {
+                    start: content_start,
+                    end: content_end,
+                    terminator: marker.to_string().repeat(length),
+                    dedent: 0,
+                },
+                info: info.to_string(),
+                indented: false,
+            });
+            previous_blank = false;
+            i = next;
+            continue;
+        }
+
+        if !blank(line) && indent_width(line) >= 4 && previous_blank && !in_list {
+            let mut last = i;
+            let mut next = i + 1;
+            while next < lines.len() && (blank(lines[next].1) || indent_width(lines[next].1) >= 4) {
+                if !blank(lines[next].1) {
+                    last = next;
+                }
+                next += 1;
+            }
+            blocks.push(CodeBlock {
+                region: EmbeddedRegion {
+                    start,
+                    end: lines[last].0 + lines[last].1.len(),
+                    terminator: String::new(),
+                    dedent: 4,
+                },
+                info: String::new(),
+                indented: true,
+            });
+            previous_blank = false;
+            i = last + 1;
+            continue;
+        }
+
+        if is_list_item(line) {
+            in_list = true;
+        } else if !blank(line) && indent_width(line) == 0 {
+            in_list = false;
+        }
+        previous_blank = blank(line);
+        i += 1;
+    }
+    blocks
+}
+
+/// The text of `region` as it is parsed: verbatim, or with each line's
+/// Markdown indentation removed.
+pub fn region_code(source: &str, region: &EmbeddedRegion) -> String {
+    let text = &source[region.start..region.end];
+    if region.dedent == 0 {
+        return text.to_string();
+    }
+    text.split_inclusive('\n')
+        .map(|line| &line[stripped_indent(line, region.dedent)..])
+        .collect()
+}
+
+/// Offsets in `source` of the range `start..end` of `region_code`.
+fn region_offsets(
+    source: &str,
+    region: &EmbeddedRegion,
+    start: usize,
+    end: usize,
+) -> (usize, usize) {
+    if region.dedent == 0 {
+        return (region.start + start, region.start + end);
+    }
+    // Each line's code maps linearly onto the line after its indentation
+    let to_parent = |offset: usize| {
+        let mut parent = region.start;
+        let mut code = 0;
+        for line in source[region.start..region.end].split_inclusive('\n') {
+            let strip = stripped_indent(line, region.dedent);
+            let len = line.len() - strip;
+            if offset < code + len {
+                return parent + strip + (offset - code);
+            }
+            code += len;
+            parent += line.len();
+        }
+        region.end
+    };
+    let parent_start = to_parent(start);
+    // An end at a line break stays on its line, not after the next indent
+    let parent_end = match end > start {
+        true => to_parent(end - 1) + 1,
+        false => parent_start,
+    };
+    (parent_start, parent_end)
+}
+
+/// `replacement` indented to sit inside `region`, so new lines of an
+/// indented code block stay in the block.
+pub fn indent_replacement(region: &EmbeddedRegion, replacement: &str) -> String {
+    if region.dedent == 0 {
+        return replacement.to_string();
+    }
+    let indent = " ".repeat(region.dedent);
+    replacement
+        .split('\n')
+        .enumerate()
+        .map(|(i, line)| match i > 0 && !line.is_empty() {
+            true => format!("{indent}{line}"),
+            false => line.to_string(),
+        })
+        .collect::<Vec<_>>()
+        .join("\n")
+}
+
+/// 0-based line and character column of `offset`, as ast-grep reports them.
+fn position_json(source: &str, offset: usize) -> Value {
+    let line_start = edit_utils::line_start(source, offset);
+    serde_json::json!({
+        "line": edit_utils::line_number(source, offset) - 1,
+        "column": source[line_start..offset].chars().count(),
+    })
+}
+
+/// An ast-grep style `range` for `region` within `parent`.
+pub fn region_range(parent: &str, region: &EmbeddedRegion) -> Value {
+    serde_json::json!({
+        "byteOffset": {"start": region.start, "end": region.end},
+        "start": position_json(parent, region.start),
+        "end": position_json(parent, region.end),
+    })
+}
+
+/// Rewrite a match found in the embedded text so its offsets, lines, and
+/// columns refer to `parent`, where the embedded text starts at `base`.
+pub fn map_match_to_parent(m: &Value, parent: &str, base: usize, file: Option<&str>) -> Value {
+    map_match(m, parent, |start, end| (base + start, base + end), file)
+}
+
+/// `map_match_to_parent` for a match in the `region_code` of `region`.
+pub fn map_match_to_region(
+    m: &Value,
+    parent: &str,
+    region: &EmbeddedRegion,
+    file: Option<&str>,
+) -> Value {
+    map_match(
+        m,
+        parent,
+        |start, end| region_offsets(parent, region, start, end),
+        file,
+    )
+}
+
+fn map_match(
+    m: &Value,
+    parent: &str,
+    to_parent: impl Fn(usize, usize) -> (usize, usize),
+    file: Option<&str>,
+) -> Value {
+    let mut mapped = m.clone();
+    let offsets = |range: &Value| {
+        let start = range["start"].as_u64()? as usize;
+        let end = range["end"].as_u64()? as usize;
+        Some(to_parent(start, end))
+    };
+
+    if let Some((start, end)) = offsets(&m["range"]["byteOffset"]) {
+        mapped["range"]["byteOffset"] = serde_json::json!({"start": start, "end": end});
+        mapped["range"]["start"] = position_json(parent, start);
+        mapped["range"]["end"] = position_json(parent, end);
+    }
+    if let Some((start, end)) = offsets(&m["replacementOffsets"]) {
+        mapped["replacementOffsets"] = serde_json::json!({"start": start, "end": end});
+    }
+    mapped["file"] = file.map_or(Value::Null, |file| Value::String(file.to_string()));
+    if let Some(object) = mapped.as_object_mut() {
+        // These describe the temporary file the embedded text was parsed from
+        object.remove("lines");
+        object.remove("charCount");
+    }
+    mapped
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn content(text: &str) -> Result<&str> {
+        let language = if text.starts_with('`') { "go" } else { "rust" };
+        let (start, end) = string_literal_content(text, language)?;
+        Ok(&text[start..end])
+    }
+
+    #[test]
+    fn test_string_literal_content() {
+        assert_eq!(
+            content("`SELECT *\n  FROM users`").unwrap(),
+            "SELECT *\n  FROM users"
+        );
+        assert_eq!(content("\"SELECT 1\"").unwrap(), "SELECT 1");
+        assert_eq!(content("r#\"a \"quoted\" b\"#").unwrap(), "a \"quoted\" b");
+        assert_eq!(content("\"\"\"\nSELECT 1\n\"\"\"").unwrap(), "\nSELECT 1\n");
+        assert_eq!(content("R\"sql(SELECT 1)sql\"").unwrap(), "SELECT 1");
+        assert_eq!(content("@\"C:\\temp\"").unwrap(), "C:\\temp");
+
+        assert!(content("\"a\\nb\"").is_err());
+        assert!(string_literal_content("`id = ${id}`", "javascript").is_err());
+        assert!(string_literal_content("`a\\nb`", "javascript").is_err());
+        assert_eq!(content("`a\\d+`").unwrap(), "a\\d+");
+        assert!(content("f\"{x}\"").is_err());
+    }
+
+    #[test]
+    fn test_html_element_regions() {
+        let html =
+            "<p>x</p>\n<script type=\"module\">\nlet a = 1;\n</script>\n<style>p {}</style>\n";
+        let scripts = html_element_regions(html, "script");
+        assert_eq!(scripts.len(), 1);
+        assert_eq!(&html[scripts[0].start..scripts[0].end], "\nlet a = 1;\n");
+        assert_eq!(html_element_regions(html, "style").len(), 1);
+    }
+
+    #[test]
+    fn test_markdown_code_blocks() {
+        let markdown = "# Example\n\n```go title=\"main.go\"\nfunc main() {}\n```\n\nText:\n\n    x := 1\n\n    y := 2\n\nEnd\n\n- item\n\n    continued\n\n````\n```\n````\n";
+        let blocks = markdown_code_blocks(markdown);
+        let contents: Vec<&str> = blocks
+            .iter()
+            .map(|block| &markdown[block.region.start..block.region.end])
+            .collect();
+        assert_eq!(
+            contents,
+            ["func main() {}\n", "    x := 1\n\n    y := 2\n", "```\n"]
+        );
+        assert_eq!(blocks[0].info, "go title=\"main.go\"");
+        assert!(!blocks[0].indented);
+        assert!(blocks[1].indented);
+        assert_eq!(blocks[2].region.terminator, "````");
+        assert_eq!(
+            region_code(markdown, &blocks[1].region),
+            "x := 1\n\ny := 2\n"
+        );
+
+        // An unclosed fence runs to the end
+        let blocks = markdown_code_blocks("```\nlet a;\n");
+        assert_eq!(blocks[0].region.end, "```\nlet a;\n".len());
+    }
+
+    #[test]
+    fn test_map_match_to_region_undoes_dedent() {
+        let parent = "Text\n\n    a := 1\n\tb := f(a)\n";
+        let region = &markdown_code_blocks(parent)[0].region;
+        let code = region_code(parent, region);
+        assert_eq!(code, "a := 1\nb := f(a)\n");
+        let start = code.find("f(a)").unwrap();
+        let m = serde_json::json!({
+            "range": {"byteOffset": {"start": start, "end": start + 4}},
+            "replacementOffsets": {"start": 0, "end": 7}
+        });
+        let mapped = map_match_to_region(&m, parent, region, None);
+        let offsets = &mapped["range"]["byteOffset"];
+        let (start, end) = (
+            offsets["start"].as_u64().unwrap() as usize,
+            offsets["end"].as_u64().unwrap() as usize,
+        );
+        assert_eq!(&parent[start..end], "f(a)");
+        assert_eq!(mapped["range"]["start"]["line"], 3);
+        // A range ending at a line break stops before the next indent
+        let replaced = &mapped["replacementOffsets"];
+        let end = replaced["end"].as_u64().unwrap() as usize;
+        assert_eq!(&parent[end - 7..end], "a := 1\n");
+
+        assert_eq!(
+            indent_replacement(region, "c := 2\n\nd := 3"),
+            "c := 2\n\n    d := 3"
+        );
+    }
+
+    #[test]
+    fn test_map_match_to_parent() {
+        let parent = "package db\n\nconst q = `\nSELECT id FROM users`\n";
+        let base = parent.find('`').unwrap() + 1;
+        let m = serde_json::json!({
+            "text": "users",
+            "file": "/tmp/embedded.sql",
+            "lines": "SELECT id FROM users",
+            "range": {
+                "byteOffset": {"start": 16, "end": 21},
+                "start": {"line": 1, "column": 15},
+                "end": {"line": 1, "column": 20}
+            }
+        });
+        let mapped = map_match_to_parent(&m, parent, base, Some("db.go"));
+        let start = mapped["range"]["byteOffset"]["start"].as_u64().unwrap() as usize;
+        assert_eq!(&parent[start..start + 5], "users");
+        assert_eq!(mapped["range"]["start"]["line"], 3);
+        assert_eq!(mapped["range"]["start"]["column"], 15);
+        assert_eq!(mapped["file"], "db.go");
+        assert!(mapped.get("lines").is_none());
+    }
+}
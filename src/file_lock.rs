@@ -0,0 +1,106 @@
+//! Per-file locks held across a tool call's read-modify-write, so two calls
+//! editing the same file run one after the other instead of one writing
+//! over the other's change. Calls on different files still run in parallel.
+//!
+//! A call that needs several files locks them all at once, in path order,
+//! and never asks for another lock while holding some. Two calls can
+//! therefore never each wait for a file the other holds.
+
+use std::collections::{BTreeSet, HashMap};
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex, Weak};
+use tokio::sync::OwnedMutexGuard;
+
+/// Held while a call edits one file; dropping it lets the next call in.
+pub type FileGuard = OwnedMutexGuard<()>;
+
+/// The lock of every file some call currently holds or waits for.
+#[derive(Default)]
+pub struct FileLocks {
+    /// Entries whose lock nobody holds anymore are pruned lazily
+    locks: Mutex<HashMap<PathBuf, Weak<tokio::sync::Mutex<()>>>>,
+}
+
+impl FileLocks {
+    pub fn new() -> Self {
+        Self::default()
+    }
+
+    /// Lock every file in `paths`, waiting for calls that hold any of them.
+    pub async fn lock_all(&self, paths: impl IntoIterator<Item = PathBuf>) -> Vec<FileGuard> {
+        // Sorted and deduplicated, which is what rules out deadlocks
+        let keys: BTreeSet<PathBuf> = paths.into_iter().map(|path| lock_key(&path)).collect();
+        let mut guards = Vec::with_capacity(keys.len());
+        for key in keys {
+            guards.push(self.lock_for(key).lock_owned().await);
+        }
+        guards
+    }
+
+    fn lock_for(&self, key: PathBuf) -> Arc<tokio::sync::Mutex<()>> {
+        let mut locks = self.locks.lock().unwrap();
+        locks.retain(|_, lock| lock.strong_count() > 0);
+        if let Some(lock) = locks.get(&key).and_then(Weak::upgrade) {
+            return lock;
+        }
+        let lock = Arc::new(tokio::sync::Mutex::new(()));
+        locks.insert(key, Arc::downgrade(&lock));
+        lock
+    }
+}
+
+/// The absolute path a file is locked under, with symlinks and `..`
+/// resolved so every spelling of one file shares a lock. A file that does
+/// not exist yet is keyed under its resolved parent directory.
+fn lock_key(path: &Path) -> PathBuf {
+    if let Ok(path) = path.canonicalize() {
+        return path;
+    }
+    let absolute = std::path::absolute(path).unwrap_or_else(|_| path.to_path_buf());
+    match (absolute.parent(), absolute.file_name()) {
+        (Some(parent), Some(name)) => parent
+            .canonicalize()
+            .map(|parent| parent.join(name))
+            .unwrap_or(absolute),
+        _ => absolute,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::time::Duration;
+
+    #[tokio::test]
+    async fn test_same_file_waits_for_holder() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("main.go");
+        std::fs::write(&path, "package main\n").unwrap();
+        let locks = FileLocks::new();
+
+        let held = locks.lock_all([path.clone()]).await;
+        // Another spelling of the same file shares its lock
+        let other_spelling = dir.path().join(".").join("main.go");
+        let blocked =
+            tokio::time::timeout(Duration::from_millis(50), locks.lock_all([other_spelling]));
+        assert!(blocked.await.is_err());
+
+        drop(held);
+        let relocked = tokio::time::timeout(Duration::from_millis(50), locks.lock_all([path]));
+        assert_eq!(relocked.await.unwrap().len(), 1);
+    }
+
+    #[tokio::test]
+    async fn test_different_files_lock_independently() {
+        let dir = tempfile::tempdir().unwrap();
+        let locks = FileLocks::new();
+
+        let _a = locks.lock_all([dir.path().join("a.go")]).await;
+        let b = tokio::time::timeout(
+            Duration::from_millis(50),
+            locks.lock_all([dir.path().join("b.go"), dir.path().join("b.go")]),
+        );
+        // Duplicates are locked once rather than deadlocking on themselves
+        assert_eq!(b.await.unwrap().len(), 1);
+    }
+}
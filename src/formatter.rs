@@ -0,0 +1,140 @@
+//! Formatters for edited files, by language.
+//!
+//! Each formatter is a command that reads a file's text on stdin and
+//! writes it back formatted on stdout. Built-in ones cover the languages
+//! with a formatter most projects use, and the server config can replace
+//! them, add others, or turn one off. A formatter that is not installed,
+//! or that fails on the text, leaves it unchanged rather than failing the
+//! edit.
+
+use crate::server_config::FormatterConfig;
+use anyhow::Result;
+use std::collections::{BTreeMap, HashMap};
+use std::process::Stdio;
+use tokio::io::AsyncWriteExt;
+use tokio::process::Command as TokioCommand;
+
+/// Formatters used when the config names none for a language. Prettier is
+/// told the parser, since text on stdin has no file name to infer it from.
+const BUILT_IN_FORMATTERS: &[(&str, &str, &[&str])] = &[
+    ("go", "gofmt", &[]),
+    (
+        "rust",
+        "rustfmt",
+        &["--emit", "stdout", "--edition", "2021"],
+    ),
+    ("python", "black", &["--quiet", "-"]),
+    ("javascript", "prettier", &["--parser", "babel"]),
+    ("typescript", "prettier", &["--parser", "typescript"]),
+    ("css", "prettier", &["--parser", "css"]),
+    ("html", "prettier", &["--parser", "html"]),
+];
+
+/// The formatter command of each language: the built-in ones, with the
+/// config's entries in their place. An entry with an empty command turns
+/// formatting off for its language.
+#[derive(Debug, Clone)]
+pub struct FormatterRegistry {
+    formatters: BTreeMap<String, FormatterConfig>,
+}
+
+impl Default for FormatterRegistry {
+    fn default() -> Self {
+        Self::load(&HashMap::new())
+    }
+}
+
+impl FormatterRegistry {
+    pub fn load(configured: &HashMap<String, FormatterConfig>) -> Self {
+        let mut formatters: BTreeMap<String, FormatterConfig> = BUILT_IN_FORMATTERS
+            .iter()
+            .map(|(language, command, args)| {
+                let formatter = FormatterConfig {
+                    command: command.to_string(),
+                    args: args.iter().map(|arg| arg.to_string()).collect(),
+                };
+                (language.to_string(), formatter)
+            })
+            .collect();
+        for (language, formatter) in configured {
+            formatters.insert(language.clone(), formatter.clone());
+        }
+        formatters.retain(|_, formatter| !formatter.command.is_empty());
+        Self { formatters }
+    }
+
+    /// The formatter registered for `language`, if any.
+    pub fn get(&self, language: &str) -> Option<&FormatterConfig> {
+        self.formatters.get(language)
+    }
+
+    /// Each language with a formatter, and its command.
+    pub fn commands(&self) -> BTreeMap<&str, &str> {
+        self.formatters
+            .iter()
+            .map(|(language, formatter)| (language.as_str(), formatter.command.as_str()))
+            .collect()
+    }
+}
+
+/// `text` passed through `command` on stdin, or `None` when it cannot be
+/// started or fails.
+pub async fn run<S: AsRef<str>>(command: &str, args: &[S], text: &str) -> Result<Option<String>> {
+    let Ok(mut child) = TokioCommand::new(command)
+        .args(args.iter().map(AsRef::as_ref))
+        .stdin(Stdio::piped())
+        .stdout(Stdio::piped())
+        .stderr(Stdio::piped())
+        .kill_on_drop(true)
+        .spawn()
+    else {
+        return Ok(None);
+    };
+    if let Some(mut stdin) = child.stdin.take() {
+        stdin.write_all(text.as_bytes()).await?;
+    }
+    let output = child.wait_with_output().await?;
+    if !output.status.success() {
+        return Ok(None);
+    }
+    Ok(Some(String::from_utf8(output.stdout)?))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_config_replaces_and_disables_formatters() {
+        let configured = HashMap::from([
+            (
+                "python".to_string(),
+                FormatterConfig {
+                    command: "ruff".to_string(),
+                    args: vec!["format".to_string(), "-".to_string()],
+                },
+            ),
+            (
+                "html".to_string(),
+                FormatterConfig {
+                    command: String::new(),
+                    args: Vec::new(),
+                },
+            ),
+        ]);
+        let registry = FormatterRegistry::load(&configured);
+        assert_eq!(registry.get("python").unwrap().command, "ruff");
+        assert_eq!(registry.get("go").unwrap().command, "gofmt");
+        assert!(registry.get("html").is_none());
+        assert!(registry.get("kotlin").is_none());
+        assert_eq!(registry.commands()["typescript"], "prettier");
+    }
+
+    #[tokio::test]
+    async fn test_missing_formatter_leaves_text_alone() {
+        let formatted = run("splice-weaver-no-such-formatter", &["-"], "x = 1\n")
+            .await
+            .unwrap();
+        assert_eq!(formatted, None);
+    }
+}
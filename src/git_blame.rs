@@ -0,0 +1,252 @@
+//! When the lines of a match last changed, from `git blame --porcelain`.
+//!
+//! Blame is best effort: a file outside a repository, one git does not
+//! track, or a machine without git simply has no history to report.
+
+use serde_json::Value;
+use std::collections::HashMap;
+use std::path::Path;
+use tokio::process::Command as TokioCommand;
+
+/// The commit that last changed one line.
+#[derive(Debug, Clone, PartialEq)]
+pub struct LineCommit {
+    pub commit: String,
+    pub author: String,
+    /// Author time, in seconds since the epoch
+    pub time: i64,
+    /// Author time zone, such as `+0200`
+    pub tz: String,
+    pub summary: String,
+}
+
+impl LineCommit {
+    /// Whether the line has changed since the last commit; git blames such
+    /// lines on an all-zero commit.
+    pub fn is_uncommitted(&self) -> bool {
+        self.commit.bytes().all(|b| b == b'0')
+    }
+
+    /// The commit as a match's `blame` field.
+    pub fn to_json(&self) -> Value {
+        let mut entry = serde_json::json!({
+            "commit": self.commit,
+            "author": self.author,
+            "date": format_date(self.time, &self.tz),
+            "summary": self.summary,
+        });
+        if self.is_uncommitted() {
+            entry["uncommitted"] = true.into();
+        }
+        entry
+    }
+}
+
+/// The commit of each line in porcelain blame output, by 1-indexed line
+/// number in the current file. Commit details are given only the first
+/// time a commit appears, so they are carried over to its later lines.
+pub fn parse_porcelain(output: &str) -> HashMap<usize, LineCommit> {
+    let mut commits: HashMap<String, LineCommit> = HashMap::new();
+    let mut lines = HashMap::new();
+    let mut current: Option<(String, usize)> = None;
+    for line in output.lines() {
+        // Each line's content, prefixed with a tab, ends its entry
+        if line.starts_with('\t') {
+            if let Some((commit, number)) = current.take() {
+                if let Some(details) = commits.get(&commit) {
+                    lines.insert(number, details.clone());
+                }
+            }
+            continue;
+        }
+        let (key, value) = line.split_once(' ').unwrap_or((line, ""));
+        if current.is_none() {
+            // `<commit> <original line> <final line> [<group size>]`
+            let final_line = value.split(' ').nth(1).and_then(|n| n.parse().ok());
+            if let Some(number) = final_line {
+                commits
+                    .entry(key.to_string())
+                    .or_insert_with(|| LineCommit {
+                        commit: key.to_string(),
+                        author: String::new(),
+                        time: 0,
+                        tz: "+0000".to_string(),
+                        summary: String::new(),
+                    });
+                current = Some((key.to_string(), number));
+            }
+            continue;
+        }
+        let Some(details) = current
+            .as_ref()
+            .and_then(|(commit, _)| commits.get_mut(commit))
+        else {
+            continue;
+        };
+        match key {
+            "author" => details.author = value.to_string(),
+            "author-time" => details.time = value.parse().unwrap_or(0),
+            "author-tz" => details.tz = value.to_string(),
+            "summary" => details.summary = value.to_string(),
+            _ => {}
+        }
+    }
+    lines
+}
+
+/// The most recent commit to any of `lines`, if git knows any of them.
+pub fn latest<'a>(
+    commits: &'a HashMap<usize, LineCommit>,
+    lines: std::ops::RangeInclusive<usize>,
+) -> Option<&'a LineCommit> {
+    lines
+        .filter_map(|line| commits.get(&line))
+        .max_by_key(|commit| (commit.is_uncommitted(), commit.time))
+}
+
+/// Blame the given 1-indexed inclusive line ranges of `file` in one run of
+/// git. `None` if git is missing or cannot blame the file.
+pub async fn blame_lines(
+    file: &Path,
+    ranges: &[(usize, usize)],
+) -> Option<HashMap<usize, LineCommit>> {
+    let directory = file.parent().filter(|dir| !dir.as_os_str().is_empty())?;
+    let mut command = TokioCommand::new("git");
+    command
+        .arg("-C")
+        .arg(directory)
+        .arg("blame")
+        .arg("--porcelain");
+    for (start, end) in ranges {
+        command.arg("-L").arg(format!("{start},{end}"));
+    }
+    let output = command
+        .arg("--")
+        .arg(file.file_name()?)
+        .kill_on_drop(true)
+        .output()
+        .await
+        .ok()?;
+    if !output.status.success() {
+        return None;
+    }
+    Some(parse_porcelain(&String::from_utf8_lossy(&output.stdout)))
+}
+
+/// `time` in the time zone `tz` as an ISO 8601 date, e.g.
+/// `2024-03-05T14:30:00+01:00`.
+pub fn format_date(time: i64, tz: &str) -> String {
+    let sign = if tz.starts_with('-') { -1 } else { 1 };
+    let digits = tz.trim_start_matches(['+', '-']);
+    let (hours, minutes) = match (digits.get(..2), digits.get(2..4)) {
+        (Some(hours), Some(minutes)) => (
+            hours.parse::<i64>().unwrap_or(0),
+            minutes.parse::<i64>().unwrap_or(0),
+        ),
+        _ => (0, 0),
+    };
+    let local = time + sign * (hours * 3600 + minutes * 60);
+    let (days, seconds) = (local.div_euclid(86400), local.rem_euclid(86400));
+
+    // Civil date from days since 1970-01-01 (Howard Hinnant's algorithm)
+    let z = days + 719468;
+    let era = z.div_euclid(146097);
+    let day_of_era = z.rem_euclid(146097);
+    let year_of_era =
+        (day_of_era - day_of_era / 1460 + day_of_era / 36524 - day_of_era / 146096) / 365;
+    let day_of_year = day_of_era - (365 * year_of_era + year_of_era / 4 - year_of_era / 100);
+    let month_index = (5 * day_of_year + 2) / 153;
+    let day = day_of_year - (153 * month_index + 2) / 5 + 1;
+    let month = if month_index < 10 {
+        month_index + 3
+    } else {
+        month_index - 9
+    };
+    let year = year_of_era + era * 400 + i64::from(month <= 2);
+
+    format!(
+        "{year:04}-{month:02}-{day:02}T{:02}:{:02}:{:02}{}{:02}:{:02}",
+        seconds / 3600,
+        seconds % 3600 / 60,
+        seconds % 60,
+        if sign < 0 { '-' } else { '+' },
+        hours,
+        minutes
+    )
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const PORCELAIN: &str = "\
+1f0e9a2c3b4d5e6f708192a3b4c5d6e7f8091a2b 1 1 2
+author Ada
+author-mail <ada@example.com>
+author-time 1709649000
+author-tz +0100
+committer Ada
+committer-time 1709649000
+committer-tz +0100
+summary Add the parser
+filename parse.go
+\tpackage parse
+1f0e9a2c3b4d5e6f708192a3b4c5d6e7f8091a2b 2 2
+\t
+9a8b7c6d5e4f30211203f4e5d6c7b8a9f0e1d2c3 3 3 1
+author Grace
+author-mail <grace@example.com>
+author-time 1712000000
+author-tz -0500
+summary Handle empty input
+previous 1f0e9a2c3b4d5e6f708192a3b4c5d6e7f8091a2b parse.go
+filename parse.go
+\tfunc Parse() {}
+";
+
+    #[test]
+    fn test_parse_porcelain_carries_commit_details() {
+        let lines = parse_porcelain(PORCELAIN);
+        assert_eq!(lines.len(), 3);
+        assert_eq!(lines[&1].author, "Ada");
+        assert_eq!(lines[&2].summary, "Add the parser");
+        assert_eq!(lines[&3].author, "Grace");
+        assert_eq!(lines[&3].tz, "-0500");
+
+        assert_eq!(latest(&lines, 1..=3).unwrap().author, "Grace");
+        assert_eq!(latest(&lines, 1..=2).unwrap().author, "Ada");
+        assert!(latest(&lines, 7..=9).is_none());
+    }
+
+    #[test]
+    fn test_uncommitted_lines_are_the_latest() {
+        let mut lines = parse_porcelain(PORCELAIN);
+        lines.insert(
+            2,
+            LineCommit {
+                commit: "0".repeat(40),
+                author: "Not Committed Yet".to_string(),
+                time: 0,
+                tz: "+0000".to_string(),
+                summary: String::new(),
+            },
+        );
+        let commit = latest(&lines, 1..=3).unwrap();
+        assert_eq!(commit.to_json()["uncommitted"], true);
+        assert!(lines[&3].to_json().get("uncommitted").is_none());
+    }
+
+    #[test]
+    fn test_format_date() {
+        assert_eq!(format_date(0, "+0000"), "1970-01-01T00:00:00+00:00");
+        assert_eq!(
+            format_date(1709649000, "+0100"),
+            "2024-03-05T15:30:00+01:00"
+        );
+        assert_eq!(
+            format_date(1712000000, "-0500"),
+            "2024-04-01T14:33:20-05:00"
+        );
+        assert_eq!(format_date(951782400, "+0000"), "2000-02-29T00:00:00+00:00");
+    }
+}
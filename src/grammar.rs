@@ -0,0 +1,346 @@
+//! Tree-sitter grammars loaded from shared libraries named in the server
+//! config, for languages ast-grep does not bundle.
+//!
+//! ast-grep loads the libraries itself, from the `customLanguages` of an
+//! sgconfig file the server writes and passes with `--config`. Each library
+//! is checked when the config is loaded, so a wrong path or a missing
+//! symbol is reported at startup and by `capabilities`, rather than as a
+//! failed scan later.
+
+use crate::server_config::GrammarConfig;
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::collections::{BTreeMap, HashMap};
+use std::path::Path;
+use tracing::{info, warn};
+
+/// Languages ast-grep bundles, which a custom grammar may not replace.
+const BUNDLED_LANGUAGES: &[&str] = &[
+    "bash",
+    "c",
+    "cpp",
+    "csharp",
+    "css",
+    "elixir",
+    "go",
+    "haskell",
+    "html",
+    "java",
+    "javascript",
+    "json",
+    "kotlin",
+    "lua",
+    "php",
+    "python",
+    "ruby",
+    "rust",
+    "scala",
+    "swift",
+    "tsx",
+    "typescript",
+    "yaml",
+];
+
+/// Name of the function a grammar library exports its language from.
+pub fn language_symbol(name: &str, config: &GrammarConfig) -> String {
+    config
+        .language_symbol
+        .clone()
+        .unwrap_or_else(|| format!("tree_sitter_{}", name.replace('-', "_")))
+}
+
+/// Check that `config` names a shared library exporting the grammar's
+/// language function, without loading it into the server.
+pub fn check_library(name: &str, config: &GrammarConfig) -> Result<()> {
+    if BUNDLED_LANGUAGES.contains(&name) {
+        return Err(anyhow!(
+            "'{}' is a bundled language; register the grammar under another name",
+            name
+        ));
+    }
+    if config.extensions.is_empty() {
+        return Err(anyhow!("no extensions are given for its files"));
+    }
+    let path = &config.library_path;
+    let bytes =
+        std::fs::read(path).map_err(|e| anyhow!("cannot read {}: {}", path.display(), e))?;
+    let shared_library = [
+        b"\x7fELF".as_slice(),
+        b"\xcf\xfa\xed\xfe",
+        b"\xce\xfa\xed\xfe",
+        b"\xca\xfe\xba\xbe",
+        b"MZ",
+    ]
+    .iter()
+    .any(|magic| bytes.starts_with(magic));
+    if !shared_library {
+        return Err(anyhow!(
+            "{} is not a shared library (expected an ELF, Mach-O or PE file)",
+            path.display()
+        ));
+    }
+    // Exported names are stored NUL-terminated in the symbol string table
+    let symbol = language_symbol(name, config);
+    let needle = format!("{symbol}\0");
+    if !bytes
+        .windows(needle.len())
+        .any(|window| window == needle.as_bytes())
+    {
+        return Err(anyhow!(
+            "{} does not export {}; set language_symbol if the grammar uses another name",
+            path.display(),
+            symbol
+        ));
+    }
+    Ok(())
+}
+
+/// An sgconfig file registering `grammars` as ast-grep custom languages.
+pub fn sgconfig_yaml(grammars: &BTreeMap<String, GrammarConfig>) -> Result<String> {
+    let languages: serde_json::Map<String, Value> = grammars
+        .iter()
+        .map(|(name, config)| {
+            let mut language = serde_json::json!({
+                "libraryPath": config.library_path,
+                "extensions": config.extensions,
+                "languageSymbol": language_symbol(name, config),
+            });
+            if let Some(expando) = config.expando_char {
+                language["expandoChar"] = expando.to_string().into();
+            }
+            (name.clone(), language)
+        })
+        .collect();
+    Ok(serde_yaml::to_string(&serde_json::json!({
+        "ruleDirs": [],
+        "customLanguages": languages,
+    }))?)
+}
+
+/// The custom grammars a server was configured with: those that passed
+/// their checks, why the others did not, and the sgconfig file handing the
+/// loaded ones to ast-grep.
+#[derive(Default)]
+pub struct GrammarRegistry {
+    loaded: BTreeMap<String, GrammarConfig>,
+    failed: BTreeMap<String, (GrammarConfig, String)>,
+    sgconfig: Option<tempfile::TempPath>,
+}
+
+impl GrammarRegistry {
+    /// Check each configured grammar and write the sgconfig for those
+    /// that pass. Failures are logged and kept for reporting.
+    pub fn load(grammars: &HashMap<String, GrammarConfig>) -> Self {
+        let mut registry = Self::default();
+        for (name, config) in grammars {
+            match check_library(name, config) {
+                Ok(()) => {
+                    info!(
+                        "Registered grammar {} from {}",
+                        name,
+                        config.library_path.display()
+                    );
+                    registry.loaded.insert(name.clone(), config.clone());
+                }
+                Err(e) => {
+                    warn!("Grammar {} was not registered: {}", name, e);
+                    registry
+                        .failed
+                        .insert(name.clone(), (config.clone(), e.to_string()));
+                }
+            }
+        }
+        if !registry.loaded.is_empty() {
+            let written = sgconfig_yaml(&registry.loaded).and_then(|yaml| {
+                let file = tempfile::Builder::new()
+                    .prefix("sgconfig-")
+                    .suffix(".yml")
+                    .tempfile()?;
+                std::fs::write(file.path(), yaml)?;
+                Ok(file.into_temp_path())
+            });
+            match written {
+                Ok(path) => registry.sgconfig = Some(path),
+                Err(e) => {
+                    warn!("Custom grammars are unavailable: {}", e);
+                    let reason = format!("the sgconfig file could not be written: {e}");
+                    for (name, config) in std::mem::take(&mut registry.loaded) {
+                        registry.failed.insert(name, (config, reason.clone()));
+                    }
+                }
+            }
+        }
+        registry
+    }
+
+    /// The sgconfig file to pass ast-grep, if any grammar is loaded.
+    pub fn sgconfig_path(&self) -> Option<&Path> {
+        self.sgconfig.as_deref()
+    }
+
+    /// Whether `language` is a custom grammar: `Some(Ok)` if it loaded,
+    /// `Some(Err)` with the reason if it did not, `None` if it is not one.
+    pub fn check(&self, language: &str) -> Option<Result<()>> {
+        if self.loaded.contains_key(language) {
+            return Some(Ok(()));
+        }
+        self.failed.get(language).map(|(_, reason)| {
+            Err(anyhow!(
+                "The {} grammar failed to load: {}",
+                language,
+                reason
+            ))
+        })
+    }
+
+    /// The loaded grammar whose files have `extension`.
+    pub fn extension_language(&self, extension: &str) -> Option<&str> {
+        self.loaded
+            .iter()
+            .find(|(_, config)| {
+                config
+                    .extensions
+                    .iter()
+                    .any(|known| known.eq_ignore_ascii_case(extension))
+            })
+            .map(|(name, _)| name.as_str())
+    }
+
+    /// The extension used for snippets of a loaded grammar.
+    pub fn file_extension(&self, language: &str) -> Option<&str> {
+        self.loaded
+            .get(language)
+            .and_then(|config| config.extensions.first())
+            .map(String::as_str)
+    }
+
+    /// Every configured grammar as a `capabilities` language entry.
+    pub fn languages(&self) -> Vec<Value> {
+        let loaded = self
+            .loaded
+            .iter()
+            .map(|(name, config)| (name, config, None));
+        let failed = self
+            .failed
+            .iter()
+            .map(|(name, (config, reason))| (name, config, Some(reason)));
+        loaded
+            .chain(failed)
+            .map(|(name, config, error)| {
+                let mut entry = serde_json::json!({
+                    "name": name,
+                    "supported": error.is_none(),
+                    "extensions": config.extensions,
+                    "aliases": [],
+                    "library": config.library_path,
+                });
+                if let Some(error) = error {
+                    entry["error"] = error.clone().into();
+                }
+                entry
+            })
+            .collect()
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use std::path::PathBuf;
+
+    fn grammar(dir: &Path, file: &str, contents: &[u8]) -> GrammarConfig {
+        let library_path = dir.join(file);
+        std::fs::write(&library_path, contents).unwrap();
+        GrammarConfig {
+            library_path,
+            extensions: vec!["mojo".to_string()],
+            language_symbol: None,
+            expando_char: None,
+            node_types_path: None,
+        }
+    }
+
+    #[test]
+    fn test_check_library() {
+        let dir = tempfile::tempdir().unwrap();
+        let good = grammar(
+            dir.path(),
+            "mojo.so",
+            b"\x7fELF\x02\x01\0tree_sitter_mojo\0",
+        );
+        assert!(check_library("mojo", &good).is_ok());
+
+        let error = check_library(
+            "mojo",
+            &grammar(dir.path(), "a.so", b"\x7fELF\0tree_sitter_mojox\0"),
+        )
+        .unwrap_err()
+        .to_string();
+        assert!(
+            error.contains("does not export tree_sitter_mojo"),
+            "{error}"
+        );
+
+        let renamed = GrammarConfig {
+            language_symbol: Some("tree_sitter_mojox".to_string()),
+            ..grammar(dir.path(), "b.so", b"\x7fELF\0tree_sitter_mojox\0")
+        };
+        assert!(check_library("mojo", &renamed).is_ok());
+
+        let error = check_library("mojo", &grammar(dir.path(), "c.so", b"#!/bin/sh\n"))
+            .unwrap_err()
+            .to_string();
+        assert!(error.contains("not a shared library"), "{error}");
+
+        let missing = GrammarConfig {
+            library_path: PathBuf::from("/nonexistent/mojo.so"),
+            ..good.clone()
+        };
+        assert!(check_library("mojo", &missing)
+            .unwrap_err()
+            .to_string()
+            .contains("cannot read /nonexistent/mojo.so"));
+        assert!(check_library("go", &good).is_err());
+    }
+
+    #[test]
+    fn test_registry_reports_failures() {
+        let dir = tempfile::tempdir().unwrap();
+        let mut grammars = HashMap::new();
+        grammars.insert(
+            "mojo".to_string(),
+            GrammarConfig {
+                expando_char: Some('µ'),
+                ..grammar(dir.path(), "mojo.so", b"\x7fELF\0tree_sitter_mojo\0")
+            },
+        );
+        grammars.insert(
+            "odin".to_string(),
+            grammar(dir.path(), "odin.so", b"\x7fELF\0tree_sitter_mojo\0"),
+        );
+        let registry = GrammarRegistry::load(&grammars);
+
+        assert!(registry.check("mojo").unwrap().is_ok());
+        let error = registry.check("odin").unwrap().unwrap_err().to_string();
+        assert!(error.contains("The odin grammar failed to load"), "{error}");
+        assert!(registry.check("go").is_none());
+        assert_eq!(registry.extension_language("MOJO"), Some("mojo"));
+        assert_eq!(registry.file_extension("mojo"), Some("mojo"));
+        assert_eq!(registry.file_extension("odin"), None);
+
+        let sgconfig = std::fs::read_to_string(registry.sgconfig_path().unwrap()).unwrap();
+        let parsed: Value = serde_yaml::from_str(&sgconfig).unwrap();
+        let mojo = &parsed["customLanguages"]["mojo"];
+        assert_eq!(mojo["languageSymbol"].as_str(), Some("tree_sitter_mojo"));
+        assert_eq!(mojo["expandoChar"].as_str(), Some("µ"));
+        assert!(parsed["customLanguages"].get("odin").is_none());
+
+        let languages = registry.languages();
+        assert_eq!(languages[0]["supported"], true);
+        assert_eq!(languages[1]["name"], "odin");
+        assert!(languages[1]["error"]
+            .as_str()
+            .unwrap()
+            .contains("does not export"));
+    }
+}
@@ -1,6 +1,31 @@
 pub mod ast_grep_tools;
+pub mod atomic_write;
 pub mod benchmark_utils;
 pub mod binary_manager;
+pub mod build_constraint;
+pub mod dialect;
+pub mod edit_guard;
+pub mod edit_plan;
+pub mod edit_utils;
+pub mod embedded;
 pub mod evaluation_client;
+pub mod file_lock;
+pub mod formatter;
+pub mod git_blame;
+pub mod grammar;
+pub mod markup;
+pub mod match_explain;
+pub mod match_filter;
+pub mod node_tree;
+pub mod node_types;
+pub mod operation_context;
+pub mod operation_log;
+pub mod ripgrep_json;
+pub mod rule_check;
+pub mod server_config;
 pub mod simple_search;
 pub mod snapshot_utils;
+pub mod structure;
+pub mod text_encoding;
+pub mod toml_doc;
+pub mod unified_diff;
@@ -4,11 +4,38 @@ use std::sync::Arc;
 use tracing::{error, info};
 
 mod ast_grep_tools;
+mod atomic_write;
 mod binary_manager;
+mod build_constraint;
+mod dialect;
+mod edit_guard;
+mod edit_plan;
+mod edit_utils;
+mod embedded;
 pub mod evaluation_client;
+mod file_lock;
+mod formatter;
+mod git_blame;
+mod grammar;
+mod markup;
+mod match_explain;
+mod match_filter;
+mod node_tree;
+mod node_types;
+mod operation_context;
+mod operation_log;
+mod ripgrep_json;
+mod rule_check;
+mod server_config;
 mod simple_search;
+mod structure;
+mod text_encoding;
+mod toml_doc;
+mod unified_diff;
 use ast_grep_tools::AstGrepTools;
 use binary_manager::BinaryManager;
+use operation_context::OperationContext;
+use server_config::ServerConfig;
 
 // All functionality now handled by AstGrepTools
 
@@ -52,6 +79,21 @@ impl AstGrepServer {
         let binary_manager =
             Arc::new(BinaryManager::new().expect("Failed to initialize binary manager"));
         let tools = Arc::new(AstGrepTools::new(binary_manager));
+        tools.set_config(ServerConfig::load());
+        tools.set_tool_options(
+            Self::tool_list()
+                .iter()
+                .map(|tool| {
+                    let options = tool
+                        .input_schema
+                        .get("properties")
+                        .and_then(|properties| properties.as_object())
+                        .map(|properties| properties.keys().cloned().collect())
+                        .unwrap_or_default();
+                    (tool.name.to_string(), options)
+                })
+                .collect(),
+        );
 
         // Set a default root to the current directory
         if let Ok(current_dir) = std::env::current_dir() {
@@ -64,34 +106,83 @@ impl AstGrepServer {
 
         Self { tools }
     }
-}
 
-impl ServerHandler for AstGrepServer {
-    fn get_info(&self) -> InitializeResult {
-        InitializeResult {
-            protocol_version: ProtocolVersion::V_2024_11_05,
-            capabilities: ServerCapabilities::builder()
-                .enable_tools()
-                .enable_resources()
-                .enable_prompts()
-                .build(),
-            server_info: Implementation {
-                name: "splice-weaver-mcp".to_string(),
-                version: "0.1.0".to_string(),
-            },
-            instructions: Some(
-                "Splice Weaver MCP - ast-grep server with scope navigation and rule execution tools"
-                    .to_string(),
-            ),
-        }
+    /// The `returnEditedNode` option of the tools that write files.
+    fn return_edited_node_property() -> serde_json::Value {
+        serde_json::json!({
+            "type": "boolean",
+            "description": "After writing, also return the node now at each edit's place in the reparsed file (kind, range and text), to check the result without reading it back",
+            "default": false
+        })
     }
 
-    async fn list_tools(
-        &self,
-        _request: Option<PaginatedRequestParam>,
-        _context: RequestContext<RoleServer>,
-    ) -> Result<ListToolsResult, rmcp::Error> {
-        let tools = vec![
+    /// The `dry_run` option of the tools that edit one file.
+    fn dry_run_property() -> serde_json::Value {
+        serde_json::json!({
+            "type": "boolean",
+            "description": "If true, return the new content without writing the file",
+            "default": true
+        })
+    }
+
+    /// The `force` option of the tools that write files.
+    fn force_property() -> serde_json::Value {
+        serde_json::json!({
+            "type": "boolean",
+            "description": "Edit files marked as generated (protected regions are never edited)",
+            "default": false
+        })
+    }
+
+    /// The `fileEncoding` option of a tool reading one target file, and
+    /// writing it back if `writes`.
+    fn file_encoding_property(writes: bool) -> serde_json::Value {
+        let on_write = if writes { " and kept on write" } else { "" };
+        serde_json::json!({
+            "type": "string",
+            "enum": ["utf-8", "latin-1"],
+            "description": format!("Encoding of the target file; a UTF-8 byte order mark is stripped before parsing{on_write}"),
+            "default": "utf-8"
+        })
+    }
+
+    /// The `contentEncoding` option of a tool taking `code`, and returning
+    /// the edited content if `returns_content`.
+    fn content_encoding_property(returns_content: bool) -> serde_json::Value {
+        let returned = if returns_content {
+            " (and content returned)"
+        } else {
+            ""
+        };
+        serde_json::json!({
+            "type": "string",
+            "enum": ["utf-8", "utf-16"],
+            "description": format!("Set to utf-16 when code is sent{returned} as base64 UTF-16LE"),
+            "default": "utf-8"
+        })
+    }
+
+    /// The `offsetEncoding` option, counting `units_for` in its units.
+    fn offset_encoding_property(units_for: &str) -> serde_json::Value {
+        serde_json::json!({
+            "type": "string",
+            "enum": ["utf-8", "utf-16"],
+            "description": format!("Units for {units_for}"),
+            "default": "utf-8"
+        })
+    }
+
+    /// The `dialect` option of the tools that parse files by extension.
+    fn dialect_property() -> serde_json::Value {
+        serde_json::json!({
+            "type": "string",
+            "description": "Parse this call's files with a grammar variant instead of the one their extension selects: c or cpp (e.g. a .h header as C++), or typescript or tsx (also jsx; JSX in .js and .ts files). Rules written for the variant's languages run on its grammar, and the result names the dialect"
+        })
+    }
+
+    /// Every tool the server offers, with its input schema.
+    fn tool_list() -> Vec<Tool> {
+        vec![
             Tool::new(
                 "find_scope",
                 "Find containing scope around a position using relational rules",
@@ -147,11 +238,157 @@ impl ServerHandler for AstGrepServer {
                             "type": "boolean",
                             "description": "If true, preview changes without applying",
                             "default": true
-                        }
+                        },
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "matchBraceStyle": {
+                            "type": "boolean",
+                            "description": "For replace: make inserted braces follow the file's existing style (Go is run through gofmt instead)",
+                            "default": false
+                        },
+                        "format": {
+                            "type": "boolean",
+                            "description": "For replace: run each edited file through its language's formatter (gofmt, rustfmt, black or prettier unless splice-weaver.yaml names another); a file whose formatter is missing is written unformatted and reports formatted: false",
+                            "default": false
+                        },
+                        "keepOriginalAsComment": {
+                            "type": "boolean",
+                            "description": "For replace: keep each replaced node's original text as a '// before:' comment (in the language's line comment syntax) below the line the replacement ends on",
+                            "default": false
+                        },
+                        "preserveBlankLines": {
+                            "type": "boolean",
+                            "description": "For replace: Keep the blank lines around each match as they are: line breaks at the edges of the fix text are dropped, and those the replaced range took in are kept. Set false to write fixes exactly as ast-grep renders them",
+                            "default": true
+                        },
+                        "formatEditedOnly": {
+                            "type": "boolean",
+                            "description": "With format, or matchBraceStyle on Go: format only the statements around each fix instead of the whole file, so untouched lines stay as they are (Go only, since gofmt formats fragments; other languages are formatted whole); falls back to the whole file when a fix is not inside a statement on lines of its own or a fragment fails to format. Each file reports format_scope 'edited' or 'file'",
+                            "default": false
+                        },
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of the target's files; a UTF-8 byte order mark is stripped before parsing and kept on write",
+                            "default": "utf-8"
+                        },
+                        "timeoutMs": {
+                            "type": "number",
+                            "description": "Stop after this many milliseconds; replace returns the files finished so far with status 'timed_out'"
+                        },
+                        "dialect": Self::dialect_property(),
+                        "filter": {
+                            "type": "string",
+                            "description": "Keep only matches passing these pseudo-classes: ':longer-than(N)' (more than N characters of text), ':spanning-lines(N)' (at least N lines, counting first and last), ':depth(N)' (N named nodes below the root, so the root's named children are at depth 1), ':top-level' (depth 1) and ':contains(S)' (a node at any depth inside the match that S picks, where S is a node kind, ':matches(/regex/)' on its text, or both, as in 'call_expression:matches(/panic/)'; unlike a rule's has, not just direct children); chained ones must all hold, e.g. ':top-level :spanning-lines(50)'"
+                        },
+                        "includeBlame": {
+                            "type": "boolean",
+                            "description": "For search/scan: add each match's blame, the most recent commit to change its lines (commit, author, date, summary; uncommitted: true for changes not yet committed), from git blame. Omitted for files git does not track",
+                            "default": false
+                        },
+                        "includeScope": {
+                            "type": "boolean",
+                            "description": "For search/scan: add each match's scope: its kind (package/module/file at the top level, type, impl, function, method, or block inside a block statement), the enclosing scope node, the nearest scope name, and the path of names from the top, e.g. [\"Server\", \"Start\"]",
+                            "default": false
+                        },
+                        "includeGenerics": {
+                            "type": "boolean",
+                            "description": "For search/scan in Go or Rust: add each match's generics, the generic instantiations and macro invocations inside it: kind (call, value, type or macro), name, qualifier, line, and type_arguments (arguments for a macro). For a generic declared in the same file, also its declared_line, type_parameters, bindings from parameter to argument, and the parameters left inferred; e.g. Max[int](a, b) binds T to int. Calls without type arguments are listed only for such generics",
+                            "default": false
+                        },
+                        "explain": {
+                            "type": "boolean",
+                            "description": "For search/scan: add each match's explanation of why it matched, to debug a rule that over- or under-matches: the atomic checks it passed (kind, pattern, regex, ...), each inside/has/follows/precedes step with the node it found (steps under all are included, nested steps are traced from their parent's node), the constraints with the values they were checked against, the filter it passed, and the captured metavariables",
+                            "default": false
+                        },
+                        "nodeIds": {
+                            "type": "boolean",
+                            "description": "For search/scan: add each match's nodeId, its path of 0-based named-child indices from the root (e.g. '/12/3/0'). Unlike offsets it stays valid across edits that leave the path's nodes in place; look it up again with resolve_node_id",
+                            "default": false
+                        },
+                        "globalIndex": {
+                            "type": "boolean",
+                            "description": "For search/scan: sort matches by file path, then position in the file, and number them with globalIndex; target one later with resolve_match_index",
+                            "default": false
+                        },
+                        "buildTags": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "For search/scan: Go build tags such as [\"linux\", \"amd64\"]; matches in Go files that do not build with them, by file name suffix or //go:build line, are dropped"
+                        },
+                        "byteRange": {
+                            "type": "object",
+                            "properties": {
+                                "start": {"type": "number"},
+                                "end": {"type": "number"}
+                            },
+                            "required": ["start", "end"],
+                            "description": "For search/scan of one file: parse only these UTF-8 bytes, widened to whole top-level items, and return matches overlapping them with whole-file offsets. Faster on very large files; structure crossing the widened window may be incomplete"
+                        },
+                        "outputFormat": {
+                            "type": "string",
+                            "enum": ["ast-grep", "ripgrep", "lsp"],
+                            "description": "For search/scan: 'ripgrep' emits line-delimited ripgrep --json events with line-relative submatch offsets; 'lsp' emits [{uri, ranges}] with LSP Ranges (zero-based lines, UTF-16 characters) for editor selections",
+                            "default": "ast-grep"
+                        },
+                        "force": Self::force_property()
                     },
                     "required": ["rule_config", "target"]
                 })).unwrap()
             ),
+            Tool::new(
+                "validate_rule",
+                "Check that a rule config (and optionally a filter) is well-formed without running it. Returns valid: true with the normalized rule and the node kinds, fields, metavariables and utils it refers to, or valid: false with an error giving its stage (yaml, rule, language or filter), the path of the offending key such as rule.has.kind, and its line and column. Unknown keys get the closest valid one suggested. Whether kinds exist in the grammar and patterns parse is only known when the rule runs",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "rule_config": {
+                            "type": "string",
+                            "description": "YAML rule configuration, as for execute_rule"
+                        },
+                        "filter": {
+                            "type": "string",
+                            "description": "Filter to check as well, as for execute_rule, e.g. ':spanning-lines(50)'"
+                        }
+                    },
+                    "required": ["rule_config"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "structural_replace",
+                "Replace every match of an ast-grep pattern in one file with a replacement template, e.g. pattern '$A.Close($B)' and replacement 'Close($A, $B)'. Each metavariable in the replacement is filled with the exact source text it captured, so captures can be reordered, reused or dropped. A metavariable repeated in the pattern only matches when every occurrence is the same code. Metavariables the pattern does not capture are refused. Matches nested in another match are skipped; run it again for those",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to rewrite (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File to rewrite (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "pattern": {
+                            "type": "string",
+                            "description": "ast-grep pattern to match, with $NAME for one node and $$$NAME for a list"
+                        },
+                        "replacement": {
+                            "type": "string",
+                            "description": "Text each match becomes, using the pattern's metavariables"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(false),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    },
+                    "required": ["language", "pattern", "replacement"]
+                })).unwrap()
+            ),
             Tool::new(
                 "search_examples",
                 "Search ast-grep rule examples with pagination support",
@@ -234,21 +471,2913 @@ impl ServerHandler for AstGrepServer {
                     "required": ["description"]
                 })).unwrap()
             ),
-        ];
-
-        Ok(ListToolsResult::with_all_items(tools))
-    }
-
-    async fn call_tool(
-        &self,
-        request: CallToolRequestParam,
-        _context: RequestContext<RoleServer>,
-    ) -> Result<CallToolResult, rmcp::Error> {
-        let args = request
-            .arguments
-            .map(serde_json::Value::Object)
-            .unwrap_or(serde_json::Value::Null);
-        match self.tools.call_tool(&request.name, args).await {
+            Tool::new(
+                "insert_import",
+                "Insert an import/using statement in sorted order among existing imports",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "target": {
+                            "type": "string",
+                            "description": "File path to add the import to"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (csharp, java, rust, python, javascript, typescript, swift)"
+                        },
+                        "import": {
+                            "type": "string",
+                            "description": "Full import statement (e.g., 'using System.Linq;')"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "utf-16"],
+                            "description": "Set to utf-16 to get the previewed content as base64 UTF-16LE",
+                            "default": "utf-8"
+                        }
+                    },
+                    "required": ["target", "language", "import"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "replace_node",
+                "Replace the function (or node kind) at a position with new source, adding the imports it needs in the same edit. Go imports are import paths, placed in the standard library or third-party group as goimports would; other languages take whole import statements as insert_import does. Fails if the result does not parse",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the node (or use start_byte/end_byte)"
+                        },
+                        "start_byte": {
+                            "type": "number",
+                            "description": "Start of a byte range inside the node"
+                        },
+                        "end_byte": {
+                            "type": "number",
+                            "description": "End of the byte range (defaults to start_byte)"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Node kind to replace (default: the language's function kinds)"
+                        },
+                        "replacement": {
+                            "type": "string",
+                            "description": "New source for the node, written from column 0; lines after the first are indented to the node's line"
+                        },
+                        "imports": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Imports the replacement needs; those already present are left alone. In Go, a path with an optional alias (e.g. 'time', 'errors', 'log \"github.com/x/log\"'); elsewhere a full import statement (e.g. 'use std::fmt;')"
+                        },
+                        "keepOriginalAsComment": {
+                            "type": "boolean",
+                            "description": "Keep the node's original text as a '// before:' comment (in the language's line comment syntax) below the replacement, for review",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["language", "replacement"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "check_go_imports",
+                "Report problems in a Go file's imports with their ranges: a path imported twice under the same name, one path imported under different names, and blank (_) imports. Changes nothing unless fix asks for dedupe (delete the repeated imports) or consolidate (merge the import declarations into one sorted group)",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to check (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to check (or use code)"
+                        },
+                        "fix": {
+                            "type": "array",
+                            "items": {"type": "string", "enum": ["dedupe", "consolidate"]},
+                            "description": "Fixes to make: dedupe deletes imports repeated under the same name and blank imports of packages imported by name; consolidate merges all import declarations into one group"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "get_enclosing_function",
+                "Find the function, method, or closure containing a position (null at file scope)",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to search within (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to search within (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position to look up (or use start_byte/end_byte)"
+                        },
+                        "start_byte": {
+                            "type": "number",
+                            "description": "Start of the byte range to look up"
+                        },
+                        "end_byte": {
+                            "type": "number",
+                            "description": "End of the byte range to look up (defaults to start_byte)"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte/end_byte, position columns, and returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "clear_rule_cache",
+                "Clear cached rule configs so the next run re-validates them",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {}
+                })).unwrap()
+            ),
+            Tool::new(
+                "inline_variable",
+                "Inline a single-assignment local variable: replace each read with its initializer and remove the declaration",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to refactor (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the variable to inline"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the declaration, needed when the name is declared more than once"
+                        },
+                        "side_effect_free": {
+                            "type": "boolean",
+                            "description": "Vouch that the initializer has no side effects and is safe to duplicate (required unless it is obviously pure)",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte/end_byte, position columns, and returned ranges")
+                    },
+                    "required": ["language", "name"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "replace_comment",
+                "Replace the doc comment above a declaration, or insert one if it has none",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the declaration whose doc comment to replace"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Node kind of the declaration, when the name alone is ambiguous (e.g., 'function_item')"
+                        },
+                        "comment": {
+                            "type": "string",
+                            "description": "New comment text without comment markers; the language's prefix is added to each line"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["language", "name", "comment"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "detect_language",
+                "Report which language the server would use for a file, how it was detected (extension, shebang, override), and whether it is supported",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "path": {
+                            "type": "string",
+                            "description": "File path to check; it does not need to exist"
+                        },
+                        "content": {
+                            "type": "string",
+                            "description": "File content, used for shebang detection when the extension is unknown (read from path if omitted)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Explicit language override to check"
+                        },
+                        "dialect": {
+                            "type": "string",
+                            "description": "Grammar variant to check the file against, as other tools take it (e.g. cpp for a .h header); one that does not apply to the file's language is an error, and the result names the one chosen"
+                        }
+                    },
+                    "required": ["path"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "parse_embedded",
+                "Parse code embedded in a string literal (e.g. SQL in a Go raw string), an HTML <script> element, or a Markdown code block as another language, and run a rule over it with offsets mapped back to the parent file",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code containing the embedded code (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path containing the embedded code (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Language of the parent file (e.g., 'go', 'python', 'html', or 'markdown')"
+                        },
+                        "embedded_language": {
+                            "type": "string",
+                            "description": "Language to parse the embedded code as (e.g., 'javascript'); for Markdown, defaults to the language the fence names"
+                        },
+                        "block": {
+                            "type": "integer",
+                            "description": "For Markdown, 0-based index of the code block (instead of position), as listed by markdown_code_blocks"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the string literal or element (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the string literal or element"
+                        },
+                        "rule_config": {
+                            "type": "string",
+                            "description": "YAML rule for embedded_language; without it only the embedded region is returned"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte, position columns, and returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "compute_edit_plan",
+                "Run a rule with a fix and save the resulting edits to a JSON plan file instead of applying them; apply it later with apply_edits_from_file",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "rule_config": {
+                            "type": "string",
+                            "description": "YAML rule configuration with a fix"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File or directory path to compute edits for"
+                        },
+                        "plan_path": {
+                            "type": "string",
+                            "description": "Where to write the plan file"
+                        },
+                        "preserveBlankLines": {
+                            "type": "boolean",
+                            "description": "Keep the blank lines around each match as they are: line breaks at the edges of the fix text are dropped, and those the replaced range took in are kept. Set false to write fixes exactly as ast-grep renders them",
+                            "default": true
+                        },
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of the target's files; a UTF-8 byte order mark is stripped before parsing. Pass the same value to apply_edits_from_file",
+                            "default": "utf-8"
+                        }
+                    },
+                    "required": ["rule_config", "target", "plan_path"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "apply_edits_from_file",
+                "Apply an edit plan file written by compute_edit_plan; refused if any planned file changed since the plan was computed",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "plan_path": {
+                            "type": "string",
+                            "description": "Path of the plan file"
+                        },
+                        "dry_run": {
+                            "type": "boolean",
+                            "description": "If true, check the plan without writing any file",
+                            "default": true
+                        },
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "timeoutMs": {
+                            "type": "number",
+                            "description": "Stop after this many milliseconds, returning the files finished so far with status 'timed_out'"
+                        },
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of the planned files; a UTF-8 byte order mark is stripped before editing and kept on write",
+                            "default": "utf-8"
+                        }
+                    },
+                    "required": ["plan_path"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "insert_member",
+                "Insert a method or property as the last member of a named class, struct, protocol, or impl block, indented like its existing members",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (swift, java, csharp, javascript, typescript, rust, cpp)"
+                        },
+                        "type_name": {
+                            "type": "string",
+                            "description": "Name of the type to add the member to (the implemented type for Rust impl blocks)"
+                        },
+                        "member": {
+                            "type": "string",
+                            "description": "Source of the member; it is re-indented to fit the type body"
+                        },
+                        "line": {
+                            "type": "integer",
+                            "description": "1-indexed line the declaration starts on, when the type is declared more than once (e.g. a Swift extension)"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["language", "type_name", "member"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "insert_at",
+                "Insert top-level code at a named place in a file instead of next to a node: after_package (after the package clause, or the file's header of shebang, docstring and leading comments where there is none), after_imports (after the last import, falling back to after_package), before_first_declaration (before the first code after the imports and its comment, falling back to end_of_file) or end_of_file. The code is set apart by blank lines; the output gives the place it resolved to and its line. The result must parse no worse than the file did",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (go, java, rust, python, javascript, typescript, csharp, swift, c, cpp)"
+                        },
+                        "anchor": {
+                            "type": "string",
+                            "enum": ["after_package", "after_imports", "before_first_declaration", "end_of_file"],
+                            "description": "Where to insert; hyphens may be used for underscores"
+                        },
+                        "text": {
+                            "type": "string",
+                            "description": "Code to insert, as it should appear at the top level"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["language", "anchor", "text"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_html_elements",
+                "List the elements of an HTML document with a given tag, id, class, or attribute, with their attributes, class lists, and ranges",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "HTML to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "HTML file to search (or use code)"
+                        },
+                        "tag": {
+                            "type": "string",
+                            "description": "Tag name, matched without regard to case"
+                        },
+                        "id": {
+                            "type": "string",
+                            "description": "Value of the id attribute"
+                        },
+                        "class": {
+                            "type": "string",
+                            "description": "A name the class attribute must contain"
+                        },
+                        "attribute": {
+                            "type": "object",
+                            "description": "An attribute the element must have, and optionally its value",
+                            "properties": {
+                                "name": {"type": "string"},
+                                "value": {"type": "string"}
+                            },
+                            "required": ["name"]
+                        },
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "edit_html_class",
+                "Add names to or remove them from the class attribute of HTML elements chosen by tag, id, class, attribute, or position. The attribute is created when missing and removed when emptied",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "HTML to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "HTML file to edit (or use code)"
+                        },
+                        "tag": {
+                            "type": "string",
+                            "description": "Select elements by tag name"
+                        },
+                        "id": {
+                            "type": "string",
+                            "description": "Select the element with this id"
+                        },
+                        "class": {
+                            "type": "string",
+                            "description": "Select elements whose class attribute contains this name"
+                        },
+                        "attribute": {
+                            "type": "object",
+                            "description": "Select elements with this attribute, and optionally this value",
+                            "properties": {
+                                "name": {"type": "string"},
+                                "value": {"type": "string"}
+                            },
+                            "required": ["name"]
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the element (or use tag, id, class, or attribute); the innermost one is edited",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "add": {
+                            "type": ["string", "array"],
+                            "items": {"type": "string"},
+                            "description": "Class names to add at the end of the list"
+                        },
+                        "remove": {
+                            "type": ["string", "array"],
+                            "items": {"type": "string"},
+                            "description": "Class names to remove"
+                        },
+                        "all": {
+                            "type": "boolean",
+                            "description": "Edit every selected element; otherwise more than one is an error",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "offsetEncoding": Self::offset_encoding_property("position columns"),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_css_rules",
+                "List the rule sets of a stylesheet with their selectors and declarations, optionally only those a selector picks or that set a property",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "CSS to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "CSS file to search (or use code)"
+                        },
+                        "selector": {
+                            "type": "string",
+                            "description": "A selector list that must equal the rule's, or one selector in it (whitespace is ignored)"
+                        },
+                        "property": {
+                            "type": "string",
+                            "description": "Only rules that declare this property"
+                        },
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "edit_css_declaration",
+                "Set or remove a declaration in the CSS rule set a selector picks. An existing declaration gets the new value; otherwise one is added after the rule's last declaration",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "CSS to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "CSS file to edit (or use code)"
+                        },
+                        "selector": {
+                            "type": "string",
+                            "description": "Selector of the rule set: its whole selector list, or one selector in it"
+                        },
+                        "line": {
+                            "type": "integer",
+                            "description": "1-indexed line the rule set starts on, when the selector picks more than one"
+                        },
+                        "property": {
+                            "type": "string",
+                            "description": "Property to set or remove, e.g. margin or --accent"
+                        },
+                        "value": {
+                            "type": "string",
+                            "description": "New value; it replaces the whole value, !important included"
+                        },
+                        "remove": {
+                            "type": "boolean",
+                            "description": "Remove the declaration instead of setting it",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["selector", "property"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_toml_keys",
+                "List the tables of a TOML file (e.g. Cargo.toml, pyproject.toml), with their keys, or the values a selector picks, such as table[name=dependencies] > pair[key=serde] > pair[key=version]. A selector's path is found however the file spells it: as a [table.subtable] header, a dotted key or an inline table. Arrays of tables take [index=N], counting from 0",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "TOML to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "TOML file to search (or use code)"
+                        },
+                        "selector": {
+                            "type": "string",
+                            "description": "table[name=a.b][index=N] and pair[key=c] steps joined by '>'; a quoted name or key may hold dots. Omit to list every table"
+                        },
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "edit_toml_value",
+                "Set the value of the TOML key a selector picks, e.g. table[name=dependencies] > pair[key=serde] > pair[key=version]. Only the value is replaced, keeping spacing, trailing comments and literal quotes. A missing key is added to its table (in order when the keys are sorted, after the last key otherwise) or to its inline table; a missing table is appended",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "TOML to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "TOML file to edit (or use code)"
+                        },
+                        "selector": {
+                            "type": "string",
+                            "description": "table[name=a.b][index=N] and pair[key=c] steps joined by '>', ending at the key to set"
+                        },
+                        "value": {
+                            "description": "New value as JSON: strings are quoted, arrays stay arrays and objects become inline tables"
+                        },
+                        "raw": {
+                            "type": "boolean",
+                            "description": "Take value as TOML text to insert as written, e.g. a date or { path = \"../x\" }",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "force": Self::force_property(),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["selector", "value"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "rewrite_returns",
+                "Apply a template to the value of every return statement in one function, e.g. wrap each returned expression in Ok(...)",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'rust', 'go', 'typescript')"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function whose returns to rewrite (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "template": {
+                            "type": "string",
+                            "description": "Replacement for each returned value, with $EXPR standing for the original expression (e.g., 'Ok($EXPR)')"
+                        },
+                        "skipNested": {
+                            "type": "boolean",
+                            "description": "Leave returns inside nested functions and closures alone, since they return from those instead",
+                            "default": true
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["language", "template"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "file_outline",
+                "List a file's top-level functions, types, methods, and variables with their names, receivers, and ranges in document order",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to outline (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to outline (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "dialect": Self::dialect_property(),
+                        "depth": {
+                            "type": "number",
+                            "description": "Also list declarations nested up to this many levels inside others (e.g., 1 for methods inside classes); 0 lists top-level declarations only",
+                            "default": 0
+                        },
+                        "group_methods": {
+                            "type": "boolean",
+                            "description": "List Go methods under the type their receiver names (pointer and value receivers alike) instead of at the top level",
+                            "default": false
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "dump_tree",
+                "Dump the syntax tree of code as compact JSON: each named node's kind, id and lines, with the text of leaves. includeTypes/excludeTypes prune it to the kinds of interest, keeping the path to them. maxTotalBytes caps the whole dump; nodes are then expanded breadth-first, so top-level structure comes first and nodes left unexpanded are marked truncated with their childCount",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to dump (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to dump (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'go')"
+                        },
+                        "includeTypes": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Show only nodes of these kinds (e.g., ['function_declaration', 'call_expression']) and the nodes on the path to them; a shown node whose children are all pruned is dumped as a leaf with its text"
+                        },
+                        "excludeTypes": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Drop nodes of these kinds, unless they are on the path to a node that is shown"
+                        },
+                        "maxTotalBytes": {
+                            "type": "integer",
+                            "description": "Largest size of the nodes list in bytes of compact JSON; without it the whole tree is dumped"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false)
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "file_skeleton",
+                "Return a file folded for an overview: function and method bodies become { ... } (... in Python) and long array, map and struct literals are folded to their brackets, keeping imports, signatures, type declarations and comments outside bodies",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to fold (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to fold (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "maxBodyLines": {
+                            "type": "number",
+                            "description": "Keep function bodies spanning at most this many lines; 0 folds every body",
+                            "default": 1
+                        },
+                        "maxBlockLines": {
+                            "type": "number",
+                            "description": "Keep literals spanning at most this many lines; 0 folds every literal",
+                            "default": 20
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "after_comment",
+                "Find the nodes directly following a comment that matches a regex, e.g. regions tagged '// BEGIN generated'",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to search (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "regex": {
+                            "type": "string",
+                            "description": "Regex the comment text must match (e.g., 'BEGIN generated')"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Only return following nodes of this kind (e.g., 'function_declaration')"
+                        },
+                        "max_blank_lines": {
+                            "type": "number",
+                            "description": "Most blank lines allowed between the comment and the node",
+                            "default": 1
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    },
+                    "required": ["language", "regex"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_comments",
+                "Find comments matching a regex (TODO, FIXME and XXX by default) in a file or directory, with the declaration each is inside or directly above, e.g. a TODO in function foo. Only comment nodes are searched, so string literals mentioning TODO are not matches. With includeBlame, each also gets the last commit to change it",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File or directory to search (or use code); a directory is searched for files of language"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "regex": {
+                            "type": "string",
+                            "description": "Regex the comment text must match",
+                            "default": "\\b(TODO|FIXME|XXX)\\b"
+                        },
+                        "includeBlame": {
+                            "type": "boolean",
+                            "description": "Add each comment's blame, the most recent commit to change its lines (commit, author, date, summary), from git blame. Omitted for files git does not track",
+                            "default": false
+                        },
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of a target file (directories are read as UTF-8); a UTF-8 byte order mark is stripped before parsing",
+                            "default": "utf-8"
+                        },
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "apply_unified_diff",
+                "Apply a unified diff (diff -u / git diff) to the files it names; context lines must match, and patched files are re-parsed to check they are still valid",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "diff": {
+                            "type": "string",
+                            "description": "Unified diff with ---/+++ headers and @@ hunks; a/ and b/ path prefixes are stripped"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File to patch instead of the path in the diff's header (single-file diffs only)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Language to re-parse the patched file as (default: detected from the file extension)"
+                        },
+                        "dry_run": {
+                            "type": "boolean",
+                            "description": "If true, return the patched content without writing files",
+                            "default": true
+                        },
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of the patched files; a UTF-8 byte order mark is stripped before patching and kept on write",
+                            "default": "utf-8"
+                        },
+                        "contentEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "utf-16"],
+                            "description": "Set to utf-16 to get the previewed content as base64 UTF-16LE",
+                            "default": "utf-8"
+                        }
+                    },
+                    "required": ["diff"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "apply_go_edits",
+                "Apply edits to one or more Go files as a single change and tidy the result: every edited file must parse, then goimports adds missing imports, drops unused ones and formats the file. Without goimports installed, files are formatted with gofmt and a warning says imports were not updated. Nothing is written unless every file parses and tidies cleanly. Preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "files": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "path": {"type": "string", "description": "Go file to edit"},
+                                    "edits": {
+                                        "type": "array",
+                                        "items": {
+                                            "type": "object",
+                                            "properties": {
+                                                "start": {"type": "integer", "description": "Byte offset where the edit starts"},
+                                                "end": {"type": "integer", "description": "Byte offset where the edit ends"},
+                                                "replacement": {"type": "string"}
+                                            },
+                                            "required": ["start", "end", "replacement"]
+                                        },
+                                        "description": "Edits at byte offsets into the file as it is now; they must not overlap"
+                                    }
+                                },
+                                "required": ["path", "edits"]
+                            },
+                            "description": "Files and the edits to make in each"
+                        },
+                        "imports": {
+                            "type": "boolean",
+                            "description": "Run goimports on the edited files; set to false to only format them with gofmt",
+                            "default": true
+                        },
+                        "dry_run": {
+                            "type": "boolean",
+                            "description": "If true, return the tidied content without writing files",
+                            "default": true
+                        },
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "latin-1"],
+                            "description": "Encoding of the files; a UTF-8 byte order mark is stripped before editing and kept on write",
+                            "default": "utf-8"
+                        },
+                        "contentEncoding": {
+                            "type": "string",
+                            "enum": ["utf-8", "utf-16"],
+                            "description": "Set to utf-16 to get the previewed content as base64 UTF-16LE",
+                            "default": "utf-8"
+                        }
+                    },
+                    "required": ["files"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "write_patch",
+                "Write edits as a git-format patch (diff --git headers, index lines with blob ids, and hunks) that git apply accepts, for review instead of applying them. Takes an edit plan from compute_edit_plan, or files given as edits or whole new contents; files that do not exist yet are added. Several files go in one patch, with paths relative to the workspace root",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "plan_path": {
+                            "type": "string",
+                            "description": "Edit plan file written by compute_edit_plan (or use files); refused if any planned file changed since"
+                        },
+                        "files": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "path": {"type": "string", "description": "File the edits apply to"},
+                                    "edits": {
+                                        "type": "array",
+                                        "items": {
+                                            "type": "object",
+                                            "properties": {
+                                                "start": {"type": "integer"},
+                                                "end": {"type": "integer"},
+                                                "replacement": {"type": "string"}
+                                            },
+                                            "required": ["start", "end", "replacement"]
+                                        },
+                                        "description": "Byte-range edits of the file's current contents"
+                                    },
+                                    "content": {"type": "string", "description": "Whole new contents, instead of edits; required for a new file"}
+                                },
+                                "required": ["path"]
+                            },
+                            "description": "Files to include (or use plan_path)"
+                        },
+                        "output": {
+                            "type": "string",
+                            "description": "Path to write the patch to, e.g. 'changes.patch'; without it the patch is returned"
+                        },
+                        "context": {
+                            "type": "integer",
+                            "description": "Unchanged lines to show around each change",
+                            "default": 3
+                        },
+                        "hunk_headers": {
+                            "type": "string",
+                            "enum": ["none", "declaration"],
+                            "description": "Set to declaration to end each hunk's @@ line with the first line of the function, method or type its first change is in, found in the parse tree rather than by git's xfuncname regexes",
+                            "default": "none"
+                        }
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "preview_edits",
+                "Preview a multi-file change before making it: returns a map of path to unified diff against the current disk contents, without writing any file. Takes an edit plan from compute_edit_plan, or files given as edits or whole new contents. With save_plan, the previewed change is saved as an edit plan that apply_edits_from_file applies exactly as shown, refusing it if any file changed since",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "plan_path": {
+                            "type": "string",
+                            "description": "Edit plan file written by compute_edit_plan (or use files); refused if any planned file changed since"
+                        },
+                        "files": {
+                            "type": "array",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "path": {"type": "string", "description": "File the edits apply to"},
+                                    "edits": {
+                                        "type": "array",
+                                        "items": {
+                                            "type": "object",
+                                            "properties": {
+                                                "start": {"type": "integer"},
+                                                "end": {"type": "integer"},
+                                                "replacement": {"type": "string"}
+                                            },
+                                            "required": ["start", "end", "replacement"]
+                                        },
+                                        "description": "Byte-range edits of the file's current contents"
+                                    },
+                                    "content": {"type": "string", "description": "Whole new contents, instead of edits; required for a new file"}
+                                },
+                                "required": ["path"]
+                            },
+                            "description": "Files to preview (or use plan_path)"
+                        },
+                        "save_plan": {
+                            "type": "string",
+                            "description": "Path to save the previewed change to as an edit plan, for apply_edits_from_file to apply once it is approved"
+                        },
+                        "context": {
+                            "type": "integer",
+                            "description": "Unchanged lines to show around each change",
+                            "default": 3
+                        },
+                        "hunk_headers": {
+                            "type": "string",
+                            "enum": ["none", "declaration"],
+                            "description": "Set to declaration to end each hunk's @@ line with the first line of the function, method or type its first change is in, found in the parse tree rather than by git's xfuncname regexes",
+                            "default": "none"
+                        }
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_similar",
+                "Find structural clones of the function (or node kind) at a position within a file, ignoring renamed variables and changed literals",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to search within (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the reference node (or use start_byte/end_byte)"
+                        },
+                        "start_byte": {
+                            "type": "number",
+                            "description": "Start of a byte range inside the reference node"
+                        },
+                        "end_byte": {
+                            "type": "number",
+                            "description": "End of the byte range (defaults to start_byte)"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Node kind to compare (default: the language's function kinds)"
+                        },
+                        "threshold": {
+                            "type": "number",
+                            "description": "Minimum similarity from 0 to 1, where 1 means identical up to names and literals",
+                            "default": 0.8
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte/end_byte, position columns, and returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "text_between",
+                "Get the exact source (whitespace and comments included) between two sibling nodes, e.g. what separates two functions",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to inspect (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to inspect (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "first": {
+                            "type": "object",
+                            "properties": {
+                                "start_byte": {"type": "number"},
+                                "end_byte": {"type": "number"}
+                            },
+                            "required": ["start_byte", "end_byte"],
+                            "description": "Exact range of one node, e.g. from a search result"
+                        },
+                        "second": {
+                            "type": "object",
+                            "properties": {
+                                "start_byte": {"type": "number"},
+                                "end_byte": {"type": "number"}
+                            },
+                            "required": ["start_byte", "end_byte"],
+                            "description": "Exact range of a sibling node; the two may come in either order"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("the node ranges and the returned start_byte/end_byte")
+                    },
+                    "required": ["language", "first", "second"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "add_error_checks",
+                "Go: insert `if err != nil { return ... }` after calls whose err result the next statement ignores, returning zero values that match the enclosing function's results",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Must be 'go'",
+                            "default": "go"
+                        },
+                        "template": {
+                            "type": "string",
+                            "description": "Check to insert, with $RETURN standing for the zero values and err (e.g., 'if err != nil {\n\treturn $RETURN\n}')"
+                        },
+                        "lines": {
+                            "type": "array",
+                            "items": {"type": "integer"},
+                            "description": "Only fix the assignments starting on these 1-indexed lines (default: every unchecked one)"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "get_node_text",
+                "Get the exact source of the function (or node kind) at a position, optionally with CRLF line endings normalized to LF. To read several nodes in one call, pass selectors (or name, or allowMultiple): the result is then a results array in selector order, each entry with the index of its selector",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to read from (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to read from (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'rust')"
+                        },
+                        "dialect": Self::dialect_property(),
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the node (or use start_byte/end_byte)"
+                        },
+                        "start_byte": {
+                            "type": "number",
+                            "description": "Start of a byte range inside the node"
+                        },
+                        "end_byte": {
+                            "type": "number",
+                            "description": "End of the byte range (defaults to start_byte)"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Node kind to return (default: the language's function kinds). Kinds name grammar nodes such as 'short_var_declaration'; to select an anonymous token such as an operator or keyword, give its literal text in double quotes, e.g. '\":=\"' or '\"return\"'"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of a function to return instead of a position; returns results like selectors"
+                        },
+                        "allowMultiple": {
+                            "type": "boolean",
+                            "description": "Return every node the selector matches, e.g. every node of kind in the file or every function called name, instead of one; returns results like selectors",
+                            "default": false
+                        },
+                        "selectors": {
+                            "type": "array",
+                            "description": "Nodes to read from one scan of the source. A selector that matches nothing gets an error entry without failing the others",
+                            "items": {
+                                "type": "object",
+                                "properties": {
+                                    "position": {
+                                        "type": "object",
+                                        "properties": {
+                                            "line": {"type": "number"},
+                                            "column": {"type": "number"}
+                                        },
+                                        "description": "Position inside the node"
+                                    },
+                                    "start_byte": {"type": "number"},
+                                    "end_byte": {"type": "number"},
+                                    "name": {
+                                        "type": "string",
+                                        "description": "Name of the function to read"
+                                    },
+                                    "kind": {
+                                        "type": "string",
+                                        "description": "Node kind to return (default: the language's function kinds)"
+                                    },
+                                    "allowMultiple": {
+                                        "type": "boolean",
+                                        "description": "Return every node the selector matches, innermost first for a position",
+                                        "default": false
+                                    }
+                                }
+                            }
+                        },
+                        "normalizeNewlines": {
+                            "type": "boolean",
+                            "description": "Return CRLF endings as LF so the text can be re-inserted cleanly; edits write it back with the target file's own ending",
+                            "default": false
+                        },
+                        "maxBytes": {
+                            "type": "number",
+                            "description": "Truncate the text to this many bytes, cutting at a line boundary when possible; the range still covers the whole node"
+                        },
+                        "truncationMarker": {
+                            "type": "string",
+                            "description": "Marker put in place of truncated text, with $OMITTED standing for the byte count (default: a comment in the language's style, e.g. '/* ...$OMITTED bytes omitted... */')"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte/end_byte, position columns, and returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "free_identifiers",
+                "List the identifiers a function reads but does not declare itself (package-level names, imports, builtins), subtracting its parameters, locals, and named results",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to analyze (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to analyze (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "One of 'javascript', 'typescript', 'python', 'go', 'rust'"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to analyze (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_references",
+                "List every place in one file that refers to a top-level symbol (function, type, package-level variable or constant), each marked as its declaration, a read, a write or a call. Same-name parameters and locals are not the symbol: uses inside a function that binds the name are left out and counted as shadowed. Scopes are whole functions, not blocks. Only this file is searched, so unlike a language server it misses uses in other files and cannot tell apart symbols from imports or methods reached through a value",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to search (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to search (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "One of 'javascript', 'typescript', 'python', 'go', 'rust'"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the symbol (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position on the symbol's declaration or any reference to it (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "fileEncoding": Self::file_encoding_property(false),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns and the returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "toggle_go_export",
+                "Export a Go function, type, var, const, field or method by capitalizing its name, or unexport it by lowering it (URLParser becomes urlParser), renaming its uses and the first word of its doc comment. Top-level names skip same-name locals; fields and methods are refused when another type in the file has a member of that name. Only this file is renamed, and other files in the package that mention the name are listed",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to edit (or use code)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Declared name to toggle (or use position)"
+                        },
+                        "type": {
+                            "type": "string",
+                            "description": "Type that owns the field or method, when the name is declared on more than one"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position on the declared name (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset of the declared name (or use position)"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(false),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "convert_go_returns",
+                "Convert a Go function between named and unnamed results, rewriting bare returns and declaring the former result names as locals; refuses conversions that a defer or an existing name would make unsafe",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to convert (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to convert (or use code)"
+                        },
+                        "style": {
+                            "type": "string",
+                            "enum": ["named", "unnamed"],
+                            "description": "Results style to convert the function to"
+                        },
+                        "names": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Result names when converting to named, one per result; may be omitted when every result is an error (named err)"
+                        },
+                        "bare_returns": {
+                            "type": "boolean",
+                            "description": "When converting to named, turn explicit returns into assignments followed by a bare return",
+                            "default": false
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to convert (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["style"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "reflow_go_signature",
+                "Reflow a Go function or method signature between one line and one parameter per line (with trailing commas and the closing parenthesis on its own line), rewriting only the parameter lists so names, types, receiver and body are kept; joining also joins a wrapped result list",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to reflow (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to reflow (or use code)"
+                        },
+                        "layout": {
+                            "type": "string",
+                            "enum": ["single_line", "multi_line"],
+                            "description": "Layout to give the signature; defaults to the other one, so a wrapped signature is joined and a one-line one wrapped"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to reflow (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "get_session_log",
+                "List every edit written to disk in a session: tool, file, the byte-range edits against the file as it was, and a timestamp. Any tool call accepts session_id to group its edits; calls without one use the server's own session",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "session_id": {
+                            "type": "string",
+                            "description": "Session to list (defaults to this server's session)"
+                        },
+                        "file": {
+                            "type": "string",
+                            "description": "Only list edits to this file, as it was passed to the editing tool"
+                        }
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "convert_go_receiver",
+                "Switch a Go method between a value receiver (p Person) and a pointer receiver (p *Person). Converting to a value receiver reports assignments and increments through the receiver in the body, which would then change a copy",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to refactor (or use code)"
+                        },
+                        "style": {
+                            "type": "string",
+                            "enum": ["pointer", "value"],
+                            "description": "Receiver form to convert the method to"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the method (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the method (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["style"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "go_receiver_names",
+                "Make the receivers of a Go type's methods use one name, as Go style asks: name, or else the name most of its methods use. Lists the methods whose receiver differs and renames each receiver with its uses in that method's body. Methods whose body redeclares the old name, or that already use the new one, are reported under skipped rather than renamed",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path to edit (or use code)"
+                        },
+                        "type": {
+                            "type": "string",
+                            "description": "Receiver type whose methods to check, e.g. 'Server' for (s *Server)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Receiver name to use; defaults to the most common one, ties going to the earliest method"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["type"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "extract_go_interface",
+                "Declare a Go interface of a type's method signatures: its exported methods in source order, with their doc comments, or all of them with include_unexported. The interface goes after the type's declaration, or before its first method if the type is declared in another file. assertion adds var _ Name = (*Type)(nil) to keep the type implementing it. Reports which of Type and *Type implements it",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path to edit (or use code)"
+                        },
+                        "type": {
+                            "type": "string",
+                            "description": "Type whose methods to collect, e.g. 'Server' for methods on (s *Server)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the new interface"
+                        },
+                        "include_unexported": {
+                            "type": "boolean",
+                            "description": "Also declare the unexported methods; they are otherwise listed under skipped",
+                            "default": false
+                        },
+                        "assertion": {
+                            "type": "boolean",
+                            "description": "Add a compile-time check that *Type implements the interface",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["type", "name"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "hoist_go_closure",
+                "Turn a Go func literal into a named top-level function placed after the function holding it, and put a reference to the new function where the literal was. The signature is the literal's own. Variables the literal captures from its enclosing function are reported; by default they make the call fail, and with captured set to parameters they become leading parameters, passed at the call if the literal is called in place or through a small forwarding closure otherwise. Preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path to edit (or use code)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            },
+                            "description": "A position in the func literal; the innermost literal containing it is hoisted"
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Byte offset in the func literal (alternative to position)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the new function"
+                        },
+                        "captured": {
+                            "type": "string",
+                            "enum": ["refuse", "parameters"],
+                            "description": "What to do with captured variables: refuse, or pass them as parameters. Closures that assign to a captured variable are always refused",
+                            "default": "refuse"
+                        },
+                        "types": {
+                            "type": "object",
+                            "additionalProperties": {"type": "string"},
+                            "description": "Types of captured variables, for those whose declarations do not state one (e.g. {\"total\": \"int\"})"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["name"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "inline_go_function",
+                "Inline a Go function whose body is a single return of one expression: replace each call in the file with the expression, arguments substituted for parameters, and remove the function once nothing else in the file refers to it. Other files of the package are not searched. Calls are skipped when an argument that may have side effects would run a different number of times or in a different order, when the call is a statement of its own, or when a local at the call would shadow a name the expression reads. Preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path to edit (or use code)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to inline (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            },
+                            "description": "A position in the function to inline"
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Byte offset in the function (alternative to position)"
+                        },
+                        "side_effect_free": {
+                            "type": "boolean",
+                            "description": "Vouch that the arguments have no side effects, so they may be duplicated, dropped or reordered",
+                            "default": false
+                        },
+                        "keep_function": {
+                            "type": "boolean",
+                            "description": "Keep the function declaration even when no references to it remain",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "extract_go_constant",
+                "Replace a Go literal with a new named constant declared at file scope, and optionally every identical literal in the file. The constant joins an existing const group (not an iota one) or gets its own declaration after the imports. Import paths and struct tags are left alone; preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to refactor (or use code)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the literal (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the literal"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the new constant; it must not be used in the file yet"
+                        },
+                        "all": {
+                            "type": "boolean",
+                            "description": "Also replace every other literal with the same text",
+                            "default": false
+                        },
+                        "typed": {
+                            "type": "boolean",
+                            "description": "Declare the constant with the literal's default type (int, float64, complex128, rune, or string) instead of untyped",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["name"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_magic_numbers",
+                "Survey the numeric literals of a Go file that may deserve a name, grouped by value with the most repeated first. Each use gives its function and role: what it is compared with, assigned to, passed to or the field it sets. Skips 0 and 1 (configurable), loop bounds and constants' values. Each group suggests a name where the context gives one, and the start_byte and all to pass to extract_go_constant",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to survey (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to survey (or use code)"
+                        },
+                        "ignore": {
+                            "type": "array",
+                            "items": {"type": ["string", "number"]},
+                            "description": "Values to leave out, compared without a sign; replaces the default of 0 and 1"
+                        },
+                        "includeLoopBounds": {
+                            "type": "boolean",
+                            "description": "Also report numbers in a for loop's header",
+                            "default": false
+                        },
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges and start_byte")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_long_signatures",
+                "Survey the functions of a file that declare more parameters or return more values than the given limits, the most crowded first, as candidates for bundling parameters into a struct or results into a type. Each gives its name, line, range, signature and counts, and which limits it exceeds. Go names sharing a type count one each; receivers (Go's, self, cls, this) do not count as parameters, and results count the elements of a Go result list or a tuple type",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to survey (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File to survey (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "enum": ["javascript", "typescript", "python", "go", "rust"],
+                            "description": "Language of the code"
+                        },
+                        "maxParameters": {
+                            "type": "integer",
+                            "description": "Most parameters a function may declare before it is reported",
+                            "default": 5
+                        },
+                        "maxResults": {
+                            "type": "integer",
+                            "description": "Most values a function may return before it is reported",
+                            "default": 3
+                        },
+                        "offsetEncoding": Self::offset_encoding_property("returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "generate_go_table_test",
+                "Add a table-driven test skeleton for a Go function to the _test.go file next to it, creating the file (in the same package) if there is none. The cases struct has a field for a method's receiver, one per parameter and one per result, with a trailing error result as wantErr; a loop runs each case as a subtest and compares the results. The table is left empty for you to fill in. testing (and reflect, when a result needs DeepEqual) are imported. Generic functions are refused; preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code holding the function (or use target); the test file's content is returned"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path holding the function (or use code)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to test (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            },
+                            "description": "A position in the function to test"
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Byte offset in the function (alternative to position)"
+                        },
+                        "test_name": {
+                            "type": "string",
+                            "description": "Name of the test function; defaults to the gotests name (TestAdd, Test_add, TestServer_Start)"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "convert_go_concatenation",
+                "Rewrite Go string concatenations such as \"Hello, \" + name + \"!\" as fmt.Sprintf(\"Hello, %s!\", name), adding the fmt import if needed. String literals become the format string and other operands %s arguments. Converts every concatenation in the file, or the one at position; preview first, since it is a style choice",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to refactor (or use code)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside one concatenation to convert only that one (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside one concatenation to convert only that one"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "split_go_assignment",
+                "Split a Go multi-value assignment such as a, b := f(), g() into one statement per name. Under :=, a name the same scope already declares is assigned with =. Refuses calls returning several values, for/if/switch headers, and assignments where a later value reads an earlier target, as in a, b = b, a; preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to refactor (or use code)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the assignment (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the assignment"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "convert_go_errors",
+                "Rewrite Go error constructors such as errors.New and fmt.Errorf in return statements to a project's own (e.g. apperr.New) by a mapping, keeping messages and format arguments. Converts the whole file, or one function by name or position; imports the new package and drops an old import the file no longer uses. Warns about %w wrapping; preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to refactor (or use code)"
+                        },
+                        "mapping": {
+                            "type": "object",
+                            "additionalProperties": {"type": "string"},
+                            "description": "Constructor to convert, by import path and function, to the one to call instead, e.g. {\"errors.New\": \"apperr.New\", \"fmt.Errorf\": \"apperr.Errorf\"}"
+                        },
+                        "import": {
+                            "type": "string",
+                            "description": "Import path of the new constructors' package (e.g. 'example.com/app/apperr', or 'apperr example.com/app/errors' with an alias); needed unless the file imports it already"
+                        },
+                        "returnsOnly": {
+                            "type": "boolean",
+                            "description": "Only convert calls in return statements; set to false to convert every call",
+                            "default": true
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Only convert within the function with this name"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function to convert within (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the function to convert within"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_go_shadowing",
+                "Find declarations in a Go function that shadow a variable of the same name from an enclosing scope or the package, such as a := in an inner block re-declaring a parameter or err. Reports where each shadowing and shadowed declaration is. Read-only unless rename is given, which renames one shadowing declaration and its uses; preview first",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to check (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to check (or use code)"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to check"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function to check (or use name/start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the function to check"
+                        },
+                        "rename": {
+                            "type": "object",
+                            "description": "Rename a shadowing declaration and its uses, e.g. {\"name\": \"err\", \"to\": \"readErr\"}; line chooses between several that shadow the same name",
+                            "properties": {
+                                "name": {"type": "string"},
+                                "to": {"type": "string"},
+                                "line": {"type": "integer"}
+                            },
+                            "required": ["name", "to"]
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte, position columns, and returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "group_declarations",
+                "Merge consecutive top-level single-line Go var or const declarations into one parenthesized group, or split a group into separate declarations, keeping each declaration's comments with it. Without a position every run or group in the file is rewritten; with one, just the declaration there. Consts using iota or omitted values are left alone, since their values depend on the group",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file path to edit (or use code)"
+                        },
+                        "direction": {
+                            "type": "string",
+                            "enum": ["group", "split"],
+                            "description": "group or split; defaults to splitting the group at the position, and otherwise to grouping"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside one declaration (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside one declaration"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "convert_switch",
+                "Rewrite the if/else-if chain at position as a switch when every condition compares the same variable with == (x == 1 || x == 2 becomes case 1, 2; a final else becomes default), or the switch at position as the equivalent if chain. Refuses chains with other conditions, repeated values, or a break or fallthrough whose meaning would change; preview first. Supports go",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Source code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to refactor (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language of the code"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the if chain or switch (or use start_byte); the innermost one is converted",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the if chain or switch"
+                        },
+                        "direction": {
+                            "type": "string",
+                            "enum": ["to_switch", "to_if"],
+                            "description": "Which way to convert; by default the innermost if chain or switch at position is converted to the other form"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "go_build_constraints",
+                "Report a Go file's build constraints: its //go:build line, legacy // +build lines (and whether they still agree with it), and the GOOS/GOARCH its file name selects, such as _linux_amd64.go. With tags, also whether the file builds under them. Parsed as go/build does: only header comments before the package clause count",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to inspect (or use target); file name suffixes only apply to target"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to inspect (or use code)"
+                        },
+                        "tags": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Build tags to test, e.g. [\"linux\", \"amd64\"]; the result's active says whether the file builds with them"
+                        },
+                        "fileEncoding": Self::file_encoding_property(false),
+                        "contentEncoding": Self::content_encoding_property(false)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "set_go_build_constraint",
+                "Add, replace or remove the //go:build line of a Go file. The constraint is checked and written the way gofmt prints it, followed by the blank line Go requires; legacy // +build lines are removed. Without an existing line it goes at the top of the file",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Go code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Go file to edit (or use code)"
+                        },
+                        "constraint": {
+                            "type": "string",
+                            "description": "Build constraint expression such as 'linux && (amd64 || arm64)'; omit or leave empty to remove the line"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": {
+                            "type": "boolean",
+                            "description": "Edit files marked as generated or excluded by the server's build tags (protected regions are never edited)",
+                            "default": false
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "split_function",
+                "Split a function in two: the statements from split_line on move into a new helper function, and the original ends by returning a call to it. Locals and parameters the moved statements read are passed as arguments, typed from their declarations where possible. Supports javascript, typescript, python, go",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to refactor (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "One of 'javascript', 'typescript', 'python', 'go'"
+                        },
+                        "name": {
+                            "type": "string",
+                            "description": "Name of the function to split (or use position)"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the function (or use name)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "split_line": {
+                            "type": "integer",
+                            "description": "1-indexed line of the first statement to move; it must sit directly in the function body, not inside a nested block"
+                        },
+                        "helper_name": {
+                            "type": "string",
+                            "description": "Name of the new helper function"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["language", "split_line", "helper_name"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "merge_functions",
+                "Merge two functions or methods declared in the same scope: the statements of the second are appended to the body of the first, and the second is removed with its doc comment. Refuses when the signatures differ unless signature gives the merged one, and when the first ends in a return. With update_calls, references to the second in the file are renamed to the first. Supports javascript, typescript, python, go",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to refactor (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "One of 'javascript', 'typescript', 'python', 'go'"
+                        },
+                        "first": {
+                            "type": "object",
+                            "description": "The function that is kept: its name, or a 1-indexed position inside it",
+                            "properties": {
+                                "name": {"type": "string"},
+                                "position": {
+                                    "type": "object",
+                                    "properties": {
+                                        "line": {"type": "integer"},
+                                        "column": {"type": "integer"}
+                                    }
+                                }
+                            }
+                        },
+                        "second": {
+                            "type": "object",
+                            "description": "The function whose statements are moved and which is removed: its name, or a 1-indexed position inside it",
+                            "properties": {
+                                "name": {"type": "string"},
+                                "position": {
+                                    "type": "object",
+                                    "properties": {
+                                        "line": {"type": "integer"},
+                                        "column": {"type": "integer"}
+                                    }
+                                }
+                            }
+                        },
+                        "signature": {
+                            "type": "string",
+                            "description": "Signature of the merged function, up to its body (e.g. 'func save(path string, force bool) error'); required when the two signatures differ"
+                        },
+                        "update_calls": {
+                            "type": "boolean",
+                            "description": "Rename references to the second function in this file to the first; top-level functions only",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("position columns")
+                    },
+                    "required": ["language", "first", "second"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "edit_list_element",
+                "Delete or insert an element of a comma-separated list (call arguments, parameters, array items, struct literal fields, imports) and the comma and spacing around it, so the list stays valid whether or not it uses a trailing comma. Supports javascript, typescript, python, go, rust",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "One of 'javascript', 'typescript', 'python', 'go', 'rust'"
+                        },
+                        "operation": {
+                            "type": "string",
+                            "enum": ["delete", "insert"],
+                            "description": "Delete the element at the position (or at index), or insert element into the list around the position"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the element to delete, or inside the list's brackets to insert into; the innermost list around it is used (or use start_byte/end_byte)"
+                        },
+                        "start_byte": {
+                            "type": "number",
+                            "description": "Start of the byte range to look up"
+                        },
+                        "end_byte": {
+                            "type": "number",
+                            "description": "End of the byte range to look up (defaults to start_byte)"
+                        },
+                        "index": {
+                            "type": "integer",
+                            "description": "0-based element index: for delete, the element to remove instead of the one at the position; for insert, the index the new element takes (defaults to the end)"
+                        },
+                        "element": {
+                            "type": "string",
+                            "description": "For insert: the element's text, without a separating comma"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte/end_byte and position columns")
+                    },
+                    "required": ["language", "operation"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "reorder_elements",
+                "Reorder the fields of a struct or the parameters of a function: alphabetically, by type, exported first, or into a custom order of names. Comments and attributes above an element and a comment ending its line move with it; separators stay in place. A receiver (self) stays first and a variadic parameter last. Call sites and positional literals are not updated, so preview first. Supports go, rust, python, javascript, typescript, java, c, cpp, csharp",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to refactor (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to refactor (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language of the code"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the struct body or parameter list (or use start_byte); the innermost one is reordered",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the struct body or parameter list"
+                        },
+                        "container": {
+                            "type": "string",
+                            "description": "Node kind of the list to reorder, e.g. 'field_declaration_list' or 'parameter_list', when the position is inside both a struct and a parameter list"
+                        },
+                        "order": {
+                            "type": "string",
+                            "enum": ["alphabetical", "by_type", "exported_first", "custom"],
+                            "description": "alphabetical sorts by name, by_type groups elements of one type together, exported_first moves exported elements ahead of the others, custom follows names. Ties keep their current order"
+                        },
+                        "names": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "For the custom order: element names in their new order; elements not listed follow in their current order"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["language", "order"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "resolve_node_id",
+                "Find the node a nodeId from an earlier execute_rule search (nodeIds: true) names in the code as it is now, after edits have shifted offsets. Returns its kind, text and current range, or says which step of the path no longer exists",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to look in (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to look in (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'go')"
+                        },
+                        "node_id": {
+                            "type": "string",
+                            "description": "Path of 0-based named-child indices from the root, e.g. '/12/3/0'"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("the returned range")
+                    },
+                    "required": ["language", "node_id"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "markdown_code_blocks",
+                "List the fenced and indented code blocks of a Markdown document with the language each fence names, their ranges and contents; edit one with parse_embedded (language: markdown)",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Markdown to look in (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "Markdown file path to look in (or use code)"
+                        },
+                        "validate": {
+                            "type": "boolean",
+                            "description": "Parse each block in a supported language and report its syntax_errors",
+                            "default": false
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("the returned ranges")
+                    }
+                })).unwrap()
+            ),
+            Tool::new(
+                "resolve_match_index",
+                "Find the match at a globalIndex from an earlier execute_rule search (globalIndex: true) by re-running the same rule against the files as they are now. Returns the match with its current offsets, ready for an edit",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "rule_config": {
+                            "type": "string",
+                            "description": "The YAML rule of the earlier search"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File or directory path of the earlier search"
+                        },
+                        "index": {
+                            "type": "integer",
+                            "description": "0-based globalIndex of the match"
+                        },
+                        "filter": {
+                            "type": "string",
+                            "description": "The earlier search's filter, if it had one"
+                        },
+                        "buildTags": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "The earlier search's buildTags, if it had them"
+                        },
+                        "expected_total": {
+                            "type": "integer",
+                            "description": "Match count of the earlier search; if the search now finds a different number, fail instead of returning a match whose index may have shifted"
+                        }
+                    },
+                    "required": ["rule_config", "target", "index"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "extract_strings",
+                "List the user-facing string literals of a file with their text, kind and range, for externalizing them into translations. Import paths, struct tags, attributes and docstrings are left out. Optionally keep only literals passed directly to calls such as fmt.Print*; replace one with replace_string_literal",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to look in (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to look in (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'python', 'typescript')"
+                        },
+                        "functions": {
+                            "type": "array",
+                            "items": {"type": "string"},
+                            "description": "Keep only literals that are arguments of calls to these callees, written as in the source; * matches anything, e.g. ['fmt.Print*', 'log.Fatal']"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false),
+                        "offsetEncoding": Self::offset_encoding_property("the returned ranges")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "replace_string_literal",
+                "Replace the string literal at a position with a translation lookup built from template, e.g. i18n.T(\"greeting\")",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'python', 'typescript')"
+                        },
+                        "position": {
+                            "type": "object",
+                            "description": "1-indexed position inside the literal (or use start_byte)",
+                            "properties": {
+                                "line": {"type": "integer"},
+                                "column": {"type": "integer"}
+                            }
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the literal"
+                        },
+                        "key": {
+                            "type": "string",
+                            "description": "Translation key substituted for {key} in template"
+                        },
+                        "template": {
+                            "type": "string",
+                            "description": "Replacement code; {key} becomes key and {text} the original literal, e.g. '_({text})' for gettext",
+                            "default": "i18n.T(\"{key}\")"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["language", "key"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "normalize_quotes",
+                "Rewrite the string literals of a JavaScript, TypeScript or Python file to one quote style, unescaping the old quote. Strings containing the new quote are skipped rather than escaped, and template strings and JSX attributes are left alone",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language ('javascript', 'typescript' or 'python')"
+                        },
+                        "quote": {
+                            "type": "string",
+                            "enum": ["single", "double"],
+                            "description": "Quote style to use",
+                            "default": "double"
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true)
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "wrap_statements",
+                "Put text before and after each statement directly in a block, e.g. a trace call before every statement of a function, indented like the statement. The block is the innermost one covering position. With nest the statements are indented one step deeper, for templates that open and close a try block. Statements that share a line, declarations under skipDeclarations or nest, and a Rust block's final value are reported under skipped; nothing is added after a return, break, continue or throw unless nesting",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to edit (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to edit (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language"
+                        },
+                        "position": {
+                            "type": "object",
+                            "properties": {
+                                "line": {"type": "number", "description": "Line number (1-indexed)"},
+                                "column": {"type": "number", "description": "Column number (1-indexed)"}
+                            },
+                            "required": ["line", "column"],
+                            "description": "Position inside the block whose statements to wrap"
+                        },
+                        "start_byte": {
+                            "type": "integer",
+                            "description": "Offset inside the block, instead of position"
+                        },
+                        "before": {
+                            "type": "string",
+                            "description": "Text to put on the lines before each statement; $LINE and $INDEX become the statement's line and its index in the block"
+                        },
+                        "after": {
+                            "type": "string",
+                            "description": "Text to put on the lines after each statement, with the same placeholders as before"
+                        },
+                        "nest": {
+                            "type": "boolean",
+                            "description": "Indent each statement one step deeper between before and after",
+                            "default": false
+                        },
+                        "skipDeclarations": {
+                            "type": "boolean",
+                            "description": "Leave declarations such as var and let statements unwrapped",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(true),
+                        "offsetEncoding": Self::offset_encoding_property("start_byte and position columns")
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "find_unreachable_code",
+                "Find statements that can never run because they follow a return, break, continue, goto, throw, raise or panic in the same block, and optionally delete them. A labeled statement that a goto jumps to is reachable again, along with what follows it. Only a statement that itself leaves the block counts, so code after an if whose branches all return is not reported",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to check (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File to check (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'go', 'rust', 'python')"
+                        },
+                        "delete": {
+                            "type": "boolean",
+                            "description": "Delete the unreachable statements",
+                            "default": false
+                        },
+                        "dry_run": Self::dry_run_property(),
+                        "returnEditedNode": Self::return_edited_node_property(),
+                        "force": Self::force_property(),
+                        "fileEncoding": Self::file_encoding_property(false),
+                        "contentEncoding": Self::content_encoding_property(false)
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "node_type_histogram",
+                "Count the named nodes of code by kind, most frequent first, to get a feel for a file or grammar and see which kinds are worth writing rules for. scope_rule limits the count to nodes inside its matches",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "code": {
+                            "type": "string",
+                            "description": "Code to count (or use target)"
+                        },
+                        "target": {
+                            "type": "string",
+                            "description": "File path to count (or use code)"
+                        },
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'go')"
+                        },
+                        "scope_rule": {
+                            "type": "string",
+                            "description": "YAML rule whose matches are the scopes to count within (e.g., the functions); nodes in nested matches count once"
+                        },
+                        "limit": {
+                            "type": "integer",
+                            "description": "Return only this many of the most frequent kinds; total and distinct still cover all of them"
+                        },
+                        "fileEncoding": Self::file_encoding_property(true),
+                        "contentEncoding": Self::content_encoding_property(false)
+                    },
+                    "required": ["language"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "fields_for_type",
+                "List the fields a language's grammar defines on a node kind, with whether each is required, whether it holds several nodes, and the kinds it may hold, so field selectors and has/field rules can be written without guessing. Read from the grammar's node-types.json, downloaded once per language for ast-grep's own grammars and named by node_types_path for a custom grammar",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {
+                        "language": {
+                            "type": "string",
+                            "description": "Programming language (e.g., 'javascript', 'python', 'go')"
+                        },
+                        "kind": {
+                            "type": "string",
+                            "description": "Named node kind to describe (e.g., 'function_declaration')"
+                        }
+                    },
+                    "required": ["language", "kind"]
+                })).unwrap()
+            ),
+            Tool::new(
+                "get_capabilities",
+                "Report the server version, the ast-grep binary in use, the languages it recognizes with their file extensions and whether each is supported, the encodings and protections in effect, and every tool with its options. Call this first to adapt to what this server can do",
+                serde_json::from_value::<serde_json::Map<String, serde_json::Value>>(serde_json::json!({
+                    "type": "object",
+                    "properties": {}
+                })).unwrap()
+            ),
+        ]
+    }
+}
+
+impl ServerHandler for AstGrepServer {
+    fn get_info(&self) -> InitializeResult {
+        InitializeResult {
+            protocol_version: ProtocolVersion::V_2024_11_05,
+            capabilities: ServerCapabilities::builder()
+                .enable_tools()
+                .enable_resources()
+                .enable_prompts()
+                .build(),
+            server_info: Implementation {
+                name: "splice-weaver-mcp".to_string(),
+                version: "0.1.0".to_string(),
+            },
+            instructions: Some(
+                "Splice Weaver MCP - ast-grep server with scope navigation and rule execution tools"
+                    .to_string(),
+            ),
+        }
+    }
+
+    async fn list_tools(
+        &self,
+        _request: Option<PaginatedRequestParam>,
+        _context: RequestContext<RoleServer>,
+    ) -> Result<ListToolsResult, rmcp::Error> {
+        Ok(ListToolsResult::with_all_items(Self::tool_list()))
+    }
+
+    async fn call_tool(
+        &self,
+        request: CallToolRequestParam,
+        context: RequestContext<RoleServer>,
+    ) -> Result<CallToolResult, rmcp::Error> {
+        let args = request
+            .arguments
+            .map(serde_json::Value::Object)
+            .unwrap_or(serde_json::Value::Null);
+
+        // Forward MCP cancellation of this request to the running tool
+        let operation = OperationContext::new();
+        let canceller = operation.clone();
+        let cancellation = tokio::spawn(async move {
+            context.ct.cancelled().await;
+            canceller.cancel();
+        });
+        let result = self
+            .tools
+            .call_tool_with_context(&request.name, args, operation)
+            .await;
+        cancellation.abort();
+
+        match result {
             Ok(result) => Ok(CallToolResult::success(vec![Content::text(result)])),
             Err(e) => {
                 error!("Tool execution failed: {}", e);
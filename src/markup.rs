@@ -0,0 +1,356 @@
+//! Read and rewrite the parts of HTML and CSS that the edit tools work on:
+//! the attributes and class list of an element's start tag, the selector
+//! list of a CSS rule set, and its declarations. ast-grep locates the
+//! element or rule; the helpers here only look at its text.
+
+/// An attribute of a start tag. Offsets are into the tag's text.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Attribute {
+    pub name: String,
+    /// `None` for an attribute without a value, such as `disabled`
+    pub value: Option<String>,
+    pub start: usize,
+    pub end: usize,
+    /// Range of the value inside its quotes, empty when there is none
+    pub value_start: usize,
+    pub value_end: usize,
+    pub quoted: bool,
+}
+
+/// The start tag of an HTML element: `<div id="main" class="wide">`.
+#[derive(Debug, Clone, PartialEq)]
+pub struct StartTag {
+    pub name: String,
+    pub text: String,
+    pub attributes: Vec<Attribute>,
+}
+
+impl StartTag {
+    /// The start tag at the beginning of `element`, the source of a whole
+    /// element. It runs to the first `>` outside a quoted value.
+    pub fn parse(element: &str) -> Option<Self> {
+        if !element.starts_with('<') {
+            return None;
+        }
+        let mut quote = None;
+        let mut end = None;
+        for (i, c) in element.char_indices() {
+            match (quote, c) {
+                (Some(open), _) if c == open => quote = None,
+                (Some(_), _) => {}
+                (None, '"' | '\'') => quote = Some(c),
+                (None, '>') => {
+                    end = Some(i + 1);
+                    break;
+                }
+                _ => {}
+            }
+        }
+        let text = &element[..end?];
+        let name_end = text[1..]
+            .find(|c: char| c.is_whitespace() || c == '/' || c == '>')
+            .map_or(text.len(), |i| i + 1);
+        if name_end == 1 {
+            return None;
+        }
+        Some(Self {
+            name: text[1..name_end].to_string(),
+            text: text.to_string(),
+            attributes: parse_attributes(text, name_end),
+        })
+    }
+
+    /// The attribute called `name`, ignoring case as HTML does.
+    pub fn attribute(&self, name: &str) -> Option<&Attribute> {
+        self.attributes
+            .iter()
+            .find(|attribute| attribute.name.eq_ignore_ascii_case(name))
+    }
+
+    /// The names in the `class` attribute, in order.
+    pub fn classes(&self) -> Vec<&str> {
+        self.attribute("class")
+            .and_then(|attribute| attribute.value.as_deref())
+            .map_or(Vec::new(), |value| value.split_whitespace().collect())
+    }
+
+    /// The class list after adding `add` and removing `remove`, and the
+    /// edit to the tag's text that makes the change: `(start, end,
+    /// replacement)`, or `None` when the list stays the same. Added names
+    /// go at the end; an emptied `class` attribute is removed.
+    pub fn edit_classes(
+        &self,
+        add: &[&str],
+        remove: &[&str],
+    ) -> (Vec<String>, Option<(usize, usize, String)>) {
+        let before = self.classes();
+        let mut after: Vec<String> = before
+            .iter()
+            .filter(|class| !remove.contains(class))
+            .map(|class| class.to_string())
+            .collect();
+        for class in add {
+            if !after.iter().any(|existing| existing == class) {
+                after.push(class.to_string());
+            }
+        }
+        if after == before {
+            return (after, None);
+        }
+        let joined = after.join(" ");
+        let edit = match self.attribute("class") {
+            Some(attribute) if after.is_empty() => {
+                let start = self.text[..attribute.start].trim_end().len();
+                (start, attribute.end, String::new())
+            }
+            Some(attribute) if attribute.quoted => {
+                (attribute.value_start, attribute.value_end, joined)
+            }
+            Some(attribute) => (
+                attribute.start,
+                attribute.end,
+                format!("{}=\"{}\"", attribute.name, joined),
+            ),
+            None => {
+                let at = self
+                    .attributes
+                    .last()
+                    .map_or(self.name.len() + 1, |attribute| attribute.end);
+                (at, at, format!(" class=\"{}\"", joined))
+            }
+        };
+        (after, Some(edit))
+    }
+}
+
+/// The attributes of start tag `text`, read from offset `from`.
+fn parse_attributes(text: &str, from: usize) -> Vec<Attribute> {
+    let bytes = text.as_bytes();
+    let mut attributes = Vec::new();
+    let mut i = from;
+    loop {
+        while i < bytes.len() && (bytes[i].is_ascii_whitespace() || bytes[i] == b'/') {
+            i += 1;
+        }
+        if i >= bytes.len() || bytes[i] == b'>' {
+            break;
+        }
+        let start = i;
+        while i < bytes.len() && !bytes[i].is_ascii_whitespace() && !b"=/>".contains(&bytes[i]) {
+            i += 1;
+        }
+        let name = text[start..i].to_string();
+        if name.is_empty() {
+            // A stray `=`
+            i += 1;
+            continue;
+        }
+
+        let mut next = i;
+        while next < bytes.len() && bytes[next].is_ascii_whitespace() {
+            next += 1;
+        }
+        let (mut value, mut value_start, mut value_end, mut quoted) = (None, i, i, false);
+        if next < bytes.len() && bytes[next] == b'=' {
+            i = next + 1;
+            while i < bytes.len() && bytes[i].is_ascii_whitespace() {
+                i += 1;
+            }
+            if i < bytes.len() && (bytes[i] == b'"' || bytes[i] == b'\'') {
+                let quote = bytes[i];
+                value_start = i + 1;
+                value_end = text[value_start..]
+                    .bytes()
+                    .position(|b| b == quote)
+                    .map_or(text.len(), |position| value_start + position);
+                i = (value_end + 1).min(text.len());
+                quoted = true;
+            } else {
+                value_start = i;
+                while i < bytes.len() && !bytes[i].is_ascii_whitespace() && bytes[i] != b'>' {
+                    i += 1;
+                }
+                value_end = i;
+            }
+            value = Some(text[value_start..value_end].to_string());
+        }
+        attributes.push(Attribute {
+            name,
+            value,
+            start,
+            end: i,
+            value_start,
+            value_end,
+            quoted,
+        });
+    }
+    attributes
+}
+
+/// `selector` with its whitespace collapsed, and none around commas or
+/// combinators, so `ul > li` and `ul>li` compare equal. Quoted attribute
+/// values are left as they are.
+pub fn normalize_selector(selector: &str) -> String {
+    let mut normalized = String::new();
+    let mut depth = 0;
+    let mut quote = None;
+    let mut pending_space = false;
+    for c in selector.trim().chars() {
+        if let Some(open) = quote {
+            normalized.push(c);
+            if c == open {
+                quote = None;
+            }
+            continue;
+        }
+        if c.is_whitespace() {
+            pending_space = true;
+            continue;
+        }
+        let joins = |c: char| c == ',' || (depth == 0 && matches!(c, '>' | '+' | '~'));
+        let after_join = normalized.chars().last().is_some_and(joins);
+        if pending_space && !joins(c) && !after_join && !normalized.is_empty() {
+            normalized.push(' ');
+        }
+        pending_space = false;
+        match c {
+            '(' | '[' => depth += 1,
+            ')' | ']' => depth -= 1,
+            '"' | '\'' => quote = Some(c),
+            _ => {}
+        }
+        normalized.push(c);
+    }
+    normalized
+}
+
+/// The selectors of a comma-separated selector list, each normalized.
+pub fn selector_list(selectors: &str) -> Vec<String> {
+    let mut list = Vec::new();
+    let mut depth = 0;
+    let mut start = 0;
+    for (i, c) in selectors.char_indices() {
+        match c {
+            '(' | '[' => depth += 1,
+            ')' | ']' => depth -= 1,
+            ',' if depth == 0 => {
+                list.push(normalize_selector(&selectors[start..i]));
+                start = i + 1;
+            }
+            _ => {}
+        }
+    }
+    list.push(normalize_selector(&selectors[start..]));
+    list.retain(|selector| !selector.is_empty());
+    list
+}
+
+/// Whether a rule set with selector list `selectors` is selected by
+/// `wanted`: the same list, or one selector that is in the list.
+pub fn selects(selectors: &str, wanted: &str) -> bool {
+    let list = selector_list(selectors);
+    let wanted = selector_list(wanted);
+    list == wanted || (wanted.len() == 1 && list.contains(&wanted[0]))
+}
+
+/// The property, value and `!important` flag of a declaration such as
+/// `color: red !important;`.
+pub fn split_declaration(text: &str) -> Option<(&str, &str, bool)> {
+    let (property, value) = text.split_once(':')?;
+    let value = value.trim().trim_end_matches(';').trim_end();
+    let (value, important) = match value.strip_suffix("!important") {
+        Some(value) => (value.trim_end(), true),
+        None => (value, false),
+    };
+    Some((property.trim(), value, important))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_start_tag_attributes() {
+        let tag = StartTag::parse(
+            "<input type=\"text\" data-x='a > b' disabled value=plain>rest</input>",
+        )
+        .unwrap();
+        assert_eq!(tag.name, "input");
+        assert_eq!(
+            tag.text,
+            "<input type=\"text\" data-x='a > b' disabled value=plain>"
+        );
+        let values: Vec<(&str, Option<&str>)> = tag
+            .attributes
+            .iter()
+            .map(|attribute| (attribute.name.as_str(), attribute.value.as_deref()))
+            .collect();
+        assert_eq!(
+            values,
+            [
+                ("type", Some("text")),
+                ("data-x", Some("a > b")),
+                ("disabled", None),
+                ("value", Some("plain"))
+            ]
+        );
+        assert_eq!(StartTag::parse("<br/>").unwrap().name, "br");
+        assert_eq!(StartTag::parse("text"), None);
+    }
+
+    #[test]
+    fn test_edit_classes() {
+        let apply = |tag: &str, add: &[&str], remove: &[&str]| {
+            let start_tag = StartTag::parse(tag).unwrap();
+            match start_tag.edit_classes(add, remove) {
+                (_, Some((start, end, replacement))) => {
+                    format!("{}{}{}", &tag[..start], replacement, &tag[end..])
+                }
+                (_, None) => tag.to_string(),
+            }
+        };
+        assert_eq!(
+            apply("<div class=\"a b\">", &["c", "a"], &["b"]),
+            "<div class=\"a c\">"
+        );
+        assert_eq!(
+            apply("<div id=x>", &["wide"], &[]),
+            "<div id=x class=\"wide\">"
+        );
+        assert_eq!(apply("<p>", &["lead"], &[]), "<p class=\"lead\">");
+        assert_eq!(
+            apply("<div class='a' id=\"m\">", &[], &["a"]),
+            "<div id=\"m\">"
+        );
+        assert_eq!(apply("<div class=a>", &["b"], &[]), "<div class=\"a b\">");
+        assert_eq!(
+            apply("<img class=\"a\" />", &["a"], &[]),
+            "<img class=\"a\" />"
+        );
+    }
+
+    #[test]
+    fn test_selectors() {
+        assert_eq!(normalize_selector("ul  >li,\n  a:hover"), "ul>li,a:hover");
+        assert_eq!(normalize_selector(".nav  a"), ".nav a");
+        assert_eq!(
+            normalize_selector("a[title=\"two  words\"] ~ b"),
+            "a[title=\"two  words\"]~b"
+        );
+        assert_eq!(
+            selector_list("h1, h2 , :is(a, b)"),
+            ["h1", "h2", ":is(a,b)"]
+        );
+        assert!(selects("h1, h2", "h2"));
+        assert!(selects("h1, h2", "h1,h2"));
+        assert!(!selects("h1 a", "a"));
+        assert_eq!(
+            split_declaration("color : red !important;"),
+            Some(("color", "red", true))
+        );
+        assert_eq!(
+            split_declaration("margin: 0 auto"),
+            Some(("margin", "0 auto", false))
+        );
+    }
+}
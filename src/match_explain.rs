@@ -0,0 +1,306 @@
+//! Why a match matched: the parts of a rule config an `explain` search
+//! traces for each match.
+//!
+//! ast-grep only reports the node a rule matched, so the trace is rebuilt
+//! from the config. The atomic checks of the rule (`kind`, `pattern`,
+//! `regex`, `nthChild`, `range`) are listed as they were written, since a
+//! match passed them all. Each relational step (`inside`, `has`, `follows`,
+//! `precedes`) is located by running its sub-rule on its own and taking
+//! the nearest node in the right place: the innermost enclosing node for
+//! `inside`, the first outermost one within for `has`, and the nearest
+//! one ending before or starting after for `follows` and `precedes`. A
+//! step's `stopBy` and `field` are shown but not checked. Steps inside a
+//! step are located from the node it found. Steps under `all` are traced
+//! like top-level ones; `any` and `not` are shown as written, not broken
+//! down into the branch that held.
+
+use crate::edit_utils::NodeSpan;
+use serde_json::{Map, Value};
+
+/// Keys of a rule that check the node itself.
+const ATOMIC_KEYS: &[&str] = &[
+    "kind", "pattern", "regex", "nthChild", "range", "any", "not",
+];
+
+/// Keys relating the node to another one.
+const RELATIONAL_KEYS: &[&str] = &["inside", "has", "follows", "precedes"];
+
+/// A relational step of a rule and the steps of its own sub-rule.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Step {
+    pub relation: &'static str,
+    /// The sub-rule, without `stopBy` and `field`
+    pub rule: Value,
+    pub stop_by: Value,
+    pub field: Option<String>,
+    pub steps: Vec<Step>,
+}
+
+/// The relational steps of `rule`, including those under `all`.
+pub fn steps(rule: &Value) -> Vec<Step> {
+    let mut found = Vec::new();
+    let Some(rule) = rule.as_object() else {
+        return found;
+    };
+    for (key, value) in rule {
+        if let Some(relation) = RELATIONAL_KEYS.iter().find(|relation| *relation == key) {
+            let mut sub_rule = value.clone();
+            let (stop_by, field) = match sub_rule.as_object_mut() {
+                Some(sub_rule) => (
+                    sub_rule.remove("stopBy").unwrap_or(Value::Null),
+                    sub_rule
+                        .remove("field")
+                        .and_then(|field| field.as_str().map(str::to_string)),
+                ),
+                None => (Value::Null, None),
+            };
+            found.push(Step {
+                relation,
+                steps: steps(&sub_rule),
+                rule: sub_rule,
+                stop_by,
+                field,
+            });
+        } else if key == "all" {
+            for item in value.as_array().into_iter().flatten() {
+                found.extend(steps(item));
+            }
+        }
+    }
+    found
+}
+
+/// The atomic and composite checks of `rule`, and those under `all`, as
+/// written. `matches` names the util the rule used.
+pub fn checks(rule: &Value) -> Map<String, Value> {
+    let mut found = Map::new();
+    let Some(rule) = rule.as_object() else {
+        return found;
+    };
+    for (key, value) in rule {
+        if ATOMIC_KEYS.contains(&key.as_str()) || key == "matches" {
+            found.insert(key.clone(), value.clone());
+        } else if key == "all" {
+            for item in value.as_array().into_iter().flatten() {
+                found.extend(checks(item));
+            }
+        }
+    }
+    found
+}
+
+/// Index of the node a step found relative to `anchor`, among the nodes
+/// its sub-rule matches in the file.
+pub fn locate(relation: &str, anchor: (usize, usize), candidates: &[NodeSpan]) -> Option<usize> {
+    let (start, end) = anchor;
+    let itself = |span: &NodeSpan| (span.start, span.end) == anchor;
+    let indexed = candidates.iter().enumerate();
+    match relation {
+        "inside" => indexed
+            .filter(|(_, span)| span.start <= start && end <= span.end && !itself(span))
+            .min_by_key(|(_, span)| span.end - span.start),
+        "has" => {
+            let within: Vec<(usize, &NodeSpan)> = indexed
+                .filter(|(_, span)| start <= span.start && span.end <= end && !itself(span))
+                .collect();
+            within
+                .iter()
+                .filter(|(_, span)| {
+                    !within.iter().any(|(_, outer)| {
+                        outer.start <= span.start
+                            && span.end <= outer.end
+                            && (outer.start, outer.end) != (span.start, span.end)
+                    })
+                })
+                .min_by_key(|(_, span)| span.start)
+                .copied()
+        }
+        "follows" => indexed
+            .filter(|(_, span)| span.end <= start)
+            .max_by_key(|(_, span)| span.end),
+        "precedes" => indexed
+            .filter(|(_, span)| end <= span.start)
+            .min_by_key(|(_, span)| span.start),
+        _ => None,
+    }
+    .map(|(index, _)| index)
+}
+
+/// Every step of `steps` and of their sub-rules, depth first: the order
+/// `trace` takes their nodes in.
+pub fn flatten(steps: &[Step]) -> Vec<&Step> {
+    steps
+        .iter()
+        .flat_map(|step| std::iter::once(step).chain(flatten(&step.steps)))
+        .collect()
+}
+
+/// The trace of `steps` from `anchor`, the node they relate to. `found`
+/// holds the spans and descriptions of the nodes each step's sub-rule
+/// matches, in `flatten` order, and `next` is the index of `steps[0]` in
+/// it. A step whose anchor was not located has no node either.
+pub fn trace(
+    steps: &[Step],
+    anchor: Option<(usize, usize)>,
+    found: &[(Vec<NodeSpan>, Vec<Value>)],
+    next: &mut usize,
+) -> Vec<Value> {
+    let mut traced = Vec::new();
+    for step in steps {
+        let (spans, nodes) = &found[*next];
+        *next += 1;
+        let located = anchor.and_then(|anchor| locate(step.relation, anchor, spans));
+        let inner = trace(
+            &step.steps,
+            located.map(|index| (spans[index].start, spans[index].end)),
+            found,
+            next,
+        );
+        traced.push(serde_json::json!({
+            "relation": step.relation,
+            "rule": step.rule,
+            "stopBy": step.stop_by,
+            "field": step.field,
+            "node": located.map(|index| nodes[index].clone()),
+            "steps": inner,
+        }));
+    }
+    traced
+}
+
+/// The metavariables a match bound: the text of each single one, the
+/// texts of each multiple one, and each transformed value.
+pub fn captures(m: &Value) -> Map<String, Value> {
+    let metavariables = &m["metaVariables"];
+    let mut found = Map::new();
+    for (name, node) in metavariables["single"].as_object().into_iter().flatten() {
+        found.insert(name.clone(), node["text"].clone());
+    }
+    for (name, nodes) in metavariables["multi"].as_object().into_iter().flatten() {
+        let texts: Vec<Value> = nodes
+            .as_array()
+            .into_iter()
+            .flatten()
+            .map(|node| node["text"].clone())
+            .collect();
+        found.insert(name.clone(), texts.into());
+    }
+    for (name, text) in metavariables["transformed"]
+        .as_object()
+        .into_iter()
+        .flatten()
+    {
+        found.insert(name.clone(), text.clone());
+    }
+    found
+}
+
+/// Each of the config's `constraints` with the value it was checked
+/// against in `m`.
+pub fn constraints(config: &Value, m: &Value) -> Vec<Value> {
+    let bound = captures(m);
+    config["constraints"]
+        .as_object()
+        .into_iter()
+        .flatten()
+        .map(|(name, rule)| {
+            serde_json::json!({
+                "metavariable": name,
+                "rule": rule,
+                "value": bound.get(name).cloned().unwrap_or(Value::Null),
+            })
+        })
+        .collect()
+}
+
+/// The first line of `text`, marked when there is more.
+pub fn first_line(text: &str) -> String {
+    match text.split_once('\n') {
+        Some((line, _)) => format!("{} …", line.trim_end()),
+        None => text.to_string(),
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn span(start: usize, end: usize) -> NodeSpan {
+        NodeSpan {
+            start,
+            end,
+            text: String::new(),
+        }
+    }
+
+    #[test]
+    fn test_steps_and_checks() {
+        let rule = serde_json::json!({
+            "kind": "call_expression",
+            "all": [
+                {"regex": "^log"},
+                {"inside": {"kind": "function_declaration", "stopBy": "end",
+                            "has": {"field": "name", "regex": "^Test"}}}
+            ],
+            "not": {"has": {"kind": "comment"}},
+            "follows": {"pattern": "defer $F()"}
+        });
+        let found = steps(&rule);
+        let relations: Vec<&str> = found.iter().map(|step| step.relation).collect();
+        assert_eq!(relations, ["inside", "follows"]);
+        assert_eq!(found[0].stop_by, "end");
+        assert_eq!(found[0].rule["kind"], "function_declaration");
+        assert!(found[0].rule.get("stopBy").is_none());
+        assert_eq!(found[0].steps[0].relation, "has");
+        assert_eq!(found[0].steps[0].field.as_deref(), Some("name"));
+
+        let checked = checks(&rule);
+        assert_eq!(checked["kind"], "call_expression");
+        assert_eq!(checked["regex"], "^log");
+        assert!(checked.contains_key("not"));
+        assert!(!checked.contains_key("follows"));
+    }
+
+    #[test]
+    fn test_locate() {
+        let candidates = [span(0, 100), span(10, 50), span(20, 30), span(60, 70)];
+        // Innermost enclosing, never the node itself
+        assert_eq!(locate("inside", (20, 30), &candidates), Some(1));
+        // Outermost within, first by position
+        assert_eq!(locate("has", (0, 100), &candidates), Some(1));
+        assert_eq!(locate("follows", (60, 70), &candidates), Some(1));
+        assert_eq!(locate("precedes", (20, 30), &candidates), Some(3));
+        assert_eq!(locate("has", (60, 70), &candidates), None);
+
+        // A nested step is located from the node its parent found
+        let rule = serde_json::json!({"inside": {"kind": "block", "inside": {"kind": "func"}}});
+        let nested = steps(&rule);
+        assert_eq!(flatten(&nested).len(), 2);
+        let found = [
+            (vec![span(10, 50)], vec![serde_json::json!("block")]),
+            (
+                vec![span(0, 100), span(60, 70)],
+                vec![serde_json::json!("func"), serde_json::json!("other")],
+            ),
+        ];
+        let traced = trace(&nested, Some((20, 30)), &found, &mut 0);
+        assert_eq!(traced[0]["node"], "block");
+        assert_eq!(traced[0]["steps"][0]["node"], "func");
+        let traced = trace(&nested, Some((60, 70)), &found, &mut 0);
+        assert!(traced[0]["node"].is_null());
+        assert!(traced[0]["steps"][0]["node"].is_null());
+
+        let m = serde_json::json!({"metaVariables": {
+            "single": {"F": {"text": "Close"}},
+            "multi": {"ARGS": [{"text": "a"}, {"text": "b"}]},
+            "transformed": {"UPPER": "CLOSE"}
+        }});
+        let bound = captures(&m);
+        assert_eq!(bound["F"], "Close");
+        assert_eq!(bound["ARGS"], serde_json::json!(["a", "b"]));
+        assert_eq!(bound["UPPER"], "CLOSE");
+        let config = serde_json::json!({"constraints": {"F": {"regex": "^C"}}});
+        assert_eq!(constraints(&config, &m)[0]["value"], "Close");
+        assert_eq!(first_line("func f() {\n}"), "func f() { …");
+    }
+}
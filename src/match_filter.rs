@@ -0,0 +1,411 @@
+//! Size, depth and content filters applied to ast-grep matches, written as
+//! selector pseudo-classes: `:longer-than(200)`, `:spanning-lines(50)`,
+//! `:depth(2)`, `:contains(call_expression:matches(/panic/))`.
+//!
+//! - `:longer-than(N)` keeps matches whose text is more than `N` characters
+//!   (exclusive: exactly `N` characters is not longer than `N`).
+//! - `:spanning-lines(N)` keeps matches covering at least `N` lines,
+//!   counting the first and last line (inclusive: a match on lines 3-52
+//!   spans 50 lines and is kept by `:spanning-lines(50)`).
+//! - `:depth(N)` keeps matches `N` named nodes below the root: the root's
+//!   named children are at depth 1, theirs at depth 2. Unnamed tokens such
+//!   as punctuation do not count. It is the number of steps in the match's
+//!   node id, so `/12/3` is at depth 2.
+//! - `:top-level` is `:depth(1)`, the file's top-level items.
+//! - `:contains(S)` keeps matches with a node inside them, at any depth,
+//!   that the selector `S` picks. `S` is a node kind, `:matches(/regex/)`
+//!   on the node's text, or a kind followed by `:matches`. Unlike a rule's
+//!   `has`, which only looks at direct children unless told otherwise,
+//!   every descendant counts; the match itself does not.
+//!
+//! Several pseudo-classes can be chained and must all hold.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::fmt;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum FilterCondition {
+    LongerThan(usize),
+    SpanningLines(usize),
+    Depth(usize),
+    Contains(NodeSelector),
+}
+
+/// The nodes a `:contains()` looks for: those of a kind, those whose text
+/// matches a regex, or both.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct NodeSelector {
+    pub kind: Option<String>,
+    pub regex: Option<String>,
+}
+
+impl NodeSelector {
+    /// Parse `kind`, `:matches(/regex/)` or `kind:matches(/regex/)`. A `/`
+    /// in the regex is written `\/`.
+    pub fn parse(selector: &str) -> Result<Self> {
+        let selector = selector.trim();
+        let kind_end = selector
+            .find(|c: char| !(c.is_ascii_alphanumeric() || c == '_'))
+            .unwrap_or(selector.len());
+        let kind = (kind_end > 0).then(|| selector[..kind_end].to_string());
+        let rest = selector[kind_end..].trim();
+        let regex = match rest {
+            "" => None,
+            _ => {
+                let literal = rest
+                    .strip_prefix(":matches(")
+                    .and_then(|rest| rest.strip_suffix(')'))
+                    .map(str::trim)
+                    .and_then(|rest| rest.strip_prefix('/'))
+                    .and_then(|rest| rest.strip_suffix('/'))
+                    .ok_or_else(|| {
+                        anyhow!(
+                            "Expected a node kind and/or :matches(/regex/) in ':contains({})'",
+                            selector
+                        )
+                    })?;
+                let regex = literal.replace("\\/", "/");
+                regex::Regex::new(&regex)
+                    .map_err(|e| anyhow!("Invalid regex in ':contains({})': {}", selector, e))?;
+                Some(regex)
+            }
+        };
+        if kind.is_none() && regex.is_none() {
+            return Err(anyhow!(
+                ":contains() needs a node kind or :matches(/regex/)"
+            ));
+        }
+        Ok(Self { kind, regex })
+    }
+
+    /// Rule matching the nodes the selector picks.
+    pub fn rule(&self, language: &str) -> String {
+        let mut rule = format!("id: contains\nlanguage: {language}\nrule:\n");
+        if let Some(kind) = &self.kind {
+            rule.push_str(&format!("  kind: {kind}\n"));
+        }
+        if let Some(regex) = &self.regex {
+            // A JSON string is a valid double-quoted YAML scalar
+            rule.push_str(&format!(
+                "  regex: {}\n",
+                serde_json::to_string(regex).unwrap_or_default()
+            ));
+        }
+        rule
+    }
+}
+
+impl fmt::Display for NodeSelector {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        if let Some(kind) = &self.kind {
+            f.write_str(kind)?;
+        }
+        if let Some(regex) = &self.regex {
+            write!(f, ":matches(/{}/)", regex.replace('/', "\\/"))?;
+        }
+        Ok(())
+    }
+}
+
+/// Offset of the `)` closing the `(` at `open`, skipping parentheses
+/// nested in it and any inside a `/regex/`.
+fn closing_paren(text: &str, open: usize) -> Option<usize> {
+    let mut depth = 0;
+    let mut in_regex = false;
+    let mut escaped = false;
+    for (i, c) in text[open..].char_indices() {
+        match c {
+            _ if escaped => escaped = false,
+            '\\' if in_regex => escaped = true,
+            '/' => in_regex = !in_regex,
+            _ if in_regex => {}
+            '(' => depth += 1,
+            ')' => {
+                depth -= 1;
+                if depth == 0 {
+                    return Some(open + i);
+                }
+            }
+            _ => {}
+        }
+    }
+    None
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct MatchFilter {
+    conditions: Vec<FilterCondition>,
+}
+
+impl MatchFilter {
+    /// Parse a chain of pseudo-classes such as `:spanning-lines(50)`.
+    pub fn parse(selector: &str) -> Result<Self> {
+        Self::parse_at(selector).map_err(|(_, e)| e)
+    }
+
+    /// Like `parse`, with the byte offset in `selector` of the
+    /// pseudo-class an error is about.
+    pub fn parse_at(selector: &str) -> std::result::Result<Self, (usize, anyhow::Error)> {
+        let mut conditions = Vec::new();
+        let mut rest = selector.trim();
+        while !rest.is_empty() {
+            let offset = selector.len() - rest.len();
+            let fail = |e: anyhow::Error| (offset, e);
+            let body = rest.strip_prefix(':').ok_or_else(|| {
+                fail(anyhow!(
+                    "Expected ':' at '{}' in filter '{}'",
+                    rest,
+                    selector
+                ))
+            })?;
+            let name_end = body
+                .find(|c: char| !(c.is_ascii_alphanumeric() || c == '-'))
+                .unwrap_or(body.len());
+            if &body[..name_end] == "top-level" && !body[name_end..].starts_with('(') {
+                conditions.push(FilterCondition::Depth(1));
+                rest = body[name_end..].trim_start();
+                continue;
+            }
+            let open = body.find('(').ok_or_else(|| {
+                fail(anyhow!(
+                    "Missing '(' after ':{}' in filter '{}'",
+                    body,
+                    selector
+                ))
+            })?;
+            let close = closing_paren(body, open)
+                .ok_or_else(|| fail(anyhow!("Missing ')' in filter '{}'", selector)))?;
+            let name = body[..open].trim();
+            let argument = body[open + 1..close].trim();
+            if name == "contains" {
+                conditions.push(FilterCondition::Contains(
+                    NodeSelector::parse(argument).map_err(fail)?,
+                ));
+                rest = body[close + 1..].trim_start();
+                continue;
+            }
+            let n: usize = argument.parse().map_err(|_| {
+                fail(anyhow!(
+                    ":{}() takes a non-negative integer, got '{}'",
+                    name,
+                    argument
+                ))
+            })?;
+            conditions.push(match name {
+                "longer-than" => FilterCondition::LongerThan(n),
+                "spanning-lines" => FilterCondition::SpanningLines(n),
+                "depth" => FilterCondition::Depth(n),
+                _ => {
+                    return Err(fail(anyhow!(
+                        "Unknown pseudo-class ':{}'. Use :longer-than(N), :spanning-lines(N), :depth(N), :top-level or :contains(selector)",
+                        name
+                    )))
+                }
+            });
+            rest = body[close + 1..].trim_start();
+        }
+        if conditions.is_empty() {
+            return Err((0, anyhow!("Empty filter")));
+        }
+        Ok(Self { conditions })
+    }
+
+    /// Whether a condition needs the match's place in the syntax tree,
+    /// which ast-grep does not report: the matches must carry a `nodeId`.
+    pub fn needs_node_ids(&self) -> bool {
+        self.conditions
+            .iter()
+            .any(|condition| matches!(condition, FilterCondition::Depth(_)))
+    }
+
+    /// The selectors of the `:contains()` conditions, in order. Whether a
+    /// match contains a node each one picks takes a scan of its file, so
+    /// the caller works it out and sets the match's `contains` to one flag
+    /// per selector.
+    pub fn contains_selectors(&self) -> Vec<&NodeSelector> {
+        self.conditions
+            .iter()
+            .filter_map(|condition| match condition {
+                FilterCondition::Contains(selector) => Some(selector),
+                _ => None,
+            })
+            .collect()
+    }
+
+    /// Whether an entry of ast-grep's `--json` output passes every condition.
+    /// A match without a `nodeId` fails any depth condition, and one without
+    /// `contains` flags any `:contains()`.
+    pub fn keeps(&self, m: &Value) -> bool {
+        let mut contains = 0;
+        self.conditions.iter().all(|condition| match *condition {
+            FilterCondition::LongerThan(n) => {
+                m["text"].as_str().map_or(0, |text| text.chars().count()) > n
+            }
+            FilterCondition::SpanningLines(n) => {
+                let line = |key: &str| m["range"][key]["line"].as_u64().unwrap_or(0);
+                (line("end").saturating_sub(line("start")) + 1) as usize >= n
+            }
+            FilterCondition::Depth(n) => m["nodeId"]
+                .as_str()
+                .is_some_and(|id| id.matches('/').count() == n),
+            FilterCondition::Contains(_) => {
+                contains += 1;
+                m["contains"][contains - 1].as_bool() == Some(true)
+            }
+        })
+    }
+
+    pub fn apply(&self, matches: Vec<Value>) -> Vec<Value> {
+        matches.into_iter().filter(|m| self.keeps(m)).collect()
+    }
+}
+
+/// The filter in canonical form, one space between pseudo-classes.
+impl fmt::Display for MatchFilter {
+    fn fmt(&self, f: &mut fmt::Formatter) -> fmt::Result {
+        let conditions: Vec<String> = self
+            .conditions
+            .iter()
+            .map(|condition| match condition {
+                FilterCondition::LongerThan(n) => format!(":longer-than({n})"),
+                FilterCondition::SpanningLines(n) => format!(":spanning-lines({n})"),
+                FilterCondition::Depth(1) => ":top-level".to_string(),
+                FilterCondition::Depth(n) => format!(":depth({n})"),
+                FilterCondition::Contains(selector) => format!(":contains({selector})"),
+            })
+            .collect();
+        f.write_str(&conditions.join(" "))
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn node(text: &str, start_line: u64, end_line: u64) -> Value {
+        serde_json::json!({
+            "text": text,
+            "range": {
+                "start": {"line": start_line, "column": 0},
+                "end": {"line": end_line, "column": 1}
+            }
+        })
+    }
+
+    #[test]
+    fn test_boundaries() {
+        let longer = MatchFilter::parse(":longer-than(3)").unwrap();
+        assert!(!longer.keeps(&node("abc", 0, 0)));
+        assert!(longer.keeps(&node("abcd", 0, 0)));
+        // Characters, not bytes
+        assert!(!longer.keeps(&node("😀😀😀", 0, 0)));
+
+        let spanning = MatchFilter::parse(":spanning-lines(50)").unwrap();
+        assert!(spanning.keeps(&node("", 2, 51)));
+        assert!(!spanning.keeps(&node("", 2, 50)));
+    }
+
+    #[test]
+    fn test_chained_conditions() {
+        let filter = MatchFilter::parse(" :spanning-lines(2) :longer-than(5) ").unwrap();
+        assert!(filter.keeps(&node("fn a() {\n}", 0, 1)));
+        assert!(!filter.keeps(&node("{\n}", 0, 1)));
+        assert!(!filter.keeps(&node("fn a() {}", 0, 0)));
+    }
+
+    #[test]
+    fn test_depth_conditions() {
+        let at = |id: Option<&str>| {
+            let mut m = node("x", 0, 0);
+            m["nodeId"] = id.into();
+            m
+        };
+        let top_level = MatchFilter::parse(":top-level").unwrap();
+        assert!(top_level.needs_node_ids());
+        assert!(top_level.keeps(&at(Some("/4"))));
+        assert!(!top_level.keeps(&at(Some("/4/0"))));
+        assert!(!top_level.keeps(&at(None)));
+
+        let filter = MatchFilter::parse(":depth(2) :longer-than(0)").unwrap();
+        assert!(filter.keeps(&at(Some("/4/0"))));
+        assert!(!filter.keeps(&at(Some("/4/0/1"))));
+        assert!(!MatchFilter::parse(":longer-than(0)")
+            .unwrap()
+            .needs_node_ids());
+
+        assert_eq!(
+            MatchFilter::parse(":depth(1):depth(3)")
+                .unwrap()
+                .to_string(),
+            ":top-level :depth(3)"
+        );
+        assert!(MatchFilter::parse(":top-level(1)").is_err());
+        assert!(MatchFilter::parse(":top").is_err());
+    }
+
+    #[test]
+    fn test_contains_conditions() {
+        let filter =
+            MatchFilter::parse(r":contains(call_expression:matches(/panic\(/)) :longer-than(1)")
+                .unwrap();
+        assert_eq!(
+            filter.contains_selectors(),
+            [&NodeSelector {
+                kind: Some("call_expression".to_string()),
+                regex: Some(r"panic\(".to_string()),
+            }]
+        );
+        assert!(!filter.needs_node_ids());
+        let flagged = |flags: Value| {
+            let mut m = node("func f() { panic(1) }", 0, 0);
+            m["contains"] = flags;
+            m
+        };
+        assert!(filter.keeps(&flagged(serde_json::json!([true]))));
+        assert!(!filter.keeps(&flagged(serde_json::json!([false]))));
+        assert!(!filter.keeps(&node("func f() {}", 0, 0)));
+
+        // A `/` in the regex is escaped, and parentheses there do not nest
+        let filter = MatchFilter::parse(r":contains(:matches(/[)]a\/b/)):top-level").unwrap();
+        assert_eq!(
+            filter.contains_selectors()[0].regex.as_deref(),
+            Some("[)]a/b")
+        );
+        assert_eq!(
+            filter.to_string(),
+            r":contains(:matches(/[)]a\/b/)) :top-level"
+        );
+        assert_eq!(
+            NodeSelector::parse("identifier").unwrap().rule("go"),
+            "id: contains\nlanguage: go\nrule:\n  kind: identifier\n"
+        );
+        assert!(NodeSelector::parse(r#"call_expression:matches(/"x"/)"#)
+            .unwrap()
+            .rule("go")
+            .ends_with("  kind: call_expression\n  regex: \"\\\"x\\\"\"\n"));
+
+        assert!(MatchFilter::parse(":contains()").is_err());
+        assert!(MatchFilter::parse(":contains(call_expression:has(x))").is_err());
+        assert!(MatchFilter::parse(":contains(:matches(/(/))").is_err());
+        assert!(MatchFilter::parse(":contains(identifier").is_err());
+    }
+
+    #[test]
+    fn test_parse_errors() {
+        assert!(MatchFilter::parse("").is_err());
+        assert!(MatchFilter::parse(":shorter-than(3)").is_err());
+        assert!(MatchFilter::parse(":longer-than(-1)").is_err());
+        assert!(MatchFilter::parse(":longer-than(3").is_err());
+        assert!(MatchFilter::parse("longer-than(3)").is_err());
+
+        let (offset, _) = MatchFilter::parse_at(":longer-than(3) :wider-than(2)").unwrap_err();
+        assert_eq!(offset, 16);
+        assert_eq!(
+            MatchFilter::parse("  :spanning-lines( 2 ):longer-than(5)")
+                .unwrap()
+                .to_string(),
+            ":spanning-lines(2) :longer-than(5)"
+        );
+    }
+}
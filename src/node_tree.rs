@@ -0,0 +1,357 @@
+//! The syntax tree of a file, rebuilt from ast-grep matches, and node ids
+//! that name a node by its place in that tree rather than by byte offsets.
+//!
+//! An id is the path of 0-based named-child indices from the root, such as
+//! `/12/3/0`: the first named child of the fourth named child of the root's
+//! thirteenth. Edits elsewhere in the file shift offsets but leave the id
+//! valid, as long as no named node is added or removed before the node on
+//! its path.
+//!
+//! ast-grep reports matches rather than trees, so the tree is rebuilt from
+//! one match per named node below the root (see [`NODE_RULE`]): nested
+//! ranges give the parents, and document order gives the child indices.
+
+use crate::edit_utils::NodeSpan;
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::collections::VecDeque;
+
+/// Longest leaf text a tree dump shows before cutting it off.
+const DUMP_TEXT_BYTES: usize = 80;
+
+/// Rule body matching every named node that has a parent, i.e. all of them
+/// but the root. `nthChild` counts named siblings only.
+pub const NODE_RULE: &str = "nthChild:\n    position: n+1\n";
+
+struct TreeNode {
+    span: NodeSpan,
+    kind: String,
+    children: Vec<usize>,
+    id: String,
+}
+
+/// The named nodes of one file, arranged as a tree.
+pub struct NodeTree {
+    matches: Vec<Value>,
+    nodes: Vec<TreeNode>,
+    /// Named children of the root
+    roots: Vec<usize>,
+}
+
+impl NodeTree {
+    /// Rebuild the tree from the matches of [`NODE_RULE`] in one file.
+    pub fn from_matches(mut matches: Vec<Value>) -> Self {
+        matches.retain(|m| NodeSpan::from_match(m).is_some());
+        // ast-grep reports nodes in document order, parents first; keep that
+        // order for nodes sharing a range, such as a statement and its
+        // expression
+        matches.sort_by_key(|m| {
+            let span = NodeSpan::from_match(m).unwrap();
+            (span.start, std::cmp::Reverse(span.end))
+        });
+
+        let mut nodes: Vec<TreeNode> = Vec::with_capacity(matches.len());
+        let mut roots = Vec::new();
+        let mut open: Vec<usize> = Vec::new();
+        for m in &matches {
+            let span = NodeSpan::from_match(m).unwrap();
+            while let Some(&parent) = open.last() {
+                let parent_span = &nodes[parent].span;
+                if parent_span.start <= span.start && span.end <= parent_span.end {
+                    break;
+                }
+                open.pop();
+            }
+            let index = nodes.len();
+            let id = match open.last() {
+                Some(&parent) => {
+                    nodes[parent].children.push(index);
+                    format!("{}/{}", nodes[parent].id, nodes[parent].children.len() - 1)
+                }
+                None => {
+                    roots.push(index);
+                    format!("/{}", roots.len() - 1)
+                }
+            };
+            nodes.push(TreeNode {
+                span,
+                kind: m["kind"].as_str().unwrap_or_default().to_string(),
+                children: Vec::new(),
+                id,
+            });
+            open.push(index);
+        }
+        Self {
+            matches,
+            nodes,
+            roots,
+        }
+    }
+
+    /// Id of the node spanning `start..end`. Of nodes sharing that range,
+    /// the one of `kind` if given, otherwise the outermost.
+    pub fn id_of(&self, start: usize, end: usize, kind: Option<&str>) -> Option<&str> {
+        let mut same_range = self
+            .nodes
+            .iter()
+            .filter(|node| (node.span.start, node.span.end) == (start, end));
+        let node = match kind {
+            Some(kind) => same_range.find(|node| node.kind == kind),
+            None => same_range.next(),
+        }?;
+        Some(&node.id)
+    }
+
+    /// The match of the node `id` names, or why it no longer resolves.
+    pub fn resolve(&self, id: &str) -> Result<&Value> {
+        let path = id
+            .strip_prefix('/')
+            .filter(|path| !path.is_empty())
+            .ok_or_else(|| anyhow!("Node id '{}' must be a path below the root, e.g. /0/2", id))?;
+
+        let mut children = &self.roots;
+        let mut node = None;
+        let mut walked = String::new();
+        for step in path.split('/') {
+            let index: usize = step
+                .parse()
+                .map_err(|_| anyhow!("Node id '{}' has a non-numeric step '{}'", id, step))?;
+            let &child = children.get(index).ok_or_else(|| {
+                anyhow!(
+                    "Node id '{}' no longer resolves: {} has {} named children",
+                    id,
+                    if walked.is_empty() {
+                        "the root"
+                    } else {
+                        walked.as_str()
+                    },
+                    children.len()
+                )
+            })?;
+            walked = self.nodes[child].id.clone();
+            children = &self.nodes[child].children;
+            node = Some(child);
+        }
+        Ok(&self.matches[node.unwrap()])
+    }
+
+    /// The tree as nested JSON nodes with their id, kind and lines, and the
+    /// text of leaves. Only nodes whose kind passes `keep` are shown, with
+    /// the ancestors on their path; a node all of whose children are
+    /// pruned is shown as a leaf. With a budget, nodes are expanded
+    /// breadth-first while the compact JSON stays within `max_total_bytes`,
+    /// so the top levels are shown first; a node left unexpanded has
+    /// `truncated: true` and its `childCount`. Returns the shown children
+    /// of the root and whether that list itself was cut.
+    pub fn dump(
+        &self,
+        max_total_bytes: Option<usize>,
+        keep: impl Fn(&str) -> bool,
+    ) -> (Vec<Value>, bool) {
+        // Parents are stored before their children, so a backward pass
+        // sees every child first
+        let mut kept = vec![false; self.nodes.len()];
+        for node in (0..self.nodes.len()).rev() {
+            kept[node] = keep(&self.nodes[node].kind)
+                || self.nodes[node].children.iter().any(|&child| kept[child]);
+        }
+        let shown = |children: &[usize]| -> Vec<usize> {
+            children
+                .iter()
+                .copied()
+                .filter(|&child| kept[child])
+                .collect()
+        };
+        let children: Vec<Vec<usize>> = self
+            .nodes
+            .iter()
+            .map(|node| shown(&node.children))
+            .collect();
+        let roots = shown(&self.roots);
+
+        let budget = max_total_bytes.unwrap_or(usize::MAX);
+        // Entries are priced with their truncation marker, so the dump
+        // stays within budget wherever the expansion stops
+        let cost = |nodes: &[usize]| -> usize {
+            nodes
+                .iter()
+                .map(|&node| self.entry(node, &children).to_string().len() + 1)
+                .sum()
+        };
+        let mut used = "[]".len();
+        if used + cost(&roots) > budget {
+            return (Vec::new(), !roots.is_empty());
+        }
+        used += cost(&roots);
+
+        let mut expanded = vec![false; self.nodes.len()];
+        let mut queue: VecDeque<usize> = roots.iter().copied().collect();
+        while let Some(node) = queue.pop_front() {
+            if children[node].is_empty() {
+                continue;
+            }
+            let expansion = cost(&children[node]) + r#","children":[]"#.len();
+            if used + expansion > budget {
+                break;
+            }
+            used += expansion;
+            expanded[node] = true;
+            queue.extend(&children[node]);
+        }
+        let nodes = roots
+            .iter()
+            .map(|&root| self.dump_node(root, &children, &expanded))
+            .collect();
+        (nodes, false)
+    }
+
+    fn dump_node(&self, node: usize, children: &[Vec<usize>], expanded: &[bool]) -> Value {
+        let mut entry = self.entry(node, children);
+        if expanded[node] {
+            let object = entry.as_object_mut().unwrap();
+            object.remove("truncated");
+            object.remove("childCount");
+            entry["children"] = children[node]
+                .iter()
+                .map(|&child| self.dump_node(child, children, expanded))
+                .collect();
+        }
+        entry
+    }
+
+    /// A node's dump entry without its children.
+    fn entry(&self, node: usize, children: &[Vec<usize>]) -> Value {
+        let tree_node = &self.nodes[node];
+        let range = &self.matches[node]["range"];
+        let line = |position: &str| range[position]["line"].as_u64().map(|line| line + 1);
+        let mut entry = serde_json::json!({
+            "id": tree_node.id,
+            "kind": tree_node.kind,
+            "lines": [line("start"), line("end")],
+        });
+        if children[node].is_empty() {
+            let text = &tree_node.span.text;
+            entry["text"] = match text.len() > DUMP_TEXT_BYTES {
+                true => {
+                    let mut end = DUMP_TEXT_BYTES;
+                    while !text.is_char_boundary(end) {
+                        end -= 1;
+                    }
+                    format!("{}...", &text[..end])
+                }
+                false => text.clone(),
+            }
+            .into();
+        } else {
+            entry["truncated"] = true.into();
+            entry["childCount"] = children[node].len().into();
+        }
+        entry
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn node(kind: &str, start: usize, end: usize) -> Value {
+        serde_json::json!({"kind": kind, "range": {"byteOffset": {"start": start, "end": end}}})
+    }
+
+    /// `a := f(x)` followed by `g()`, reported out of order
+    fn tree() -> NodeTree {
+        NodeTree::from_matches(vec![
+            node("expression_statement", 11, 14),
+            node("short_var_declaration", 0, 10),
+            node("expression_list", 0, 1),
+            node("identifier", 0, 1),
+            node("expression_list", 5, 10),
+            node("call_expression", 5, 10),
+            node("identifier", 5, 6),
+            node("argument_list", 6, 10),
+            node("identifier", 7, 8),
+            node("call_expression", 11, 14),
+        ])
+    }
+
+    #[test]
+    fn test_ids_follow_named_child_indices() {
+        let tree = tree();
+        assert_eq!(tree.id_of(0, 10, None), Some("/0"));
+        assert_eq!(tree.id_of(7, 8, None), Some("/0/1/0/1/0"));
+        assert_eq!(tree.id_of(5, 10, None), Some("/0/1"));
+        assert_eq!(tree.id_of(5, 10, Some("call_expression")), Some("/0/1/0"));
+        assert_eq!(tree.id_of(11, 14, Some("call_expression")), Some("/1/0"));
+        assert_eq!(tree.id_of(1, 2, None), None);
+
+        assert_eq!(tree.resolve("/0/1/0/1/0").unwrap()["kind"], "identifier");
+        assert_eq!(tree.resolve("/1").unwrap()["kind"], "expression_statement");
+    }
+
+    #[test]
+    fn test_stale_ids_explain_where_they_break() {
+        let tree = tree();
+        let error = tree.resolve("/0/1/0/5").unwrap_err().to_string();
+        assert!(error.contains("/0/1/0 has 2 named children"), "{error}");
+        let error = tree.resolve("/2").unwrap_err().to_string();
+        assert!(error.contains("the root has 2 named children"), "{error}");
+        assert!(tree.resolve("/").is_err());
+        assert!(tree.resolve("/a").is_err());
+    }
+
+    #[test]
+    fn test_dump_nests_nodes_by_range() {
+        let nodes = tree().dump(None, |_| true).0;
+        assert_eq!(nodes.len(), 2);
+        assert_eq!(nodes[0]["kind"], "short_var_declaration");
+        assert_eq!(nodes[0]["children"][1]["children"][0]["id"], "/0/1/0");
+        assert_eq!(
+            nodes[0]["children"][1]["children"][0]["kind"],
+            "call_expression"
+        );
+        assert_eq!(nodes[1]["children"][0]["kind"], "call_expression");
+        assert!(nodes[1]["children"][0].get("children").is_none());
+        assert!(nodes[0].get("truncated").is_none());
+    }
+
+    #[test]
+    fn test_dump_expands_breadth_first_within_budget() {
+        let tree = tree();
+        let (nodes, cut) = tree.dump(Some(0), |_| true);
+        assert!(nodes.is_empty());
+        assert!(cut);
+
+        // Room for both statements and their children, but no deeper
+        let (shallow, cut) = tree.dump(Some(600), |_| true);
+        assert!(!cut);
+        let size = serde_json::to_string(&shallow).unwrap().len();
+        assert!(size <= 600, "{size}");
+        assert!(shallow[0]["children"].is_array());
+        assert_eq!(shallow[0]["children"][1]["truncated"], true);
+        assert_eq!(shallow[0]["children"][1]["childCount"], 1);
+    }
+
+    #[test]
+    fn test_dump_keeps_the_path_to_kept_kinds() {
+        let tree = tree();
+        let calls = tree.dump(None, |kind| kind == "call_expression").0;
+        assert_eq!(calls.len(), 2);
+        assert_eq!(calls[0]["kind"], "short_var_declaration");
+        // The assigned identifier holds no call, so only the value's path is left
+        assert_eq!(calls[0]["children"].as_array().unwrap().len(), 1);
+        assert_eq!(calls[0]["children"][0]["kind"], "expression_list");
+        let call = &calls[0]["children"][0]["children"][0];
+        assert_eq!(call["kind"], "call_expression");
+        assert!(call.get("children").is_none());
+        assert!(call["text"].is_string());
+        assert_eq!(calls[1]["children"][0]["kind"], "call_expression");
+
+        let named = tree.dump(None, |kind| kind != "identifier").0;
+        let call = &named[0]["children"][1]["children"][0];
+        assert_eq!(call["children"].as_array().unwrap().len(), 1);
+        assert_eq!(call["children"][0]["kind"], "argument_list");
+        assert!(call["children"][0].get("children").is_none());
+
+        assert!(tree.dump(None, |kind| kind == "block").0.is_empty());
+    }
+}
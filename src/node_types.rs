@@ -0,0 +1,247 @@
+//! The node types of tree-sitter grammars: which fields each kind of node
+//! has, and which kinds each field may hold.
+//!
+//! ast-grep does not expose its grammars' `node-types.json`, so a bundled
+//! language's file is fetched from the grammar's repository the first time
+//! it is needed, at the version the pinned ast-grep release builds with.
+//! The caller keeps it, since it only changes with the grammar. A custom
+//! grammar names its own file in the config.
+
+use anyhow::{anyhow, Result};
+use serde_json::Value;
+use std::path::Path;
+
+/// Where each bundled language's `node-types.json` lives, at the grammar
+/// versions ast-grep 0.38.7 (AST_GREP_VERSION in `binary_manager.rs`)
+/// builds with. Update them together.
+const NODE_TYPES_URLS: &[(&str, &str)] = &[
+    ("c", "tree-sitter/tree-sitter-c/v0.24.1/src"),
+    ("cpp", "tree-sitter/tree-sitter-cpp/v0.23.4/src"),
+    ("csharp", "tree-sitter/tree-sitter-c-sharp/v0.23.1/src"),
+    ("css", "tree-sitter/tree-sitter-css/v0.23.2/src"),
+    ("go", "tree-sitter/tree-sitter-go/v0.23.4/src"),
+    ("html", "tree-sitter/tree-sitter-html/v0.23.2/src"),
+    ("java", "tree-sitter/tree-sitter-java/v0.23.5/src"),
+    (
+        "javascript",
+        "tree-sitter/tree-sitter-javascript/v0.23.1/src",
+    ),
+    ("python", "tree-sitter/tree-sitter-python/v0.23.6/src"),
+    ("rust", "tree-sitter/tree-sitter-rust/v0.24.0/src"),
+    // The generated sources are only kept on tags of their own
+    (
+        "swift",
+        "alex-pinkus/tree-sitter-swift/0.7.0-with-generated-files/src",
+    ),
+    (
+        "typescript",
+        "tree-sitter/tree-sitter-typescript/v0.23.2/typescript/src",
+    ),
+];
+
+/// URL of the `node-types.json` of `language`, or `None` for a language
+/// ast-grep does not bundle.
+pub fn node_types_url(language: &str) -> Option<String> {
+    let language = match language {
+        "c++" => "cpp",
+        "cs" => "csharp",
+        language => language,
+    };
+    NODE_TYPES_URLS
+        .iter()
+        .find(|(name, _)| *name == language)
+        .map(|(_, path)| format!("https://raw.githubusercontent.com/{path}/node-types.json"))
+}
+
+/// Download the node types of a language ast-grep bundles.
+pub async fn fetch(language: &str) -> Result<Value> {
+    let url = node_types_url(language).ok_or_else(|| {
+        anyhow!(
+            "The node types of {} are not known; a custom grammar can name its node-types.json with node_types_path",
+            language
+        )
+    })?;
+    let response = reqwest::Client::new().get(&url).send().await?;
+    if !response.status().is_success() {
+        return Err(anyhow!(
+            "Failed to download {}: HTTP {}",
+            url,
+            response.status()
+        ));
+    }
+    parse(&response.bytes().await?, &url)
+}
+
+/// The node types in a grammar's `node-types.json`.
+pub fn from_file(path: &Path) -> Result<Value> {
+    let bytes =
+        std::fs::read(path).map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+    parse(&bytes, &path.display().to_string())
+}
+
+fn parse(bytes: &[u8], source: &str) -> Result<Value> {
+    let node_types: Value = serde_json::from_slice(bytes)
+        .map_err(|e| anyhow!("{} is not valid JSON: {}", source, e))?;
+    if !node_types.is_array() {
+        return Err(anyhow!("{} is not a list of node types", source));
+    }
+    Ok(node_types)
+}
+
+/// The fields of the named node type `kind`, each with whether it is
+/// required, whether it holds several nodes, and the kinds it may hold.
+/// Anonymous kinds are quoted, as tree-sitter queries write them. Children
+/// outside any field are described the same way under `children`, and a
+/// supertype lists its `subtypes` instead. Returns `None` if the grammar
+/// has no such type.
+pub fn describe(node_types: &Value, kind: &str) -> Option<Value> {
+    let node_type = node_types
+        .as_array()?
+        .iter()
+        .find(|node_type| node_type["type"] == kind && node_type["named"] == true)?;
+    let kinds = |types: &Value| -> Vec<String> {
+        types
+            .as_array()
+            .into_iter()
+            .flatten()
+            .filter_map(|t| {
+                let name = t["type"].as_str()?;
+                Some(match t["named"].as_bool() {
+                    Some(true) => name.to_string(),
+                    _ => format!("{name:?}"),
+                })
+            })
+            .collect()
+    };
+    let child = |info: &Value| {
+        serde_json::json!({
+            "required": info["required"].as_bool().unwrap_or(false),
+            "multiple": info["multiple"].as_bool().unwrap_or(false),
+            "types": kinds(&info["types"]),
+        })
+    };
+    let mut fields: Vec<Value> = node_type["fields"]
+        .as_object()
+        .into_iter()
+        .flatten()
+        .map(|(name, info)| {
+            let mut field = child(info);
+            field["name"] = name.as_str().into();
+            field
+        })
+        .collect();
+    fields.sort_by(|a, b| a["name"].as_str().cmp(&b["name"].as_str()));
+    Some(serde_json::json!({
+        "kind": kind,
+        "fields": fields,
+        "children": node_type.get("children").map(child),
+        "subtypes": node_type.get("subtypes").map(kinds),
+    }))
+}
+
+/// Named node types whose names contain `kind`, for suggesting the one
+/// meant. Underscores are ignored, so `funcdecl` finds `func_decl`.
+pub fn similar_types(node_types: &Value, kind: &str) -> Vec<String> {
+    let wanted = kind.replace('_', "").to_lowercase();
+    let mut similar: Vec<String> = node_types
+        .as_array()
+        .into_iter()
+        .flatten()
+        .filter(|node_type| node_type["named"] == true)
+        .filter_map(|node_type| node_type["type"].as_str())
+        .filter(|name| !name.starts_with('_'))
+        .filter(|name| name.replace('_', "").to_lowercase().contains(&wanted))
+        .map(str::to_string)
+        .collect();
+    similar.sort();
+    similar.dedup();
+    similar
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn go_types() -> Value {
+        serde_json::json!([
+            {
+                "type": "_expression",
+                "named": true,
+                "subtypes": [
+                    {"type": "call_expression", "named": true},
+                    {"type": "identifier", "named": true}
+                ]
+            },
+            {
+                "type": "function_declaration",
+                "named": true,
+                "fields": {
+                    "name": {"multiple": false, "required": true, "types": [{"type": "identifier", "named": true}]},
+                    "body": {"multiple": false, "required": false, "types": [{"type": "block", "named": true}]},
+                    "parameters": {"multiple": false, "required": true, "types": [{"type": "parameter_list", "named": true}]},
+                    "result": {"multiple": false, "required": false, "types": [
+                        {"type": "parameter_list", "named": true},
+                        {"type": "_simple_type", "named": true}
+                    ]}
+                }
+            },
+            {
+                "type": "inc_statement",
+                "named": true,
+                "fields": {},
+                "children": {"multiple": false, "required": true, "types": [{"type": "_expression", "named": true}]}
+            },
+            {"type": "func", "named": false},
+            {"type": "++", "named": false}
+        ])
+    }
+
+    #[test]
+    fn test_describe_fields() {
+        let node_types = go_types();
+        let function = describe(&node_types, "function_declaration").unwrap();
+        let names: Vec<&str> = function["fields"]
+            .as_array()
+            .unwrap()
+            .iter()
+            .map(|field| field["name"].as_str().unwrap())
+            .collect();
+        assert_eq!(names, ["body", "name", "parameters", "result"]);
+        assert_eq!(function["fields"][1]["required"], true);
+        assert_eq!(
+            function["fields"][3]["types"],
+            serde_json::json!(["parameter_list", "_simple_type"])
+        );
+        assert!(function["children"].is_null());
+
+        let statement = describe(&node_types, "inc_statement").unwrap();
+        assert_eq!(statement["fields"], serde_json::json!([]));
+        assert_eq!(
+            statement["children"]["types"],
+            serde_json::json!(["_expression"])
+        );
+
+        let expression = describe(&node_types, "_expression").unwrap();
+        assert_eq!(
+            expression["subtypes"],
+            serde_json::json!(["call_expression", "identifier"])
+        );
+
+        // Anonymous tokens are not node types to describe
+        assert!(describe(&node_types, "func").is_none());
+        assert!(describe(&node_types, "method_declaration").is_none());
+        assert_eq!(
+            similar_types(&node_types, "functiondeclaration"),
+            ["function_declaration"]
+        );
+        assert!(node_types_url("mojo").is_none());
+        assert_eq!(
+            node_types_url("c++").unwrap(),
+            "https://raw.githubusercontent.com/tree-sitter/tree-sitter-cpp/v0.23.4/src/node-types.json"
+        );
+        assert!(parse(b"{}", "node-types.json")
+            .unwrap_err()
+            .to_string()
+            .contains("not a list of node types"));
+    }
+}
@@ -0,0 +1,164 @@
+//! Cancellation and deadlines for long-running tool calls.
+//!
+//! A tool call gets an `OperationContext` that is cancelled when the MCP
+//! client cancels the request, and optionally carries a deadline from the
+//! call's `timeoutMs` argument. Bulk operations check it between files and
+//! return what they finished; single subprocess runs are raced against it.
+
+use std::future::Future;
+use std::sync::Arc;
+use std::time::Duration;
+use tokio::sync::watch;
+use tokio::time::Instant;
+
+/// Why an operation stopped before finishing.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum Interruption {
+    Cancelled,
+    TimedOut,
+}
+
+impl Interruption {
+    pub fn as_str(self) -> &'static str {
+        match self {
+            Self::Cancelled => "cancelled",
+            Self::TimedOut => "timed_out",
+        }
+    }
+}
+
+impl std::fmt::Display for Interruption {
+    fn fmt(&self, f: &mut std::fmt::Formatter<'_>) -> std::fmt::Result {
+        f.write_str(match self {
+            Self::Cancelled => "was cancelled",
+            Self::TimedOut => "timed out",
+        })
+    }
+}
+
+#[derive(Clone)]
+pub struct OperationContext {
+    cancelled: Arc<watch::Sender<bool>>,
+    deadline: Option<Instant>,
+}
+
+impl Default for OperationContext {
+    fn default() -> Self {
+        Self::new()
+    }
+}
+
+impl OperationContext {
+    pub fn new() -> Self {
+        let (cancelled, _) = watch::channel(false);
+        Self {
+            cancelled: Arc::new(cancelled),
+            deadline: None,
+        }
+    }
+
+    /// Add a deadline `timeout` from now, keeping any earlier one.
+    pub fn with_timeout(mut self, timeout: Duration) -> Self {
+        let deadline = Instant::now() + timeout;
+        self.deadline = Some(match self.deadline {
+            Some(existing) => existing.min(deadline),
+            None => deadline,
+        });
+        self
+    }
+
+    /// Apply the `timeoutMs` argument of a tool call, if present.
+    pub fn with_timeout_from_args(self, args: &serde_json::Value) -> Self {
+        match args["timeoutMs"].as_u64() {
+            Some(ms) => self.with_timeout(Duration::from_millis(ms)),
+            None => self,
+        }
+    }
+
+    /// Cancel the operation and every clone of this context.
+    pub fn cancel(&self) {
+        self.cancelled.send_replace(true);
+    }
+
+    /// Whether the operation should stop now, and why.
+    pub fn interruption(&self) -> Option<Interruption> {
+        if *self.cancelled.borrow() {
+            Some(Interruption::Cancelled)
+        } else if self
+            .deadline
+            .is_some_and(|deadline| Instant::now() >= deadline)
+        {
+            Some(Interruption::TimedOut)
+        } else {
+            None
+        }
+    }
+
+    /// Resolves once the operation is cancelled or its deadline passes.
+    pub async fn interrupted(&self) -> Interruption {
+        let mut receiver = self.cancelled.subscribe();
+        let cancelled = async move {
+            // The sender lives as long as `self`, so this only returns on cancel
+            let _ = receiver.wait_for(|cancelled| *cancelled).await;
+        };
+        match self.deadline {
+            Some(deadline) => tokio::select! {
+                _ = cancelled => Interruption::Cancelled,
+                _ = tokio::time::sleep_until(deadline) => Interruption::TimedOut,
+            },
+            None => {
+                cancelled.await;
+                Interruption::Cancelled
+            }
+        }
+    }
+
+    /// Run `future` unless the operation is interrupted first, in which case
+    /// it is dropped. Only use this for work that is safe to abandon.
+    pub async fn run<F: Future>(&self, future: F) -> Result<F::Output, Interruption> {
+        if let Some(interruption) = self.interruption() {
+            return Err(interruption);
+        }
+        tokio::select! {
+            output = future => Ok(output),
+            interruption = self.interrupted() => Err(interruption),
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[tokio::test]
+    async fn test_cancel_interrupts_run() {
+        let context = OperationContext::new();
+        let canceller = context.clone();
+        tokio::spawn(async move {
+            tokio::time::sleep(Duration::from_millis(10)).await;
+            canceller.cancel();
+        });
+
+        let result = context
+            .run(tokio::time::sleep(Duration::from_secs(30)))
+            .await;
+        assert_eq!(result, Err(Interruption::Cancelled));
+        assert_eq!(context.interruption(), Some(Interruption::Cancelled));
+    }
+
+    #[tokio::test]
+    async fn test_timeout_from_args() {
+        let context = OperationContext::new().with_timeout_from_args(&serde_json::json!({
+            "timeoutMs": 10
+        }));
+        assert_eq!(context.interruption(), None);
+        let result = context
+            .run(tokio::time::sleep(Duration::from_secs(30)))
+            .await;
+        assert_eq!(result, Err(Interruption::TimedOut));
+
+        // Work that finishes in time is unaffected
+        let context = OperationContext::new().with_timeout(Duration::from_secs(30));
+        assert_eq!(context.run(async { 42 }).await, Ok(42));
+    }
+}
@@ -0,0 +1,206 @@
+//! A record of every edit written to disk, per session.
+//!
+//! Each tool call runs inside a [`ToolCall`] scope naming the tool and the
+//! session it belongs to; whenever a file is written during the call, the
+//! edits that produced it are logged under that session. The log lives in
+//! memory and, when `operation_log.file` is configured, is also appended to
+//! a JSON Lines file so it survives restarts and can be shared.
+
+use crate::edit_utils::TextEdit;
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::future::Future;
+use std::io::Write;
+use std::path::{Path, PathBuf};
+use std::sync::{Arc, Mutex};
+use std::time::{SystemTime, UNIX_EPOCH};
+
+tokio::task_local! {
+    static CURRENT_CALL: ToolCall;
+}
+
+/// The tool call a write happens in.
+#[derive(Debug, Clone)]
+pub struct ToolCall {
+    pub tool: String,
+    pub session: String,
+    /// Files written during the call and their edits, shared by its clones
+    writes: Arc<Mutex<Vec<(PathBuf, Vec<TextEdit>)>>>,
+}
+
+impl ToolCall {
+    pub fn new(tool: &str, session: &str) -> Self {
+        Self {
+            tool: tool.to_string(),
+            session: session.to_string(),
+            writes: Arc::default(),
+        }
+    }
+
+    /// Note that the call wrote `path` with `edits`.
+    pub fn record_write(&self, path: &Path, edits: &[TextEdit]) {
+        self.writes
+            .lock()
+            .unwrap()
+            .push((path.to_path_buf(), edits.to_vec()));
+    }
+
+    /// Files the call has written so far, in the order it wrote them.
+    pub fn writes(&self) -> Vec<(PathBuf, Vec<TextEdit>)> {
+        self.writes.lock().unwrap().clone()
+    }
+
+    /// Run `future` as part of this call, so writes it makes are logged
+    /// under it.
+    pub async fn scope<F: Future>(self, future: F) -> F::Output {
+        CURRENT_CALL.scope(self, future).await
+    }
+
+    /// The call the current task is running in, if any.
+    pub fn current() -> Option<Self> {
+        CURRENT_CALL.try_with(|call| call.clone()).ok()
+    }
+}
+
+/// One file written by one tool call.
+#[derive(Debug, Clone, PartialEq, Serialize, Deserialize)]
+pub struct LogEntry {
+    pub session: String,
+    pub tool: String,
+    pub file: String,
+    /// Edits against the file as it was before the write
+    pub edits: Vec<TextEdit>,
+    /// Milliseconds since the Unix epoch
+    pub timestamp: u64,
+}
+
+#[derive(Debug, Default)]
+pub struct OperationLog {
+    entries: Vec<LogEntry>,
+    file: Option<PathBuf>,
+}
+
+impl OperationLog {
+    /// A log backed by the JSON Lines file at `path`, starting with the
+    /// entries already in it.
+    pub fn open(path: &Path) -> Result<Self> {
+        let entries = match std::fs::read_to_string(path) {
+            Ok(contents) => contents
+                .lines()
+                .filter(|line| !line.trim().is_empty())
+                .enumerate()
+                .map(|(index, line)| {
+                    serde_json::from_str(line).map_err(|e| {
+                        anyhow!(
+                            "{} line {}: invalid log entry: {}",
+                            path.display(),
+                            index + 1,
+                            e
+                        )
+                    })
+                })
+                .collect::<Result<_>>()?,
+            Err(e) if e.kind() == std::io::ErrorKind::NotFound => Vec::new(),
+            Err(e) => return Err(anyhow!("Failed to read {}: {}", path.display(), e)),
+        };
+        Ok(Self {
+            entries,
+            file: Some(path.to_path_buf()),
+        })
+    }
+
+    /// Log that `call` wrote `file` with `edits`.
+    pub fn record(&mut self, call: &ToolCall, file: &str, edits: &[TextEdit]) -> Result<()> {
+        let entry = LogEntry {
+            session: call.session.clone(),
+            tool: call.tool.clone(),
+            file: file.to_string(),
+            edits: edits.to_vec(),
+            timestamp: SystemTime::now()
+                .duration_since(UNIX_EPOCH)
+                .map(|elapsed| elapsed.as_millis() as u64)
+                .unwrap_or(0),
+        };
+        // Keep the entry in memory even if the file cannot be written
+        let line = serde_json::to_string(&entry)?;
+        self.entries.push(entry);
+        if let Some(path) = &self.file {
+            std::fs::OpenOptions::new()
+                .create(true)
+                .append(true)
+                .open(path)
+                .and_then(|mut log| writeln!(log, "{line}"))
+                .map_err(|e| anyhow!("Failed to append to {}: {}", path.display(), e))?;
+        }
+        Ok(())
+    }
+
+    /// Entries of `session`, oldest first.
+    pub fn session(&self, session: &str) -> Vec<&LogEntry> {
+        self.entries
+            .iter()
+            .filter(|entry| entry.session == session)
+            .collect()
+    }
+
+    /// Every session with entries, in the order each first wrote a file.
+    pub fn sessions(&self) -> Vec<&str> {
+        let mut sessions: Vec<&str> = Vec::new();
+        for entry in &self.entries {
+            if !sessions.contains(&entry.session.as_str()) {
+                sessions.push(&entry.session);
+            }
+        }
+        sessions
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn call(session: &str) -> ToolCall {
+        ToolCall::new("rename_symbol", session)
+    }
+
+    #[test]
+    fn test_file_backed_log_survives_reopen() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("operations.jsonl");
+        let edit = TextEdit {
+            start: 4,
+            end: 7,
+            replacement: "total".to_string(),
+        };
+
+        let mut log = OperationLog::open(&path).unwrap();
+        log.record(&call("a"), "main.go", &[edit.clone()]).unwrap();
+        log.record(&call("b"), "util.go", &[]).unwrap();
+        log.record(&call("a"), "util.go", &[]).unwrap();
+
+        let reopened = OperationLog::open(&path).unwrap();
+        assert_eq!(reopened.sessions(), ["a", "b"]);
+        let entries = reopened.session("a");
+        assert_eq!(entries.len(), 2);
+        assert_eq!(entries[0].file, "main.go");
+        assert_eq!(entries[0].edits, [edit]);
+        assert_eq!(entries[0].tool, "rename_symbol");
+    }
+
+    #[tokio::test]
+    async fn test_current_call_is_scoped() {
+        assert!(ToolCall::current().is_none());
+        let scoped = call("s");
+        let session = scoped
+            .clone()
+            .scope(async {
+                let current = ToolCall::current().unwrap();
+                current.record_write(Path::new("main.go"), &[]);
+                current.session
+            })
+            .await;
+        assert_eq!(session, "s");
+        // Writes noted inside the scope show on the call it was given
+        assert_eq!(scoped.writes(), [(PathBuf::from("main.go"), Vec::new())]);
+    }
+}
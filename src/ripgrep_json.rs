@@ -0,0 +1,214 @@
+//! Render ast-grep matches as ripgrep `--json` events.
+//!
+//! ripgrep emits one JSON object per line: a `begin` event per file, one
+//! `match` event per matching line (or block of lines for multi-line
+//! matches) with submatch offsets relative to that line, an `end` event per
+//! file and a final `summary`. Tools that already render ripgrep output can
+//! consume structural search results unchanged.
+
+use crate::edit_utils::{self, NodeSpan};
+use serde_json::{json, Value};
+use std::time::Duration;
+
+/// Running totals in the shape of ripgrep's `stats` object.
+#[derive(Debug, Default, Clone)]
+pub struct SearchStats {
+    pub searches: u64,
+    pub searches_with_match: u64,
+    pub bytes_searched: u64,
+    pub matched_lines: u64,
+    pub matches: u64,
+}
+
+impl SearchStats {
+    fn add(&mut self, other: &SearchStats) {
+        self.searches += other.searches;
+        self.searches_with_match += other.searches_with_match;
+        self.bytes_searched += other.bytes_searched;
+        self.matched_lines += other.matched_lines;
+        self.matches += other.matches;
+    }
+
+    fn to_json(&self, elapsed: Duration) -> Value {
+        json!({
+            "elapsed": elapsed_json(elapsed),
+            "searches": self.searches,
+            "searches_with_match": self.searches_with_match,
+            "bytes_searched": self.bytes_searched,
+            "bytes_printed": 0,
+            "matched_lines": self.matched_lines,
+            "matches": self.matches,
+        })
+    }
+}
+
+fn elapsed_json(elapsed: Duration) -> Value {
+    json!({
+        "secs": elapsed.as_secs(),
+        "nanos": elapsed.subsec_nanos(),
+        "human": format!("{:.6}s", elapsed.as_secs_f64()),
+    })
+}
+
+/// Events for one searched file. `spans` are the file's matches in any order.
+pub fn file_events(
+    path: &str,
+    source: &str,
+    spans: &[NodeSpan],
+    elapsed: Duration,
+) -> (Vec<Value>, SearchStats) {
+    let mut spans: Vec<&NodeSpan> = spans.iter().collect();
+    spans.sort_by_key(|span| (span.start, span.end));
+
+    // Matches whose lines overlap share one event, as in ripgrep
+    let mut groups: Vec<(usize, usize, Vec<&NodeSpan>)> = Vec::new();
+    for span in spans {
+        let start = edit_utils::line_start(source, span.start);
+        // The line holding the match's last character, not the one after it
+        let last = source[..span.end]
+            .char_indices()
+            .next_back()
+            .map(|(i, _)| i)
+            .filter(|i| *i >= span.start)
+            .unwrap_or(span.start);
+        let end = edit_utils::line_end(source, last);
+        match groups.last_mut() {
+            Some((_, group_end, members)) if start < *group_end => {
+                *group_end = (*group_end).max(end);
+                members.push(span);
+            }
+            _ => groups.push((start, end, vec![span])),
+        }
+    }
+
+    let mut stats = SearchStats {
+        searches: 1,
+        searches_with_match: u64::from(!groups.is_empty()),
+        bytes_searched: source.len() as u64,
+        ..SearchStats::default()
+    };
+
+    let mut events = vec![json!({"type": "begin", "data": {"path": {"text": path}}})];
+    for (start, end, members) in &groups {
+        let lines = &source[*start..*end];
+        stats.matched_lines += lines.lines().count().max(1) as u64;
+        stats.matches += members.len() as u64;
+        events.push(json!({
+            "type": "match",
+            "data": {
+                "path": {"text": path},
+                "lines": {"text": lines},
+                "line_number": edit_utils::line_number(source, *start),
+                "absolute_offset": start,
+                "submatches": members
+                    .iter()
+                    .map(|span| json!({
+                        "match": {"text": &source[span.start..span.end]},
+                        "start": span.start - start,
+                        "end": span.end - start,
+                    }))
+                    .collect::<Vec<_>>(),
+            }
+        }));
+    }
+    events.push(json!({
+        "type": "end",
+        "data": {
+            "path": {"text": path},
+            "binary_offset": null,
+            "stats": stats.to_json(elapsed),
+        }
+    }));
+    (events, stats)
+}
+
+/// Line-delimited output for several files followed by a summary event.
+pub fn render(files: &[(String, String, Vec<NodeSpan>)], elapsed: Duration) -> String {
+    let mut total = SearchStats::default();
+    let mut lines = Vec::new();
+    for (path, source, spans) in files {
+        let (events, stats) = file_events(path, source, spans, elapsed);
+        total.add(&stats);
+        lines.extend(events.iter().map(Value::to_string));
+    }
+    lines.push(
+        json!({
+            "type": "summary",
+            "data": {
+                "elapsed_total": elapsed_json(elapsed),
+                "stats": total.to_json(elapsed),
+            }
+        })
+        .to_string(),
+    );
+    lines.join("\n") + "\n"
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn span(source: &str, needle: &str) -> NodeSpan {
+        let start = source.find(needle).unwrap();
+        NodeSpan {
+            start,
+            end: start + needle.len(),
+            text: needle.to_string(),
+        }
+    }
+
+    #[test]
+    fn test_submatch_offsets_are_line_relative() {
+        let source = "let a = 1;\nfoo(a); foo(b);\n";
+        let spans = vec![span(source, "foo(b)"), span(source, "foo(a)")];
+        let (events, stats) = file_events("src/x.js", source, &spans, Duration::ZERO);
+
+        assert_eq!(events.len(), 3);
+        let data = &events[1]["data"];
+        assert_eq!(events[1]["type"], "match");
+        assert_eq!(data["line_number"], 2);
+        assert_eq!(data["absolute_offset"], 11);
+        assert_eq!(data["lines"]["text"], "foo(a); foo(b);\n");
+        assert_eq!(data["submatches"][0]["start"], 0);
+        assert_eq!(data["submatches"][1]["start"], 8);
+        assert_eq!(data["submatches"][1]["end"], 14);
+        assert_eq!(stats.matches, 2);
+        assert_eq!(stats.matched_lines, 1);
+    }
+
+    #[test]
+    fn test_overlapping_multiline_matches_share_an_event() {
+        let source = "a\nb\nc\nd\ne\n";
+        let spans = vec![span(source, "b\nc\nd"), span(source, "a\nb\nc")];
+        let (events, stats) = file_events("x.txt", source, &spans, Duration::ZERO);
+
+        assert_eq!(events.len(), 3);
+        let data = &events[1]["data"];
+        assert_eq!(data["line_number"], 1);
+        assert_eq!(data["lines"]["text"], "a\nb\nc\nd\n");
+        assert_eq!(data["submatches"][0]["start"], 0);
+        assert_eq!(data["submatches"][1]["start"], 2);
+        assert_eq!(data["submatches"][1]["end"], 7);
+        assert_eq!(stats.matches, 2);
+        assert_eq!(stats.matched_lines, 4);
+    }
+
+    #[test]
+    fn test_multiline_match_and_summary() {
+        let source = "fn a() {\n    1\n}\n";
+        let spans = vec![span(source, "fn a() {\n    1\n}")];
+        let output = render(
+            &[("a.rs".to_string(), source.to_string(), spans)],
+            Duration::ZERO,
+        );
+        let events: Vec<Value> = output
+            .lines()
+            .map(|line| serde_json::from_str(line).unwrap())
+            .collect();
+
+        assert_eq!(events[1]["data"]["lines"]["text"], source);
+        assert_eq!(events[2]["data"]["stats"]["matched_lines"], 3);
+        assert_eq!(events[3]["type"], "summary");
+        assert_eq!(events[3]["data"]["stats"]["searches_with_match"], 1);
+    }
+}
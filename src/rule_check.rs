@@ -0,0 +1,451 @@
+//! Static checks of ast-grep rule configs: the shape ast-grep expects of
+//! each key, done without running the rule so a malformed one is caught
+//! with the path and line of the offending key.
+//!
+//! The checks cover what can be known from the config alone. Whether a
+//! `kind` exists in the language's grammar or a `pattern` parses is left
+//! to ast-grep.
+
+use regex::Regex;
+use serde_yaml::Value;
+use std::collections::BTreeSet;
+
+/// Keys of a rule config's top level.
+const CONFIG_KEYS: &[&str] = &[
+    "id",
+    "language",
+    "rule",
+    "constraints",
+    "utils",
+    "fix",
+    "transform",
+    "rewriters",
+    "message",
+    "severity",
+    "note",
+    "labels",
+    "metadata",
+    "files",
+    "ignores",
+    "url",
+];
+
+/// Keys of a rule object, atomic, relational and composite.
+const RULE_KEYS: &[&str] = &[
+    "pattern", "kind", "regex", "nthChild", "range", "inside", "has", "precedes", "follows", "all",
+    "any", "not", "matches",
+];
+
+/// Keys a relational rule takes besides those of a rule.
+const RELATIONAL_KEYS: &[&str] = &["stopBy", "field"];
+
+/// Keys of a pattern given as an object.
+const PATTERN_KEYS: &[&str] = &["context", "selector", "strictness"];
+
+/// A problem with a config: where it is, as a path of keys and indices
+/// such as `rule.has.kind`, and what is wrong.
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct RuleProblem {
+    pub path: String,
+    pub message: String,
+}
+
+/// What a config refers to, collected while checking it.
+#[derive(Debug, Clone, Default, PartialEq, Eq)]
+pub struct RuleSummary {
+    pub kinds: BTreeSet<String>,
+    pub fields: BTreeSet<String>,
+    pub metavariables: BTreeSet<String>,
+    pub utils: BTreeSet<String>,
+    pub warnings: Vec<RuleProblem>,
+}
+
+/// Check a parsed config, returning what it refers to or the first
+/// problem found.
+pub fn check_config(config: &Value) -> Result<RuleSummary, RuleProblem> {
+    let problem = |path: &str, message: String| RuleProblem {
+        path: path.to_string(),
+        message,
+    };
+    let map = config
+        .as_mapping()
+        .ok_or_else(|| problem("", "the config must be a YAML object".to_string()))?;
+    let mut summary = RuleSummary::default();
+    for (key, value) in map.iter() {
+        let key = key.as_str().unwrap_or_default();
+        if !CONFIG_KEYS.contains(&key) {
+            return Err(unknown_key(key, key, CONFIG_KEYS));
+        }
+        match key {
+            "rule" => check_rule(value, "rule", false, &mut summary)?,
+            "utils" => {
+                let utils = value
+                    .as_mapping()
+                    .ok_or_else(|| problem("utils", "utils must map names to rules".to_string()))?;
+                for (name, util) in utils.iter() {
+                    let name = name.as_str().unwrap_or_default();
+                    check_rule(util, &format!("utils.{name}"), false, &mut summary)?;
+                }
+            }
+            "constraints" => {
+                let constraints = value.as_mapping().ok_or_else(|| {
+                    problem(
+                        "constraints",
+                        "constraints must map metavariables to rules".to_string(),
+                    )
+                })?;
+                for (name, constraint) in constraints.iter() {
+                    let name = name.as_str().unwrap_or_default();
+                    check_rule(
+                        constraint,
+                        &format!("constraints.{name}"),
+                        false,
+                        &mut summary,
+                    )?;
+                }
+            }
+            "id" | "language" | "message" | "note" | "url" if !value.is_string() => {
+                return Err(problem(key, format!("{key} must be a string")));
+            }
+            _ => {}
+        }
+    }
+    for missing in ["id", "language", "rule"] {
+        if !map.contains_key(missing) {
+            return Err(problem("", format!("missing required key {missing}")));
+        }
+    }
+
+    if let Some(fix) = map.get("fix").and_then(Value::as_str) {
+        let transformed: BTreeSet<&str> = map
+            .get("transform")
+            .and_then(Value::as_mapping)
+            .map(|transform| transform.keys().filter_map(Value::as_str).collect())
+            .unwrap_or_default();
+        let mut unbound: Vec<String> = metavariables(fix)
+            .into_iter()
+            .filter(|name| {
+                !summary.metavariables.contains(name) && !transformed.contains(name.as_str())
+            })
+            .collect();
+        unbound.dedup();
+        for name in &unbound {
+            summary.warnings.push(problem(
+                "fix",
+                format!("${name} is not captured by any pattern, so the fix has no text for it"),
+            ));
+        }
+        summary.metavariables.extend(metavariables(fix));
+    }
+    let defined: BTreeSet<String> = map
+        .get("utils")
+        .and_then(Value::as_mapping)
+        .map(|utils| {
+            utils
+                .keys()
+                .filter_map(|key| key.as_str().map(str::to_string))
+                .collect()
+        })
+        .unwrap_or_default();
+    for util in &summary.utils {
+        if !defined.contains(util) {
+            summary.warnings.push(problem(
+                "rule",
+                format!("matches refers to {util}, which is not in utils; it must come from the project's sgconfig"),
+            ));
+        }
+    }
+    if let Some(constraints) = map.get("constraints").and_then(Value::as_mapping) {
+        for name in constraints.keys().filter_map(Value::as_str) {
+            if !summary.metavariables.contains(name) {
+                summary.warnings.push(problem(
+                    &format!("constraints.{name}"),
+                    format!(
+                        "${name} does not appear in any pattern, so the constraint never applies"
+                    ),
+                ));
+            }
+        }
+    }
+    Ok(summary)
+}
+
+/// Check one rule object at `path`; `relational` allows `stopBy` and
+/// `field`.
+fn check_rule(
+    rule: &Value,
+    path: &str,
+    relational: bool,
+    summary: &mut RuleSummary,
+) -> Result<(), RuleProblem> {
+    let problem = |path: &str, message: String| RuleProblem {
+        path: path.to_string(),
+        message,
+    };
+    let map = rule
+        .as_mapping()
+        .ok_or_else(|| problem(path, "a rule must be an object of rule keys".to_string()))?;
+    if map.is_empty() {
+        return Err(problem(path, "a rule needs at least one key".to_string()));
+    }
+    for (key, value) in map.iter() {
+        let key = key.as_str().unwrap_or_default();
+        let at = format!("{path}.{key}");
+        match key {
+            "pattern" => match value {
+                Value::String(pattern) => summary.metavariables.extend(metavariables(pattern)),
+                Value::Mapping(pattern) => {
+                    for (pattern_key, pattern_value) in pattern.iter() {
+                        let pattern_key = pattern_key.as_str().unwrap_or_default();
+                        if !PATTERN_KEYS.contains(&pattern_key) {
+                            return Err(unknown_key(
+                                &format!("{at}.{pattern_key}"),
+                                pattern_key,
+                                PATTERN_KEYS,
+                            ));
+                        }
+                        if pattern_key == "context" {
+                            let context = pattern_value.as_str().ok_or_else(|| {
+                                problem(
+                                    &format!("{at}.context"),
+                                    "context must be a string".to_string(),
+                                )
+                            })?;
+                            summary.metavariables.extend(metavariables(context));
+                        }
+                    }
+                    if !pattern.contains_key("context") {
+                        return Err(problem(&at, "a pattern object needs a context".to_string()));
+                    }
+                }
+                _ => {
+                    return Err(problem(
+                        &at,
+                        "pattern must be a string or an object with context".to_string(),
+                    ))
+                }
+            },
+            "kind" | "regex" | "matches" => {
+                let text = value
+                    .as_str()
+                    .ok_or_else(|| problem(&at, format!("{key} must be a string")))?;
+                match key {
+                    "kind" => {
+                        summary.kinds.insert(text.to_string());
+                    }
+                    "regex" => {
+                        Regex::new(text)
+                            .map_err(|e| problem(&at, format!("invalid regex: {e}")))?;
+                    }
+                    _ => {
+                        summary.utils.insert(text.to_string());
+                    }
+                }
+            }
+            "inside" | "has" | "precedes" | "follows" => check_rule(value, &at, true, summary)?,
+            "not" => check_rule(value, &at, false, summary)?,
+            "all" | "any" => {
+                let rules = value
+                    .as_sequence()
+                    .ok_or_else(|| problem(&at, format!("{key} must be a list of rules")))?;
+                for (index, rule) in rules.iter().enumerate() {
+                    check_rule(rule, &format!("{at}.{index}"), false, summary)?;
+                }
+            }
+            "nthChild" => {
+                if !(value.as_u64().is_some() || value.is_string() || value.is_mapping()) {
+                    return Err(problem(&at, "nthChild must be a number, a formula such as '2n+1', or an object with position".to_string()));
+                }
+            }
+            "range" => {
+                if !value.is_mapping() {
+                    return Err(problem(
+                        &at,
+                        "range must be an object with start and end".to_string(),
+                    ));
+                }
+            }
+            "stopBy" if relational => match value {
+                Value::String(stop) if stop == "neighbor" || stop == "end" => {}
+                Value::Mapping(_) => check_rule(value, &at, false, summary)?,
+                _ => {
+                    return Err(problem(
+                        &at,
+                        "stopBy must be 'neighbor', 'end' or a rule".to_string(),
+                    ))
+                }
+            },
+            "field" if relational => {
+                let field = value
+                    .as_str()
+                    .ok_or_else(|| problem(&at, "field must be a string".to_string()))?;
+                summary.fields.insert(field.to_string());
+            }
+            "stopBy" | "field" => {
+                return Err(problem(
+                    &at,
+                    format!("{key} only applies inside inside, has, precedes or follows"),
+                ))
+            }
+            _ => {
+                let known: Vec<&str> = RULE_KEYS
+                    .iter()
+                    .chain(if relational { RELATIONAL_KEYS } else { &[] })
+                    .copied()
+                    .collect();
+                return Err(unknown_key(&at, key, &known));
+            }
+        }
+    }
+    Ok(())
+}
+
+/// Problem for a key that is not one of `known`, suggesting the closest.
+fn unknown_key(path: &str, key: &str, known: &[&str]) -> RuleProblem {
+    let suggestion = known
+        .iter()
+        .map(|candidate| (distance(key, candidate), *candidate))
+        .filter(|(distance, _)| *distance <= 2)
+        .min()
+        .map(|(_, candidate)| format!("; did you mean {candidate}?"))
+        .unwrap_or_default();
+    RuleProblem {
+        path: path.to_string(),
+        message: format!("unknown key {key}{suggestion}"),
+    }
+}
+
+/// Levenshtein distance between two keys, by character.
+fn distance(a: &str, b: &str) -> usize {
+    let b: Vec<char> = b.chars().collect();
+    let mut row: Vec<usize> = (0..=b.len()).collect();
+    for (i, a) in a.chars().enumerate() {
+        let mut diagonal = row[0];
+        row[0] = i + 1;
+        for (j, b) in b.iter().enumerate() {
+            let next = (diagonal + usize::from(a != *b))
+                .min(row[j] + 1)
+                .min(row[j + 1] + 1);
+            diagonal = row[j + 1];
+            row[j + 1] = next;
+        }
+    }
+    row[b.len()]
+}
+
+/// Metavariables in a pattern, without the `$`s, e.g. `ARGS` for
+/// `$$$ARGS`. `$_` and other names starting with `_` are not captured and
+/// left out.
+pub fn metavariables(pattern: &str) -> Vec<String> {
+    captures(pattern)
+        .into_iter()
+        .map(|(name, _)| name)
+        .collect()
+}
+
+/// Metavariables in a pattern in the order they appear, repeats included,
+/// each with whether it is a `$$$` capture of a list of nodes.
+pub fn captures(pattern: &str) -> Vec<(String, bool)> {
+    let re = Regex::new(r"\$(\$\$)?([A-Z][A-Z0-9_]*)").unwrap();
+    re.captures_iter(pattern)
+        .map(|capture| (capture[2].to_string(), capture.get(1).is_some()))
+        .collect()
+}
+
+/// 1-indexed line and column of the key a problem's path ends at, found by
+/// following its keys through the config's lines; `None` if a key cannot be
+/// found, e.g. in flow-style YAML.
+pub fn locate(config: &str, path: &str) -> Option<(usize, usize)> {
+    let lines: Vec<&str> = config.lines().collect();
+    let mut at = None;
+    let mut from = 0;
+    for key in path.split('.').filter(|key| !key.is_empty()) {
+        if key.parse::<usize>().is_ok() {
+            continue;
+        }
+        let (index, column) = lines
+            .iter()
+            .enumerate()
+            .skip(from)
+            .find_map(|(index, line)| {
+                let trimmed = line.trim_start().trim_start_matches("- ");
+                trimmed
+                    .strip_prefix(key)
+                    .is_some_and(|rest| rest.trim_start().starts_with(':'))
+                    .then(|| (index, line.len() - trimmed.len()))
+            })?;
+        at = Some((index + 1, column + 1));
+        from = index + 1;
+    }
+    at
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    fn check(config: &str) -> Result<RuleSummary, RuleProblem> {
+        check_config(&serde_yaml::from_str(config).unwrap())
+    }
+
+    #[test]
+    fn test_summary_of_a_valid_rule() {
+        let config = "id: calls\nlanguage: go\nrule:\n  pattern: $F($$$ARGS)\n  inside:\n    kind: function_declaration\n    field: body\n    stopBy: end\nconstraints:\n  F:\n    regex: ^log\n";
+        let summary = check(config).unwrap();
+        assert_eq!(
+            summary.kinds,
+            BTreeSet::from(["function_declaration".to_string()])
+        );
+        assert_eq!(summary.fields, BTreeSet::from(["body".to_string()]));
+        assert_eq!(
+            summary.metavariables,
+            BTreeSet::from(["ARGS".to_string(), "F".to_string()])
+        );
+        assert!(summary.warnings.is_empty());
+    }
+
+    #[test]
+    fn test_problems_with_their_paths() {
+        let config = "id: a\nlanguage: go\nrule:\n  any:\n    - kind: identifier\n    - has:\n        knd: call_expression\n";
+        let problem = check(config).unwrap_err();
+        assert_eq!(problem.path, "rule.any.1.has.knd");
+        assert_eq!(problem.message, "unknown key knd; did you mean kind?");
+        assert_eq!(locate(config, &problem.path), Some((7, 9)));
+
+        let problem =
+            check("id: a\nlanguage: go\nrule:\n  kind: identifier\n  field: name\n").unwrap_err();
+        assert_eq!(problem.path, "rule.field");
+        let problem = check("id: a\nlanguage: go\nrule:\n  regex: '('\n").unwrap_err();
+        assert!(problem.message.starts_with("invalid regex"));
+        let problem = check("id: a\nlanguage: go\n").unwrap_err();
+        assert_eq!(problem.message, "missing required key rule");
+    }
+
+    #[test]
+    fn test_constraint_without_metavariable_warns() {
+        let summary =
+            check("id: a\nlanguage: go\nrule:\n  pattern: f($X)\nconstraints:\n  Y:\n    kind: identifier\n")
+                .unwrap();
+        assert_eq!(summary.warnings[0].path, "constraints.Y");
+    }
+
+    #[test]
+    fn test_fix_metavariable_without_capture_warns() {
+        let config = "id: a\nlanguage: go\nrule:\n  pattern: $A.Close()\nfix: Close($A, $B, $LOWER)\ntransform:\n  LOWER:\n    convert: { source: $A, toCase: lowerCase }\n";
+        let summary = check(config).unwrap();
+        assert_eq!(summary.warnings.len(), 1);
+        assert_eq!(summary.warnings[0].path, "fix");
+        assert!(summary.warnings[0]
+            .message
+            .starts_with("$B is not captured"));
+        assert_eq!(
+            captures("$F($A, $$$REST, $A)"),
+            [
+                ("F".to_string(), false),
+                ("A".to_string(), false),
+                ("REST".to_string(), true),
+                ("A".to_string(), false)
+            ]
+        );
+    }
+}
@@ -0,0 +1,290 @@
+use anyhow::{anyhow, Result};
+use serde::{Deserialize, Serialize};
+use std::collections::HashMap;
+use std::path::{Path, PathBuf};
+use tracing::{info, warn};
+
+/// Environment variable pointing at an explicit server config file.
+pub const CONFIG_ENV_VAR: &str = "SPLICE_WEAVER_CONFIG";
+/// Config file picked up from the working directory when no path is given.
+pub const DEFAULT_CONFIG_FILE: &str = "splice-weaver.yaml";
+
+/// Server-wide settings, loaded once at startup from `splice-weaver.yaml`.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ServerConfig {
+    pub protection: ProtectionConfig,
+    /// Grammar field names for canonical concepts, per language, e.g.
+    /// `swift: { BODY: body }`. Entries override the built-in defaults, so
+    /// only languages whose grammar differs need listing.
+    pub field_aliases: HashMap<String, HashMap<String, String>>,
+    pub parse_timeout: ParseTimeoutConfig,
+    pub operation_log: OperationLogConfig,
+    /// Tree-sitter grammars to load from shared libraries, by language
+    /// name. Their field names go in `field_aliases` like any language's.
+    pub grammars: HashMap<String, GrammarConfig>,
+    /// Formatter commands by language, in place of the built-in ones, e.g.
+    /// `python: { command: ruff, args: [format, "-"] }`. An empty command
+    /// turns formatting off for the language.
+    pub formatters: HashMap<String, FormatterConfig>,
+}
+
+/// How long ast-grep may take to parse and match one file before the tool
+/// call fails instead of blocking the server.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ParseTimeoutConfig {
+    /// Limit for languages not listed below
+    pub default_ms: u64,
+    /// Per-language limits, e.g. `cpp: 15000` for a slow grammar
+    pub languages: HashMap<String, u64>,
+}
+
+/// Where the record of applied edits is kept besides memory.
+#[derive(Debug, Clone, Default, Serialize, Deserialize)]
+#[serde(default)]
+pub struct OperationLogConfig {
+    /// JSON Lines file each written edit is appended to
+    pub file: Option<PathBuf>,
+}
+
+/// A grammar compiled as a shared library, registered with ast-grep as a
+/// custom language.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+pub struct GrammarConfig {
+    /// Path to the `.so`, `.dylib` or `.dll`, relative to the config file
+    pub library_path: PathBuf,
+    /// Extensions of the language's files, without the dot
+    pub extensions: Vec<String>,
+    /// Function the library exports the language from; defaults to
+    /// `tree_sitter_<name>`
+    #[serde(default)]
+    pub language_symbol: Option<String>,
+    /// Character patterns use in place of `$` for metavariables, for
+    /// grammars where `$` cannot start an identifier
+    #[serde(default)]
+    pub expando_char: Option<char>,
+    /// The grammar's `node-types.json`, relative to the config file, for
+    /// `fields_for_type`
+    #[serde(default)]
+    pub node_types_path: Option<PathBuf>,
+}
+
+/// A formatter that reads a file's text on stdin and writes it formatted
+/// to stdout.
+#[derive(Debug, Clone, PartialEq, Eq, Serialize, Deserialize)]
+pub struct FormatterConfig {
+    pub command: String,
+    #[serde(default)]
+    pub args: Vec<String>,
+}
+
+/// Guards that stop mutating tools from clobbering generated or protected code.
+#[derive(Debug, Clone, Serialize, Deserialize)]
+#[serde(default)]
+pub struct ProtectionConfig {
+    /// Regexes that mark a whole file as generated when found in its header
+    pub generated_markers: Vec<String>,
+    /// How many leading lines are checked for a generated marker
+    pub header_lines: usize,
+    /// Text on the comment line that opens a protected region
+    pub region_start: String,
+    /// Text on the comment line that closes a protected region
+    pub region_end: String,
+    /// Languages whose files may be edited, e.g. `[go]`; empty allows all.
+    /// Files whose language cannot be detected are refused when set.
+    pub editable_languages: Vec<String>,
+    /// Go build tags the server edits for, e.g. `[linux, amd64]`. Go files
+    /// whose build constraints exclude them are refused unless forced;
+    /// empty allows all.
+    pub build_tags: Vec<String>,
+}
+
+impl Default for ServerConfig {
+    fn default() -> Self {
+        Self {
+            protection: ProtectionConfig::default(),
+            field_aliases: HashMap::new(),
+            parse_timeout: ParseTimeoutConfig::default(),
+            operation_log: OperationLogConfig::default(),
+            grammars: HashMap::new(),
+            formatters: HashMap::new(),
+        }
+    }
+}
+
+impl Default for ParseTimeoutConfig {
+    fn default() -> Self {
+        Self {
+            default_ms: 5000,
+            languages: HashMap::new(),
+        }
+    }
+}
+
+impl Default for ProtectionConfig {
+    fn default() -> Self {
+        Self {
+            generated_markers: vec![
+                r"^\s*(//|#|/\*|--)\s*Code generated .* DO NOT EDIT\.?".to_string(),
+                r"@generated".to_string(),
+                r"(?i)auto-?generated\b.*\bdo not (edit|modify)".to_string(),
+            ],
+            header_lines: 5,
+            region_start: "BEGIN PROTECTED REGION".to_string(),
+            region_end: "END PROTECTED REGION".to_string(),
+            editable_languages: Vec::new(),
+            build_tags: Vec::new(),
+        }
+    }
+}
+
+impl ServerConfig {
+    /// Load the config from `$SPLICE_WEAVER_CONFIG`, then `./splice-weaver.yaml`,
+    /// falling back to defaults when neither exists or the file is invalid.
+    pub fn load() -> Self {
+        let path = match std::env::var(CONFIG_ENV_VAR) {
+            Ok(path) => PathBuf::from(path),
+            Err(_) => PathBuf::from(DEFAULT_CONFIG_FILE),
+        };
+
+        if !path.exists() {
+            return Self::default();
+        }
+
+        match Self::from_file(&path) {
+            Ok(config) => {
+                info!("Loaded server config from {}", path.display());
+                config
+            }
+            Err(e) => {
+                warn!("Ignoring server config {}: {}", path.display(), e);
+                Self::default()
+            }
+        }
+    }
+
+    pub fn from_file(path: &Path) -> Result<Self> {
+        let contents = std::fs::read_to_string(path)
+            .map_err(|e| anyhow!("Failed to read {}: {}", path.display(), e))?;
+        let mut config = Self::from_yaml(&contents)?;
+        if let Some(directory) = path.parent() {
+            for grammar in config.grammars.values_mut() {
+                grammar.library_path = directory.join(&grammar.library_path);
+                grammar.node_types_path = grammar
+                    .node_types_path
+                    .as_ref()
+                    .map(|node_types| directory.join(node_types));
+            }
+        }
+        Ok(config)
+    }
+
+    pub fn from_yaml(contents: &str) -> Result<Self> {
+        serde_yaml::from_str(contents).map_err(|e| anyhow!("Invalid server config: {}", e))
+    }
+
+    /// The grammar field `language` uses for `concept`: `NAME` (a
+    /// declaration's name), `BODY` (its block of statements or members), or
+    /// `TYPE_NAME` (the type a member body belongs to).
+    pub fn field_name(&self, language: &str, concept: &str) -> Option<String> {
+        self.field_aliases
+            .get(language)
+            .and_then(|fields| fields.get(concept))
+            .cloned()
+            .or_else(|| default_field_name(language, concept).map(|field| field.to_string()))
+    }
+}
+
+impl ParseTimeoutConfig {
+    pub fn for_language(&self, language: &str) -> std::time::Duration {
+        let ms = self
+            .languages
+            .get(language)
+            .copied()
+            .unwrap_or(self.default_ms);
+        std::time::Duration::from_millis(ms)
+    }
+}
+
+/// Field names used by the bundled grammars.
+fn default_field_name(language: &str, concept: &str) -> Option<&'static str> {
+    match (concept, language) {
+        ("NAME", _) => Some("name"),
+        ("BODY", _) => Some("body"),
+        // Methods live in `impl Type { ... }`, which names its type in `type`
+        ("TYPE_NAME", "rust") => Some("type"),
+        ("TYPE_NAME", _) => Some("name"),
+        ("RECEIVER", _) => Some("receiver"),
+        ("TARGET", _) => Some("left"),
+        _ => None,
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_partial_config_keeps_defaults() {
+        let config = ServerConfig::from_yaml("protection:\n  header_lines: 2\n").unwrap();
+        assert_eq!(config.protection.header_lines, 2);
+        assert_eq!(config.protection.region_start, "BEGIN PROTECTED REGION");
+        assert!(!config.protection.generated_markers.is_empty());
+    }
+
+    #[test]
+    fn test_field_aliases_override_defaults() {
+        let config =
+            ServerConfig::from_yaml("field_aliases:\n  kotlin:\n    BODY: class_body\n").unwrap();
+        assert_eq!(
+            config.field_name("kotlin", "BODY").as_deref(),
+            Some("class_body")
+        );
+        assert_eq!(config.field_name("kotlin", "NAME").as_deref(), Some("name"));
+        assert_eq!(
+            config.field_name("rust", "TYPE_NAME").as_deref(),
+            Some("type")
+        );
+        assert_eq!(config.field_name("rust", "PARAMS"), None);
+    }
+
+    #[test]
+    fn test_grammar_paths_are_relative_to_the_config() {
+        let dir = tempfile::tempdir().unwrap();
+        let path = dir.path().join("splice-weaver.yaml");
+        std::fs::write(
+            &path,
+            "grammars:\n  mojo:\n    library_path: grammars/mojo.so\n    extensions: [mojo, \"🔥\"]\n    node_types_path: grammars/node-types.json\n",
+        )
+        .unwrap();
+        let config = ServerConfig::from_file(&path).unwrap();
+        let mojo = &config.grammars["mojo"];
+        assert_eq!(mojo.library_path, dir.path().join("grammars/mojo.so"));
+        assert_eq!(mojo.extensions, ["mojo", "🔥"]);
+        assert_eq!(mojo.language_symbol, None);
+        assert_eq!(
+            mojo.node_types_path,
+            Some(dir.path().join("grammars/node-types.json"))
+        );
+    }
+
+    #[test]
+    fn test_formatters_take_default_args() {
+        let config = ServerConfig::from_yaml(
+            "formatters:\n  python:\n    command: ruff\n    args: [format, \"-\"]\n  go:\n    command: gofumpt\n",
+        )
+        .unwrap();
+        assert_eq!(config.formatters["python"].args, ["format", "-"]);
+        assert!(config.formatters["go"].args.is_empty());
+    }
+
+    #[test]
+    fn test_parse_timeout_per_language() {
+        let config =
+            ServerConfig::from_yaml("parse_timeout:\n  languages:\n    cpp: 15000\n").unwrap();
+        let timeout = &config.parse_timeout;
+        assert_eq!(timeout.for_language("cpp").as_millis(), 15000);
+        assert_eq!(timeout.for_language("go").as_millis(), 5000);
+    }
+}
@@ -0,0 +1,132 @@
+//! Fuzzy structural comparison of code, for finding copy-paste clones.
+//!
+//! ast-grep reports a match's text but not its syntax tree, so structure is
+//! approximated by the node's token sequence with names and literals
+//! normalized away: `total += price * qty` and `sum += cost * n` are the same
+//! sequence `ID += ID * ID`. Control-flow keywords are kept, since they are
+//! what distinguishes a loop from a branch. Two sequences are compared by
+//! token edit distance.
+
+/// Keywords kept as themselves instead of being normalized to `ID`.
+const KEYWORDS: &[&str] = &[
+    "async", "await", "break", "case", "catch", "class", "const", "continue", "def", "default",
+    "defer", "do", "elif", "else", "except", "finally", "fn", "for", "func", "function", "go",
+    "if", "impl", "in", "lambda", "let", "loop", "match", "new", "raise", "return", "select",
+    "struct", "switch", "throw", "try", "var", "while", "with", "yield",
+];
+
+/// Normalized tokens of `text`: `ID` for identifiers, `NUM` for numbers,
+/// `STR` for string and character literals, keywords and punctuation as
+/// written. Whitespace and comments are dropped.
+pub fn normalized_tokens(text: &str) -> Vec<String> {
+    let chars: Vec<char> = text.chars().collect();
+    let mut tokens = Vec::new();
+    let mut i = 0;
+    while i < chars.len() {
+        let c = chars[i];
+        let rest = |offset: usize| chars.get(i + offset).copied();
+        if c.is_whitespace() {
+            i += 1;
+        } else if (c == '/' && rest(1) == Some('/')) || c == '#' {
+            while i < chars.len() && chars[i] != '\n' {
+                i += 1;
+            }
+        } else if c == '/' && rest(1) == Some('*') {
+            i += 2;
+            while i < chars.len() && !(chars[i] == '*' && chars.get(i + 1) == Some(&'/')) {
+                i += 1;
+            }
+            i += 2;
+        } else if c == '"' || c == '\'' || c == '`' {
+            i += 1;
+            while i < chars.len() && chars[i] != c {
+                if chars[i] == '\\' {
+                    i += 1;
+                }
+                i += 1;
+            }
+            i += 1;
+            tokens.push("STR".to_string());
+        } else if c.is_ascii_digit() {
+            while i < chars.len() && (chars[i].is_alphanumeric() || matches!(chars[i], '.' | '_')) {
+                i += 1;
+            }
+            tokens.push("NUM".to_string());
+        } else if c.is_alphabetic() || c == '_' || c == '$' {
+            let start = i;
+            while i < chars.len() && (chars[i].is_alphanumeric() || matches!(chars[i], '_' | '$')) {
+                i += 1;
+            }
+            let word: String = chars[start..i].iter().collect();
+            tokens.push(match KEYWORDS.contains(&word.as_str()) {
+                true => word,
+                false => "ID".to_string(),
+            });
+        } else {
+            tokens.push(c.to_string());
+            i += 1;
+        }
+    }
+    tokens
+}
+
+/// Similarity of two token sequences from 0.0 (nothing in common) to 1.0
+/// (identical): one minus their edit distance over the longer length.
+pub fn similarity(a: &[String], b: &[String]) -> f64 {
+    let longest = a.len().max(b.len());
+    if longest == 0 {
+        return 1.0;
+    }
+    1.0 - edit_distance(a, b) as f64 / longest as f64
+}
+
+/// Highest similarity two sequences of these lengths could have, so pairs
+/// that cannot reach a threshold are skipped without comparing them.
+pub fn similarity_bound(a_len: usize, b_len: usize) -> f64 {
+    let longest = a_len.max(b_len);
+    if longest == 0 {
+        return 1.0;
+    }
+    a_len.min(b_len) as f64 / longest as f64
+}
+
+/// Levenshtein distance between token sequences.
+fn edit_distance(a: &[String], b: &[String]) -> usize {
+    let mut previous: Vec<usize> = (0..=b.len()).collect();
+    let mut current = vec![0; b.len() + 1];
+    for (i, a_token) in a.iter().enumerate() {
+        current[0] = i + 1;
+        for (j, b_token) in b.iter().enumerate() {
+            let substitution = previous[j] + usize::from(a_token != b_token);
+            current[j + 1] = substitution.min(previous[j + 1] + 1).min(current[j] + 1);
+        }
+        std::mem::swap(&mut previous, &mut current);
+    }
+    previous[b.len()]
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    #[test]
+    fn test_renamed_clone_is_identical() {
+        let a = normalized_tokens("for item in items:\n    total += item.price * 2  # sum\n");
+        let b = normalized_tokens("for row in rows:\n    count += row.cost * 10\n");
+        assert_eq!(a, b);
+        assert_eq!(similarity(&a, &b), 1.0);
+        assert_eq!(a[0], "for");
+        assert!(a.contains(&"NUM".to_string()));
+    }
+
+    #[test]
+    fn test_similarity_degrades_with_edits() {
+        let a = normalized_tokens("if (a > b) { return a; }");
+        let b = normalized_tokens("if (a > b) { log(a); return a; }");
+        let c = normalized_tokens("while (x) { x = next(x); }");
+        let close = similarity(&a, &b);
+        assert!(close > 0.5 && close < 1.0, "{}", close);
+        assert!(similarity(&a, &c) < close);
+        assert!(similarity_bound(a.len(), b.len()) >= close);
+    }
+}
@@ -0,0 +1,435 @@
+//! Conversions for clients that work in UTF-16 rather than UTF-8.
+//!
+//! Internally every offset is a UTF-8 byte offset into a Rust `String`.
+//! Clients such as VS Code webviews count UTF-16 code units instead and may
+//! send content as raw UTF-16, so tool arguments are converted on the way in
+//! and results on the way out.
+//!
+//! Files on disk are decoded the same way: a UTF-8 byte order mark is
+//! stripped before parsing and Latin-1 files are decoded when declared, and
+//! both are restored when the file is written back.
+
+use crate::edit_utils;
+use anyhow::{anyhow, Result};
+use base64::Engine;
+use serde_json::Value;
+
+/// Unit that client-supplied offsets and columns are counted in.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum OffsetEncoding {
+    Utf8,
+    Utf16,
+}
+
+/// How inline `code` is sent and how returned `content` is encoded.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum ContentEncoding {
+    /// A plain JSON string
+    Utf8,
+    /// Base64 of UTF-16LE bytes (a UTF-16BE byte order mark is honoured)
+    Utf16,
+}
+
+/// Encoding of a file on disk, declared with `fileEncoding`.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub enum FileEncoding {
+    Utf8,
+    /// ISO-8859-1: every byte is the code point of the same value
+    Latin1,
+}
+
+pub const UTF8_BOM: &[u8] = b"\xEF\xBB\xBF";
+
+fn parse_encoding_name(args: &Value, key: &str) -> Result<bool> {
+    match args[key].as_str() {
+        None => Ok(false),
+        Some(name) => match name.to_ascii_lowercase().as_str() {
+            "utf-8" | "utf8" => Ok(false),
+            "utf-16" | "utf16" | "utf-16le" => Ok(true),
+            _ => Err(anyhow!(
+                "Unsupported {} '{}'. Use 'utf-8' or 'utf-16'",
+                key,
+                name
+            )),
+        },
+    }
+}
+
+impl OffsetEncoding {
+    /// Read `offsetEncoding` from tool arguments, defaulting to UTF-8 bytes.
+    pub fn from_args(args: &Value) -> Result<Self> {
+        Ok(match parse_encoding_name(args, "offsetEncoding")? {
+            true => Self::Utf16,
+            false => Self::Utf8,
+        })
+    }
+
+    /// Convert a client offset into `source` to a byte offset.
+    pub fn to_byte_offset(self, source: &str, offset: usize) -> Result<usize> {
+        match self {
+            Self::Utf8 => {
+                if offset > source.len() || !source.is_char_boundary(offset) {
+                    return Err(anyhow!(
+                        "Byte offset {} is not on a character boundary",
+                        offset
+                    ));
+                }
+                Ok(offset)
+            }
+            Self::Utf16 => utf16_to_byte_offset(source, offset),
+        }
+    }
+
+    /// Byte offset of a 1-indexed line and column, with the column counted
+    /// in characters for UTF-8 and code units for UTF-16. Clamped to the end
+    /// of the line; `None` if the line does not exist.
+    pub fn position_to_byte_offset(
+        self,
+        source: &str,
+        line: usize,
+        column: usize,
+    ) -> Result<Option<usize>> {
+        match self {
+            Self::Utf8 => Ok(edit_utils::offset_from_position(source, line, column)),
+            Self::Utf16 => {
+                let Some(line_offset) = edit_utils::offset_from_position(source, line, 1) else {
+                    return Ok(None);
+                };
+                let rest = &source[line_offset..];
+                let line_text = &rest[..rest.find('\n').unwrap_or(rest.len())];
+                let units = column
+                    .saturating_sub(1)
+                    .min(line_text.encode_utf16().count());
+                Ok(Some(line_offset + utf16_to_byte_offset(line_text, units)?))
+            }
+        }
+    }
+
+    /// Convert a byte offset into `source` to the client's units.
+    pub fn from_byte_offset(self, source: &str, offset: usize) -> usize {
+        match self {
+            Self::Utf8 => offset,
+            Self::Utf16 => byte_to_utf16_offset(source, offset),
+        }
+    }
+}
+
+impl ContentEncoding {
+    /// Read `contentEncoding` from tool arguments, defaulting to plain strings.
+    pub fn from_args(args: &Value) -> Result<Self> {
+        Ok(match parse_encoding_name(args, "contentEncoding")? {
+            true => Self::Utf16,
+            false => Self::Utf8,
+        })
+    }
+
+    pub fn decode(self, content: &str) -> Result<String> {
+        match self {
+            Self::Utf8 => Ok(content.to_string()),
+            Self::Utf16 => {
+                let bytes = base64::engine::general_purpose::STANDARD
+                    .decode(content.trim())
+                    .map_err(|e| anyhow!("UTF-16 content must be base64 encoded: {}", e))?;
+                decode_utf16_bytes(&bytes)
+            }
+        }
+    }
+
+    pub fn encode(self, content: &str) -> String {
+        match self {
+            Self::Utf8 => content.to_string(),
+            Self::Utf16 => {
+                let bytes: Vec<u8> = content
+                    .encode_utf16()
+                    .flat_map(|unit| unit.to_le_bytes())
+                    .collect();
+                base64::engine::general_purpose::STANDARD.encode(bytes)
+            }
+        }
+    }
+}
+
+impl FileEncoding {
+    /// Read `fileEncoding` from tool arguments, defaulting to UTF-8.
+    pub fn from_args(args: &Value) -> Result<Self> {
+        match args["fileEncoding"].as_str() {
+            None => Ok(Self::Utf8),
+            Some(name) => match name.to_ascii_lowercase().as_str() {
+                "utf-8" | "utf8" => Ok(Self::Utf8),
+                "latin-1" | "latin1" | "iso-8859-1" => Ok(Self::Latin1),
+                _ => Err(anyhow!(
+                    "Unsupported fileEncoding '{}'. Use 'utf-8' or 'latin-1'",
+                    name
+                )),
+            },
+        }
+    }
+
+    /// Text of a file's bytes, without a UTF-8 byte order mark.
+    pub fn decode(self, bytes: &[u8]) -> Result<String> {
+        match self {
+            Self::Utf8 => String::from_utf8(bytes.strip_prefix(UTF8_BOM).unwrap_or(bytes).to_vec())
+                .map_err(|e| {
+                    anyhow!(
+                        "File is not valid UTF-8 ({}); set fileEncoding if it is Latin-1",
+                        e
+                    )
+                }),
+            Self::Latin1 => Ok(bytes.iter().map(|&byte| byte as char).collect()),
+        }
+    }
+
+    /// Bytes to write for `text`, with a UTF-8 byte order mark if `bom`.
+    /// Characters Latin-1 cannot represent are an error, not replaced.
+    pub fn encode(self, text: &str, bom: bool) -> Result<Vec<u8>> {
+        match self {
+            Self::Utf8 => {
+                let mut bytes = if bom { UTF8_BOM.to_vec() } else { Vec::new() };
+                bytes.extend_from_slice(text.as_bytes());
+                Ok(bytes)
+            }
+            Self::Latin1 => text
+                .chars()
+                .map(|c| {
+                    u8::try_from(u32::from(c))
+                        .map_err(|_| anyhow!("'{}' cannot be written as Latin-1", c))
+                })
+                .collect(),
+        }
+    }
+}
+
+/// Decode UTF-16 bytes, little-endian unless a big-endian BOM says otherwise.
+/// Unpaired surrogates are rejected rather than replaced so the round trip
+/// stays lossless.
+pub fn decode_utf16_bytes(bytes: &[u8]) -> Result<String> {
+    if bytes.len() % 2 != 0 {
+        return Err(anyhow!(
+            "UTF-16 content has an odd number of bytes ({})",
+            bytes.len()
+        ));
+    }
+    let (big_endian, body) = match bytes {
+        [0xFE, 0xFF, rest @ ..] => (true, rest),
+        [0xFF, 0xFE, rest @ ..] => (false, rest),
+        _ => (false, bytes),
+    };
+    let units = body.chunks_exact(2).map(|pair| {
+        if big_endian {
+            u16::from_be_bytes([pair[0], pair[1]])
+        } else {
+            u16::from_le_bytes([pair[0], pair[1]])
+        }
+    });
+    char::decode_utf16(units)
+        .collect::<Result<String, _>>()
+        .map_err(|e| anyhow!("Invalid UTF-16 content: {}", e))
+}
+
+/// Byte offset of the UTF-16 code unit `offset` in `source`. Offsets that
+/// fall between the two halves of a surrogate pair are rejected.
+pub fn utf16_to_byte_offset(source: &str, offset: usize) -> Result<usize> {
+    let mut units = 0;
+    for (byte, c) in source.char_indices() {
+        if units == offset {
+            return Ok(byte);
+        }
+        units += c.len_utf16();
+        if units > offset {
+            return Err(anyhow!(
+                "UTF-16 offset {} splits the surrogate pair of '{}'",
+                offset,
+                c
+            ));
+        }
+    }
+    if units == offset {
+        Ok(source.len())
+    } else {
+        Err(anyhow!(
+            "UTF-16 offset {} is past the end of the source ({} code units)",
+            offset,
+            units
+        ))
+    }
+}
+
+/// Number of UTF-16 code units before the byte `offset` in `source`.
+pub fn byte_to_utf16_offset(source: &str, offset: usize) -> usize {
+    source[..offset].encode_utf16().count()
+}
+
+/// Rewrite an ast-grep `range` for a client using `encoding`: UTF-16
+/// clients get `utf16Offset` alongside `byteOffset`, and columns counted in
+/// code units.
+pub fn encode_range(source: &str, range: &Value, encoding: OffsetEncoding) -> Value {
+    let mut range = range.clone();
+    if encoding == OffsetEncoding::Utf8 {
+        return range;
+    }
+    let byte_offset = |key: &str| range["byteOffset"][key].as_u64().map(|o| o as usize);
+    if let (Some(start), Some(end)) = (byte_offset("start"), byte_offset("end")) {
+        for (key, offset) in [("start", start), ("end", end)] {
+            let line_start = edit_utils::line_start(source, offset);
+            range[key]["column"] = source[line_start..offset].encode_utf16().count().into();
+        }
+        range["utf16Offset"] = serde_json::json!({
+            "start": byte_to_utf16_offset(source, start),
+            "end": byte_to_utf16_offset(source, end),
+        });
+    }
+    range
+}
+
+/// An ast-grep style `range` for bytes `start..end` of `source`, for
+/// spans that did not come from an ast-grep match. Lines are zero-based
+/// and columns count characters, as ast-grep reports them.
+pub fn byte_range(source: &str, start: usize, end: usize) -> Value {
+    let position = |offset: usize| {
+        let line_start = edit_utils::line_start(source, offset);
+        serde_json::json!({
+            "line": source[..line_start].matches('\n').count(),
+            "column": source[line_start..offset].chars().count(),
+        })
+    };
+    serde_json::json!({
+        "byteOffset": {"start": start, "end": end},
+        "start": position(start),
+        "end": position(end),
+    })
+}
+
+/// An ast-grep `range` as an LSP `Range`: zero-based lines and UTF-16
+/// `character` offsets, as editors expect for selections.
+pub fn lsp_range(source: &str, range: &Value) -> Option<Value> {
+    let position = |key: &str| {
+        let offset = range["byteOffset"][key].as_u64()? as usize;
+        let line_start = edit_utils::line_start(source, offset);
+        Some(serde_json::json!({
+            "line": source[..line_start].matches('\n').count(),
+            "character": source[line_start..offset].encode_utf16().count(),
+        }))
+    };
+    Some(serde_json::json!({
+        "start": position("start")?,
+        "end": position("end")?,
+    }))
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    // "😀" is 4 bytes in UTF-8 and a surrogate pair in UTF-16
+    const SOURCE: &str = "let s = \"a😀b\";\n";
+
+    #[test]
+    fn test_selection_spanning_emoji() {
+        // UTF-16 selection covering `a😀b`: code units 9..13
+        let start = utf16_to_byte_offset(SOURCE, 9).unwrap();
+        let end = utf16_to_byte_offset(SOURCE, 13).unwrap();
+        assert_eq!(&SOURCE[start..end], "a😀b");
+        assert_eq!((start, end), (9, 15));
+
+        assert_eq!(byte_to_utf16_offset(SOURCE, start), 9);
+        assert_eq!(byte_to_utf16_offset(SOURCE, end), 13);
+    }
+
+    #[test]
+    fn test_offset_inside_surrogate_pair_rejected() {
+        let error = utf16_to_byte_offset(SOURCE, 11).unwrap_err();
+        assert!(error.to_string().contains("surrogate pair"));
+        assert!(OffsetEncoding::Utf8.to_byte_offset(SOURCE, 11).is_err());
+    }
+
+    #[test]
+    fn test_utf16_content_round_trip() {
+        let encoded = ContentEncoding::Utf16.encode(SOURCE);
+        assert_eq!(ContentEncoding::Utf16.decode(&encoded).unwrap(), SOURCE);
+
+        // Big-endian input with a byte order mark decodes to the same text
+        let mut big_endian = vec![0xFE, 0xFF];
+        big_endian.extend(SOURCE.encode_utf16().flat_map(|unit| unit.to_be_bytes()));
+        assert_eq!(decode_utf16_bytes(&big_endian).unwrap(), SOURCE);
+
+        // A lone high surrogate is an error, not a replacement character
+        assert!(decode_utf16_bytes(&[0x3D, 0xD8]).is_err());
+    }
+
+    #[test]
+    fn test_file_encoding_round_trip() {
+        let bom_file = b"\xEF\xBB\xBFlet a = 1;\n";
+        let text = FileEncoding::Utf8.decode(bom_file).unwrap();
+        assert_eq!(text, "let a = 1;\n");
+        assert_eq!(FileEncoding::Utf8.encode(&text, true).unwrap(), bom_file);
+
+        // "café" in Latin-1 is not valid UTF-8
+        let latin1_file = b"s = 'caf\xE9'\n";
+        assert!(FileEncoding::Utf8.decode(latin1_file).is_err());
+        let text = FileEncoding::Latin1.decode(latin1_file).unwrap();
+        assert_eq!(text, "s = 'café'\n");
+        assert_eq!(
+            FileEncoding::Latin1.encode(&text, false).unwrap(),
+            latin1_file
+        );
+        assert!(FileEncoding::Latin1.encode("s = '😀'", false).is_err());
+    }
+
+    #[test]
+    fn test_encode_range_columns() {
+        let range = serde_json::json!({
+            "byteOffset": {"start": 9, "end": 15},
+            "start": {"line": 0, "column": 9},
+            "end": {"line": 0, "column": 12}
+        });
+        let encoded = encode_range(SOURCE, &range, OffsetEncoding::Utf16);
+        assert_eq!(encoded["utf16Offset"]["end"], 13);
+        assert_eq!(encoded["end"]["column"], 13);
+        assert_eq!(encoded["byteOffset"]["end"], 15);
+    }
+
+    #[test]
+    fn test_utf16_position_columns() {
+        let source = "😀x\n";
+        let utf16 = OffsetEncoding::Utf16;
+        // Column 3 is just past the surrogate pair
+        assert_eq!(
+            utf16.position_to_byte_offset(source, 1, 3).unwrap(),
+            Some(4)
+        );
+        assert!(utf16.position_to_byte_offset(source, 1, 2).is_err());
+        assert_eq!(
+            OffsetEncoding::Utf8
+                .position_to_byte_offset(source, 1, 2)
+                .unwrap(),
+            Some(4)
+        );
+    }
+
+    #[test]
+    fn test_byte_range_matches_ast_grep_shape() {
+        let source = "x := 1\ny😀 := 2\n";
+        let range = byte_range(source, 13, 15);
+        assert_eq!(&source[13..15], ":=");
+        assert_eq!(range["start"], serde_json::json!({"line": 1, "column": 3}));
+        assert_eq!(range["end"]["column"], 5);
+        assert_eq!(
+            encode_range(source, &range, OffsetEncoding::Utf16)["start"]["column"],
+            4
+        );
+    }
+
+    #[test]
+    fn test_lsp_range_counts_utf16_characters() {
+        let source = format!("// head\n{SOURCE}");
+        let range = serde_json::json!({"byteOffset": {"start": 16, "end": 24}});
+        assert_eq!(&source[16..24], "\"a😀b\"");
+        assert_eq!(
+            lsp_range(&source, &range).unwrap(),
+            serde_json::json!({
+                "start": {"line": 1, "character": 8},
+                "end": {"line": 1, "character": 14},
+            })
+        );
+    }
+}
@@ -0,0 +1,746 @@
+//! Find and rewrite keys of a TOML document in place: its tables, the
+//! key/value pairs of each, and where every key and value sits in the
+//! text. ast-grep bundles no TOML grammar, so documents are parsed with
+//! the tree-sitter-toml grammar built into the server, and its syntax tree
+//! is read into tables here. Duplicate keys are not checked for.
+//!
+//! Keys are picked with selectors such as
+//! `table[name=dependencies] > pair[key=serde] > pair[key=version]`. A
+//! selector names a dotted path: the table's name followed by the keys.
+//! The path is looked up in every form TOML can spell it in, so the one
+//! above also finds `version` under `[dependencies.serde]`, in the inline
+//! table `serde = { version = "1" }`, and as `serde.version = "1"`. An
+//! array of tables, `[[bin]]`, needs `table[name=bin][index=N]` to choose
+//! one entry, counting from 0.
+
+use anyhow::{anyhow, Result};
+use tree_sitter::Node;
+
+/// A `key = value` pair. Offsets are into the document.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Pair {
+    /// The key's dotted parts, unquoted
+    pub key: Vec<String>,
+    /// Range of the whole pair, from the key to the end of the value
+    pub start: usize,
+    pub end: usize,
+    pub value_start: usize,
+    pub value_end: usize,
+    /// What the value is; see [`value_kind`]
+    pub kind: &'static str,
+    /// The pairs of an inline table value
+    pub children: Vec<Pair>,
+}
+
+/// A table: the pairs under a `[name]` or `[[name]]` header, or those
+/// before the first header.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Table {
+    /// The dotted name, empty for the pairs before any header
+    pub name: Vec<String>,
+    /// An entry of an array of tables, `[[name]]`
+    pub array: bool,
+    /// Range of the header, `None` for the pairs before any header
+    pub header: Option<(usize, usize)>,
+    /// Where the table ends: the start of the next header's line
+    pub end: usize,
+    pub pairs: Vec<Pair>,
+}
+
+/// Where a selector points: the table it names, if any, and the dotted
+/// path from the document's root.
+#[derive(Debug, Clone, PartialEq)]
+pub struct Selector {
+    pub table: Option<(Vec<String>, Option<usize>)>,
+    pub path: Vec<String>,
+}
+
+fn error_at(source: &str, offset: usize, message: &str) -> anyhow::Error {
+    anyhow!(
+        "Line {}: {}",
+        source[..offset].matches('\n').count() + 1,
+        message
+    )
+}
+
+/// The syntax tree of a TOML document.
+fn parse(source: &str) -> Result<tree_sitter::Tree> {
+    let mut parser = tree_sitter::Parser::new();
+    parser.set_language(&tree_sitter_toml_ng::LANGUAGE.into())?;
+    parser
+        .parse(source, None)
+        .ok_or_else(|| anyhow!("Could not parse the TOML document"))
+}
+
+/// The first syntax error or missing token in `node`, if any.
+fn first_error(node: Node) -> Option<Node> {
+    if node.is_error() || node.is_missing() {
+        return Some(node);
+    }
+    let mut cursor = node.walk();
+    let children: Vec<Node> = node.children(&mut cursor).collect();
+    children
+        .into_iter()
+        .filter(|child| child.has_error() || child.is_missing())
+        .find_map(first_error)
+}
+
+/// The named children of `node`, without comments.
+fn named_children(node: Node) -> Vec<Node> {
+    let mut cursor = node.walk();
+    let children = node
+        .named_children(&mut cursor)
+        .filter(|child| child.kind() != "comment")
+        .collect();
+    children
+}
+
+fn is_key(node: &Node) -> bool {
+    matches!(node.kind(), "bare_key" | "quoted_key" | "dotted_key")
+}
+
+/// The unquoted parts of a key node.
+fn key(source: &str, node: Node) -> Vec<String> {
+    match node.kind() {
+        "dotted_key" => named_children(node)
+            .into_iter()
+            .filter(is_key)
+            .flat_map(|child| key(source, child))
+            .collect(),
+        _ => vec![unquote(&source[node.byte_range()])],
+    }
+}
+
+fn pair(source: &str, node: Node) -> Result<Pair> {
+    let mut named = named_children(node).into_iter();
+    let key_node = named
+        .next()
+        .filter(is_key)
+        .ok_or_else(|| error_at(source, node.start_byte(), "expected a key"))?;
+    let value = named
+        .next()
+        .ok_or_else(|| error_at(source, key_node.end_byte(), "expected a value"))?;
+    let children = match value.kind() {
+        "inline_table" => pairs(source, value)?,
+        _ => Vec::new(),
+    };
+    Ok(Pair {
+        key: key(source, key_node),
+        start: node.start_byte(),
+        end: value.end_byte(),
+        value_start: value.start_byte(),
+        value_end: value.end_byte(),
+        kind: value_kind(value.kind()),
+        children,
+    })
+}
+
+fn pairs(source: &str, node: Node) -> Result<Vec<Pair>> {
+    named_children(node)
+        .into_iter()
+        .filter(|child| child.kind() == "pair")
+        .map(|child| pair(source, child))
+        .collect()
+}
+
+/// The tables of a TOML document, in order, the pairs before any header
+/// first. Fails on a syntax error.
+pub fn tables(source: &str) -> Result<Vec<Table>> {
+    let tree = parse(source)?;
+    let root = tree.root_node();
+    if let Some(error) = first_error(root) {
+        return Err(error_at(source, error.start_byte(), "not valid TOML"));
+    }
+
+    let mut tables = vec![Table {
+        name: Vec::new(),
+        array: false,
+        header: None,
+        end: source.len(),
+        pairs: Vec::new(),
+    }];
+    for node in named_children(root) {
+        let array = match node.kind() {
+            "pair" => {
+                let pair = pair(source, node)?;
+                if let Some(table) = tables.last_mut() {
+                    table.pairs.push(pair);
+                }
+                continue;
+            }
+            "table" => false,
+            "table_array_element" => true,
+            _ => continue,
+        };
+        let name = named_children(node)
+            .into_iter()
+            .find(is_key)
+            .ok_or_else(|| error_at(source, node.start_byte(), "expected a table name"))?;
+        let close = if array { "]]" } else { "]" };
+        let header_end = source[name.end_byte()..]
+            .find(close)
+            .map(|i| name.end_byte() + i + close.len())
+            .ok_or_else(|| {
+                error_at(
+                    source,
+                    name.end_byte(),
+                    &format!("expected '{close}' after the table name"),
+                )
+            })?;
+        let line_start = source[..node.start_byte()].rfind('\n').map_or(0, |i| i + 1);
+        if let Some(table) = tables.last_mut() {
+            table.end = line_start;
+        }
+        tables.push(Table {
+            name: key(source, name),
+            array,
+            header: Some((node.start_byte(), header_end)),
+            end: source.len(),
+            pairs: pairs(source, node)?,
+        });
+    }
+    Ok(tables)
+}
+
+/// The text of a key or string without its quotes. Escapes other than
+/// `\"` and `\\` are kept as written.
+pub fn unquote(text: &str) -> String {
+    if text.len() >= 2 && text.starts_with('\'') && text.ends_with('\'') {
+        return text[1..text.len() - 1].to_string();
+    }
+    if text.len() >= 2 && text.starts_with('"') && text.ends_with('"') {
+        return text[1..text.len() - 1]
+            .replace("\\\"", "\"")
+            .replace("\\\\", "\\");
+    }
+    text.to_string()
+}
+
+/// A key part as TOML writes it: bare when it can be, quoted otherwise.
+pub fn key_part(part: &str) -> String {
+    match !part.is_empty()
+        && part
+            .chars()
+            .all(|c| c.is_ascii_alphanumeric() || c == '_' || c == '-')
+    {
+        true => part.to_string(),
+        false => quote(part),
+    }
+}
+
+/// A dotted key as TOML writes it.
+pub fn dotted(key: &[String]) -> String {
+    key.iter()
+        .map(|part| key_part(part))
+        .collect::<Vec<_>>()
+        .join(".")
+}
+
+/// `text` as a basic string.
+pub fn quote(text: &str) -> String {
+    let mut quoted = String::from("\"");
+    for c in text.chars() {
+        match c {
+            '"' => quoted.push_str("\\\""),
+            '\\' => quoted.push_str("\\\\"),
+            '\n' => quoted.push_str("\\n"),
+            '\t' => quoted.push_str("\\t"),
+            '\r' => quoted.push_str("\\r"),
+            c if c.is_control() => quoted.push_str(&format!("\\u{:04X}", c as u32)),
+            c => quoted.push(c),
+        }
+    }
+    quoted.push('"');
+    quoted
+}
+
+/// A JSON value as a TOML value: objects become inline tables. `null` has
+/// no TOML form.
+pub fn to_toml(value: &serde_json::Value) -> Result<String> {
+    Ok(match value {
+        serde_json::Value::Null => return Err(anyhow!("TOML has no null value")),
+        serde_json::Value::Bool(value) => value.to_string(),
+        serde_json::Value::Number(value) => value.to_string(),
+        serde_json::Value::String(value) => quote(value),
+        serde_json::Value::Array(values) => format!(
+            "[{}]",
+            values
+                .iter()
+                .map(to_toml)
+                .collect::<Result<Vec<_>>>()?
+                .join(", ")
+        ),
+        serde_json::Value::Object(entries) if entries.is_empty() => "{}".to_string(),
+        serde_json::Value::Object(entries) => format!(
+            "{{ {} }}",
+            entries
+                .iter()
+                .map(|(key, value)| Ok(format!("{} = {}", key_part(key), to_toml(value)?)))
+                .collect::<Result<Vec<_>>>()?
+                .join(", ")
+        ),
+    })
+}
+
+/// Whether `text` is exactly one TOML value.
+pub fn is_value(text: &str) -> bool {
+    let prefix = "value = ";
+    let start = prefix.len();
+    tables(&format!("{prefix}{text}\n")).is_ok_and(|tables| {
+        matches!(tables.as_slice(), [table] if matches!(
+            table.pairs.as_slice(),
+            [pair] if (pair.value_start, pair.value_end) == (start, start + text.len())
+        ))
+    })
+}
+
+/// What a value of the grammar's node kind is: `string`, `integer`,
+/// `float`, `boolean`, `datetime`, `array` or `table`.
+pub fn value_kind(kind: &str) -> &'static str {
+    match kind {
+        "string" => "string",
+        "integer" => "integer",
+        "float" => "float",
+        "boolean" => "boolean",
+        "array" => "array",
+        "inline_table" => "table",
+        _ => "datetime",
+    }
+}
+
+impl Selector {
+    /// Parse `table[name=a] > pair[key=b] > pair[key=c]`. A bare
+    /// attribute value is a dotted path; a quoted one is a single key and
+    /// may hold dots.
+    pub fn parse(selector: &str) -> Result<Self> {
+        let mut table = None;
+        let mut path = Vec::new();
+        for (i, step) in selector.split('>').enumerate() {
+            let step = step.trim();
+            let kind_end = step.find('[').unwrap_or(step.len());
+            let mut attributes = Vec::new();
+            let mut rest = &step[kind_end..];
+            while !rest.is_empty() {
+                let close = rest
+                    .strip_prefix('[')
+                    .and_then(|inner| inner.find(']'))
+                    .ok_or_else(|| anyhow!("Expected [attribute=value] in '{}'", step))?;
+                let (name, value) = rest[1..close + 1]
+                    .split_once('=')
+                    .ok_or_else(|| anyhow!("Expected [attribute=value] in '{}'", step))?;
+                let value = value.trim();
+                let parts = match value.starts_with(['"', '\'']) {
+                    true => vec![unquote(value)],
+                    false => value
+                        .split('.')
+                        .map(|part| part.trim().to_string())
+                        .collect(),
+                };
+                attributes.push((name.trim(), value, parts));
+                rest = &rest[close + 2..];
+            }
+            let attribute = |wanted: &str| {
+                attributes
+                    .iter()
+                    .find(|(name, _, _)| *name == wanted)
+                    .map(|(_, value, parts)| (*value, parts.clone()))
+            };
+            if let Some((name, _, _)) = attributes
+                .iter()
+                .find(|(name, _, _)| !matches!(*name, "name" | "index" | "key"))
+            {
+                return Err(anyhow!(
+                    "Unknown attribute '{}'; tables take name and index, pairs take key",
+                    name
+                ));
+            }
+            match &step[..kind_end] {
+                "table" if i == 0 => {
+                    let (_, name) =
+                        attribute("name").ok_or_else(|| anyhow!("'{}' needs [name=...]", step))?;
+                    let index = match attribute("index") {
+                        Some((index, _)) => Some(index.parse::<usize>().map_err(|_| {
+                            anyhow!("index takes a non-negative integer, got '{}'", index)
+                        })?),
+                        None => None,
+                    };
+                    path.extend(name.iter().cloned());
+                    table = Some((name, index));
+                }
+                "table" => return Err(anyhow!("table can only be the selector's first step")),
+                "pair" => {
+                    let (_, key) =
+                        attribute("key").ok_or_else(|| anyhow!("'{}' needs [key=...]", step))?;
+                    path.extend(key);
+                }
+                kind => {
+                    return Err(anyhow!(
+                        "Unknown step '{}'; use table[name=...] or pair[key=...]",
+                        kind
+                    ))
+                }
+            }
+        }
+        if path.iter().any(String::is_empty) {
+            return Err(anyhow!("'{}' has an empty key", selector));
+        }
+        Ok(Selector { table, path })
+    }
+}
+
+/// The tables `selector` may find its path in: every table, or only the
+/// chosen entry of an array of tables. Fails when an array is not given an
+/// index or the entry does not exist.
+pub fn candidate_tables<'a>(tables: &'a [Table], selector: &Selector) -> Result<Vec<&'a Table>> {
+    let Some((name, index)) = &selector.table else {
+        return Ok(tables.iter().collect());
+    };
+    let entries: Vec<&Table> = tables
+        .iter()
+        .filter(|table| table.array && table.name == *name)
+        .collect();
+    match (index, entries.len()) {
+        (None, 0) => Ok(tables.iter().collect()),
+        (None, count) => Err(anyhow!(
+            "[[{}]] is an array of {} tables; add [index=N] to choose one",
+            dotted(name),
+            count
+        )),
+        (Some(index), count) => entries
+            .get(*index)
+            .map(|entry| vec![*entry])
+            .ok_or_else(|| {
+                anyhow!(
+                    "[[{}]] has {} entries, so there is no index {}",
+                    dotted(name),
+                    count,
+                    index
+                )
+            }),
+    }
+}
+
+/// The pairs holding `path`, each with its table, however the path is
+/// spelled: as headers, dotted keys or inline tables.
+pub fn find<'a>(tables: &[&'a Table], path: &[String]) -> Vec<(&'a Table, &'a Pair)> {
+    fn walk<'a>(pairs: &'a [Pair], path: &[String], found: &mut Vec<&'a Pair>) {
+        for pair in pairs {
+            if pair.key.as_slice() == path {
+                found.push(pair);
+            } else if path.starts_with(&pair.key) {
+                walk(&pair.children, &path[pair.key.len()..], found);
+            }
+        }
+    }
+    let mut found = Vec::new();
+    for table in tables {
+        if let Some(rest) = path.strip_prefix(table.name.as_slice()) {
+            let mut pairs = Vec::new();
+            walk(&table.pairs, rest, &mut pairs);
+            found.extend(pairs.into_iter().map(|pair| (*table, pair)));
+        }
+    }
+    found
+}
+
+/// Where to add `path = value` when no pair holds `path` yet, as a
+/// `(start, end, replacement)` edit. The key goes into an inline table
+/// that holds a prefix of the path, or else into the innermost table
+/// whose name is one: in key order when the table has three or more
+/// keys and they are sorted, after its last key otherwise. A table the selector names that does
+/// not exist is appended to the document.
+pub fn insertion(
+    source: &str,
+    tables: &[Table],
+    selector: &Selector,
+    value: &str,
+) -> Result<(usize, usize, String)> {
+    let path = &selector.path;
+    let candidates = candidate_tables(tables, selector)?;
+    let line_number = |offset: usize| source[..offset].matches('\n').count() + 1;
+    for length in (1..path.len()).rev() {
+        let Some((_, pair)) = find(&candidates, &path[..length]).into_iter().next() else {
+            continue;
+        };
+        if pair.kind != "table" {
+            return Err(anyhow!(
+                "{} on line {} is a {}, not a table",
+                dotted(&path[..length]),
+                line_number(pair.start),
+                pair.kind
+            ));
+        }
+        let entry = format!("{} = {}", dotted(&path[length..]), value);
+        return Ok(match pair.children.last() {
+            Some(last) => (last.end, last.end, format!(", {entry}")),
+            None => (pair.value_start, pair.value_end, format!("{{ {entry} }}")),
+        });
+    }
+
+    let line_end = |offset: usize| {
+        source[offset..]
+            .find('\n')
+            .map_or(source.len(), |i| offset + i + 1)
+    };
+    let line_start = |offset: usize| source[..offset].rfind('\n').map_or(0, |i| i + 1);
+    // A new line at `at`, the start of a line or the end of the document
+    let new_line = |at: usize, line: String| {
+        let newline = match at > 0 && !source[..at].ends_with('\n') {
+            true => "\n",
+            false => "",
+        };
+        Ok((at, at, format!("{newline}{line}\n")))
+    };
+    let table = candidates
+        .iter()
+        .filter(|table| table.name.len() < path.len() && path.starts_with(&table.name))
+        .max_by_key(|table| table.name.len())
+        .ok_or_else(|| anyhow!("No table can hold {}", dotted(path)))?;
+    if let Some((name, _)) = &selector.table {
+        if table.name.len() < name.len() {
+            let separator = match source.trim().is_empty() {
+                true => "",
+                false => "\n",
+            };
+            return new_line(
+                source.len(),
+                format!(
+                    "{separator}[{}]\n{} = {}",
+                    dotted(name),
+                    dotted(&path[name.len()..]),
+                    value
+                ),
+            );
+        }
+    }
+    if table.array && selector.table.is_none() {
+        return Err(anyhow!(
+            "[[{}]] is an array of tables; choose an entry with table[name={}][index=N]",
+            dotted(&table.name),
+            dotted(&table.name)
+        ));
+    }
+    let key = dotted(&path[table.name.len()..]);
+    let indent = table.pairs.last().map_or("", |pair| {
+        let start = line_start(pair.start);
+        &source[start..pair.start]
+    });
+    let line = format!("{indent}{key} = {value}");
+    let keys: Vec<String> = table.pairs.iter().map(|pair| dotted(&pair.key)).collect();
+    let sorted = keys.len() >= 3 && keys.windows(2).all(|pair| pair[0] <= pair[1]);
+    if let Some(next) = table
+        .pairs
+        .iter()
+        .zip(&keys)
+        .find(|(_, other)| sorted && **other > key)
+        .map(|(pair, _)| pair)
+    {
+        // Above the comments that lead the next key
+        let mut at = line_start(next.start);
+        while at > 0 {
+            let previous = line_start(at - 1);
+            match source[previous..at].trim_start().starts_with('#') {
+                true => at = previous,
+                false => break,
+            }
+        }
+        return new_line(at, line);
+    }
+    match (table.pairs.last(), table.header) {
+        (Some(last), _) => new_line(line_end(last.end), line),
+        (None, Some((_, header_end))) => new_line(line_end(header_end), line),
+        (None, None) => {
+            let blank = match tables.len() > 1 {
+                true => "\n",
+                false => "",
+            };
+            Ok((0, 0, format!("{line}\n{blank}")))
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const CARGO: &str = r#"# The package
+[package]
+name = "demo" # the crate's name
+version = "0.1.0"
+
+[dependencies]
+anyhow = "1.0"
+serde = { version = "1", features = ["derive"] }
+tokio.version = "1.0"
+
+[dependencies.reqwest]
+version = "0.11"
+
+[[bin]]
+name = "one"
+
+[[bin]]
+name = "two"
+"#;
+
+    fn cargo_tables() -> Vec<Table> {
+        tables(CARGO).unwrap()
+    }
+
+    #[test]
+    fn test_tables() {
+        let tables = cargo_tables();
+        let names: Vec<(String, bool)> = tables
+            .iter()
+            .map(|table| (dotted(&table.name), table.array))
+            .collect();
+        assert_eq!(
+            names,
+            [
+                ("".to_string(), false),
+                ("package".to_string(), false),
+                ("dependencies".to_string(), false),
+                ("dependencies.reqwest".to_string(), false),
+                ("bin".to_string(), true),
+                ("bin".to_string(), true)
+            ]
+        );
+        let package = &tables[1];
+        assert_eq!(
+            &CARGO[package.pairs[0].value_start..package.pairs[0].value_end],
+            "\"demo\""
+        );
+        assert_eq!(
+            package.header.map(|(start, end)| &CARGO[start..end]),
+            Some("[package]")
+        );
+        assert_eq!(&CARGO[package.end..package.end + 14], "[dependencies]");
+        let serde = &tables[2].pairs[1];
+        assert_eq!(serde.kind, "table");
+        assert_eq!(serde.children.len(), 2);
+        assert_eq!(serde.children[1].kind, "array");
+        assert_eq!(tables[2].pairs[2].key, ["tokio", "version"]);
+        assert_eq!(
+            tables[5].header.map(|(start, end)| &CARGO[start..end]),
+            Some("[[bin]]")
+        );
+        assert_eq!(
+            &CARGO[tables[5].pairs[0].value_start..tables[5].pairs[0].value_end],
+            "\"two\""
+        );
+
+        let error = super::tables("[a]\nb 1\n").unwrap_err().to_string();
+        assert_eq!(error, "Line 2: not valid TOML");
+    }
+
+    #[test]
+    fn test_selectors() {
+        let tables = cargo_tables();
+        let lookup = |selector: &str| -> Result<Vec<String>> {
+            let selector = Selector::parse(selector)?;
+            let candidates = candidate_tables(&tables, &selector)?;
+            Ok(find(&candidates, &selector.path)
+                .iter()
+                .map(|(_, pair)| CARGO[pair.value_start..pair.value_end].to_string())
+                .collect())
+        };
+        assert_eq!(
+            lookup("table[name=dependencies] > pair[key=serde] > pair[key=version]").unwrap(),
+            ["\"1\""]
+        );
+        assert_eq!(
+            lookup("table[name=dependencies] > pair[key=reqwest.version]").unwrap(),
+            ["\"0.11\""]
+        );
+        assert_eq!(
+            lookup("pair[key=dependencies.tokio.version]").unwrap(),
+            ["\"1.0\""]
+        );
+        assert_eq!(
+            lookup("table[name=bin][index=1] > pair[key=name]").unwrap(),
+            ["\"two\""]
+        );
+        assert!(lookup("table[name=bin] > pair[key=name]")
+            .unwrap_err()
+            .to_string()
+            .contains("add [index=N]"));
+        assert_eq!(Selector::parse("pair[key=\"a.b\"]").unwrap().path, ["a.b"]);
+        assert!(Selector::parse("pair[name=a]").is_err());
+        assert!(Selector::parse("pair[key=a] > table[name=b]").is_err());
+    }
+
+    #[test]
+    fn test_insertion() {
+        let tables = cargo_tables();
+        let insert = |selector: &str, value: &str| -> Result<String> {
+            let (start, end, replacement) =
+                insertion(CARGO, &tables, &Selector::parse(selector)?, value)?;
+            Ok(format!(
+                "{}{}{}",
+                &CARGO[..start],
+                replacement,
+                &CARGO[end..]
+            ))
+        };
+        // The dependencies are sorted, so a new one goes in order
+        assert!(
+            insert("table[name=dependencies] > pair[key=rand]", "\"0.8\"")
+                .unwrap()
+                .contains("anyhow = \"1.0\"\nrand = \"0.8\"\nserde = {")
+        );
+        assert!(
+            insert("table[name=package] > pair[key=edition]", "\"2021\"")
+                .unwrap()
+                .contains("version = \"0.1.0\"\nedition = \"2021\"\n\n[dependencies]")
+        );
+        assert!(insert(
+            "table[name=dependencies] > pair[key=serde] > pair[key=optional]",
+            "true"
+        )
+        .unwrap()
+        .contains("features = [\"derive\"], optional = true }"));
+        assert!(
+            insert("table[name=bin][index=0] > pair[key=path]", "\"a.rs\"")
+                .unwrap()
+                .contains("name = \"one\"\npath = \"a.rs\"\n\n[[bin]]")
+        );
+        assert!(insert("table[name=features] > pair[key=default]", "[]")
+            .unwrap()
+            .ends_with("name = \"two\"\n\n[features]\ndefault = []\n"));
+        assert!(insert(
+            "table[name=dependencies] > pair[key=anyhow.version]",
+            "\"1\""
+        )
+        .unwrap_err()
+        .to_string()
+        .contains("dependencies.anyhow on line 7 is a string, not a table"));
+        assert!(insert("pair[key=bin.path]", "\"a.rs\"")
+            .unwrap_err()
+            .to_string()
+            .contains("choose an entry"));
+    }
+
+    #[test]
+    fn test_values() {
+        assert_eq!(
+            to_toml(
+                &serde_json::json!({"version": "1", "features": ["a b"], "default-features": false})
+            )
+            .unwrap(),
+            "{ default-features = false, features = [\"a b\"], version = \"1\" }"
+        );
+        assert_eq!(quote("say \"hi\"\n"), "\"say \\\"hi\\\"\\n\"");
+        assert_eq!(key_part("a.b"), "\"a.b\"");
+
+        // A comment after the value is not part of it
+        assert_eq!(
+            tables("value = [1, 2]\n").unwrap()[0].pairs[0].kind,
+            "array"
+        );
+        assert!(is_value("[1, 2]"));
+        assert!(!is_value("[1, 2] # two"));
+        assert!(!is_value("1 2"));
+        assert_eq!(value_kind("local_date"), "datetime");
+        assert_eq!(value_kind("inline_table"), "table");
+    }
+}
@@ -0,0 +1,613 @@
+//! Parsing, applying and writing unified diffs (`diff -u`, `git diff`).
+//!
+//! Hunks are applied the way `patch` does without fuzz: every context and
+//! removed line must match exactly, but a hunk may be found up to
+//! [`MAX_HUNK_OFFSET`] lines away from where its header says if the file
+//! has shifted since the diff was made. A hunk that does not match within
+//! that window is a conflict and nothing is applied.
+//!
+//! Patches are written the way `git diff` writes them, with the blob ids
+//! of both versions, so `git apply --3way` can merge one that no longer
+//! applies cleanly.
+
+use crate::edit_utils::TextEdit;
+use anyhow::{anyhow, Result};
+use sha1::{Digest, Sha1};
+
+/// Farthest a hunk is looked for from its header line, after the shift of
+/// the hunk before it. Further away, the same lines are more likely another
+/// copy of them than the place the diff was made against.
+pub const MAX_HUNK_OFFSET: usize = 100;
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub enum HunkLine {
+    Context(String),
+    Remove(String),
+    Add(String),
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct Hunk {
+    /// 1-indexed first line of the hunk in the old file (0 for an empty file)
+    pub old_start: usize,
+    pub lines: Vec<HunkLine>,
+    /// The last added line ends the file without a newline
+    pub no_newline_at_end: bool,
+}
+
+#[derive(Debug, Clone, PartialEq, Eq)]
+pub struct FilePatch {
+    /// Path from the `---` header, `None` for a new file (`/dev/null`)
+    pub old_path: Option<String>,
+    /// Path from the `+++` header, `None` for a deleted file
+    pub new_path: Option<String>,
+    pub hunks: Vec<Hunk>,
+}
+
+/// A hunk that was applied, and how far from its header line it was found.
+#[derive(Debug, Clone, Copy, PartialEq, Eq)]
+pub struct AppliedHunk {
+    pub line: usize,
+    pub offset: isize,
+}
+
+/// Path from a `---`/`+++` header without git's `a/`/`b/` prefix or a
+/// trailing timestamp; `None` for `/dev/null`.
+fn header_path(header: &str) -> Option<String> {
+    let path = header.split('\t').next().unwrap_or(header).trim();
+    if path == "/dev/null" {
+        return None;
+    }
+    let path = path
+        .strip_prefix("a/")
+        .or_else(|| path.strip_prefix("b/"))
+        .unwrap_or(path);
+    Some(path.to_string())
+}
+
+/// `start[,count]` from a hunk header; the count defaults to 1.
+fn parse_range(range: &str) -> Option<(usize, usize)> {
+    match range.split_once(',') {
+        Some((start, count)) => Some((start.parse().ok()?, count.parse().ok()?)),
+        None => Some((range.parse().ok()?, 1)),
+    }
+}
+
+/// Parse every file in a unified diff. Lines outside of hunks (`diff --git`,
+/// `index`, commit messages) are ignored.
+pub fn parse(diff: &str) -> Result<Vec<FilePatch>> {
+    let mut patches: Vec<FilePatch> = Vec::new();
+    let mut lines = diff.lines().enumerate().peekable();
+    while let Some((index, line)) = lines.next() {
+        if let Some(old) = line.strip_prefix("--- ") {
+            let new = match lines.next() {
+                Some((_, next)) if next.starts_with("+++ ") => &next[4..],
+                _ => return Err(anyhow!("Line {}: '---' header without '+++'", index + 1)),
+            };
+            patches.push(FilePatch {
+                old_path: header_path(old),
+                new_path: header_path(new),
+                hunks: Vec::new(),
+            });
+            continue;
+        }
+        let Some(header) = line.strip_prefix("@@ ") else {
+            continue;
+        };
+        let patch = patches
+            .last_mut()
+            .ok_or_else(|| anyhow!("Line {}: hunk before any '---'/'+++' header", index + 1))?;
+        let invalid = || anyhow!("Line {}: invalid hunk header '{}'", index + 1, line);
+        let mut ranges = header.split_whitespace();
+        let (old_start, mut old_left) = ranges
+            .next()
+            .and_then(|range| range.strip_prefix('-'))
+            .and_then(parse_range)
+            .ok_or_else(invalid)?;
+        let (_, mut new_left) = ranges
+            .next()
+            .and_then(|range| range.strip_prefix('+'))
+            .and_then(parse_range)
+            .ok_or_else(invalid)?;
+
+        let mut hunk = Hunk {
+            old_start,
+            lines: Vec::new(),
+            no_newline_at_end: false,
+        };
+        while old_left > 0 || new_left > 0 {
+            let (body_index, body) = lines
+                .next()
+                .ok_or_else(|| anyhow!("Hunk at line {} ends before all its lines", index + 1))?;
+            // Some tools strip the space from empty context lines
+            let (marker, text) = match body.chars().next() {
+                Some(marker) => (marker, body[marker.len_utf8()..].to_string()),
+                None => (' ', String::new()),
+            };
+            match marker {
+                ' ' if old_left > 0 && new_left > 0 => {
+                    old_left -= 1;
+                    new_left -= 1;
+                    hunk.lines.push(HunkLine::Context(text));
+                }
+                '-' if old_left > 0 => {
+                    old_left -= 1;
+                    hunk.lines.push(HunkLine::Remove(text));
+                }
+                '+' if new_left > 0 => {
+                    new_left -= 1;
+                    hunk.lines.push(HunkLine::Add(text));
+                }
+                '\\' => {}
+                _ => {
+                    return Err(anyhow!(
+                        "Line {}: '{}' does not fit the hunk header at line {}",
+                        body_index + 1,
+                        body,
+                        index + 1
+                    ))
+                }
+            }
+        }
+        // "\ No newline at end of file" only matters after the new side's last line
+        if let Some((_, marker)) = lines.peek() {
+            if marker.starts_with('\\') && !matches!(hunk.lines.last(), Some(HunkLine::Remove(_))) {
+                hunk.no_newline_at_end = true;
+            }
+        }
+        patch.hunks.push(hunk);
+    }
+    if patches.is_empty() {
+        return Err(anyhow!("No '---'/'+++' file headers found in the diff"));
+    }
+    Ok(patches)
+}
+
+impl Hunk {
+    fn old_lines(&self) -> Vec<&str> {
+        self.lines
+            .iter()
+            .filter_map(|line| match line {
+                HunkLine::Context(text) | HunkLine::Remove(text) => Some(text.as_str()),
+                HunkLine::Add(_) => None,
+            })
+            .collect()
+    }
+
+    fn new_lines(&self) -> Vec<&str> {
+        self.lines
+            .iter()
+            .filter_map(|line| match line {
+                HunkLine::Context(text) | HunkLine::Add(text) => Some(text.as_str()),
+                HunkLine::Remove(_) => None,
+            })
+            .collect()
+    }
+}
+
+impl FilePatch {
+    /// Path the patch applies to: the new path, or the old one for a
+    /// deleted file.
+    pub fn path(&self) -> Option<&str> {
+        self.new_path.as_deref().or(self.old_path.as_deref())
+    }
+
+    /// Edits applying every hunk to `source`, with where each was found.
+    /// Fails with a `patch`-style conflict message if any hunk's context
+    /// does not match.
+    pub fn edits(&self, source: &str) -> Result<(Vec<TextEdit>, Vec<AppliedHunk>)> {
+        let newline = if source.contains("\r\n") {
+            "\r\n"
+        } else {
+            "\n"
+        };
+        let lines: Vec<&str> = source.split_inclusive('\n').collect();
+        let content = |line: &str| line.trim_end_matches(['\r', '\n']).to_string();
+        let mut line_offsets = Vec::with_capacity(lines.len() + 1);
+        let mut offset = 0;
+        for line in &lines {
+            line_offsets.push(offset);
+            offset += line.len();
+        }
+        line_offsets.push(offset);
+
+        let mut edits = Vec::new();
+        let mut applied = Vec::new();
+        // Hunks apply in order; each must start after the previous one, and
+        // is first looked for as far from its header as the previous one was
+        let mut earliest = 0;
+        let mut drift: isize = 0;
+        for (number, hunk) in self.hunks.iter().enumerate() {
+            let old = hunk.old_lines();
+            let matches_at = |at: usize| {
+                at + old.len() <= lines.len()
+                    && old
+                        .iter()
+                        .zip(&lines[at..])
+                        .all(|(expected, actual)| *expected == content(actual))
+            };
+            // An empty old side (e.g. a new file) sits after line `old_start`
+            let header_at = if old.is_empty() {
+                hunk.old_start
+            } else {
+                hunk.old_start.saturating_sub(1)
+            };
+            let expected = (header_at as isize + drift).clamp(0, lines.len() as isize) as usize;
+            let found = (0..=MAX_HUNK_OFFSET)
+                .flat_map(|distance| {
+                    [
+                        expected.checked_add(distance),
+                        expected.checked_sub(distance),
+                    ]
+                })
+                .flatten()
+                .find(|&at| at >= earliest && at <= lines.len() && matches_at(at));
+            let Some(at) = found else {
+                let mismatch = old
+                    .iter()
+                    .enumerate()
+                    .find(|(i, line)| {
+                        lines
+                            .get(expected + i)
+                            .map(|actual| content(actual))
+                            .as_deref()
+                            != Some(**line)
+                    })
+                    .map(|(i, line)| {
+                        format!(
+                            "; expected line {} to be {:?} but found {:?}",
+                            expected + i + 1,
+                            line,
+                            lines
+                                .get(expected + i)
+                                .map(|actual| content(actual))
+                                .unwrap_or_else(|| "end of file".to_string())
+                        )
+                    })
+                    .unwrap_or_default();
+                return Err(anyhow!(
+                    "Hunk #{} FAILED at {}: context does not match{}",
+                    number + 1,
+                    hunk.old_start,
+                    mismatch
+                ));
+            };
+
+            let new = hunk.new_lines();
+            let mut replacement = String::new();
+            for (i, line) in new.iter().enumerate() {
+                replacement.push_str(line);
+                let last = i + 1 == new.len();
+                let ends_file = at + old.len() == lines.len();
+                if !(last && ends_file && hunk.no_newline_at_end) {
+                    replacement.push_str(newline);
+                }
+            }
+            // Appending after a final line that has no newline
+            let start = line_offsets[at];
+            if at == lines.len() && at > 0 && !source.ends_with('\n') && !replacement.is_empty() {
+                replacement.insert_str(0, newline);
+            }
+            edits.push(TextEdit {
+                start,
+                end: line_offsets[at + old.len()],
+                replacement,
+            });
+            drift = at as isize - header_at as isize;
+            applied.push(AppliedHunk {
+                line: at + 1,
+                offset: drift,
+            });
+            earliest = at + old.len();
+        }
+        Ok((edits, applied))
+    }
+}
+
+/// Git's object id for a blob holding `content`: the SHA-1 of a
+/// `blob <size>` header, a NUL byte and the content.
+pub fn git_blob_id(content: &str) -> String {
+    let mut hasher = Sha1::new();
+    hasher.update(format!("blob {}\0", content.len()));
+    hasher.update(content);
+    format!("{:x}", hasher.finalize())
+}
+
+/// Largest number of cells in the table of one changed run before the run
+/// is shown as removed and re-added whole instead of diffed line by line.
+const MAX_DIFF_CELLS: usize = 4_000_000;
+
+/// A line of a diff being written: its marker and text with its newline.
+type HunkLineRef<'a> = (char, &'a str);
+
+/// The lines of `old` and `new` as kept, removed or added, keeping each
+/// line's newline. Lines common to both ends are matched first; the run
+/// between them is aligned by its longest common subsequence.
+fn diff_lines<'a>(old: &'a str, new: &'a str) -> Vec<HunkLineRef<'a>> {
+    let old: Vec<&str> = old.split_inclusive('\n').collect();
+    let new: Vec<&str> = new.split_inclusive('\n').collect();
+    let prefix = old.iter().zip(&new).take_while(|(a, b)| a == b).count();
+    let suffix = old[prefix..]
+        .iter()
+        .rev()
+        .zip(new[prefix..].iter().rev())
+        .take_while(|(a, b)| a == b)
+        .count();
+    let (old_run, new_run) = (
+        &old[prefix..old.len() - suffix],
+        &new[prefix..new.len() - suffix],
+    );
+
+    let mut lines: Vec<HunkLineRef> = old[..prefix].iter().map(|l| (' ', *l)).collect();
+    if (old_run.len() + 1) * (new_run.len() + 1) > MAX_DIFF_CELLS {
+        lines.extend(old_run.iter().map(|l| ('-', *l)));
+        lines.extend(new_run.iter().map(|l| ('+', *l)));
+    } else {
+        // lengths[i][j]: longest common subsequence of old_run[i..], new_run[j..]
+        let width = new_run.len() + 1;
+        let mut lengths = vec![0u32; (old_run.len() + 1) * width];
+        for i in (0..old_run.len()).rev() {
+            for j in (0..new_run.len()).rev() {
+                lengths[i * width + j] = if old_run[i] == new_run[j] {
+                    lengths[(i + 1) * width + j + 1] + 1
+                } else {
+                    lengths[(i + 1) * width + j].max(lengths[i * width + j + 1])
+                };
+            }
+        }
+        let (mut i, mut j) = (0, 0);
+        while i < old_run.len() || j < new_run.len() {
+            if i < old_run.len() && j < new_run.len() && old_run[i] == new_run[j] {
+                lines.push((' ', old_run[i]));
+                i += 1;
+                j += 1;
+            } else if j == new_run.len()
+                || (i < old_run.len() && lengths[(i + 1) * width + j] >= lengths[i * width + j + 1])
+            {
+                lines.push(('-', old_run[i]));
+                i += 1;
+            } else {
+                lines.push(('+', new_run[j]));
+                j += 1;
+            }
+        }
+    }
+    lines.extend(old[old.len() - suffix..].iter().map(|l| (' ', *l)));
+    lines
+}
+
+/// `start[,count]` for a hunk header, the way git writes it: the count is
+/// left out when it is 1, and an empty side starts at the line before it.
+fn format_range(first: usize, count: usize) -> String {
+    match count {
+        0 => format!("{},0", first),
+        1 => format!("{}", first + 1),
+        _ => format!("{},{}", first + 1, count),
+    }
+}
+
+/// A `git diff` of one file that `git apply` accepts: the `diff --git`
+/// header, the blob ids of both versions in the `index` line, and hunks
+/// with `context` lines around each change. `old` is `None` for a new
+/// file, and `mode` is its git file mode, e.g. `100644`. Each hunk header
+/// ends with what `section` gives for the old line its first change is at,
+/// the way git names the enclosing function there. `None` if the contents
+/// are the same.
+pub fn format_git_patch(
+    path: &str,
+    old: Option<&str>,
+    new: &str,
+    mode: &str,
+    context: usize,
+    section: impl Fn(usize) -> Option<String>,
+) -> Option<String> {
+    if old == Some(new) {
+        return None;
+    }
+    let mut patch = format!("diff --git a/{path} b/{path}\n");
+    match old {
+        Some(old) => {
+            patch.push_str(&format!(
+                "index {}..{} {mode}\n--- a/{path}\n+++ b/{path}\n",
+                git_blob_id(old),
+                git_blob_id(new)
+            ));
+        }
+        None => {
+            patch.push_str(&format!(
+                "new file mode {mode}\nindex {}..{}\n--- /dev/null\n+++ b/{path}\n",
+                "0".repeat(40),
+                git_blob_id(new)
+            ));
+        }
+    }
+
+    let lines = diff_lines(old.unwrap_or(""), new);
+    let changes: Vec<usize> = (0..lines.len()).filter(|&i| lines[i].0 != ' ').collect();
+    // Changes closer than twice the context share a hunk
+    let mut groups: Vec<(usize, usize)> = Vec::new();
+    for &change in &changes {
+        match groups.last_mut() {
+            Some((_, last)) if change - *last <= 2 * context + 1 => *last = change,
+            _ => groups.push((change, change)),
+        }
+    }
+    // Line numbers on each side before each diff line
+    let mut numbers = Vec::with_capacity(lines.len() + 1);
+    let (mut old_line, mut new_line) = (0, 0);
+    for (marker, _) in &lines {
+        numbers.push((old_line, new_line));
+        match marker {
+            '-' => old_line += 1,
+            '+' => new_line += 1,
+            _ => {
+                old_line += 1;
+                new_line += 1;
+            }
+        }
+    }
+    for (first, last) in groups {
+        let start = first.saturating_sub(context);
+        let end = (last + context + 1).min(lines.len());
+        let hunk = &lines[start..end];
+        let old_count = hunk.iter().filter(|(marker, _)| *marker != '+').count();
+        let new_count = hunk.iter().filter(|(marker, _)| *marker != '-').count();
+        let (old_first, new_first) = numbers[start];
+        // An added line is looked up at the old line it follows
+        let changed_line = match lines[first].0 {
+            '-' => numbers[first].0 + 1,
+            _ => numbers[first].0.max(1),
+        };
+        let heading = section(changed_line)
+            .map(|heading| format!(" {heading}"))
+            .unwrap_or_default();
+        patch.push_str(&format!(
+            "@@ -{} +{} @@{}\n",
+            format_range(old_first, old_count),
+            format_range(new_first, new_count),
+            heading
+        ));
+        for (marker, text) in hunk {
+            patch.push(*marker);
+            match text.strip_suffix('\n') {
+                Some(text) => {
+                    patch.push_str(text);
+                    patch.push('\n');
+                }
+                None => {
+                    patch.push_str(text);
+                    patch.push_str("\n\\ No newline at end of file\n");
+                }
+            }
+        }
+    }
+    Some(patch)
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+    use crate::edit_utils::apply_edits;
+
+    fn apply(diff: &str, source: &str) -> Result<String> {
+        let patches = parse(diff)?;
+        let (edits, _) = patches[0].edits(source)?;
+        apply_edits(source, &edits)
+    }
+
+    const SOURCE: &str =
+        "fn main() {\n    let a = 1;\n    let b = 2;\n    println!(\"{}\", a + b);\n}\n";
+
+    #[test]
+    fn test_apply_hunk() {
+        let diff = "diff --git a/src/main.rs b/src/main.rs\n--- a/src/main.rs\n+++ b/src/main.rs\n@@ -2,3 +2,3 @@ fn main() {\n     let a = 1;\n-    let b = 2;\n+    let b = 3;\n     println!(\"{}\", a + b);\n";
+        let patches = parse(diff).unwrap();
+        assert_eq!(patches[0].path(), Some("src/main.rs"));
+        assert_eq!(
+            apply(diff, SOURCE).unwrap(),
+            SOURCE.replace("let b = 2", "let b = 3")
+        );
+    }
+
+    #[test]
+    fn test_hunk_found_at_offset() {
+        let shifted = format!("// header\n\n{SOURCE}");
+        let diff = "--- main.rs\n+++ main.rs\n@@ -3,1 +3,2 @@\n     let b = 2;\n+    let c = 4;\n";
+        let patches = parse(diff).unwrap();
+        let (edits, applied) = patches[0].edits(&shifted).unwrap();
+        assert_eq!(applied[0], AppliedHunk { line: 5, offset: 2 });
+        assert!(apply_edits(&shifted, &edits)
+            .unwrap()
+            .contains("let b = 2;\n    let c = 4;\n"));
+    }
+
+    #[test]
+    fn test_hunk_too_far_from_its_header() {
+        let shifted = format!("{}{SOURCE}", "//\n".repeat(MAX_HUNK_OFFSET + 1));
+        let diff = "--- main.rs\n+++ main.rs\n@@ -3,1 +3,2 @@\n     let b = 2;\n+    let c = 4;\n";
+        let patches = parse(diff).unwrap();
+        let error = patches[0].edits(&shifted).unwrap_err().to_string();
+        assert!(error.starts_with("Hunk #1 FAILED at 3"), "{}", error);
+
+        let shifted = format!("{}{SOURCE}", "//\n".repeat(MAX_HUNK_OFFSET - 1));
+        let (_, applied) = patches[0].edits(&shifted).unwrap();
+        assert_eq!(applied[0].offset, MAX_HUNK_OFFSET as isize - 1);
+    }
+
+    #[test]
+    fn test_context_conflict() {
+        let diff = "--- main.rs\n+++ main.rs\n@@ -2,2 +2,2 @@\n     let a = 1;\n-    let b = 5;\n+    let b = 6;\n";
+        let error = apply(diff, SOURCE).unwrap_err().to_string();
+        assert!(error.starts_with("Hunk #1 FAILED at 2"), "{}", error);
+        assert!(
+            error.contains("expected line 3 to be \"    let b = 5;\""),
+            "{}",
+            error
+        );
+    }
+
+    #[test]
+    fn test_line_with_a_multibyte_marker() {
+        let diff = "--- main.rs\n+++ main.rs\n@@ -2,1 +2,1 @@\né    let a = 1;\n";
+        let error = parse(diff).unwrap_err().to_string();
+        assert!(error.contains("does not fit the hunk header"), "{}", error);
+    }
+
+    #[test]
+    fn test_new_file_without_trailing_newline() {
+        let diff = "--- /dev/null\n+++ b/notes.txt\n@@ -0,0 +1,2 @@\n+first\n+second\n\\ No newline at end of file\n";
+        let patches = parse(diff).unwrap();
+        assert_eq!(patches[0].old_path, None);
+        assert_eq!(apply(diff, "").unwrap(), "first\nsecond");
+    }
+
+    #[test]
+    fn test_git_blob_id() {
+        // `printf 'hello\n' | git hash-object --stdin`
+        assert_eq!(
+            git_blob_id("hello\n"),
+            "ce013625030ba8dba906f756967f9e9ca394464a"
+        );
+    }
+
+    #[test]
+    fn test_format_git_patch_round_trip() {
+        let new = SOURCE
+            .replace("let a = 1", "let a = 10")
+            .replace("}\n", "}");
+        let patch =
+            format_git_patch("src/main.rs", Some(SOURCE), &new, "100644", 0, |_| None).unwrap();
+        assert!(patch.starts_with("diff --git a/src/main.rs b/src/main.rs\nindex "));
+        assert!(patch.contains("@@ -2 +2 @@\n-    let a = 1;\n+    let a = 10;\n@@ -5 +5 @@\n"));
+        assert!(patch.ends_with("-}\n+}\n\\ No newline at end of file\n"));
+        assert_eq!(apply(&patch, SOURCE).unwrap(), new);
+        assert_eq!(
+            format_git_patch("src/main.rs", Some(SOURCE), SOURCE, "100644", 3, |_| None),
+            None
+        );
+
+        let created =
+            format_git_patch("notes.txt", None, "first\n", "100644", 3, |_| None).unwrap();
+        assert!(created
+            .contains("new file mode 100644\nindex 0000000000000000000000000000000000000000.."));
+        assert!(created.ends_with("--- /dev/null\n+++ b/notes.txt\n@@ -0,0 +1 @@\n+first\n"));
+        assert_eq!(apply(&created, "").unwrap(), "first\n");
+    }
+
+    #[test]
+    fn test_format_git_patch_sections() {
+        let new = SOURCE.replace("let b = 2", "let b = 3");
+        let section = |line: usize| (line <= 5).then(|| format!("fn main() {{ // line {line}"));
+        let patch =
+            format_git_patch("src/main.rs", Some(SOURCE), &new, "100644", 1, section).unwrap();
+        assert!(patch.contains("\n@@ -2,3 +2,3 @@ fn main() { // line 3\n     let a = 1;\n"));
+
+        // An added line is looked up at the line it follows
+        let added = SOURCE.replace("}\n", "}\n\nfn other() {}\n");
+        let patch = format_git_patch("src/main.rs", Some(SOURCE), &added, "100644", 0, |line| {
+            Some(format!("line {line}"))
+        })
+        .unwrap();
+        assert!(patch.contains("\n@@ -5,0 +6,2 @@ line 5\n"), "{}", patch);
+    }
+}
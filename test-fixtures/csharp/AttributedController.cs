@@ -0,0 +1,65 @@
+using System;
+using System.Collections.Generic;
+using System.Threading.Tasks;
+
+namespace TestFixtures.Api
+{
+    using Microsoft.AspNetCore.Mvc;
+
+    // Attributed class with attributed members
+    [ApiController]
+    [Route("api/[controller]")]
+    public class OrdersController : ControllerBase
+    {
+        private readonly List<string> _orders = new List<string>();
+
+        [HttpGet]
+        public IEnumerable<string> GetAll()
+        {
+            return _orders;
+        }
+
+        [HttpGet("{id}")]
+        [ProducesResponseType(200)]
+        [ProducesResponseType(404)]
+        public ActionResult<string> GetById(int id)
+        {
+            if (id < 0 || id >= _orders.Count)
+            {
+                return NotFound();
+            }
+            return _orders[id];
+        }
+
+        [HttpPost]
+        public async Task<IActionResult> Create([FromBody] string order)
+        {
+            await Task.Delay(1);
+            _orders.Add(order);
+            return Ok();
+        }
+
+        [Obsolete("Use GetAll instead")]
+        public int Count() => _orders.Count;
+    }
+
+    public interface IOrderStore
+    {
+        void Save(string order);
+    }
+}
+
+namespace TestFixtures.Domain
+{
+    [Serializable]
+    public class Order
+    {
+        public int Id { get; set; }
+        public string Name { get; set; }
+
+        public override string ToString()
+        {
+            return $"{Id}: {Name}";
+        }
+    }
+}
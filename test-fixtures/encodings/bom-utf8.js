@@ -0,0 +1,6 @@
+﻿import { readFile } from 'fs';
+
+// Greeting shown on the start page
+export function greet(name) {
+  return `Héllo, ${name}`;
+}
@@ -0,0 +1,8 @@
+class Cart {
+  total() {
+    return this.items.length;
+  }
+}
+
+class Wishlist {
+}
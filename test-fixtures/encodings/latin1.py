@@ -0,0 +1,3 @@
+# Gru an die Benutzer
+def greet(name):
+    return "Gr dich, " + name
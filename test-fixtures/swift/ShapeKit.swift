@@ -0,0 +1,46 @@
+import Foundation
+import SwiftUI
+
+/// Anything with an area.
+protocol Shape {
+    var name: String { get }
+    func area() -> Double
+}
+
+/// An axis-aligned rectangle.
+struct Rectangle: Shape, Equatable {
+    let width: Double
+    let height: Double
+
+    var name: String { "rectangle" }
+
+    func area() -> Double {
+        width * height
+    }
+}
+
+extension Rectangle {
+    static let unit = Rectangle(width: 1, height: 1)
+}
+
+@objc
+class ShapeStore: NSObject {
+    @Published var shapes: [Shape] = []
+    @objc dynamic var selectedIndex: Int = 0
+
+    @objc func totalArea() -> Double {
+        shapes.map { $0.area() }.reduce(0, +)
+    }
+
+    func largest(completion: @escaping (Shape?) -> Void) {
+        DispatchQueue.main.async {
+            let sorted = self.shapes.sorted { $0.area() > $1.area() }
+            completion(sorted.first)
+        }
+    }
+}
+
+enum Unit {
+    case points
+    case pixels(scale: Double)
+}
@@ -0,0 +1,84 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CODE: &str = r#"// BEGIN generated
+function a() {}
+
+// BEGIN generated
+
+function b() {}
+
+// BEGIN generated
+
+
+function c() {}
+// unrelated
+function d() {}
+"#;
+
+/// Names of the functions found after a marker comment.
+fn function_names(output: &str) -> Result<Vec<String>> {
+    let parsed: Value = serde_json::from_str(output)?;
+    Ok(parsed["matches"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .filter_map(|m| m["node"]["text"].as_str()?.strip_prefix("function "))
+        .map(|rest| rest.split('(').next().unwrap().to_string())
+        .collect())
+}
+
+#[tokio::test]
+async fn test_after_comment_blank_line_tolerance() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "after_comment",
+            json!({"code": CODE, "language": "javascript", "regex": "BEGIN ("}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Invalid regex"));
+
+    let result = tools
+        .call_tool(
+            "after_comment",
+            json!({"code": CODE, "language": "javascript", "regex": "BEGIN generated"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            assert_eq!(function_names(&output)?, ["a", "b"]);
+
+            let output = tools
+                .call_tool(
+                    "after_comment",
+                    json!({
+                        "code": CODE,
+                        "language": "javascript",
+                        "regex": "BEGIN generated",
+                        "max_blank_lines": 0
+                    }),
+                )
+                .await?;
+            assert_eq!(function_names(&output)?, ["a"]);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,131 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::git_blame;
+use std::path::Path;
+use std::process::Command;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n";
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+/// Commit `main.go` in a new repository, or `false` if git is unavailable.
+fn commit_file(dir: &Path) -> Result<bool> {
+    std::fs::write(dir.join("main.go"), SOURCE)?;
+    let git = |args: &[&str]| {
+        Command::new("git")
+            .arg("-C")
+            .arg(dir)
+            .args(["-c", "user.name=Ada", "-c", "user.email=ada@example.com"])
+            .args(args)
+            .output()
+            .map(|output| output.status.success())
+            .unwrap_or(false)
+    };
+    Ok(
+        git(&["init", "-q"])
+            && git(&["add", "main.go"])
+            && git(&["commit", "-q", "-m", "Add main"]),
+    )
+}
+
+#[tokio::test]
+async fn test_blame_lines_reports_last_commit() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    if !commit_file(temp_dir.path())? {
+        println!("⚠️  git not available, skipping blame test");
+        return Ok(());
+    }
+    let file = temp_dir.path().join("main.go");
+
+    let commits = git_blame::blame_lines(&file, &[(3, 5)]).await.unwrap();
+    assert_eq!(commits.len(), 3);
+    let commit = git_blame::latest(&commits, 3..=5).unwrap();
+    assert_eq!(commit.author, "Ada");
+    assert_eq!(commit.summary, "Add main");
+    assert!(!commit.is_uncommitted());
+
+    // Changed lines are blamed on no commit yet
+    std::fs::write(&file, SOURCE.replace("hi", "hello"))?;
+    let commits = git_blame::blame_lines(&file, &[(3, 5)]).await.unwrap();
+    assert!(commits[&4].is_uncommitted());
+    assert!(!commits[&3].is_uncommitted());
+
+    // Outside a repository there is nothing to report
+    let untracked = tempfile::tempdir()?;
+    let other = untracked.path().join("main.go");
+    std::fs::write(&other, SOURCE)?;
+    assert!(git_blame::blame_lines(&other, &[(1, 1)]).await.is_none());
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_include_blame() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    if !commit_file(temp_dir.path())? {
+        println!("⚠️  git not available, skipping blame test");
+        return Ok(());
+    }
+    let tools = create_tools(temp_dir.path());
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: prints\nlanguage: go\nrule:\n  pattern: println($$$)\n",
+                "target": temp_dir.path().display().to_string(),
+                "includeBlame": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed[0]["blame"]["author"], "Ada");
+            assert_eq!(parsed[0]["blame"]["summary"], "Add main");
+            assert!(parsed[0]["blame"].get("uncommitted").is_none());
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: prints\nlanguage: go\nrule:\n  pattern: println($$$)\n",
+                "target": temp_dir.path().display().to_string(),
+                "operation": "replace",
+                "includeBlame": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("includeBlame only applies"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
@@ -0,0 +1,71 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::json;
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+/// Replace the match of `rule_config` in a file holding `source` and
+/// return the file afterwards, or `None` without ast-grep.
+async fn replace_in_file(source: &str, rule_config: &str) -> Result<Option<String>> {
+    let temp_dir = tempfile::tempdir()?;
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    let file = temp_dir.path().join("lib.rs");
+    tokio::fs::write(&file, source).await?;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": rule_config,
+                "target": "lib.rs",
+                "operation": "replace",
+                "dry_run": false
+            }),
+        )
+        .await;
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            Ok(Some(tokio::fs::read_to_string(&file).await?))
+        }
+        // If ast-grep binary is not available, this is expected
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+            Ok(None)
+        }
+        Err(e) => Err(e),
+    }
+}
+
+#[tokio::test]
+async fn test_top_level_replace_keeps_blank_lines() -> Result<()> {
+    let source = "fn first() {}\n\nfn old() {}\n\nfn last() {}\n";
+    // A block scalar fix ends with a newline of its own
+    let rule_config =
+        "id: rename\nlanguage: rust\nrule:\n  pattern: fn old() {}\nfix: |\n  fn new() {}\n";
+
+    if let Some(written) = replace_in_file(source, rule_config).await? {
+        assert_eq!(written, "fn first() {}\n\nfn new() {}\n\nfn last() {}\n");
+    }
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_nested_replace_keeps_blank_lines() -> Result<()> {
+    let source = "impl S {\n    fn a(&self) {}\n\n    fn old(&self) {}\n}\n";
+    let rule_config = "id: rename\nlanguage: rust\nrule:\n  pattern: fn old(&self) {}\nfix: |\n\n  fn new(&self) {}\n";
+
+    if let Some(written) = replace_in_file(source, rule_config).await? {
+        assert_eq!(
+            written,
+            "impl S {\n    fn a(&self) {}\n\n    fn new(&self) {}\n}\n"
+        );
+    }
+    Ok(())
+}
@@ -0,0 +1,97 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CODE: &str = r#"package main
+
+import "fmt"
+
+func a() {
+	fmt.Println("a")
+}
+
+func b() {
+	if true {
+		fmt.Println("b")
+	}
+}
+
+func c() {
+	fmt.Println("c")
+}
+"#;
+
+const RULE: &str = "id: println\nlanguage: go\nrule:\n  pattern: fmt.Println($$$)\n";
+
+#[tokio::test]
+async fn test_byte_range_search_reports_file_offsets() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("main.go");
+    std::fs::write(&path, CODE)?;
+    let target = path.display().to_string();
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": target,
+                "operation": "replace",
+                "byteRange": {"start": 0, "end": 10}
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error
+        .to_string()
+        .contains("only applies to the search and scan"));
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({"rule_config": RULE, "target": target, "byteRange": {"start": 0, "end": 1000}}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("outside the source"));
+
+    // Just the `if` statement inside b
+    let start = CODE.find("if true").unwrap();
+    let end = CODE.find("\t}\n}").unwrap() + 2;
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({"rule_config": RULE, "target": target, "byteRange": {"start": start, "end": end}}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            let offset = CODE.find("fmt.Println(\"b\")").unwrap();
+            assert_eq!(matches[0]["text"], "fmt.Println(\"b\")");
+            assert_eq!(matches[0]["range"]["byteOffset"]["start"], offset);
+            assert_eq!(
+                matches[0]["range"]["start"],
+                json!({"line": 10, "column": 2})
+            );
+            assert_eq!(matches[0]["file"], target);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
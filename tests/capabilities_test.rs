@@ -0,0 +1,66 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_capabilities_report_languages_and_tools() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Works whether or not the ast-grep binary is installed
+    let output = tools
+        .capabilities(&[(
+            "get_node_text".to_string(),
+            vec!["code".to_string(), "kind".to_string()],
+        )])
+        .await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+
+    assert!(!parsed["version"].as_str().unwrap().is_empty());
+    assert!(parsed["ast_grep"]["available"].is_boolean());
+    let languages = parsed["languages"].as_array().unwrap();
+    let language = |name: &str| {
+        languages
+            .iter()
+            .find(|language| language["name"] == name)
+            .unwrap()
+            .clone()
+    };
+    assert_eq!(language("python")["supported"], true);
+    assert_eq!(
+        language("python")["extensions"],
+        json!(["py", "pyi", "pyw"])
+    );
+    assert_eq!(language("cpp")["aliases"], json!(["c++"]));
+    // Recognized by extension, but not parsed
+    assert_eq!(language("ruby")["supported"], false);
+    assert_eq!(
+        parsed["tools"],
+        json!([{"name": "get_node_text", "options": ["code", "kind"]}])
+    );
+    assert_eq!(parsed["features"]["editable_languages"], json!([]));
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_get_capabilities_tool_reports_the_set_tools() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_tool_options(vec![(
+        "dump_tree".to_string(),
+        vec!["code".to_string(), "language".to_string()],
+    )]);
+
+    let output = tools.call_tool("get_capabilities", json!({})).await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(
+        parsed["tools"],
+        json!([{"name": "dump_tree", "options": ["code", "language"]}])
+    );
+
+    Ok(())
+}
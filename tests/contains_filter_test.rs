@@ -0,0 +1,88 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+func mustOpen(path string) {
+	if path == "" {
+		panic("no path")
+	}
+}
+
+func open(path string) error {
+	return nil
+}
+
+func main() {
+	defer func() {
+		recover()
+	}()
+	mustOpen("a")
+}
+"#;
+
+#[tokio::test]
+async fn test_filter_by_contents() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("main.go");
+    std::fs::write(&path, SOURCE)?;
+    let rule = "id: functions\nlanguage: go\nrule:\n  kind: function_declaration\n";
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": rule,
+                "target": path.display().to_string(),
+                "filter": ":contains(call_expression:matches(/^panic\\(/))"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            // The call is two blocks down, not a direct child
+            assert_eq!(matches.len(), 1);
+            assert!(matches[0]["text"]
+                .as_str()
+                .unwrap()
+                .starts_with("func mustOpen"));
+            assert!(matches[0].get("contains").is_none());
+
+            // A kind alone, inside a nested function literal
+            let output = tools
+                .call_tool(
+                    "execute_rule",
+                    json!({
+                        "rule_config": rule,
+                        "target": path.display().to_string(),
+                        "filter": ":contains(func_literal) :spanning-lines(2)"
+                    }),
+                )
+                .await?;
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            assert!(matches[0]["text"]
+                .as_str()
+                .unwrap()
+                .starts_with("func main"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,114 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CHAIN: &str = "package main\n\nfunc name(code int) string {\n\tif code == 200 { // ok\n\t\treturn \"OK\"\n\t} else if code == 301 || code == 302 {\n\t\treturn \"Moved\"\n\t} else {\n\t\treturn \"Unknown\"\n\t}\n}\n";
+
+const SWITCH: &str = "package main\n\nfunc name(code int) string {\n\tswitch code {\n\tcase 200: // ok\n\t\treturn \"OK\"\n\tcase 301, 302:\n\t\treturn \"Moved\"\n\tdefault:\n\t\treturn \"Unknown\"\n\t}\n}\n";
+
+fn tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+#[tokio::test]
+async fn test_if_chain_and_switch_convert_both_ways() -> Result<()> {
+    let tools = tools();
+
+    let result = tools
+        .call_tool(
+            "convert_switch",
+            json!({
+                "code": CHAIN,
+                "language": "go",
+                "position": {"line": 6, "column": 12}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["direction"], "to_switch");
+            assert_eq!(parsed["subject"], "code");
+            assert_eq!(parsed["branches"], 3);
+            assert_eq!(parsed["line"], 4);
+            assert_eq!(parsed["applied"], false);
+            assert_eq!(parsed["content"], SWITCH);
+
+            let output = tools
+                .call_tool(
+                    "convert_switch",
+                    json!({
+                        "code": SWITCH,
+                        "language": "go",
+                        "position": {"line": 7, "column": 2},
+                        "direction": "to_if"
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["direction"], "to_if");
+            assert_eq!(parsed["content"], CHAIN);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_convert_switch_refuses_changes_in_meaning() -> Result<()> {
+    let tools = tools();
+
+    let mixed = "package main\n\nfunc f(a, b int) {\n\tif a == 1 {\n\t\tg()\n\t} else if b == 2 {\n\t\th()\n\t}\n}\n";
+    let looped = "package main\n\nfunc f(xs []int) {\n\tfor _, x := range xs {\n\t\tif x == 1 {\n\t\t\tbreak\n\t\t} else if x == 2 {\n\t\t\tg()\n\t\t}\n\t}\n}\n";
+    let falls = "package main\n\nfunc f(x int) {\n\tswitch x {\n\tcase 1:\n\t\tg()\n\t\tfallthrough\n\tcase 2:\n\t\th()\n\t}\n}\n";
+    let cases = [
+        (mixed, 5, "does not compare a"),
+        (looped, 6, "would leave the switch"),
+        (falls, 6, "fallthrough on line 7"),
+    ];
+
+    for (code, line, expected) in cases {
+        let result = tools
+            .call_tool(
+                "convert_switch",
+                json!({
+                    "code": code,
+                    "language": "go",
+                    "position": {"line": line, "column": 3}
+                }),
+            )
+            .await;
+        match result {
+            Ok(output) => panic!("Expected an error, got {}", output),
+            Err(e) if e.to_string().contains("ast-grep") => {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+                break;
+            }
+            Err(e) => assert!(e.to_string().contains(expected), "{}", e),
+        }
+    }
+
+    let error = tools
+        .call_tool(
+            "convert_switch",
+            json!({"code": CHAIN, "language": "go", "direction": "sideways"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Unknown direction"), "{}", error);
+
+    Ok(())
+}
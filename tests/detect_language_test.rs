@@ -0,0 +1,57 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+async fn detect(tools: &AstGrepTools, args: Value) -> Result<Value> {
+    let output = tools.call_tool("detect_language", args).await?;
+    Ok(serde_json::from_str(&output)?)
+}
+
+#[tokio::test]
+async fn test_detect_language_methods() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = detect(&tools, json!({"path": "src/Controllers/Home.cs"})).await?;
+    assert_eq!(result["language"], "csharp");
+    assert_eq!(result["method"], "extension");
+    assert_eq!(result["supported"], true);
+
+    let result = detect(
+        &tools,
+        json!({"path": "bin/deploy", "content": "#!/usr/bin/env python3\nprint('hi')\n"}),
+    )
+    .await?;
+    assert_eq!(result["language"], "python");
+    assert_eq!(result["method"], "shebang");
+
+    let result = detect(&tools, json!({"path": "script.txt", "language": "Rust"})).await?;
+    assert_eq!(result["language"], "rust");
+    assert_eq!(result["method"], "override");
+    assert_eq!(result["supported"], true);
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_detect_language_reports_unsupported() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // A known language the server cannot parse is named, not just rejected
+    let result = detect(&tools, json!({"path": "app/models/user.rb"})).await?;
+    assert_eq!(result["language"], "ruby");
+    assert_eq!(result["supported"], false);
+    assert!(result["message"]
+        .as_str()
+        .unwrap()
+        .contains("ruby is not supported"));
+
+    let result = detect(&tools, json!({"path": "README", "content": "hello\n"})).await?;
+    assert!(result["language"].is_null());
+    assert_eq!(result["supported"], false);
+
+    Ok(())
+}
@@ -0,0 +1,91 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const HEADER: &str = r#"#pragma once
+
+class Widget {
+public:
+    int size() const;
+};
+"#;
+
+#[tokio::test]
+async fn test_dialect_overrides_grammar() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("widget.h");
+    std::fs::write(&path, HEADER)?;
+
+    // The file is still C by its extension; the dialect is what it parses as
+    let output = tools
+        .call_tool(
+            "detect_language",
+            json!({"path": path.display().to_string(), "dialect": "c++"}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["language"], "c");
+    assert_eq!(parsed["dialect"], "cpp");
+
+    let error = tools
+        .call_tool(
+            "detect_language",
+            json!({"path": "main.go", "dialect": "tsx"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("The tsx dialect only applies to javascript, typescript files, not go"),
+        "{}",
+        error
+    );
+    let error = tools
+        .call_tool(
+            "detect_language",
+            json!({"path": "main.py", "dialect": "python4"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Unknown dialect"), "{}", error);
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: classes\nlanguage: c\nrule:\n  kind: class_specifier\n",
+                "target": path.display().to_string(),
+                "dialect": "cpp"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            // C has no classes, so only the C++ grammar finds one
+            assert_eq!(matches.len(), 1);
+            assert!(matches[0]["text"]
+                .as_str()
+                .unwrap()
+                .starts_with("class Widget"));
+            assert_eq!(matches[0]["dialect"], "cpp");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,146 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CODE: &str = "package main\n\nfunc a() {\n\tx := 1\n\tfmt.Println(x)\n}\n\nfunc b() {}\n";
+
+fn create_tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+#[tokio::test]
+async fn test_dump_tree() -> Result<()> {
+    let tools = create_tools();
+    let result = tools
+        .call_tool("dump_tree", json!({"code": CODE, "language": "go"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let kinds: Vec<&str> = parsed["nodes"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|node| node["kind"].as_str().unwrap())
+                .collect();
+            assert_eq!(
+                kinds,
+                [
+                    "package_clause",
+                    "function_declaration",
+                    "function_declaration"
+                ]
+            );
+            assert_eq!(parsed["nodes"][1]["lines"], json!([3, 6]));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_dump_tree_of_included_kinds() -> Result<()> {
+    let tools = create_tools();
+    let result = tools
+        .call_tool(
+            "dump_tree",
+            json!({
+                "code": CODE,
+                "language": "go",
+                "includeTypes": ["call_expression"],
+                "excludeTypes": ["function_declaration"]
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let nodes = parsed["nodes"].as_array().unwrap();
+            // Only a's call is left, with the excluded function on its path
+            assert_eq!(nodes.len(), 1);
+            assert_eq!(nodes[0]["kind"], "function_declaration");
+            assert_eq!(nodes[0]["lines"], json!([3, 6]));
+            let dump = serde_json::to_string(nodes)?;
+            assert!(!dump.contains("package_clause"), "{}", dump);
+            assert!(!dump.contains("short_var_declaration"), "{}", dump);
+            assert!(dump.contains("\"text\":\"fmt.Println(x)\""), "{}", dump);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_dump_tree_within_budget() -> Result<()> {
+    let tools = create_tools();
+    let result = tools
+        .call_tool(
+            "dump_tree",
+            json!({"code": CODE, "language": "go", "maxTotalBytes": 400}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let nodes = &parsed["nodes"];
+            assert!(serde_json::to_string(nodes)?.len() <= 400);
+            assert_eq!(parsed["truncated"], false);
+            // Both functions are shown before anything inside either
+            let kinds: Vec<&str> = nodes
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|node| node["kind"].as_str().unwrap())
+                .collect();
+            assert_eq!(
+                kinds,
+                [
+                    "package_clause",
+                    "function_declaration",
+                    "function_declaration"
+                ]
+            );
+            assert_eq!(nodes[1]["id"], "/1");
+            assert!(nodes
+                .as_array()
+                .unwrap()
+                .iter()
+                .any(|node| node["truncated"] == true));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
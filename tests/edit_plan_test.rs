@@ -0,0 +1,305 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::edit_plan::{EditPlan, FilePlan};
+use splice_weaver_mcp::edit_utils::TextEdit;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::sync::Arc;
+
+fn create_tools(root_path: &std::path::Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_apply_plan_and_refuse_stale_plan() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+
+    let source = "var a = 1;\nvar b = 2;\n";
+    let test_file = temp_dir.path().join("vars.js");
+    tokio::fs::write(&test_file, source).await?;
+
+    // Plans are normally written by compute_edit_plan; build one by hand so
+    // applying is tested without ast-grep
+    let edits = ["var a", "var b"]
+        .iter()
+        .map(|needle| {
+            let start = source.find(needle).unwrap();
+            TextEdit {
+                start,
+                end: start + 3,
+                replacement: "let".to_string(),
+            }
+        })
+        .collect();
+    let plan = EditPlan::new(vec![FilePlan::new(
+        test_file.display().to_string(),
+        source,
+        edits,
+    )]);
+    tokio::fs::write(temp_dir.path().join("plan.json"), plan.to_json()?).await?;
+
+    let preview = tools
+        .call_tool("apply_edits_from_file", json!({"plan_path": "plan.json"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&preview)?;
+    assert_eq!(parsed["applied"], false);
+    assert_eq!(parsed["files"][0]["edits"], 2);
+    assert_eq!(tokio::fs::read_to_string(&test_file).await?, source);
+
+    let output = tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": "plan.json", "dry_run": false}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["status"], "completed");
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        "let a = 1;\nlet b = 2;\n"
+    );
+
+    // The file no longer matches the plan's hash, so applying again is refused
+    let error = tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": "plan.json", "dry_run": false}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Edit plan is stale"));
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        "let a = 1;\nlet b = 2;\n"
+    );
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_apply_plan_keeps_byte_order_mark() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+
+    // The plan holds offsets into the text without its byte order mark
+    let source = "var a = 1;\n";
+    let test_file = temp_dir.path().join("bom.js");
+    tokio::fs::write(&test_file, format!("\u{feff}{source}")).await?;
+    let plan = EditPlan::new(vec![FilePlan::new(
+        test_file.display().to_string(),
+        source,
+        vec![TextEdit {
+            start: 0,
+            end: 3,
+            replacement: "let".to_string(),
+        }],
+    )]);
+    tokio::fs::write(temp_dir.path().join("plan.json"), plan.to_json()?).await?;
+
+    let output = tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": "plan.json", "dry_run": false}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["files"][0]["status"], Value::Null);
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        "\u{feff}let a = 1;\n"
+    );
+    let log: Value = serde_json::from_str(&tools.call_tool("get_session_log", json!({})).await?)?;
+    assert_eq!(log["entries"].as_array().unwrap().len(), 1);
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_compute_then_apply_plan() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+
+    let test_file = temp_dir.path().join("vars.js");
+    tokio::fs::write(&test_file, "var a = 1;\nvar b = 2;\n").await?;
+
+    let rule_config = r#"
+id: var-to-let
+language: javascript
+rule:
+  pattern: var $NAME = $VALUE
+fix: let $NAME = $VALUE
+"#;
+
+    let result = tools
+        .call_tool(
+            "compute_edit_plan",
+            json!({
+                "rule_config": rule_config,
+                "target": "vars.js",
+                "plan_path": "var-to-let.json"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["edits"], 2);
+
+            tools
+                .call_tool(
+                    "apply_edits_from_file",
+                    json!({"plan_path": "var-to-let.json", "dry_run": false}),
+                )
+                .await?;
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert_eq!(content, "let a = 1;\nlet b = 2;\n");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_editable_languages_refuses_other_files() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    tools.set_config(ServerConfig::from_yaml(
+        "protection:\n  editable_languages: [go]\n",
+    )?);
+
+    let source = "#!/bin/sh\necho hi\n";
+    let script = temp_dir.path().join("deploy");
+    tokio::fs::write(&script, source).await?;
+    let edit = TextEdit {
+        start: source.find("hi").unwrap(),
+        end: source.find("hi").unwrap() + 2,
+        replacement: "bye".to_string(),
+    };
+    let plan = EditPlan::new(vec![FilePlan::new(
+        script.display().to_string(),
+        source,
+        vec![edit],
+    )]);
+    tokio::fs::write(temp_dir.path().join("plan.json"), plan.to_json()?).await?;
+
+    // The language comes from the shebang when there is no extension
+    let error = tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": "plan.json", "dry_run": false}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("editing is disabled for bash files"),
+        "{}",
+        error
+    );
+    assert_eq!(tokio::fs::read_to_string(&script).await?, source);
+
+    // Tools that only parse the file are refused the same way
+    tokio::fs::write(temp_dir.path().join("setup.py"), "import os\n").await?;
+    let error = tools
+        .call_tool(
+            "file_outline",
+            json!({"target": "setup.py", "language": "python"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("editing is disabled for python files"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_editable_languages_filters_directory_walks() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    tools.set_config(ServerConfig::from_yaml(
+        "protection:\n  editable_languages: [go]\n",
+    )?);
+    tokio::fs::create_dir(temp_dir.path().join("src")).await?;
+    tokio::fs::write(
+        temp_dir.path().join("src/main.go"),
+        "package main\n\nfunc main() {\n\tprintln(1)\n}\n",
+    )
+    .await?;
+    tokio::fs::write(temp_dir.path().join("src/tool.js"), "console.log(1);\n").await?;
+
+    // Inline code is held to the allowlist by its language
+    let error = tools
+        .call_tool(
+            "dump_tree",
+            json!({"code": "import os\n", "language": "python"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("editing is disabled for python files"),
+        "{}",
+        error
+    );
+
+    let search = |language: &str, pattern: &str| {
+        json!({
+            "rule_config": format!("id: calls\nlanguage: {language}\nrule:\n  pattern: {pattern}\n"),
+            "target": "src",
+        })
+    };
+    let result = tools
+        .call_tool("execute_rule", search("go", "println($$$)"))
+        .await;
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed.as_array().unwrap().len(), 1);
+
+            // The directory is walked, but its JavaScript file is left out
+            let output = tools
+                .call_tool("execute_rule", search("javascript", "console.log($$$)"))
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed, json!([]));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
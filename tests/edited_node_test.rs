@@ -0,0 +1,63 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str =
+    "package main\n\nimport \"fmt\"\n\nfunc wait() {\n\tfmt.Println(\"waiting\")\n}\n";
+
+#[tokio::test]
+async fn test_return_edited_node() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let path = temp_dir.path().join("main.go");
+    std::fs::write(&path, SOURCE)?;
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    let replace = |dry_run: bool| {
+        tools.call_tool(
+            "replace_node",
+            json!({
+                "target": path.display().to_string(),
+                "language": "go",
+                "position": {"line": 6, "column": 2},
+                "replacement": "func wait() {\n\tfmt.Println(\"waited\")\n}",
+                "dry_run": dry_run,
+                "returnEditedNode": true,
+            }),
+        )
+    };
+
+    match replace(true).await {
+        Ok(output) => {
+            println!("Output: {}", output);
+            // A preview writes nothing, so there is no edited node
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["edited_nodes"], json!([]));
+
+            let parsed: Value = serde_json::from_str(&replace(false).await?)?;
+            assert_eq!(parsed["applied"], true);
+            let nodes = parsed["edited_nodes"].as_array().unwrap();
+            assert_eq!(nodes.len(), 1);
+            // Only the changed line was rewritten, and its node is reported
+            assert_eq!(nodes[0]["text"], "fmt.Println(\"waited\")");
+            assert_eq!(nodes[0]["file"], path.display().to_string());
+            assert_eq!(nodes[0]["range"]["start"]["line"], 5);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
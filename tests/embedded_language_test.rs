@@ -0,0 +1,102 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const PAGE: &str = "<!doctype html>\n<html>\n<body>\n<script>\nconsole.log(\"ready\");\n</script>\n</body>\n</html>\n";
+
+#[tokio::test]
+async fn test_embedded_region_in_html() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Locating a <script> element needs no parser, so this runs without ast-grep
+    let output = tools
+        .call_tool(
+            "parse_embedded",
+            json!({
+                "code": PAGE,
+                "language": "html",
+                "embedded_language": "javascript",
+                "position": {"line": 5, "column": 1}
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["code"], "\nconsole.log(\"ready\");\n");
+    assert_eq!(parsed["region"]["start"]["line"], 3);
+    assert_eq!(
+        parsed["region"]["byteOffset"]["start"],
+        PAGE.find("<script>").unwrap() + "<script>".len()
+    );
+
+    let error = tools
+        .call_tool(
+            "parse_embedded",
+            json!({
+                "code": PAGE,
+                "language": "html",
+                "embedded_language": "javascript",
+                "position": {"line": 1, "column": 1}
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("No <script> or <style> element"));
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_rewrite_script_in_html() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let rule_config = r#"
+id: use-logger
+language: javascript
+rule:
+  pattern: console.log($A)
+fix: logger.info($A)
+"#;
+
+    let result = tools
+        .call_tool(
+            "parse_embedded",
+            json!({
+                "code": PAGE,
+                "language": "html",
+                "embedded_language": "javascript",
+                "position": {"line": 5, "column": 1},
+                "rule_config": rule_config
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let start = parsed["matches"][0]["range"]["byteOffset"]["start"]
+                .as_u64()
+                .unwrap() as usize;
+            assert_eq!(start, PAGE.find("console.log").unwrap());
+            assert_eq!(parsed["matches"][0]["range"]["start"]["line"], 4);
+            assert_eq!(
+                parsed["content"],
+                PAGE.replace("console.log(\"ready\")", "logger.info(\"ready\")")
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
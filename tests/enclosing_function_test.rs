@@ -0,0 +1,79 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const GO_CODE: &str = r#"package main
+
+var limit = 10
+
+func divide(a, b float64) (float64, error) {
+    check := func() bool {
+        return b == 0
+    }
+    if check() {
+        return 0, nil
+    }
+    return a / b, nil
+}
+"#;
+
+async fn enclosing_function(tools: &AstGrepTools, line: u32, column: u32) -> Result<Option<Value>> {
+    let result = tools
+        .call_tool(
+            "get_enclosing_function",
+            json!({
+                "code": GO_CODE,
+                "language": "go",
+                "position": {"line": line, "column": column}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            let parsed: Value = serde_json::from_str(&output)?;
+            Ok(Some(parsed["function"].clone()))
+        }
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+            Ok(None)
+        }
+        Err(e) => Err(e),
+    }
+}
+
+#[tokio::test]
+async fn test_enclosing_function_named_and_closure() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Inside the body of divide, outside the closure
+    if let Some(function) = enclosing_function(&tools, 12, 5).await? {
+        assert_eq!(function["name"], "divide");
+    }
+
+    // Inside the closure: the innermost function wins and has no name
+    if let Some(function) = enclosing_function(&tools, 7, 9).await? {
+        assert!(function["name"].is_null());
+        assert!(function["signature"]
+            .as_str()
+            .unwrap()
+            .starts_with("func()"));
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_enclosing_function_file_scope_is_null() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    if let Some(function) = enclosing_function(&tools, 3, 5).await? {
+        assert!(function.is_null(), "File scope has no enclosing function");
+    }
+
+    Ok(())
+}
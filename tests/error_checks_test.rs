@@ -0,0 +1,61 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package config
+
+func Load(path string) (*Config, int, error) {
+	data, err := os.ReadFile(path)
+	cfg, err := parse(data) // decode
+	if err != nil {
+		return nil, 0, err
+	}
+	return cfg, len(data), nil
+}
+
+func Print(path string) {
+	out, err := os.ReadFile(path)
+	fmt.Println(string(out))
+}
+"#;
+
+#[tokio::test]
+async fn test_add_error_checks_matches_results() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("add_error_checks", json!({"code": SOURCE}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["inserted"], 1);
+            assert_eq!(parsed["applied"], false);
+            // The second call is checked; Print has no error to return
+            let sites = parsed["sites"].as_array().unwrap();
+            assert_eq!(sites.len(), 2);
+            assert_eq!(sites[0]["line"], 4);
+            assert_eq!(sites[0]["returns"], "nil, 0, err");
+            assert_eq!(sites[1]["line"], 13);
+            assert!(sites[1]["skipped"].is_string());
+            assert!(parsed["content"].as_str().unwrap().contains(
+                "\tdata, err := os.ReadFile(path)\n\tif err != nil {\n\t\treturn nil, 0, err\n\t}\n\tcfg, err"
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -110,3 +110,360 @@ rule:
     println!("✅ All path resolution integration tests passed!");
     Ok(())
 }
+
+#[tokio::test]
+async fn test_execute_rule_size_filter() -> Result<()> {
+    let binary_manager = std::sync::Arc::new(
+        splice_weaver_mcp::binary_manager::BinaryManager::new()
+            .expect("Failed to create binary manager"),
+    );
+    let tools = splice_weaver_mcp::ast_grep_tools::AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path().to_path_buf();
+    tokio::fs::write(
+        root_path.join("test.rs"),
+        "fn short() {}\n\nfn long() {\n    let a = 1;\n    let b = 2;\n}\n",
+    )
+    .await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let rule_config = r#"
+id: functions
+language: rust
+rule:
+  kind: function_item
+"#;
+
+    // Malformed filters are rejected before ast-grep runs
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": rule_config,
+                "target": "test.rs",
+                "filter": ":taller-than(3)"
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Unknown pseudo-class"));
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": rule_config,
+                "target": "test.rs",
+                "filter": ":spanning-lines(4)"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<serde_json::Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            assert!(matches[0]["text"].as_str().unwrap().starts_with("fn long"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_keep_original_as_comment() -> Result<()> {
+    let binary_manager = std::sync::Arc::new(
+        splice_weaver_mcp::binary_manager::BinaryManager::new()
+            .expect("Failed to create binary manager"),
+    );
+    let tools = splice_weaver_mcp::ast_grep_tools::AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path();
+
+    let test_file = root_path.join("totals.py");
+    tokio::fs::write(&test_file, "def total(xs):\n    return sum(xs)\n").await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let rule_config = r#"
+id: fsum
+language: python
+rule:
+  pattern: sum($XS)
+fix: math.fsum($XS)
+"#;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": rule_config,
+                "target": "totals.py",
+                "operation": "replace",
+                "dry_run": false,
+                "keepOriginalAsComment": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert_eq!(
+                content,
+                "def total(xs):\n    return math.fsum(xs)\n    # before:\n    # sum(xs)\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_format_edited_only() -> Result<()> {
+    if std::process::Command::new("gofmt")
+        .arg("-l")
+        .arg("/dev/null")
+        .output()
+        .is_err()
+    {
+        println!("⚠️  gofmt not available, skipping formatting test");
+        return Ok(());
+    }
+    let binary_manager = std::sync::Arc::new(
+        splice_weaver_mcp::binary_manager::BinaryManager::new()
+            .expect("Failed to create binary manager"),
+    );
+    let tools = splice_weaver_mcp::ast_grep_tools::AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path();
+
+    let test_file = root_path.join("main.go");
+    tokio::fs::write(
+        &test_file,
+        "package main\n\nfunc main() {\n\ta  :=  1\n\tb := old(a,a)\n\tuse(a, b)\n}\n",
+    )
+    .await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let rule_config = r#"
+id: fresh
+language: go
+rule:
+  pattern: old($X, $Y)
+fix: fresh($X,$Y)
+"#;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": rule_config,
+                "target": "main.go",
+                "operation": "replace",
+                "dry_run": false,
+                "matchBraceStyle": true,
+                "formatEditedOnly": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: serde_json::Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["files"][0]["format_scope"], "edited");
+            // The untouched line keeps its spacing
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert_eq!(
+                content,
+                "package main\n\nfunc main() {\n\ta  :=  1\n\tb := fresh(a, a)\n\tuse(a, b)\n}\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_format_with_configured_formatters() -> Result<()> {
+    let binary_manager = std::sync::Arc::new(
+        splice_weaver_mcp::binary_manager::BinaryManager::new()
+            .expect("Failed to create binary manager"),
+    );
+    let tools = splice_weaver_mcp::ast_grep_tools::AstGrepTools::new(binary_manager);
+    let config = splice_weaver_mcp::server_config::ServerConfig::from_yaml(
+        "formatters:\n  python:\n    command: sed\n    args: [\"s/math.fsum/fsum/\"]\n  javascript:\n    command: splice-weaver-no-such-formatter\n",
+    )?;
+    tools.set_config(config);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path();
+    tokio::fs::write(
+        root_path.join("totals.py"),
+        "def total(xs):\n    return sum(xs)\n",
+    )
+    .await?;
+    tokio::fs::write(root_path.join("totals.js"), "const total = sum(xs);\n").await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": "id: fsum\nlanguage: python\nrule:\n  pattern: sum($XS)\nfix: math.fsum($XS)\n",
+                "target": "totals.py",
+                "operation": "replace",
+                "dry_run": false,
+                "format": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: serde_json::Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["files"][0]["formatted"], true);
+            let content = tokio::fs::read_to_string(root_path.join("totals.py")).await?;
+            assert_eq!(content, "def total(xs):\n    return fsum(xs)\n");
+
+            // A formatter that is not installed leaves the fixed file as it is
+            let output = tools
+                .call_tool(
+                    "execute_rule",
+                    serde_json::json!({
+                        "rule_config": "id: fsum\nlanguage: javascript\nrule:\n  pattern: sum($XS)\nfix: fsum($XS)\n",
+                        "target": "totals.js",
+                        "operation": "replace",
+                        "dry_run": false,
+                        "format": true
+                    }),
+                )
+                .await?;
+            let parsed: serde_json::Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["files"][0]["formatted"], false);
+            let content = tokio::fs::read_to_string(root_path.join("totals.js")).await?;
+            assert_eq!(content, "const total = fsum(xs);\n");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_lsp_ranges() -> Result<()> {
+    let binary_manager = std::sync::Arc::new(
+        splice_weaver_mcp::binary_manager::BinaryManager::new()
+            .expect("Failed to create binary manager"),
+    );
+    let tools = splice_weaver_mcp::ast_grep_tools::AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path().canonicalize()?;
+    tokio::fs::write(
+        root_path.join("greet.js"),
+        "const s = \"😀\"; log(s);\nlog(1);\n",
+    )
+    .await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let rule_config = r#"
+id: logs
+language: javascript
+rule:
+  pattern: log($A)
+"#;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            serde_json::json!({
+                "rule_config": rule_config,
+                "target": "greet.js",
+                "outputFormat": "lsp"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let files: Vec<serde_json::Value> = serde_json::from_str(&output)?;
+            assert_eq!(files.len(), 1);
+            assert_eq!(
+                files[0]["uri"],
+                format!("file://{}", root_path.join("greet.js").display())
+            );
+            // The emoji before the first call is two UTF-16 code units
+            assert_eq!(
+                files[0]["ranges"],
+                serde_json::json!([
+                    {"start": {"line": 0, "character": 16}, "end": {"line": 0, "character": 22}},
+                    {"start": {"line": 1, "character": 0}, "end": {"line": 1, "character": 6}}
+                ])
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,111 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+func TestOpen(t *testing.T) {
+	defer cleanup()
+	log.Printf("opening %s", name)
+}
+
+func open() {
+	log.Println("open")
+}
+"#;
+
+const RULE: &str = r#"id: test-logging
+language: go
+rule:
+  pattern: $LOGGER.$METHOD($$$ARGS)
+  all:
+    - inside:
+        kind: function_declaration
+        stopBy: end
+        has:
+          field: name
+          regex: ^Test
+    - inside:
+        kind: expression_statement
+        follows:
+          kind: defer_statement
+constraints:
+  METHOD:
+    regex: ^Print
+"#;
+
+#[tokio::test]
+async fn test_explain_match() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("main_test.go");
+    std::fs::write(&path, SOURCE)?;
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": path.display().to_string(),
+                "operation": "replace",
+                "explain": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("explain only applies to whole-file search and scan"),
+        "{}",
+        error
+    );
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": path.display().to_string(),
+                "explain": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            let explanation = &matches[0]["explanation"];
+            assert_eq!(explanation["checks"]["pattern"], "$LOGGER.$METHOD($$$ARGS)");
+            let inside = &explanation["steps"][0];
+            assert_eq!(inside["relation"], "inside");
+            assert_eq!(inside["stopBy"], "end");
+            assert_eq!(inside["node"]["kind"], "function_declaration");
+            assert_eq!(inside["node"]["text"], "func TestOpen(t *testing.T) { …");
+            // The nested step is found inside the function, not the call
+            assert_eq!(inside["steps"][0]["field"], "name");
+            assert_eq!(inside["steps"][0]["node"]["text"], "TestOpen");
+            let statement = &explanation["steps"][1];
+            assert_eq!(statement["node"]["kind"], "expression_statement");
+            assert_eq!(statement["steps"][0]["relation"], "follows");
+            assert_eq!(statement["steps"][0]["node"]["text"], "defer cleanup()");
+            assert_eq!(explanation["constraints"][0]["value"], "Printf");
+            assert_eq!(explanation["captures"]["LOGGER"], "log");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,87 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import "time"
+
+const (
+	Name    = "server"
+	Version = "1.2"
+)
+
+type Config struct {
+	Port int `json:"port"`
+}
+
+func main() {
+	time.Sleep(30 * time.Second)
+	retry(30)
+	println("port", 8080)
+}
+"#;
+
+#[tokio::test]
+async fn test_extract_go_constant() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "extract_go_constant",
+            json!({"code": SOURCE, "position": {"line": 15, "column": 13}, "name": "Timeout", "all": true}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["type"], "int");
+            assert_eq!(parsed["grouped"], true);
+            assert_eq!(parsed["declaration"], "Timeout = 30");
+            let lines: Vec<u64> = parsed["replaced"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["line"].as_u64().unwrap())
+                .collect();
+            assert_eq!(lines, [15, 16]);
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\tVersion = \"1.2\"\n\tTimeout = 30\n)"));
+            // Untyped, so it still multiplies a time.Duration
+            assert!(content.contains("time.Sleep(Timeout * time.Second)"));
+
+            let error = tools
+                .call_tool(
+                    "extract_go_constant",
+                    json!({"code": SOURCE, "position": {"line": 11, "column": 12}, "name": "PortTag"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("struct tag"), "{}", error);
+
+            let error = tools
+                .call_tool(
+                    "extract_go_constant",
+                    json!({"code": SOURCE, "position": {"line": 17, "column": 19}, "name": "Name"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("already used"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,113 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nimport \"fmt\"\n\ntype User struct {\n\tName string `json:\"name\"`\n}\n\nfunc main() {\n\tfmt.Println(\"Hello, world\")\n\tfmt.Printf(\"%d items\\n\", count(\"cart\"))\n\tpanic(\"unreachable\")\n}\n";
+
+#[tokio::test]
+async fn test_extract_strings_passed_to_print() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("extract_strings", json!({"code": SOURCE, "language": "go"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // The import path and struct tag are not user-facing
+            let texts: Vec<&str> = parsed["strings"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|string| string["text"].as_str().unwrap())
+                .collect();
+            assert_eq!(
+                texts,
+                [
+                    "\"Hello, world\"",
+                    "\"%d items\\n\"",
+                    "\"cart\"",
+                    "\"unreachable\""
+                ]
+            );
+
+            // "cart" is passed to count, not directly to Printf
+            let output = tools
+                .call_tool(
+                    "extract_strings",
+                    json!({"code": SOURCE, "language": "go", "functions": ["fmt.Print*"]}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let strings = parsed["strings"].as_array().unwrap();
+            assert_eq!(strings.len(), 2);
+            assert_eq!(strings[0]["call"], "fmt.Println");
+            assert_eq!(strings[0]["line"], 10);
+            assert_eq!(strings[1]["call"], "fmt.Printf");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_replace_string_literal_with_translation() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "replace_string_literal",
+            json!({"code": SOURCE, "language": "go", "key": "greeting", "template": "i18n.T()"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("template must contain"));
+
+    let result = tools
+        .call_tool(
+            "replace_string_literal",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "key": "greeting",
+                "position": {"line": 10, "column": 16}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["original"], "\"Hello, world\"");
+            assert_eq!(
+                parsed["content"],
+                SOURCE.replace("\"Hello, world\"", "i18n.T(\"greeting\")")
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,120 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::node_types;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::path::Path;
+use std::sync::Arc;
+
+const GO_NODE_TYPES: &str = "test-fixtures/node-types/go.json";
+
+#[test]
+fn test_describe_checked_in_node_types() -> Result<()> {
+    let node_types = node_types::from_file(Path::new(GO_NODE_TYPES))?;
+
+    let function = node_types::describe(&node_types, "function_declaration").unwrap();
+    let names: Vec<&str> = function["fields"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|field| field["name"].as_str().unwrap())
+        .collect();
+    assert_eq!(
+        names,
+        ["body", "name", "parameters", "result", "type_parameters"]
+    );
+    assert_eq!(function["fields"][1]["required"], true);
+    assert_eq!(function["fields"][0]["required"], false);
+    assert_eq!(
+        function["fields"][3]["types"],
+        json!(["_simple_type", "parameter_list"])
+    );
+
+    let statement = node_types::describe(&node_types, "inc_statement").unwrap();
+    assert_eq!(statement["children"]["types"], json!(["_expression"]));
+    let expression = node_types::describe(&node_types, "_expression").unwrap();
+    assert_eq!(
+        expression["subtypes"],
+        json!(["call_expression", "identifier", "selector_expression"])
+    );
+
+    assert!(node_types::describe(&node_types, "func").is_none());
+    assert_eq!(
+        node_types::similar_types(&node_types, "declaration"),
+        ["function_declaration", "method_declaration"]
+    );
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_fields_for_type_of_a_custom_grammar() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    // Just enough of a shared library for the header and symbol checks
+    std::fs::write(
+        temp_dir.path().join("mojo.so"),
+        b"\x7fELF\x02\x01\x01\0tree_sitter_mojo\0",
+    )?;
+    std::fs::copy(GO_NODE_TYPES, temp_dir.path().join("node-types.json"))?;
+    std::fs::write(
+        temp_dir.path().join("odin.so"),
+        b"\x7fELF\0tree_sitter_odin\0",
+    )?;
+    let config_path = temp_dir.path().join("splice-weaver.yaml");
+    std::fs::write(
+        &config_path,
+        "grammars:\n  mojo:\n    library_path: mojo.so\n    extensions: [mojo]\n    node_types_path: node-types.json\n  odin:\n    library_path: odin.so\n    extensions: [odin]\n",
+    )?;
+
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_config(ServerConfig::from_file(&config_path)?);
+
+    let output = tools
+        .call_tool(
+            "fields_for_type",
+            json!({"language": "mojo", "kind": "method_declaration"}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["language"], "mojo");
+    assert_eq!(parsed["fields"][3]["name"], "receiver");
+    assert_eq!(parsed["fields"][3]["required"], true);
+
+    // A wrong kind gets suggestions
+    let error = tools
+        .call_tool(
+            "fields_for_type",
+            json!({"language": "mojo", "kind": "functiondeclaration"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("did you mean function_declaration"),
+        "{}",
+        error
+    );
+
+    let error = tools
+        .call_tool(
+            "fields_for_type",
+            json!({"language": "odin", "kind": "procedure_declaration"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("set node_types_path"),
+        "{}",
+        error
+    );
+
+    let error = tools
+        .call_tool("fields_for_type", json!({"language": "mojo"}))
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Missing kind"), "{}", error);
+
+    Ok(())
+}
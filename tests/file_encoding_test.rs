@@ -0,0 +1,217 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::json;
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+async fn create_workspace(fixture: &str) -> Result<(AstGrepTools, tempfile::TempDir)> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let contents = tokio::fs::read(format!("test-fixtures/encodings/{fixture}")).await?;
+    tokio::fs::write(temp_dir.path().join(fixture), &contents).await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    Ok((tools, temp_dir))
+}
+
+#[tokio::test]
+async fn test_byte_order_mark_kept_on_write() -> Result<()> {
+    let (tools, temp_dir) = create_workspace("bom-utf8.js").await?;
+    let test_file = temp_dir.path().join("bom-utf8.js");
+
+    let result = tools
+        .call_tool(
+            "insert_import",
+            json!({
+                "target": "bom-utf8.js",
+                "language": "javascript",
+                "import": "import path from 'path';",
+                "dry_run": false
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert!(content.starts_with('\u{FEFF}'));
+            assert_eq!(content.matches('\u{FEFF}').count(), 1);
+            assert!(content.contains("import path from 'path';\n"));
+            assert!(content.contains("import { readFile } from 'fs';\n"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_latin1_file_round_trip() -> Result<()> {
+    let (tools, temp_dir) = create_workspace("latin1.py").await?;
+    let test_file = temp_dir.path().join("latin1.py");
+    let args = json!({
+        "target": "latin1.py",
+        "language": "python",
+        "name": "greet",
+        "comment": "Begrüßung",
+        "dry_run": false
+    });
+
+    // Undeclared, the file is read as UTF-8 and refused
+    let error = tools
+        .call_tool("replace_comment", args.clone())
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("fileEncoding"), "{}", error);
+
+    let mut args = args;
+    args["fileEncoding"] = json!("latin-1");
+    let result = tools.call_tool("replace_comment", args).await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let content = tokio::fs::read(&test_file).await?;
+            assert!(content.starts_with(b"# Begr\xfc\xdfung\ndef greet(name):\n"));
+            assert!(content.ends_with(b"\"Gr\xfc\xdf dich, \" + name\n"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_execute_rule_in_file_encoding() -> Result<()> {
+    let (tools, temp_dir) = create_workspace("latin1.py").await?;
+    let test_file = temp_dir.path().join("latin1.py");
+    tokio::fs::copy(
+        "test-fixtures/encodings/bom-utf8.js",
+        temp_dir.path().join("bom-utf8.js"),
+    )
+    .await?;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: rename\nlanguage: python\nrule:\n  pattern: greet\nfix: welcome",
+                "target": "latin1.py",
+                "operation": "replace",
+                "fileEncoding": "latin-1",
+                "dry_run": false
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let content = tokio::fs::read(&test_file).await?;
+            assert!(content.starts_with(b"# Gru\xdf an die Benutzer\ndef welcome(name):\n"));
+            assert!(content.ends_with(b"\"Gr\xfc\xdf dich, \" + name\n"));
+
+            // A directory's BOM files are matched at offsets after the mark
+            tools
+                .call_tool(
+                    "execute_rule",
+                    json!({
+                        "rule_config": "id: rename\nlanguage: javascript\nrule:\n  pattern: greet\nfix: welcome",
+                        "target": ".",
+                        "operation": "replace",
+                        "dry_run": false
+                    }),
+                )
+                .await?;
+            let content = tokio::fs::read_to_string(temp_dir.path().join("bom-utf8.js")).await?;
+            assert!(content.starts_with("\u{FEFF}import { readFile } from 'fs';"));
+            assert!(content.contains("export function welcome(name) {"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_crlf_node_text_round_trip() -> Result<()> {
+    let (tools, temp_dir) = create_workspace("crlf.js").await?;
+    let test_file = temp_dir.path().join("crlf.js");
+
+    let result = tools
+        .call_tool(
+            "get_node_text",
+            json!({
+                "target": "crlf.js",
+                "language": "javascript",
+                "kind": "method_definition",
+                "position": {"line": 3, "column": 5},
+                "normalizeNewlines": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: serde_json::Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["line_ending"], "crlf");
+            let method = parsed["text"].as_str().unwrap();
+            assert_eq!(method, "total() {\n    return this.items.length;\n  }");
+
+            tools
+                .call_tool(
+                    "insert_member",
+                    json!({
+                        "target": "crlf.js",
+                        "language": "javascript",
+                        "type_name": "Wishlist",
+                        "member": method,
+                        "dry_run": false
+                    }),
+                )
+                .await?;
+            // The inserted copy takes the file's CRLF endings
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert_eq!(content.matches("return this.items.length;\r\n").count(), 2);
+            assert!(!content.replace("\r\n", "").contains('\n'), "{:?}", content);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
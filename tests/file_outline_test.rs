@@ -0,0 +1,175 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+fn create_tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+fn summarize(output: &str) -> Result<Vec<(String, String, Value, u64)>> {
+    let parsed: Value = serde_json::from_str(output)?;
+    Ok(parsed["symbols"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|symbol| {
+            (
+                symbol["name"].as_str().unwrap().to_string(),
+                symbol["kind"].as_str().unwrap().to_string(),
+                symbol["receiver"].clone(),
+                symbol["depth"].as_u64().unwrap(),
+            )
+        })
+        .collect())
+}
+
+#[tokio::test]
+async fn test_go_outline_with_method_receivers() -> Result<()> {
+    let tools = create_tools();
+    let code = r#"package shapes
+
+const Pi = 3.14
+
+type Circle struct {
+    Radius float64
+}
+
+func (c *Circle) Area() float64 {
+    r := c.Radius
+    return Pi * r * r
+}
+
+func NewCircle(r float64) *Circle {
+    return &Circle{Radius: r}
+}
+
+func (c Circle) Diameter() float64 {
+    return 2 * c.Radius
+}
+"#;
+
+    let result = tools
+        .call_tool("file_outline", json!({"code": code, "language": "go"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            assert_eq!(
+                summarize(&output)?,
+                vec![
+                    ("Pi".to_string(), "variable".to_string(), Value::Null, 0),
+                    ("Circle".to_string(), "type".to_string(), Value::Null, 0),
+                    ("Area".to_string(), "method".to_string(), json!("Circle"), 0),
+                    (
+                        "NewCircle".to_string(),
+                        "function".to_string(),
+                        Value::Null,
+                        0
+                    ),
+                    (
+                        "Diameter".to_string(),
+                        "method".to_string(),
+                        json!("Circle"),
+                        0
+                    ),
+                ]
+            );
+
+            // Pointer and value receivers both group under Circle
+            let output = tools
+                .call_tool(
+                    "file_outline",
+                    json!({"code": code, "language": "go", "group_methods": true}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let symbols = parsed["symbols"].as_array().unwrap();
+            assert_eq!(symbols.len(), 3);
+            assert_eq!(symbols[1]["name"], "Circle");
+            let methods: Vec<&str> = symbols[1]["methods"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|method| method["name"].as_str().unwrap())
+                .collect();
+            assert_eq!(methods, ["Area", "Diameter"]);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_python_outline_depth() -> Result<()> {
+    let tools = create_tools();
+    let code = r#"LIMIT = 10
+
+class Stack:
+    kind = "lifo"
+
+    def push(self, item):
+        self.items.append(item)
+        count = len(self.items)
+
+def helper():
+    pass
+"#;
+
+    let result = tools
+        .call_tool("file_outline", json!({"code": code, "language": "python"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            assert_eq!(
+                summarize(&output)?,
+                vec![
+                    ("LIMIT".to_string(), "variable".to_string(), Value::Null, 0),
+                    ("Stack".to_string(), "type".to_string(), Value::Null, 0),
+                    ("helper".to_string(), "function".to_string(), Value::Null, 0),
+                ]
+            );
+
+            // Locals such as `count` stay out even when nesting is included
+            let output = tools
+                .call_tool(
+                    "file_outline",
+                    json!({"code": code, "language": "python", "depth": 1}),
+                )
+                .await?;
+            assert_eq!(
+                summarize(&output)?,
+                vec![
+                    ("LIMIT".to_string(), "variable".to_string(), Value::Null, 0),
+                    ("Stack".to_string(), "type".to_string(), Value::Null, 0),
+                    ("kind".to_string(), "variable".to_string(), Value::Null, 1),
+                    ("push".to_string(), "method".to_string(), json!("Stack"), 1),
+                    ("helper".to_string(), "function".to_string(), Value::Null, 0),
+                ]
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
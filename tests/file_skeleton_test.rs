@@ -0,0 +1,92 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import "fmt"
+
+// Config holds the settings.
+type Config struct {
+	Name string
+}
+
+func (c *Config) String() string { return c.Name }
+
+// Load reads the settings.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		return nil, fmt.Errorf("no path")
+	}
+	handler := func() {
+		fmt.Println("loaded")
+	}
+	handler()
+	return &Config{Name: path}, nil
+}
+
+var defaults = []string{
+	"a",
+	"b",
+	"c",
+}
+"#;
+
+#[tokio::test]
+async fn test_file_skeleton() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "file_skeleton",
+            json!({"code": SOURCE, "language": "go", "maxBlockLines": 3}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // The closure goes with the body it is in, and one-line bodies stay
+            assert_eq!(
+                parsed["content"],
+                "package main\n\nimport \"fmt\"\n\n// Config holds the settings.\ntype Config struct {\n\tName string\n}\n\nfunc (c *Config) String() string { return c.Name }\n\n// Load reads the settings.\nfunc Load(path string) (*Config, error) { ... }\n\nvar defaults = []string{ ... }\n"
+            );
+            assert_eq!(
+                parsed["folded"],
+                json!([
+                    {"kind": "function", "line": 13, "lines": 11},
+                    {"kind": "block", "line": 25, "lines": 5},
+                ])
+            );
+
+            let output = tools
+                .call_tool(
+                    "file_skeleton",
+                    json!({
+                        "code": "class Loader:\n    def load(self, path):\n        with open(path) as f:\n            return f.read()\n",
+                        "language": "python",
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "class Loader:\n    def load(self, path):\n        ...\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
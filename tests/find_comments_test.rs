@@ -0,0 +1,78 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\n// FIXME: handle errors\n// from the network\nfunc fetch() {\n\t// TODO retry\n\tlog(\"TODO: not a comment\")\n}\n\n// Server handles requests.\ntype Server struct{}\n\nfunc (s *Server) Start() {\n\tgo s.loop() // XXX leaks\n}\n\n// TODO: split this file\n\nvar x = 1\n";
+
+fn tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+#[tokio::test]
+async fn test_find_comments_with_declarations() -> Result<()> {
+    let tools = tools();
+
+    let result = tools
+        .call_tool("find_comments", json!({"code": SOURCE, "language": "go"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let comments = parsed["comments"].as_array().unwrap();
+            // The string literal mentioning TODO is not a comment
+            assert_eq!(parsed["total"], 4);
+
+            assert_eq!(comments[0]["match"], "FIXME");
+            assert_eq!(comments[0]["line"], 3);
+            assert_eq!(comments[0]["declaration"]["name"], "fetch");
+            assert_eq!(comments[0]["declaration"]["relation"], "above");
+
+            assert_eq!(comments[1]["text"], "// TODO retry");
+            assert_eq!(comments[1]["declaration"]["name"], "fetch");
+            assert_eq!(comments[1]["declaration"]["relation"], "inside");
+
+            assert_eq!(comments[2]["match"], "XXX");
+            assert_eq!(comments[2]["declaration"]["kind"], "method");
+            assert_eq!(comments[2]["declaration"]["receiver"], "Server");
+
+            // A blank line separates it from the var, so it documents nothing
+            assert_eq!(comments[3]["line"], 17);
+            assert!(comments[3]["declaration"].is_null());
+            assert!(comments[3].get("blame").is_none());
+
+            let output = tools
+                .call_tool(
+                    "find_comments",
+                    json!({"code": SOURCE, "language": "go", "regex": "(?i)handles"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["total"], 1);
+            assert_eq!(parsed["comments"][0]["declaration"]["name"], "Server");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool(
+            "find_comments",
+            json!({"code": SOURCE, "language": "go", "regex": "TODO("}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Invalid regex"), "{}", error);
+
+    Ok(())
+}
@@ -0,0 +1,87 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+var count = 0
+
+func bump() {
+	count++
+}
+
+func report(count int) {
+	println(count)
+}
+
+func main() {
+	bump()
+	println(count)
+}
+"#;
+
+#[tokio::test]
+async fn test_find_references_skips_shadowed_locals() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "find_references",
+            json!({"code": SOURCE, "language": "go", "name": "count"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["declarations"], json!([3]));
+            let kinds: Vec<(u64, &str)> = parsed["references"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|reference| {
+                    (
+                        reference["line"].as_u64().unwrap(),
+                        reference["kind"].as_str().unwrap(),
+                    )
+                })
+                .collect();
+            assert_eq!(kinds, [(3, "declaration"), (6, "write"), (15, "read")]);
+            // The parameter of report and its use
+            assert_eq!(parsed["shadowed"], 2);
+
+            let output = tools
+                .call_tool(
+                    "find_references",
+                    json!({"code": SOURCE, "language": "go", "position": {"line": 14, "column": 3}}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["name"], "bump");
+            assert_eq!(parsed["counts"]["call"], 1);
+
+            let error = tools
+                .call_tool(
+                    "find_references",
+                    json!({"code": SOURCE, "language": "go", "position": {"line": 10, "column": 11}}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("is local to"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,72 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+fn create_tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+#[tokio::test]
+async fn test_find_renamed_clone() -> Result<()> {
+    let tools = create_tools();
+    let code = r#"function totalPrice(items) {
+  let total = 0;
+  for (const item of items) {
+    total += item.price * 2;
+  }
+  return total;
+}
+
+function sumCosts(rows) {
+  let sum = 0;
+  for (const row of rows) {
+    sum += row.cost * 3;
+  }
+  return sum;
+}
+
+function greet(name) {
+  console.log("hello " + name);
+}
+"#;
+
+    let result = tools
+        .call_tool(
+            "find_similar",
+            json!({
+                "code": code,
+                "language": "javascript",
+                "position": {"line": 4, "column": 5},
+                "threshold": 0.9
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["reference"]["signature"],
+                "function totalPrice(items) {"
+            );
+            let candidates = parsed["candidates"].as_array().unwrap();
+            assert_eq!(candidates.len(), 1);
+            assert_eq!(candidates[0]["signature"], "function sumCosts(rows) {");
+            assert_eq!(candidates[0]["similarity"], 1.0);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
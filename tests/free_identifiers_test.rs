@@ -0,0 +1,85 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const GO_CODE: &str = r#"package main
+
+var limit = 3
+
+func label(names []string, sep string) (out string) {
+	for i, name := range names {
+		if i >= limit {
+			break
+		}
+		out += strings.ToUpper(name) + sep
+	}
+	fmt.Println(out)
+	return
+}
+"#;
+
+#[tokio::test]
+async fn test_go_free_identifiers() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "free_identifiers",
+            json!({"code": GO_CODE, "language": "go", "name": "label"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let free: Vec<&str> = parsed["free"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|identifier| identifier["name"].as_str().unwrap())
+                .collect();
+            // Parameters, the named result, and range variables are bound
+            assert_eq!(free, ["limit", "strings", "fmt"]);
+            assert_eq!(parsed["free"][0]["first_line"], 7);
+            assert_eq!(
+                parsed["bound"],
+                json!(["i", "label", "name", "names", "out", "sep"])
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_free_identifiers_unsupported_language() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "free_identifiers",
+            json!({"code": "class A {}", "language": "java", "name": "A"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("does not support java"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
@@ -0,0 +1,153 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::sync::Arc;
+
+const GO_SOURCE: &str = r#"package main
+
+func Max[T comparable](a, b T) T {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func Map[T, U any](slice []T, fn func(T) U) []U {
+	result := make([]U, len(slice))
+	for i, v := range slice {
+		result[i] = fn(v)
+	}
+	return result
+}
+
+type Stack[T any] struct {
+	items []T
+}
+
+func main() {
+	names := []string{"a", "bb"}
+	biggest := Max[int](3, 7)
+	lengths := Map[string](names, func(name string) int { return len(name) })
+	larger := Max(1.5, 2.5)
+	var stack Stack[string]
+	first := names[0]
+	println(biggest, lengths, larger, stack, first)
+}
+"#;
+
+const RUST_SOURCE: &str = r#"fn parse<T: std::str::FromStr>(text: &str) -> Option<T> {
+    text.parse().ok()
+}
+
+fn main() {
+    let port = parse::<u16>("8080");
+    let ports = vec![1, 2, 3];
+    println!("{:?} {:?}", port, ports);
+}
+"#;
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_execute_rule_include_generics() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    std::fs::write(temp_dir.path().join("main.go"), GO_SOURCE)?;
+    std::fs::write(temp_dir.path().join("main.rs"), RUST_SOURCE)?;
+    let tools = create_tools(temp_dir.path());
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: main\nlanguage: go\nrule:\n  kind: function_declaration\n  regex: ^func main\n",
+                "target": temp_dir.path().join("main.go").display().to_string(),
+                "includeGenerics": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let generics = parsed[0]["generics"].as_array().unwrap();
+            let names: Vec<(&str, &str)> = generics
+                .iter()
+                .map(|g| (g["kind"].as_str().unwrap(), g["name"].as_str().unwrap()))
+                .collect();
+            // The indexing of names and the calls of non-generics are not listed
+            assert_eq!(
+                names,
+                [
+                    ("call", "Max"),
+                    ("call", "Map"),
+                    ("call", "Max"),
+                    ("type", "Stack")
+                ]
+            );
+            assert_eq!(generics[0]["bindings"], json!({"T": "int"}));
+            assert_eq!(generics[0]["declared_line"], 3);
+            assert_eq!(generics[1]["type_arguments"], json!(["string"]));
+            assert_eq!(generics[1]["inferred"], json!(["U"]));
+            assert_eq!(generics[2]["inferred"], json!(["T"]));
+            assert_eq!(generics[3]["bindings"], json!({"T": "string"}));
+
+            let output = tools
+                .call_tool(
+                    "execute_rule",
+                    json!({
+                        "rule_config": "id: main\nlanguage: rust\nrule:\n  kind: function_item\n  regex: ^fn main\n",
+                        "target": temp_dir.path().join("main.rs").display().to_string(),
+                        "includeGenerics": true
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let generics = parsed[0]["generics"].as_array().unwrap();
+            assert_eq!(generics[0]["name"], "parse");
+            assert_eq!(generics[0]["bindings"], json!({"T": "u16"}));
+            assert_eq!(generics[1]["kind"], "macro");
+            assert_eq!(generics[1]["arguments"], json!(["1", "2", "3"]));
+            assert_eq!(generics[2]["name"], "println");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: main\nlanguage: go\nrule:\n  kind: function_declaration\n",
+                "target": temp_dir.path().display().to_string(),
+                "outputFormat": "lsp",
+                "includeGenerics": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("includeGenerics only applies"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
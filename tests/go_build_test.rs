@@ -0,0 +1,120 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::sync::Arc;
+
+const SOURCE: &str = "// Copyright 2024\n\n//go:build linux && !cgo\n// +build linux,!cgo\n\n// Package poll waits for I/O.\npackage poll\n";
+
+fn create_tools(root_path: &std::path::Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_go_build_constraints_report() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let file = temp_dir.path().join("poll_amd64.go");
+    tokio::fs::write(&file, SOURCE).await?;
+
+    let output = tools
+        .call_tool(
+            "go_build_constraints",
+            json!({"target": file.display().to_string(), "tags": ["linux", "amd64"]}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["go_build"]["line"], 3);
+    assert_eq!(parsed["go_build"]["constraint"], "linux && !cgo");
+    assert_eq!(parsed["plus_build"][0]["line"], 4);
+    assert_eq!(parsed["plus_build_matches"], true);
+    assert_eq!(parsed["file_name_constraint"], "amd64");
+    assert_eq!(parsed["builds_when"], "amd64 && linux && !cgo");
+    assert_eq!(parsed["active"], true);
+
+    let output = tools
+        .call_tool(
+            "go_build_constraints",
+            json!({"target": file.display().to_string(), "tags": ["linux", "arm64"]}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["active"], false);
+
+    let error = tools
+        .call_tool(
+            "go_build_constraints",
+            json!({"code": "//go:build linux &&\n\npackage poll\n"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("Invalid //go:build line 1"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_set_go_build_constraint_and_build_tag_guard() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let file = temp_dir.path().join("poll.go");
+    tokio::fs::write(&file, SOURCE).await?;
+
+    let output = tools
+        .call_tool(
+            "set_go_build_constraint",
+            json!({
+                "target": file.display().to_string(),
+                "constraint": "darwin||linux",
+                "dry_run": false
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["before"], "linux && !cgo");
+    assert_eq!(parsed["after"], "darwin || linux");
+    assert_eq!(parsed["plus_build_removed"], 1);
+    assert_eq!(parsed["applied"], true);
+    let edited = "// Copyright 2024\n\n//go:build darwin || linux\n\n// Package poll waits for I/O.\npackage poll\n";
+    assert_eq!(tokio::fs::read_to_string(&file).await?, edited);
+
+    // A server editing for Windows refuses the file unless forced
+    tools.set_config(ServerConfig::from_yaml(
+        "protection:\n  build_tags: [windows, amd64]\n",
+    )?);
+    let remove = json!({"target": file.display().to_string(), "dry_run": false});
+    let error = tools
+        .call_tool("set_go_build_constraint", remove.clone())
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("only builds when `darwin || linux`"),
+        "{}",
+        error
+    );
+    assert_eq!(tokio::fs::read_to_string(&file).await?, edited);
+
+    let mut forced = remove;
+    forced["force"] = json!(true);
+    tools.call_tool("set_go_build_constraint", forced).await?;
+    assert_eq!(
+        tokio::fs::read_to_string(&file).await?,
+        "// Copyright 2024\n\n// Package poll waits for I/O.\npackage poll\n"
+    );
+
+    Ok(())
+}
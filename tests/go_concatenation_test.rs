@@ -0,0 +1,93 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nimport \"os\"\n\nconst prefix = \"app: \" + \"v1\"\n\nfunc greet(name string) string {\n\ttotal := 1 + 2\n\tos.Exit(total)\n\treturn \"Hello, \" + name + \"! 100%\"\n}\n";
+
+#[tokio::test]
+async fn test_convert_go_concatenation_adds_import() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("convert_go_concatenation", json!({"code": SOURCE}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // Sums of numbers and of literals only are left alone
+            assert_eq!(parsed["conversions"].as_array().unwrap().len(), 1);
+            assert_eq!(parsed["conversions"][0]["line"], 10);
+            assert_eq!(parsed["import_added"], true);
+            assert_eq!(parsed["applied"], false);
+            assert_eq!(
+                parsed["content"],
+                SOURCE
+                    .replace("import \"os\"", "import \"fmt\"\nimport \"os\"")
+                    .replace(
+                        "\"Hello, \" + name + \"! 100%\"",
+                        "fmt.Sprintf(\"Hello, %s! 100%%\", name)"
+                    )
+            );
+
+            let error = tools
+                .call_tool(
+                    "convert_go_concatenation",
+                    json!({"code": SOURCE, "position": {"line": 8, "column": 11}}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("does not join string literals"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_convert_go_concatenation_uses_fmt_alias() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let source = "package main\n\nimport (\n\tf \"fmt\"\n)\n\nfunc path(dir, file string) string {\n\tf.Println(dir)\n\treturn dir + \"/\" + file\n}\n";
+
+    let result = tools
+        .call_tool(
+            "convert_go_concatenation",
+            json!({"code": source, "position": {"line": 9, "column": 16}}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["import_added"], false);
+            assert_eq!(
+                parsed["content"],
+                source.replace("dir + \"/\" + file", "f.Sprintf(\"%s/%s\", dir, file)")
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
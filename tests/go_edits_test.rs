@@ -0,0 +1,90 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nimport \"fmt\"\n\nfunc main() {\n\tfmt.Println(\"hi\")\n}\n";
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_apply_go_edits_tidies_imports() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    std::fs::write(temp_dir.path().join("main.go"), SOURCE)?;
+    let tools = create_tools(temp_dir.path());
+
+    let start = SOURCE.find("fmt.Println(\"hi\")").unwrap();
+    let end = start + "fmt.Println(\"hi\")".len();
+    let result = tools
+        .call_tool(
+            "apply_go_edits",
+            json!({
+                "files": [{
+                    "path": "main.go",
+                    "edits": [{"start": start, "end": end, "replacement": "println(strings.ToUpper(\"hi\"))"}]
+                }]
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["applied"], false);
+            let file = &parsed["files"][0];
+            match file["formatted_with"].as_str() {
+                Some("goimports") => {
+                    assert_eq!(file["imports_added"], json!(["strings"]));
+                    assert_eq!(file["imports_removed"], json!(["fmt"]));
+                }
+                _ => assert!(!parsed["warnings"].as_array().unwrap().is_empty()),
+            }
+            assert!(file["content"]
+                .as_str()
+                .unwrap()
+                .contains("println(strings.ToUpper(\"hi\"))"));
+
+            // An edit that breaks the syntax writes nothing
+            let error = tools
+                .call_tool(
+                    "apply_go_edits",
+                    json!({
+                        "files": [{
+                            "path": "main.go",
+                            "edits": [{"start": start, "end": end, "replacement": "fmt.Println(\"hi\""}]
+                        }],
+                        "dry_run": false
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("does not parse"), "{}", error);
+            assert_eq!(
+                std::fs::read_to_string(temp_dir.path().join("main.go"))?,
+                SOURCE
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,92 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+func Get(key string) (string, error) {
+	if key == "" {
+		return "", errors.New("empty key")
+	}
+	fmt.Println("get", key)
+	return "", fmt.Errorf("no key %q: %w", key, errNotFound)
+}
+
+var errNotFound = fmt.Sprint("not found")
+"#;
+
+#[tokio::test]
+async fn test_convert_go_errors() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "convert_go_errors",
+            json!({
+                "code": SOURCE,
+                "mapping": {"errors.New": "apperr.New", "fmt.Errorf": "apperr.Errorf"},
+                "import": "example.com/app/apperr",
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["conversions"].as_array().unwrap().len(), 2);
+            assert_eq!(
+                parsed["conversions"][1]["after"],
+                "apperr.Errorf(\"no key %q: %w\", key, errNotFound)"
+            );
+            assert_eq!(parsed["warnings"].as_array().unwrap().len(), 1);
+            // fmt is still used elsewhere, errors is not
+            assert_eq!(parsed["imports"]["removed"], json!(["\"errors\""]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.starts_with(
+                "package store\n\nimport (\n\t\"fmt\"\n\n\t\"example.com/app/apperr\"\n)\n"
+            ));
+            assert!(content.contains("return \"\", apperr.New(\"empty key\")\n"));
+
+            let error = tools
+                .call_tool(
+                    "convert_go_errors",
+                    json!({
+                        "code": SOURCE,
+                        "mapping": {"errors.New": "apperr.New"},
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("pass its path as import"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool("convert_go_errors", json!({"code": SOURCE}))
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Missing mapping"), "{}", error);
+
+    Ok(())
+}
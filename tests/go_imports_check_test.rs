@@ -0,0 +1,106 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import (
+	"fmt"
+	_ "embed"
+	f "fmt"
+	"os"
+)
+
+import "os"
+
+import _ "os"
+"#;
+
+#[tokio::test]
+async fn test_check_go_imports() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("check_go_imports", json!({"code": SOURCE}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let kinds: Vec<(&str, u64)> = parsed["issues"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|issue| {
+                    (
+                        issue["kind"].as_str().unwrap(),
+                        issue["line"].as_u64().unwrap(),
+                    )
+                })
+                .collect();
+            assert_eq!(
+                kinds,
+                [
+                    ("blank_import", 5),
+                    ("alias_conflict", 6),
+                    ("duplicate", 10),
+                    ("duplicate", 12),
+                ]
+            );
+            assert_eq!(parsed["issues"][2]["related_line"], 7);
+            // Reporting alone leaves the file as it is
+            assert!(parsed["content"].is_null());
+
+            let output = tools
+                .call_tool(
+                    "check_go_imports",
+                    json!({"code": SOURCE, "fix": ["dedupe"]}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "package main\n\nimport (\n\t\"fmt\"\n\t_ \"embed\"\n\tf \"fmt\"\n\t\"os\"\n)\n"
+            );
+
+            let output = tools
+                .call_tool(
+                    "check_go_imports",
+                    json!({
+                        "code": "package main\n\nimport \"os\"\n\nimport (\n\t\"github.com/x/log\"\n\t\"fmt\"\n)\n\nfunc main() {}\n",
+                        "fix": ["consolidate"],
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "package main\n\nimport (\n\t\"fmt\"\n\t\"os\"\n\n\t\"github.com/x/log\"\n)\n\nfunc main() {}\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool("check_go_imports", json!({"code": SOURCE, "fix": ["sort"]}))
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("Unknown fix 'sort'"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
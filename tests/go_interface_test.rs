@@ -0,0 +1,80 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_extract_go_interface() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let fixture = tokio::fs::read_to_string("test-fixtures/go/basic-functions.go").await?;
+
+    let result = tools
+        .call_tool(
+            "extract_go_interface",
+            json!({"code": fixture, "type": "Rectangle", "name": "Measurer", "assertion": true}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["methods"][0]["signature"], "Area() float64");
+            assert_eq!(parsed["methods"][1]["signature"], "Perimeter() float64");
+            assert_eq!(parsed["implemented_by"], "Rectangle");
+            assert!(parsed["content"].as_str().unwrap().contains(
+                "type Rectangle struct {\n    Width, Height float64\n}\n\ntype Measurer interface {\n    Area() float64\n    Perimeter() float64\n}\n\nvar _ Measurer = (*Rectangle)(nil)\n\nfunc (r Rectangle) Area()"
+            ));
+
+            // Person's methods are all unexported
+            let error = tools
+                .call_tool(
+                    "extract_go_interface",
+                    json!({"code": fixture, "type": "Person", "name": "Greeter"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("no exported methods"),
+                "{}",
+                error
+            );
+
+            let output = tools
+                .call_tool(
+                    "extract_go_interface",
+                    json!({
+                        "code": fixture,
+                        "type": "Person",
+                        "name": "Greeter",
+                        "include_unexported": true
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["methods"].as_array().unwrap().len(), 2);
+            assert_eq!(parsed["implemented_by"], "*Person");
+
+            let error = tools
+                .call_tool(
+                    "extract_go_interface",
+                    json!({"code": fixture, "type": "Rectangle", "name": "Shape"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("already exists"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,86 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_convert_go_receiver_both_ways() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let fixture = tokio::fs::read_to_string("test-fixtures/go/basic-functions.go").await?;
+    let greet_line = fixture
+        .lines()
+        .position(|line| line.starts_with("func (p Person) greet()"))
+        .unwrap()
+        + 1;
+
+    let result = tools
+        .call_tool(
+            "convert_go_receiver",
+            json!({
+                "code": fixture,
+                "style": "pointer",
+                "position": {"line": greet_line + 1, "column": 5}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["receiver"], "(p *Person)");
+            assert_eq!(parsed["mutations"], json!([]));
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("func (p *Person) greet() string {"));
+
+            // birthday increments a field, which a value receiver would lose
+            let output = tools
+                .call_tool(
+                    "convert_go_receiver",
+                    json!({"code": fixture, "style": "value", "name": "birthday"}),
+                )
+                .await?;
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["receiver"], "(p Person)");
+            assert_eq!(parsed["mutations"][0]["text"], "p.Age++");
+            assert!(parsed["warning"].as_str().unwrap().contains("copy"));
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("func (p Person) birthday() {"));
+
+            let error = tools
+                .call_tool(
+                    "convert_go_receiver",
+                    json!({"code": fixture, "style": "pointer", "name": "birthday"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("already has a pointer receiver"));
+
+            let error = tools
+                .call_tool(
+                    "convert_go_receiver",
+                    json!({"code": fixture, "style": "value", "name": "createPerson"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("is not a method"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
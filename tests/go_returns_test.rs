@@ -0,0 +1,174 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const GO_CODE: &str = r#"package main
+
+func stats(numbers []int) (sum, count int, average float64) {
+	for _, n := range numbers {
+		sum += n
+	}
+	count = len(numbers)
+	if count == 0 {
+		return
+	}
+	average = float64(sum) / float64(count)
+	return
+}
+
+func divide(a, b float64) (float64, error) {
+	if b == 0 {
+		return 0, errors.New("division by zero")
+	}
+	return a / b, nil
+}
+
+func closeAll(files []*os.File) (err error) {
+	defer func() { err = files[0].Close() }()
+	return nil
+}
+
+func closeBoth(a, b io.Closer) (error, error) {
+	return a.Close(), b.Close()
+}
+"#;
+
+fn skip_without_binary(e: anyhow::Error) -> Result<()> {
+    // If ast-grep binary is not available, this is expected
+    if e.to_string().contains("ast-grep") {
+        println!("⚠️  ast-grep binary not available, skipping execution test");
+        Ok(())
+    } else {
+        Err(e)
+    }
+}
+
+#[tokio::test]
+async fn test_convert_to_unnamed() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({"code": GO_CODE, "name": "stats", "style": "unnamed"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["results"], "(int, int, float64)");
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains(
+                "func stats(numbers []int) (int, int, float64) {\n\tvar sum, count int\n\tvar average float64\n\tfor"
+            ));
+            assert_eq!(content.matches("return sum, count, average").count(), 2);
+            Ok(())
+        }
+        Err(e) => skip_without_binary(e),
+    }
+}
+
+#[tokio::test]
+async fn test_convert_to_named() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({
+                "code": GO_CODE,
+                "name": "divide",
+                "style": "named",
+                "names": ["quotient", "err"],
+                "bare_returns": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["results"], "(quotient float64, err error)");
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content
+                .contains("\t\tquotient, err = 0, errors.New(\"division by zero\")\n\t\treturn\n"));
+            assert!(content.contains("\tquotient, err = a / b, nil\n\treturn\n}"));
+            Ok(())
+        }
+        Err(e) => skip_without_binary(e),
+    }
+}
+
+#[tokio::test]
+async fn test_names_error_results_in_turn() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({"code": GO_CODE, "name": "closeBoth", "style": "named"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["results"], "(err error, err2 error)");
+            Ok(())
+        }
+        Err(e) => skip_without_binary(e),
+    }
+}
+
+#[tokio::test]
+async fn test_refuses_unsafe_conversions() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // The deferred closure sets err after return
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({"code": GO_CODE, "name": "closeAll", "style": "unnamed"}),
+        )
+        .await;
+    match result {
+        Ok(output) => panic!("Expected a refusal, got {}", output),
+        Err(e) if e.to_string().contains("ast-grep") => return skip_without_binary(e),
+        Err(e) => assert!(e.to_string().contains("defer on line 23"), "{}", e),
+    }
+
+    // `b` is already a parameter of divide
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({"code": GO_CODE, "name": "divide", "style": "named", "names": ["b", "err"]}),
+        )
+        .await;
+    match result {
+        Ok(output) => panic!("Expected a refusal, got {}", output),
+        Err(e) => assert!(e.to_string().contains("already uses that name"), "{}", e),
+    }
+
+    // Go rejects two results of the same name
+    let result = tools
+        .call_tool(
+            "convert_go_returns",
+            json!({"code": GO_CODE, "name": "closeBoth", "style": "named", "names": ["err", "err"]}),
+        )
+        .await;
+    match result {
+        Ok(output) => panic!("Expected a refusal, got {}", output),
+        Err(e) => assert!(e.to_string().contains("must be unique"), "{}", e),
+    }
+    Ok(())
+}
@@ -0,0 +1,131 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+var debug = false
+
+func load(path string) (string, error) {
+	data, err := read(path)
+	if err != nil {
+		return "", err
+	}
+	if len(data) > 0 {
+		path := clean(path)
+		data, err := parse(data, path)
+		if err != nil {
+			return "", err
+		}
+		debug := true
+		_ = debug
+		return data, nil
+	}
+	return data, nil
+}
+"#;
+
+#[tokio::test]
+async fn test_find_go_shadowing() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("find_go_shadowing", json!({"code": SOURCE, "name": "load"}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let shadowing = parsed["shadowing"].as_array().unwrap();
+            let found: Vec<(&str, u64, u64)> = shadowing
+                .iter()
+                .map(|entry| {
+                    (
+                        entry["name"].as_str().unwrap(),
+                        entry["line"].as_u64().unwrap(),
+                        entry["shadows"]["line"].as_u64().unwrap(),
+                    )
+                })
+                .collect();
+            assert_eq!(
+                found,
+                [
+                    ("path", 11, 5),
+                    ("data", 12, 6),
+                    ("err", 12, 6),
+                    ("debug", 16, 3)
+                ]
+            );
+            assert_eq!(shadowing[3]["shadows"]["package_level"], true);
+            assert!(parsed["content"].is_null());
+
+            // The outer path is still read where the inner one is declared
+            let output = tools
+                .call_tool(
+                    "find_go_shadowing",
+                    json!({
+                        "code": SOURCE,
+                        "position": {"line": 11, "column": 3},
+                        "rename": {"name": "path", "to": "cleaned"},
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["renamed"]["lines"], json!([11, 12]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\t\tcleaned := clean(path)\n"));
+            assert!(content.contains("\t\tdata, err := parse(data, cleaned)\n"));
+            assert!(content.contains("\tdata, err := read(path)\n"));
+
+            let output = tools
+                .call_tool(
+                    "find_go_shadowing",
+                    json!({
+                        "code": SOURCE,
+                        "name": "load",
+                        "rename": {"name": "err", "to": "parseErr"},
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["renamed"]["lines"], json!([12, 13, 14]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\t\tdata, parseErr := parse(data, path)\n"));
+            assert!(content.contains("\t\t\treturn \"\", parseErr\n"));
+            assert!(content.contains("\tif err != nil {\n\t\treturn \"\", err\n"));
+
+            let error = tools
+                .call_tool(
+                    "find_go_shadowing",
+                    json!({
+                        "code": SOURCE,
+                        "name": "load",
+                        "rename": {"name": "err", "to": "data"},
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error
+                    .to_string()
+                    .contains("'data' is already used on line 6"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
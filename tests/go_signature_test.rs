@@ -0,0 +1,109 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package store
+
+func (s *Store) Put(ctx context.Context, key string, opts ...Option) error {
+	return nil
+}
+
+func Get(
+	ctx context.Context,
+	key, fallback string,
+) (
+	value []byte,
+	err error,
+) {
+	return nil, nil
+}
+
+func Close(force bool /* now */) {}
+"#;
+
+#[tokio::test]
+async fn test_reflow_go_signature() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("store.go");
+    std::fs::write(&path, SOURCE)?;
+
+    let result = tools
+        .call_tool(
+            "reflow_go_signature",
+            json!({"target": path.display().to_string(), "name": "Put"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["layout"], "multi_line");
+            assert_eq!(parsed["parameters"], 3);
+            assert_eq!(
+                parsed["signature"],
+                "func (s *Store) Put(\n\tctx context.Context,\n\tkey string,\n\topts ...Option,\n) error"
+            );
+            assert_eq!(parsed["applied"], false);
+
+            // A wrapped signature is joined, result list and all
+            let output = tools
+                .call_tool(
+                    "reflow_go_signature",
+                    json!({
+                        "target": path.display().to_string(),
+                        "position": {"line": 9, "column": 2},
+                        "dry_run": false,
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["layout"], "single_line");
+            assert_eq!(parsed["applied"], true);
+            let written = std::fs::read_to_string(&path)?;
+            assert!(written.contains(
+                "func Get(ctx context.Context, key, fallback string) (value []byte, err error) {\n\treturn nil, nil\n}\n"
+            ));
+
+            let output = tools
+                .call_tool(
+                    "reflow_go_signature",
+                    json!({
+                        "target": path.display().to_string(),
+                        "name": "Get",
+                        "layout": "single_line",
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["unchanged"], true);
+
+            let error = tools
+                .call_tool(
+                    "reflow_go_signature",
+                    json!({"target": path.display().to_string(), "name": "Close"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("has a comment on line 11"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
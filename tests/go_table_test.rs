@@ -0,0 +1,126 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package shapes
+
+type Rect struct {
+	w, h float64
+}
+
+func (r *Rect) Scale(by float64) (Rect, error) {
+	return Rect{r.w * by, r.h * by}, nil
+}
+
+func area(w, h float64) float64 {
+	return w * h
+}
+"#;
+
+const EXISTING_TEST: &str = r#"package shapes
+
+import "testing"
+
+func TestRect(t *testing.T) {
+}
+"#;
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_generate_go_table_test() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let source_path = temp_dir.path().join("shapes.go");
+    std::fs::write(&source_path, SOURCE)?;
+    let tools = create_tools(temp_dir.path());
+
+    let result = tools
+        .call_tool(
+            "generate_go_table_test",
+            json!({
+                "target": source_path.display().to_string(),
+                "name": "area",
+                "dry_run": false,
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["test_name"], "Test_area");
+            assert_eq!(parsed["created"], true);
+            assert_eq!(parsed["applied"], true);
+            let written = std::fs::read_to_string(temp_dir.path().join("shapes_test.go"))?;
+            assert!(written.starts_with(
+                "package shapes\n\nimport \"testing\"\n\nfunc Test_area(t *testing.T) {\n"
+            ));
+            assert!(written.contains(
+                "\t\tname string\n\t\tw    float64\n\t\th    float64\n\t\twant float64\n"
+            ));
+            assert!(written.contains("\t\t\tgot := area(tt.w, tt.h)\n"));
+
+            // A method is called on the case's receiver, next to the other tests
+            std::fs::write(temp_dir.path().join("shapes_test.go"), EXISTING_TEST)?;
+            let output = tools
+                .call_tool(
+                    "generate_go_table_test",
+                    json!({
+                        "target": source_path.display().to_string(),
+                        "position": {"line": 8, "column": 2},
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["test_name"], "TestRect_Scale");
+            assert_eq!(parsed["created"], false);
+            assert_eq!(parsed["imports"]["added"], json!(["\"reflect\""]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.starts_with(
+                "package shapes\n\nimport (\n\t\"reflect\"\n\t\"testing\"\n)\n\nfunc TestRect(t *testing.T) {\n}\n\nfunc TestRect_Scale(t *testing.T) {\n"
+            ));
+            assert!(content.contains("\t\t\tgot, err := tt.receiver.Scale(tt.by)\n"));
+            assert!(content.contains("if !reflect.DeepEqual(got, tt.want) {"));
+
+            let error = tools
+                .call_tool(
+                    "generate_go_table_test",
+                    json!({
+                        "target": source_path.display().to_string(),
+                        "name": "area",
+                        "test_name": "TestRect",
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("already has TestRect on line 5"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,73 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_custom_grammars_register_and_report_failures() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    // Just enough of a shared library for the header and symbol checks
+    std::fs::write(
+        temp_dir.path().join("mojo.so"),
+        b"\x7fELF\x02\x01\x01\0tree_sitter_mojo\0",
+    )?;
+    std::fs::write(temp_dir.path().join("odin.so"), b"not a library")?;
+    let config_path = temp_dir.path().join("splice-weaver.yaml");
+    std::fs::write(
+        &config_path,
+        "grammars:\n  mojo:\n    library_path: mojo.so\n    extensions: [mojo]\n  odin:\n    library_path: odin.so\n    extensions: [odin]\n",
+    )?;
+
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_config(ServerConfig::from_file(&config_path)?);
+
+    let output = tools.capabilities(&[]).await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    let languages = parsed["languages"].as_array().unwrap();
+    let language = |name: &str| {
+        languages
+            .iter()
+            .find(|language| language["name"] == name)
+            .unwrap()
+            .clone()
+    };
+    assert_eq!(language("mojo")["supported"], true);
+    assert_eq!(language("mojo")["extensions"], json!(["mojo"]));
+    assert_eq!(language("odin")["supported"], false);
+    assert!(language("odin")["error"]
+        .as_str()
+        .unwrap()
+        .contains("is not a shared library"));
+
+    let output = tools
+        .call_tool("detect_language", json!({"path": "src/main.mojo"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["language"], "mojo");
+    assert_eq!(parsed["method"], "extension");
+    assert_eq!(parsed["supported"], true);
+
+    // Rules for a grammar that failed say why rather than "unsupported"
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: any\nlanguage: odin\nrule:\n  pattern: $A\n",
+                "target": temp_dir.path().display().to_string()
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("The odin grammar failed to load"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
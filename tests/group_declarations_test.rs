@@ -0,0 +1,91 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\n// Version is the release.\nvar Version = \"1.2\"\nvar debug = false // set by -debug\n\n//go:embed banner.txt\nvar banner string\n\nconst A = iota\nconst B = iota\n\nfunc main() {\n\tvar x = 1\n\tvar y = 2\n\t_ = x + y\n}\n";
+
+#[tokio::test]
+async fn test_group_declarations() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "group_declarations",
+            json!({"code": SOURCE, "language": "python"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("only supports Go"));
+
+    let result = tools
+        .call_tool("group_declarations", json!({"code": SOURCE}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // The embed directive keeps banner alone, and grouping would
+            // renumber the iota constants
+            assert_eq!(parsed["changes"].as_array().unwrap().len(), 1);
+            assert_eq!(parsed["changes"][0]["count"], 2);
+            assert_eq!(parsed["skipped"][0]["line"], 10);
+            assert_eq!(
+                parsed["content"],
+                SOURCE.replace(
+                    "// Version is the release.\nvar Version = \"1.2\"\nvar debug = false // set by -debug\n",
+                    "var (\n\t// Version is the release.\n\tVersion = \"1.2\"\n\tdebug = false // set by -debug\n)\n"
+                )
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_split_declaration_group() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let source = "package main\n\nconst (\n\t// Name of the tool.\n\tName = \"weaver\"\n\n\tPort = 8080 // default\n)\n";
+    let result = tools
+        .call_tool(
+            "group_declarations",
+            json!({"code": source, "position": {"line": 5, "column": 2}}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["direction"], "split");
+            assert_eq!(
+                parsed["content"],
+                "package main\n\n// Name of the tool.\nconst Name = \"weaver\"\n\nconst Port = 8080 // default\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,77 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_hoist_go_closure() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let fixture = tokio::fs::read_to_string("test-fixtures/go/basic-functions.go").await?;
+    let literal_line = fixture
+        .lines()
+        .position(|line| line.trim() == "return func(x int) int {")
+        .unwrap()
+        + 1;
+    let position = json!({"line": literal_line, "column": 12});
+
+    let result = tools
+        .call_tool(
+            "hoist_go_closure",
+            json!({
+                "code": fixture,
+                "position": position,
+                "name": "multiply",
+                "captured": "parameters"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["captured"][0]["name"], "factor");
+            assert_eq!(parsed["captured"][0]["type"], "int");
+            assert_eq!(parsed["signature"], "func multiply(factor int, x int) int");
+            assert_eq!(
+                parsed["replacement"],
+                "func(x int) int { return multiply(factor, x) }"
+            );
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("return func(x int) int { return multiply(factor, x) }\n}\n"));
+            assert!(content
+                .contains("\nfunc multiply(factor int, x int) int {\n    return x * factor\n}\n"));
+
+            // Captured variables are refused by default
+            let error = tools
+                .call_tool(
+                    "hoist_go_closure",
+                    json!({"code": fixture, "position": position, "name": "multiply"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("captures factor"), "{}", error);
+
+            let error = tools
+                .call_tool(
+                    "hoist_go_closure",
+                    json!({"code": fixture, "position": position, "name": "add"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("already exists"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
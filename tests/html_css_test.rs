@@ -0,0 +1,211 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+async fn create_workspace() -> Result<(AstGrepTools, tempfile::TempDir)> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    for fixture in ["html/index.html", "css/styles.css"] {
+        let name = fixture.rsplit('/').next().unwrap();
+        let contents = tokio::fs::read_to_string(format!("test-fixtures/{fixture}")).await?;
+        tokio::fs::write(temp_dir.path().join(name), &contents).await?;
+    }
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    Ok((tools, temp_dir))
+}
+
+#[tokio::test]
+async fn test_html_and_css_files_are_supported() -> Result<()> {
+    let (tools, _temp_dir) = create_workspace().await?;
+
+    for (path, language) in [("index.html", "html"), ("styles.css", "css")] {
+        let output = tools
+            .call_tool("detect_language", json!({"path": path}))
+            .await?;
+        let parsed: Value = serde_json::from_str(&output)?;
+        assert_eq!(parsed["language"], language);
+        assert_eq!(parsed["supported"], true);
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_edit_html_class() -> Result<()> {
+    let (tools, temp_dir) = create_workspace().await?;
+
+    let result = tools
+        .call_tool(
+            "find_html_elements",
+            json!({"target": "index.html", "tag": "article", "class": "card"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["count"], 2);
+            assert_eq!(
+                parsed["elements"][1]["classes"],
+                json!(["card", "featured"])
+            );
+            assert_eq!(parsed["elements"][1]["attributes"]["data-kind"], "photo");
+
+            let error = tools
+                .call_tool(
+                    "edit_html_class",
+                    json!({"target": "index.html", "class": "nav-link", "add": "muted"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("pass all: true"), "{}", error);
+
+            let output = tools
+                .call_tool(
+                    "edit_html_class",
+                    json!({
+                        "target": "index.html",
+                        "class": "nav-link",
+                        "add": ["muted"],
+                        "remove": ["active"],
+                        "all": true,
+                        "dry_run": false
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["applied"], true);
+            assert_eq!(parsed["elements"][0]["after"], json!(["nav-link", "muted"]));
+            let written = std::fs::read_to_string(temp_dir.path().join("index.html"))?;
+            assert!(written.contains("<a href=\"/\" class=\"nav-link muted\">Home</a>"));
+
+            // The About link has no class attribute yet
+            let output = tools
+                .call_tool(
+                    "edit_html_class",
+                    json!({"target": "index.html", "position": {"line": 12, "column": 8}, "add": "external"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("<a href=\"/about\" class=\"external\">About</a>"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_edit_css_declaration() -> Result<()> {
+    let (tools, _temp_dir) = create_workspace().await?;
+
+    let result = tools
+        .call_tool(
+            "find_css_rules",
+            json!({"target": "styles.css", "selector": ".nav"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // The selector list on line 7 and the rule inside @media
+            assert_eq!(parsed["count"], 2);
+            assert_eq!(
+                parsed["rules"][0]["selectors"],
+                json!([".nav", ".nav-dark"])
+            );
+            assert_eq!(parsed["rules"][1]["line"], 28);
+
+            let error = tools
+                .call_tool(
+                    "edit_css_declaration",
+                    json!({"target": "styles.css", "selector": ".nav", "property": "gap", "value": "2rem"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("pass line"), "{}", error);
+
+            let output = tools
+                .call_tool(
+                    "edit_css_declaration",
+                    json!({"target": "styles.css", "selector": ".card", "property": "border", "value": "none"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["action"], "updated");
+            assert_eq!(parsed["previous"], "1px solid #ddd !important");
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains(".card {\n  padding: 1rem;\n  border: none;\n}"));
+
+            let output = tools
+                .call_tool(
+                    "edit_css_declaration",
+                    json!({"target": "styles.css", "selector": ".nav-link.active", "property": "color", "value": "#000"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["action"], "added");
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains(".nav-link.active { font-weight: bold; color: #000; }"));
+
+            let output = tools
+                .call_tool(
+                    "edit_css_declaration",
+                    json!({"target": "styles.css", "selector": ".card.featured", "property": "padding", "value": "0"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains(".card.featured {\n  padding: 0;\n}"));
+
+            let output = tools
+                .call_tool(
+                    "edit_css_declaration",
+                    json!({"target": "styles.css", "selector": "body", "property": "margin", "remove": true}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["action"], "removed");
+            assert!(parsed["content"].as_str().unwrap().starts_with(
+                "/* Styles for test-fixtures/html/index.html */\nbody {\n  font-family"
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,88 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+// square returns x times x.
+func square(x int) int {
+	return x * x
+}
+
+func main() {
+	a := square(3) + 1
+	b := square(a)
+	c := square(next())
+	println(a, b, c)
+}
+"#;
+
+#[tokio::test]
+async fn test_inline_go_function() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "inline_go_function",
+            json!({"code": SOURCE, "name": "square"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["expression"], "x * x");
+            assert_eq!(parsed["inlined"][0]["replacement"], "(3 * 3)");
+            assert_eq!(parsed["inlined"][1]["replacement"], "a * a");
+            // next() would run twice
+            assert_eq!(parsed["skipped"][0]["line"], 11);
+            assert!(parsed["skipped"][0]["reason"]
+                .as_str()
+                .unwrap()
+                .contains("would run 2 times"));
+            // The skipped call still needs the function
+            assert_eq!(parsed["removed"], false);
+            assert_eq!(parsed["references"], json!([11]));
+
+            let output = tools
+                .call_tool(
+                    "inline_go_function",
+                    json!({"code": SOURCE, "name": "square", "side_effect_free": true}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["removed"], true);
+            assert_eq!(
+                parsed["content"],
+                "package main\n\nfunc main() {\n\ta := (3 * 3) + 1\n\tb := a * a\n\tc := next() * next()\n\tprintln(a, b, c)\n}\n"
+            );
+
+            let error = tools
+                .call_tool(
+                    "inline_go_function",
+                    json!({"code": "package main\n\nfunc f(n int) int {\n\tn++\n\treturn n\n}\n", "name": "f"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("not a single return statement"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
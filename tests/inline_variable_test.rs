@@ -0,0 +1,65 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const JS_CODE: &str = r#"function area(width, height) {
+    const scale = width * height;
+    const label = describe(scale);
+    return scale / 2 + scale;
+}
+"#;
+
+async fn inline(tools: &AstGrepTools, args: Value) -> Result<Option<Value>> {
+    match tools.call_tool("inline_variable", args).await {
+        Ok(output) => Ok(Some(serde_json::from_str(&output)?)),
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+            Ok(None)
+        }
+        Err(e) => Err(e),
+    }
+}
+
+#[tokio::test]
+async fn test_inline_variable_replaces_every_read() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let args = json!({
+        "code": JS_CODE,
+        "language": "javascript",
+        "name": "scale"
+    });
+    if let Some(result) = inline(&tools, args).await? {
+        assert_eq!(result["replaced"], 3);
+        assert_eq!(
+            result["content"],
+            "function area(width, height) {\n    const label = describe((width * height));\n    return (width * height) / 2 + (width * height);\n}\n"
+        );
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_inline_variable_requires_vouching_for_calls() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let args = json!({
+        "code": "function f() {\n    const label = describe(1);\n    return label;\n}\n",
+        "language": "javascript",
+        "name": "label"
+    });
+    match tools.call_tool("inline_variable", args).await {
+        Ok(output) => panic!("Expected a side-effect refusal, got {}", output),
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+        }
+        Err(e) => assert!(e.to_string().contains("side_effect_free")),
+    }
+
+    Ok(())
+}
@@ -0,0 +1,107 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"// Package store keeps values.
+package store
+
+import (
+	"context"
+)
+
+// Store holds values.
+type Store struct{}
+"#;
+
+#[tokio::test]
+async fn test_insert_at_anchor() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "insert_at",
+            json!({"code": SOURCE, "language": "go", "anchor": "top", "text": "var x = 1"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("Unknown anchor: top"),
+        "{}",
+        error
+    );
+
+    let result = tools
+        .call_tool(
+            "insert_at",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "anchor": "after-imports",
+                "text": "var errClosed = errors.New(\"closed\")\n",
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["resolved"], "after_imports");
+            assert_eq!(parsed["line"], 8);
+            assert_eq!(
+                parsed["content"],
+                "// Package store keeps values.\npackage store\n\nimport (\n\t\"context\"\n)\n\nvar errClosed = errors.New(\"closed\")\n\n// Store holds values.\ntype Store struct{}\n"
+            );
+
+            // The declaration's comment stays with it
+            let output = tools
+                .call_tool(
+                    "insert_at",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "anchor": "before_first_declaration",
+                        "text": "type ID string",
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains(")\n\ntype ID string\n\n// Store holds values.\n"));
+
+            // Without imports, after_imports falls back to the package clause
+            let output = tools
+                .call_tool(
+                    "insert_at",
+                    json!({
+                        "code": "package store\n\nfunc Open() {}\n",
+                        "language": "go",
+                        "anchor": "after_imports",
+                        "text": "import \"os\"",
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["resolved"], "after_package");
+            assert_eq!(
+                parsed["content"],
+                "package store\n\nimport \"os\"\n\nfunc Open() {}\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,94 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::json;
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_insert_import_csharp_sorted() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Start from the attributed C# fixture so namespaces and nested usings are exercised
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path().to_path_buf();
+    let test_file = root_path.join("AttributedController.cs");
+    let fixture = tokio::fs::read_to_string("test-fixtures/csharp/AttributedController.cs").await?;
+    tokio::fs::write(&test_file, &fixture).await?;
+
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let result = tools
+        .call_tool(
+            "insert_import",
+            json!({
+                "target": "AttributedController.cs",
+                "language": "csharp",
+                "import": "using System.Linq;",
+                "dry_run": false
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert!(
+                content.contains(
+                    "using System.Collections.Generic;\nusing System.Linq;\nusing System.Threading.Tasks;\n"
+                ),
+                "using directive should be inserted in sorted order"
+            );
+
+            // Inserting the same directive again is a no-op
+            let output = tools
+                .call_tool(
+                    "insert_import",
+                    json!({
+                        "target": "AttributedController.cs",
+                        "language": "csharp",
+                        "import": "using System.Linq;",
+                        "dry_run": false
+                    }),
+                )
+                .await?;
+            assert!(output.contains("\"changed\": false"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_insert_import_unsupported_language() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "insert_import",
+            json!({
+                "target": "main.c",
+                "language": "c",
+                "import": "#include <stdio.h>"
+            }),
+        )
+        .await;
+
+    let error = result.expect_err("C imports are not supported");
+    assert!(error.to_string().contains("does not support"));
+    Ok(())
+}
@@ -0,0 +1,111 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CODE: &str = r#"package main
+
+func main() {
+	total := sum(1, compute(2, 3), 4)
+	p := Point{
+		X: 1,
+		Y: 2,
+	}
+	use(total, p)
+}
+"#;
+
+async fn edit(tools: &AstGrepTools, args: Value) -> Result<String> {
+    let output = tools.call_tool("edit_list_element", args).await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+    Ok(parsed["content"].as_str().unwrap().to_string())
+}
+
+#[tokio::test]
+async fn test_delete_middle_argument_keeps_list_valid() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = edit(
+        &tools,
+        json!({
+            "code": CODE,
+            "language": "go",
+            "operation": "delete",
+            "position": {"line": 4, "column": 18}
+        }),
+    )
+    .await;
+
+    match result {
+        Ok(content) => {
+            assert!(content.contains("total := sum(1, 4)\n"));
+
+            // The last field of a multi-line literal keeps Go's trailing comma
+            let content = edit(
+                &tools,
+                json!({
+                    "code": CODE,
+                    "language": "go",
+                    "operation": "delete",
+                    "position": {"line": 7, "column": 3}
+                }),
+            )
+            .await?;
+            assert!(content.contains("p := Point{\n\t\tX: 1,\n\t}\n"));
+
+            let content = edit(
+                &tools,
+                json!({
+                    "code": CODE,
+                    "language": "go",
+                    "operation": "insert",
+                    "position": {"line": 6, "column": 3},
+                    "element": "Z: 3"
+                }),
+            )
+            .await?;
+            assert!(content.contains("\t\tY: 2,\n\t\tZ: 3,\n\t}\n"));
+
+            let content = edit(
+                &tools,
+                json!({
+                    "code": CODE,
+                    "language": "go",
+                    "operation": "insert",
+                    "position": {"line": 9, "column": 6},
+                    "index": 0,
+                    "element": "ctx"
+                }),
+            )
+            .await?;
+            assert!(content.contains("use(ctx, total, p)"));
+
+            let error = tools
+                .call_tool(
+                    "edit_list_element",
+                    json!({
+                        "code": CODE,
+                        "language": "go",
+                        "operation": "delete",
+                        "position": {"line": 4, "column": 17}
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("between list elements"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
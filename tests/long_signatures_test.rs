@@ -0,0 +1,94 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package store
+
+func (s *Store) Put(ctx context.Context, key, value string, ttl time.Duration, tags []string, opts ...Option) error {
+	return nil
+}
+
+func Stats() (hits, misses int, ratio float64, err error) {
+	return 0, 0, 0, nil
+}
+
+func Get(ctx context.Context, key string) ([]byte, error) {
+	return nil, nil
+}
+"#;
+
+#[tokio::test]
+async fn test_find_long_signatures() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "find_long_signatures",
+            json!({"code": SOURCE, "language": "java"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("find_long_signatures does not support java yet"),
+        "{}",
+        error
+    );
+
+    let result = tools
+        .call_tool(
+            "find_long_signatures",
+            json!({"code": SOURCE, "language": "go"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["checked"], 3);
+            let functions = parsed["functions"].as_array().unwrap();
+            let names: Vec<&str> = functions
+                .iter()
+                .map(|function| function["name"].as_str().unwrap())
+                .collect();
+            // Put has the longer signature; Get stays within the limits
+            assert_eq!(names, ["Put", "Stats"]);
+            // The receiver is not a parameter, but names sharing a type are
+            assert_eq!(functions[0]["parameters"], 6);
+            assert_eq!(functions[0]["results"], 1);
+            assert_eq!(functions[0]["exceeds"], json!(["parameters"]));
+            assert_eq!(functions[0]["line"], 3);
+            assert_eq!(functions[1]["results"], 4);
+            assert_eq!(functions[1]["exceeds"], json!(["results"]));
+            assert_eq!(
+                functions[1]["signature"],
+                "func Stats() (hits, misses int, ratio float64, err error)"
+            );
+
+            let output = tools
+                .call_tool(
+                    "find_long_signatures",
+                    json!({"code": SOURCE, "language": "go", "maxParameters": 1, "maxResults": 5}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["count"], 2);
+            assert_eq!(parsed["functions"][1]["name"], "Get");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,96 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import "time"
+
+const maxUsers = 100
+
+type Config struct {
+	Retries int
+	Timeout time.Duration
+}
+
+func connect(attempts int) Config {
+	for i := 0; i < 5; i++ {
+		time.Sleep(250 * time.Millisecond)
+	}
+	if attempts > 3 {
+		return Config{Retries: 3}
+	}
+	timeout := 30 * time.Second
+	return Config{Retries: attempts, Timeout: timeout * 2}
+}
+"#;
+
+#[tokio::test]
+async fn test_find_magic_numbers() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool("find_magic_numbers", json!({"code": SOURCE}))
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let numbers = parsed["numbers"].as_array().unwrap();
+            let values: Vec<&str> = numbers
+                .iter()
+                .map(|number| number["value"].as_str().unwrap())
+                .collect();
+            // Constants, 0 and 1, and the loop's bounds are left out
+            assert_eq!(values, ["3", "250", "30", "2"]);
+
+            let three = &numbers[0];
+            assert_eq!(three["count"], 2);
+            assert_eq!(three["suggested_name"], "minAttempts");
+            assert_eq!(three["occurrences"][0]["role"], "comparison");
+            assert_eq!(three["occurrences"][0]["compared_to"], "attempts");
+            assert_eq!(three["occurrences"][0]["function"], "connect");
+            assert_eq!(three["occurrences"][1]["role"], "field");
+            assert_eq!(three["occurrences"][1]["field"], "Retries");
+            assert_eq!(three["extract"]["all"], true);
+
+            let sleep = &numbers[1]["occurrences"][0];
+            assert_eq!(sleep["role"], "argument");
+            assert_eq!(sleep["argument_of"], "time.Sleep");
+            assert_eq!(sleep["expression"], "250 * time.Millisecond");
+            assert_eq!(numbers[2]["suggested_name"], "defaultTimeout");
+            assert_eq!(numbers[2]["occurrences"][0]["assigned_to"], "timeout");
+            assert_eq!(numbers[3]["occurrences"][0]["field"], "Timeout");
+
+            let output = tools
+                .call_tool(
+                    "find_magic_numbers",
+                    json!({"code": SOURCE, "ignore": [3], "includeLoopBounds": true}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let values: Vec<&str> = parsed["numbers"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|number| number["value"].as_str().unwrap())
+                .collect();
+            assert_eq!(values, ["0", "5", "250", "30", "2"]);
+            assert_eq!(parsed["numbers"][1]["occurrences"][0]["role"], "loop_bound");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
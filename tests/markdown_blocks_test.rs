@@ -0,0 +1,108 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const README: &str = "# Usage\n\n```golang\nfmt.Println(\"hi\")\n```\n\nOr indented:\n\n    fmt.Println(\"bye\")\n\n~~~rust\nlet x = 1;\n~~~\n";
+
+#[tokio::test]
+async fn test_list_markdown_code_blocks() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Blocks are found by scanning text, so listing runs without ast-grep
+    let output = tools
+        .call_tool("markdown_code_blocks", json!({"code": README}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    let blocks = parsed["blocks"].as_array().unwrap();
+    assert_eq!(blocks.len(), 3);
+    assert_eq!(blocks[0]["info"], "golang");
+    assert_eq!(blocks[0]["language"], "go");
+    assert_eq!(blocks[0]["style"], "fenced");
+    assert_eq!(blocks[0]["code"], "fmt.Println(\"hi\")\n");
+    assert_eq!(blocks[0]["range"]["start"]["line"], 3);
+    assert_eq!(blocks[1]["style"], "indented");
+    assert_eq!(blocks[1]["language"], Value::Null);
+    assert_eq!(blocks[1]["code"], "fmt.Println(\"bye\")\n");
+    assert_eq!(blocks[2]["language"], "rust");
+
+    // The fence's language is used when embedded_language is left out
+    let output = tools
+        .call_tool(
+            "parse_embedded",
+            json!({"code": README, "language": "markdown", "block": 2}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["embedded_language"], "rust");
+    assert_eq!(parsed["code"], "let x = 1;\n");
+
+    let error = tools
+        .call_tool(
+            "parse_embedded",
+            json!({"code": README, "language": "markdown", "block": 1}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Missing embedded_language"));
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_rewrite_markdown_code_blocks() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let rule_config = r#"
+id: use-log
+language: go
+rule:
+  pattern: fmt.Println($A)
+fix: log.Println($A)
+"#;
+
+    for (position, expected) in [
+        (
+            json!({"line": 4, "column": 1}),
+            README.replace("fmt.Println(\"hi\")", "log.Println(\"hi\")"),
+        ),
+        (
+            json!({"line": 10, "column": 5}),
+            README.replace("fmt.Println(\"bye\")", "log.Println(\"bye\")"),
+        ),
+    ] {
+        let result = tools
+            .call_tool(
+                "parse_embedded",
+                json!({
+                    "code": README,
+                    "language": "markdown",
+                    "embedded_language": "go",
+                    "position": position,
+                    "rule_config": rule_config
+                }),
+            )
+            .await;
+
+        match result {
+            Ok(output) => {
+                println!("Output: {}", output);
+                let parsed: Value = serde_json::from_str(&output)?;
+                assert_eq!(parsed["content"], expected);
+            }
+            Err(e) => {
+                // If ast-grep binary is not available, this is expected
+                if e.to_string().contains("ast-grep") {
+                    println!("⚠️  ast-grep binary not available, skipping execution test");
+                } else {
+                    return Err(e);
+                }
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,99 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const RULE: &str = "id: calls\nlanguage: go\nrule:\n  pattern: fmt.Println($$$)\n";
+
+#[tokio::test]
+async fn test_global_index_survives_edit_in_earlier_file() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let target = dir.path().display().to_string();
+    std::fs::write(
+        dir.path().join("b.go"),
+        "package main\n\nfunc b() {\n\tfmt.Println(1)\n\tfmt.Println(2)\n}\n",
+    )?;
+    std::fs::write(
+        dir.path().join("a.go"),
+        "package main\n\nfunc a() {\n\tfmt.Println(0)\n}\n",
+    )?;
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": target,
+                "outputFormat": "ripgrep",
+                "globalIndex": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("globalIndex only applies"));
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({"rule_config": RULE, "target": target, "globalIndex": true}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            let texts: Vec<&str> = matches
+                .iter()
+                .map(|m| m["text"].as_str().unwrap())
+                .collect();
+            assert_eq!(
+                texts,
+                ["fmt.Println(0)", "fmt.Println(1)", "fmt.Println(2)"]
+            );
+            assert_eq!(matches[2]["globalIndex"], 2);
+
+            // A longer a.go moves no match ahead of the one picked in b.go
+            std::fs::write(
+                dir.path().join("a.go"),
+                "package main\n\nfunc a() {\n\tx := compute()\n\tfmt.Println(x)\n}\n",
+            )?;
+            let output = tools
+                .call_tool(
+                    "resolve_match_index",
+                    json!({"rule_config": RULE, "target": target, "index": 2, "expected_total": 3}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["match"]["text"], "fmt.Println(2)");
+            assert!(parsed["match"]["file"].as_str().unwrap().ends_with("b.go"));
+
+            // A new match ahead of it would shift the index
+            std::fs::write(
+                dir.path().join("a.go"),
+                "package main\n\nfunc a() {\n\tfmt.Println(0)\n\tfmt.Println(0)\n}\n",
+            )?;
+            let error = tools
+                .call_tool(
+                    "resolve_match_index",
+                    json!({"rule_config": RULE, "target": target, "index": 2, "expected_total": 3}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("now finds 4 matches, not 3"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
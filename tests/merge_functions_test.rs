@@ -0,0 +1,172 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import "fmt"
+
+func setup() {
+	fmt.Println("config")
+}
+
+// setupLogging turns on logging.
+func setupLogging() {
+	fmt.Println("logging")
+}
+
+func open(path string) {
+	fmt.Println(path)
+}
+
+func main() {
+	setup()
+	setupLogging()
+}
+"#;
+
+const PYTHON_SOURCE: &str = r#"class Server:
+    def start(self):
+        self.listen()
+
+    def announce(self):
+        """Say hello."""
+        print("started")
+"#;
+
+#[tokio::test]
+async fn test_merge_functions() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "merge_functions",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "first": {"name": "setup"},
+                "second": {"name": "setupLogging"},
+                "update_calls": true,
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["removed"], "setupLogging");
+            assert_eq!(parsed["calls_updated"][0]["line"], 20);
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains(
+                "func setup() {\n\tfmt.Println(\"config\")\n\tfmt.Println(\"logging\")\n}\n\nfunc open("
+            ));
+            assert!(!content.contains("turns on logging"));
+            assert!(content.contains("\tsetup()\n\tsetup()\n}"));
+
+            // Without update_calls the remaining call is reported
+            let output = tools
+                .call_tool(
+                    "merge_functions",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "first": {"name": "setup"},
+                        "second": {"position": {"line": 11, "column": 2}},
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["warnings"][0].as_str().unwrap().contains("line 20"));
+
+            // Different signatures need the merged one
+            let error = tools
+                .call_tool(
+                    "merge_functions",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "first": {"name": "open"},
+                        "second": {"name": "setup"},
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("different signatures"),
+                "{}",
+                error
+            );
+            let output = tools
+                .call_tool(
+                    "merge_functions",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "first": {"name": "open"},
+                        "second": {"name": "setup"},
+                        "signature": "func open(path string, verbose bool)",
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains(
+                "func open(path string, verbose bool) {\n\tfmt.Println(path)\n\tfmt.Println(\"config\")\n}"
+            ));
+
+            // Methods merge in place, but their callers are not followed
+            let output = tools
+                .call_tool(
+                    "merge_functions",
+                    json!({
+                        "code": PYTHON_SOURCE,
+                        "language": "python",
+                        "first": {"name": "start"},
+                        "second": {"name": "announce"},
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "class Server:\n    def start(self):\n        self.listen()\n        print(\"started\")\n"
+            );
+            assert!(parsed["warnings"][0]
+                .as_str()
+                .unwrap()
+                .contains("docstring"));
+            let error = tools
+                .call_tool(
+                    "merge_functions",
+                    json!({
+                        "code": PYTHON_SOURCE,
+                        "language": "python",
+                        "first": {"name": "start"},
+                        "second": {"name": "announce"},
+                        "update_calls": true,
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(
+                error.to_string().contains("top-level functions"),
+                "{}",
+                error
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
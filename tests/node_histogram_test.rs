@@ -0,0 +1,94 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nfunc a() {\n\tf(1)\n\tf(2)\n}\n\nfunc b() {\n\tg()\n}\n";
+
+#[tokio::test]
+async fn test_node_type_histogram() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "node_type_histogram",
+            json!({"code": SOURCE, "language": "go"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let count = |parsed: &Value, kind: &str| {
+                parsed["kinds"]
+                    .as_array()
+                    .unwrap()
+                    .iter()
+                    .find(|entry| entry["kind"] == kind)
+                    .map(|entry| entry["count"].clone())
+            };
+            assert_eq!(count(&parsed, "call_expression"), Some(json!(3)));
+            assert_eq!(count(&parsed, "function_declaration"), Some(json!(2)));
+            assert_eq!(parsed["scopes"], Value::Null);
+            let counts: Vec<u64> = parsed["kinds"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["count"].as_u64().unwrap())
+                .collect();
+            assert!(counts.windows(2).all(|pair| pair[0] >= pair[1]));
+
+            // Only a's two calls are inside the scope
+            let output = tools
+                .call_tool(
+                    "node_type_histogram",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "scope_rule": "id: a\nlanguage: go\nrule:\n  pattern: func a() { $$$ }\n"
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["scopes"], 1);
+            assert_eq!(count(&parsed, "call_expression"), Some(json!(2)));
+            assert_eq!(count(&parsed, "function_declaration"), Some(json!(1)));
+
+            let output = tools
+                .call_tool(
+                    "node_type_histogram",
+                    json!({"code": SOURCE, "language": "go", "limit": 1}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["kinds"].as_array().unwrap().len(), 1);
+            assert!(parsed["distinct"].as_u64().unwrap() > 1);
+
+            let error = tools
+                .call_tool(
+                    "node_type_histogram",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "scope_rule": "id: none\nlanguage: go\nrule:\n  kind: for_statement\n"
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("matches nothing"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
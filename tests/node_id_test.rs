@@ -0,0 +1,144 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const RULE: &str = "id: calls\nlanguage: go\nrule:\n  pattern: fmt.Println($$$)\n";
+
+#[tokio::test]
+async fn test_node_id_survives_edit_above() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("main.go");
+    std::fs::write(
+        &path,
+        "package main\n\nfunc main() {\n\tx := 1\n\tfmt.Println(x)\n}\n",
+    )?;
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": path.display().to_string(),
+                "outputFormat": "lsp",
+                "nodeIds": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("nodeIds only applies"));
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": RULE,
+                "target": path.display().to_string(),
+                "nodeIds": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            let id = matches[0]["nodeId"].as_str().unwrap().to_string();
+
+            // Longer lines before the call shift its offsets, not its path
+            let edited = "package main\n\nfunc main() {\n\tx := computeSomething(1, 2, 3)\n\tfmt.Println(x)\n}\n";
+            let output = tools
+                .call_tool(
+                    "resolve_node_id",
+                    json!({"code": edited, "language": "go", "node_id": id}),
+                )
+                .await?;
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["text"], "fmt.Println(x)");
+            assert_eq!(parsed["range"]["start"]["line"], 4);
+
+            let error = tools
+                .call_tool(
+                    "resolve_node_id",
+                    json!({"code": edited, "language": "go", "node_id": "/9"}),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("no longer resolves"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_filter_by_depth() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let dir = tempfile::tempdir()?;
+    let path = dir.path().join("main.go");
+    std::fs::write(
+        &path,
+        "package main\n\nvar x = 1\n\nfunc main() {\n\tvar y = 2\n\tprintln(x, y)\n}\n",
+    )?;
+    let rule = "id: vars\nlanguage: go\nrule:\n  kind: var_declaration\n";
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": rule,
+                "target": path.display().to_string(),
+                "filter": ":top-level"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            assert_eq!(matches[0]["text"], "var x = 1");
+            // Ids are only worked out for the filter
+            assert!(matches[0].get("nodeId").is_none());
+
+            let output = tools
+                .call_tool(
+                    "execute_rule",
+                    json!({
+                        "rule_config": rule,
+                        "target": path.display().to_string(),
+                        "filter": ":depth(1)",
+                        "nodeIds": true
+                    }),
+                )
+                .await?;
+            let matches: Vec<Value> = serde_json::from_str(&output)?;
+            assert_eq!(matches.len(), 1);
+            assert_eq!(matches[0]["nodeId"], "/1");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
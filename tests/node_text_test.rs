@@ -0,0 +1,167 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const PYTHON_CODE: &str = "def report(rows):\n    total = sum(rows)\n    mean = total / len(rows)\n    return total, mean\n";
+
+#[tokio::test]
+async fn test_node_text_truncated_with_comment_marker() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "get_node_text",
+            json!({
+                "code": PYTHON_CODE,
+                "language": "python",
+                "position": {"line": 2, "column": 5},
+                "maxBytes": 45
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["text"],
+                "def report(rows):\n    total = sum(rows)\n# ...51 bytes omitted..."
+            );
+            assert_eq!(parsed["truncated"], true);
+            // The range still covers the whole function
+            assert_eq!(
+                parsed["range"]["byteOffset"]["end"],
+                PYTHON_CODE.trim_end().len()
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+const GO_CODE: &str =
+    "package main\n\nfunc main() {\n\tmsg := \"use := here\" // or :=\n\tfmt.Println(msg)\n}\n";
+
+#[tokio::test]
+async fn test_node_text_selects_literal_token() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "get_node_text",
+            json!({
+                "code": GO_CODE,
+                "language": "go",
+                "kind": "\":=\"",
+                "position": {"line": 4, "column": 6}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["kind"], "\":=\"");
+            assert_eq!(parsed["text"], ":=");
+            assert_eq!(
+                parsed["range"]["byteOffset"],
+                json!({"start": 33, "end": 35})
+            );
+            assert_eq!(parsed["range"]["start"], json!({"line": 3, "column": 5}));
+
+            // The `:=` inside the string literal is not a token
+            let error = tools
+                .call_tool(
+                    "get_node_text",
+                    json!({
+                        "code": GO_CODE,
+                        "language": "go",
+                        "kind": "\":=\"",
+                        "position": {"line": 4, "column": 14}
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("No := token"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+const GO_FUNCTIONS: &str = "package main\n\nfunc add(a, b int) int {\n\treturn a + b\n}\n\nfunc sub(a, b int) int {\n\treturn a - b\n}\n";
+
+#[tokio::test]
+async fn test_node_texts_follow_selector_order() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "get_node_text",
+            json!({
+                "code": GO_FUNCTIONS,
+                "language": "go",
+                "selectors": [
+                    {"name": "sub"},
+                    {"position": {"line": 4, "column": 2}},
+                    {"name": "mul"},
+                    {"kind": "return_statement", "allowMultiple": true}
+                ]
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let results = parsed["results"].as_array().unwrap();
+            assert_eq!(results.len(), 5);
+            assert_eq!(results[0]["index"], 0);
+            assert!(results[0]["text"].as_str().unwrap().starts_with("func sub"));
+            assert!(results[1]["text"].as_str().unwrap().starts_with("func add"));
+            // A miss does not fail the other selectors
+            assert_eq!(results[2]["index"], 2);
+            assert!(results[2]["error"]
+                .as_str()
+                .unwrap()
+                .contains("No function named 'mul'"));
+            assert_eq!(results[3]["text"], "return a + b");
+            assert_eq!(results[4]["text"], "return a - b");
+            assert_eq!(results[4]["index"], 3);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,90 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "import { greet } from 'greet';\n\nconst name = 'Ada';\nconst message = 'it\\'s ' + name;\nconst quoted = 'say \"hi\"';\nconst template = `hello ${name}`;\nconst done = \"done\";\n";
+
+#[tokio::test]
+async fn test_normalize_quotes_to_double() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "normalize_quotes",
+            json!({"code": SOURCE, "language": "javascript", "quote": "backtick"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("quote must be"));
+
+    let result = tools
+        .call_tool(
+            "normalize_quotes",
+            json!({"code": SOURCE, "language": "javascript", "quote": "double"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["changes"].as_array().unwrap().len(), 3);
+            assert_eq!(parsed["skipped"][0]["line"], 5);
+            assert_eq!(parsed["skipped"][0]["reason"], "contains_quote");
+            assert_eq!(parsed["applied"], false);
+            assert_eq!(
+                parsed["content"],
+                "import { greet } from \"greet\";\n\nconst name = \"Ada\";\nconst message = \"it's \" + name;\nconst quoted = 'say \"hi\"';\nconst template = `hello ${name}`;\nconst done = \"done\";\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_normalize_quotes_in_python() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let source =
+        "def greet(name):\n    \"\"\"Say hello.\"\"\"\n    return f\"hello {name}\" + r\"\\d\"\n";
+    let result = tools
+        .call_tool(
+            "normalize_quotes",
+            json!({"code": source, "language": "python", "quote": "single"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "def greet(name):\n    '''Say hello.'''\n    return f'hello {name}' + r'\\d'\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
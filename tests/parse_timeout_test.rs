@@ -0,0 +1,82 @@
+use anyhow::Result;
+use serde_json::json;
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_parse_timeout_fails_the_call() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    // No parse finishes within 0 ms
+    tools.set_config(ServerConfig::from_yaml(
+        "parse_timeout:\n  languages:\n    javascript: 0\n",
+    )?);
+
+    let result = tools
+        .call_tool(
+            "get_enclosing_function",
+            json!({
+                "code": "function a() {\n  return 1;\n}\n",
+                "language": "javascript",
+                "position": {"line": 2, "column": 3}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => panic!("Expected a parse timeout, got {}", output),
+        Err(e) if e.to_string().contains("Parse timed out") => {
+            assert!(e.to_string().contains("as javascript (limit 0 ms"), "{}", e);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_parse_timeout_covers_directory_scans() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_config(ServerConfig::from_yaml(
+        "parse_timeout:\n  languages:\n    javascript: 0\n",
+    )?);
+    let temp_dir = tempfile::tempdir()?;
+    tokio::fs::write(temp_dir.path().join("a.js"), "function a() {}\n").await?;
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: functions\nlanguage: javascript\nrule:\n  kind: function_declaration\n",
+                "target": temp_dir.path().display().to_string()
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => panic!("Expected a parse timeout, got {}", output),
+        Err(e) if e.to_string().contains("Parse timed out") => {
+            assert!(e.to_string().contains("as javascript (limit 0 ms"), "{}", e);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
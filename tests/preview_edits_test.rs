@@ -0,0 +1,115 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::sync::Arc;
+
+const MAIN: &str = "package main\n\nfunc main() {\n\tgreet(\"hi\")\n}\n";
+const GREET: &str = "package main\n\nfunc greet(s string) {\n\tprintln(s)\n}\n";
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_preview_then_apply() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    std::fs::write(temp_dir.path().join("main.go"), MAIN)?;
+    std::fs::write(temp_dir.path().join("greet.go"), GREET)?;
+    let tools = create_tools(temp_dir.path());
+
+    let start = MAIN.find("\"hi\"").unwrap();
+    let files = json!([
+        {
+            "path": "main.go",
+            "edits": [{"start": start, "end": start + 4, "replacement": "\"hello\""}]
+        },
+        {"path": "greet.go", "content": GREET.replace("println(s)", "println(\"> \" + s)")}
+    ]);
+    let output = tools
+        .call_tool(
+            "preview_edits",
+            json!({"files": files, "save_plan": "greeting.plan.json"}),
+        )
+        .await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert!(parsed["diffs"]["main.go"]
+        .as_str()
+        .unwrap()
+        .contains("-\tgreet(\"hi\")\n+\tgreet(\"hello\")\n"));
+    assert!(parsed["diffs"]["greet.go"]
+        .as_str()
+        .unwrap()
+        .contains("+\tprintln(\"> \" + s)\n"));
+    assert_eq!(parsed["files"][1]["removed"], 1);
+    // Nothing was written but the plan
+    assert_eq!(
+        std::fs::read_to_string(temp_dir.path().join("main.go"))?,
+        MAIN
+    );
+
+    // The saved plan makes the previewed change and nothing else
+    let plan_path = parsed["plan_path"].as_str().unwrap().to_string();
+    tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": plan_path, "dry_run": false}),
+        )
+        .await?;
+    assert_eq!(
+        std::fs::read_to_string(temp_dir.path().join("main.go"))?,
+        MAIN.replace("\"hi\"", "\"hello\"")
+    );
+    assert!(std::fs::read_to_string(temp_dir.path().join("greet.go"))?.contains("\"> \" + s"));
+
+    // A file that changed after the preview makes the plan stale
+    let output = tools
+        .call_tool(
+            "preview_edits",
+            json!({
+                "files": [{"path": "greet.go", "content": GREET}],
+                "save_plan": "revert.plan.json",
+            }),
+        )
+        .await?;
+    let plan_path = serde_json::from_str::<Value>(&output)?["plan_path"]
+        .as_str()
+        .unwrap()
+        .to_string();
+    std::fs::write(temp_dir.path().join("greet.go"), GREET)?;
+    let error = tools
+        .call_tool(
+            "apply_edits_from_file",
+            json!({"plan_path": plan_path, "dry_run": false}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("stale"), "{}", error);
+
+    let error = tools
+        .call_tool(
+            "preview_edits",
+            json!({
+                "files": [{"path": "new.go", "content": "package main\n"}],
+                "save_plan": "new.plan.json",
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("does not exist yet"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
@@ -0,0 +1,66 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\ntype Server struct{ addr string }\n\nfunc (s *Server) Addr() string { return s.addr }\n\nfunc (s *Server) Close() {}\n\nfunc (srv *Server) Start() {\n\tgo func() { srv.listen(srv.addr) }()\n}\n\nfunc (this Server) Copy(s string) Server {\n\treturn this\n}\n\nfunc (self *Server) Reset() {\n\tfor _, self := range []*Server{} {\n\t\t_ = self\n\t}\n}\n\nfunc (c *Client) Start() {}\n";
+
+#[tokio::test]
+async fn test_receiver_names_follow_the_majority() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "go_receiver_names",
+            json!({"code": SOURCE, "type": "Server", "name": "a-b"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("not a valid receiver name"));
+
+    let result = tools
+        .call_tool(
+            "go_receiver_names",
+            json!({"code": SOURCE, "type": "Server"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["name"], "s");
+            assert_eq!(parsed["names"]["s"], 2);
+            assert_eq!(parsed["renamed"].as_array().unwrap().len(), 1);
+            assert_eq!(parsed["renamed"][0]["method"], "Start");
+            assert_eq!(parsed["renamed"][0]["uses"], 2);
+            // Copy has a parameter s, and Reset declares another self
+            let skipped: Vec<&str> = parsed["skipped"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["method"].as_str().unwrap())
+                .collect();
+            assert_eq!(skipped, ["Copy", "Reset"]);
+            assert_eq!(
+                parsed["content"],
+                SOURCE.replace(
+                    "func (srv *Server) Start() {\n\tgo func() { srv.listen(srv.addr) }()",
+                    "func (s *Server) Start() {\n\tgo func() { s.listen(s.addr) }()"
+                )
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,121 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const STRUCT: &str = "package main\n\ntype Config struct {\n\t// Port to listen on\n\tPort int `json:\"port\"`\n\thost string\n\tName string // display name\n}\n";
+
+#[tokio::test]
+async fn test_reorder_struct_fields_keeps_comments() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "reorder_elements",
+            json!({
+                "code": STRUCT,
+                "language": "go",
+                "position": {"line": 6, "column": 2},
+                "order": "alphabetical"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["container"], "field_declaration_list");
+            assert_eq!(parsed["order"], json!(["host", "Name", "Port"]));
+            assert_eq!(parsed["changed"], true);
+            assert_eq!(parsed["applied"], false);
+            assert_eq!(
+                parsed["content"],
+                "package main\n\ntype Config struct {\n\thost string\n\tName string // display name\n\t// Port to listen on\n\tPort int `json:\"port\"`\n}\n"
+            );
+
+            let output = tools
+                .call_tool(
+                    "reorder_elements",
+                    json!({
+                        "code": STRUCT,
+                        "language": "go",
+                        "position": {"line": 6, "column": 2},
+                        "order": "exported_first"
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["order"], json!(["Port", "Name", "host"]));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_reorder_python_parameters() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let source = "class Box:\n    def area(self, width, height, scale=1):\n        return width * height * scale\n";
+
+    let result = tools
+        .call_tool(
+            "reorder_elements",
+            json!({
+                "code": source,
+                "language": "python",
+                "position": {"line": 2, "column": 20},
+                "order": "custom",
+                "names": ["height"]
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // self stays first
+            assert_eq!(parsed["order"], json!(["self", "height", "width", "scale"]));
+            assert_eq!(
+                parsed["content"],
+                source.replace("width, height, scale", "height, width, scale")
+            );
+
+            let error = tools
+                .call_tool(
+                    "reorder_elements",
+                    json!({
+                        "code": source,
+                        "language": "python",
+                        "position": {"line": 2, "column": 20},
+                        "order": "alphabetical"
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("which has a default value"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
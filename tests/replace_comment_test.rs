@@ -0,0 +1,100 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+#[tokio::test]
+async fn test_replace_comment_on_go_divide() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let root_path = temp_dir.path().to_path_buf();
+    let test_file = root_path.join("basic-functions.go");
+    let fixture = tokio::fs::read_to_string("test-fixtures/go/basic-functions.go").await?;
+    tokio::fs::write(&test_file, &fixture).await?;
+
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+
+    let result = tools
+        .call_tool(
+            "replace_comment",
+            json!({
+                "target": "basic-functions.go",
+                "language": "go",
+                "name": "divide",
+                "comment": "divide returns a / b.\n\nIt returns an error when b is zero.",
+                "dry_run": false
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["action"], "replaced");
+
+            let content = tokio::fs::read_to_string(&test_file).await?;
+            assert!(content.contains(
+                "}\n\n// divide returns a / b.\n//\n// It returns an error when b is zero.\nfunc divide(a, b float64) (float64, error) {\n"
+            ));
+            assert!(!content.contains("// Functions with multiple return values"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_replace_comment_inserts_rust_doc_comment() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // The plain `//` line above divide is a section comment, not a doc comment
+    let code = "// Functions with early return\nfn divide(a: f64, b: f64) -> Option<f64> {\n    if b == 0.0 {\n        return None;\n    }\n    Some(a / b)\n}\n";
+    let result = tools
+        .call_tool(
+            "replace_comment",
+            json!({
+                "code": code,
+                "language": "rust",
+                "name": "divide",
+                "comment": "Divide `a` by `b`, or `None` when `b` is zero."
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["action"], "inserted");
+            assert!(parsed["content"].as_str().unwrap().starts_with(
+                "// Functions with early return\n/// Divide `a` by `b`, or `None` when `b` is zero.\nfn divide("
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,133 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+import "fmt"
+
+func wait() {
+	fmt.Println("waiting")
+}
+"#;
+
+const REPLACEMENT: &str = "func wait() error {\n\tif !ready {\n\t\treturn errors.New(\"not ready\")\n\t}\n\ttime.Sleep(time.Second)\n\tfmt.Println(\"waited\")\n\treturn nil\n}";
+
+#[tokio::test]
+async fn test_replace_node_with_imports() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "replace_node",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "position": {"line": 6, "column": 2},
+                "replacement": REPLACEMENT,
+                "imports": ["time", "errors", "fmt"],
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["kind"], "function_declaration");
+            assert_eq!(
+                parsed["imports"]["added"],
+                json!(["\"time\"", "\"errors\""])
+            );
+            assert_eq!(parsed["imports"]["present"], json!(["\"fmt\""]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.starts_with(
+                "package main\n\nimport (\n\t\"errors\"\n\t\"fmt\"\n\t\"time\"\n)\n\nfunc wait() error {\n"
+            ));
+            assert!(content.contains("\ttime.Sleep(time.Second)\n"));
+
+            // Third-party packages get a group of their own
+            let output = tools
+                .call_tool(
+                    "replace_node",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "position": {"line": 6, "column": 2},
+                        "replacement": "func wait() {\n\tlog.Info(\"waiting\")\n}",
+                        "imports": ["log \"github.com/x/log\""],
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("import (\n\t\"fmt\"\n\n\tlog \"github.com/x/log\"\n)\n"));
+
+            let error = tools
+                .call_tool(
+                    "replace_node",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "position": {"line": 6, "column": 2},
+                        "replacement": "func wait( {",
+                        "imports": ["time"],
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("does not parse"), "{}", error);
+
+            let output = tools
+                .call_tool(
+                    "replace_node",
+                    json!({
+                        "code": "def wait():\n    pass\n",
+                        "language": "python",
+                        "position": {"line": 2, "column": 5},
+                        "replacement": "def wait():\n    time.sleep(1)",
+                        "imports": ["import time"],
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(
+                parsed["content"],
+                "import time\n\ndef wait():\n    time.sleep(1)\n"
+            );
+
+            // The original stays below as a comment for review
+            let output = tools
+                .call_tool(
+                    "replace_node",
+                    json!({
+                        "code": SOURCE,
+                        "language": "go",
+                        "position": {"line": 6, "column": 2},
+                        "replacement": "func wait() {}",
+                        "keepOriginalAsComment": true,
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert!(parsed["content"].as_str().unwrap().ends_with(
+                "func wait() {}\n// before:\n// func wait() {\n// \tfmt.Println(\"waiting\")\n// }\n"
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
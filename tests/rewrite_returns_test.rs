@@ -0,0 +1,80 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"fn parse(input: &str) -> Option<u32> {
+    if input.is_empty() {
+        return None;
+    }
+    let digits = input.chars().filter(|c| {
+        return c.is_ascii_digit();
+    });
+    return input.parse().ok();
+}
+"#;
+
+#[tokio::test]
+async fn test_rewrite_returns_skips_closures() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "rewrite_returns",
+            json!({
+                "code": SOURCE,
+                "language": "rust",
+                "name": "parse",
+                "template": "Ok($EXPR)"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["rewritten"], 2);
+            assert_eq!(parsed["lines"], json!([3, 8]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("return Ok(None);"));
+            assert!(content.contains("return Ok(input.parse().ok());"));
+            // The closure's return is its own
+            assert!(content.contains("return c.is_ascii_digit();"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_rewrite_returns_requires_placeholder() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "rewrite_returns",
+            json!({
+                "code": SOURCE,
+                "language": "rust",
+                "name": "parse",
+                "template": "Ok(value)"
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("$EXPR"));
+
+    Ok(())
+}
@@ -0,0 +1,97 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nvar count = 0\n\ntype Server struct{}\n\nfunc (s *Server) Start() {\n\tcount := 1\n\tfor i := 0; i < 3; i++ {\n\t\tcount += i\n\t}\n\tgo func() { count++ }()\n}\n";
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_execute_rule_include_scope() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    std::fs::write(temp_dir.path().join("main.go"), SOURCE)?;
+    let tools = create_tools(temp_dir.path());
+
+    let result = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: count\nlanguage: go\nrule:\n  kind: identifier\n  regex: ^count$\n",
+                "target": temp_dir.path().display().to_string(),
+                "globalIndex": true,
+                "includeScope": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let scopes: Vec<(&str, Option<&str>)> = parsed
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|m| {
+                    (
+                        m["scope"]["kind"].as_str().unwrap(),
+                        m["scope"]["name"].as_str(),
+                    )
+                })
+                .collect();
+            // The same name at package level, in the method, in its loop,
+            // and in a closure, which has no name of its own
+            assert_eq!(
+                scopes,
+                [
+                    ("package", None),
+                    ("method", Some("Start")),
+                    ("block", Some("Start")),
+                    ("function", Some("Start")),
+                ]
+            );
+            assert_eq!(parsed[2]["scope"]["node"], "for_statement");
+            assert_eq!(parsed[1]["scope"]["path"], json!(["Server", "Start"]));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    let error = tools
+        .call_tool(
+            "execute_rule",
+            json!({
+                "rule_config": "id: count\nlanguage: go\nrule:\n  kind: identifier\n  regex: ^count$\n",
+                "target": temp_dir.path().display().to_string(),
+                "outputFormat": "lsp",
+                "includeScope": true
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("includeScope only applies"),
+        "{}",
+        error
+    );
+
+    Ok(())
+}
@@ -0,0 +1,69 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use splice_weaver_mcp::server_config::ServerConfig;
+use std::sync::Arc;
+
+fn create_tools(root_path: &std::path::Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_session_log_records_applied_edits() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let log_file = temp_dir.path().join("operations.jsonl");
+    let tools = create_tools(temp_dir.path());
+    let mut config = ServerConfig::default();
+    config.operation_log.file = Some(log_file.clone());
+    tools.set_config(config);
+    tokio::fs::write(temp_dir.path().join("notes.txt"), "alpha\nbeta\ngamma\n").await?;
+
+    // Plain text has no grammar, so this runs without ast-grep
+    let diff = "--- a/notes.txt\n+++ b/notes.txt\n@@ -1,3 +1,3 @@\n alpha\n-beta\n+BETA\n gamma\n";
+    tools
+        .call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false, "session_id": "review-1"}),
+        )
+        .await?;
+    // Previews write nothing, so they are not logged
+    let undo = "--- a/notes.txt\n+++ b/notes.txt\n@@ -1,3 +1,3 @@\n alpha\n-BETA\n+beta\n gamma\n";
+    tools
+        .call_tool("apply_unified_diff", json!({"diff": undo}))
+        .await?;
+
+    let output = tools
+        .call_tool("get_session_log", json!({"session_id": "review-1"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["session"], "review-1");
+    let entries = parsed["entries"].as_array().unwrap();
+    assert_eq!(entries.len(), 1);
+    assert_eq!(entries[0]["tool"], "apply_unified_diff");
+    assert!(entries[0]["file"].as_str().unwrap().ends_with("notes.txt"));
+    assert_eq!(
+        entries[0]["edits"][0]["replacement"],
+        "alpha\nBETA\ngamma\n"
+    );
+    assert!(entries[0]["timestamp"].as_u64().unwrap() > 0);
+
+    // The server's own session has nothing yet
+    let output = tools.call_tool("get_session_log", json!({})).await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["entries"], json!([]));
+    assert_eq!(parsed["sessions"], json!(["review-1"]));
+
+    let logged = tokio::fs::read_to_string(&log_file).await?;
+    assert_eq!(logged.lines().count(), 1);
+    assert!(logged.contains("\"session\":\"review-1\""));
+
+    Ok(())
+}
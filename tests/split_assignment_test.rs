@@ -0,0 +1,75 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+func load(path string) (int, error) {
+	var size int
+	size, err := stat(path), check(path)
+	a, b := 1, 2
+	a, b = b, a
+	n, ok := lookup(path)
+	for i, j := 0, 10; i < j; i++ {
+	}
+	return size + a + b + n, err
+}
+"#;
+
+#[tokio::test]
+async fn test_split_go_assignment() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let split = |line: u64, column: u64| {
+        tools.call_tool(
+            "split_go_assignment",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "position": {"line": line, "column": column},
+            }),
+        )
+    };
+
+    match split(5, 2).await {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // size was declared above, so only err is new
+            assert_eq!(
+                parsed["statements"],
+                json!(["size = stat(path)", "err := check(path)"])
+            );
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("\tsize = stat(path)\n\terr := check(path)\n"));
+            assert_eq!(parsed["warnings"].as_array().unwrap().len(), 1);
+
+            let parsed: Value = serde_json::from_str(&split(6, 2).await?)?;
+            assert_eq!(parsed["statements"], json!(["a := 1", "b := 2"]));
+            assert_eq!(parsed["warnings"], json!([]));
+
+            for (line, column, message) in [
+                (7, 2, "needs a as it was before"),
+                (8, 2, "returns several values at once"),
+                (9, 6, "in a statement's header"),
+            ] {
+                let error = split(line, column).await.unwrap_err();
+                assert!(error.to_string().contains(message), "{}", error);
+            }
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,146 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const PYTHON_CODE: &str = r#"def report(orders, currency):
+    total = 0
+    for order in orders:
+        total += order.amount
+    lines = [f"{len(orders)} orders"]
+    lines.append(f"{total} {currency}")
+    return "\n".join(lines)
+"#;
+
+const GO_CODE: &str = r#"package main
+
+func summarize(prices []float64, label string) string {
+	count := 0
+	var total float64
+	for _, price := range prices {
+		total += price
+		count++
+	}
+	avg := total / float64(count)
+	return fmt.Sprintf("%s: %d items, %.2f average", label, count, avg)
+}
+"#;
+
+#[tokio::test]
+async fn test_split_python_function() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "split_function",
+            json!({
+                "code": PYTHON_CODE,
+                "language": "python",
+                "name": "report",
+                "split_line": 5,
+                "helper_name": "format_report"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // `order` is bound before the split but not read after it
+            assert_eq!(parsed["parameters"], json!(["orders", "currency", "total"]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains(
+                "        total += order.amount\n    return format_report(orders, currency, total)\n\n\ndef format_report(orders, currency, total):\n    lines = "
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_split_go_function_types_parameters() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "split_function",
+            json!({
+                "code": GO_CODE,
+                "language": "go",
+                "name": "summarize",
+                "split_line": 10,
+                "helper_name": "formatSummary"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["untyped"], json!([]));
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\treturn formatSummary(label, count, total)\n}\n"));
+            assert!(content.contains(
+                "func formatSummary(label string, count int, total float64) string {\n\tavg := total / float64(count)\n"
+            ));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_split_line_inside_nested_block_is_refused() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "split_function",
+            json!({
+                "code": GO_CODE,
+                "language": "go",
+                "name": "summarize",
+                "split_line": 7,
+                "helper_name": "formatSummary"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => panic!("Expected the split to be refused, got {}", output),
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+        }
+        Err(e) => assert!(
+            e.to_string()
+                .contains("does not start a statement directly"),
+            "{}",
+            e
+        ),
+    }
+
+    Ok(())
+}
@@ -0,0 +1,93 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+func main() {
+	f.Close(ctx)
+	g.Close(x.Close(y))
+	same := b == b
+	other := b == c
+}
+"#;
+
+#[tokio::test]
+async fn test_structural_replace() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Metavariables are checked before anything is parsed
+    let error = tools
+        .call_tool(
+            "structural_replace",
+            json!({"code": SOURCE, "language": "go", "pattern": "$A.Close($B)", "replacement": "Close($A, $C)"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("$C in the replacement is not captured"),
+        "{}",
+        error
+    );
+    let error = tools
+        .call_tool(
+            "structural_replace",
+            json!({"code": SOURCE, "language": "go", "pattern": "$F($$$ARGS)", "replacement": "$F($ARGS)"}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("list of nodes"), "{}", error);
+
+    let result = tools
+        .call_tool(
+            "structural_replace",
+            json!({"code": SOURCE, "language": "go", "pattern": "$A.Close($B)", "replacement": "Close($A, $B)"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["count"], 2);
+            assert_eq!(
+                parsed["replaced"][0]["captures"],
+                json!({"A": "f", "B": "ctx"})
+            );
+            // The inner call is an argument of the outer one
+            assert_eq!(parsed["skipped"][0]["text"], "x.Close(y)");
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\tClose(f, ctx)\n\tClose(g, x.Close(y))\n"));
+
+            let output = tools
+                .call_tool(
+                    "structural_replace",
+                    json!({"code": SOURCE, "language": "go", "pattern": "$X == $X", "replacement": "true"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["count"], 1);
+            assert_eq!(parsed["repeated"], json!(["X"]));
+            assert_eq!(parsed["dropped"], json!(["X"]));
+            assert!(parsed["content"]
+                .as_str()
+                .unwrap()
+                .contains("same := true\n\tother := b == c"));
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
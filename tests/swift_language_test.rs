@@ -0,0 +1,136 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const FIXTURE: &str = "test-fixtures/swift/ShapeKit.swift";
+
+async fn create_workspace() -> Result<(AstGrepTools, tempfile::TempDir)> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let temp_dir = tempfile::tempdir()?;
+    let fixture = tokio::fs::read_to_string(FIXTURE).await?;
+    tokio::fs::write(temp_dir.path().join("ShapeKit.swift"), &fixture).await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    Ok((tools, temp_dir))
+}
+
+#[tokio::test]
+async fn test_swift_files_are_supported() -> Result<()> {
+    let (tools, _temp_dir) = create_workspace().await?;
+
+    let output = tools
+        .call_tool("detect_language", json!({"path": "ShapeKit.swift"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["language"], "swift");
+    assert_eq!(parsed["supported"], true);
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_insert_method_into_conforming_struct() -> Result<()> {
+    let (tools, temp_dir) = create_workspace().await?;
+    let test_file = temp_dir.path().join("ShapeKit.swift");
+
+    // Rectangle is declared on line 11 and extended on line 22
+    let ambiguous = tools
+        .call_tool(
+            "insert_member",
+            json!({
+                "target": "ShapeKit.swift",
+                "language": "swift",
+                "type_name": "Rectangle",
+                "member": "func scaled(by factor: Double) -> Rectangle {\n    Rectangle(width: width * factor, height: height * factor)\n}"
+            }),
+        )
+        .await;
+
+    match ambiguous {
+        Ok(output) => panic!("Expected Rectangle to be ambiguous, got {}", output),
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+            return Ok(());
+        }
+        Err(e) => assert!(e.to_string().contains("lines 11, 22"), "{}", e),
+    }
+
+    let output = tools
+        .call_tool(
+            "insert_member",
+            json!({
+                "target": "ShapeKit.swift",
+                "language": "swift",
+                "type_name": "Rectangle",
+                "line": 11,
+                "member": "func scaled(by factor: Double) -> Rectangle {\n    Rectangle(width: width * factor, height: height * factor)\n}",
+                "dry_run": false
+            }),
+        )
+        .await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["line"], 21);
+
+    let content = tokio::fs::read_to_string(&test_file).await?;
+    assert!(content.contains(
+        "        width * height\n    }\n\n    func scaled(by factor: Double) -> Rectangle {\n        Rectangle(width: width * factor, height: height * factor)\n    }\n}\n\nextension Rectangle {"
+    ));
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_enclosing_function_in_trailing_closure() -> Result<()> {
+    let (tools, _temp_dir) = create_workspace().await?;
+
+    let result = tools
+        .call_tool(
+            "get_enclosing_function",
+            json!({
+                "target": "ShapeKit.swift",
+                "language": "swift",
+                "position": {"line": 36, "column": 9}
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["function"]["name"], "largest");
+
+            // Inside the trailing closure the closure itself is innermost
+            let output = tools
+                .call_tool(
+                    "get_enclosing_function",
+                    json!({
+                        "target": "ShapeKit.swift",
+                        "language": "swift",
+                        "position": {"line": 38, "column": 13}
+                    }),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["function"]["signature"], "{");
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,88 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const CODE: &str = "fn a() {}\n\n// helpers\nfn b() {\n    let x = 1;\n}\n";
+
+fn create_tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+fn node(text: &str) -> Value {
+    let start = CODE.find(text).unwrap();
+    json!({"start_byte": start, "end_byte": start + text.len()})
+}
+
+#[tokio::test]
+async fn test_text_between_functions() -> Result<()> {
+    let tools = create_tools();
+    let result = tools
+        .call_tool(
+            "text_between",
+            json!({
+                "code": CODE,
+                "language": "rust",
+                "first": node("fn b() {\n    let x = 1;\n}"),
+                "second": node("fn a() {}")
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["text"], "\n\n// helpers\n");
+            assert_eq!(parsed["start_byte"], 9);
+            assert_eq!(parsed["end_line"], 4);
+
+            // A function's statement is not a sibling of the other function
+            let error = tools
+                .call_tool(
+                    "text_between",
+                    json!({
+                        "code": CODE,
+                        "language": "rust",
+                        "first": node("fn a() {}"),
+                        "second": node("let x = 1;")
+                    }),
+                )
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("are not siblings"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_text_between_rejects_overlap() -> Result<()> {
+    let tools = create_tools();
+    let error = tools
+        .call_tool(
+            "text_between",
+            json!({
+                "code": CODE,
+                "language": "rust",
+                "first": node("fn b() {\n    let x = 1;\n}"),
+                "second": node("let x = 1;")
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("The nodes overlap"), "{}", error);
+
+    Ok(())
+}
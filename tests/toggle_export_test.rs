@@ -0,0 +1,104 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package shapes
+
+// compute multiplies the sides.
+func compute(w, h int) int {
+	return w * h
+}
+
+type Box struct {
+	Width  int
+	height int
+}
+
+func (b Box) Area() int {
+	return compute(b.Width, b.height)
+}
+
+func scale(compute int) int {
+	return compute * 2
+}
+
+func NewBox() Box {
+	return Box{Width: 1, height: 2}
+}
+
+func init() {}
+"#;
+
+#[tokio::test]
+async fn test_toggle_go_export() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "toggle_go_export",
+            json!({"code": SOURCE, "name": "compute"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["new_name"], "Compute");
+            assert_eq!(parsed["exported"], true);
+            let content = parsed["content"].as_str().unwrap();
+            assert!(
+                content.contains("// Compute multiplies the sides.\nfunc Compute(w, h int) int {")
+            );
+            assert!(content.contains("return Compute(b.Width, b.height)"));
+            // The parameter of scale is another variable
+            assert!(content.contains("func scale(compute int) int {\n\treturn compute * 2"));
+
+            let output = tools
+                .call_tool(
+                    "toggle_go_export",
+                    json!({"code": SOURCE, "name": "height", "type": "Box"}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["kind"], "field");
+            let lines: Vec<u64> = parsed["renamed"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["line"].as_u64().unwrap())
+                .collect();
+            assert_eq!(lines, [10, 14, 22]);
+
+            let output = tools
+                .call_tool("toggle_go_export", json!({"code": SOURCE, "name": "Area"}))
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["new_name"], "area");
+            assert!(parsed["warnings"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .any(|warning| warning.as_str().unwrap().contains("satisfy interfaces")));
+
+            let error = tools
+                .call_tool("toggle_go_export", json!({"code": SOURCE, "name": "init"}))
+                .await
+                .unwrap_err();
+            assert!(error.to_string().contains("Go runtime"), "{}", error);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
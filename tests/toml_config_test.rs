@@ -0,0 +1,223 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+/// Tools in a workspace holding the Cargo.toml fixture.
+async fn create_workspace() -> Result<(AstGrepTools, tempfile::TempDir)> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    let temp_dir = tempfile::tempdir()?;
+    let contents = tokio::fs::read_to_string("test-fixtures/toml/Cargo.toml").await?;
+    tokio::fs::write(temp_dir.path().join("Cargo.toml"), &contents).await?;
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", temp_dir.path().display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    Ok((tools, temp_dir))
+}
+
+#[tokio::test]
+async fn test_find_toml_keys() -> Result<()> {
+    let (tools, _temp_dir) = create_workspace().await?;
+
+    let output = tools
+        .call_tool("detect_language", json!({"path": "Cargo.toml"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["language"], "toml");
+
+    let output = tools
+        .call_tool("find_toml_keys", json!({"target": "Cargo.toml"}))
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    let names: Vec<&str> = parsed["tables"]
+        .as_array()
+        .unwrap()
+        .iter()
+        .map(|table| table["name"].as_str().unwrap())
+        .collect();
+    assert_eq!(
+        names,
+        [
+            "package",
+            "dependencies",
+            "dependencies.reqwest",
+            "dev-dependencies",
+            "bin",
+            "bin",
+            "profile.release"
+        ]
+    );
+    assert_eq!(
+        parsed["tables"][1]["keys"],
+        json!(["anyhow", "regex", "serde", "tokio.version"])
+    );
+
+    // The same path, spelled as an inline table, a subtable and a dotted key
+    for (selector, value, line) in [
+        (
+            "table[name=dependencies] > pair[key=serde] > pair[key=version]",
+            "\"1.0\"",
+            10,
+        ),
+        (
+            "table[name=dependencies] > pair[key=reqwest] > pair[key=version]",
+            "\"0.11\"",
+            14,
+        ),
+        ("pair[key=dependencies.tokio.version]", "\"1.38\"", 11),
+        (
+            "table[name=bin][index=1] > pair[key=path]",
+            "\"src/bench.rs\"",
+            30,
+        ),
+    ] {
+        let output = tools
+            .call_tool(
+                "find_toml_keys",
+                json!({"target": "Cargo.toml", "selector": selector}),
+            )
+            .await?;
+        let parsed: Value = serde_json::from_str(&output)?;
+        assert_eq!(parsed["values"][0]["value"], value, "{}", selector);
+        assert_eq!(parsed["values"][0]["line"], line, "{}", selector);
+    }
+
+    let output = tools
+        .call_tool(
+            "find_toml_keys",
+            json!({"target": "Cargo.toml", "selector": "table[name=bin]"}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["tables"].as_array().unwrap().len(), 2);
+    assert_eq!(parsed["tables"][1]["line"], 28);
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_edit_toml_value() -> Result<()> {
+    let (tools, temp_dir) = create_workspace().await?;
+
+    // The trailing comment stays
+    let output = tools
+        .call_tool(
+            "edit_toml_value",
+            json!({
+                "target": "Cargo.toml",
+                "selector": "table[name=package] > pair[key=version]",
+                "value": "0.4.0",
+                "dry_run": false,
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["action"], "updated");
+    assert_eq!(parsed["previous"], "\"0.3.1\"");
+    assert_eq!(parsed["applied"], true);
+    let written = std::fs::read_to_string(temp_dir.path().join("Cargo.toml"))?;
+    assert!(written.contains("version = \"0.4.0\"   # bumped by the release script\n"));
+
+    for (selector, value, expected) in [
+        // A literal string stays literal
+        (
+            "table[name=dependencies] > pair[key=regex]",
+            json!("1.11"),
+            "regex = '1.11'\n",
+        ),
+        (
+            "table[name=dependencies] > pair[key=serde] > pair[key=features]",
+            json!(["derive", "rc"]),
+            "serde = { version = \"1.0\", features = [\"derive\", \"rc\"] }\n",
+        ),
+        // The dependencies are sorted, so a new one goes in order
+        (
+            "table[name=dependencies] > pair[key=rand]",
+            json!("0.8"),
+            "anyhow = \"1.0\"\nrand = \"0.8\"\nregex = '1.10'\n",
+        ),
+        (
+            "table[name=dependencies] > pair[key=serde] > pair[key=optional]",
+            json!(true),
+            "features = [\"derive\"], optional = true }\n",
+        ),
+        (
+            "table[name=bin][index=0] > pair[key=test]",
+            json!(false),
+            "path = \"src/main.rs\"\ntest = false\n\n[[bin]]\n",
+        ),
+        (
+            "table[name=features] > pair[key=default]",
+            json!(["json"]),
+            "lto = true\n\n[features]\ndefault = [\"json\"]\n",
+        ),
+    ] {
+        let output = tools
+            .call_tool(
+                "edit_toml_value",
+                json!({"target": "Cargo.toml", "selector": selector, "value": value}),
+            )
+            .await?;
+        let parsed: Value = serde_json::from_str(&output)?;
+        assert!(
+            parsed["content"].as_str().unwrap().contains(expected),
+            "{}: {}",
+            selector,
+            parsed["content"]
+        );
+    }
+
+    let output = tools
+        .call_tool(
+            "edit_toml_value",
+            json!({
+                "target": "Cargo.toml",
+                "selector": "table[name=dependencies.reqwest] > pair[key=default-features]",
+                "value": "true",
+                "raw": true,
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert!(parsed["content"]
+        .as_str()
+        .unwrap()
+        .contains("version = \"0.11\"\ndefault-features = true\nfeatures = [\n"));
+
+    let error = tools
+        .call_tool(
+            "edit_toml_value",
+            json!({
+                "target": "Cargo.toml",
+                "selector": "table[name=dependencies] > pair[key=anyhow] > pair[key=features]",
+                "value": ["std"],
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error
+            .to_string()
+            .contains("dependencies.anyhow on line 8 is a string, not a table"),
+        "{}",
+        error
+    );
+    let error = tools
+        .call_tool(
+            "edit_toml_value",
+            json!({
+                "target": "Cargo.toml",
+                "selector": "table[name=bin] > pair[key=path]",
+                "value": "src/lib.rs",
+            }),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("add [index=N]"), "{}", error);
+
+    Ok(())
+}
@@ -0,0 +1,164 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+fn create_tools(root_path: &std::path::Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_apply_diff_and_reject_conflict() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let test_file = temp_dir.path().join("notes.txt");
+    tokio::fs::write(&test_file, "alpha\nbeta\ngamma\n").await?;
+
+    // Plain text has no grammar, so this runs without ast-grep
+    let diff = "--- a/notes.txt\n+++ b/notes.txt\n@@ -1,3 +1,3 @@\n alpha\n-beta\n+BETA\n gamma\n";
+    let output = tools
+        .call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["applied"], true);
+    assert_eq!(parsed["files"][0]["hunks"][0]["offset"], 0);
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        "alpha\nBETA\ngamma\n"
+    );
+
+    // The same diff no longer matches the file
+    let error = tools
+        .call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false}),
+        )
+        .await
+        .unwrap_err();
+    assert!(
+        error.to_string().contains("Hunk #1 FAILED at 1"),
+        "{}",
+        error
+    );
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        "alpha\nBETA\ngamma\n"
+    );
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_diff_breaking_syntax_is_refused() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let source = "function add(a, b) {\n  return a + b;\n}\n";
+    let test_file = temp_dir.path().join("math.js");
+    tokio::fs::write(&test_file, source).await?;
+
+    let diff = "--- a/math.js\n+++ b/math.js\n@@ -1,3 +1,3 @@\n function add(a, b) {\n-  return a + b;\n+  return a + ;\n }\n";
+    let result = tools
+        .call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => panic!("Expected the diff to be refused, got {}", output),
+        Err(e) if e.to_string().contains("ast-grep") => {
+            println!("⚠️  ast-grep binary not available, skipping execution test");
+        }
+        Err(e) => {
+            assert!(
+                e.to_string().contains("does not parse as javascript"),
+                "{}",
+                e
+            );
+            assert_eq!(tokio::fs::read_to_string(&test_file).await?, source);
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_concurrent_diffs_to_one_file_all_land() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let test_file = temp_dir.path().join("lines.txt");
+    let lines: Vec<String> = (0..16).map(|i| format!("line{i}")).collect();
+    tokio::fs::write(&test_file, lines.join("\n") + "\n").await?;
+
+    // Each call reads, patches and writes the whole file; without a lock
+    // held across that, a later write would drop an earlier one's change
+    let calls = (0..8).map(|i| {
+        let changed = 2 * i;
+        let diff = format!(
+            "--- a/lines.txt\n+++ b/lines.txt\n@@ -{},2 +{},2 @@\n-line{changed}\n+LINE{changed}\n line{}\n",
+            changed + 1,
+            changed + 1,
+            changed + 1
+        );
+        tools.call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false}),
+        )
+    });
+    for result in futures::future::join_all(calls).await {
+        result?;
+    }
+
+    let expected: Vec<String> = (0..16)
+        .map(|i| match i % 2 {
+            0 => format!("LINE{i}"),
+            _ => format!("line{i}"),
+        })
+        .collect();
+    assert_eq!(
+        tokio::fs::read_to_string(&test_file).await?,
+        expected.join("\n") + "\n"
+    );
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_diff_changing_nothing_is_not_written() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let tools = create_tools(temp_dir.path());
+    let test_file = temp_dir.path().join("notes.txt");
+    tokio::fs::write(&test_file, "alpha\nbeta\n").await?;
+    let an_hour_ago = std::time::SystemTime::now() - std::time::Duration::from_secs(3600);
+    std::fs::File::options()
+        .write(true)
+        .open(&test_file)?
+        .set_modified(an_hour_ago)?;
+
+    // The hunk replaces beta with itself
+    let diff = "--- a/notes.txt\n+++ b/notes.txt\n@@ -1,2 +1,2 @@\n alpha\n-beta\n+beta\n";
+    let output = tools
+        .call_tool(
+            "apply_unified_diff",
+            json!({"diff": diff, "dry_run": false}),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["files"][0]["status"], "no changes");
+    assert_eq!(std::fs::metadata(&test_file)?.modified()?, an_hour_ago);
+
+    let log: Value = serde_json::from_str(&tools.call_tool("get_session_log", json!({})).await?)?;
+    assert_eq!(log["entries"], json!([]));
+    Ok(())
+}
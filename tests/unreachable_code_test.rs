@@ -0,0 +1,80 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = r#"package main
+
+func parse(s string) (int, error) {
+	if s == "" {
+		return 0, errEmpty
+		log("empty")
+	}
+	for _, c := range s {
+		if c == ' ' {
+			continue
+			count++
+		}
+	}
+	panic("not implemented")
+	cleanup()
+retry:
+	goto retry
+}
+"#;
+
+#[tokio::test]
+async fn test_find_unreachable_code() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let result = tools
+        .call_tool(
+            "find_unreachable_code",
+            json!({"code": SOURCE, "language": "go"}),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            assert_eq!(parsed["count"], 3);
+            let lines: Vec<u64> = parsed["unreachable"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["line"].as_u64().unwrap())
+                .collect();
+            assert_eq!(lines, [6, 11, 15]);
+            // The goto target and what follows it can still run
+            assert_eq!(parsed["unreachable"][2]["end_line"], 15);
+            assert_eq!(parsed["unreachable"][2]["after"]["exit"], "panic");
+            assert!(parsed["content"].is_null());
+
+            let output = tools
+                .call_tool(
+                    "find_unreachable_code",
+                    json!({"code": SOURCE, "language": "go", "delete": true}),
+                )
+                .await?;
+            let parsed: Value = serde_json::from_str(&output)?;
+            let content = parsed["content"].as_str().unwrap();
+            assert!(content.contains("\t\treturn 0, errEmpty\n\t}"));
+            assert!(content.contains("\t\t\tcontinue\n\t\t}"));
+            assert!(content.contains("\tpanic(\"not implemented\")\nretry:\n"));
+            assert_eq!(parsed["warnings"].as_array().unwrap().len(), 1);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
@@ -0,0 +1,97 @@
+use anyhow::Result;
+use base64::Engine;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+// The emoji is 4 bytes in UTF-8 and two code units in UTF-16, so UTF-8 and
+// UTF-16 offsets disagree for everything after it.
+const JS_CODE: &str =
+    "const banner = \"😀\";\nfunction greet(name) {\n    return \"hi 😀 \" + name;\n}\n";
+
+fn utf16_base64(text: &str) -> String {
+    let bytes: Vec<u8> = text
+        .encode_utf16()
+        .flat_map(|unit| unit.to_le_bytes())
+        .collect();
+    base64::engine::general_purpose::STANDARD.encode(bytes)
+}
+
+#[tokio::test]
+async fn test_utf16_selection_spanning_emoji() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Select `hi 😀 ` inside greet, as a UTF-16 client would report it
+    let utf16: Vec<u16> = JS_CODE.encode_utf16().collect();
+    let needle: Vec<u16> = "hi 😀 ".encode_utf16().collect();
+    let start = utf16
+        .windows(needle.len())
+        .position(|window| window == needle.as_slice())
+        .unwrap();
+    let end = start + needle.len();
+
+    let result = tools
+        .call_tool(
+            "get_enclosing_function",
+            json!({
+                "code": utf16_base64(JS_CODE),
+                "contentEncoding": "utf-16",
+                "offsetEncoding": "utf-16",
+                "language": "javascript",
+                "start_byte": start,
+                "end_byte": end
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            let parsed: Value = serde_json::from_str(&output)?;
+            let function = &parsed["function"];
+            assert_eq!(function["name"], "greet");
+
+            // Offsets come back in UTF-16 code units
+            let function_start = JS_CODE.find("function").unwrap();
+            assert_eq!(
+                function["range"]["utf16Offset"]["start"],
+                JS_CODE[..function_start].encode_utf16().count()
+            );
+            assert_eq!(function["range"]["utf16Offset"]["end"], utf16.len() - 1);
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_utf16_offset_inside_emoji_rejected() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    // Code unit 17 is the low surrogate of the first emoji
+    let result = tools
+        .call_tool(
+            "get_enclosing_function",
+            json!({
+                "code": JS_CODE,
+                "offsetEncoding": "utf-16",
+                "language": "javascript",
+                "start_byte": 17
+            }),
+        )
+        .await;
+
+    let error = result.expect_err("an offset between surrogates is not a valid position");
+    assert!(error.to_string().contains("surrogate pair"));
+    Ok(())
+}
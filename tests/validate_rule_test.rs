@@ -0,0 +1,80 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+fn create_tools() -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    AstGrepTools::new(binary_manager)
+}
+
+#[tokio::test]
+async fn test_validate_rule_reports_what_it_refers_to() -> Result<()> {
+    let tools = create_tools();
+    let output = tools
+        .call_tool(
+            "validate_rule",
+            json!({
+                "rule_config": "id: log-calls\nlanguage: go\nrule:\n  pattern: log.Printf($FMT, $$$ARGS)\n  inside:\n    kind: function_declaration\n    stopBy: end\n",
+                "filter": ":spanning-lines(2)"
+            }),
+        )
+        .await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["valid"], true);
+    assert_eq!(parsed["kinds"], json!(["function_declaration"]));
+    assert_eq!(parsed["metavariables"], json!(["ARGS", "FMT"]));
+    assert_eq!(parsed["filter"], ":spanning-lines(2)");
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_validate_rule_locates_problems() -> Result<()> {
+    let tools = create_tools();
+    let output = tools
+        .call_tool(
+            "validate_rule",
+            json!({
+                "rule_config": "id: calls\nlanguage: go\nrule:\n  kind: call_expression\n  has:\n    patern: fmt.Println($X)\n"
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["valid"], false);
+    assert_eq!(parsed["error"]["stage"], "rule");
+    assert_eq!(parsed["error"]["path"], "rule.has.patern");
+    assert_eq!(
+        parsed["error"]["message"],
+        "unknown key patern; did you mean pattern?"
+    );
+    assert_eq!(parsed["error"]["line"], 6);
+    assert_eq!(parsed["error"]["column"], 5);
+
+    let output = tools
+        .call_tool(
+            "validate_rule",
+            json!({
+                "rule_config": "id: calls\nlanguage: cobol\nrule:\n  kind: call_expression\n"
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["error"]["stage"], "language");
+    assert_eq!(parsed["error"]["line"], 2);
+
+    let output = tools
+        .call_tool(
+            "validate_rule",
+            json!({
+                "rule_config": "id: calls\nlanguage: go\nrule:\n  kind: call_expression\n",
+                "filter": ":longer-than(3) :wider-than(2)"
+            }),
+        )
+        .await?;
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["error"]["stage"], "filter");
+    assert_eq!(parsed["error"]["column"], 17);
+    Ok(())
+}
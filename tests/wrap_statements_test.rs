@@ -0,0 +1,67 @@
+use anyhow::Result;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nfunc run() error {\n\tx := load()\n\tif x > 0 {\n\t\tsave(x)\n\t}\n\treturn nil\n}\n";
+
+#[tokio::test]
+async fn test_wrap_statements_traces_each_statement() -> Result<()> {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+
+    let error = tools
+        .call_tool(
+            "wrap_statements",
+            json!({"code": SOURCE, "language": "go", "position": {"line": 4, "column": 2}}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("Provide before, after or both"));
+
+    let result = tools
+        .call_tool(
+            "wrap_statements",
+            json!({
+                "code": SOURCE,
+                "language": "go",
+                "position": {"line": 4, "column": 2},
+                "before": "trace($LINE)",
+                "after": "done($INDEX)",
+                "skipDeclarations": true
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            // Only the function's own statements, not the one in the if
+            let lines: Vec<u64> = parsed["wrapped"]
+                .as_array()
+                .unwrap()
+                .iter()
+                .map(|entry| entry["line"].as_u64().unwrap())
+                .collect();
+            assert_eq!(lines, [5, 8]);
+            assert_eq!(parsed["skipped"][0]["line"], 4);
+            assert_eq!(parsed["wrapped"][1]["after"], false);
+            assert_eq!(
+                parsed["content"],
+                "package main\n\nfunc run() error {\n\tx := load()\n\ttrace(5)\n\tif x > 0 {\n\t\tsave(x)\n\t}\n\tdone(1)\n\ttrace(8)\n\treturn nil\n}\n"
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}
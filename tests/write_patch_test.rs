@@ -0,0 +1,142 @@
+use anyhow::Result;
+use rmcp::model::Root;
+use serde_json::{json, Value};
+use splice_weaver_mcp::ast_grep_tools::AstGrepTools;
+use splice_weaver_mcp::binary_manager::BinaryManager;
+use std::path::Path;
+use std::process::Command;
+use std::sync::Arc;
+
+const SOURCE: &str = "package main\n\nfunc main() {\n\tprintln(\"hi\")\n}\n";
+
+fn create_tools(root_path: &Path) -> AstGrepTools {
+    let binary_manager = Arc::new(BinaryManager::new().expect("Failed to create binary manager"));
+    let tools = AstGrepTools::new(binary_manager);
+    tools.set_roots(vec![Root {
+        uri: format!("file://{}", root_path.display()),
+        name: Some("test_workspace".to_string()),
+    }]);
+    tools
+}
+
+#[tokio::test]
+async fn test_write_patch_for_git_apply() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    std::fs::write(temp_dir.path().join("main.go"), SOURCE)?;
+    let tools = create_tools(temp_dir.path());
+
+    let start = SOURCE.find("\"hi\"").unwrap();
+    let output = tools
+        .call_tool(
+            "write_patch",
+            json!({
+                "files": [
+                    {
+                        "path": "main.go",
+                        "edits": [{"start": start, "end": start + 4, "replacement": "\"hello\""}]
+                    },
+                    {"path": "docs/notes.txt", "content": "first\nsecond"}
+                ],
+                "output": "changes.patch"
+            }),
+        )
+        .await?;
+    println!("Output: {}", output);
+    let parsed: Value = serde_json::from_str(&output)?;
+    assert_eq!(parsed["files"][0]["path"], "main.go");
+    assert_eq!(parsed["files"][0]["status"], "modified");
+    assert_eq!(parsed["files"][0]["added"], 1);
+    assert_eq!(parsed["files"][1]["status"], "added");
+    assert_eq!(parsed["patch"], Value::Null);
+
+    let patch = std::fs::read_to_string(temp_dir.path().join("changes.patch"))?;
+    assert!(patch.starts_with("diff --git a/main.go b/main.go\nindex "));
+    assert!(patch.contains("-\tprintln(\"hi\")\n+\tprintln(\"hello\")\n"));
+    assert!(patch.contains("diff --git a/docs/notes.txt b/docs/notes.txt\nnew file mode 100644\n"));
+    // Nothing was applied
+    assert_eq!(
+        std::fs::read_to_string(temp_dir.path().join("main.go"))?,
+        SOURCE
+    );
+
+    let error = tools
+        .call_tool(
+            "write_patch",
+            json!({"files": [{"path": "main.go", "content": SOURCE}]}),
+        )
+        .await
+        .unwrap_err();
+    assert!(error.to_string().contains("no patch to write"), "{}", error);
+
+    let applied = Command::new("git")
+        .arg("-C")
+        .arg(temp_dir.path())
+        .args(["apply", "changes.patch"])
+        .output();
+    match applied {
+        Ok(applied) => {
+            assert!(
+                applied.status.success(),
+                "{}",
+                String::from_utf8_lossy(&applied.stderr)
+            );
+            assert_eq!(
+                std::fs::read_to_string(temp_dir.path().join("main.go"))?,
+                SOURCE.replace("\"hi\"", "\"hello\"")
+            );
+            assert_eq!(
+                std::fs::read_to_string(temp_dir.path().join("docs/notes.txt"))?,
+                "first\nsecond"
+            );
+        }
+        Err(_) => println!("⚠️  git not available, skipping git apply check"),
+    }
+
+    Ok(())
+}
+
+#[tokio::test]
+async fn test_write_patch_names_declarations_in_hunk_headers() -> Result<()> {
+    let temp_dir = tempfile::tempdir()?;
+    let source = "package main\n\nfunc divide(a, b float64) float64 {\n\tif b == 0 {\n\t\treturn 0\n\t}\n\treturn a / b\n}\n";
+    std::fs::write(temp_dir.path().join("math.go"), source)?;
+    let tools = create_tools(temp_dir.path());
+
+    let start = source.find("return a / b").unwrap();
+    let result = tools
+        .call_tool(
+            "write_patch",
+            json!({
+                "files": [{
+                    "path": "math.go",
+                    "edits": [{"start": start, "end": start + 12, "replacement": "return a / b * 1"}]
+                }],
+                "context": 1,
+                "hunk_headers": "declaration"
+            }),
+        )
+        .await;
+
+    match result {
+        Ok(output) => {
+            println!("Output: {}", output);
+            let parsed: Value = serde_json::from_str(&output)?;
+            let patch = parsed["patch"].as_str().unwrap();
+            assert!(
+                patch.contains("@@ -6,3 +6,3 @@ func divide(a, b float64) float64 {\n"),
+                "{}",
+                patch
+            );
+        }
+        Err(e) => {
+            // If ast-grep binary is not available, this is expected
+            if e.to_string().contains("ast-grep") {
+                println!("⚠️  ast-grep binary not available, skipping execution test");
+            } else {
+                return Err(e);
+            }
+        }
+    }
+
+    Ok(())
+}